@@ -2,12 +2,17 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/smira/aptly/aptly"
 	"github.com/smira/aptly/deb"
 	"github.com/smira/aptly/query"
+	"io"
+	"net/http"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -22,6 +27,43 @@ func apiVersion(c *gin.Context) {
 	c.JSON(200, gin.H{"Version": aptly.Version})
 }
 
+// GET /api/version/compare?a=:version1&op=:op&b=:version2
+func apiVersionCompare(c *gin.Context) {
+	result, err := deb.CompareVersionsRelation(c.Request.URL.Query().Get("a"), c.Request.URL.Query().Get("op"),
+		c.Request.URL.Query().Get("b"))
+	if err != nil {
+		c.Fail(400, err)
+		return
+	}
+
+	c.JSON(200, gin.H{"Result": result})
+}
+
+// publishedFilter parses filter by publishing state from query parameter ?published=1|0,
+// nil means no filtering
+func publishedFilter(c *gin.Context) (*bool, error) {
+	value := c.Request.URL.Query().Get("published")
+	if value == "" {
+		return nil, nil
+	}
+
+	published, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse published filter: %s", value)
+	}
+
+	return &published, nil
+}
+
+// labelsFilter parses label filter from query parameters ?label=key=value (possibly repeated)
+func labelsFilter(c *gin.Context) (deb.Labels, error) {
+	return deb.ParseLabels(strings.Join(c.Request.URL.Query()["label"], ","))
+}
+
+// Cache of package lists used when searching packages of collections,
+// lists are keyed by UUID of collection
+var packageListCache = deb.NewPackageListCache(16)
+
 // Periodically flushes CollectionFactory to free up memory used by collections,
 // flushing caches.
 //
@@ -51,63 +93,145 @@ func cacheFlusher() {
 
 		// all collections locked, flush them
 		context.CollectionFactory().Flush()
+		packageListCache.Flush()
 	}
 }
 
-// Common piece of code to show list of packages,
-// with searching & details if requested
-func showPackages(c *gin.Context, reflist *deb.PackageRefList) {
-	result := []*deb.Package{}
+// Writes JSON array to the client element by element as produced by iterator,
+// so that whole response is never kept in memory
+//
+// As response status is already sent when iteration starts, errors are
+// reported in X-Aptly-Error trailer
+func streamJSONArray(c *gin.Context, iterator func(emit func(interface{}) error) error) {
+	c.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.Writer.Header().Set("Trailer", "X-Aptly-Error")
+	c.Writer.WriteHeader(200)
+
+	flusher, _ := c.Writer.(http.Flusher)
 
-	list, err := deb.NewPackageListFromRefList(reflist, context.CollectionFactory().PackageCollection(), nil)
+	err := writeJSONArray(c.Writer, flusher, iterator)
 	if err != nil {
-		c.Fail(404, err)
-		return
+		c.Writer.Header().Set("X-Aptly-Error", err.Error())
 	}
+}
 
-	queryS := c.Request.URL.Query().Get("q")
-	if queryS != "" {
-		q, err := query.Parse(c.Request.URL.Query().Get("q"))
-		if err != nil {
-			c.Fail(400, err)
-			return
-		}
+// Streamed JSON array is flushed to the client once that many elements
+// or bytes have been written since last flush
+const (
+	jsonArrayFlushElements = 100
+	jsonArrayFlushBytes    = 32 * 1024
+)
 
-		withDeps := c.Request.URL.Query().Get("withDeps") == "1"
-		architecturesList := []string{}
+// writeJSONArray writes elements produced by iterator as JSON array,
+// flushing (if flusher is not nil) every jsonArrayFlushElements elements
+// or jsonArrayFlushBytes bytes, so that client receives elements as they
+// become available without flushing on each small element
+func writeJSONArray(w io.Writer, flusher http.Flusher, iterator func(emit func(interface{}) error) error) error {
+	count, pendingElements, pendingBytes := 0, 0, 0
+
+	_, err := w.Write([]byte("["))
+	if err == nil {
+		err = iterator(func(item interface{}) error {
+			data, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
 
-		if withDeps {
-			if len(context.ArchitecturesList()) > 0 {
-				architecturesList = context.ArchitecturesList()
-			} else {
-				architecturesList = list.Architectures(false)
+			if count > 0 {
+				if _, err = w.Write([]byte(",")); err != nil {
+					return err
+				}
+			}
+			if _, err = w.Write(data); err != nil {
+				return err
 			}
 
-			sort.Strings(architecturesList)
+			count++
+			pendingElements++
+			pendingBytes += len(data) + 1
 
-			if len(architecturesList) == 0 {
-				c.Fail(400, fmt.Errorf("unable to determine list of architectures, please specify explicitly"))
-				return
+			if flusher != nil && (pendingElements >= jsonArrayFlushElements || pendingBytes >= jsonArrayFlushBytes) {
+				flusher.Flush()
+				pendingElements, pendingBytes = 0, 0
 			}
+			return nil
+		})
+	}
+
+	w.Write([]byte("]"))
+
+	return err
+}
+
+// Common piece of code to show list of packages,
+// with searching & details if requested
+//
+// When searching, package list is taken from cache by id of the collection
+func showPackages(c *gin.Context, id string, reflist *deb.PackageRefList) {
+	details := c.Request.URL.Query().Get("format") == "details"
+
+	queryS := c.Request.URL.Query().Get("q")
+	if queryS == "" {
+		// no filtering, so packages are loaded from DB one by one
+		streamJSONArray(c, func(emit func(interface{}) error) error {
+			return reflist.ForEach(func(key []byte) error {
+				if !details {
+					return emit(string(key))
+				}
+
+				p, err := context.CollectionFactory().PackageCollection().ByKey(key)
+				if err != nil {
+					return fmt.Errorf("unable to load package %s: %s", key, err)
+				}
+				return emit(p)
+			})
+		})
+		return
+	}
+
+	list, err := packageListCache.Get(id, reflist, context.CollectionFactory().PackageCollection())
+	if err != nil {
+		c.Fail(500, err)
+		return
+	}
+
+	q, err := query.Parse(queryS)
+	if err != nil {
+		c.Fail(400, err)
+		return
+	}
+
+	withDeps := c.Request.URL.Query().Get("withDeps") == "1"
+	architecturesList := []string{}
+
+	if withDeps {
+		if len(context.ArchitecturesList()) > 0 {
+			architecturesList = context.ArchitecturesList()
+		} else {
+			architecturesList = list.Architectures(false)
 		}
 
-		list.PrepareIndex()
+		sort.Strings(architecturesList)
 
-		list, err = list.Filter([]deb.PackageQuery{q}, withDeps,
-			nil, context.DependencyOptions(), architecturesList)
-		if err != nil {
-			c.Fail(500, fmt.Errorf("unable to search: %s", err))
+		if len(architecturesList) == 0 {
+			c.Fail(400, fmt.Errorf("unable to determine list of architectures, please specify explicitly"))
+			return
 		}
 	}
 
-	if c.Request.URL.Query().Get("format") == "details" {
-		list.ForEach(func(p *deb.Package) error {
-			result = append(result, p)
-			return nil
-		})
-
-		c.JSON(200, result)
-	} else {
-		c.JSON(200, list.Strings())
+	list, err = list.Filter([]deb.PackageQuery{q}, withDeps,
+		nil, context.DependencyOptions(), architecturesList)
+	if err != nil {
+		c.Fail(500, fmt.Errorf("unable to search: %s", err))
+		return
 	}
+
+	streamJSONArray(c, func(emit func(interface{}) error) error {
+		return list.ForEach(func(p *deb.Package) error {
+			if details {
+				return emit(p)
+			}
+			return emit(string(p.Key("")))
+		})
+	})
 }
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Launch gocheck tests
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type APISuite struct{}
+
+var _ = Suite(&APISuite{})
+
+// flushRecorder records contents of the buffer at each flush
+type flushRecorder struct {
+	bytes.Buffer
+	flushed []string
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushed = append(r.flushed, r.String())
+}
+
+func (s *APISuite) TestWriteJSONArray(c *C) {
+	w := &flushRecorder{}
+
+	err := writeJSONArray(w, w, func(emit func(interface{}) error) error {
+		c.Assert(emit("a"), IsNil)
+		c.Assert(emit(map[string]int{"b": 1}), IsNil)
+		// small elements are not flushed one by one
+		c.Check(w.flushed, HasLen, 0)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Check(w.String(), Equals, `["a",{"b":1}]`)
+
+	w = &flushRecorder{}
+	err = writeJSONArray(w, nil, func(emit func(interface{}) error) error {
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Check(w.String(), Equals, `[]`)
+
+	w = &flushRecorder{}
+	err = writeJSONArray(w, w, func(emit func(interface{}) error) error {
+		c.Assert(emit("a"), IsNil)
+		return fmt.Errorf("broken")
+	})
+	c.Check(err, ErrorMatches, "broken")
+	c.Check(w.String(), Equals, `["a"]`)
+}
+
+func (s *APISuite) TestWriteJSONArrayFlush(c *C) {
+	w := &flushRecorder{}
+
+	err := writeJSONArray(w, w, func(emit func(interface{}) error) error {
+		for i := 0; i < 2*jsonArrayFlushElements+1; i++ {
+			c.Assert(emit(i), IsNil)
+		}
+		c.Check(w.flushed, HasLen, 2)
+
+		// large element is flushed right away
+		c.Assert(emit(strings.Repeat("x", jsonArrayFlushBytes)), IsNil)
+		c.Check(w.flushed, HasLen, 3)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Check(w.flushed[0], Matches, `\[0,1,.*,99`)
+}
+
+// countingWriter discards everything written, counting flushes
+type countingWriter struct {
+	flushes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w *countingWriter) Flush() {
+	w.flushes++
+}
+
+func (s *APISuite) TestWriteJSONArrayLarge(c *C) {
+	const elements = 200000
+
+	w := &countingWriter{}
+	var before, after runtime.MemStats
+
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	err := writeJSONArray(w, w, func(emit func(interface{}) error) error {
+		for i := 0; i < elements; i++ {
+			err := emit(fmt.Sprintf("pkg%06d_1.0_amd64", i))
+			if err != nil {
+				return err
+			}
+		}
+
+		// nothing emitted so far should be kept in memory
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Check(w.flushes, Equals, elements/jsonArrayFlushElements)
+	c.Check(int64(after.HeapAlloc)-int64(before.HeapAlloc) < 1024*1024, Equals, true)
+}
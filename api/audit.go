@@ -0,0 +1,71 @@
+package api
+
+import (
+	"code.google.com/p/go-uuid/uuid"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/smira/aptly/utils"
+	"time"
+)
+
+// auditRecorder records mutating requests in audit journal
+//
+// Entry is written ahead of processing request, so request is rejected if it can't
+// be recorded. Once request is processed, it's recorded again with response status.
+func auditRecorder(journal *utils.AuditJournal) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == "GET" || c.Request.Method == "HEAD" {
+			c.Next()
+			return
+		}
+
+		// principal is known only if request has been authenticated
+		// (e.g. by gin.BasicAuth middleware), credentials sent by client
+		// are not trusted on their own
+		principal, _ := c.Keys[gin.AuthUserKey].(string)
+
+		entry := &utils.AuditEntry{
+			ID:        uuid.New(),
+			Operation: c.Request.Method,
+			Target:    c.Request.URL.Path,
+			Principal: principal,
+		}
+
+		err := journal.Track(entry, func() int {
+			c.Next()
+			return c.Writer.Status()
+		})
+		if err != nil {
+			c.Fail(500, err)
+		}
+	}
+}
+
+// GET /api/audit
+func apiAudit(c *gin.Context) {
+	journal := context.AuditJournal()
+	if journal == nil {
+		c.Fail(404, fmt.Errorf("audit journal is not enabled"))
+		return
+	}
+
+	var since time.Time
+
+	if value := c.Request.URL.Query().Get("since"); value != "" {
+		var err error
+
+		since, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.Fail(400, fmt.Errorf("unable to parse since: %s", err))
+			return
+		}
+	}
+
+	entries, err := journal.Entries(since)
+	if err != nil {
+		c.Fail(500, err)
+		return
+	}
+
+	c.JSON(200, entries)
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// GET /api/config/validate
+func apiConfigValidate(c *gin.Context) {
+	problems, warnings := context.ValidateConfig()
+
+	strict := c.Request.URL.Query().Get("strict") == "1"
+
+	errors := make([]string, len(problems))
+	for i := range problems {
+		errors[i] = problems[i].Error()
+	}
+
+	if warnings == nil {
+		warnings = []string{}
+	}
+
+	valid := len(errors) == 0 && (!strict || len(warnings) == 0)
+
+	c.JSON(200, gin.H{"Valid": valid, "Errors": errors, "Warnings": warnings})
+}
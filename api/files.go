@@ -4,9 +4,21 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"unicode"
+)
+
+// Policies for uploaded files colliding with already present files
+const (
+	collisionOverwrite = "overwrite"
+	collisionReject    = "reject"
+	collisionRename    = "rename"
 )
 
 func verifyPath(path string) bool {
@@ -21,6 +33,95 @@ func verifyPath(path string) bool {
 
 }
 
+// uploadFilename returns name of uploaded file exactly as sent by client
+func uploadFilename(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err == nil {
+		return params["filename"]
+	}
+	return part.FileName()
+}
+
+// sanitizeFilename returns base name to store uploaded file under, path traversal
+// attempts are rejected
+func sanitizeFilename(filename string) (string, error) {
+	parts := strings.Split(strings.Replace(filename, "\\", "/", -1), "/")
+	for _, part := range parts {
+		if part == ".." {
+			return "", fmt.Errorf("wrong filename %q: path traversal", filename)
+		}
+	}
+
+	// clients might send full path of the file
+	base := parts[len(parts)-1]
+	if base == "" || base == "." || strings.IndexFunc(base, unicode.IsControl) != -1 {
+		return "", fmt.Errorf("wrong filename %q", filename)
+	}
+
+	return base, nil
+}
+
+// receiveUploadFile streams uploaded file to temporary file in dir, returning its path
+func receiveUploadFile(dir string, src io.Reader) (string, error) {
+	tmp, err := ioutil.TempFile(dir, ".upload-")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(tmp, src)
+	if err == nil {
+		// temporary files are created private, but uploaded files should be readable
+		err = tmp.Chmod(0644)
+	}
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// storeUploadFile moves received temporary file to final location according to collision
+// policy, returning name file has been stored under
+func storeUploadFile(dir, tmpPath, name, collision string) (string, error) {
+	if collision == collisionOverwrite {
+		return name, os.Rename(tmpPath, filepath.Join(dir, name))
+	}
+
+	defer os.Remove(tmpPath)
+
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	for i := 0; ; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", stem, i, ext)
+		}
+
+		// hard link fails if file already exists
+		err := os.Link(tmpPath, filepath.Join(dir, candidate))
+		if err == nil || !os.IsExist(err) || collision == collisionReject {
+			return candidate, err
+		}
+	}
+}
+
+// countingReader counts bytes read from underlying reader
+type countingReader struct {
+	io.ReadCloser
+	count int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
 func verifyDir(c *gin.Context) bool {
 	if !verifyPath(c.Params.ByName("dir")) {
 		c.Fail(400, fmt.Errorf("wrong dir"))
@@ -60,56 +161,151 @@ func apiFilesListDirs(c *gin.Context) {
 }
 
 // POST /files/:dir/
+//
+// Query parameter collision selects what happens when file with the same name is already
+// present: overwrite (default), reject or rename (stored with numeric suffix)
 func apiFilesUpload(c *gin.Context) {
 	if !verifyDir(c) {
 		return
 	}
 
+	collision := c.Request.URL.Query().Get("collision")
+	if collision == "" {
+		collision = collisionOverwrite
+	}
+	if collision != collisionOverwrite && collision != collisionReject && collision != collisionRename {
+		c.Fail(400, fmt.Errorf("unknown collision policy: %s", collision))
+		return
+	}
+
+	limit := context.Config().UploadSizeLimit
+	var body *countingReader
+
+	if limit > 0 {
+		if c.Request.ContentLength > limit {
+			failUploadTooLarge(c)
+			return
+		}
+		// http.MaxBytesReader reads more than limit from the body only when the limit is exceeded
+		body = &countingReader{ReadCloser: c.Request.Body}
+		c.Request.Body = http.MaxBytesReader(c.Writer, body, limit)
+	}
+
 	path := filepath.Join(context.UploadPath(), c.Params.ByName("dir"))
-	err := os.MkdirAll(path, 0777)
+	_, err := os.Stat(path)
+	created := os.IsNotExist(err)
 
+	err = os.MkdirAll(path, 0777)
 	if err != nil {
 		c.Fail(500, err)
 		return
 	}
 
-	err = c.Request.ParseMultipartForm(10 * 1024 * 1024)
+	// files are streamed to disk part by part, so that request body is never buffered in memory
+	reader, err := c.Request.MultipartReader()
 	if err != nil {
 		c.Fail(400, err)
 		return
 	}
 
-	stored := []string{}
+	type upload struct {
+		name, tmpPath string
+	}
 
-	for _, files := range c.Request.MultipartForm.File {
-		for _, file := range files {
-			src, err := file.Open()
-			if err != nil {
-				c.Fail(500, err)
-				return
-			}
-			defer src.Close()
+	// all the parts are received into temporary files before any of them is stored,
+	// so that request with invalid part doesn't leave some of the files behind
+	uploads := []upload{}
+	succeeded := false
+	defer func() {
+		for _, u := range uploads {
+			os.Remove(u.tmpPath)
+		}
+		if created && !succeeded {
+			// removed only if it's still empty
+			os.Remove(path)
+		}
+	}()
 
-			destPath := filepath.Join(path, filepath.Base(file.Filename))
-			dst, err := os.Create(destPath)
-			if err != nil {
-				c.Fail(500, err)
+	fail := func(code int, err error) {
+		if body != nil && body.count > limit {
+			failUploadTooLarge(c)
+			return
+		}
+		c.Fail(code, err)
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(400, err)
+			return
+		}
+
+		filename := uploadFilename(part)
+		if filename == "" {
+			// not a file
+			part.Close()
+			continue
+		}
+
+		name, err := sanitizeFilename(filename)
+		if err != nil {
+			part.Close()
+			c.Fail(400, err)
+			return
+		}
+
+		tmpPath, err := receiveUploadFile(path, part)
+		part.Close()
+		if err != nil {
+			fail(500, err)
+			return
+		}
+
+		uploads = append(uploads, upload{name: name, tmpPath: tmpPath})
+	}
+
+	if collision == collisionReject {
+		seen := map[string]bool{}
+		for _, u := range uploads {
+			_, err = os.Lstat(filepath.Join(path, u.name))
+			if seen[u.name] || err == nil {
+				c.Fail(409, fmt.Errorf("file %s already exists", filepath.Join(c.Params.ByName("dir"), u.name)))
 				return
 			}
-			defer dst.Close()
+			seen[u.name] = true
+		}
+	}
+
+	stored := []string{}
 
-			_, err = io.Copy(dst, src)
-			if err != nil {
+	for len(uploads) > 0 {
+		u := uploads[0]
+		uploads = uploads[1:]
+
+		storedName, err := storeUploadFile(path, u.tmpPath, u.name, collision)
+		if err != nil {
+			if os.IsExist(err) {
+				c.Fail(409, fmt.Errorf("file %s already exists", filepath.Join(c.Params.ByName("dir"), u.name)))
+			} else {
 				c.Fail(500, err)
-				return
 			}
-
-			stored = append(stored, filepath.Join(c.Params.ByName("dir"), filepath.Base(file.Filename)))
+			return
 		}
+
+		stored = append(stored, filepath.Join(c.Params.ByName("dir"), storedName))
 	}
 
+	succeeded = true
 	c.JSON(200, stored)
+}
 
+// failUploadTooLarge reports request body exceeding upload size limit
+func failUploadTooLarge(c *gin.Context) {
+	c.Fail(413, fmt.Errorf("upload is larger than limit of %d bytes", context.Config().UploadSizeLimit))
 }
 
 // GET /files/:dir
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/smira/aptly/utils"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var keyringNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]*$`)
+
+// managedKeyring returns path to managed keyring, failing request on invalid name
+func managedKeyring(c *gin.Context, name string) (string, bool) {
+	if !keyringNameRegexp.MatchString(name) {
+		c.Fail(400, fmt.Errorf("wrong keyring name: %s", name))
+		return "", false
+	}
+
+	return filepath.Join(context.KeyringsPath(), name+".gpg"), true
+}
+
+// verificationKeyring returns path to existing managed keyring used to verify signatures,
+// failing request if keyring name is invalid or keyring doesn't exist
+//
+// Only keyrings managed via /api/gpg/keys could be referenced, so that request
+// can't point to arbitrary files on the server.
+func verificationKeyring(c *gin.Context, name string) (string, bool) {
+	keyring, ok := managedKeyring(c, name)
+	if !ok {
+		return "", false
+	}
+
+	if _, err := os.Stat(keyring); err != nil {
+		c.Fail(400, fmt.Errorf("keyring %s not found", name))
+		return "", false
+	}
+
+	return keyring, true
+}
+
+// saveUploadedKeyring stores uploaded keyring in temporary file, returning its path
+func saveUploadedKeyring(header *multipart.FileHeader) (string, error) {
+	source, err := header.Open()
+	if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	target, err := ioutil.TempFile("", "aptly-keyring")
+	if err != nil {
+		return "", err
+	}
+	defer target.Close()
+
+	_, err = io.Copy(target, source)
+	if err != nil {
+		os.Remove(target.Name())
+		return "", err
+	}
+
+	return target.Name(), nil
+}
+
+// POST /api/gpg/verify
+func apiGPGVerify(c *gin.Context) {
+	err := c.Request.ParseMultipartForm(10 * 1024 * 1024)
+	if err != nil {
+		c.Fail(400, err)
+		return
+	}
+
+	form := c.Request.MultipartForm
+	defer form.RemoveAll()
+
+	if len(form.File["file"]) != 1 || len(form.File["signature"]) != 1 {
+		c.Fail(400, fmt.Errorf("exactly one file and one signature should be uploaded"))
+		return
+	}
+
+	var verifier utils.Verifier = &utils.GpgVerifier{}
+	for _, name := range form.Value["keyring"] {
+		keyring, ok := verificationKeyring(c, name)
+		if !ok {
+			return
+		}
+		verifier.AddKeyring(keyring)
+	}
+
+	for _, header := range form.File["keyring"] {
+		keyring, err := saveUploadedKeyring(header)
+		if err != nil {
+			c.Fail(500, fmt.Errorf("unable to save keyring: %s", err))
+			return
+		}
+		defer os.Remove(keyring)
+
+		verifier.AddKeyring(keyring)
+	}
+
+	err = verifier.InitKeyring()
+	if err != nil {
+		c.Fail(500, err)
+		return
+	}
+
+	file, err := form.File["file"][0].Open()
+	if err != nil {
+		c.Fail(500, err)
+		return
+	}
+	defer file.Close()
+
+	signature, err := form.File["signature"][0].Open()
+	if err != nil {
+		c.Fail(500, err)
+		return
+	}
+	defer signature.Close()
+
+	info, err := verifier.VerifyDetachedSignatureInfo(signature, file)
+	if err != nil {
+		c.Fail(500, err)
+		return
+	}
+
+	c.JSON(200, info)
+}
+
+// POST /api/gpg/keys
+func apiGPGKeysImport(c *gin.Context) {
+	var b struct {
+		Keyring string `binding:"required"`
+		Key     string `binding:"required"`
+	}
+
+	if !c.Bind(&b) {
+		return
+	}
+
+	keyring, ok := managedKeyring(c, b.Keyring)
+	if !ok {
+		return
+	}
+
+	err := os.MkdirAll(context.KeyringsPath(), 0700)
+	if err != nil {
+		c.Fail(500, err)
+		return
+	}
+
+	fingerprints, err := utils.GpgImportKeys(keyring, []byte(b.Key))
+	if err != nil {
+		c.Fail(400, err)
+		return
+	}
+
+	keys, err := utils.GpgListKeys(keyring)
+	if err != nil {
+		c.Fail(500, err)
+		return
+	}
+
+	imported := []utils.GpgKey{}
+	for _, key := range keys {
+		for _, fingerprint := range fingerprints {
+			if key.Fingerprint == fingerprint {
+				imported = append(imported, key)
+				break
+			}
+		}
+	}
+
+	c.JSON(201, imported)
+}
+
+// GET /api/gpg/keys/:keyring
+func apiGPGKeysList(c *gin.Context) {
+	keyring, ok := managedKeyring(c, c.Params.ByName("keyring"))
+	if !ok {
+		return
+	}
+
+	if _, err := os.Stat(keyring); os.IsNotExist(err) {
+		c.Fail(404, fmt.Errorf("keyring %s not found", c.Params.ByName("keyring")))
+		return
+	}
+
+	keys, err := utils.GpgListKeys(keyring)
+	if err != nil {
+		c.Fail(500, err)
+		return
+	}
+
+	c.JSON(200, keys)
+}
+
+// DELETE /api/gpg/keys/:keyring/:key
+func apiGPGKeysDelete(c *gin.Context) {
+	keyring, ok := managedKeyring(c, c.Params.ByName("keyring"))
+	if !ok {
+		return
+	}
+
+	if _, err := os.Stat(keyring); os.IsNotExist(err) {
+		c.Fail(404, fmt.Errorf("keyring %s not found", c.Params.ByName("keyring")))
+		return
+	}
+
+	keys, err := utils.GpgListKeys(keyring)
+	if err != nil {
+		c.Fail(500, err)
+		return
+	}
+
+	for _, key := range keys {
+		if key.Matches(c.Params.ByName("key")) {
+			err = utils.GpgDeleteKey(keyring, key.Fingerprint)
+			if err != nil {
+				c.Fail(500, err)
+				return
+			}
+
+			c.JSON(200, gin.H{})
+			return
+		}
+	}
+
+	c.Fail(404, fmt.Errorf("key %s not found in keyring %s", c.Params.ByName("key"), c.Params.ByName("keyring")))
+}
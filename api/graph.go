@@ -1,7 +1,6 @@
 package api
 
 import (
-	"bytes"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/smira/aptly/deb"
@@ -9,67 +8,174 @@ import (
 	"mime"
 	"os"
 	"os/exec"
+	"path"
+	"strconv"
+	"strings"
 )
 
+// Formats graph could be rendered in: json is list of nodes and edges,
+// other formats are rendered by graphviz
+var graphFormats = []string{"dot", "gif", "jpg", "json", "pdf", "png", "svg"}
+
+// Path to graphviz dot binary, empty if graphviz is not installed
+var graphvizPath string
+
+// detectGraphviz looks up graphviz dot binary once on startup, if it's
+// not available graph is returned in DOT format instead of being rendered
+func detectGraphviz() {
+	graphvizPath, _ = exec.LookPath("dot")
+}
+
+// parseGraphOptions builds graph options from query parameters depth, focus & max-nodes
+func parseGraphOptions(c *gin.Context) (*deb.GraphOptions, error) {
+	var err error
+
+	options := &deb.GraphOptions{
+		Focus: c.Request.URL.Query().Get("focus"),
+		Depth: -1,
+	}
+
+	if _, err = path.Match(options.Focus, ""); err != nil {
+		return nil, fmt.Errorf("unable to parse focus: %s", err)
+	}
+
+	if value := c.Request.URL.Query().Get("depth"); value != "" {
+		options.Depth, err = strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse depth: %s", err)
+		}
+	}
+
+	if value := c.Request.URL.Query().Get("max-nodes"); value != "" {
+		options.MaxNodes, err = strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse max-nodes: %s", err)
+		}
+	}
+
+	return options, nil
+}
+
+// failGraph reports error building graph, too large graph is client error
+func failGraph(c *gin.Context, err error) {
+	if _, ok := err.(*deb.GraphTooLargeError); ok {
+		c.Fail(400, err)
+	} else {
+		c.Fail(500, err)
+	}
+}
+
 // GET /api/graph.:ext
 func apiGraph(c *gin.Context) {
-	var (
-		err    error
-		output []byte
-	)
-
 	ext := c.Params.ByName("ext")
 
+	supported := false
+	for _, format := range graphFormats {
+		supported = supported || format == ext
+	}
+	if !supported {
+		c.Fail(400, fmt.Errorf("unsupported graph format %s, supported formats: %s", ext, strings.Join(graphFormats, ", ")))
+		return
+	}
+
+	options, err := parseGraphOptions(c)
+	if err != nil {
+		c.Fail(400, err)
+		return
+	}
+
 	factory := context.CollectionFactory()
 
+	// collections are locked only while graph is being collected, rendering
+	// might take a while and shouldn't block other requests
 	factory.RemoteRepoCollection().RLock()
-	defer factory.RemoteRepoCollection().RUnlock()
 	factory.LocalRepoCollection().RLock()
-	defer factory.LocalRepoCollection().RUnlock()
 	factory.SnapshotCollection().RLock()
-	defer factory.SnapshotCollection().RUnlock()
 	factory.PublishedRepoCollection().RLock()
-	defer factory.PublishedRepoCollection().RUnlock()
 
-	graph, err := deb.BuildGraph(factory)
+	nodes, edges, err := deb.BuildGraphNodes(factory, options)
+
+	factory.PublishedRepoCollection().RUnlock()
+	factory.SnapshotCollection().RUnlock()
+	factory.LocalRepoCollection().RUnlock()
+	factory.RemoteRepoCollection().RUnlock()
+
 	if err != nil {
-		c.JSON(500, err)
+		failGraph(c, err)
 		return
 	}
 
-	buf := bytes.NewBufferString(graph.String())
-
-	command := exec.Command("dot", "-T"+ext)
-	command.Stderr = os.Stderr
+	if ext == "json" {
+		c.JSON(200, gin.H{"Nodes": nodes, "Edges": edges})
+		return
+	}
 
-	stdin, err := command.StdinPipe()
-	if err != nil {
-		c.Fail(500, err)
+	if graphvizPath == "" {
+		// client would have to render graph on its own
+		c.Writer.Header().Set("X-Aptly-Graph-Fallback", "graphviz is not installed, graph returned in DOT format")
+		c.Writer.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		c.Writer.WriteHeader(200)
+		deb.WriteGraph(c.Writer, nodes, edges)
 		return
 	}
 
-	_, err = io.Copy(stdin, buf)
+	command := exec.Command(graphvizPath, "-T"+ext)
+	command.Stderr = os.Stderr
+
+	stdin, err := command.StdinPipe()
 	if err != nil {
 		c.Fail(500, err)
 		return
 	}
 
-	err = stdin.Close()
+	stdout, err := command.StdoutPipe()
 	if err != nil {
 		c.Fail(500, err)
 		return
 	}
 
-	output, err = command.Output()
+	err = command.Start()
 	if err != nil {
 		c.Fail(500, fmt.Errorf("unable to execute dot: %s (is graphviz package installed?)", err))
 		return
 	}
 
+	// graph is fed to dot while its output is being sent to the client
+	writeErr := make(chan error, 1)
+	go func() {
+		err := deb.WriteGraph(stdin, nodes, edges)
+		stdin.Close()
+		writeErr <- err
+	}()
+
 	mimeType := mime.TypeByExtension("." + ext)
 	if mimeType == "" {
 		mimeType = "application/octet-stream"
 	}
 
-	c.Data(200, mimeType, output)
+	// image is sent to the client as dot renders it, so errors after
+	// first chunk has been sent are reported in X-Aptly-Error trailer
+	c.Writer.Header().Set("Content-Type", mimeType)
+	c.Writer.Header().Set("Trailer", "X-Aptly-Error")
+
+	_, err = io.Copy(c.Writer, stdout)
+	if err != nil {
+		// client is gone, dot might block writing the rest of the output
+		command.Process.Kill()
+	}
+	if waitErr := command.Wait(); err == nil && waitErr != nil {
+		err = fmt.Errorf("unable to render graph: %s", waitErr)
+	}
+	if graphErr := <-writeErr; err == nil && graphErr != nil {
+		err = fmt.Errorf("unable to write graph: %s", graphErr)
+	}
+
+	if err != nil {
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Trailer")
+			c.Fail(500, err)
+			return
+		}
+		c.Writer.Header().Set("X-Aptly-Error", err.Error())
+	}
 }
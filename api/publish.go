@@ -3,9 +3,11 @@ package api
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
+	ctx "github.com/smira/aptly/context"
 	"github.com/smira/aptly/deb"
 	"github.com/smira/aptly/utils"
 	"strings"
+	"time"
 )
 
 type SigningOptions struct {
@@ -23,18 +25,14 @@ func getSigner(options *SigningOptions) (utils.Signer, error) {
 		return nil, nil
 	}
 
-	signer := &utils.GpgSigner{}
-	signer.SetKey(options.GpgKey)
-	signer.SetKeyRing(options.Keyring, options.SecretKeyring)
-	signer.SetPassphrase(options.Passphrase, options.PassphraseFile)
-	signer.SetBatch(options.Batch)
-
-	err := signer.Init()
-	if err != nil {
-		return nil, err
-	}
-
-	return signer, nil
+	return context.NewSigner(ctx.SignerOptions{
+		GpgKey:         options.GpgKey,
+		Keyring:        options.Keyring,
+		SecretKeyring:  options.SecretKeyring,
+		Passphrase:     options.Passphrase,
+		PassphraseFile: options.PassphraseFile,
+		Batch:          options.Batch,
+	})
 }
 
 // Replace '_' with '/' and double '__' with single '_'
@@ -61,8 +59,19 @@ func apiPublishRepoOrSnapshot(c *gin.Context) {
 		Label          string
 		Origin         string
 		ForceOverwrite bool
+		Strict         bool
+		LatestOnly     bool
 		Architectures  []string
 		Signing        SigningOptions
+
+		SourceOnlyComponents []string
+		InReleaseOnly        bool
+		SkipRelease          bool
+		NotAutomatic         bool
+		ButAutomaticUpgrades bool
+		FilenamePrefix       string
+		ChecksumsManifest    bool
+		PinFriendly          bool
 	}
 
 	if !c.Bind(&b) {
@@ -145,6 +154,36 @@ func apiPublishRepoOrSnapshot(c *gin.Context) {
 	}
 	published.Origin = b.Origin
 	published.Label = b.Label
+	published.InReleaseOnly = b.InReleaseOnly
+	published.SkipRelease = b.SkipRelease
+	published.NotAutomatic = b.NotAutomatic
+	published.ButAutomaticUpgrades = b.ButAutomaticUpgrades
+	published.ChecksumsManifest = b.ChecksumsManifest
+
+	published.PinFriendly = b.PinFriendly
+	err = published.CheckPinning()
+	if err != nil {
+		c.Fail(400, fmt.Errorf("unable to publish: %s", err))
+		return
+	}
+
+	err = published.CheckReleaseFlags()
+	if err != nil {
+		c.Fail(400, fmt.Errorf("unable to publish: %s", err))
+		return
+	}
+
+	err = published.SetSourceOnlyComponents(b.SourceOnlyComponents)
+	if err != nil {
+		c.Fail(400, fmt.Errorf("unable to publish: %s", err))
+		return
+	}
+
+	err = published.SetFilenamePrefix(b.FilenamePrefix)
+	if err != nil {
+		c.Fail(400, fmt.Errorf("unable to publish: %s", err))
+		return
+	}
 
 	duplicate := collection.CheckDuplicate(published)
 	if duplicate != nil {
@@ -153,7 +192,24 @@ func apiPublishRepoOrSnapshot(c *gin.Context) {
 		return
 	}
 
+	published.SetSkipSigning(b.Signing.Skip)
+
+	err = published.SetCompressionLevels(context.CompressionLevels())
+	if err != nil {
+		c.Fail(500, fmt.Errorf("unable to publish: %s", err))
+		return
+	}
+	published.SetTempDir(context.TempDir())
+
+	task, taskCtx := tasks.Start(fmt.Sprintf("publish %s", published))
+	published.SetContext(taskCtx)
+	published.SetStrictDuplicates(b.Strict)
+	published.LatestOnly = b.LatestOnly
+
 	err = published.Publish(context.PackagePool(), context, context.CollectionFactory(), signer, nil, b.ForceOverwrite)
+	tasks.Warn(task, published.PartialFailures())
+	tasks.Warn(task, published.Warnings())
+	tasks.Finish(task, err)
 	if err != nil {
 		c.Fail(500, fmt.Errorf("unable to publish: %s", err))
 		return
@@ -174,8 +230,17 @@ func apiPublishUpdateSwitch(c *gin.Context) {
 	distribution := c.Params.ByName("distribution")
 
 	var b struct {
-		ForceOverwrite bool
-		Signing        SigningOptions
+		ForceOverwrite       bool
+		Strict               bool
+		Force                bool
+		LatestOnly           *bool
+		Signing              SigningOptions
+		InReleaseOnly        *bool
+		SkipRelease          *bool
+		NotAutomatic         *bool
+		ButAutomaticUpgrades *bool
+		ChecksumsManifest    *bool
+		PinFriendly          *bool
 	}
 
 	if !c.Bind(&b) {
@@ -218,15 +283,72 @@ func apiPublishUpdateSwitch(c *gin.Context) {
 		published.UpdateLocalRepo(component)
 	}
 
+	if b.InReleaseOnly != nil {
+		published.InReleaseOnly = *b.InReleaseOnly
+	}
+	if b.SkipRelease != nil {
+		published.SkipRelease = *b.SkipRelease
+	}
+	if b.NotAutomatic != nil {
+		published.NotAutomatic = *b.NotAutomatic
+	}
+	if b.ButAutomaticUpgrades != nil {
+		published.ButAutomaticUpgrades = *b.ButAutomaticUpgrades
+	}
+	if b.ChecksumsManifest != nil {
+		published.ChecksumsManifest = *b.ChecksumsManifest
+	}
+	if b.LatestOnly != nil {
+		published.LatestOnly = *b.LatestOnly
+	}
+	if b.PinFriendly != nil {
+		published.PinFriendly = *b.PinFriendly
+	}
+
+	err = published.CheckPinning()
+	if err != nil {
+		c.Fail(400, fmt.Errorf("unable to update: %s", err))
+		return
+	}
+
+	err = published.CheckReleaseFlags()
+	if err != nil {
+		c.Fail(400, fmt.Errorf("unable to update: %s", err))
+		return
+	}
+
+	published.SetSkipSigning(b.Signing.Skip)
+
+	err = published.SetCompressionLevels(context.CompressionLevels())
+	if err != nil {
+		c.Fail(500, fmt.Errorf("unable to update: %s", err))
+		return
+	}
+	published.SetTempDir(context.TempDir())
+
+	task, taskCtx := tasks.Start(fmt.Sprintf("update %s", published))
+	published.SetContext(taskCtx)
+	published.SetStrictDuplicates(b.Strict)
+	published.SetArchitecturesCheck(b.Force)
+
 	err = published.Publish(context.PackagePool(), context, context.CollectionFactory(), signer, nil, b.ForceOverwrite)
+	tasks.Warn(task, published.PartialFailures())
+	tasks.Warn(task, published.Warnings())
+	tasks.Finish(task, err)
+	if _, ok := err.(*deb.ArchitecturesMismatchError); ok {
+		c.Fail(400, fmt.Errorf("unable to update: %s", err))
+		return
+	}
 	if err != nil {
 		c.Fail(500, fmt.Errorf("unable to update: %s", err))
+		return
 	}
 
 	err = collection.Update(published)
 	if err != nil {
 		c.Fail(500, fmt.Errorf("unable to save to DB: %s", err))
 	}
+	invalidatePublishedCache(published)
 
 	err = collection.CleanupPrefixComponentFiles(published.Prefix, components,
 		context.GetPublishedStorage(storage), context.CollectionFactory(), nil)
@@ -237,7 +359,150 @@ func apiPublishUpdateSwitch(c *gin.Context) {
 	c.JSON(200, published)
 }
 
+// POST /publish/:prefix/:distribution/resign
+func apiPublishResign(c *gin.Context) {
+	param := parseEscapedPath(c.Params.ByName("prefix"))
+	storage, prefix := deb.ParsePrefix(param)
+	distribution := c.Params.ByName("distribution")
+
+	var b struct {
+		Signing  SigningOptions
+		ValidFor string
+	}
+
+	if !c.Bind(&b) {
+		return
+	}
+
+	if b.Signing.Skip {
+		c.Fail(400, fmt.Errorf("unable to resign: signing can't be skipped"))
+		return
+	}
+
+	var validFor time.Duration
+	if b.ValidFor != "" {
+		var err error
+
+		validFor, err = time.ParseDuration(b.ValidFor)
+		if err == nil && validFor <= 0 {
+			err = fmt.Errorf("should be positive")
+		}
+		if err != nil {
+			c.Fail(400, fmt.Errorf("unable to resign: invalid ValidFor: %s", err))
+			return
+		}
+	}
+
+	signer, err := getSigner(&b.Signing)
+	if err != nil {
+		c.Fail(500, fmt.Errorf("unable to initialize GPG signer: %s", err))
+		return
+	}
+
+	collection := context.CollectionFactory().PublishedRepoCollection()
+	collection.Lock()
+	defer collection.Unlock()
+
+	published, err := collection.ByStoragePrefixDistribution(storage, prefix, distribution)
+	if err != nil {
+		c.Fail(404, fmt.Errorf("unable to resign: %s", err))
+		return
+	}
+
+	published.SetTempDir(context.TempDir())
+
+	err = published.Resign(context, signer, validFor, nil)
+	if err != nil {
+		c.Fail(500, fmt.Errorf("unable to resign: %s", err))
+		return
+	}
+
+	c.JSON(200, published)
+}
+
 // DELETE /publish/:prefix/:distribution
 func apiPublishDrop(c *gin.Context) {
 	c.JSON(400, gin.H{})
 }
+
+// GET /publish/:prefix/:distribution
+func apiPublishShow(c *gin.Context) {
+	published, ok := loadPublished(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(200, published)
+}
+
+// GET /publish/:prefix/:distribution/packages
+func apiPublishPackages(c *gin.Context) {
+	published, ok := loadPublished(c)
+	if !ok {
+		return
+	}
+
+	components := published.Components()
+	if component := c.Request.URL.Query().Get("component"); component != "" {
+		if published.SourceName(component) == "" {
+			c.Fail(404, fmt.Errorf("component %s is not published", component))
+			return
+		}
+		components = []string{component}
+	}
+
+	reflist := deb.NewPackageRefList()
+	for _, component := range components {
+		componentRefs, err := published.PublishedRefList(component, context.CollectionFactory().PackageCollection())
+		if err != nil {
+			c.Fail(500, err)
+			return
+		}
+		reflist = reflist.Merge(componentRefs, false)
+	}
+
+	showPackages(c, publishedCacheID(published, components), reflist)
+}
+
+// publishedCacheID builds id of package list of published components in package list cache
+func publishedCacheID(published *deb.PublishedRepo, components []string) string {
+	return published.UUID + ":" + strings.Join(components, ",")
+}
+
+// invalidatePublishedCache removes cached package lists of published repository
+func invalidatePublishedCache(published *deb.PublishedRepo) {
+	packageListCache.Invalidate(publishedCacheID(published, published.Components()))
+	for _, component := range published.Components() {
+		packageListCache.Invalidate(publishedCacheID(published, []string{component}))
+	}
+}
+
+// loadPublished looks up published repository by :prefix & :distribution
+func loadPublished(c *gin.Context) (*deb.PublishedRepo, bool) {
+	param := parseEscapedPath(c.Params.ByName("prefix"))
+	storage, prefix := deb.ParsePrefix(param)
+	distribution := c.Params.ByName("distribution")
+
+	// published.LoadComplete would touch local repo collection
+	localRepoCollection := context.CollectionFactory().LocalRepoCollection()
+	localRepoCollection.RLock()
+	defer localRepoCollection.RUnlock()
+
+	collection := context.CollectionFactory().PublishedRepoCollection()
+	collection.RLock()
+	defer collection.RUnlock()
+
+	published, err := collection.ByStoragePrefixDistribution(storage, prefix, distribution)
+	if err != nil {
+		c.Fail(404, err)
+		return nil, false
+	}
+
+	err = collection.LoadComplete(published, context.CollectionFactory())
+	if err != nil {
+		c.Fail(500, err)
+		return nil, false
+	}
+
+	return published, true
+}
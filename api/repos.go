@@ -6,23 +6,58 @@ import (
 	"github.com/smira/aptly/aptly"
 	"github.com/smira/aptly/database"
 	"github.com/smira/aptly/deb"
+	"github.com/smira/aptly/query"
 	"github.com/smira/aptly/utils"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // GET /api/repos
 func apiReposList(c *gin.Context) {
 	result := []*deb.LocalRepo{}
 
+	labels, err := labelsFilter(c)
+	if err != nil {
+		c.Fail(400, err)
+		return
+	}
+
+	published, err := publishedFilter(c)
+	if err != nil {
+		c.Fail(400, err)
+		return
+	}
+
 	collection := context.CollectionFactory().LocalRepoCollection()
 	collection.RLock()
 	defer collection.RUnlock()
 
-	context.CollectionFactory().LocalRepoCollection().ForEach(func(r *deb.LocalRepo) error {
+	publishedCollection := context.CollectionFactory().PublishedRepoCollection()
+	publishedCollection.RLock()
+	defer publishedCollection.RUnlock()
+
+	sortMethodString := c.Request.URL.Query().Get("sort")
+	if sortMethodString == "" {
+		sortMethodString = "name"
+	}
+
+	err = context.CollectionFactory().LocalRepoCollection().ForEachSorted(sortMethodString, func(r *deb.LocalRepo) error {
+		if !r.Labels.Matches(labels) {
+			return nil
+		}
+		if published != nil && (len(publishedCollection.ByLocalRepo(r)) > 0) != *published {
+			return nil
+		}
+
 		result = append(result, r)
 		return nil
 	})
+	if err != nil {
+		c.Fail(400, err)
+		return
+	}
 
 	c.JSON(200, result)
 }
@@ -34,21 +69,31 @@ func apiReposCreate(c *gin.Context) {
 		Comment             string
 		DefaultDistribution string
 		DefaultComponent    string
+		Labels              deb.Labels
+		KeepVersions        int
 	}
 
 	if !c.Bind(&b) {
 		return
 	}
 
+	err := deb.ValidateKeepVersions(b.KeepVersions)
+	if err != nil {
+		c.Fail(400, err)
+		return
+	}
+
 	repo := deb.NewLocalRepo(b.Name, b.Comment)
 	repo.DefaultComponent = b.DefaultComponent
 	repo.DefaultDistribution = b.DefaultDistribution
+	repo.Labels = deb.Labels{}.Merge(b.Labels)
+	repo.KeepVersions = b.KeepVersions
 
 	collection := context.CollectionFactory().LocalRepoCollection()
 	collection.Lock()
 	defer collection.Unlock()
 
-	err := context.CollectionFactory().LocalRepoCollection().Add(repo)
+	err = context.CollectionFactory().LocalRepoCollection().Add(repo)
 	if err != nil {
 		c.Fail(400, err)
 		return
@@ -60,15 +105,26 @@ func apiReposCreate(c *gin.Context) {
 // PUT /api/repos/:name
 func apiReposEdit(c *gin.Context) {
 	var b struct {
+		Name                string
 		Comment             string
 		DefaultDistribution string
 		DefaultComponent    string
+		Labels              deb.Labels
+		KeepVersions        *int
 	}
 
 	if !c.Bind(&b) {
 		return
 	}
 
+	if b.KeepVersions != nil {
+		err := deb.ValidateKeepVersions(*b.KeepVersions)
+		if err != nil {
+			c.Fail(400, err)
+			return
+		}
+	}
+
 	collection := context.CollectionFactory().LocalRepoCollection()
 	collection.Lock()
 	defer collection.Unlock()
@@ -79,6 +135,14 @@ func apiReposEdit(c *gin.Context) {
 		return
 	}
 
+	if b.Name != "" && b.Name != repo.Name {
+		_, err = collection.ByName(b.Name)
+		if err == nil {
+			c.Fail(409, fmt.Errorf("unable to rename: local repo %s already exists", b.Name))
+			return
+		}
+		repo.Name = b.Name
+	}
 	if b.Comment != "" {
 		repo.Comment = b.Comment
 	}
@@ -88,6 +152,10 @@ func apiReposEdit(c *gin.Context) {
 	if b.DefaultComponent != "" {
 		repo.DefaultComponent = b.DefaultComponent
 	}
+	repo.Labels = repo.Labels.Merge(b.Labels)
+	if b.KeepVersions != nil {
+		repo.KeepVersions = *b.KeepVersions
+	}
 
 	err = collection.Update(repo)
 	if err != nil {
@@ -154,6 +222,7 @@ func apiReposDrop(c *gin.Context) {
 		c.Fail(500, err)
 		return
 	}
+	packageListCache.Invalidate(repo.UUID)
 
 	c.JSON(200, gin.H{})
 }
@@ -176,7 +245,7 @@ func apiReposPackagesShow(c *gin.Context) {
 		return
 	}
 
-	showPackages(c, repo.RefList())
+	showPackages(c, repo.UUID, repo.RefList())
 }
 
 // Handler for both add and delete
@@ -238,6 +307,7 @@ func apiReposPackagesAddDelete(c *gin.Context, cb func(list *deb.PackageList, p
 		c.Fail(500, fmt.Errorf("unable to save: %s", err))
 		return
 	}
+	packageListCache.Invalidate(repo.UUID)
 
 	c.JSON(200, repo)
 
@@ -252,10 +322,127 @@ func apiReposPackagesAdd(c *gin.Context) {
 
 // DELETE /repos/:name/packages
 func apiReposPackagesDelete(c *gin.Context) {
-	apiReposPackagesAddDelete(c, func(list *deb.PackageList, p *deb.Package) error {
+	var b struct {
+		PackageRefs []string
+		Queries     []string
+		CheckDeps   bool
+	}
+
+	if !c.Bind(&b) {
+		return
+	}
+
+	collection := context.CollectionFactory().LocalRepoCollection()
+	collection.Lock()
+	defer collection.Unlock()
+
+	repo, err := collection.ByName(c.Params.ByName("name"))
+	if err != nil {
+		c.Fail(404, err)
+		return
+	}
+
+	err = collection.LoadComplete(repo)
+	if err != nil {
+		c.Fail(500, err)
+		return
+	}
+
+	list, err := deb.NewPackageListFromRefList(repo.RefList(), context.CollectionFactory().PackageCollection(), nil)
+	if err != nil {
+		c.Fail(500, err)
+		return
+	}
+
+	toRemove := deb.NewPackageList()
+
+	// verify package refs and build package list
+	for _, ref := range b.PackageRefs {
+		var p *deb.Package
+
+		p, err = context.CollectionFactory().PackageCollection().ByKey([]byte(ref))
+		if err != nil {
+			if err == database.ErrNotFound {
+				c.Fail(404, fmt.Errorf("package %s: %s", ref, err))
+			} else {
+				c.Fail(500, err)
+			}
+			return
+		}
+		err = toRemove.Add(p)
+		if err != nil {
+			c.Fail(400, err)
+			return
+		}
+	}
+
+	if len(b.Queries) > 0 {
+		queries := make([]deb.PackageQuery, len(b.Queries))
+		for i := range b.Queries {
+			queries[i], err = query.Parse(b.Queries[i])
+			if err != nil {
+				c.Fail(400, err)
+				return
+			}
+		}
+
+		list.PrepareIndex()
+
+		var matched *deb.PackageList
+		matched, err = list.Filter(queries, false, nil, 0, nil)
+		if err != nil {
+			c.Fail(500, err)
+			return
+		}
+		err = toRemove.Append(matched)
+		if err != nil {
+			c.Fail(400, err)
+			return
+		}
+	}
+
+	toRemove.ForEach(func(p *deb.Package) error {
 		list.Remove(p)
 		return nil
 	})
+
+	if b.CheckDeps {
+		var architecturesList []string
+
+		if len(context.ArchitecturesList()) > 0 {
+			architecturesList = context.ArchitecturesList()
+		} else {
+			architecturesList = list.Architectures(false)
+		}
+
+		var dependents []*deb.Package
+		dependents, err = list.FindDependents(toRemove, context.DependencyOptions(), architecturesList)
+		if err != nil {
+			c.Fail(500, err)
+			return
+		}
+
+		if len(dependents) > 0 {
+			names := make([]string, len(dependents))
+			for i := range dependents {
+				names[i] = dependents[i].String()
+			}
+
+			c.Fail(409, fmt.Errorf("unable to remove: packages depend on packages being removed: %s", strings.Join(names, ", ")))
+			return
+		}
+	}
+
+	repo.UpdateRefList(deb.NewPackageRefListFromPackageList(list))
+
+	err = context.CollectionFactory().LocalRepoCollection().Update(repo)
+	if err != nil {
+		c.Fail(500, fmt.Errorf("unable to save: %s", err))
+		return
+	}
+	packageListCache.Invalidate(repo.UUID)
+
+	c.JSON(200, repo)
 }
 
 // POST /repos/:name/file/:dir/:file
@@ -266,8 +453,35 @@ func apiReposPackageFromFile(c *gin.Context) {
 
 // POST /repos/:name/file/:dir
 func apiReposPackageFromDir(c *gin.Context) {
-	forceReplace := c.Request.URL.Query().Get("forceReplace") == "1"
+	conflictPolicy := c.Request.URL.Query().Get("conflict")
+	if conflictPolicy == "" {
+		conflictPolicy = deb.ConflictFail
+	}
+	if c.Request.URL.Query().Get("forceReplace") == "1" {
+		conflictPolicy = deb.ConflictReplace
+	}
+	if !utils.StrSliceHasItem([]string{deb.ConflictFail, deb.ConflictSkip, deb.ConflictReplace}, conflictPolicy) {
+		c.Fail(400, fmt.Errorf("unknown conflict policy: %s, supported policies: %s, %s, %s", conflictPolicy,
+			deb.ConflictFail, deb.ConflictSkip, deb.ConflictReplace))
+		return
+	}
 	noRemove := c.Request.URL.Query().Get("noRemove") == "1"
+	noDowngrade := c.Request.URL.Query().Get("noDowngrade") == "1"
+	normalizeFields := c.Request.URL.Query().Get("normalizeFields") == "1"
+
+	// keepVersions overrides repository setting, even with 0 (keep all versions)
+	var keepVersions *int
+	if _, ok := c.Request.URL.Query()["keepVersions"]; ok {
+		value, err := strconv.Atoi(c.Request.URL.Query().Get("keepVersions"))
+		if err == nil {
+			err = deb.ValidateKeepVersions(value)
+		}
+		if err != nil {
+			c.Fail(400, fmt.Errorf("unable to parse keepVersions: %s", err))
+			return
+		}
+		keepVersions = &value
+	}
 
 	if !verifyDir(c) {
 		return
@@ -295,7 +509,33 @@ func apiReposPackageFromDir(c *gin.Context) {
 		return
 	}
 
-	verifier := &utils.GpgVerifier{}
+	var verifier utils.Verifier
+	if c.Request.URL.Query().Get("verifyDsc") == "1" {
+		gpgVerifier := &utils.GpgVerifier{}
+		for _, name := range c.Request.URL.Query()["keyring"] {
+			keyring, ok := verificationKeyring(c, name)
+			if !ok {
+				return
+			}
+			gpgVerifier.AddKeyring(keyring)
+		}
+
+		err = gpgVerifier.InitKeyring()
+		if err != nil {
+			c.Fail(500, fmt.Errorf("unable to initialize GPG verifier: %s", err))
+			return
+		}
+		verifier = gpgVerifier
+	}
+
+	debsigVerifier := deb.NewDebsigVerifier(context.Config().Debsig)
+	if debsigVerifier != nil {
+		err = debsigVerifier.Init()
+		if err != nil {
+			c.Fail(500, fmt.Errorf("unable to initialize debsig verifier: %s", err))
+			return
+		}
+	}
 
 	var (
 		sources                      []string
@@ -315,7 +555,7 @@ func apiReposPackageFromDir(c *gin.Context) {
 		sources = []string{filepath.Join(context.UploadPath(), c.Params.ByName("dir"), c.Params.ByName("file"))}
 	}
 
-	packageFiles, failedFiles, err = deb.CollectPackageFiles(sources, reporter)
+	packageFiles, failedFiles, err = deb.CollectPackageFiles(sources, true, reporter)
 
 	if err != nil {
 		c.Fail(500, fmt.Errorf("unable to collect package files: %s", err))
@@ -328,7 +568,16 @@ func apiReposPackageFromDir(c *gin.Context) {
 		return
 	}
 
-	processedFiles, failedFiles2, err = deb.ImportPackageFiles(list, packageFiles, forceReplace, verifier, context.PackagePool(),
+	options := deb.ImportOptions{
+		ConflictPolicy:  conflictPolicy,
+		NoDowngrade:     noDowngrade,
+		NormalizeFields: normalizeFields,
+		Verifier:        verifier,
+		DebsigVerifier:  debsigVerifier,
+		Hook:            deb.NewPreAddHook(context.Config().PreAddHook),
+	}
+
+	processedFiles, failedFiles2, err = deb.ImportPackageFiles(list, packageFiles, options, context.PackagePool(),
 		context.CollectionFactory().PackageCollection(), reporter)
 	failedFiles = append(failedFiles, failedFiles2...)
 
@@ -337,6 +586,13 @@ func apiReposPackageFromDir(c *gin.Context) {
 		return
 	}
 
+	if keepVersions == nil {
+		keepVersions = &repo.KeepVersions
+	}
+	for _, p := range list.RemoveOldVersions(*keepVersions) {
+		reporter.Removed("%s removed, keeping %d latest versions", p, *keepVersions)
+	}
+
 	repo.UpdateRefList(deb.NewPackageRefListFromPackageList(list))
 
 	err = context.CollectionFactory().LocalRepoCollection().Update(repo)
@@ -344,6 +600,13 @@ func apiReposPackageFromDir(c *gin.Context) {
 		c.Fail(500, fmt.Errorf("unable to save: %s", err))
 		return
 	}
+	packageListCache.Invalidate(repo.UUID)
+
+	if !noRemove && len(failedFiles) > 0 {
+		// upload directory is cleaned up only on clean add, so that failed import could be retried
+		reporter.Warning("files are kept in upload directory as some files failed to be added")
+		noRemove = true
+	}
 
 	if !noRemove {
 		processedFiles = utils.StrSliceDeduplicate(processedFiles)
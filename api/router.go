@@ -1,6 +1,7 @@
 package api
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
 	ctx "github.com/smira/aptly/context"
 	"net/http"
@@ -13,14 +14,28 @@ func Router(c *ctx.AptlyContext) http.Handler {
 	context = c
 
 	go cacheFlusher()
+	detectGraphviz()
 
 	router := gin.Default()
 	router.Use(gin.ErrorLogger())
 
+	if context.ReadOnly() {
+		router.Use(readOnlyGuard)
+	}
+
+	if journal := context.AuditJournal(); journal != nil {
+		router.Use(auditRecorder(journal))
+	}
+
 	root := router.Group("/api")
 
 	{
 		root.GET("/version", apiVersion)
+		root.GET("/version/compare", apiVersionCompare)
+	}
+
+	{
+		root.GET("/config/validate", apiConfigValidate)
 	}
 
 	{
@@ -38,9 +53,11 @@ func Router(c *ctx.AptlyContext) http.Handler {
 		root.POST("/repos/:name/file/:dir", apiReposPackageFromDir)
 
 		root.POST("/repos/:name/snapshots", apiSnapshotsCreateFromRepository)
+		root.POST("/repos/:name/snapshot-and-clear", apiSnapshotsCreateFromRepositoryAndClear)
 	}
 
 	{
+		root.GET("/mirrors/:name/packages", apiMirrorsPackages)
 		root.POST("/mirrors/:name/snapshots", apiSnapshotsCreateFromMirror)
 	}
 
@@ -56,18 +73,23 @@ func Router(c *ctx.AptlyContext) http.Handler {
 		root.GET("/publish", apiPublishList)
 		root.POST("/publish/:prefix/repos", apiPublishRepoOrSnapshot)
 		root.POST("/publish/:prefix/snapshots", apiPublishRepoOrSnapshot)
+		root.GET("/publish/:prefix/:distribution", apiPublishShow)
+		root.GET("/publish/:prefix/:distribution/packages", apiPublishPackages)
 		root.PUT("/publish/:prefix/:distribution", apiPublishUpdateSwitch)
+		root.POST("/publish/:prefix/:distribution/resign", apiPublishResign)
 		root.DELETE("/publish/:prefix/:distribution", apiPublishDrop)
 	}
 
 	{
 		root.GET("/snapshots", apiSnapshotsList)
 		root.POST("/snapshots", apiSnapshotsCreate)
+		root.POST("/snapshots/batch", apiSnapshotsCreateBatch)
 		root.PUT("/snapshots/:name", apiSnapshotsUpdate)
 		root.GET("/snapshots/:name", apiSnapshotsShow)
 		root.GET("/snapshots/:name/packages", apiSnapshotsSearchPackages)
 		root.DELETE("/snapshots/:name", apiSnapshotsDrop)
 		root.GET("/snapshots/:name/diff/:withSnapshot", apiSnapshotsDiff)
+		root.POST("/snapshots/:name/filter", apiSnapshotsFilter)
 	}
 
 	{
@@ -78,5 +100,31 @@ func Router(c *ctx.AptlyContext) http.Handler {
 		root.GET("/graph.:ext", apiGraph)
 	}
 
+	{
+		root.POST("/gpg/verify", apiGPGVerify)
+		root.POST("/gpg/keys", apiGPGKeysImport)
+		root.GET("/gpg/keys/:keyring", apiGPGKeysList)
+		root.DELETE("/gpg/keys/:keyring/:key", apiGPGKeysDelete)
+	}
+
+	{
+		root.GET("/audit", apiAudit)
+	}
+
+	{
+		root.GET("/tasks", apiTasksList)
+		root.DELETE("/tasks/:id", apiTasksCancel)
+	}
+
 	return router
 }
+
+// readOnlyGuard rejects all requests which might modify aptly state
+func readOnlyGuard(c *gin.Context) {
+	if c.Request.Method != "GET" && c.Request.Method != "HEAD" {
+		c.Fail(405, fmt.Errorf("aptly is running in read-only mode, %s requests are not allowed", c.Request.Method))
+		return
+	}
+
+	c.Next()
+}
@@ -5,6 +5,10 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/smira/aptly/database"
 	"github.com/smira/aptly/deb"
+	"github.com/smira/aptly/query"
+	"sort"
+	"strings"
+	"time"
 )
 
 // GET /api/snapshots
@@ -19,8 +23,31 @@ func apiSnapshotsList(c *gin.Context) {
 		SortMethodString = "name"
 	}
 
+	labels, err := labelsFilter(c)
+	if err != nil {
+		c.Fail(400, err)
+		return
+	}
+
+	published, err := publishedFilter(c)
+	if err != nil {
+		c.Fail(400, err)
+		return
+	}
+
+	publishedCollection := context.CollectionFactory().PublishedRepoCollection()
+	publishedCollection.RLock()
+	defer publishedCollection.RUnlock()
+
 	result := []*deb.Snapshot{}
 	collection.ForEachSorted(SortMethodString, func(snapshot *deb.Snapshot) error {
+		if !snapshot.Labels.Matches(labels) {
+			return nil
+		}
+		if published != nil && (len(publishedCollection.BySnapshot(snapshot)) > 0) != *published {
+			return nil
+		}
+
 		result = append(result, snapshot)
 		return nil
 	})
@@ -39,6 +66,7 @@ func apiSnapshotsCreateFromMirror(c *gin.Context) {
 	var b struct {
 		Name        string `binding:"required"`
 		Description string
+		LatestOnly  bool
 	}
 
 	if !c.Bind(&b) {
@@ -81,6 +109,10 @@ func apiSnapshotsCreateFromMirror(c *gin.Context) {
 		snapshot.Description = b.Description
 	}
 
+	if b.LatestOnly {
+		snapshot.FilterLatestRefs()
+	}
+
 	err = snapshotCollection.Add(snapshot)
 	if err != nil {
 		c.Fail(400, err)
@@ -102,6 +134,7 @@ func apiSnapshotsCreate(c *gin.Context) {
 		Description     string
 		SourceSnapshots []string
 		PackageRefs     []string
+		Labels          deb.Labels
 	}
 
 	if !c.Bind(&b) {
@@ -157,6 +190,7 @@ func apiSnapshotsCreate(c *gin.Context) {
 	}
 
 	snapshot = deb.NewSnapshotFromRefList(b.Name, sources, deb.NewPackageRefListFromPackageList(list), b.Description)
+	snapshot.Labels = deb.Labels{}.Merge(b.Labels)
 
 	err = snapshotCollection.Add(snapshot)
 	if err != nil {
@@ -178,6 +212,7 @@ func apiSnapshotsCreateFromRepository(c *gin.Context) {
 	var b struct {
 		Name        string `binding:"required"`
 		Description string
+		LatestOnly  bool
 	}
 
 	if !c.Bind(&b) {
@@ -214,6 +249,10 @@ func apiSnapshotsCreateFromRepository(c *gin.Context) {
 		snapshot.Description = b.Description
 	}
 
+	if b.LatestOnly {
+		snapshot.FilterLatestRefs()
+	}
+
 	err = snapshotCollection.Add(snapshot)
 	if err != nil {
 		c.Fail(400, err)
@@ -223,6 +262,70 @@ func apiSnapshotsCreateFromRepository(c *gin.Context) {
 	c.JSON(201, snapshot)
 }
 
+// POST /api/repos/:name/snapshot-and-clear
+func apiSnapshotsCreateFromRepositoryAndClear(c *gin.Context) {
+	var (
+		err      error
+		repo     *deb.LocalRepo
+		snapshot *deb.Snapshot
+	)
+
+	var b struct {
+		Name        string `binding:"required"`
+		Description string
+	}
+
+	if !c.Bind(&b) {
+		return
+	}
+
+	collection := context.CollectionFactory().LocalRepoCollection()
+	collection.Lock()
+	defer collection.Unlock()
+
+	snapshotCollection := context.CollectionFactory().SnapshotCollection()
+	snapshotCollection.Lock()
+	defer snapshotCollection.Unlock()
+
+	repo, err = collection.ByName(c.Params.ByName("name"))
+	if err != nil {
+		c.Fail(404, err)
+		return
+	}
+
+	err = collection.LoadComplete(repo)
+	if err != nil {
+		c.Fail(500, err)
+		return
+	}
+
+	snapshot, err = deb.NewSnapshotFromLocalRepo(b.Name, repo)
+	if err != nil {
+		c.Fail(400, err)
+		return
+	}
+
+	if b.Description != "" {
+		snapshot.Description = b.Description
+	}
+
+	_, err = snapshotCollection.ByName(snapshot.Name)
+	if err == nil {
+		c.Fail(400, fmt.Errorf("snapshot with name %s already exists", snapshot.Name))
+		return
+	}
+
+	// snapshot and emptied repo are saved together
+	err = context.CollectionFactory().SnapshotAndClearLocalRepo(snapshot, repo)
+	if err != nil {
+		c.Fail(500, fmt.Errorf("unable to save: %s", err))
+		return
+	}
+	packageListCache.Invalidate(repo.UUID)
+
+	c.JSON(201, snapshot)
+}
+
 // PUT /api/snapshots/:name
 func apiSnapshotsUpdate(c *gin.Context) {
 	var (
@@ -233,6 +336,8 @@ func apiSnapshotsUpdate(c *gin.Context) {
 	var b struct {
 		Name        string
 		Description string
+		Pinned      *bool
+		Labels      deb.Labels
 	}
 
 	if !c.Bind(&b) {
@@ -263,6 +368,12 @@ func apiSnapshotsUpdate(c *gin.Context) {
 		snapshot.Description = b.Description
 	}
 
+	if b.Pinned != nil {
+		snapshot.Pinned = *b.Pinned
+	}
+
+	snapshot.Labels = snapshot.Labels.Merge(b.Labels)
+
 	err = context.CollectionFactory().SnapshotCollection().Update(snapshot)
 	if err != nil {
 		c.Fail(500, err)
@@ -273,6 +384,8 @@ func apiSnapshotsUpdate(c *gin.Context) {
 }
 
 // GET /api/snapshots/:name
+//
+// With withDepProblems=1, packages with dependencies unsatisfied within the snapshot are listed
 func apiSnapshotsShow(c *gin.Context) {
 	collection := context.CollectionFactory().SnapshotCollection()
 	collection.RLock()
@@ -290,7 +403,36 @@ func apiSnapshotsShow(c *gin.Context) {
 		return
 	}
 
-	c.JSON(200, snapshot)
+	if c.Request.URL.Query().Get("withDepProblems") != "1" {
+		c.JSON(200, snapshot)
+		return
+	}
+
+	problems, err := snapshot.DependencyProblems(context.CollectionFactory().PackageCollection(),
+		context.DependencyOptions(), context.ArchitecturesList())
+	if err != nil {
+		c.Fail(500, err)
+		return
+	}
+
+	type dependencyProblem struct {
+		Package string
+		Missing []string
+	}
+
+	result := struct {
+		*deb.Snapshot
+		DependencyProblems []dependencyProblem
+	}{Snapshot: snapshot, DependencyProblems: make([]dependencyProblem, len(problems))}
+
+	for i, problem := range problems {
+		result.DependencyProblems[i].Package = problem.Package.String()
+		for _, dep := range problem.Missing {
+			result.DependencyProblems[i].Missing = append(result.DependencyProblems[i].Missing, dep.String())
+		}
+	}
+
+	c.JSON(200, result)
 }
 
 // DELETE /api/snapshots/:name
@@ -319,6 +461,11 @@ func apiSnapshotsDrop(c *gin.Context) {
 		return
 	}
 
+	if snapshot.Pinned && !force {
+		c.Fail(409, fmt.Errorf("won't delete pinned snapshot, unpin it or use ?force=1 to override"))
+		return
+	}
+
 	if !force {
 		snapshots := snapshotCollection.BySnapshotSource(snapshot)
 		if len(snapshots) > 0 {
@@ -332,6 +479,7 @@ func apiSnapshotsDrop(c *gin.Context) {
 		c.Fail(500, err)
 		return
 	}
+	packageListCache.Invalidate(snapshot.UUID)
 
 	c.JSON(200, gin.H{})
 }
@@ -406,5 +554,219 @@ func apiSnapshotsSearchPackages(c *gin.Context) {
 		return
 	}
 
-	showPackages(c, snapshot.RefList())
+	showPackages(c, snapshot.UUID, snapshot.RefList())
+}
+
+// POST /api/snapshots/:name/filter
+func apiSnapshotsFilter(c *gin.Context) {
+	var b struct {
+		Name          string   `binding:"required"`
+		Queries       []string `binding:"required"`
+		WithDeps      bool
+		Architectures []string
+		Description   string
+	}
+
+	if !c.Bind(&b) {
+		return
+	}
+
+	queries := make([]deb.PackageQuery, len(b.Queries))
+	for i, q := range b.Queries {
+		var err error
+		queries[i], err = query.Parse(q)
+		if err != nil {
+			c.Fail(400, fmt.Errorf("unable to parse query: %s", err))
+			return
+		}
+	}
+
+	collection := context.CollectionFactory().SnapshotCollection()
+	collection.Lock()
+	defer collection.Unlock()
+
+	source, err := collection.ByName(c.Params.ByName("name"))
+	if err != nil {
+		c.Fail(404, err)
+		return
+	}
+
+	err = collection.LoadComplete(source)
+	if err != nil {
+		c.Fail(500, err)
+		return
+	}
+
+	list, err := deb.NewPackageListFromRefList(source.RefList(), context.CollectionFactory().PackageCollection(), nil)
+	if err != nil {
+		c.Fail(500, fmt.Errorf("unable to load packages: %s", err))
+		return
+	}
+
+	list.PrepareIndex()
+
+	architecturesList := b.Architectures
+	if len(architecturesList) == 0 {
+		if len(context.ArchitecturesList()) > 0 {
+			architecturesList = context.ArchitecturesList()
+		} else {
+			architecturesList = list.Architectures(false)
+		}
+	}
+	sort.Strings(architecturesList)
+
+	if len(architecturesList) == 0 && b.WithDeps {
+		c.Fail(400, fmt.Errorf("unable to determine list of architectures, please specify explicitly"))
+		return
+	}
+
+	result, err := list.Filter(queries, b.WithDeps, nil, context.DependencyOptions(), architecturesList)
+	if err != nil {
+		c.Fail(500, fmt.Errorf("unable to filter: %s", err))
+		return
+	}
+
+	if b.Description == "" {
+		b.Description = fmt.Sprintf("Filtered '%s', query was: '%s'", source.Name, strings.Join(b.Queries, " "))
+	}
+
+	snapshot := deb.NewSnapshotFromPackageList(b.Name, []*deb.Snapshot{source}, result, b.Description)
+
+	err = collection.Add(snapshot)
+	if err != nil {
+		c.Fail(400, err)
+		return
+	}
+
+	c.JSON(201, snapshot)
+}
+
+// snapshotBatchResult is outcome of creating one snapshot in batch
+type snapshotBatchResult struct {
+	Source   string
+	Kind     string
+	Snapshot string
+	Error    string `json:",omitempty"`
+}
+
+// POST /api/snapshots/batch
+func apiSnapshotsCreateBatch(c *gin.Context) {
+	var b struct {
+		Sources []struct {
+			Name string `binding:"required"`
+			Kind string
+		} `binding:"required"`
+		NameTemplate string `binding:"required"`
+		Description  string
+	}
+
+	if !c.Bind(&b) {
+		return
+	}
+
+	remoteCollection := context.CollectionFactory().RemoteRepoCollection()
+	remoteCollection.RLock()
+	defer remoteCollection.RUnlock()
+
+	localCollection := context.CollectionFactory().LocalRepoCollection()
+	localCollection.RLock()
+	defer localCollection.RUnlock()
+
+	snapshotCollection := context.CollectionFactory().SnapshotCollection()
+	snapshotCollection.Lock()
+	defer snapshotCollection.Unlock()
+
+	task, taskCtx := tasks.Start(fmt.Sprintf("snapshot batch of %d sources", len(b.Sources)))
+
+	now := time.Now()
+	results := make([]snapshotBatchResult, len(b.Sources))
+	failed := 0
+
+	for i, source := range b.Sources {
+		results[i].Source = source.Name
+		results[i].Kind = source.Kind
+
+		var snapshot *deb.Snapshot
+		err := taskCtx.Err()
+		if err == nil {
+			snapshot, err = snapshotFromSource(source.Name, &results[i].Kind, b.NameTemplate, now)
+		}
+		if err == nil {
+			if b.Description != "" {
+				snapshot.Description = b.Description
+			}
+			err = snapshotCollection.Add(snapshot)
+		}
+
+		if err != nil {
+			results[i].Error = err.Error()
+			failed++
+			continue
+		}
+
+		results[i].Snapshot = snapshot.Name
+	}
+
+	if failed > 0 {
+		tasks.Finish(task, fmt.Errorf("%d of %d snapshots failed", failed, len(b.Sources)))
+	} else {
+		tasks.Finish(task, nil)
+	}
+
+	c.JSON(200, gin.H{"Task": task.ID, "Results": results})
+}
+
+// snapshotFromSource creates snapshot of mirror or local repo, kind is either
+// "mirror", "repo" or empty (mirror is looked up first, then local repo)
+func snapshotFromSource(name string, kind *string, template string, now time.Time) (*deb.Snapshot, error) {
+	if *kind == "" || *kind == "mirror" {
+		repo, err := context.CollectionFactory().RemoteRepoCollection().ByName(name)
+		if err == nil {
+			*kind = "mirror"
+
+			err = repo.CheckLock()
+			if err != nil {
+				return nil, err
+			}
+
+			err = context.CollectionFactory().RemoteRepoCollection().LoadComplete(repo)
+			if err != nil {
+				return nil, err
+			}
+
+			return deb.NewSnapshotFromRepository(snapshotBatchName(template, name, now), repo)
+		}
+		if *kind == "mirror" {
+			return nil, err
+		}
+	}
+
+	if *kind == "" || *kind == "repo" {
+		repo, err := context.CollectionFactory().LocalRepoCollection().ByName(name)
+		if err != nil {
+			if *kind == "" {
+				return nil, fmt.Errorf("mirror or local repo with name %s not found", name)
+			}
+			return nil, err
+		}
+		*kind = "repo"
+
+		err = context.CollectionFactory().LocalRepoCollection().LoadComplete(repo)
+		if err != nil {
+			return nil, err
+		}
+
+		return deb.NewSnapshotFromLocalRepo(snapshotBatchName(template, name, now), repo)
+	}
+
+	return nil, fmt.Errorf("unknown source kind %s, expected mirror or repo", *kind)
+}
+
+// snapshotBatchName expands {source}, {date} and {time} in snapshot name template
+func snapshotBatchName(template, source string, now time.Time) string {
+	return strings.NewReplacer(
+		"{source}", source,
+		"{date}", now.Format("20060102"),
+		"{time}", now.Format("150405"),
+	).Replace(template)
 }
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	netcontext "golang.org/x/net/context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Task states
+const (
+	TaskRunning    = "RUNNING"
+	TaskCancelling = "CANCELLING"
+	TaskSucceeded  = "SUCCEEDED"
+	TaskFailed     = "FAILED"
+	TaskCancelled  = "CANCELLED"
+)
+
+// maximum number of finished tasks kept in the list
+const maxFinishedTasks = 100
+
+// Task is long-running operation (e.g. publishing) started via API
+type Task struct {
+	ID        int
+	Name      string
+	State     string
+	Error     string   `json:",omitempty"`
+	Warnings  []string `json:",omitempty"`
+	StartedAt time.Time
+	EndedAt   *time.Time `json:",omitempty"`
+
+	ctx    netcontext.Context
+	cancel netcontext.CancelFunc
+}
+
+// taskList tracks running and recently finished tasks
+type taskList struct {
+	sync.Mutex
+	lastID int
+	tasks  []*Task
+}
+
+var tasks = &taskList{}
+
+// Start registers new running task, returned context is cancelled when
+// cancellation of the task is requested (or whole aptly context is cancelled)
+func (list *taskList) Start(name string) (*Task, netcontext.Context) {
+	return list.start(name, context.Context())
+}
+
+// start registers new running task with context derived from parent
+func (list *taskList) start(name string, parent netcontext.Context) (*Task, netcontext.Context) {
+	list.Lock()
+	defer list.Unlock()
+
+	list.lastID++
+	task := &Task{ID: list.lastID, Name: name, State: TaskRunning, StartedAt: time.Now()}
+	task.ctx, task.cancel = netcontext.WithCancel(parent)
+
+	list.tasks = append(list.tasks, task)
+
+	return task, task.ctx
+}
+
+// Finish records result of the task, dropping oldest finished tasks
+func (list *taskList) Finish(task *Task, err error) {
+	list.Lock()
+	defer list.Unlock()
+
+	now := time.Now()
+	task.EndedAt = &now
+
+	if task.ctx.Err() == netcontext.Canceled {
+		task.State = TaskCancelled
+	} else if err != nil {
+		task.State = TaskFailed
+	} else {
+		task.State = TaskSucceeded
+	}
+	if err != nil {
+		task.Error = err.Error()
+	}
+	task.cancel()
+
+	finished := 0
+	for _, t := range list.tasks {
+		if t.EndedAt != nil {
+			finished++
+		}
+	}
+
+	kept := list.tasks[:0]
+	for _, t := range list.tasks {
+		if t.EndedAt != nil && finished > maxFinishedTasks {
+			finished--
+			continue
+		}
+		kept = append(kept, t)
+	}
+	list.tasks = kept
+}
+
+// Warn records warnings of the task, e.g. partial failures of publishing
+func (list *taskList) Warn(task *Task, warnings []error) {
+	list.Lock()
+	defer list.Unlock()
+
+	for _, warning := range warnings {
+		task.Warnings = append(task.Warnings, warning.Error())
+	}
+}
+
+// Cancel requests cancellation of running task
+func (list *taskList) Cancel(id int) (Task, error) {
+	list.Lock()
+	defer list.Unlock()
+
+	for _, task := range list.tasks {
+		if task.ID == id {
+			if task.State != TaskRunning {
+				return *task, fmt.Errorf("task %d is not running: %s", id, task.State)
+			}
+
+			task.State = TaskCancelling
+			task.cancel()
+			return *task, nil
+		}
+	}
+
+	return Task{}, fmt.Errorf("task %d not found", id)
+}
+
+// List returns copy of all tasks
+func (list *taskList) List() []Task {
+	list.Lock()
+	defer list.Unlock()
+
+	result := make([]Task, len(list.tasks))
+	for i, task := range list.tasks {
+		result[i] = *task
+	}
+
+	return result
+}
+
+// GET /api/tasks
+func apiTasksList(c *gin.Context) {
+	c.JSON(200, tasks.List())
+}
+
+// DELETE /api/tasks/:id
+func apiTasksCancel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.Fail(404, fmt.Errorf("task %s not found", c.Params.ByName("id")))
+		return
+	}
+
+	task, err := tasks.Cancel(id)
+	if err != nil {
+		if task.ID == 0 {
+			c.Fail(404, err)
+		} else {
+			c.Fail(409, err)
+		}
+		return
+	}
+
+	c.JSON(202, task)
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"fmt"
+	netcontext "golang.org/x/net/context"
+
+	. "gopkg.in/check.v1"
+)
+
+type TaskSuite struct {
+	list *taskList
+}
+
+var _ = Suite(&TaskSuite{})
+
+func (s *TaskSuite) SetUpTest(c *C) {
+	s.list = &taskList{}
+}
+
+func (s *TaskSuite) TestCancel(c *C) {
+	task, ctx := s.list.start("publish ppa", netcontext.Background())
+	c.Check(task.State, Equals, TaskRunning)
+
+	// task blocks until it's cancelled
+	finished := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		s.list.Finish(task, fmt.Errorf("publish interrupted: %s", ctx.Err()))
+		close(finished)
+	}()
+
+	cancelled, err := s.list.Cancel(task.ID)
+	c.Assert(err, IsNil)
+	c.Check(cancelled.State, Equals, TaskCancelling)
+	c.Check(ctx.Err(), Equals, netcontext.Canceled)
+
+	<-finished
+
+	list := s.list.List()
+	c.Assert(list, HasLen, 1)
+	c.Check(list[0].State, Equals, TaskCancelled)
+	c.Check(list[0].Error, Equals, "publish interrupted: context canceled")
+	c.Check(list[0].EndedAt, NotNil)
+
+	_, err = s.list.Cancel(task.ID)
+	c.Check(err, ErrorMatches, "task 1 is not running: CANCELLED")
+
+	_, err = s.list.Cancel(42)
+	c.Check(err, ErrorMatches, "task 42 not found")
+}
+
+func (s *TaskSuite) TestFinish(c *C) {
+	ok, _ := s.list.start("ok", netcontext.Background())
+	failed, _ := s.list.start("failed", netcontext.Background())
+	running, runningCtx := s.list.start("running", netcontext.Background())
+
+	s.list.Finish(ok, nil)
+	s.list.Finish(failed, fmt.Errorf("broken"))
+
+	list := s.list.List()
+	c.Assert(list, HasLen, 3)
+	c.Check(list[0].State, Equals, TaskSucceeded)
+	c.Check(list[1].State, Equals, TaskFailed)
+	c.Check(list[1].Error, Equals, "broken")
+	c.Check(list[2].State, Equals, TaskRunning)
+	c.Check(runningCtx.Err(), IsNil)
+
+	// oldest finished tasks are dropped, running tasks are kept
+	for i := 0; i < maxFinishedTasks; i++ {
+		task, _ := s.list.start(fmt.Sprintf("task%d", i), netcontext.Background())
+		s.list.Finish(task, nil)
+	}
+
+	list = s.list.List()
+	c.Assert(list, HasLen, maxFinishedTasks+1)
+	c.Check(list[0].ID, Equals, running.ID)
+	c.Check(list[1].Name, Equals, "task0")
+}
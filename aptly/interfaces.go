@@ -5,6 +5,7 @@ package aptly
 import (
 	"github.com/smira/aptly/utils"
 	"io"
+	"time"
 )
 
 // PackagePool is asbtraction of package pool storage.
@@ -21,6 +22,8 @@ type PackagePool interface {
 	Remove(path string) (size int64, err error)
 	// Import copies file into package pool
 	Import(path string, hashMD5 string) error
+	// Link hardlinks file into package pool, falling back to copying if hardlinking fails
+	Link(path string, hashMD5 string) error
 }
 
 // PublishedStorage is abstraction of filesystem storing all published repositories
@@ -47,6 +50,46 @@ type LocalPublishedStorage interface {
 	PublicPath() string
 }
 
+// PublishedFileInfo describes file in published storage
+type PublishedFileInfo struct {
+	Size    int64
+	ModTime time.Time
+	ETag    string
+}
+
+// ReadablePublishedStorage is published storage which allows to read published files back
+type ReadablePublishedStorage interface {
+	// Stat returns information about published file
+	Stat(path string) (PublishedFileInfo, error)
+	// ReadRange opens published file for reading length bytes starting at offset,
+	// negative length means reading till the end of file
+	ReadRange(path string, offset, length int64) (io.ReadCloser, error)
+}
+
+// CachingPublishedStorage is published storage which caches listings of published
+// files, cache is reset before each publishing
+type CachingPublishedStorage interface {
+	// ResetCache drops cached listings
+	ResetCache()
+}
+
+// PartialPublishedStorage is published storage which might fail partially (e.g. some
+// of mirrored targets), such failures are reported after publishing
+type PartialPublishedStorage interface {
+	// Failures returns partial failures since last ResetFailures
+	Failures() []error
+	// ResetFailures forgets partial failures, so that following operations
+	// are attempted on failed parts again
+	ResetFailures()
+}
+
+// NotExistCheckingPublishedStorage is published storage which recognizes its errors
+// caused by missing published files
+type NotExistCheckingPublishedStorage interface {
+	// IsNotExist checks whether error returned by storage reports missing file
+	IsNotExist(err error) bool
+}
+
 // PublishedStorageProvider is a thing that returns PublishedStorage by name
 type PublishedStorageProvider interface {
 	// GetPublishedStorage returns PublishedStorage by name
@@ -94,4 +137,8 @@ type Downloader interface {
 	Abort()
 	// GetProgress returns Progress object
 	GetProgress() Progress
+	// GetTempDir returns directory for temporary files ("" means system default)
+	GetTempDir() string
+	// WithRetryPolicy returns downloader which retries failed downloads according to policy
+	WithRetryPolicy(policy utils.RetryPolicy) Downloader
 }
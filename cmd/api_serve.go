@@ -18,6 +18,11 @@ func aptlyAPIServe(cmd *commander.Command, args []string) error {
 		return commander.ErrCommandError
 	}
 
+	if context.Timeout() > 0 {
+		// deadline is shared by all the API requests, so it would abort them all once passed
+		return fmt.Errorf("unable to serve: -timeout is not supported by api serve")
+	}
+
 	listen := context.Flags().Lookup("listen").Value.String()
 
 	fmt.Printf("\nStarting web server at: %s (press Ctrl+C to quit)...\n", listen)
@@ -2,12 +2,18 @@
 package cmd
 
 import (
+	"code.google.com/p/go-uuid/uuid"
 	"fmt"
 	"github.com/smira/aptly/aptly"
 	"github.com/smira/aptly/deb"
+	"github.com/smira/aptly/query"
+	"github.com/smira/aptly/utils"
 	"github.com/smira/commander"
 	"github.com/smira/flag"
 	"os"
+	"os/user"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -34,6 +40,15 @@ func ListPackagesRefList(reflist *deb.PackageRefList) (err error) {
 	return
 }
 
+// FormatTime formats timestamp for display, zero time means timestamp wasn't
+// recorded (object created by older version of aptly)
+func FormatTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Format("2006-01-02 15:04:05 MST")
+}
+
 // LookupOption checks boolean flag with default (usually config) and command-line
 // setting
 func LookupOption(defaultValue bool, flags *flag.FlagSet, name string) (result bool) {
@@ -46,6 +61,62 @@ func LookupOption(defaultValue bool, flags *flag.FlagSet, name string) (result b
 	return
 }
 
+// FilterPackageList loads packages from PackageRefList and returns those matching package query,
+// optionally with dependencies
+func FilterPackageList(reflist *deb.PackageRefList, queryString string, withDeps bool) (*deb.PackageList, error) {
+	q, err := query.Parse(queryString)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := deb.NewPackageListFromRefList(reflist, context.CollectionFactory().PackageCollection(), context.Progress())
+	if err != nil {
+		return nil, err
+	}
+
+	list.PrepareIndex()
+
+	architecturesList := []string{}
+
+	if withDeps {
+		if len(context.ArchitecturesList()) > 0 {
+			architecturesList = context.ArchitecturesList()
+		} else {
+			architecturesList = list.Architectures(false)
+		}
+
+		sort.Strings(architecturesList)
+
+		if len(architecturesList) == 0 {
+			return nil, fmt.Errorf("unable to determine list of architectures, please specify explicitly")
+		}
+	}
+
+	return list.Filter([]deb.PackageQuery{q}, withDeps, nil, context.DependencyOptions(), architecturesList)
+}
+
+// FilterRefList returns part of PackageRefList matching package query
+func FilterRefList(reflist *deb.PackageRefList, queryString string) (*deb.PackageRefList, error) {
+	list, err := FilterPackageList(reflist, queryString, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return deb.NewPackageRefListFromPackageList(list), nil
+}
+
+// publishedFilterFlags parses -published & -unpublished flags of list commands
+func publishedFilterFlags(cmd *commander.Command) (onlyPublished, onlyUnpublished bool, err error) {
+	onlyPublished = cmd.Flag.Lookup("published").Value.Get().(bool)
+	onlyUnpublished = cmd.Flag.Lookup("unpublished").Value.Get().(bool)
+
+	if onlyPublished && onlyUnpublished {
+		err = fmt.Errorf("flags -published and -unpublished are mutually exclusive")
+	}
+
+	return
+}
+
 // RootCommand creates root command in command tree
 func RootCommand() *commander.Command {
 	cmd := &commander.Command{
@@ -86,6 +157,9 @@ package environment to new version.`,
 	cmd.Flag.Bool("dep-follow-all-variants", false, "when processing dependencies, follow a & b if depdency is 'a|b'")
 	cmd.Flag.String("architectures", "", "list of architectures to consider during (comma-separated), default to all available")
 	cmd.Flag.String("config", "", "location of configuration file (default locations are /etc/aptly.conf, ~/.aptly.conf)")
+	cmd.Flag.Bool("read-only", false, "reject commands and API requests which modify aptly state")
+	cmd.Flag.String("temp-dir", "", "directory for temporary files (in-progress downloads, publishing), default is taken from config or system temp directory")
+	cmd.Flag.Duration("timeout", 0, "abort command if it doesn't complete in specified time (e.g. 30m), default is no timeout")
 
 	if aptly.EnableDebug {
 		cmd.Flag.String("cpuprofile", "", "write cpu profile to file")
@@ -93,5 +167,91 @@ package environment to new version.`,
 		cmd.Flag.String("memstats", "", "write memory stats periodically to this file")
 		cmd.Flag.Duration("meminterval", 100*time.Millisecond, "memory stats dump interval")
 	}
+
+	wrapMutatingCommands(cmd, "")
+
 	return cmd
 }
+
+// readOnlyCommands are commands which don't modify aptly state, so they're allowed
+// in read-only mode (API server enforces read-only mode on its own)
+var readOnlyCommands = map[string]bool{
+	"api serve":       true,
+	"config show":     true,
+	"config validate": true,
+	"graph":           true,
+	"mirror list":     true,
+	"mirror search":   true,
+	"mirror show":     true,
+	"mirror verify":   true,
+	"package search":  true,
+	"package show":    true,
+	"publish list":    true,
+	"publish show":    true,
+	"repo list":       true,
+	"repo search":     true,
+	"repo show":       true,
+	"serve":           true,
+	"snapshot diff":   true,
+	"snapshot list":   true,
+	"snapshot search": true,
+	"snapshot show":   true,
+	"snapshot verify": true,
+	"task run":        true,
+	"version":         true,
+	"version compare": true,
+}
+
+// wrapMutatingCommands wraps all commands not listed in readOnlyCommands, so that
+// they fail when aptly runs in read-only mode and get recorded in audit journal
+// if it's enabled
+func wrapMutatingCommands(cmd *commander.Command, path string) {
+	for _, subcommand := range cmd.Subcommands {
+		name := strings.TrimSpace(path + " " + subcommand.Name())
+
+		if subcommand.Run != nil && !readOnlyCommands[name] {
+			run := subcommand.Run
+			subcommand.Run = func(cmd *commander.Command, args []string) error {
+				if context.ReadOnly() {
+					return fmt.Errorf("unable to run %s: aptly is running in read-only mode", name)
+				}
+
+				journal := context.AuditJournal()
+				if journal == nil {
+					return run(cmd, args)
+				}
+
+				return auditCommand(journal, name, args, func() error { return run(cmd, args) })
+			}
+		}
+
+		wrapMutatingCommands(subcommand, name)
+	}
+}
+
+// auditCommand runs command recording it in audit journal, principal is the
+// user aptly is running as
+func auditCommand(journal *utils.AuditJournal, name string, args []string, run func() error) (err error) {
+	entry := &utils.AuditEntry{
+		ID:        uuid.New(),
+		Operation: name,
+		Target:    strings.Join(args, " "),
+	}
+
+	if current, e := user.Current(); e == nil {
+		entry.Principal = current.Username
+	}
+
+	e := journal.Track(entry, func() int {
+		err = run()
+		if err != nil {
+			return 500
+		}
+		return 200
+	})
+	if e != nil {
+		return fmt.Errorf("unable to run %s: %s", name, e)
+	}
+
+	return
+}
@@ -10,6 +10,7 @@ func makeCmdConfig() *commander.Command {
 		Short:     "manage aptly configuration",
 		Subcommands: []*commander.Command{
 			makeCmdConfigShow(),
+			makeCmdConfigValidate(),
 		},
 	}
 }
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/smira/commander"
+)
+
+func aptlyConfigValidate(cmd *commander.Command, args []string) error {
+	if len(args) != 0 {
+		cmd.Usage()
+		return commander.ErrCommandError
+	}
+
+	strict := cmd.Flag.Lookup("strict").Value.Get().(bool)
+
+	context.Progress().Printf("Validating configuration...\n")
+
+	problems, warnings := context.ValidateConfig()
+
+	for _, warning := range warnings {
+		context.Progress().ColoredPrintf("@y[!]@| %s", warning)
+	}
+
+	for _, problem := range problems {
+		context.Progress().ColoredPrintf("@r[x]@| %s", problem)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("configuration is invalid: %d problem(s) found", len(problems))
+	}
+
+	if strict && len(warnings) > 0 {
+		return fmt.Errorf("configuration has %d warning(s), failing in strict mode", len(warnings))
+	}
+
+	context.Progress().Printf("\nConfiguration is valid.\n")
+
+	return nil
+}
+
+func makeCmdConfigValidate() *commander.Command {
+	cmd := &commander.Command{
+		Run:       aptlyConfigValidate,
+		UsageLine: "validate",
+		Short:     "validate aptly's config",
+		Long: `
+Command validate loads the configuration and verifies that all published
+storage endpoints are reachable with configured credentials and that signing
+keyring is accessible. No modifications are performed.
+
+Example:
+
+  $ aptly config validate
+`,
+	}
+
+	cmd.Flag.Bool("strict", false, "fail on warnings as well as on problems")
+
+	return cmd
+}
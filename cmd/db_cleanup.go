@@ -5,6 +5,7 @@ import (
 	"github.com/smira/aptly/deb"
 	"github.com/smira/aptly/utils"
 	"github.com/smira/commander"
+	"os"
 	"sort"
 )
 
@@ -17,8 +18,15 @@ func aptlyDbCleanup(cmd *commander.Command, args []string) error {
 		return commander.ErrCommandError
 	}
 
+	rebuildRefCounts := context.Flags().Lookup("rebuild-refcounts").Value.Get().(bool)
+
+	if !rebuildRefCounts && context.CollectionFactory().RefCounts().Enabled() {
+		return aptlyDbCleanupIncremental()
+	}
+
 	// collect information about references packages...
 	existingPackageRefs := deb.NewPackageRefList()
+	refLists := []*deb.PackageRefList{}
 
 	context.Progress().Printf("Loading mirrors, local repos, snapshots and published repos...\n")
 	err = context.CollectionFactory().RemoteRepoCollection().ForEach(func(repo *deb.RemoteRepo) error {
@@ -28,6 +36,12 @@ func aptlyDbCleanup(cmd *commander.Command, args []string) error {
 		}
 		if repo.RefList() != nil {
 			existingPackageRefs = existingPackageRefs.Merge(repo.RefList(), false)
+			refLists = append(refLists, repo.RefList())
+		}
+		if repo.CheckpointRefList() != nil {
+			// packages downloaded by interrupted update
+			existingPackageRefs = existingPackageRefs.Merge(repo.CheckpointRefList(), false)
+			refLists = append(refLists, repo.CheckpointRefList())
 		}
 		return nil
 	})
@@ -42,6 +56,7 @@ func aptlyDbCleanup(cmd *commander.Command, args []string) error {
 		}
 		if repo.RefList() != nil {
 			existingPackageRefs = existingPackageRefs.Merge(repo.RefList(), false)
+			refLists = append(refLists, repo.RefList())
 		}
 		return nil
 	})
@@ -55,6 +70,7 @@ func aptlyDbCleanup(cmd *commander.Command, args []string) error {
 			return err
 		}
 		existingPackageRefs = existingPackageRefs.Merge(snapshot.RefList(), false)
+		refLists = append(refLists, snapshot.RefList())
 		return nil
 	})
 	if err != nil {
@@ -72,6 +88,7 @@ func aptlyDbCleanup(cmd *commander.Command, args []string) error {
 
 		for _, component := range published.Components() {
 			existingPackageRefs = existingPackageRefs.Merge(published.RefList(component), false)
+			refLists = append(refLists, published.RefList(component))
 		}
 		return nil
 	})
@@ -79,6 +96,21 @@ func aptlyDbCleanup(cmd *commander.Command, args []string) error {
 		return err
 	}
 
+	if rebuildRefCounts {
+		context.Progress().Printf("Rebuilding reference counts...\n")
+		err = context.CollectionFactory().RefCounts().Rebuild(func(handler func(refs *deb.PackageRefList) error) error {
+			for _, refs := range refLists {
+				if err := handler(refs); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("unable to rebuild reference counts: %s", err)
+		}
+	}
+
 	// ... and compare it to the list of all packages
 	context.Progress().Printf("Loading list of all packages...\n")
 	allPackageRefs := context.CollectionFactory().PackageCollection().AllPackageRefs()
@@ -157,6 +189,9 @@ func aptlyDbCleanup(cmd *commander.Command, args []string) error {
 		}
 		context.Progress().ShutdownBar()
 
+		// only paths of removed files are known, so drop all cached control files
+		deb.DefaultControlCache.Reset()
+
 		context.Progress().Printf("Disk space freed: %s...\n", utils.HumanBytes(totalSize))
 	}
 
@@ -166,6 +201,80 @@ func aptlyDbCleanup(cmd *commander.Command, args []string) error {
 	return err
 }
 
+// aptlyDbCleanupIncremental removes packages & files which reference counts dropped to zero
+func aptlyDbCleanupIncremental() error {
+	refCounts := context.CollectionFactory().RefCounts()
+
+	context.Progress().Printf("Loading list of unreferenced packages...\n")
+	toDelete, err := refCounts.UnreferencedPackages()
+	if err != nil {
+		return fmt.Errorf("unable to load reference counts: %s", err)
+	}
+
+	context.Progress().Printf("Deleting unreferenced packages (%d)...\n", toDelete.Len())
+
+	refCounts.StartBatch()
+	err = toDelete.ForEach(func(ref []byte) error {
+		err := context.CollectionFactory().PackageCollection().DeleteByKey(ref)
+		if err != nil {
+			return err
+		}
+		return refCounts.ForgetPackage(ref)
+	})
+	if err != nil {
+		refCounts.FinishBatch()
+		return err
+	}
+
+	err = refCounts.FinishBatch()
+	if err != nil {
+		return fmt.Errorf("unable to write to DB: %s", err)
+	}
+
+	filesToDelete, err := refCounts.UnreferencedFiles()
+	if err != nil {
+		return fmt.Errorf("unable to load reference counts: %s", err)
+	}
+
+	context.Progress().Printf("Deleting unreferenced files (%d)...\n", len(filesToDelete))
+
+	if len(filesToDelete) > 0 {
+		context.Progress().InitBar(int64(len(filesToDelete)), false)
+
+		var totalSize int64
+		for _, file := range filesToDelete {
+			path, err := context.PackagePool().RelativePath(file.Filename, file.MD5)
+			if err != nil {
+				return err
+			}
+
+			size, err := context.PackagePool().Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			deb.DefaultControlCache.Invalidate(file.MD5)
+
+			err = refCounts.ForgetFile(file)
+			if err != nil {
+				return err
+			}
+
+			context.Progress().AddBar(1)
+			totalSize += size
+		}
+		context.Progress().ShutdownBar()
+
+		context.Progress().Printf("Disk space freed: %s...\n", utils.HumanBytes(totalSize))
+	}
+
+	context.Progress().Printf("Compacting database...\n")
+
+	// database can't err as collection factory already constructed
+	db, _ := context.Database()
+	return db.CompactDB()
+}
+
 func makeCmdDbCleanup() *commander.Command {
 	cmd := &commander.Command{
 		Run:       aptlyDbCleanup,
@@ -175,11 +284,20 @@ func makeCmdDbCleanup() *commander.Command {
 Database cleanup removes information about unreferenced packages and removes
 files in the package pool that aren't used by packages anymore
 
+By default cleanup walks all mirrors, local repos, snapshots and published
+repositories to find unreferenced packages. With flag -rebuild-refcounts
+aptly additionally builds reference counts for packages and files in the
+package pool, which are then maintained as packages are added to or removed from
+repositories and snapshots. Once reference counts are built, subsequent
+cleanups process only packages and files which are no longer referenced.
+
 Example:
 
   $ aptly db cleanup
 `,
 	}
 
+	cmd.Flag.Bool("rebuild-refcounts", false, "rebuild reference counts from scratch and enable incremental cleanup")
+
 	return cmd
 }
@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"fmt"
 	"github.com/smira/aptly/database"
+	"github.com/smira/aptly/deb"
 	"github.com/smira/commander"
 )
 
@@ -14,10 +16,77 @@ func aptlyDbRecover(cmd *commander.Command, args []string) error {
 		return commander.ErrCommandError
 	}
 
+	if !context.Flags().Lookup("force").Value.Get().(bool) {
+		return fmt.Errorf("recovery might lose data, backup the database and run with -force to proceed")
+	}
+
 	context.Progress().Printf("Recovering database...\n")
-	err = database.RecoverDB(context.DBPath())
+	keys, err := database.RecoverDB(context.DBPath())
+	if err != nil {
+		return err
+	}
+	context.Progress().Printf("Recovered %d keys.\n", keys)
+
+	return aptlyDbRecoverCheck()
+}
+
+// aptlyDbRecoverCheck loads all the collections from recovered database,
+// reporting objects which can't be loaded completely
+func aptlyDbRecoverCheck() error {
+	var (
+		problems                                       []string
+		mirrors, localRepos, snapshots, publishedRepos int
+	)
+
+	context.Progress().Printf("Checking mirrors, local repos, snapshots and published repos...\n")
+
+	collectionFactory := context.CollectionFactory()
+
+	collectionFactory.RemoteRepoCollection().ForEach(func(repo *deb.RemoteRepo) error {
+		mirrors++
+		if err := collectionFactory.RemoteRepoCollection().LoadComplete(repo); err != nil {
+			problems = append(problems, fmt.Sprintf("mirror %s: %s", repo.Name, err))
+		}
+		return nil
+	})
 
-	return err
+	collectionFactory.LocalRepoCollection().ForEach(func(repo *deb.LocalRepo) error {
+		localRepos++
+		if err := collectionFactory.LocalRepoCollection().LoadComplete(repo); err != nil {
+			problems = append(problems, fmt.Sprintf("local repo %s: %s", repo.Name, err))
+		}
+		return nil
+	})
+
+	collectionFactory.SnapshotCollection().ForEach(func(snapshot *deb.Snapshot) error {
+		snapshots++
+		if err := collectionFactory.SnapshotCollection().LoadComplete(snapshot); err != nil {
+			problems = append(problems, fmt.Sprintf("snapshot %s: %s", snapshot.Name, err))
+		}
+		return nil
+	})
+
+	collectionFactory.PublishedRepoCollection().ForEach(func(published *deb.PublishedRepo) error {
+		publishedRepos++
+		if err := collectionFactory.PublishedRepoCollection().LoadComplete(published, collectionFactory); err != nil {
+			problems = append(problems, fmt.Sprintf("published repo %s: %s", published, err))
+		}
+		return nil
+	})
+
+	context.Progress().Printf("Found %d mirrors, %d local repos, %d snapshots and %d published repos.\n",
+		mirrors, localRepos, snapshots, publishedRepos)
+
+	if len(problems) > 0 {
+		context.Progress().ColoredPrintf("@y[!]@| @!Some objects couldn't be loaded after recovery:@|")
+		for _, problem := range problems {
+			context.Progress().ColoredPrintf("  %s", problem)
+		}
+
+		return fmt.Errorf("database is not consistent after recovery")
+	}
+
+	return nil
 }
 
 func makeCmdDbRecover() *commander.Command {
@@ -27,13 +96,20 @@ func makeCmdDbRecover() *commander.Command {
 		Short:     "recover DB after crash",
 		Long: `
 Database recover does its' best to recover the database after a crash.
-It is recommended to backup the DB before running recover.
+Keys which could be salvaged are counted, and then all the mirrors, local
+repos, snapshots and published repositories are loaded to check that
+database is consistent.
+
+Recovery might lose data, so it is recommended to backup the DB before running
+recover. Recovery should be confirmed with flag -force.
 
 Example:
 
-  $ aptly db recover
+  $ aptly db recover -force
 `,
 	}
 
+	cmd.Flag.Bool("force", false, "confirm database recovery")
+
 	return cmd
 }
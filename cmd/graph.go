@@ -20,7 +20,7 @@ func aptlyGraph(cmd *commander.Command, args []string) error {
 	}
 
 	fmt.Printf("Generating graph...\n")
-	graph, err := deb.BuildGraph(context.CollectionFactory())
+	graph, err := deb.BuildGraph(context.CollectionFactory(), nil)
 	if err != nil {
 		return err
 	}
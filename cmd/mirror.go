@@ -16,7 +16,7 @@ func getVerifier(flags *flag.FlagSet) (utils.Verifier, error) {
 
 	verifier := &utils.GpgVerifier{}
 	for _, keyRing := range keyRings {
-		verifier.AddKeyring(keyRing)
+		verifier.AddKeyring(context.KeyringPath(keyRing))
 	}
 
 	err := verifier.InitKeyring()
@@ -57,6 +57,7 @@ func makeCmdMirror() *commander.Command {
 			makeCmdMirrorRename(),
 			makeCmdMirrorEdit(),
 			makeCmdMirrorSearch(),
+			makeCmdMirrorVerify(),
 		},
 	}
 }
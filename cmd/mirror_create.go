@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/smira/aptly/deb"
 	"github.com/smira/aptly/query"
+	"github.com/smira/aptly/utils"
 	"github.com/smira/commander"
 	"github.com/smira/flag"
 	"strings"
@@ -44,6 +45,16 @@ func aptlyMirrorCreate(cmd *commander.Command, args []string) error {
 	repo.FilterWithDeps = context.Flags().Lookup("filter-with-deps").Value.Get().(bool)
 	repo.SkipComponentCheck = context.Flags().Lookup("force-components").Value.Get().(bool)
 
+	maxTries := context.Flags().Lookup("max-tries").Value.Get().(int)
+	if maxTries > 0 {
+		repo.RetryPolicy = &utils.RetryPolicy{MaxAttempts: maxTries}
+	}
+
+	repo.Labels, err = deb.ParseLabels(context.Flags().Lookup("label").Value.String())
+	if err != nil {
+		return fmt.Errorf("unable to create mirror: %s", err)
+	}
+
 	if repo.Filter != "" {
 		_, err = query.Parse(repo.Filter)
 		if err != nil {
@@ -84,6 +95,8 @@ PPA urls could specified in short format:
 
   $ aptly mirror create <name> ppa:<user>/<project>
 
+Keyrings imported via API (POST /api/gpg/keys) could be referenced by name with -keyring flag.
+
 Example:
 
   $ aptly mirror create wheezy-main http://mirror.yandex.ru/debian/ wheezy main
@@ -97,6 +110,8 @@ Example:
 	cmd.Flag.String("filter", "", "filter packages in mirror")
 	cmd.Flag.Bool("filter-with-deps", false, "when filtering, include dependencies of matching packages as well")
 	cmd.Flag.Bool("force-components", false, "(only with component list) skip check that requested components are listed in Release file")
+	cmd.Flag.String("label", "", "set labels, comma-separated list of key=value")
+	cmd.Flag.Int("max-tries", 0, "number of attempts to download each file, default is taken from config")
 	cmd.Flag.Var(&keyRingsFlag{}, "keyring", "gpg keyring to use when verifying Release file (could be specified multiple times)")
 
 	return cmd
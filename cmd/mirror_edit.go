@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/smira/aptly/deb"
 	"github.com/smira/aptly/query"
+	"github.com/smira/aptly/utils"
 	"github.com/smira/commander"
 	"github.com/smira/flag"
 )
@@ -24,8 +26,22 @@ func aptlyMirrorEdit(cmd *commander.Command, args []string) error {
 		return fmt.Errorf("unable to edit: %s", err)
 	}
 
+	oldFilter, oldFilterWithDeps := repo.Filter, repo.FilterWithDeps
+
+	labels, err := deb.ParseLabels(context.Flags().Lookup("label").Value.String())
+	if err != nil {
+		return fmt.Errorf("unable to edit: %s", err)
+	}
+	repo.Labels = repo.Labels.Merge(labels)
+
 	context.Flags().Visit(func(flag *flag.Flag) {
 		switch flag.Name {
+		case "max-tries":
+			if maxTries := flag.Value.Get().(int); maxTries > 0 {
+				repo.RetryPolicy = &utils.RetryPolicy{MaxAttempts: maxTries}
+			} else {
+				repo.RetryPolicy = nil
+			}
 		case "filter":
 			repo.Filter = flag.Value.String()
 		case "filter-with-deps":
@@ -63,6 +79,12 @@ func aptlyMirrorEdit(cmd *commander.Command, args []string) error {
 	}
 
 	fmt.Printf("Mirror %s successfully updated.\n", repo)
+
+	if repo.Filter != oldFilter || repo.FilterWithDeps != oldFilterWithDeps {
+		// filter is applied to the whole upstream package list on each update,
+		// so packages (and dependencies) no longer matching would be dropped
+		fmt.Printf("Filter has been changed, run 'aptly mirror update %s' to re-filter mirror contents.\n", repo.Name)
+	}
 	return err
 }
 
@@ -73,7 +95,11 @@ func makeCmdMirrorEdit() *commander.Command {
 		Short:     "edit mirror settings",
 		Long: `
 Command edit allows one to change settings of mirror:
-filters, list of architectures.
+filters, list of architectures, labels.
+
+Changed filter is applied on next mirror update: packages which no longer
+match the filter (including dependencies pulled in only by such packages)
+are dropped from the mirror, and newly matching packages are downloaded.
 
 Example:
 
@@ -86,6 +112,8 @@ Example:
 	cmd.Flag.Bool("filter-with-deps", false, "when filtering, include dependencies of matching packages as well")
 	cmd.Flag.Bool("with-sources", false, "download source packages in addition to binary packages")
 	cmd.Flag.Bool("with-udebs", false, "download .udeb packages (Debian installer support)")
+	cmd.Flag.String("label", "", "set labels, comma-separated list of key=value (empty value removes label)")
+	cmd.Flag.Int("max-tries", 0, "number of attempts to download each file (0 resets to default from config)")
 
 	return cmd
 }
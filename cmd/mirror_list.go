@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/smira/aptly/deb"
 	"github.com/smira/commander"
-	"sort"
 )
 
 func aptlyMirrorList(cmd *commander.Command, args []string) error {
@@ -16,21 +15,32 @@ func aptlyMirrorList(cmd *commander.Command, args []string) error {
 
 	raw := cmd.Flag.Lookup("raw").Value.Get().(bool)
 
-	repos := make([]string, context.CollectionFactory().RemoteRepoCollection().Len())
-	i := 0
-	context.CollectionFactory().RemoteRepoCollection().ForEach(func(repo *deb.RemoteRepo) error {
+	labels, err := deb.ParseLabels(cmd.Flag.Lookup("label").Value.String())
+	if err != nil {
+		return fmt.Errorf("unable to list: %s", err)
+	}
+
+	sortMethodString := cmd.Flag.Lookup("sort").Value.Get().(string)
+
+	repos := make([]string, 0, context.CollectionFactory().RemoteRepoCollection().Len())
+	err = context.CollectionFactory().RemoteRepoCollection().ForEachSorted(sortMethodString, func(repo *deb.RemoteRepo) error {
+		if !repo.Labels.Matches(labels) {
+			return nil
+		}
+
 		if raw {
-			repos[i] = repo.Name
+			repos = append(repos, repo.Name)
 		} else {
-			repos[i] = repo.String()
+			repos = append(repos, repo.String())
 		}
-		i++
 		return nil
 	})
 
 	context.CloseDatabase()
 
-	sort.Strings(repos)
+	if err != nil {
+		return fmt.Errorf("unable to list: %s", err)
+	}
 
 	if raw {
 		for _, repo := range repos {
@@ -66,6 +76,8 @@ Example:
 	}
 
 	cmd.Flag.Bool("raw", false, "display list in machine-readable format")
+	cmd.Flag.String("label", "", "list only items with labels, comma-separated list of key=value")
+	cmd.Flag.String("sort", "name", "display list in 'name', creation time ('created') or modification time ('modified') order")
 
 	return cmd
 }
@@ -32,6 +32,8 @@ func aptlyMirrorShow(cmd *commander.Command, args []string) error {
 	if repo.Status == deb.MirrorUpdating {
 		fmt.Printf("Status: In Update (PID %d)\n", repo.WorkerPID)
 	}
+	fmt.Printf("Created At: %s\n", FormatTime(repo.CreatedAt))
+	fmt.Printf("Last Modified: %s\n", FormatTime(repo.ModifiedAt))
 	fmt.Printf("Archive Root URL: %s\n", repo.ArchiveRoot)
 	fmt.Printf("Distribution: %s\n", repo.Distribution)
 	fmt.Printf("Components: %s\n", strings.Join(repo.Components, ", "))
@@ -54,11 +56,20 @@ func aptlyMirrorShow(cmd *commander.Command, args []string) error {
 		}
 		fmt.Printf("Filter With Deps: %s\n", filterWithDeps)
 	}
+	if len(repo.Labels) > 0 {
+		fmt.Printf("Labels: %s\n", repo.Labels)
+	}
+	if repo.RetryPolicy != nil && repo.RetryPolicy.MaxAttempts > 0 {
+		fmt.Printf("Max Tries: %d\n", repo.RetryPolicy.MaxAttempts)
+	}
 	if repo.LastDownloadDate.IsZero() {
 		fmt.Printf("Last update: never\n")
 	} else {
 		fmt.Printf("Last update: %s\n", repo.LastDownloadDate.Format("2006-01-02 15:04:05 MST"))
 		fmt.Printf("Number of packages: %d\n", repo.NumPackages())
+		if repo.MissingPackages > 0 {
+			fmt.Printf("Missing packages: %d (last update was partial, run update again to retry)\n", repo.MissingPackages)
+		}
 	}
 
 	fmt.Printf("\nInformation from release file:\n")
@@ -67,11 +78,20 @@ func aptlyMirrorShow(cmd *commander.Command, args []string) error {
 	}
 
 	withPackages := context.Flags().Lookup("with-packages").Value.Get().(bool)
-	if withPackages {
+	packageQuery := context.Flags().Lookup("query").Value.String()
+	if withPackages || packageQuery != "" {
 		if repo.LastDownloadDate.IsZero() {
 			fmt.Printf("Unable to show package list, mirror hasn't been downloaded yet.\n")
 		} else {
-			ListPackagesRefList(repo.RefList())
+			reflist := repo.RefList()
+			if packageQuery != "" {
+				reflist, err = FilterRefList(reflist, packageQuery)
+				if err != nil {
+					return fmt.Errorf("unable to show: %s", err)
+				}
+			}
+
+			err = ListPackagesRefList(reflist)
 		}
 	}
 
@@ -86,14 +106,20 @@ func makeCmdMirrorShow() *commander.Command {
 		Long: `
 Shows detailed information about the mirror.
 
+With -with-packages, list of packages downloaded by last mirror update is
+printed, -query limits list to packages matching package query.
+
 Example:
 
   $ aptly mirror show wheezy-main
+
+  $ aptly mirror show -query='Priority (required)' wheezy-main
 `,
 		Flag: *flag.NewFlagSet("aptly-mirror-show", flag.ExitOnError),
 	}
 
 	cmd.Flag.Bool("with-packages", false, "show detailed list of packages and versions stored in the mirror")
+	cmd.Flag.String("query", "", "show only packages matching package query (implies -with-packages)")
 
 	return cmd
 }
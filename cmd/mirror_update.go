@@ -40,19 +40,34 @@ func aptlyMirrorUpdate(cmd *commander.Command, args []string) error {
 	}
 
 	ignoreMismatch := context.Flags().Lookup("ignore-checksums").Value.Get().(bool)
+	partial := context.Flags().Lookup("partial").Value.Get().(bool)
+	retryPolicy := context.Config().RetryPolicy.Override(repo.RetryPolicy)
+	if context.Flags().IsSet("max-tries") {
+		maxTries := context.Flags().Lookup("max-tries").Value.Get().(int)
+		if maxTries < 1 {
+			return fmt.Errorf("unable to update: -max-tries should be at least 1")
+		}
+		retryPolicy.MaxAttempts = maxTries
+	}
+	downloader := context.Downloader().WithRetryPolicy(retryPolicy)
+
+	batchSize := context.Flags().Lookup("batch-size").Value.Get().(int)
+	if batchSize < 0 {
+		return fmt.Errorf("unable to update: -batch-size should not be negative")
+	}
 
 	verifier, err := getVerifier(context.Flags())
 	if err != nil {
 		return fmt.Errorf("unable to initialize GPG verifier: %s", err)
 	}
 
-	err = repo.Fetch(context.Downloader(), verifier)
+	err = repo.Fetch(downloader, verifier)
 	if err != nil {
 		return fmt.Errorf("unable to update: %s", err)
 	}
 
 	context.Progress().Printf("Downloading & parsing package files...\n")
-	err = repo.DownloadPackageIndexes(context.Progress(), context.Downloader(), context.CollectionFactory(), ignoreMismatch)
+	err = repo.DownloadPackageIndexes(context.Progress(), downloader, context.CollectionFactory(), ignoreMismatch)
 	if err != nil {
 		return fmt.Errorf("unable to update: %s", err)
 	}
@@ -109,46 +124,91 @@ func aptlyMirrorUpdate(cmd *commander.Command, args []string) error {
 	sigch := make(chan os.Signal)
 	signal.Notify(sigch, os.Interrupt)
 
-	count := len(queue)
-	context.Progress().Printf("Download queue: %d items (%s)\n", count, utils.HumanBytes(downloadSize))
+	context.Progress().Printf("Download queue: %d items (%s)\n", len(queue), utils.HumanBytes(downloadSize))
+
+	if batchSize == 0 || batchSize > len(queue) {
+		batchSize = len(queue)
+	}
 
 	// Download from the queue
 	context.Progress().InitBar(downloadSize, true)
 
-	// Download all package files
-	ch := make(chan error, count)
+	// Download all package files, batch by batch
+	type downloadResult struct {
+		task int
+		err  error
+	}
+
+	download := func(task int, results chan<- downloadResult) {
+		ch := make(chan error, 1)
+		downloader.DownloadWithChecksum(repo.PackageURL(queue[task].RepoURI).String(), queue[task].DestinationPath,
+			ch, queue[task].Checksums, ignoreMismatch)
+		go func() {
+			results <- downloadResult{task: task, err: <-ch}
+		}()
+	}
 
-	// In separate goroutine (to avoid blocking main), push queue to downloader
-	go func() {
-		for _, task := range queue {
-			context.Downloader().DownloadWithChecksum(repo.PackageURL(task.RepoURI).String(), task.DestinationPath, ch, task.Checksums, ignoreMismatch)
+	errors := make([]string, 0)
+	failed := []deb.PackageDownloadTask{}
+
+	for start := 0; start < len(queue); start += batchSize {
+		end := start + batchSize
+		if end > len(queue) {
+			end = len(queue)
 		}
 
-		// We don't need queue after this point
-		queue = nil
-	}()
+		results := make(chan downloadResult, end-start)
 
-	// Wait for all downloads to finish
-	errors := make([]string, 0)
+		// In separate goroutine (to avoid blocking main), push batch to downloader
+		go func(start, end int) {
+			for i := start; i < end; i++ {
+				download(i, results)
+			}
+		}(start, end)
+
+		// Wait for all downloads of the batch to finish
+		for count := end - start; count > 0; {
+			select {
+			case <-sigch:
+				signal.Stop(sigch)
+				// abort downloads in progress, removing partially downloaded files
+				context.Cancel()
+				return fmt.Errorf("unable to update: interrupted")
+			case <-context.Context().Done():
+				signal.Stop(sigch)
+				return fmt.Errorf("unable to update: %s", context.Context().Err())
+			case result := <-results:
+				if result.err != nil {
+					errors = append(errors, result.err.Error())
+					failed = append(failed, queue[result.task])
+				}
+				count--
+			}
+		}
 
-	for count > 0 {
-		select {
-		case <-sigch:
-			signal.Stop(sigch)
-			return fmt.Errorf("unable to update: interrupted")
-		case err = <-ch:
+		if end < len(queue) {
+			// whole batch has landed, record checkpoint so that interrupted update could be resumed
+			err = checkpointMirrorUpdate(repo, append(append([]deb.PackageDownloadTask(nil), failed...), queue[end:]...))
 			if err != nil {
-				errors = append(errors, err.Error())
+				return fmt.Errorf("unable to update: %s", err)
 			}
-			count--
 		}
 	}
 
 	context.Progress().ShutdownBar()
 	signal.Stop(sigch)
 
+	missing := 0
 	if len(errors) > 0 {
-		return fmt.Errorf("unable to update: download errors:\n  %s\n", strings.Join(errors, "\n  "))
+		if !partial {
+			return fmt.Errorf("unable to update: download errors:\n  %s\n", strings.Join(errors, "\n  "))
+		}
+
+		missing = repo.ExcludeFailedDownloads(failed)
+		context.Progress().ColoredPrintf("@y[!]@| @!Some files failed to download, %d packages left out of the mirror:@|", missing)
+		for _, e := range errors {
+			context.Progress().ColoredPrintf("  %s", e)
+		}
 	}
 
 	err = context.ReOpenDatabase()
@@ -156,16 +216,42 @@ func aptlyMirrorUpdate(cmd *commander.Command, args []string) error {
 		return fmt.Errorf("unable to update: %s", err)
 	}
 
-	repo.FinalizeDownload()
+	if missing > 0 {
+		repo.FinalizePartialDownload(missing)
+	} else {
+		repo.FinalizeDownload()
+	}
 	err = context.CollectionFactory().RemoteRepoCollection().Update(repo)
 	if err != nil {
 		return fmt.Errorf("unable to update: %s", err)
 	}
 
+	err = context.CollectionFactory().RemoteRepoCollection().UpdateCheckpoint(repo)
+	if err != nil {
+		return fmt.Errorf("unable to update: %s", err)
+	}
+
 	context.Progress().Printf("\nMirror `%s` has been successfully updated.\n", repo.Name)
 	return err
 }
 
+// checkpointMirrorUpdate stores list of packages downloaded so far, pending are download
+// tasks which haven't finished successfully yet
+func checkpointMirrorUpdate(repo *deb.RemoteRepo, pending []deb.PackageDownloadTask) error {
+	err := context.ReOpenDatabase()
+	if err != nil {
+		return err
+	}
+
+	repo.Checkpoint(pending)
+	err = context.CollectionFactory().RemoteRepoCollection().UpdateCheckpoint(repo)
+	if err != nil {
+		return err
+	}
+
+	return context.CloseDatabase()
+}
+
 func makeCmdMirrorUpdate() *commander.Command {
 	cmd := &commander.Command{
 		Run:       aptlyMirrorUpdate,
@@ -176,6 +262,17 @@ Updates remote mirror (downloads package files and meta information). When mirro
 this command should be run for the first time to fetch mirror contents. This command can be
 run multiple times to get updated repository contents. If interrupted, command can be safely restarted.
 
+Failed downloads are retried according to retryPolicy from config, number of attempts
+could be overridden per mirror (aptly mirror edit -max-tries) or with -max-tries for single
+update. With -partial flag, update completes
+even if some files couldn't be downloaded: packages with missing files are left out of the mirror
+and would be downloaded on next update.
+
+With -batch-size, package files are downloaded in batches of specified number of
+files. After each batch is complete, update records checkpoint with packages downloaded
+so far, so that interrupted update resumes without verifying those packages again. Mirror
+contents are replaced only when update is complete.
+
 Example:
 
   $ aptly mirror update wheezy-main
@@ -187,6 +284,9 @@ Example:
 	cmd.Flag.Bool("ignore-checksums", false, "ignore checksum mismatches while downloading package files and metadata")
 	cmd.Flag.Bool("ignore-signatures", false, "disable verification of Release file signatures")
 	cmd.Flag.Int64("download-limit", 0, "limit download speed (kbytes/sec)")
+	cmd.Flag.Int("max-tries", 1, "number of attempts to download each file, default is taken from mirror settings or config")
+	cmd.Flag.Int("batch-size", 0, "download package files in batches of specified size, recording checkpoint after each batch")
+	cmd.Flag.Bool("partial", false, "complete update with packages downloaded successfully, leaving out failed ones")
 	cmd.Flag.Var(&keyRingsFlag{}, "keyring", "gpg keyring to use when verifying Release file (could be specified multiple times)")
 
 	return cmd
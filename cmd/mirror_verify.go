@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/smira/aptly/deb"
+	"github.com/smira/commander"
+	"github.com/smira/flag"
+)
+
+func aptlyMirrorVerify(cmd *commander.Command, args []string) error {
+	var err error
+	if len(args) != 1 {
+		cmd.Usage()
+		return commander.ErrCommandError
+	}
+
+	name := args[0]
+
+	repo, err := context.CollectionFactory().RemoteRepoCollection().ByName(name)
+	if err != nil {
+		return fmt.Errorf("unable to verify: %s", err)
+	}
+
+	err = context.CollectionFactory().RemoteRepoCollection().LoadComplete(repo)
+	if err != nil {
+		return fmt.Errorf("unable to verify: %s", err)
+	}
+
+	if repo.RefList() == nil {
+		return fmt.Errorf("unable to verify: mirror hasn't been downloaded yet")
+	}
+
+	quick := context.Flags().Lookup("quick").Value.Get().(bool)
+
+	context.Progress().Printf("Loading packages...\n")
+
+	packageList, err := deb.NewPackageListFromRefList(repo.RefList(), context.CollectionFactory().PackageCollection(), context.Progress())
+	if err != nil {
+		return fmt.Errorf("unable to load packages: %s", err)
+	}
+
+	context.Progress().Printf("Verifying package files...\n")
+	context.Progress().InitBar(int64(packageList.Len()), false)
+
+	var missing, mismatched []string
+	checked := 0
+
+	err = packageList.ForEach(func(p *deb.Package) error {
+		context.Progress().AddBar(1)
+
+		for _, f := range p.Files() {
+			result, err := f.VerifyChecksums(context.PackagePool(), quick)
+			if err != nil {
+				return err
+			}
+
+			checked++
+
+			switch result {
+			case deb.FileMissing:
+				missing = append(missing, fmt.Sprintf("%s: %s", p, f.Filename))
+			case deb.FileMismatch:
+				mismatched = append(mismatched, fmt.Sprintf("%s: %s", p, f.Filename))
+			}
+		}
+
+		return nil
+	})
+
+	context.Progress().ShutdownBar()
+
+	if err != nil {
+		return fmt.Errorf("unable to verify: %s", err)
+	}
+
+	for _, file := range missing {
+		context.Progress().ColoredPrintf("@y[!]@| @!Missing file:@| %s", file)
+	}
+	for _, file := range mismatched {
+		context.Progress().ColoredPrintf("@r[!]@| @!Checksum mismatch:@| %s", file)
+	}
+
+	if len(missing) > 0 || len(mismatched) > 0 {
+		return fmt.Errorf("verification failed: %d missing and %d mismatched files out of %d", len(missing), len(mismatched), checked)
+	}
+
+	context.Progress().Printf("\nAll %d files of mirror %s have been verified successfully.\n", checked, repo.Name)
+
+	return nil
+}
+
+func makeCmdMirrorVerify() *commander.Command {
+	cmd := &commander.Command{
+		Run:       aptlyMirrorVerify,
+		UsageLine: "verify <name>",
+		Short:     "verify mirror's package files in the pool",
+		Long: `
+Verify checks that every package file referenced by the mirror is present
+in the package pool and that its size and checksums match the ones from the
+upstream index recorded by last mirror update. Missing and mismatched files are
+reported.
+
+With -quick, only presence of package files is checked.
+
+Example:
+
+  $ aptly mirror verify wheezy-main
+`,
+		Flag: *flag.NewFlagSet("aptly-mirror-verify", flag.ExitOnError),
+	}
+
+	cmd.Flag.Bool("quick", false, "check only presence of package files, skipping checksum verification")
+
+	return cmd
+}
@@ -1,29 +1,58 @@
 package cmd
 
 import (
+	ctx "github.com/smira/aptly/context"
+	"github.com/smira/aptly/deb"
 	"github.com/smira/aptly/utils"
 	"github.com/smira/commander"
 	"github.com/smira/flag"
 )
 
+// skipSigning checks whether signing is disabled with -skip-signing or in config
+func skipSigning(flags *flag.FlagSet) bool {
+	return LookupOption(context.Config().GpgDisableSign, flags, "skip-signing")
+}
+
 func getSigner(flags *flag.FlagSet) (utils.Signer, error) {
-	if LookupOption(context.Config().GpgDisableSign, flags, "skip-signing") {
+	if skipSigning(flags) {
+		context.Progress().Printf("Warning: signing is skipped, apt would reject unsigned repository unless it is marked as trusted\n")
 		return nil, nil
 	}
 
-	signer := &utils.GpgSigner{}
-	signer.SetKey(flags.Lookup("gpg-key").Value.String())
-	signer.SetKeyRing(flags.Lookup("keyring").Value.String(), flags.Lookup("secret-keyring").Value.String())
-	signer.SetPassphrase(flags.Lookup("passphrase").Value.String(), flags.Lookup("passphrase-file").Value.String())
-	signer.SetBatch(flags.Lookup("batch").Value.Get().(bool))
+	return context.NewSigner(ctx.SignerOptions{
+		GpgKey:         flags.Lookup("gpg-key").Value.String(),
+		Keyring:        flags.Lookup("keyring").Value.String(),
+		SecretKeyring:  flags.Lookup("secret-keyring").Value.String(),
+		Passphrase:     flags.Lookup("passphrase").Value.String(),
+		PassphraseFile: flags.Lookup("passphrase-file").Value.String(),
+		Batch:          flags.Lookup("batch").Value.Get().(bool),
+	})
+}
 
-	err := signer.Init()
-	if err != nil {
-		return nil, err
+// applyReleaseOptions updates Release publishing options from flags, options
+// not set on command line keep their current values
+func applyReleaseOptions(published *deb.PublishedRepo, flags *flag.FlagSet) {
+	published.InReleaseOnly = LookupOption(published.InReleaseOnly, flags, "inrelease-only")
+	published.SkipRelease = LookupOption(published.SkipRelease, flags, "skip-release")
+	published.NotAutomatic = LookupOption(published.NotAutomatic, flags, "notautomatic")
+	published.ButAutomaticUpgrades = LookupOption(published.ButAutomaticUpgrades, flags, "butautomaticupgrades")
+	published.ChecksumsManifest = LookupOption(published.ChecksumsManifest, flags, "checksums-manifest")
+}
+
+// applyOverrides loads override file specified with -override flag
+func applyOverrides(published *deb.PublishedRepo, flags *flag.FlagSet) error {
+	filename := flags.Lookup("override").Value.String()
+	if filename == "" {
+		return nil
 	}
 
-	return signer, nil
+	overrides, err := deb.LoadOverrides(filename)
+	if err != nil {
+		return err
+	}
 
+	published.SetOverrides(overrides)
+	return nil
 }
 
 func makeCmdPublish() *commander.Command {
@@ -31,9 +60,12 @@ func makeCmdPublish() *commander.Command {
 		UsageLine: "publish",
 		Short:     "manage published repositories",
 		Subcommands: []*commander.Command{
+			makeCmdPublishCleanup(),
 			makeCmdPublishDrop(),
 			makeCmdPublishList(),
 			makeCmdPublishRepo(),
+			makeCmdPublishResign(),
+			makeCmdPublishShow(),
 			makeCmdPublishSnapshot(),
 			makeCmdPublishSwitch(),
 			makeCmdPublishUpdate(),
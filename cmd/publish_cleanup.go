@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/smira/aptly/deb"
+	"github.com/smira/commander"
+	"github.com/smira/flag"
+	"path/filepath"
+)
+
+func aptlyPublishCleanup(cmd *commander.Command, args []string) error {
+	var err error
+	if len(args) > 1 {
+		cmd.Usage()
+		return commander.ErrCommandError
+	}
+
+	param := "."
+	if len(args) == 1 {
+		param = args[0]
+	}
+
+	storage, prefix := deb.ParsePrefix(param)
+	publishedStorage := context.GetPublishedStorage(storage)
+
+	context.Progress().Printf("Looking for orphaned files...\n")
+
+	orphans, err := context.CollectionFactory().PublishedRepoCollection().OrphanedFiles(storage, prefix, publishedStorage,
+		context.CollectionFactory(), context.Progress())
+	if err != nil {
+		return fmt.Errorf("unable to cleanup: %s", err)
+	}
+
+	dryRun := context.Flags().Lookup("dry-run").Value.Get().(bool)
+
+	if len(orphans) == 0 {
+		context.Progress().Printf("No orphaned files found.\n")
+		return nil
+	}
+
+	if dryRun {
+		context.Progress().Printf("Orphaned files (%d) which would be removed:\n", len(orphans))
+	} else {
+		context.Progress().Printf("Removing orphaned files (%d)...\n", len(orphans))
+	}
+
+	for _, file := range orphans {
+		context.Progress().Printf("  %s\n", file)
+
+		if !dryRun {
+			err = publishedStorage.Remove(filepath.Join(prefix, file))
+			if err != nil {
+				return fmt.Errorf("unable to cleanup: %s", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func makeCmdPublishCleanup() *commander.Command {
+	cmd := &commander.Command{
+		Run:       aptlyPublishCleanup,
+		UsageLine: "cleanup [[<endpoint>:]<prefix>]",
+		Short:     "remove orphaned files from published storage",
+		Long: `
+Command cleanup looks for files under dists/ and pool/ of <prefix> in published storage
+which are not used by any published repository (e.g. left over by failed or changed
+publishes) and removes them. Metadata of published distributions and package files
+referenced by any published repository under the prefix are never removed.
+
+Use -dry-run to list files which would be removed.
+
+Example:
+
+    $ aptly publish cleanup -dry-run s3:repo:debian
+`,
+		Flag: *flag.NewFlagSet("aptly-publish-cleanup", flag.ExitOnError),
+	}
+
+	cmd.Flag.Bool("dry-run", false, "don't remove files, just show what would be removed")
+
+	return cmd
+}
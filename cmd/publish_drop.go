@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/smira/aptly/deb"
 	"github.com/smira/commander"
+	"github.com/smira/flag"
 )
 
 func aptlyPublishDrop(cmd *commander.Command, args []string) error {
@@ -22,8 +23,19 @@ func aptlyPublishDrop(cmd *commander.Command, args []string) error {
 
 	storage, prefix := deb.ParsePrefix(param)
 
+	keepPool := context.Flags().Lookup("keep-pool").Value.Get().(bool)
+	forceDrop := context.Flags().Lookup("force-drop").Value.Get().(bool)
+	if keepPool && forceDrop {
+		return fmt.Errorf("unable to remove: flags -keep-pool and -force-drop can't be used together")
+	}
+
+	if forceDrop {
+		context.Progress().ColoredPrintf("@rWARNING@|: force drop mode enabled, package files shared with other published " +
+			"repositories under the same prefix would be removed as well.")
+	}
+
 	err = context.CollectionFactory().PublishedRepoCollection().Remove(context, storage, prefix, distribution,
-		context.CollectionFactory(), context.Progress())
+		context.CollectionFactory(), context.Progress(), keepPool, forceDrop)
 	if err != nil {
 		return fmt.Errorf("unable to remove: %s", err)
 	}
@@ -42,11 +54,22 @@ func makeCmdPublishDrop() *commander.Command {
 Command removes whatever has been published under specified <prefix>,
 publishing <endpoint> and <distribution> name.
 
+If other distributions are published under the same prefix, package files
+in the pool which are still used by them are kept. With -keep-pool only
+distribution metadata (dists/<distribution>) is removed and the pool is left
+intact (e.g. to avoid re-uploading files when publishing again). With -force-drop
+pool components of the distribution are removed completely, even if other
+distributions under the same prefix are using them.
+
 Example:
 
     $ aptly publish drop wheezy
 `,
+		Flag: *flag.NewFlagSet("aptly-publish-drop", flag.ExitOnError),
 	}
 
+	cmd.Flag.Bool("keep-pool", false, "remove only distribution metadata, keeping files in the pool")
+	cmd.Flag.Bool("force-drop", false, "remove pool files even if they are used by other published repositories")
+
 	return cmd
 }
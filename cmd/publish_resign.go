@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/smira/aptly/deb"
+	"github.com/smira/commander"
+	"github.com/smira/flag"
+	"time"
+)
+
+func aptlyPublishResign(cmd *commander.Command, args []string) error {
+	var err error
+	if len(args) < 1 || len(args) > 2 {
+		cmd.Usage()
+		return commander.ErrCommandError
+	}
+
+	distribution := args[0]
+	param := "."
+
+	if len(args) == 2 {
+		param = args[1]
+	}
+	storage, prefix := deb.ParsePrefix(param)
+
+	published, err := context.CollectionFactory().PublishedRepoCollection().ByStoragePrefixDistribution(storage, prefix, distribution)
+	if err != nil {
+		return fmt.Errorf("unable to resign: %s", err)
+	}
+
+	signer, err := getSigner(context.Flags())
+	if err != nil {
+		return fmt.Errorf("unable to initialize GPG signer: %s", err)
+	}
+	if signer == nil {
+		return fmt.Errorf("unable to resign: signing is disabled")
+	}
+
+	published.SetTempDir(context.TempDir())
+
+	validFor := context.Flags().Lookup("valid-for").Value.Get().(time.Duration)
+	if validFor < 0 {
+		return fmt.Errorf("unable to resign: -valid-for should be positive")
+	}
+
+	err = published.Resign(context, signer, validFor, context.Progress())
+	if err != nil {
+		return fmt.Errorf("unable to resign: %s", err)
+	}
+
+	context.Progress().Printf("\nPublished repository %s has been successfully re-signed.\n", published.String())
+
+	return err
+}
+
+func makeCmdPublishResign() *commander.Command {
+	cmd := &commander.Command{
+		Run:       aptlyPublishResign,
+		UsageLine: "resign <distribution> [[<endpoint>:]<prefix>]",
+		Short:     "sign Release file of published repository again",
+		Long: `
+Command signs existing Release file of published repository again (e.g. after
+rotation of the signing key) and uploads new signatures: InRelease and Release.gpg.
+Package indexes are not regenerated.
+
+If Release file has Valid-Until field, Date and Valid-Until are renewed keeping
+the same validity period, flag -valid-for sets new validity period (and adds
+Valid-Until if it was missing). Release file is uploaded again in that case.
+
+Example:
+
+    $ aptly publish resign -gpg-key=8B48AD6246925553 wheezy ppa
+`,
+		Flag: *flag.NewFlagSet("aptly-publish-resign", flag.ExitOnError),
+	}
+	cmd.Flag.String("gpg-key", "", "GPG key ID to use when signing the release (overrides gpgKey from config)")
+	cmd.Flag.Var(&keyRingsFlag{}, "keyring", "GPG keyring to use (instead of default)")
+	cmd.Flag.String("secret-keyring", "", "GPG secret keyring to use (instead of default)")
+	cmd.Flag.String("passphrase", "", "GPG passhprase for the key (warning: could be insecure)")
+	cmd.Flag.String("passphrase-file", "", "GPG passhprase-file for the key (warning: could be insecure)")
+	cmd.Flag.Bool("batch", false, "run GPG with detached tty")
+	cmd.Flag.Duration("valid-for", 0, "set Valid-Until of Release file that far from now (e.g. 168h)")
+
+	return cmd
+}
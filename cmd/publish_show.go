@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/smira/aptly/deb"
+	"github.com/smira/commander"
+	"github.com/smira/flag"
+	"strings"
+)
+
+func aptlyPublishShow(cmd *commander.Command, args []string) error {
+	var err error
+	if len(args) < 1 || len(args) > 2 {
+		cmd.Usage()
+		return commander.ErrCommandError
+	}
+
+	distribution := args[0]
+	param := "."
+
+	if len(args) == 2 {
+		param = args[1]
+	}
+
+	storage, prefix := deb.ParsePrefix(param)
+
+	repo, err := context.CollectionFactory().PublishedRepoCollection().ByStoragePrefixDistribution(storage, prefix, distribution)
+	if err != nil {
+		return fmt.Errorf("unable to show: %s", err)
+	}
+
+	err = context.CollectionFactory().PublishedRepoCollection().LoadComplete(repo, context.CollectionFactory())
+	if err != nil {
+		return fmt.Errorf("unable to show: %s", err)
+	}
+
+	if repo.Storage != "" {
+		fmt.Printf("Storage: %s\n", repo.Storage)
+	}
+	fmt.Printf("Prefix: %s\n", repo.Prefix)
+	fmt.Printf("Distribution: %s\n", repo.Distribution)
+	fmt.Printf("Architectures: %s\n", strings.Join(repo.Architectures, " "))
+	if repo.Origin != "" {
+		fmt.Printf("Origin: %s\n", repo.Origin)
+	}
+	if repo.Label != "" {
+		fmt.Printf("Label: %s\n", repo.Label)
+	}
+
+	fmt.Printf("Sources:\n")
+	for _, component := range repo.Components() {
+		fmt.Printf("  %s: %s [%s]\n", component, repo.SourceName(component), repo.SourceKind)
+	}
+
+	withPackages := context.Flags().Lookup("with-packages").Value.Get().(bool)
+	packageQuery := context.Flags().Lookup("query").Value.String()
+	if withPackages || packageQuery != "" {
+		for _, component := range repo.Components() {
+			var reflist *deb.PackageRefList
+
+			reflist, err = repo.PublishedRefList(component, context.CollectionFactory().PackageCollection())
+			if err != nil {
+				return fmt.Errorf("unable to show: %s", err)
+			}
+
+			if packageQuery != "" {
+				reflist, err = FilterRefList(reflist, packageQuery)
+				if err != nil {
+					return fmt.Errorf("unable to show: %s", err)
+				}
+			}
+
+			fmt.Printf("\nComponent %s:\n", component)
+			err = ListPackagesRefList(reflist)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return err
+}
+
+func makeCmdPublishShow() *commander.Command {
+	cmd := &commander.Command{
+		Run:       aptlyPublishShow,
+		UsageLine: "show <distribution> [[<endpoint>:]<prefix>]",
+		Short:     "shows details of published repository",
+		Long: `
+Command show displays full information of a published repository.
+
+With -with-packages, packages published in each component are listed (only
+packages matching published architectures), -query limits list to packages
+matching package query.
+
+Example:
+
+    $ aptly publish show wheezy
+
+    $ aptly publish show -query='Priority (required)' wheezy ppa
+`,
+		Flag: *flag.NewFlagSet("aptly-publish-show", flag.ExitOnError),
+	}
+
+	cmd.Flag.Bool("with-packages", false, "show list of published packages")
+	cmd.Flag.String("query", "", "show only packages matching package query (implies -with-packages)")
+
+	return cmd
+}
@@ -118,6 +118,30 @@ func aptlyPublishSnapshotOrRepo(cmd *commander.Command, args []string) error {
 	}
 	published.Origin = cmd.Flag.Lookup("origin").Value.String()
 	published.Label = cmd.Flag.Lookup("label").Value.String()
+	applyReleaseOptions(published, context.Flags())
+
+	published.PinFriendly = context.Flags().Lookup("pin-friendly").Value.Get().(bool)
+	err = published.CheckPinning()
+	if err != nil {
+		return fmt.Errorf("unable to publish: %s", err)
+	}
+	err = published.CheckReleaseFlags()
+	if err != nil {
+		return fmt.Errorf("unable to publish: %s", err)
+	}
+
+	sourceOnly := cmd.Flag.Lookup("source-only-components").Value.String()
+	if sourceOnly != "" {
+		err = published.SetSourceOnlyComponents(strings.Split(sourceOnly, ","))
+		if err != nil {
+			return fmt.Errorf("unable to publish: %s", err)
+		}
+	}
+
+	err = published.SetFilenamePrefix(cmd.Flag.Lookup("filename-prefix").Value.String())
+	if err != nil {
+		return fmt.Errorf("unable to publish: %s", err)
+	}
 
 	duplicate := context.CollectionFactory().PublishedRepoCollection().CheckDuplicate(published)
 	if duplicate != nil {
@@ -129,6 +153,7 @@ func aptlyPublishSnapshotOrRepo(cmd *commander.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("unable to initialize GPG signer: %s", err)
 	}
+	published.SetSkipSigning(skipSigning(context.Flags()))
 
 	forceOverwrite := context.Flags().Lookup("force-overwrite").Value.Get().(bool)
 	if forceOverwrite {
@@ -136,6 +161,19 @@ func aptlyPublishSnapshotOrRepo(cmd *commander.Command, args []string) error {
 			"the same package pool.\n")
 	}
 
+	err = published.SetCompressionLevels(context.CompressionLevels())
+	if err != nil {
+		return fmt.Errorf("unable to publish: %s", err)
+	}
+	published.SetTempDir(context.TempDir())
+	published.SetContext(context.Context())
+	published.SetStrictDuplicates(context.Flags().Lookup("strict").Value.Get().(bool))
+	published.LatestOnly = LookupOption(published.LatestOnly, context.Flags(), "latest-only")
+	err = applyOverrides(published, context.Flags())
+	if err != nil {
+		return fmt.Errorf("unable to publish: %s", err)
+	}
+
 	err = published.Publish(context.PackagePool(), context, context.CollectionFactory(), signer, context.Progress(), forceOverwrite)
 	if err != nil {
 		return fmt.Errorf("unable to publish: %s", err)
@@ -162,7 +200,9 @@ func aptlyPublishSnapshotOrRepo(cmd *commander.Command, args []string) error {
 	}
 
 	context.Progress().Printf("Now you can add following line to apt sources:\n")
-	context.Progress().Printf("  deb http://your-server/%s %s %s\n", prefix, distribution, repoComponents)
+	if len(published.BinaryArchitectures()) > 0 {
+		context.Progress().Printf("  deb http://your-server/%s %s %s\n", prefix, distribution, repoComponents)
+	}
 	if utils.StrSliceHasItem(published.Architectures, "source") {
 		context.Progress().Printf("  deb-src http://your-server/%s %s %s\n", prefix, distribution, repoComponents)
 	}
@@ -188,6 +228,35 @@ as the arguments:
 
     aptly publish snapshot -component=main,contrib snap-main snap-contrib
 
+Architecture 'source' could be listed in -architectures along with binary architectures:
+with -architectures=amd64,source amd64 binaries and Sources indexes are published,
+while source packages are not published if 'source' is not listed. By default all
+the architectures of published packages (including source) are published.
+
+Components listed in -source-only-components flag are published with
+Sources indexes only, binary package indexes are not generated for them.
+
+With -inrelease-only, Release is signed only as clearsigned InRelease file,
+detached signature Release.gpg is not published. Unsigned Release is still
+published unless -skip-release is specified.
+
+Flags -notautomatic and -butautomaticupgrades set corresponding fields
+in Release file, so that apt pins repository like experimental or backports.
+
+With -filename-prefix, paths to package files in generated indexes are prefixed
+with the value (e.g. to serve pool from a different location), files are still
+uploaded to the pool of published repository. Prefix should be relative, as apt
+resolves paths relative to repository URL.
+
+With -pin-friendly, publishing fails unless -origin and -label are specified, so that
+Origin and Label fields of Release file, which clients pin repository on (along with
+Suite, set from distribution), are set explicitly instead of defaults derived from
+prefix. Pin-friendly mode is remembered and checked again by publish update and switch.
+
+With -checksums-manifest, manifest CHECKSUMS.sha256 (in sha256sum format) listing
+all the files published under prefix (indexes and pool) is uploaded to prefix
+root after publishing, manifest is kept up to date by publish update and switch.
+
 Example:
 
     $ aptly publish snapshot wheezy-main
@@ -196,16 +265,29 @@ Example:
 	}
 	cmd.Flag.String("distribution", "", "distribution name to publish")
 	cmd.Flag.String("component", "", "component name to publish (for multi-component publishing, separate components with commas)")
-	cmd.Flag.String("gpg-key", "", "GPG key ID to use when signing the release")
+	cmd.Flag.String("gpg-key", "", "GPG key ID to use when signing the release (overrides gpgKey from config)")
 	cmd.Flag.Var(&keyRingsFlag{}, "keyring", "GPG keyring to use (instead of default)")
 	cmd.Flag.String("secret-keyring", "", "GPG secret keyring to use (instead of default)")
 	cmd.Flag.String("passphrase", "", "GPG passhprase for the key (warning: could be insecure)")
 	cmd.Flag.String("passphrase-file", "", "GPG passhprase-file for the key (warning: could be insecure)")
 	cmd.Flag.Bool("batch", false, "run GPG with detached tty")
 	cmd.Flag.Bool("skip-signing", false, "don't sign Release files with GPG")
+	cmd.Flag.Bool("inrelease-only", false, "publish only clearsigned InRelease, without detached signature Release.gpg")
+	cmd.Flag.Bool("skip-release", false, "don't publish unsigned Release file (with -inrelease-only)")
+	cmd.Flag.Bool("checksums-manifest", false, "publish manifest CHECKSUMS.sha256 of all the published files at prefix root")
+	cmd.Flag.Bool("notautomatic", false, "set NotAutomatic: yes in Release file (packages are not installed automatically)")
+	cmd.Flag.Bool("butautomaticupgrades", false, "set ButAutomaticUpgrades: yes in Release file (with -notautomatic)")
 	cmd.Flag.String("origin", "", "origin name to publish")
 	cmd.Flag.String("label", "", "label to publish")
+	cmd.Flag.Bool("pin-friendly", false, "require -origin and -label to be set, so that clients could pin on Origin and Label")
 	cmd.Flag.Bool("force-overwrite", false, "overwrite files in package pool in case of mismatch")
+	cmd.Flag.Bool("strict", false, "fail if the same package (name, version and architecture) is published in several components")
+	cmd.Flag.String("override", "", "override file (package, priority, section) applied to published package indexes")
+	cmd.Flag.Bool("latest-only", false, "publish only the latest version of each package (by name and architecture)")
+	cmd.Flag.Int("gzip-level", 0, "gzip compression level for indexes (1-9), default is taken from config")
+	cmd.Flag.Int("bzip2-level", 0, "bzip2 compression level for indexes (1-9), default is taken from config")
+	cmd.Flag.String("source-only-components", "", "components to publish with Sources indexes only, separated by commas")
+	cmd.Flag.String("filename-prefix", "", "relative path prepended to package file paths in generated indexes")
 
 	return cmd
 }
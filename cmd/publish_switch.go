@@ -61,11 +61,18 @@ func aptlyPublishSwitch(cmd *commander.Command, args []string) error {
 		return fmt.Errorf("mismatch in number of components (%d) and snapshots (%d)", len(components), len(names))
 	}
 
-	for i, component := range components {
+	unknownComponents := []string{}
+	for _, component := range components {
 		if !utils.StrSliceHasItem(publishedComponents, component) {
-			return fmt.Errorf("unable to switch: component %s is not in published repository", component)
+			unknownComponents = append(unknownComponents, component)
 		}
+	}
+	if len(unknownComponents) > 0 {
+		return fmt.Errorf("unable to switch: components not in published repository: %s (published components: %s)",
+			strings.Join(unknownComponents, ", "), strings.Join(publishedComponents, ", "))
+	}
 
+	for i, component := range components {
 		snapshot, err = context.CollectionFactory().SnapshotCollection().ByName(names[i])
 		if err != nil {
 			return fmt.Errorf("unable to switch: %s", err)
@@ -83,6 +90,7 @@ func aptlyPublishSwitch(cmd *commander.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("unable to initialize GPG signer: %s", err)
 	}
+	published.SetSkipSigning(skipSigning(context.Flags()))
 
 	forceOverwrite := context.Flags().Lookup("force-overwrite").Value.Get().(bool)
 	if forceOverwrite {
@@ -90,7 +98,35 @@ func aptlyPublishSwitch(cmd *commander.Command, args []string) error {
 			"the same package pool.\n")
 	}
 
+	applyReleaseOptions(published, context.Flags())
+
+	err = published.SetCompressionLevels(context.CompressionLevels())
+	if err != nil {
+		return fmt.Errorf("unable to publish: %s", err)
+	}
+	published.SetTempDir(context.TempDir())
+	published.SetContext(context.Context())
+	published.SetStrictDuplicates(context.Flags().Lookup("strict").Value.Get().(bool))
+	published.SetArchitecturesCheck(context.Flags().Lookup("force").Value.Get().(bool))
+	published.LatestOnly = LookupOption(published.LatestOnly, context.Flags(), "latest-only")
+	published.PinFriendly = LookupOption(published.PinFriendly, context.Flags(), "pin-friendly")
+	err = published.CheckPinning()
+	if err != nil {
+		return fmt.Errorf("unable to publish: %s", err)
+	}
+	err = published.CheckReleaseFlags()
+	if err != nil {
+		return fmt.Errorf("unable to publish: %s", err)
+	}
+	err = applyOverrides(published, context.Flags())
+	if err != nil {
+		return fmt.Errorf("unable to publish: %s", err)
+	}
+
 	err = published.Publish(context.PackagePool(), context, context.CollectionFactory(), signer, context.Progress(), forceOverwrite)
+	if _, ok := err.(*deb.ArchitecturesMismatchError); ok {
+		return fmt.Errorf("unable to switch: %s (use -force to switch anyway)", err)
+	}
 	if err != nil {
 		return fmt.Errorf("unable to publish: %s", err)
 	}
@@ -127,21 +163,40 @@ same order, e.g.:
 
 	aptly publish update -component=main,contrib wheezy wh-main wh-contrib
 
+Architectures of packages in new snapshots (together with components which are
+not switched) are compared with published architectures: if some published
+architectures are left without packages, switch fails listing missing (and
+extra) architectures; flag -force switches anyway, publishing empty indexes for
+such architectures. Packages of architectures which are not published (e.g. when
+only some architectures of a mirror are published) are skipped with a warning.
+
 Example:
 
     $ aptly publish update wheezy ppa wheezy-7.5
 `,
 		Flag: *flag.NewFlagSet("aptly-publish-switch", flag.ExitOnError),
 	}
-	cmd.Flag.String("gpg-key", "", "GPG key ID to use when signing the release")
+	cmd.Flag.String("gpg-key", "", "GPG key ID to use when signing the release (overrides gpgKey from config)")
 	cmd.Flag.Var(&keyRingsFlag{}, "keyring", "GPG keyring to use (instead of default)")
 	cmd.Flag.String("secret-keyring", "", "GPG secret keyring to use (instead of default)")
 	cmd.Flag.String("passphrase", "", "GPG passhprase for the key (warning: could be insecure)")
 	cmd.Flag.String("passphrase-file", "", "GPG passhprase-file for the key (warning: could be insecure)")
 	cmd.Flag.Bool("batch", false, "run GPG with detached tty")
 	cmd.Flag.Bool("skip-signing", false, "don't sign Release files with GPG")
+	cmd.Flag.Bool("inrelease-only", false, "publish only clearsigned InRelease, without detached signature Release.gpg")
+	cmd.Flag.Bool("skip-release", false, "don't publish unsigned Release file (with -inrelease-only)")
+	cmd.Flag.Bool("checksums-manifest", false, "publish manifest CHECKSUMS.sha256 of all the published files at prefix root")
+	cmd.Flag.Bool("notautomatic", false, "set NotAutomatic: yes in Release file (packages are not installed automatically)")
+	cmd.Flag.Bool("butautomaticupgrades", false, "set ButAutomaticUpgrades: yes in Release file (with -notautomatic)")
 	cmd.Flag.String("component", "", "component names to update (for multi-component publishing, separate components with commas)")
+	cmd.Flag.Int("gzip-level", 0, "gzip compression level for indexes (1-9), default is taken from config")
+	cmd.Flag.Int("bzip2-level", 0, "bzip2 compression level for indexes (1-9), default is taken from config")
+	cmd.Flag.Bool("force", false, "switch even if published architectures are missing in new snapshots")
 	cmd.Flag.Bool("force-overwrite", false, "overwrite files in package pool in case of mismatch")
+	cmd.Flag.Bool("strict", false, "fail if the same package (name, version and architecture) is published in several components")
+	cmd.Flag.String("override", "", "override file (package, priority, section) applied to published package indexes")
+	cmd.Flag.Bool("latest-only", false, "publish only the latest version of each package (by name and architecture)")
+	cmd.Flag.Bool("pin-friendly", false, "require Origin and Label of published repository to be set, so that clients could pin on them")
 
 	return cmd
 }
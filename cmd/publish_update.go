@@ -47,6 +47,7 @@ func aptlyPublishUpdate(cmd *commander.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("unable to initialize GPG signer: %s", err)
 	}
+	published.SetSkipSigning(skipSigning(context.Flags()))
 
 	forceOverwrite := context.Flags().Lookup("force-overwrite").Value.Get().(bool)
 	if forceOverwrite {
@@ -54,6 +55,30 @@ func aptlyPublishUpdate(cmd *commander.Command, args []string) error {
 			"the same package pool.\n")
 	}
 
+	applyReleaseOptions(published, context.Flags())
+
+	err = published.SetCompressionLevels(context.CompressionLevels())
+	if err != nil {
+		return fmt.Errorf("unable to publish: %s", err)
+	}
+	published.SetTempDir(context.TempDir())
+	published.SetContext(context.Context())
+	published.SetStrictDuplicates(context.Flags().Lookup("strict").Value.Get().(bool))
+	published.LatestOnly = LookupOption(published.LatestOnly, context.Flags(), "latest-only")
+	published.PinFriendly = LookupOption(published.PinFriendly, context.Flags(), "pin-friendly")
+	err = published.CheckPinning()
+	if err != nil {
+		return fmt.Errorf("unable to publish: %s", err)
+	}
+	err = published.CheckReleaseFlags()
+	if err != nil {
+		return fmt.Errorf("unable to publish: %s", err)
+	}
+	err = applyOverrides(published, context.Flags())
+	if err != nil {
+		return fmt.Errorf("unable to publish: %s", err)
+	}
+
 	err = published.Publish(context.PackagePool(), context, context.CollectionFactory(), signer, context.Progress(), forceOverwrite)
 	if err != nil {
 		return fmt.Errorf("unable to publish: %s", err)
@@ -95,14 +120,25 @@ Example:
 `,
 		Flag: *flag.NewFlagSet("aptly-publish-update", flag.ExitOnError),
 	}
-	cmd.Flag.String("gpg-key", "", "GPG key ID to use when signing the release")
+	cmd.Flag.String("gpg-key", "", "GPG key ID to use when signing the release (overrides gpgKey from config)")
 	cmd.Flag.Var(&keyRingsFlag{}, "keyring", "GPG keyring to use (instead of default)")
 	cmd.Flag.String("secret-keyring", "", "GPG secret keyring to use (instead of default)")
 	cmd.Flag.String("passphrase", "", "GPG passhprase for the key (warning: could be insecure)")
 	cmd.Flag.String("passphrase-file", "", "GPG passhprase-file for the key (warning: could be insecure)")
 	cmd.Flag.Bool("batch", false, "run GPG with detached tty")
 	cmd.Flag.Bool("skip-signing", false, "don't sign Release files with GPG")
+	cmd.Flag.Int("gzip-level", 0, "gzip compression level for indexes (1-9), default is taken from config")
+	cmd.Flag.Int("bzip2-level", 0, "bzip2 compression level for indexes (1-9), default is taken from config")
+	cmd.Flag.Bool("inrelease-only", false, "publish only clearsigned InRelease, without detached signature Release.gpg")
+	cmd.Flag.Bool("skip-release", false, "don't publish unsigned Release file (with -inrelease-only)")
+	cmd.Flag.Bool("checksums-manifest", false, "publish manifest CHECKSUMS.sha256 of all the published files at prefix root")
+	cmd.Flag.Bool("notautomatic", false, "set NotAutomatic: yes in Release file (packages are not installed automatically)")
+	cmd.Flag.Bool("butautomaticupgrades", false, "set ButAutomaticUpgrades: yes in Release file (with -notautomatic)")
 	cmd.Flag.Bool("force-overwrite", false, "overwrite files in package pool in case of mismatch")
+	cmd.Flag.Bool("strict", false, "fail if the same package (name, version and architecture) is published in several components")
+	cmd.Flag.String("override", "", "override file (package, priority, section) applied to published package indexes")
+	cmd.Flag.Bool("latest-only", false, "publish only the latest version of each package (by name and architecture)")
+	cmd.Flag.Bool("pin-friendly", false, "require Origin and Label of published repository to be set, so that clients could pin on them")
 
 	return cmd
 }
@@ -15,12 +15,14 @@ func makeCmdRepo() *commander.Command {
 			makeCmdRepoDrop(),
 			makeCmdRepoEdit(),
 			makeCmdRepoImport(),
+			makeCmdRepoImportExisting(),
 			makeCmdRepoList(),
 			makeCmdRepoMove(),
 			makeCmdRepoRemove(),
 			makeCmdRepoShow(),
 			makeCmdRepoRename(),
 			makeCmdRepoSearch(),
+			makeCmdRepoSnapshotAndClear(),
 		},
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/smira/aptly/utils"
 	"github.com/smira/commander"
 	"github.com/smira/flag"
+	"io/ioutil"
 	"os"
 )
 
@@ -19,7 +20,19 @@ func aptlyRepoAdd(cmd *commander.Command, args []string) error {
 
 	name := args[0]
 
-	verifier := &utils.GpgVerifier{}
+	var verifier utils.Verifier
+	if context.Flags().Lookup("verify-dsc").Value.Get().(bool) {
+		gpgVerifier := &utils.GpgVerifier{}
+		for _, keyRing := range context.Flags().Lookup("keyring").Value.Get().([]string) {
+			gpgVerifier.AddKeyring(keyRing)
+		}
+
+		err = gpgVerifier.InitKeyring()
+		if err != nil {
+			return fmt.Errorf("unable to initialize GPG verifier: %s", err)
+		}
+		verifier = gpgVerifier
+	}
 
 	repo, err := context.CollectionFactory().LocalRepoCollection().ByName(name)
 	if err != nil {
@@ -31,6 +44,16 @@ func aptlyRepoAdd(cmd *commander.Command, args []string) error {
 		return fmt.Errorf("unable to add: %s", err)
 	}
 
+	keepVersions := repo.KeepVersions
+	if context.Flags().IsSet("keep-versions") {
+		keepVersions = context.Flags().Lookup("keep-versions").Value.Get().(int)
+
+		err = deb.ValidateKeepVersions(keepVersions)
+		if err != nil {
+			return fmt.Errorf("unable to add: %s", err)
+		}
+	}
+
 	context.Progress().Printf("Loading packages...\n")
 
 	list, err := deb.NewPackageListFromRefList(repo.RefList(), context.CollectionFactory().PackageCollection(), context.Progress())
@@ -38,24 +61,74 @@ func aptlyRepoAdd(cmd *commander.Command, args []string) error {
 		return fmt.Errorf("unable to load packages: %s", err)
 	}
 
-	forceReplace := context.Flags().Lookup("force-replace").Value.Get().(bool)
+	conflictPolicy := context.Flags().Lookup("conflict").Value.String()
+	if context.Flags().Lookup("force-replace").Value.Get().(bool) {
+		conflictPolicy = deb.ConflictReplace
+	}
+
+	var packageFiles, failedFiles, locations, urls []string
+
+	for _, location := range args[1:] {
+		if deb.IsPackageURL(location) {
+			urls = append(urls, location)
+		} else {
+			locations = append(locations, location)
+		}
+	}
 
-	var packageFiles, failedFiles []string
+	recursive := !context.Flags().Lookup("no-recursive").Value.Get().(bool)
 
-	packageFiles, failedFiles, err = deb.CollectPackageFiles(args[1:], &aptly.ConsoleResultReporter{context.Progress()})
+	packageFiles, failedFiles, err = deb.CollectPackageFiles(locations, recursive, &aptly.ConsoleResultReporter{context.Progress()})
 	if err != nil {
 		return fmt.Errorf("unable to collect package files: %s", err)
 	}
 
+	if len(urls) > 0 {
+		var tempDir string
+		tempDir, err = ioutil.TempDir(context.TempDir(), "aptly")
+		if err != nil {
+			return fmt.Errorf("unable to create temporary directory: %s", err)
+		}
+		defer os.RemoveAll(tempDir)
+
+		downloadedFiles, failedURLs := deb.DownloadPackageFiles(context.Downloader(), urls, tempDir, verifier,
+			&aptly.ConsoleResultReporter{context.Progress()})
+		packageFiles = append(packageFiles, downloadedFiles...)
+		failedFiles = append(failedFiles, failedURLs...)
+	}
+
 	var processedFiles, failedFiles2 []string
 
-	processedFiles, failedFiles2, err = deb.ImportPackageFiles(list, packageFiles, forceReplace, verifier, context.PackagePool(),
-		context.CollectionFactory().PackageCollection(), &aptly.ConsoleResultReporter{context.Progress()})
+	reporter := &countingResultReporter{ResultReporter: &aptly.ConsoleResultReporter{context.Progress()}}
+
+	debsigVerifier, err := getDebsigVerifier()
+	if err != nil {
+		return err
+	}
+
+	options := deb.ImportOptions{
+		ConflictPolicy:  conflictPolicy,
+		NoDowngrade:     context.Flags().Lookup("no-downgrade").Value.Get().(bool),
+		NormalizeFields: context.Flags().Lookup("normalize-fields").Value.Get().(bool),
+		Verifier:        verifier,
+		DebsigVerifier:  debsigVerifier,
+		Hook:            deb.NewPreAddHook(context.Config().PreAddHook),
+	}
+
+	processedFiles, failedFiles2, err = deb.ImportPackageFiles(list, packageFiles, options, context.PackagePool(),
+		context.CollectionFactory().PackageCollection(), reporter)
 	failedFiles = append(failedFiles, failedFiles2...)
 	if err != nil {
 		return fmt.Errorf("unable to import package files: %s", err)
 	}
 
+	for _, p := range list.RemoveOldVersions(keepVersions) {
+		reporter.Removed("%s removed, keeping %d latest versions", p, keepVersions)
+	}
+
+	context.Progress().Printf("Summary: %d added, %d skipped, %d failed\n", reporter.added,
+		len(packageFiles)-reporter.added-len(failedFiles2), len(failedFiles))
+
 	repo.UpdateRefList(deb.NewPackageRefListFromPackageList(list))
 
 	err = context.CollectionFactory().LocalRepoCollection().Update(repo)
@@ -63,7 +136,14 @@ func aptlyRepoAdd(cmd *commander.Command, args []string) error {
 		return fmt.Errorf("unable to save: %s", err)
 	}
 
-	if context.Flags().Lookup("remove-files").Value.Get().(bool) {
+	removeFiles := context.Flags().Lookup("remove-files").Value.Get().(bool)
+	if removeFiles && len(failedFiles) > 0 {
+		// files are removed only on clean add, so that failed import could be re-run
+		context.Progress().ColoredPrintf("@y[!]@| @!Files are not removed, as some of them failed to be added@|")
+		removeFiles = false
+	}
+
+	if removeFiles {
 		processedFiles = utils.StrSliceDeduplicate(processedFiles)
 
 		for _, file := range processedFiles {
@@ -86,27 +166,93 @@ func aptlyRepoAdd(cmd *commander.Command, args []string) error {
 	return err
 }
 
+// countingResultReporter counts added packages, passing all messages to underlying reporter
+type countingResultReporter struct {
+	aptly.ResultReporter
+	added int
+}
+
+func (r *countingResultReporter) Added(msg string, a ...interface{}) {
+	r.added++
+	r.ResultReporter.Added(msg, a...)
+}
+
+// getDebsigVerifier initializes verifier of package signatures, if configured
+func getDebsigVerifier() (*deb.DebsigVerifier, error) {
+	debsigVerifier := deb.NewDebsigVerifier(context.Config().Debsig)
+	if debsigVerifier == nil {
+		return nil, nil
+	}
+
+	err := debsigVerifier.Init()
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize debsig verifier: %s", err)
+	}
+
+	return debsigVerifier, nil
+}
+
 func makeCmdRepoAdd() *commander.Command {
 	cmd := &commander.Command{
 		Run:       aptlyRepoAdd,
-		UsageLine: "add <name> <package file.deb>|<directory> ...",
+		UsageLine: "add <name> <package file.deb>|<directory>|<url> ...",
 		Short:     "add packages to local repository",
 		Long: `
 Command adds packages to local repository from .deb, .udeb (binary packages) and .dsc (source packages) files.
 When importing from directory aptly would do recursive scan looking for all files matching *.[u]deb or *.dsc
-patterns. Every file discovered would be analyzed to extract metadata, package would then be created and added
+patterns (use -no-recursive to look only at files in the directory itself). Every file discovered would be analyzed to extract metadata, package would then be created and added
 to the database. Files would be imported to internal package pool. For source packages, all required files are
 added automatically as well. Extra files for source package should be in the same directory as *.dsc file.
 
+Package files could be also specified as http:// or https:// URLs, they are downloaded to temporary
+directory (following retry policy from configuration) and added as local files. For source packages,
+files referenced by .dsc are downloaded from the same location and verified against .dsc checksums.
+
+If debsig verification is configured (see "debsig" in configuration), signatures embedded into
+binary packages are verified with debsig-verify against policies, unsigned packages and packages
+with bad signatures are rejected.
+
+If pre-add hook is configured (see "preAddHook" in configuration), hook command is run
+for every file of the package (binary package, .dsc and source files) before it's added,
+packages with files rejected by the hook are reported as failed.
+
+If package with the same name, version and architecture is already present in the repository, aptly
+follows -conflict policy: fail (report an error if contents are different), skip (keep existing package)
+or replace (remove existing package and add the new one). Packages which are already in the repository
+with exactly the same contents are skipped, so interrupted add could be simply re-run.
+
+Adding package with version lower than version of the same package already in the repository
+is reported with warning, with -no-downgrade such packages are rejected.
+
+With -normalize-fields, packages without Section get Section: misc and Priority is
+checked against known priorities (required, important, standard, optional, extra);
+anomalies are reported as warnings. Packages already known to aptly (e.g. added to
+another repository) are not normalized, as their fields are shared.
+
+Clearsigned .dsc files are unwrapped before parsing, with -verify-dsc signatures
+are verified against trusted keyring (or keyrings specified with -keyring), unsigned
+or badly signed .dsc files are rejected.
+
+With -remove-files, imported files are removed after packages have been added to the
+repository. Files are kept if any of the files failed to be added, so that add could be
+re-run after fixing the problem.
+
 Example:
 
-  $ aptly repo add testing myapp-0.1.2.deb incoming/
+  $ aptly repo add testing myapp-0.1.2.deb incoming/ https://ci.example.com/artifacts/myapp_0.1.3_amd64.deb
 `,
 		Flag: *flag.NewFlagSet("aptly-repo-add", flag.ExitOnError),
 	}
 
-	cmd.Flag.Bool("remove-files", false, "remove files that have been imported successfully into repository")
-	cmd.Flag.Bool("force-replace", false, "when adding package that conflicts with existing package, remove existing package")
+	cmd.Flag.Int("keep-versions", 0, "keep only N latest versions of each package (by name and architecture), 0 keeps all, overrides repository setting")
+	cmd.Flag.Bool("remove-files", false, "remove files that have been imported successfully into repository (only if all files were added)")
+	cmd.Flag.Bool("no-recursive", false, "don't descend into subdirectories when adding packages from directory")
+	cmd.Flag.Bool("force-replace", false, "when adding package that conflicts with existing package, remove existing package (same as -conflict=replace)")
+	cmd.Flag.Bool("no-downgrade", false, "reject packages with version lower than version of the same package already in the repository")
+	cmd.Flag.Bool("normalize-fields", false, "fill missing Section and validate Priority of added packages")
+	cmd.Flag.Bool("verify-dsc", false, "verify signatures of source packages (.dsc files)")
+	cmd.Flag.Var(&keyRingsFlag{}, "keyring", "gpg keyring to use when verifying .dsc files (could be specified multiple times)")
+	cmd.Flag.String("conflict", deb.ConflictFail, "policy for packages which already exist in repository with different contents: fail, skip or replace")
 
 	return cmd
 }
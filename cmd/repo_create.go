@@ -17,6 +17,17 @@ func aptlyRepoCreate(cmd *commander.Command, args []string) error {
 	repo := deb.NewLocalRepo(args[0], context.Flags().Lookup("comment").Value.String())
 	repo.DefaultDistribution = context.Flags().Lookup("distribution").Value.String()
 	repo.DefaultComponent = context.Flags().Lookup("component").Value.String()
+	repo.KeepVersions = context.Flags().Lookup("keep-versions").Value.Get().(int)
+
+	err = deb.ValidateKeepVersions(repo.KeepVersions)
+	if err != nil {
+		return fmt.Errorf("unable to add local repo: %s", err)
+	}
+
+	repo.Labels, err = deb.ParseLabels(context.Flags().Lookup("label").Value.String())
+	if err != nil {
+		return fmt.Errorf("unable to add local repo: %s", err)
+	}
 
 	err = context.CollectionFactory().LocalRepoCollection().Add(repo)
 	if err != nil {
@@ -47,6 +58,8 @@ Example:
 	cmd.Flag.String("comment", "", "any text that would be used to described local repository")
 	cmd.Flag.String("distribution", "", "default distribution when publishing")
 	cmd.Flag.String("component", "main", "default component when publishing")
+	cmd.Flag.String("label", "", "set labels, comma-separated list of key=value")
+	cmd.Flag.Int("keep-versions", 0, "keep only N latest versions of each package (by name and architecture) when adding packages")
 
 	return cmd
 }
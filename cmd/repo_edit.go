@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/smira/aptly/deb"
 	"github.com/smira/commander"
 	"github.com/smira/flag"
 )
@@ -35,6 +36,21 @@ func aptlyRepoEdit(cmd *commander.Command, args []string) error {
 		repo.DefaultComponent = context.Flags().Lookup("component").Value.String()
 	}
 
+	if context.Flags().IsSet("keep-versions") {
+		repo.KeepVersions = context.Flags().Lookup("keep-versions").Value.Get().(int)
+
+		err = deb.ValidateKeepVersions(repo.KeepVersions)
+		if err != nil {
+			return fmt.Errorf("unable to edit: %s", err)
+		}
+	}
+
+	labels, err := deb.ParseLabels(context.Flags().Lookup("label").Value.String())
+	if err != nil {
+		return fmt.Errorf("unable to edit: %s", err)
+	}
+	repo.Labels = repo.Labels.Merge(labels)
+
 	err = context.CollectionFactory().LocalRepoCollection().Update(repo)
 	if err != nil {
 		return fmt.Errorf("unable to edit: %s", err)
@@ -63,6 +79,7 @@ Example:
 	cmd.Flag.String("comment", "", "any text that would be used to described local repository")
 	cmd.Flag.String("distribution", "", "default distribution when publishing")
 	cmd.Flag.String("component", "", "default component when publishing")
+	cmd.Flag.Int("keep-versions", 0, "keep only N latest versions of each package (by name and architecture) when adding packages, 0 keeps all")
 
 	return cmd
 }
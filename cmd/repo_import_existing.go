@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/smira/aptly/aptly"
+	"github.com/smira/aptly/deb"
+	"github.com/smira/commander"
+	"github.com/smira/flag"
+)
+
+func aptlyRepoImportExisting(cmd *commander.Command, args []string) error {
+	var err error
+	if len(args) != 2 {
+		cmd.Usage()
+		return commander.ErrCommandError
+	}
+
+	name, root := args[0], args[1]
+
+	copyFiles := context.Flags().Lookup("copy").Value.Get().(bool)
+
+	repo, err := context.CollectionFactory().LocalRepoCollection().ByName(name)
+	if err != nil {
+		return fmt.Errorf("unable to import: %s", err)
+	}
+
+	err = context.CollectionFactory().LocalRepoCollection().LoadComplete(repo)
+	if err != nil {
+		return fmt.Errorf("unable to import: %s", err)
+	}
+
+	context.Progress().Printf("Loading packages...\n")
+
+	list, err := deb.NewPackageListFromRefList(repo.RefList(), context.CollectionFactory().PackageCollection(), context.Progress())
+	if err != nil {
+		return fmt.Errorf("unable to load packages: %s", err)
+	}
+
+	debsigVerifier, err := getDebsigVerifier()
+	if err != nil {
+		return err
+	}
+
+	options := deb.ImportOptions{
+		ConflictPolicy: context.Flags().Lookup("conflict").Value.String(),
+		DebsigVerifier: debsigVerifier,
+		Hook:           deb.NewPreAddHook(context.Config().PreAddHook),
+	}
+
+	failedPackages, err := deb.ImportExistingRepository(list, root, options, !copyFiles, context.PackagePool(),
+		context.CollectionFactory().PackageCollection(), &aptly.ConsoleResultReporter{context.Progress()})
+	if err != nil {
+		return fmt.Errorf("unable to import: %s", err)
+	}
+
+	repo.UpdateRefList(deb.NewPackageRefListFromPackageList(list))
+
+	err = context.CollectionFactory().LocalRepoCollection().Update(repo)
+	if err != nil {
+		return fmt.Errorf("unable to save: %s", err)
+	}
+
+	if len(failedPackages) > 0 {
+		context.Progress().ColoredPrintf("@y[!]@| @!Some packages were skipped due to errors:@|")
+		for _, p := range failedPackages {
+			context.Progress().ColoredPrintf("  %s", p)
+		}
+
+		return fmt.Errorf("some packages failed to be imported")
+	}
+
+	return err
+}
+
+func makeCmdRepoImportExisting() *commander.Command {
+	cmd := &commander.Command{
+		Run:       aptlyRepoImportExisting,
+		UsageLine: "import-existing <name> <directory>",
+		Short:     "import packages from existing repository on disk into local repository",
+		Long: `
+Command import-existing adds packages of repository which is not managed by aptly
+(e.g. repository maintained by hand or with other tools) to local repository <name>.
+
+Packages are discovered from Packages and Sources indexes under <directory>/dists,
+files referenced by indexes are verified against checksums from indexes and imported
+into aptly package pool. By default files are hardlinked into the pool (if that's
+not possible, files are copied), use -copy to always copy files. If pre-add hook
+is configured (see "preAddHook" in configuration), it's run for every file before
+it's imported.
+
+If package with the same name, version and architecture is already present in the repository, aptly
+follows -conflict policy: fail (report an error if contents are different), skip (keep existing package)
+or replace (remove existing package and add the new one).
+
+Example:
+
+  $ aptly repo import-existing legacy /srv/apt
+`,
+		Flag: *flag.NewFlagSet("aptly-repo-import-existing", flag.ExitOnError),
+	}
+
+	cmd.Flag.Bool("copy", false, "copy files into package pool instead of hardlinking them")
+	cmd.Flag.String("conflict", deb.ConflictFail, "policy for packages which already exist in repository with different contents: fail, skip or replace")
+
+	return cmd
+}
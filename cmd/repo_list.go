@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/smira/aptly/deb"
 	"github.com/smira/commander"
-	"sort"
 )
 
 func aptlyRepoList(cmd *commander.Command, args []string) error {
@@ -16,26 +15,51 @@ func aptlyRepoList(cmd *commander.Command, args []string) error {
 
 	raw := cmd.Flag.Lookup("raw").Value.Get().(bool)
 
-	repos := make([]string, context.CollectionFactory().LocalRepoCollection().Len())
-	i := 0
-	context.CollectionFactory().LocalRepoCollection().ForEach(func(repo *deb.LocalRepo) error {
+	labels, err := deb.ParseLabels(cmd.Flag.Lookup("label").Value.String())
+	if err != nil {
+		return fmt.Errorf("unable to list: %s", err)
+	}
+
+	sortMethodString := cmd.Flag.Lookup("sort").Value.Get().(string)
+
+	onlyPublished, onlyUnpublished, err := publishedFilterFlags(cmd)
+	if err != nil {
+		return fmt.Errorf("unable to list: %s", err)
+	}
+
+	publishedCollection := context.CollectionFactory().PublishedRepoCollection()
+
+	repos := make([]string, 0, context.CollectionFactory().LocalRepoCollection().Len())
+	err = context.CollectionFactory().LocalRepoCollection().ForEachSorted(sortMethodString, func(repo *deb.LocalRepo) error {
+		if !repo.Labels.Matches(labels) {
+			return nil
+		}
+
+		if onlyPublished || onlyUnpublished {
+			published := len(publishedCollection.ByLocalRepo(repo)) > 0
+			if published != onlyPublished {
+				return nil
+			}
+		}
+
 		if raw {
-			repos[i] = repo.Name
+			repos = append(repos, repo.Name)
 		} else {
 			err := context.CollectionFactory().LocalRepoCollection().LoadComplete(repo)
 			if err != nil {
 				return err
 			}
 
-			repos[i] = fmt.Sprintf(" * %s (packages: %d)", repo.String(), repo.NumPackages())
+			repos = append(repos, fmt.Sprintf(" * %s (packages: %d)", repo.String(), repo.NumPackages()))
 		}
-		i++
 		return nil
 	})
 
 	context.CloseDatabase()
 
-	sort.Strings(repos)
+	if err != nil {
+		return fmt.Errorf("unable to list: %s", err)
+	}
 
 	if raw {
 		for _, repo := range repos {
@@ -63,7 +87,8 @@ func makeCmdRepoList() *commander.Command {
 		UsageLine: "list",
 		Short:     "list local repositories",
 		Long: `
-List command shows full list of local package repositories.
+List command shows full list of local package repositories. With -published (-unpublished)
+flag, only repositories which are (aren't) published directly are listed.
 
 Example:
 
@@ -72,6 +97,10 @@ Example:
 	}
 
 	cmd.Flag.Bool("raw", false, "display list in machine-readable format")
+	cmd.Flag.String("label", "", "list only items with labels, comma-separated list of key=value")
+	cmd.Flag.String("sort", "name", "display list in 'name', creation time ('created') or modification time ('modified') order")
+	cmd.Flag.Bool("published", false, "list only published repositories")
+	cmd.Flag.Bool("unpublished", false, "list only repositories which aren't published")
 
 	return cmd
 }
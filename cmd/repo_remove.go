@@ -54,6 +54,30 @@ func aptlyRepoRemove(cmd *commander.Command, args []string) error {
 		return nil
 	})
 
+	if context.Flags().Lookup("check-deps").Value.Get().(bool) {
+		var architecturesList []string
+
+		if len(context.ArchitecturesList()) > 0 {
+			architecturesList = context.ArchitecturesList()
+		} else {
+			architecturesList = list.Architectures(false)
+		}
+
+		dependents, err := list.FindDependents(toRemove, context.DependencyOptions(), architecturesList)
+		if err != nil {
+			return fmt.Errorf("unable to remove: %s", err)
+		}
+
+		if len(dependents) > 0 {
+			context.Progress().ColoredPrintf("@y[!]@| @!Packages left in the repository depend on packages being removed:@|")
+			for _, p := range dependents {
+				context.Progress().ColoredPrintf("  %s", p)
+			}
+
+			return fmt.Errorf("unable to remove: %d package(s) depend on packages being removed", len(dependents))
+		}
+	}
+
 	if context.Flags().Lookup("dry-run").Value.Get().(bool) {
 		context.Progress().Printf("\nChanges not saved, as dry run has been requested.\n")
 	} else {
@@ -79,6 +103,9 @@ Commands removes packages matching <package-query> from local repository
 snapshots, they can be removed completely (including files) by running
 'aptly db cleanup'.
 
+With -check-deps, aptly refuses to remove packages which are still required
+by other packages left in the repository and lists such dependents.
+
 Example:
 
   $ aptly repo remove testing 'myapp (=0.1.12)'
@@ -87,6 +114,7 @@ Example:
 	}
 
 	cmd.Flag.Bool("dry-run", false, "don't remove, just show what would be removed")
+	cmd.Flag.Bool("check-deps", false, "refuse to remove packages which are dependencies of packages left in the repository")
 
 	return cmd
 }
@@ -27,8 +27,16 @@ func aptlyRepoShow(cmd *commander.Command, args []string) error {
 
 	fmt.Printf("Name: %s\n", repo.Name)
 	fmt.Printf("Comment: %s\n", repo.Comment)
+	fmt.Printf("Created At: %s\n", FormatTime(repo.CreatedAt))
+	fmt.Printf("Last Modified: %s\n", FormatTime(repo.ModifiedAt))
 	fmt.Printf("Default Distribution: %s\n", repo.DefaultDistribution)
 	fmt.Printf("Default Component: %s\n", repo.DefaultComponent)
+	if len(repo.Labels) > 0 {
+		fmt.Printf("Labels: %s\n", repo.Labels)
+	}
+	if repo.KeepVersions > 0 {
+		fmt.Printf("Keep Versions: %d\n", repo.KeepVersions)
+	}
 	fmt.Printf("Number of packages: %d\n", repo.NumPackages())
 
 	withPackages := context.Flags().Lookup("with-packages").Value.Get().(bool)
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/smira/aptly/deb"
+	"github.com/smira/commander"
+)
+
+func aptlyRepoSnapshotAndClear(cmd *commander.Command, args []string) error {
+	var err error
+	if len(args) != 2 {
+		cmd.Usage()
+		return commander.ErrCommandError
+	}
+
+	localRepoName, snapshotName := args[0], args[1]
+
+	repo, err := context.CollectionFactory().LocalRepoCollection().ByName(localRepoName)
+	if err != nil {
+		return fmt.Errorf("unable to snapshot and clear: %s", err)
+	}
+
+	err = context.CollectionFactory().LocalRepoCollection().LoadComplete(repo)
+	if err != nil {
+		return fmt.Errorf("unable to snapshot and clear: %s", err)
+	}
+
+	snapshot, err := deb.NewSnapshotFromLocalRepo(snapshotName, repo)
+	if err != nil {
+		return fmt.Errorf("unable to snapshot and clear: %s", err)
+	}
+
+	// both snapshot and emptied repo are written in single batch, so either
+	// both changes are saved or none of them
+	err = context.CollectionFactory().SnapshotAndClearLocalRepo(snapshot, repo)
+	if err != nil {
+		return fmt.Errorf("unable to add snapshot: %s", err)
+	}
+
+	fmt.Printf("\nSnapshot %s successfully created, local repo %s is now empty.\nYou can run 'aptly publish snapshot %s' to publish snapshot as Debian repository.\n",
+		snapshot.Name, repo.Name, snapshot.Name)
+
+	return err
+}
+
+func makeCmdRepoSnapshotAndClear() *commander.Command {
+	cmd := &commander.Command{
+		Run:       aptlyRepoSnapshotAndClear,
+		UsageLine: "snapshot-and-clear <name> <snapshot>",
+		Short:     "create snapshot of local repository and remove all packages from it",
+		Long: `
+Command snapshot-and-clear creates snapshot <snapshot> of local repository <name>
+and removes all packages from the repository. Both changes are saved together,
+so packages are never lost in between. If snapshot can't be created, local repository
+is left untouched.
+
+Example:
+
+  $ aptly repo snapshot-and-clear staging staging-2014-10-01
+`,
+	}
+
+	return cmd
+}
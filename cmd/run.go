@@ -14,7 +14,9 @@ func Run(cmd *commander.Command, cmdArgs []string, initContext bool) (returnCode
 			if !ok {
 				panic(r)
 			}
-			fmt.Println("ERROR:", fatal.Message)
+			if fatal.Message != "" {
+				fmt.Println("ERROR:", fatal.Message)
+			}
 			returnCode = fatal.ReturnCode
 		}
 	}()
@@ -38,6 +40,9 @@ func Run(cmd *commander.Command, cmdArgs []string, initContext bool) (returnCode
 
 	err = cmd.Dispatch(args)
 	if err != nil {
+		if context.TimedOut() {
+			ctx.FatalTimeout(fmt.Errorf("%s (timeout exceeded)", err))
+		}
 		ctx.Fatal(err)
 	}
 
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/smira/aptly/aptly"
 	"github.com/smira/aptly/deb"
+	aptlyhttp "github.com/smira/aptly/http"
 	"github.com/smira/aptly/utils"
 	"github.com/smira/commander"
 	"github.com/smira/flag"
@@ -42,12 +43,28 @@ func aptlyServe(cmd *commander.Command, args []string) error {
 		}
 	}
 
+	storage := context.Flags().Lookup("storage").Value.String()
+
+	var handler http.Handler
+	switch publishedStorage := context.GetPublishedStorage(storage).(type) {
+	case aptly.LocalPublishedStorage:
+		handler = aptlyhttp.NewPublicHandler(publishedStorage.PublicPath())
+	case aptly.ReadablePublishedStorage:
+		handler = aptlyhttp.NewPublishedStorageHandler(publishedStorage)
+	default:
+		return fmt.Errorf("unable to serve: serving from %s is not supported", storage)
+	}
+
 	fmt.Printf("Serving published repositories, recommended apt sources list:\n\n")
 
 	sources := make(sort.StringSlice, 0, context.CollectionFactory().PublishedRepoCollection().Len())
 	published := make(map[string]*deb.PublishedRepo, context.CollectionFactory().PublishedRepoCollection().Len())
 
 	err = context.CollectionFactory().PublishedRepoCollection().ForEach(func(repo *deb.PublishedRepo) error {
+		if repo.Storage != storage {
+			return nil
+		}
+
 		err := context.CollectionFactory().PublishedRepoCollection().LoadComplete(repo, context.CollectionFactory())
 		if err != nil {
 			return err
@@ -75,8 +92,12 @@ func aptlyServe(cmd *commander.Command, args []string) error {
 			prefix += "/"
 		}
 
-		fmt.Printf("# %s\ndeb http://%s:%s/%s %s %s\n",
-			repo, listenHost, listenPort, prefix, repo.Distribution, strings.Join(repo.Components(), " "))
+		fmt.Printf("# %s\n", repo)
+
+		if len(repo.BinaryArchitectures()) > 0 {
+			fmt.Printf("deb http://%s:%s/%s %s %s\n",
+				listenHost, listenPort, prefix, repo.Distribution, strings.Join(repo.Components(), " "))
+		}
 
 		if utils.StrSliceHasItem(repo.Architectures, "source") {
 			fmt.Printf("deb-src http://%s:%s/%s %s %s\n",
@@ -84,12 +105,11 @@ func aptlyServe(cmd *commander.Command, args []string) error {
 		}
 	}
 
-	publicPath := context.GetPublishedStorage("").(aptly.LocalPublishedStorage).PublicPath()
 	ShutdownContext()
 
 	fmt.Printf("\nStarting web server at: %s (press Ctrl+C to quit)...\n", listen)
 
-	err = http.ListenAndServe(listen, http.FileServer(http.Dir(publicPath)))
+	err = http.ListenAndServe(listen, handler)
 	if err != nil {
 		return fmt.Errorf("unable to serve: %s", err)
 	}
@@ -105,6 +125,12 @@ func makeCmdServe() *commander.Command {
 Command serve starts embedded HTTP server (not suitable for real production usage) to serve
 contents of public/ subdirectory of aptly's root that contains published repositories.
 
+Responses carry ETag & Last-Modified headers, so conditional requests from apt
+are answered with 304 Not Modified when files haven't changed.
+
+With -storage flag, repositories published to that endpoint (e.g. s3:repo)
+are served instead: files are streamed from the object storage on demand.
+
 Example:
 
   $ aptly serve -listen=:8080
@@ -113,6 +139,7 @@ Example:
 	}
 
 	cmd.Flag.String("listen", ":8080", "host:port for HTTP listening")
+	cmd.Flag.String("storage", "", "published storage endpoint to serve (default is local public directory)")
 
 	return cmd
 }
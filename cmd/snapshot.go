@@ -17,9 +17,11 @@ func makeCmdSnapshot() *commander.Command {
 			makeCmdSnapshotDiff(),
 			makeCmdSnapshotMerge(),
 			makeCmdSnapshotDrop(),
+			makeCmdSnapshotEdit(),
 			makeCmdSnapshotRename(),
 			makeCmdSnapshotSearch(),
 			makeCmdSnapshotFilter(),
+			makeCmdSnapshotExport(),
 		},
 	}
 }
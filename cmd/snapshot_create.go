@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/smira/aptly/deb"
 	"github.com/smira/commander"
+	"github.com/smira/flag"
 )
 
 func aptlySnapshotCreate(cmd *commander.Command, args []string) error {
@@ -69,6 +70,15 @@ func aptlySnapshotCreate(cmd *commander.Command, args []string) error {
 		return commander.ErrCommandError
 	}
 
+	if context.Flags().Lookup("latest-only").Value.Get().(bool) {
+		snapshot.FilterLatestRefs()
+	}
+
+	snapshot.Labels, err = deb.ParseLabels(context.Flags().Lookup("label").Value.String())
+	if err != nil {
+		return fmt.Errorf("unable to create snapshot: %s", err)
+	}
+
 	err = context.CollectionFactory().SnapshotCollection().Add(snapshot)
 	if err != nil {
 		return fmt.Errorf("unable to add snapshot: %s", err)
@@ -97,12 +107,19 @@ Command create <name> empty creates empty snapshot that could be used as a
 basis for snapshot pull operations, for example. As snapshots are immutable,
 creating one empty snapshot should be enough.
 
+With -latest-only, snapshot contains only the latest version of each package
+(by name and architecture) from the mirror or local repository.
+
 Example:
 
   $ aptly snapshot create wheezy-main-today from mirror wheezy-main
 `,
+		Flag: *flag.NewFlagSet("aptly-snapshot-create", flag.ExitOnError),
 	}
 
+	cmd.Flag.String("label", "", "set labels, comma-separated list of key=value")
+	cmd.Flag.Bool("latest-only", false, "include only the latest version of each package (by name and architecture)")
+
 	return cmd
 
 }
@@ -21,6 +21,7 @@ func aptlySnapshotDrop(cmd *commander.Command, args []string) error {
 	}
 
 	published := context.CollectionFactory().PublishedRepoCollection().BySnapshot(snapshot)
+	dropPublications := context.Flags().Lookup("force-drop-publications").Value.Get().(bool)
 
 	if len(published) > 0 {
 		fmt.Printf("Snapshot `%s` is published currently:\n", snapshot.Name)
@@ -32,10 +33,16 @@ func aptlySnapshotDrop(cmd *commander.Command, args []string) error {
 			fmt.Printf(" * %s\n", repo)
 		}
 
-		return fmt.Errorf("unable to drop: snapshot is published")
+		if !dropPublications {
+			return fmt.Errorf("unable to drop: snapshot is published, drop publications first or use -force-drop-publications")
+		}
 	}
 
 	force := context.Flags().Lookup("force").Value.Get().(bool)
+	if snapshot.Pinned && !force {
+		return fmt.Errorf("won't delete pinned snapshot, unpin it with 'aptly snapshot edit -unpin' or use -force to override")
+	}
+
 	if !force {
 		snapshots := context.CollectionFactory().SnapshotCollection().BySnapshotSource(snapshot)
 		if len(snapshots) > 0 {
@@ -48,6 +55,18 @@ func aptlySnapshotDrop(cmd *commander.Command, args []string) error {
 		}
 	}
 
+	for _, repo := range published {
+		// files in the pool still used by other published repositories are kept
+		err = context.CollectionFactory().PublishedRepoCollection().Remove(context, repo.Storage, repo.Prefix,
+			repo.Distribution, context.CollectionFactory(), context.Progress(), false, false)
+		if err != nil {
+			return fmt.Errorf("unable to drop publication %s/%s: %s", repo.StoragePrefix(), repo.Distribution, err)
+		}
+
+		context.Progress().Printf("Published repository %s/%s has been removed.\n", repo.StoragePrefix(), repo.Distribution)
+	}
+	context.Progress().Flush()
+
 	err = context.CollectionFactory().SnapshotCollection().Drop(snapshot)
 	if err != nil {
 		return fmt.Errorf("unable to drop: %s", err)
@@ -65,7 +84,9 @@ func makeCmdSnapshotDrop() *commander.Command {
 		Short:     "delete snapshot",
 		Long: `
 Drop removes information about a snapshot. If snapshot is published,
-it can't be dropped.
+it can't be dropped unless -force-drop-publications is specified: in that case
+all publications of the snapshot are dropped first. Pinned snapshots (see 'aptly snapshot edit') and snapshots
+used as source for other snapshots are dropped only with -force.
 
 Example:
 
@@ -74,7 +95,8 @@ Example:
 		Flag: *flag.NewFlagSet("aptly-snapshot-drop", flag.ExitOnError),
 	}
 
-	cmd.Flag.Bool("force", false, "remove snapshot even if it was used as source for other snapshots")
+	cmd.Flag.Bool("force-drop-publications", false, "drop publications of the snapshot before dropping the snapshot")
+	cmd.Flag.Bool("force", false, "remove snapshot even if it is pinned or was used as source for other snapshots")
 
 	return cmd
 }
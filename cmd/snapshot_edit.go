@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/smira/aptly/deb"
+	"github.com/smira/commander"
+	"github.com/smira/flag"
+)
+
+func aptlySnapshotEdit(cmd *commander.Command, args []string) error {
+	var err error
+	if len(args) != 1 {
+		cmd.Usage()
+		return commander.ErrCommandError
+	}
+
+	pin := context.Flags().Lookup("pin").Value.Get().(bool)
+	unpin := context.Flags().Lookup("unpin").Value.Get().(bool)
+	if pin && unpin {
+		return fmt.Errorf("unable to edit: flags -pin and -unpin are mutually exclusive")
+	}
+
+	snapshot, err := context.CollectionFactory().SnapshotCollection().ByName(args[0])
+	if err != nil {
+		return fmt.Errorf("unable to edit: %s", err)
+	}
+
+	if context.Flags().Lookup("description").Value.String() != "" {
+		snapshot.Description = context.Flags().Lookup("description").Value.String()
+	}
+
+	labels, err := deb.ParseLabels(context.Flags().Lookup("label").Value.String())
+	if err != nil {
+		return fmt.Errorf("unable to edit: %s", err)
+	}
+	snapshot.Labels = snapshot.Labels.Merge(labels)
+
+	if pin {
+		snapshot.Pinned = true
+	} else if unpin {
+		snapshot.Pinned = false
+	}
+
+	err = context.CollectionFactory().SnapshotCollection().Update(snapshot)
+	if err != nil {
+		return fmt.Errorf("unable to edit: %s", err)
+	}
+
+	fmt.Printf("Snapshot %s successfully updated.\n", snapshot.Name)
+	return err
+}
+
+func makeCmdSnapshotEdit() *commander.Command {
+	cmd := &commander.Command{
+		Run:       aptlySnapshotEdit,
+		UsageLine: "edit <name>",
+		Short:     "edit properties of snapshot",
+		Long: `
+Command edit allows one to change metadata of snapshot: description, labels
+and pinned flag. Pinned snapshot can't be dropped unless -force is used.
+
+Example:
+
+  $ aptly snapshot edit -pin wheezy-main
+`,
+		Flag: *flag.NewFlagSet("aptly-snapshot-edit", flag.ExitOnError),
+	}
+
+	cmd.Flag.String("description", "", "set description for snapshot")
+	cmd.Flag.Bool("pin", false, "pin snapshot, protecting it from being dropped")
+	cmd.Flag.Bool("unpin", false, "unpin snapshot")
+	cmd.Flag.String("label", "", "set labels, comma-separated list of key=value (empty value removes label)")
+
+	return cmd
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/smira/aptly/aptly"
+	"github.com/smira/aptly/deb"
+	"github.com/smira/aptly/files"
+	"github.com/smira/commander"
+	"github.com/smira/flag"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// exportStorageProvider provides the same published storage regardless of name
+type exportStorageProvider struct {
+	storage aptly.PublishedStorage
+}
+
+func (provider *exportStorageProvider) GetPublishedStorage(name string) aptly.PublishedStorage {
+	return provider.storage
+}
+
+func aptlySnapshotExport(cmd *commander.Command, args []string) error {
+	var err error
+	if len(args) != 2 {
+		cmd.Usage()
+		return commander.ErrCommandError
+	}
+
+	name, dir := args[0], args[1]
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to export: %s", err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("unable to export: directory %s is not empty", dir)
+	}
+
+	snapshot, err := context.CollectionFactory().SnapshotCollection().ByName(name)
+	if err != nil {
+		return fmt.Errorf("unable to export: %s", err)
+	}
+
+	err = context.CollectionFactory().SnapshotCollection().LoadComplete(snapshot)
+	if err != nil {
+		return fmt.Errorf("unable to export: %s", err)
+	}
+
+	component := context.Flags().Lookup("component").Value.String()
+	distribution := context.Flags().Lookup("distribution").Value.String()
+
+	exported, err := deb.NewPublishedRepo("", ".", distribution, context.ArchitecturesList(), []string{component},
+		[]interface{}{snapshot}, context.CollectionFactory())
+	if err != nil {
+		return fmt.Errorf("unable to export: %s", err)
+	}
+	exported.Origin = context.Flags().Lookup("origin").Value.String()
+	exported.Label = context.Flags().Lookup("label").Value.String()
+
+	compression := context.Flags().Lookup("compression").Value.String()
+	if compression == "none" {
+		err = exported.SetCompressions([]string{})
+	} else {
+		err = exported.SetCompressions(strings.Split(compression, ","))
+	}
+	if err != nil {
+		return fmt.Errorf("unable to export: %s", err)
+	}
+
+	signer, err := getSigner(context.Flags())
+	if err != nil {
+		return fmt.Errorf("unable to initialize GPG signer: %s", err)
+	}
+	exported.SetSkipSigning(skipSigning(context.Flags()))
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("unable to export: %s", err)
+	}
+
+	err = exported.SetCompressionLevels(context.CompressionLevels())
+	if err != nil {
+		return fmt.Errorf("unable to export: %s", err)
+	}
+	exported.SetTempDir(context.TempDir())
+	exported.SetContext(context.Context())
+
+	provider := &exportStorageProvider{storage: files.NewExportStorage(dir)}
+
+	err = exported.Publish(context.PackagePool(), provider, context.CollectionFactory(), signer, context.Progress(), false)
+	if err != nil {
+		return fmt.Errorf("unable to export: %s", err)
+	}
+
+	context.Progress().Printf("\nSnapshot %s has been successfully exported to %s as distribution %s, component %s.\n",
+		snapshot.Name, dir, exported.Distribution, strings.Join(exported.Components(), " "))
+
+	return err
+}
+
+func makeCmdSnapshotExport() *commander.Command {
+	cmd := &commander.Command{
+		Run:       aptlySnapshotExport,
+		UsageLine: "export <name> <directory>",
+		Short:     "export snapshot as repository in plain directory",
+		Long: `
+Command export writes snapshot <name> as a complete Debian repository (dists/
+and pool/) into <directory>, which should be empty or not exist. Package files
+are copied from the package pool, so the result doesn't depend on aptly and
+could be handed off to another system.
+
+Exported repository isn't registered as published repository, aptly doesn't
+track it after export.
+
+Example:
+
+  $ aptly snapshot export -distribution=wheezy wheezy-main /tmp/wheezy-repo
+`,
+		Flag: *flag.NewFlagSet("aptly-snapshot-export", flag.ExitOnError),
+	}
+	cmd.Flag.String("distribution", "", "distribution name to export")
+	cmd.Flag.String("component", "", "component name to export")
+	cmd.Flag.String("compression", "gz,bz2", "compression formats for indexes, separated by commas (gz, bz2) or none")
+	cmd.Flag.Int("gzip-level", 0, "gzip compression level for indexes (1-9), default is taken from config")
+	cmd.Flag.Int("bzip2-level", 0, "bzip2 compression level for indexes (1-9), default is taken from config")
+	cmd.Flag.String("gpg-key", "", "GPG key ID to use when signing the release (overrides gpgKey from config)")
+	cmd.Flag.Var(&keyRingsFlag{}, "keyring", "GPG keyring to use (instead of default)")
+	cmd.Flag.String("secret-keyring", "", "GPG secret keyring to use (instead of default)")
+	cmd.Flag.String("passphrase", "", "GPG passhprase for the key (warning: could be insecure)")
+	cmd.Flag.String("passphrase-file", "", "GPG passhprase-file for the key (warning: could be insecure)")
+	cmd.Flag.Bool("batch", false, "run GPG with detached tty")
+	cmd.Flag.Bool("skip-signing", false, "don't sign Release files with GPG")
+	cmd.Flag.String("origin", "", "origin name to export")
+	cmd.Flag.String("label", "", "label to export")
+
+	return cmd
+}
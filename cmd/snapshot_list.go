@@ -16,24 +16,53 @@ func aptlySnapshotList(cmd *commander.Command, args []string) error {
 	raw := cmd.Flag.Lookup("raw").Value.Get().(bool)
 	sortMethodString := cmd.Flag.Lookup("sort").Value.Get().(string)
 
+	labels, err := deb.ParseLabels(cmd.Flag.Lookup("label").Value.String())
+	if err != nil {
+		return fmt.Errorf("unable to list: %s", err)
+	}
+
+	onlyPublished, onlyUnpublished, err := publishedFilterFlags(cmd)
+	if err != nil {
+		return fmt.Errorf("unable to list: %s", err)
+	}
+
 	collection := context.CollectionFactory().SnapshotCollection()
+	publishedCollection := context.CollectionFactory().PublishedRepoCollection()
+
+	snapshots := []*deb.Snapshot{}
+	err = collection.ForEachSorted(sortMethodString, func(snapshot *deb.Snapshot) error {
+		if !snapshot.Labels.Matches(labels) {
+			return nil
+		}
+
+		if onlyPublished || onlyUnpublished {
+			published := len(publishedCollection.BySnapshot(snapshot)) > 0
+			if published != onlyPublished {
+				return nil
+			}
+		}
+
+		snapshots = append(snapshots, snapshot)
+		return nil
+	})
+	if err != nil {
+		// list header has always been printed before sorting method is checked
+		if !raw && collection.Len() > 0 {
+			fmt.Printf("List of snapshots:\n")
+		}
+		return err
+	}
 
 	if raw {
-		collection.ForEachSorted(sortMethodString, func(snapshot *deb.Snapshot) error {
+		for _, snapshot := range snapshots {
 			fmt.Printf("%s\n", snapshot.Name)
-			return nil
-		})
+		}
 	} else {
-		if collection.Len() > 0 {
+		if len(snapshots) > 0 {
 			fmt.Printf("List of snapshots:\n")
 
-			err = collection.ForEachSorted(sortMethodString, func(snapshot *deb.Snapshot) error {
+			for _, snapshot := range snapshots {
 				fmt.Printf(" * %s\n", snapshot.String())
-				return nil
-			})
-
-			if err != nil {
-				return err
 			}
 
 			fmt.Printf("\nTo get more information about snapshot, run `aptly snapshot show <name>`.\n")
@@ -51,7 +80,8 @@ func makeCmdSnapshotList() *commander.Command {
 		UsageLine: "list",
 		Short:     "list snapshots",
 		Long: `
-Command list shows full list of snapshots created.
+Command list shows full list of snapshots created. With -published (-unpublished)
+flag, only snapshots which are (aren't) published are listed.
 
 Example:
 
@@ -60,7 +90,10 @@ Example:
 	}
 
 	cmd.Flag.Bool("raw", false, "display list in machine-readable format")
-	cmd.Flag.String("sort", "name", "display list in 'name' or creation 'time' order")
+	cmd.Flag.String("sort", "name", "display list in 'name', creation 'time' ('created') or modification time ('modified') order")
+	cmd.Flag.String("label", "", "list only items with labels, comma-separated list of key=value")
+	cmd.Flag.Bool("published", false, "list only published snapshots")
+	cmd.Flag.Bool("unpublished", false, "list only snapshots which aren't published")
 
 	return cmd
 }
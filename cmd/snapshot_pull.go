@@ -158,6 +158,9 @@ versions from <source> following dependencies. New snapshot <destination>
 is created as a result of this process. Packages could be specified simply
 as 'package-name' or as package queries.
 
+With -dry-run, packages which would be added and removed are printed (the same
+way as with real pull), but snapshot <destination> is not created.
+
 Example:
 
     $ aptly snapshot pull wheezy-main wheezy-backports wheezy-new-xorg xorg-server-server
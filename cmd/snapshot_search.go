@@ -3,10 +3,8 @@ package cmd
 import (
 	"fmt"
 	"github.com/smira/aptly/deb"
-	"github.com/smira/aptly/query"
 	"github.com/smira/commander"
 	"github.com/smira/flag"
-	"sort"
 )
 
 func aptlySnapshotMirrorRepoSearch(cmd *commander.Command, args []string) error {
@@ -61,37 +59,9 @@ func aptlySnapshotMirrorRepoSearch(cmd *commander.Command, args []string) error
 		panic("unknown command")
 	}
 
-	list, err := deb.NewPackageListFromRefList(reflist, context.CollectionFactory().PackageCollection(), context.Progress())
-	if err != nil {
-		return fmt.Errorf("unable to search: %s", err)
-	}
-
-	list.PrepareIndex()
-
-	q, err := query.Parse(args[1])
-	if err != nil {
-		return fmt.Errorf("unable to search: %s", err)
-	}
-
 	withDeps := context.Flags().Lookup("with-deps").Value.Get().(bool)
-	architecturesList := []string{}
-
-	if withDeps {
-		if len(context.ArchitecturesList()) > 0 {
-			architecturesList = context.ArchitecturesList()
-		} else {
-			architecturesList = list.Architectures(false)
-		}
-
-		sort.Strings(architecturesList)
-
-		if len(architecturesList) == 0 {
-			return fmt.Errorf("unable to determine list of architectures, please specify explicitly")
-		}
-	}
 
-	result, err := list.Filter([]deb.PackageQuery{q}, withDeps,
-		nil, context.DependencyOptions(), architecturesList)
+	result, err := FilterPackageList(reflist, args[1], withDeps)
 	if err != nil {
 		return fmt.Errorf("unable to search: %s", err)
 	}
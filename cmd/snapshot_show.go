@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/smira/aptly/deb"
 	"github.com/smira/commander"
 	"github.com/smira/flag"
 )
@@ -26,8 +27,15 @@ func aptlySnapshotShow(cmd *commander.Command, args []string) error {
 	}
 
 	fmt.Printf("Name: %s\n", snapshot.Name)
-	fmt.Printf("Created At: %s\n", snapshot.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+	fmt.Printf("Created At: %s\n", FormatTime(snapshot.CreatedAt))
+	fmt.Printf("Last Modified: %s\n", FormatTime(snapshot.ModifiedAt))
 	fmt.Printf("Description: %s\n", snapshot.Description)
+	if len(snapshot.Labels) > 0 {
+		fmt.Printf("Labels: %s\n", snapshot.Labels)
+	}
+	if snapshot.Pinned {
+		fmt.Printf("Pinned: yes\n")
+	}
 	fmt.Printf("Number of packages: %d\n", snapshot.NumPackages())
 
 	withPackages := context.Flags().Lookup("with-packages").Value.Get().(bool)
@@ -35,9 +43,39 @@ func aptlySnapshotShow(cmd *commander.Command, args []string) error {
 		ListPackagesRefList(snapshot.RefList())
 	}
 
+	withDepProblems := context.Flags().Lookup("with-dep-problems").Value.Get().(bool)
+	if withDepProblems {
+		err = showSnapshotDependencyProblems(snapshot)
+	}
+
 	return err
 }
 
+// showSnapshotDependencyProblems lists packages of snapshot with dependencies which can't be satisfied
+// within the snapshot
+func showSnapshotDependencyProblems(snapshot *deb.Snapshot) error {
+	problems, err := snapshot.DependencyProblems(context.CollectionFactory().PackageCollection(),
+		context.DependencyOptions(), context.ArchitecturesList())
+	if err != nil {
+		return err
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("Dependency problems: none\n")
+		return nil
+	}
+
+	fmt.Printf("Dependency problems (%d):\n", len(problems))
+	for _, problem := range problems {
+		fmt.Printf("  %s:\n", problem.Package)
+		for _, dep := range problem.Missing {
+			fmt.Printf("    %s\n", dep.String())
+		}
+	}
+
+	return nil
+}
+
 func makeCmdSnapshotShow() *commander.Command {
 	cmd := &commander.Command{
 		Run:       aptlySnapshotShow,
@@ -46,6 +84,10 @@ func makeCmdSnapshotShow() *commander.Command {
 		Long: `
 Command show displays full information about a snapshot.
 
+With -with-dep-problems, dependencies of packages in the snapshot are resolved
+within the snapshot itself, and packages with unsatisfied dependencies are listed
+along with missing dependencies.
+
 Example:
 
     $ aptly snapshot show wheezy-main
@@ -54,6 +96,7 @@ Example:
 	}
 
 	cmd.Flag.Bool("with-packages", false, "show list of packages")
+	cmd.Flag.Bool("with-dep-problems", false, "show packages with dependencies unsatisfied within the snapshot")
 
 	return cmd
 }
@@ -27,5 +27,8 @@ Shows aptly version.
 ex:
   $ aptly version
 `,
+		Subcommands: []*commander.Command{
+			makeCmdVersionCompare(),
+		},
 	}
 }
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	ctx "github.com/smira/aptly/context"
+	"github.com/smira/aptly/deb"
+	"github.com/smira/commander"
+)
+
+func aptlyVersionCompare(cmd *commander.Command, args []string) error {
+	if len(args) != 3 {
+		cmd.Usage()
+		return commander.ErrCommandError
+	}
+
+	result, err := deb.CompareVersionsRelation(args[0], args[1], args[2])
+	if err != nil {
+		// exit code 1 is reserved for "relation doesn't hold"
+		panic(&ctx.FatalError{ReturnCode: 2, Message: fmt.Sprintf("unable to compare versions: %s", err)})
+	}
+
+	if !result {
+		// silently exit with non-zero code, like dpkg --compare-versions
+		panic(&ctx.FatalError{ReturnCode: 1})
+	}
+
+	return nil
+}
+
+func makeCmdVersionCompare() *commander.Command {
+	return &commander.Command{
+		Run:       aptlyVersionCompare,
+		UsageLine: "compare <version1> <op> <version2>",
+		Short:     "compare Debian package versions",
+		Long: `
+Command compare checks whether relation <op> holds between package versions
+<version1> and <version2>, comparing them according to Debian policy (the same way
+dpkg --compare-versions does). Exit code is 0 if relation holds and 1 otherwise.
+
+Supported relations are: lt, le, eq, ne, ge, gt (or <<, <=, =, !=, >=, >>).
+
+Example:
+
+  $ aptly version compare 1.0~rc1 lt 1.0
+`,
+	}
+}
@@ -9,14 +9,17 @@ import (
 	"github.com/smira/aptly/deb"
 	"github.com/smira/aptly/files"
 	"github.com/smira/aptly/http"
+	"github.com/smira/aptly/multi"
 	"github.com/smira/aptly/s3"
 	"github.com/smira/aptly/utils"
 	"github.com/smira/commander"
 	"github.com/smira/flag"
+	gocontext "golang.org/x/net/context"
 	"os"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -29,12 +32,17 @@ type AptlyContext struct {
 	flags, globalFlags *flag.FlagSet
 	configLoaded       bool
 
+	// cancelled to abort long-running operations, has deadline with -timeout
+	ctx    gocontext.Context
+	cancel gocontext.CancelFunc
+
 	progress          aptly.Progress
 	downloader        aptly.Downloader
 	database          database.Storage
 	packagePool       aptly.PackagePool
 	publishedStorages map[string]aptly.PublishedStorage
 	collectionFactory *deb.CollectionFactory
+	auditJournal      *utils.AuditJournal
 	dependencyOptions int
 	architecturesList []string
 	// Debug features
@@ -53,6 +61,9 @@ type FatalError struct {
 	Message    string
 }
 
+// TimeoutReturnCode is exit code for commands aborted on -timeout
+const TimeoutReturnCode = 3
+
 // Fatal panics and aborts execution with exit code 1
 func Fatal(err error) {
 	returnCode := 1
@@ -62,6 +73,11 @@ func Fatal(err error) {
 	panic(&FatalError{ReturnCode: returnCode, Message: err.Error()})
 }
 
+// FatalTimeout panics and aborts execution with TimeoutReturnCode
+func FatalTimeout(err error) {
+	panic(&FatalError{ReturnCode: TimeoutReturnCode, Message: err.Error()})
+}
+
 // Config loads and returns current configuration
 func (context *AptlyContext) Config() *utils.ConfigStructure {
 	context.Lock()
@@ -87,7 +103,7 @@ func (context *AptlyContext) config() *utils.ConfigStructure {
 				"/etc/aptly.conf",
 			}
 
-			for _, configLocation := range configLocations {
+			for _, configLocation = range configLocations {
 				err = utils.LoadConfig(configLocation, &utils.Config)
 				if err == nil {
 					break
@@ -98,11 +114,18 @@ func (context *AptlyContext) config() *utils.ConfigStructure {
 			}
 
 			if err != nil {
-				fmt.Printf("Config file not found, creating default config at %s\n\n", configLocations[0])
-				utils.SaveConfig(configLocations[0], &utils.Config)
+				configLocation = configLocations[0]
+				fmt.Printf("Config file not found, creating default config at %s\n\n", configLocation)
+				utils.SaveConfig(configLocation, &utils.Config)
 			}
 		}
 
+		// config fragments from <config>.d/ override settings from main config file
+		err = utils.LoadConfigDir(configLocation+utils.ConfigDirSuffix, &utils.Config)
+		if err != nil && !os.IsNotExist(err) {
+			Fatal(err)
+		}
+
 		context.configLoaded = true
 
 	}
@@ -168,6 +191,61 @@ func (context *AptlyContext) ArchitecturesList() []string {
 	return context.architecturesList
 }
 
+// ReadOnly checks whether aptly runs in read-only mode (-read-only flag or config),
+// when commands and API requests modifying state are rejected
+func (context *AptlyContext) ReadOnly() bool {
+	return context.LookupOption(context.Config().ReadOnly, "read-only")
+}
+
+// TempDir returns directory for temporary files (in-progress downloads, publish staging)
+// from command-line flag -temp-dir or config, empty string means system default
+//
+// Directory is created if it doesn't exist yet.
+func (context *AptlyContext) TempDir() string {
+	context.Lock()
+	defer context.Unlock()
+
+	return context.tempDir()
+}
+
+func (context *AptlyContext) tempDir() string {
+	tempDir := context.config().TempDir
+	if optionTempDir := context.globalFlags.Lookup("temp-dir").Value.String(); optionTempDir != "" {
+		tempDir = optionTempDir
+	}
+
+	if tempDir != "" {
+		err := os.MkdirAll(tempDir, 0700)
+		if err != nil {
+			Fatal(fmt.Errorf("unable to create temporary directory: %s", err))
+		}
+	}
+
+	return tempDir
+}
+
+// CompressionLevels returns index compression levels from config, overridden
+// by command-line flags -gzip-level & -bzip2-level (if command supports them)
+func (context *AptlyContext) CompressionLevels() utils.CompressionLevels {
+	context.Lock()
+	defer context.Unlock()
+
+	levels := utils.CompressionLevels{
+		utils.CompressionGzip:  context.config().GzipCompressionLevel,
+		utils.CompressionBzip2: context.config().Bzip2CompressionLevel,
+	}
+
+	if context.flags != nil {
+		for format, name := range map[string]string{utils.CompressionGzip: "gzip-level", utils.CompressionBzip2: "bzip2-level"} {
+			if context.flags.Lookup(name) != nil && context.flags.IsSet(name) {
+				levels[format] = context.flags.Lookup(name).Value.Get().(int)
+			}
+		}
+	}
+
+	return levels
+}
+
 // Progress creates or returns Progress object
 func (context *AptlyContext) Progress() aptly.Progress {
 	context.Lock()
@@ -199,8 +277,8 @@ func (context *AptlyContext) Downloader() aptly.Downloader {
 		if downloadLimit == 0 {
 			downloadLimit = context.config().DownloadLimit
 		}
-		context.downloader = http.NewDownloader(context.config().DownloadConcurrency,
-			downloadLimit*1024, context._progress())
+		context.downloader = http.NewDownloader(context.ctx, context.config().DownloadConcurrency,
+			downloadLimit*1024, context.tempDir(), context._progress()).WithRetryPolicy(context.config().RetryPolicy)
 	}
 
 	return context.downloader
@@ -231,7 +309,8 @@ func (context *AptlyContext) _database() (database.Storage, error) {
 	if context.database == nil {
 		var err error
 
-		context.database, err = database.OpenDB(context.dbPath())
+		context.database, err = database.OpenDBWait(context.dbPath(),
+			time.Duration(context.config().DatabaseLockTimeout)*time.Second, currentOperation(os.Args))
 		if err != nil {
 			return nil, fmt.Errorf("can't open database: %s", err)
 		}
@@ -240,6 +319,24 @@ func (context *AptlyContext) _database() (database.Storage, error) {
 	return context.database, nil
 }
 
+// currentOperation describes command being run from command line arguments,
+// skipping flags, as flag values might be secret (e.g. passphrases)
+func currentOperation(args []string) string {
+	words := []string{filepath.Base(args[0])}
+
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "-") {
+			if len(words) > 1 {
+				break
+			}
+			continue
+		}
+		words = append(words, arg)
+	}
+
+	return strings.Join(words, " ")
+}
+
 // CloseDatabase closes the db temporarily
 func (context *AptlyContext) CloseDatabase() error {
 	context.Lock()
@@ -309,6 +406,11 @@ func (context *AptlyContext) GetPublishedStorage(name string) aptly.PublishedSto
 	context.Lock()
 	defer context.Unlock()
 
+	return context.getPublishedStorage(name)
+}
+
+// getPublishedStorage returns instance of PublishedStorage, context should be locked
+func (context *AptlyContext) getPublishedStorage(name string) aptly.PublishedStorage {
 	publishedStorage, ok := context.publishedStorages[name]
 	if !ok {
 		if name == "" {
@@ -319,13 +421,32 @@ func (context *AptlyContext) GetPublishedStorage(name string) aptly.PublishedSto
 				Fatal(fmt.Errorf("published S3 storage %v not configured", name[3:]))
 			}
 
-			var err error
-			publishedStorage, err = s3.NewPublishedStorage(params.AccessKeyID, params.SecretAccessKey,
-				params.Region, params.Bucket, params.ACL, params.Prefix, params.StorageClass,
-				params.EncryptionMethod, params.PlusWorkaround)
+			s3Storage, err := s3.NewPublishedStorage(params.AccessKeyID, params.SecretAccessKey,
+				params.Credentials, params.Region, params.Endpoint, params.Bucket, params.ACL, params.Prefix, params.StorageClass,
+				params.EncryptionMethod, params.PlusWorkaround, params.ForcePathStyle, params.DisableSSL)
 			if err != nil {
 				Fatal(err)
 			}
+			s3Storage.SetRetryPolicy(context.config().RetryPolicy.Override(params.RetryPolicy))
+			s3Storage.SetCircuitBreaker(params.CircuitBreaker)
+			s3Storage.SetMetadata(params.Metadata)
+			s3Storage.SetPrescanPool(params.PrescanPool)
+			s3Storage.SetContext(context.ctx)
+			publishedStorage = s3Storage
+		} else if strings.HasPrefix(name, "multi:") {
+			params, ok := context.config().MultiPublishRoots[name[6:]]
+			if !ok {
+				Fatal(fmt.Errorf("published multi storage %v not configured", name[6:]))
+			}
+
+			targets := make([]multi.Target, len(params.Endpoints))
+			for i, endpoint := range params.Endpoints {
+				if strings.HasPrefix(endpoint, "multi:") {
+					Fatal(fmt.Errorf("published multi storage %v: nested multi storage %v is not supported", name[6:], endpoint))
+				}
+				targets[i] = multi.Target{Name: endpoint, Storage: context.getPublishedStorage(endpoint)}
+			}
+			publishedStorage = multi.NewPublishedStorage(targets, params.BestEffort)
 		} else {
 			Fatal(fmt.Errorf("unknown published storage format: %v", name))
 		}
@@ -335,11 +456,168 @@ func (context *AptlyContext) GetPublishedStorage(name string) aptly.PublishedSto
 	return publishedStorage
 }
 
+// ValidateConfig checks configured published storage endpoints and signing
+// facilities without performing any modifications
+//
+// It returns list of problems which would make aptly fail and list of warnings
+func (context *AptlyContext) ValidateConfig() (problems []error, warnings []string) {
+	config := context.Config()
+
+	if config.DownloadConcurrency < 1 {
+		warnings = append(warnings, fmt.Sprintf("downloadConcurrency is %d, downloads would be serialized", config.DownloadConcurrency))
+	}
+
+	names := make([]string, 0, len(config.S3PublishRoots))
+	for name := range config.S3PublishRoots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		params := config.S3PublishRoots[name]
+
+		if params.Credentials == s3.CredentialsDefault && (params.AccessKeyID == "" || params.SecretAccessKey == "") {
+			warnings = append(warnings, fmt.Sprintf("S3 endpoint %s: credentials not set, would be taken from environment", name))
+		}
+
+		storage, err := s3.NewPublishedStorage(params.AccessKeyID, params.SecretAccessKey,
+			params.Credentials, params.Region, params.Endpoint, params.Bucket, params.ACL, params.Prefix, params.StorageClass,
+			params.EncryptionMethod, params.PlusWorkaround, params.ForcePathStyle, params.DisableSSL)
+		if err == nil {
+			err = storage.Check()
+		}
+		if err != nil {
+			problems = append(problems, fmt.Errorf("S3 endpoint %s: %s", name, err))
+		}
+	}
+
+	multiNames := make([]string, 0, len(config.MultiPublishRoots))
+	for name := range config.MultiPublishRoots {
+		multiNames = append(multiNames, name)
+	}
+	sort.Strings(multiNames)
+
+	for _, name := range multiNames {
+		params := config.MultiPublishRoots[name]
+
+		if len(params.Endpoints) == 0 {
+			problems = append(problems, fmt.Errorf("multi endpoint %s: no endpoints configured", name))
+		}
+
+		for _, endpoint := range params.Endpoints {
+			if endpoint == "" {
+				continue
+			}
+			if strings.HasPrefix(endpoint, "s3:") {
+				if _, ok := config.S3PublishRoots[endpoint[3:]]; ok {
+					continue
+				}
+			}
+			problems = append(problems, fmt.Errorf("multi endpoint %s: unknown endpoint %s", name, endpoint))
+		}
+	}
+
+	levels := utils.CompressionLevels{
+		utils.CompressionGzip:  config.GzipCompressionLevel,
+		utils.CompressionBzip2: config.Bzip2CompressionLevel,
+	}
+	if err := levels.Validate(); err != nil {
+		problems = append(problems, fmt.Errorf("compression levels: %s", err))
+	}
+
+	if debsigVerifier := deb.NewDebsigVerifier(config.Debsig); debsigVerifier != nil {
+		if err := debsigVerifier.Init(); err != nil {
+			problems = append(problems, fmt.Errorf("debsig: %s", err))
+		}
+	}
+
+	if hook := deb.NewPreAddHook(config.PreAddHook); hook != nil {
+		if err := hook.Init(); err != nil {
+			problems = append(problems, fmt.Errorf("preAddHook: %s", err))
+		}
+	}
+
+	if config.GpgDisableSign {
+		warnings = append(warnings, "signing is disabled, published repositories won't be signed")
+	} else {
+		_, err := context.NewSigner(SignerOptions{})
+		if err != nil {
+			problems = append(problems, fmt.Errorf("signing keyring: %s", err))
+		}
+	}
+
+	return
+}
+
+// SignerOptions describes signing key and keyrings used when publishing
+type SignerOptions struct {
+	// GpgKey selects signing key, gpgKey from config is used if empty
+	GpgKey         string
+	Keyring        string
+	SecretKeyring  string
+	Passphrase     string
+	PassphraseFile string
+	Batch          bool
+}
+
+// NewSigner builds and initializes signer used to sign published repositories
+func (context *AptlyContext) NewSigner(options SignerOptions) (utils.Signer, error) {
+	keyRef := options.GpgKey
+	if keyRef == "" {
+		keyRef = context.Config().GpgKey
+	}
+
+	signer := &utils.GpgSigner{}
+	signer.SetKey(keyRef)
+	signer.SetKeyRing(options.Keyring, options.SecretKeyring)
+	signer.SetPassphrase(options.Passphrase, options.PassphraseFile)
+	signer.SetBatch(options.Batch)
+
+	err := signer.Init()
+	if err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
 // UploadPath builds path to upload storage
 func (context *AptlyContext) UploadPath() string {
 	return filepath.Join(context.Config().RootDir, "upload")
 }
 
+// KeyringsPath builds path to managed keyrings (imported via API)
+func (context *AptlyContext) KeyringsPath() string {
+	return filepath.Join(context.Config().RootDir, "gpg")
+}
+
+// KeyringPath resolves keyring reference: name of managed keyring is
+// converted to path to keyring file, other references are returned as is
+func (context *AptlyContext) KeyringPath(keyring string) string {
+	if strings.ContainsRune(keyring, filepath.Separator) {
+		return keyring
+	}
+
+	managed := filepath.Join(context.KeyringsPath(), keyring+".gpg")
+	if _, err := os.Stat(managed); err == nil {
+		return managed
+	}
+
+	return keyring
+}
+
+// AuditJournal returns journal of mutating operations, nil if journal is not enabled
+func (context *AptlyContext) AuditJournal() *utils.AuditJournal {
+	context.Lock()
+	defer context.Unlock()
+
+	if context.auditJournal == nil && context.config().AuditJournal {
+		context.auditJournal = utils.NewAuditJournal(filepath.Join(context.config().RootDir, "audit.jsonl"))
+	}
+
+	return context.auditJournal
+}
+
 // UpdateFlags sets internal copy of flags in the context
 func (context *AptlyContext) UpdateFlags(flags *flag.FlagSet) {
 	context.Lock()
@@ -364,6 +642,34 @@ func (context *AptlyContext) GlobalFlags() *flag.FlagSet {
 	return context.globalFlags
 }
 
+// Context returns context which is cancelled when long-running operations
+// (downloading, publishing) should be aborted
+func (context *AptlyContext) Context() gocontext.Context {
+	return context.ctx
+}
+
+// Timeout returns duration set with -timeout, zero if there's no timeout
+func (context *AptlyContext) Timeout() time.Duration {
+	timeoutFlag := context.globalFlags.Lookup("timeout")
+	if timeoutFlag == nil {
+		return 0
+	}
+
+	return timeoutFlag.Value.Get().(time.Duration)
+}
+
+// TimedOut checks whether operations have been aborted as deadline set by -timeout
+// has passed
+func (context *AptlyContext) TimedOut() bool {
+	return context.ctx.Err() == gocontext.DeadlineExceeded
+}
+
+// Cancel aborts long-running operations: in-progress downloads are stopped
+// and their temporary files are removed
+func (context *AptlyContext) Cancel() {
+	context.cancel()
+}
+
 // Shutdown shuts context down
 func (context *AptlyContext) Shutdown() {
 	context.Lock()
@@ -424,6 +730,11 @@ func NewContext(flags *flag.FlagSet) (*AptlyContext, error) {
 		dependencyOptions: -1,
 		publishedStorages: map[string]aptly.PublishedStorage{},
 	}
+	if timeout := context.Timeout(); timeout > 0 {
+		context.ctx, context.cancel = gocontext.WithTimeout(gocontext.Background(), timeout)
+	} else {
+		context.ctx, context.cancel = gocontext.WithCancel(gocontext.Background())
+	}
 
 	if aptly.EnableDebug {
 		cpuprofile := flags.Lookup("cpuprofile").Value.String()
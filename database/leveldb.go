@@ -9,6 +9,7 @@ import (
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/storage"
 	"github.com/syndtr/goleveldb/leveldb/util"
+	"os"
 )
 
 // Errors for Storage
@@ -16,11 +17,25 @@ var (
 	ErrNotFound = errors.New("key not found")
 )
 
-// Storage is an interface to KV storage
-type Storage interface {
+// ReaderWriter reads and writes single keys
+type ReaderWriter interface {
 	Get(key []byte) ([]byte, error)
 	Put(key []byte, value []byte) error
 	Delete(key []byte) error
+}
+
+// Batch collects writes which are applied to the database at once by Write,
+// Get returns values written to the batch so far. Batch which is not written
+// is discarded.
+type Batch interface {
+	ReaderWriter
+	Write() error
+}
+
+// Storage is an interface to KV storage
+type Storage interface {
+	ReaderWriter
+	CreateBatch() Batch
 	KeysByPrefix(prefix []byte) [][]byte
 	FetchByPrefix(prefix []byte) [][]byte
 	Close() error
@@ -34,6 +49,8 @@ type levelDB struct {
 	path  string
 	db    *leveldb.DB
 	batch *leveldb.Batch
+	// operation recorded in lock info, if database is opened with OpenDBWait
+	operation string
 }
 
 // Check interface
@@ -58,22 +75,28 @@ func OpenDB(path string) (Storage, error) {
 	return &levelDB{db: db, path: path}, nil
 }
 
-// RecoverDB recovers LevelDB database from corruption
-func RecoverDB(path string) error {
+// RecoverDB recovers LevelDB database from corruption, returning number of keys salvaged
+func RecoverDB(path string) (int, error) {
 	stor, err := storage.OpenFile(path)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	defer stor.Close()
 
 	db, err := leveldb.Recover(stor, nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	defer db.Close()
 
-	db.Close()
-	stor.Close()
+	keys := 0
+	iterator := db.NewIterator(nil, nil)
+	for iterator.Next() {
+		keys++
+	}
+	iterator.Release()
 
-	return nil
+	return keys, iterator.Error()
 }
 
 // Get key value from database
@@ -156,6 +179,9 @@ func (l *levelDB) Close() error {
 	if l.db == nil {
 		return nil
 	}
+	if l.operation != "" {
+		os.Remove(lockInfoPath(l.path))
+	}
 	err := l.db.Close()
 	l.db = nil
 	return err
@@ -169,6 +195,12 @@ func (l *levelDB) ReOpen() error {
 
 	var err error
 	l.db, err = internalOpen(l.path)
+	if err == nil && l.operation != "" {
+		if err = writeLockInfo(l.path, l.operation); err != nil {
+			l.db.Close()
+			l.db = nil
+		}
+	}
 	return err
 }
 
@@ -192,6 +224,49 @@ func (l *levelDB) FinishBatch() error {
 	return err
 }
 
+type levelDBBatch struct {
+	l     *levelDB
+	batch *leveldb.Batch
+	// values written to the batch, nil for deleted keys
+	written map[string][]byte
+}
+
+// CreateBatch creates batch of writes, independent of StartBatch/FinishBatch
+func (l *levelDB) CreateBatch() Batch {
+	return &levelDBBatch{l: l, batch: new(leveldb.Batch), written: make(map[string][]byte)}
+}
+
+// Get returns value written to the batch or stored in database
+func (b *levelDBBatch) Get(key []byte) ([]byte, error) {
+	if value, ok := b.written[string(key)]; ok {
+		if value == nil {
+			return nil, ErrNotFound
+		}
+		return value, nil
+	}
+
+	return b.l.Get(key)
+}
+
+// Put saves key to the batch
+func (b *levelDBBatch) Put(key []byte, value []byte) error {
+	b.written[string(key)] = append([]byte{}, value...)
+	b.batch.Put(key, value)
+	return nil
+}
+
+// Delete removes key in the batch
+func (b *levelDBBatch) Delete(key []byte) error {
+	b.written[string(key)] = nil
+	b.batch.Delete(key)
+	return nil
+}
+
+// Write applies all the writes of the batch to the database
+func (b *levelDBBatch) Write() error {
+	return b.l.db.Write(b.batch, nil)
+}
+
 // CompactDB compacts database by merging layers
 func (l *levelDB) CompactDB() error {
 	return l.db.CompactRange(util.Range{})
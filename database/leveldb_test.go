@@ -1,7 +1,12 @@
 package database
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 
 	. "gopkg.in/check.v1"
 )
@@ -43,8 +48,9 @@ func (s *LevelDBSuite) TestRecoverDB(c *C) {
 	err = s.db.Close()
 	c.Check(err, IsNil)
 
-	err = RecoverDB(s.path)
+	keys, err := RecoverDB(s.path)
 	c.Check(err, IsNil)
+	c.Check(keys, Equals, 1)
 
 	s.db, err = OpenDB(s.path)
 	c.Check(err, IsNil)
@@ -54,6 +60,28 @@ func (s *LevelDBSuite) TestRecoverDB(c *C) {
 	c.Assert(result, DeepEquals, value)
 }
 
+func (s *LevelDBSuite) TestRecoverCorruptedDB(c *C) {
+	c.Check(s.db.Put([]byte("key1"), []byte("value1")), IsNil)
+	c.Check(s.db.Put([]byte("key2"), []byte("value2")), IsNil)
+	c.Check(s.db.CompactDB(), IsNil)
+	c.Check(s.db.Close(), IsNil)
+
+	// lose track of the tables
+	manifests, _ := filepath.Glob(filepath.Join(s.path, "MANIFEST-*"))
+	c.Assert(manifests, Not(HasLen), 0)
+	for _, manifest := range append(manifests, filepath.Join(s.path, "CURRENT")) {
+		c.Assert(os.Remove(manifest), IsNil)
+	}
+
+	keys, err := RecoverDB(s.path)
+	c.Assert(err, IsNil)
+	c.Check(keys, Equals, 2)
+
+	s.db, err = OpenDB(s.path)
+	c.Assert(err, IsNil)
+	c.Check(s.db.KeysByPrefix([]byte("key")), DeepEquals, [][]byte{[]byte("key1"), []byte("key2")})
+}
+
 func (s *LevelDBSuite) TestGetPut(c *C) {
 	var (
 		key   = []byte("key")
@@ -149,6 +177,47 @@ func (s *LevelDBSuite) TestBatch(c *C) {
 	c.Check(func() { s.db.StartBatch() }, Panics, "batch already started")
 }
 
+func (s *LevelDBSuite) TestCreateBatch(c *C) {
+	var (
+		key    = []byte("key")
+		key2   = []byte("key2")
+		value  = []byte("value")
+		value2 = []byte("value2")
+	)
+
+	err := s.db.Put(key, value)
+	c.Assert(err, IsNil)
+
+	batch := s.db.CreateBatch()
+	c.Check(batch.Put(key2, value2), IsNil)
+	c.Check(batch.Delete(key), IsNil)
+
+	// batch sees its own writes, database doesn't
+	v, err := batch.Get(key2)
+	c.Check(err, IsNil)
+	c.Check(v, DeepEquals, value2)
+	_, err = batch.Get(key)
+	c.Check(err, Equals, ErrNotFound)
+
+	v, err = s.db.Get(key)
+	c.Check(err, IsNil)
+	c.Check(v, DeepEquals, value)
+	_, err = s.db.Get(key2)
+	c.Check(err, Equals, ErrNotFound)
+
+	// discarded batch leaves database untouched, other batches are independent
+	other := s.db.CreateBatch()
+	c.Check(other.Put(key, value2), IsNil)
+
+	c.Assert(batch.Write(), IsNil)
+
+	v2, err := s.db.Get(key2)
+	c.Check(err, IsNil)
+	c.Check(v2, DeepEquals, value2)
+	_, err = s.db.Get(key)
+	c.Check(err, Equals, ErrNotFound)
+}
+
 func (s *LevelDBSuite) TestCompactDB(c *C) {
 	s.db.Put([]byte{0x80, 0x01}, []byte{0x01})
 	s.db.Put([]byte{0x80, 0x03}, []byte{0x03})
@@ -176,3 +245,76 @@ func (s *LevelDBSuite) TestReOpen(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(result, DeepEquals, value)
 }
+
+type LockSuite struct {
+	path string
+}
+
+var _ = Suite(&LockSuite{})
+
+func (s *LockSuite) SetUpTest(c *C) {
+	s.path = c.MkDir()
+}
+
+func (s *LockSuite) TestOpenDBWait(c *C) {
+	db, err := OpenDBWait(s.path, 0, "aptly mirror update wheezy")
+	c.Assert(err, IsNil)
+
+	info := readLockInfo(s.path)
+	c.Assert(info, NotNil)
+	c.Check(info.PID, Equals, os.Getpid())
+	c.Check(info.Operation, Equals, "aptly mirror update wheezy")
+
+	c.Check(db.Close(), IsNil)
+	c.Check(readLockInfo(s.path), IsNil)
+
+	c.Check(db.ReOpen(), IsNil)
+	c.Check(readLockInfo(s.path), NotNil)
+	c.Check(db.Close(), IsNil)
+}
+
+func (s *LockSuite) TestOpenDBWaitTimeout(c *C) {
+	db, err := OpenDBWait(s.path, 0, "aptly mirror update wheezy")
+	c.Assert(err, IsNil)
+
+	start := time.Now()
+	_, err = OpenDBWait(s.path, 300*time.Millisecond, "aptly repo add local file.deb")
+	c.Check(time.Since(start) >= 300*time.Millisecond, Equals, true)
+	c.Assert(err, FitsTypeOf, &LockedError{})
+	c.Check(err.(*LockedError).Info.Operation, Equals, "aptly mirror update wheezy")
+	c.Check(err, ErrorMatches, "database is locked by another aptly process \\(pid \\d+, running 'aptly mirror update wheezy' since .+\\), gave up after waiting for 300ms")
+
+	c.Check(db.Close(), IsNil)
+}
+
+func (s *LockSuite) TestOpenDBWaitReleased(c *C) {
+	db, err := OpenDBWait(s.path, 0, "first")
+	c.Assert(err, IsNil)
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		db.Close()
+	}()
+
+	db2, err := OpenDBWait(s.path, 5*time.Second, "second")
+	c.Assert(err, IsNil)
+	c.Check(readLockInfo(s.path).Operation, Equals, "second")
+	c.Check(db2.Close(), IsNil)
+}
+
+func (s *LockSuite) TestLockedErrorNoInfo(c *C) {
+	db, err := OpenDB(s.path)
+	c.Assert(err, IsNil)
+
+	_, err = OpenDBWait(s.path, 0, "second")
+	c.Check(err, ErrorMatches, "database is locked by another process, gave up after waiting for 0s")
+
+	c.Check(db.Close(), IsNil)
+}
+
+func (s *LockSuite) TestIsLocked(c *C) {
+	c.Check(isLocked(syscall.EAGAIN), Equals, true)
+	c.Check(isLocked(&os.PathError{Op: "open", Path: "LOCK", Err: syscall.EWOULDBLOCK}), Equals, true)
+	c.Check(isLocked(syscall.EACCES), Equals, false)
+	c.Check(isLocked(errors.New("resource temporarily unavailable")), Equals, false)
+}
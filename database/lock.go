@@ -0,0 +1,112 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Interval between attempts to open locked database
+var lockRetryInterval = 100 * time.Millisecond
+
+// LockInfo describes process holding the database open
+type LockInfo struct {
+	PID       int
+	Operation string
+	Since     time.Time
+}
+
+// LockedError is returned when database is still locked by another process
+// after lock timeout has expired
+type LockedError struct {
+	// Info is nil if process holding the lock hasn't left lock info
+	Info    *LockInfo
+	Timeout time.Duration
+}
+
+func (e *LockedError) Error() string {
+	if e.Info == nil {
+		return fmt.Sprintf("database is locked by another process, gave up after waiting for %s", e.Timeout)
+	}
+
+	return fmt.Sprintf("database is locked by another aptly process (pid %d, running '%s' since %s), gave up after waiting for %s",
+		e.Info.PID, e.Info.Operation, e.Info.Since.Format(time.RFC3339), e.Timeout)
+}
+
+// isLocked checks whether error is caused by database being opened by another process
+//
+// LevelDB returns error from non-blocking flock() on LOCK file as is, possibly
+// wrapped into *os.PathError.
+func isLocked(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+
+	errno, ok := err.(syscall.Errno)
+	return ok && (errno == syscall.EAGAIN || errno == syscall.EWOULDBLOCK)
+}
+
+// lockInfoPath returns path to lock info file of database
+func lockInfoPath(path string) string {
+	return filepath.Join(path, "LOCK.info")
+}
+
+// writeLockInfo leaves information about current process in database directory
+func writeLockInfo(path string, operation string) error {
+	data, err := json.Marshal(LockInfo{PID: os.Getpid(), Operation: operation, Since: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(lockInfoPath(path), data, 0644)
+}
+
+// readLockInfo reads information about process holding database, returns nil if it's not available
+func readLockInfo(path string) *LockInfo {
+	data, err := ioutil.ReadFile(lockInfoPath(path))
+	if err != nil {
+		return nil
+	}
+
+	info := &LockInfo{}
+	if json.Unmarshal(data, info) != nil {
+		return nil
+	}
+
+	return info
+}
+
+// OpenDBWait opens (creates) LevelDB database, waiting up to timeout for
+// another process to release it
+//
+// While database is open, operation is recorded in lock info file, so that
+// other processes could report which operation holds the lock.
+func OpenDBWait(path string, timeout time.Duration, operation string) (Storage, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		db, err := internalOpen(path)
+		if err == nil {
+			l := &levelDB{db: db, path: path, operation: operation}
+			if err = writeLockInfo(path, operation); err != nil {
+				l.Close()
+				return nil, fmt.Errorf("unable to write lock info: %s", err)
+			}
+			return l, nil
+		}
+
+		if !isLocked(err) {
+			return nil, err
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, &LockedError{Info: readLockInfo(path), Timeout: timeout}
+		}
+
+		time.Sleep(lockRetryInterval)
+	}
+}
@@ -14,6 +14,7 @@ type CollectionFactory struct {
 	snapshots      *SnapshotCollection
 	localRepos     *LocalRepoCollection
 	publishedRepos *PublishedRepoCollection
+	refCounts      *RefCounts
 }
 
 // NewCollectionFactory creates new factory
@@ -26,6 +27,10 @@ func (factory *CollectionFactory) PackageCollection() *PackageCollection {
 	factory.Lock()
 	defer factory.Unlock()
 
+	return factory.packageCollection()
+}
+
+func (factory *CollectionFactory) packageCollection() *PackageCollection {
 	if factory.packages == nil {
 		factory.packages = NewPackageCollection(factory.db)
 	}
@@ -33,6 +38,22 @@ func (factory *CollectionFactory) PackageCollection() *PackageCollection {
 	return factory.packages
 }
 
+// RefCounts returns (or creates) new RefCounts
+func (factory *CollectionFactory) RefCounts() *RefCounts {
+	factory.Lock()
+	defer factory.Unlock()
+
+	return factory.refCountsTracker()
+}
+
+func (factory *CollectionFactory) refCountsTracker() *RefCounts {
+	if factory.refCounts == nil {
+		factory.refCounts = NewRefCounts(factory.db, factory.packageCollection())
+	}
+
+	return factory.refCounts
+}
+
 // RemoteRepoCollection returns (or creates) new RemoteRepoCollection
 func (factory *CollectionFactory) RemoteRepoCollection() *RemoteRepoCollection {
 	factory.Lock()
@@ -40,6 +61,7 @@ func (factory *CollectionFactory) RemoteRepoCollection() *RemoteRepoCollection {
 
 	if factory.remoteRepos == nil {
 		factory.remoteRepos = NewRemoteRepoCollection(factory.db)
+		factory.remoteRepos.refCounts = factory.refCountsTracker()
 	}
 
 	return factory.remoteRepos
@@ -52,6 +74,7 @@ func (factory *CollectionFactory) SnapshotCollection() *SnapshotCollection {
 
 	if factory.snapshots == nil {
 		factory.snapshots = NewSnapshotCollection(factory.db)
+		factory.snapshots.refCounts = factory.refCountsTracker()
 	}
 
 	return factory.snapshots
@@ -64,6 +87,7 @@ func (factory *CollectionFactory) LocalRepoCollection() *LocalRepoCollection {
 
 	if factory.localRepos == nil {
 		factory.localRepos = NewLocalRepoCollection(factory.db)
+		factory.localRepos.refCounts = factory.refCountsTracker()
 	}
 
 	return factory.localRepos
@@ -76,11 +100,53 @@ func (factory *CollectionFactory) PublishedRepoCollection() *PublishedRepoCollec
 
 	if factory.publishedRepos == nil {
 		factory.publishedRepos = NewPublishedRepoCollection(factory.db)
+		factory.publishedRepos.refCounts = factory.refCountsTracker()
 	}
 
 	return factory.publishedRepos
 }
 
+// SnapshotAndClearLocalRepo adds snapshot and removes all packages from local repo
+//
+// Both changes are written to DB in single batch: if anything fails, nothing is
+// saved and local repo keeps its packages. Caller should hold locks for snapshot
+// and local repo collections.
+func (factory *CollectionFactory) SnapshotAndClearLocalRepo(snapshot *Snapshot, repo *LocalRepo) error {
+	snapshotCollection := factory.SnapshotCollection()
+	localRepoCollection := factory.LocalRepoCollection()
+	refCounts := factory.RefCounts()
+
+	err := snapshotCollection.checkUnique(snapshot)
+	if err != nil {
+		return err
+	}
+
+	refCounts.Lock()
+	defer refCounts.Unlock()
+
+	batch := factory.db.CreateBatch()
+	batchRefCounts := refCounts.inBatch(batch)
+
+	oldRefs, oldModifiedAt := repo.packageRefs, repo.ModifiedAt
+	repo.UpdateRefList(NewPackageRefList())
+
+	err = snapshotCollection.update(snapshot, batch, batchRefCounts)
+	if err == nil {
+		err = localRepoCollection.update(repo, batch, batchRefCounts)
+	}
+	if err == nil {
+		err = batch.Write()
+	}
+	if err != nil {
+		repo.packageRefs, repo.ModifiedAt = oldRefs, oldModifiedAt
+		return err
+	}
+
+	refCounts.commit(batchRefCounts)
+	snapshotCollection.list = append(snapshotCollection.list, snapshot)
+	return nil
+}
+
 // Flush removes all references to collections, so that memory could be reclaimed
 func (factory *CollectionFactory) Flush() {
 	factory.Lock()
@@ -91,4 +157,5 @@ func (factory *CollectionFactory) Flush() {
 	factory.remoteRepos = nil
 	factory.publishedRepos = nil
 	factory.packages = nil
+	factory.refCounts = nil
 }
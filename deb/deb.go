@@ -2,14 +2,17 @@ package deb
 
 import (
 	"archive/tar"
-	"bufio"
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"github.com/mkrautz/goar"
 	"github.com/smira/aptly/utils"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 )
 
 // GetControlFileFromDeb reads control file from deb package
@@ -61,39 +64,182 @@ func GetControlFileFromDeb(packageFile string) (Stanza, error) {
 	}
 }
 
+// ControlCache keeps control stanzas extracted from .deb packages
+//
+// Stanzas are keyed by MD5 of package file (the same way package pool
+// locates files) and are returned only if all checksums of the package
+// match, otherwise package is read again
+type ControlCache struct {
+	sync.Mutex
+	maxEntries int
+	entries    map[string]controlCacheEntry
+	order      []string
+}
+
+type controlCacheEntry struct {
+	checksums utils.ChecksumInfo
+	stanza    Stanza
+}
+
+// NewControlCache creates cache holding up to maxEntries stanzas
+func NewControlCache(maxEntries int) *ControlCache {
+	return &ControlCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]controlCacheEntry, maxEntries),
+	}
+}
+
+// DefaultControlCache is control cache used when importing packages
+var DefaultControlCache = NewControlCache(1024)
+
+// GetControlFileFromDeb returns control file from deb package with checksums,
+// reading it from the package only if it's not cached yet
+func (cache *ControlCache) GetControlFileFromDeb(packageFile string, checksums utils.ChecksumInfo) (Stanza, error) {
+	key := checksums.MD5
+
+	cache.Lock()
+	entry, ok := cache.entries[key]
+	cache.Unlock()
+
+	if ok && entry.checksums == checksums {
+		return entry.stanza.Copy(), nil
+	}
+
+	stanza, err := GetControlFileFromDeb(packageFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	if _, ok = cache.entries[key]; ok {
+		cache.remove(key)
+	}
+	if len(cache.order) >= cache.maxEntries {
+		delete(cache.entries, cache.order[0])
+		cache.order = cache.order[1:]
+	}
+	cache.entries[key] = controlCacheEntry{checksums: checksums, stanza: stanza.Copy()}
+	cache.order = append(cache.order, key)
+
+	return stanza, nil
+}
+
+// Invalidate removes cached stanza for package file with MD5 checksum,
+// it should be called when file is removed from package pool
+func (cache *ControlCache) Invalidate(hashMD5 string) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if _, ok := cache.entries[hashMD5]; ok {
+		cache.remove(hashMD5)
+	}
+}
+
+// Reset removes all cached stanzas
+func (cache *ControlCache) Reset() {
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.entries = make(map[string]controlCacheEntry, cache.maxEntries)
+	cache.order = nil
+}
+
+func (cache *ControlCache) remove(key string) {
+	delete(cache.entries, key)
+	for i := range cache.order {
+		if cache.order[i] == key {
+			cache.order = append(cache.order[:i], cache.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Len returns number of cached stanzas
+func (cache *ControlCache) Len() int {
+	cache.Lock()
+	defer cache.Unlock()
+
+	return len(cache.entries)
+}
+
+// Markers of PGP clearsigned message
+const (
+	pgpSignedMessageHeader = "-----BEGIN PGP SIGNED MESSAGE-----"
+	pgpSignatureHeader     = "-----BEGIN PGP SIGNATURE-----"
+)
+
+// stripClearsign removes PGP clearsign wrapper, returning cleartext
+//
+// Leading empty lines are skipped, armor headers (Hash:) are dropped and
+// dash-escaped lines are unescaped. If contents are not clearsigned, they
+// are returned as is with signed == false.
+func stripClearsign(contents []byte) (text []byte, signed bool, err error) {
+	lines := strings.Split(string(contents), "\n")
+
+	start := 0
+	for start < len(lines) && strings.TrimSpace(lines[start]) == "" {
+		start++
+	}
+
+	if start == len(lines) || strings.TrimRight(lines[start], " \t\r") != pgpSignedMessageHeader {
+		return contents, false, nil
+	}
+
+	// armor headers are terminated by empty line
+	i := start + 1
+	for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+		i++
+	}
+	i++
+
+	result := []string{}
+	for ; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r")
+		if strings.TrimRight(line, " \t") == pgpSignatureHeader {
+			return []byte(strings.Join(result, "\n") + "\n"), true, nil
+		}
+		result = append(result, strings.TrimPrefix(line, "- "))
+	}
+
+	return nil, true, fmt.Errorf("malformed clearsigned file: signature not found")
+}
+
 // GetControlFileFromDsc reads control file from dsc package
+//
+// Clearsigned .dsc files are unwrapped before parsing, signature is verified
+// only if verifier is not nil.
 func GetControlFileFromDsc(dscFile string, verifier utils.Verifier) (Stanza, error) {
-	file, err := os.Open(dscFile)
+	contents, err := ioutil.ReadFile(dscFile)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	line, err := bufio.NewReader(file).ReadString('\n')
+	text, signed, err := stripClearsign(contents)
 	if err != nil {
 		return nil, err
 	}
 
-	file.Seek(0, 0)
-
-	var text *os.File
+	if verifier != nil {
+		if !signed {
+			return nil, fmt.Errorf("unable to verify %s: file is not signed", filepath.Base(dscFile))
+		}
 
-	if strings.Index(line, "BEGIN PGP SIGN") != -1 {
-		text, err = verifier.ExtractClearsigned(file)
+		err = verifier.VerifyClearsigned(bytes.NewReader(contents))
 		if err != nil {
 			return nil, err
 		}
-		defer text.Close()
-	} else {
-		text = file
 	}
 
-	reader := NewControlFileReader(text)
+	reader := NewControlFileReader(bytes.NewReader(text))
 	stanza, err := reader.ReadStanza()
 	if err != nil {
 		return nil, err
 	}
+	if stanza == nil {
+		return nil, fmt.Errorf("unable to parse %s: no control fields", filepath.Base(dscFile))
+	}
 
 	return stanza, nil
-
 }
@@ -1,7 +1,9 @@
 package deb
 
 import (
+	"fmt"
 	"github.com/smira/aptly/utils"
+	"io"
 	"path/filepath"
 	"runtime"
 
@@ -35,23 +37,129 @@ func (s *DebSuite) TestGetControlFileFromDeb(c *C) {
 	c.Check(st["Package"], Equals, "libboost-program-options-dev")
 }
 
-func (s *DebSuite) TestGetControlFileFromDsc(c *C) {
-	verifier := &utils.GpgVerifier{}
+type rejectingVerifier struct {
+	NullVerifier
+}
 
-	_, err := GetControlFileFromDsc("/no/such/file", verifier)
+func (r *rejectingVerifier) VerifyClearsigned(clearsigned io.Reader) error {
+	return fmt.Errorf("verification of clearsigned file failed")
+}
+
+func (s *DebSuite) TestGetControlFileFromDsc(c *C) {
+	_, err := GetControlFileFromDsc("/no/such/file", nil)
 	c.Check(err, ErrorMatches, ".*no such file or directory")
 
 	_, _File, _, _ := runtime.Caller(0)
-	_, err = GetControlFileFromDsc(_File, verifier)
+	_, err = GetControlFileFromDsc(_File, nil)
 	c.Check(err, ErrorMatches, "malformed stanza syntax")
 
-	st, err := GetControlFileFromDsc(s.dscFile, verifier)
+	st, err := GetControlFileFromDsc(s.dscFile, nil)
 	c.Check(err, IsNil)
 	c.Check(st["Version"], Equals, "0.6.1-1.3")
 	c.Check(st["Source"], Equals, "pyspi")
 
-	st, err = GetControlFileFromDsc(s.dscFileNoSign, verifier)
+	st, err = GetControlFileFromDsc(s.dscFileNoSign, nil)
 	c.Check(err, IsNil)
 	c.Check(st["Version"], Equals, "0.6.1-1.4")
 	c.Check(st["Source"], Equals, "pyspi")
 }
+
+func (s *DebSuite) TestGetControlFileFromDscVerify(c *C) {
+	st, err := GetControlFileFromDsc(s.dscFile, &NullVerifier{})
+	c.Check(err, IsNil)
+	c.Check(st["Source"], Equals, "pyspi")
+
+	_, err = GetControlFileFromDsc(s.dscFile, &rejectingVerifier{})
+	c.Check(err, ErrorMatches, "verification of clearsigned file failed")
+
+	_, err = GetControlFileFromDsc(s.dscFileNoSign, &NullVerifier{})
+	c.Check(err, ErrorMatches, "unable to verify pyspi-0.6.1-1.3.stripped.dsc: file is not signed")
+}
+
+func (s *DebSuite) TestStripClearsign(c *C) {
+	text, signed, err := stripClearsign([]byte("Source: a\nVersion: 1.0\n"))
+	c.Check(err, IsNil)
+	c.Check(signed, Equals, false)
+	c.Check(string(text), Equals, "Source: a\nVersion: 1.0\n")
+
+	text, signed, err = stripClearsign([]byte("\n-----BEGIN PGP SIGNED MESSAGE-----\r\nHash: SHA256\r\n\r\n" +
+		"Source: a\r\nVersion: 1.0\r\n- -----\r\n-----BEGIN PGP SIGNATURE-----\r\n\r\nabcd\r\n-----END PGP SIGNATURE-----\r\n"))
+	c.Check(err, IsNil)
+	c.Check(signed, Equals, true)
+	c.Check(string(text), Equals, "Source: a\nVersion: 1.0\n-----\n")
+
+	_, _, err = stripClearsign([]byte("-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA1\n\nSource: a\n"))
+	c.Check(err, ErrorMatches, "malformed clearsigned file: signature not found")
+}
+
+func (s *DebSuite) TestControlCache(c *C) {
+	cache := NewControlCache(2)
+
+	checksums, err := utils.ChecksumsForFile(s.debFile)
+	c.Assert(err, IsNil)
+
+	st, err := cache.GetControlFileFromDeb(s.debFile, checksums)
+	c.Assert(err, IsNil)
+	c.Check(st["Package"], Equals, "libboost-program-options-dev")
+	c.Check(cache.Len(), Equals, 1)
+
+	expected, _ := GetControlFileFromDeb(s.debFile)
+	c.Check(st, DeepEquals, expected)
+
+	// modifications of returned stanza don't affect cached copy
+	delete(st, "Package")
+
+	st, err = cache.GetControlFileFromDeb(s.debFile, checksums)
+	c.Assert(err, IsNil)
+	c.Check(st, DeepEquals, expected)
+	c.Check(cache.Len(), Equals, 1)
+
+	// package contents changed: different checksum, cache miss
+	_, err = cache.GetControlFileFromDeb("/no/such/file", utils.ChecksumInfo{MD5: "123"})
+	c.Check(err, ErrorMatches, ".*no such file or directory")
+	c.Check(cache.Len(), Equals, 1)
+
+	// same MD5, but other checksums don't match: package is read again
+	mismatched := checksums
+	mismatched.SHA256 = "abcd"
+	_, err = cache.GetControlFileFromDeb("/no/such/file", mismatched)
+	c.Check(err, ErrorMatches, ".*no such file or directory")
+
+	_, err = cache.GetControlFileFromDeb(s.debFile, mismatched)
+	c.Assert(err, IsNil)
+	c.Check(cache.Len(), Equals, 1)
+	c.Check(cache.entries[checksums.MD5].checksums, Equals, mismatched)
+
+	cache.Invalidate(checksums.MD5)
+	c.Check(cache.Len(), Equals, 0)
+
+	// eviction of oldest entries
+	for _, sum := range []string{"1", "2", "3"} {
+		_, err = cache.GetControlFileFromDeb(s.debFile, utils.ChecksumInfo{MD5: sum})
+		c.Assert(err, IsNil)
+	}
+	c.Check(cache.Len(), Equals, 2)
+	_, ok := cache.entries["1"]
+	c.Check(ok, Equals, false)
+	_, ok = cache.entries["3"]
+	c.Check(ok, Equals, true)
+
+	cache.Reset()
+	c.Check(cache.Len(), Equals, 0)
+}
+
+func (s *DebSuite) BenchmarkGetControlFileFromDeb(c *C) {
+	for i := 0; i < c.N; i++ {
+		GetControlFileFromDeb(s.debFile)
+	}
+}
+
+func (s *DebSuite) BenchmarkControlCache(c *C) {
+	cache := NewControlCache(1)
+	checksums, _ := utils.ChecksumsForFile(s.debFile)
+
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		cache.GetControlFileFromDeb(s.debFile, checksums)
+	}
+}
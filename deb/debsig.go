@@ -0,0 +1,79 @@
+package deb
+
+import (
+	"fmt"
+	"github.com/smira/aptly/utils"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Exit codes of debsig-verify(1)
+const (
+	debsigNoSignatures  = 10
+	debsigUnknownOrigin = 11
+	debsigNoPolicies    = 12
+	debsigBadSignature  = 13
+)
+
+// DebsigVerifier verifies signatures embedded into .deb packages (debsig format)
+// against policies using debsig-verify(1)
+type DebsigVerifier struct {
+	PoliciesDir string
+	KeyringsDir string
+}
+
+// NewDebsigVerifier creates verifier from configuration, returns nil if
+// verification is not configured
+func NewDebsigVerifier(config *utils.DebsigConfig) *DebsigVerifier {
+	if config == nil || config.PoliciesDir == "" {
+		return nil
+	}
+
+	return &DebsigVerifier{PoliciesDir: config.PoliciesDir, KeyringsDir: config.KeyringsDir}
+}
+
+// Init verifies availability of debsig-verify
+func (v *DebsigVerifier) Init() error {
+	_, err := exec.LookPath("debsig-verify")
+	if err != nil {
+		return fmt.Errorf("unable to find debsig-verify: %s (is debsig-verify installed?)", err)
+	}
+
+	return nil
+}
+
+// VerifyPackage checks that package file is signed and signature matches policy
+func (v *DebsigVerifier) VerifyPackage(packageFile string) error {
+	args := []string{"--policies-dir", v.PoliciesDir}
+	if v.KeyringsDir != "" {
+		args = append(args, "--keyrings-dir", v.KeyringsDir)
+	}
+	args = append(args, packageFile)
+
+	output, err := exec.Command("debsig-verify", args...).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return fmt.Errorf("unable to execute debsig-verify: %s", err)
+	}
+
+	status := -1
+	if waitStatus, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+		status = waitStatus.ExitStatus()
+	}
+
+	switch status {
+	case debsigNoSignatures:
+		return fmt.Errorf("package is not signed")
+	case debsigUnknownOrigin, debsigNoPolicies:
+		return fmt.Errorf("no policy matches package signature")
+	case debsigBadSignature:
+		return fmt.Errorf("bad signature")
+	}
+
+	return fmt.Errorf("debsig-verify failed: %s: %s", err, strings.TrimSpace(string(output)))
+}
@@ -0,0 +1,186 @@
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/smira/aptly/aptly"
+	"github.com/smira/aptly/database"
+	"github.com/smira/aptly/files"
+	"github.com/smira/aptly/utils"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	. "gopkg.in/check.v1"
+)
+
+const debsigKeyID = "21DBB89C16DB3E6D"
+
+type DebsigSuite struct {
+	verifier *DebsigVerifier
+	gpgHome  string
+	reporter *aptly.RecordingResultReporter
+}
+
+var _ = Suite(&DebsigSuite{})
+
+func (s *DebsigSuite) SetUpTest(c *C) {
+	s.reporter = &aptly.RecordingResultReporter{
+		Warnings: []string{},
+		Adds:     []string{},
+		Removes:  []string{},
+	}
+
+	if _, err := exec.LookPath("debsig-verify"); err != nil {
+		c.Skip("debsig-verify not available")
+	}
+	if _, err := exec.LookPath("gpg"); err != nil {
+		c.Skip("gpg not available")
+	}
+
+	_, _File, _, _ := runtime.Caller(0)
+	root := c.MkDir()
+
+	s.gpgHome = filepath.Join(root, "gnupg")
+	c.Assert(os.Mkdir(s.gpgHome, 0700), IsNil)
+	s.gpg(c, "--import", filepath.Join(filepath.Dir(_File), "../system/files/aptly.sec"))
+
+	keyringsDir := filepath.Join(root, "keyrings", debsigKeyID)
+	c.Assert(os.MkdirAll(keyringsDir, 0755), IsNil)
+	s.gpg(c, "--output", filepath.Join(keyringsDir, "debsig.gpg"), "--export", debsigKeyID)
+
+	policiesDir := filepath.Join(root, "policies", debsigKeyID)
+	c.Assert(os.MkdirAll(policiesDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(policiesDir, "aptly.pol"), []byte(fmt.Sprintf(`<?xml version="1.0"?>
+<!DOCTYPE Policy SYSTEM "https://www.debian.org/debsig/1.0/policy.dtd">
+<Policy xmlns="https://www.debian.org/debsig/1.0/">
+  <Origin Name="aptly" id="%[1]s" Description="aptly tester"/>
+  <Selection>
+    <Required Type="origin" File="debsig.gpg" id="%[1]s"/>
+  </Selection>
+  <Verification MinOptional="0">
+    <Required Type="origin" File="debsig.gpg" id="%[1]s"/>
+  </Verification>
+</Policy>
+`, debsigKeyID)), 0644), IsNil)
+
+	s.verifier = NewDebsigVerifier(&utils.DebsigConfig{
+		PoliciesDir: filepath.Join(root, "policies"),
+		KeyringsDir: filepath.Join(root, "keyrings"),
+	})
+	c.Assert(s.verifier.Init(), IsNil)
+}
+
+func (s *DebsigSuite) gpg(c *C, args ...string) {
+	output, err := exec.Command("gpg", append([]string{"--homedir", s.gpgHome, "--batch", "--no-tty"}, args...)...).CombinedOutput()
+	c.Assert(err, IsNil, Commentf("gpg: %s", output))
+}
+
+// appendArMember appends member to ar archive
+func appendArMember(archive *bytes.Buffer, name string, data []byte) {
+	fmt.Fprintf(archive, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", name, 0, 0, 0, "100644", len(data))
+	archive.Write(data)
+	if len(data)%2 == 1 {
+		archive.WriteByte('\n')
+	}
+}
+
+// writeDebsigTestDeb builds .deb package with (empty) data member, optionally
+// embedding debsig signature of type origin
+func (s *DebsigSuite) writeDebsigTestDeb(c *C, path, name string, sign, tamper bool) {
+	c.Assert(writeTestDeb(path, name, "1.0", "amd64"), IsNil)
+
+	contents, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+
+	var dataTar bytes.Buffer
+	gz := gzip.NewWriter(&dataTar)
+	tar.NewWriter(gz).Close()
+	gz.Close()
+
+	deb := bytes.NewBuffer(contents)
+	appendArMember(deb, "data.tar.gz", dataTar.Bytes())
+
+	if sign {
+		// signature covers concatenated contents of debian-binary, control.tar.gz and data.tar.gz
+		var signed bytes.Buffer
+		for rest := deb.Bytes()[8:]; len(rest) > 0; {
+			var size int
+			fmt.Sscanf(string(rest[48:58]), "%d", &size)
+			signed.Write(rest[60 : 60+size])
+			rest = rest[60+size+size%2:]
+		}
+
+		if tamper {
+			// signature of different contents doesn't match the package
+			signed.WriteString("tampered")
+		}
+
+		payload := filepath.Join(c.MkDir(), "payload")
+		c.Assert(ioutil.WriteFile(payload, signed.Bytes(), 0644), IsNil)
+		s.gpg(c, "--output", payload+".sig", "--detach-sign", payload)
+
+		signature, err := ioutil.ReadFile(payload + ".sig")
+		c.Assert(err, IsNil)
+
+		appendArMember(deb, "_gpgorigin", signature)
+	}
+
+	c.Assert(ioutil.WriteFile(path, deb.Bytes(), 0644), IsNil)
+}
+
+func (s *DebsigSuite) TestVerifySigned(c *C) {
+	debFile := filepath.Join(c.MkDir(), "signed_1.0_amd64.deb")
+	s.writeDebsigTestDeb(c, debFile, "signed", true, false)
+
+	c.Check(s.verifier.VerifyPackage(debFile), IsNil)
+}
+
+func (s *DebsigSuite) TestVerifyUnsigned(c *C) {
+	debFile := filepath.Join(c.MkDir(), "unsigned_1.0_amd64.deb")
+	s.writeDebsigTestDeb(c, debFile, "unsigned", false, false)
+
+	c.Check(s.verifier.VerifyPackage(debFile), ErrorMatches, "package is not signed")
+}
+
+func (s *DebsigSuite) TestVerifyBadSignature(c *C) {
+	debFile := filepath.Join(c.MkDir(), "signed_1.0_amd64.deb")
+	s.writeDebsigTestDeb(c, debFile, "signed", true, true)
+
+	c.Check(s.verifier.VerifyPackage(debFile), ErrorMatches, "bad signature")
+}
+
+func (s *DebsigSuite) TestImportPackageFiles(c *C) {
+	dir := c.MkDir()
+	signed := filepath.Join(dir, "signed_1.0_amd64.deb")
+	s.writeDebsigTestDeb(c, signed, "signed", true, false)
+	unsigned := filepath.Join(dir, "unsigned_1.0_amd64.deb")
+	s.writeDebsigTestDeb(c, unsigned, "unsigned", false, false)
+
+	db, _ := database.OpenDB(c.MkDir())
+	defer db.Close()
+	list := NewPackageList()
+
+	_, failedFiles, err := ImportPackageFiles(list, []string{signed, unsigned},
+		ImportOptions{ConflictPolicy: ConflictFail, DebsigVerifier: s.verifier}, files.NewPackagePool(c.MkDir()),
+		NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(failedFiles, DeepEquals, []string{unsigned})
+	c.Check(s.reporter.Adds, DeepEquals, []string{"signed_1.0_amd64 added"})
+	c.Check(s.reporter.Warnings, DeepEquals, []string{"Unable to add unsigned_1.0_amd64.deb: signature verification failed: package is not signed"})
+}
+
+type DebsigConfigSuite struct{}
+
+var _ = Suite(&DebsigConfigSuite{})
+
+func (s *DebsigConfigSuite) TestNewDebsigVerifier(c *C) {
+	c.Check(NewDebsigVerifier(nil), IsNil)
+	c.Check(NewDebsigVerifier(&utils.DebsigConfig{KeyringsDir: "/keyrings"}), IsNil)
+	c.Check(NewDebsigVerifier(&utils.DebsigConfig{PoliciesDir: "/policies", KeyringsDir: "/keyrings"}), DeepEquals,
+		&DebsigVerifier{PoliciesDir: "/policies", KeyringsDir: "/keyrings"})
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"errors"
 	"io"
+	"sort"
 	"strings"
 )
 
@@ -21,6 +22,8 @@ var (
 		"Version",
 		"Codename",
 		"Date",
+		"NotAutomatic",
+		"ButAutomaticUpgrades",
 		"Architectures",
 		"Architecture",
 		"Components",
@@ -93,9 +96,7 @@ func (s Stanza) Copy() (result Stanza) {
 
 // Write single field from Stanza to writer
 func writeField(w *bufio.Writer, field, value string) (err error) {
-	_, multiline := multilineFields[field]
-
-	if !multiline {
+	if !isMultilineField(field) {
 		_, err = w.WriteString(field + ": " + value + "\n")
 	} else {
 		if !strings.HasSuffix(value, "\n") {
@@ -108,6 +109,9 @@ func writeField(w *bufio.Writer, field, value string) (err error) {
 }
 
 // WriteTo saves stanza back to stream, modifying itself on the fly
+//
+// Fields are written in canonical order (as apt does it), fields missing from
+// canonical order follow sorted by name, so output doesn't depend on map iteration order
 func (s Stanza) WriteTo(w *bufio.Writer, isSource, isRelease bool) error {
 	canonicalOrder := canonicalOrderBinary
 	if isSource {
@@ -128,8 +132,14 @@ func (s Stanza) WriteTo(w *bufio.Writer, isSource, isRelease bool) error {
 		}
 	}
 
-	for field, value := range s {
-		err := writeField(w, field, value)
+	fields := make([]string, 0, len(s))
+	for field := range s {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		err := writeField(w, field, s[field])
 		if err != nil {
 			return err
 		}
@@ -157,6 +167,16 @@ func init() {
 	multilineFields["MD5Sum"] = true
 }
 
+// isMultilineField checks whether continuation lines of the field should be kept
+// verbatim, translated descriptions (Description-en, ...) are multiline as well
+func isMultilineField(field string) bool {
+	if multilineFields[field] {
+		return true
+	}
+
+	return strings.HasPrefix(field, "Description-") && field != "Description-md5"
+}
+
 // ControlFileReader implements reading of control files stanza by stanza
 type ControlFileReader struct {
 	scanner *bufio.Scanner
@@ -196,7 +216,7 @@ func (c *ControlFileReader) ReadStanza() (Stanza, error) {
 				return nil, ErrMalformedStanza
 			}
 			lastField = parts[0]
-			_, lastFieldMultiline = multilineFields[lastField]
+			lastFieldMultiline = isMultilineField(lastField)
 			if lastFieldMultiline {
 				stanza[lastField] = parts[1]
 				if parts[1] != "" {
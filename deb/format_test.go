@@ -123,6 +123,94 @@ func (s *ControlFileSuite) TestReadWriteStanza(c *C) {
 	c.Assert(strings.HasPrefix(str, "Package: "), Equals, true)
 }
 
+const multilineDescription = `Package: foo
+Priority: optional
+Section: utils
+Maintainer: Aptly Tester <test@aptly.info>
+Architecture: amd64
+Version: 1.0-1
+Filename: pool/main/f/foo/foo_1.0-1_amd64.deb
+Size: 1024
+MD5sum: 0035d7822b2f8f0ec4013f270fd650c2
+Description: summary line  
+ First paragraph of long description,
+ continued on the next line.
+ .
+   verbatim indented
+	  line with tab
+ .
+ Last paragraph.
+Description-md5: 2be7e62f455351435b1e055745d3e81c
+`
+
+const translatedDescription = `Package: foo
+Description-md5: 2be7e62f455351435b1e055745d3e81c
+Description-de: Zusammenfassung
+ Erster Absatz.
+ .
+   wörtlich eingerückt
+`
+
+func (s *ControlFileSuite) TestReadWriteMultilineDescription(c *C) {
+	for _, input := range []string{multilineDescription, translatedDescription} {
+		stanza, err := NewControlFileReader(bytes.NewBufferString(input)).ReadStanza()
+		c.Assert(err, IsNil)
+
+		buf := &bytes.Buffer{}
+		w := bufio.NewWriter(buf)
+		c.Assert(stanza.Copy().WriteTo(w, false, false), IsNil)
+		c.Assert(w.Flush(), IsNil)
+
+		if strings.HasPrefix(input, "Package: foo\nPriority") {
+			c.Check(buf.String(), Equals, input)
+		} else {
+			// order of non-canonical fields isn't fixed
+			c.Check(strings.Split(buf.String(), "\n"), HasLen, len(strings.Split(input, "\n")))
+			c.Check(strings.Contains(buf.String(), "Description-de: Zusammenfassung\n Erster Absatz.\n .\n   wörtlich eingerückt\n"), Equals, true)
+		}
+	}
+
+	// round-trip via package, as when publishing mirrored packages
+	stanza, _ := NewControlFileReader(bytes.NewBufferString(multilineDescription)).ReadStanza()
+	buf := &bytes.Buffer{}
+	w := bufio.NewWriter(buf)
+	c.Assert(NewPackageFromControlFile(stanza).Stanza().WriteTo(w, false, false), IsNil)
+	c.Assert(w.Flush(), IsNil)
+	c.Check(buf.String(), Equals, multilineDescription)
+}
+
+func (s *ControlFileSuite) TestWriteToOrder(c *C) {
+	fields := []string{"Package", "Version", "Homepage", "Tag", "Bugs", "Origin", "Vcs-Git", "Multi-Arch", "Description"}
+
+	write := func(order []string) string {
+		stanza := Stanza{}
+		for _, field := range order {
+			stanza[field] = "value of " + field
+		}
+
+		buf := &bytes.Buffer{}
+		w := bufio.NewWriter(buf)
+		c.Assert(stanza.WriteTo(w, false, false), IsNil)
+		c.Assert(w.Flush(), IsNil)
+		return buf.String()
+	}
+
+	expected := write(fields)
+	c.Check(expected, Equals, "Package: value of Package\nVersion: value of Version\nDescription:value of Description\n"+
+		"Bugs: value of Bugs\nHomepage: value of Homepage\nMulti-Arch: value of Multi-Arch\nOrigin: value of Origin\n"+
+		"Tag: value of Tag\nVcs-Git: value of Vcs-Git\n")
+
+	reversed := make([]string, len(fields))
+	for i := range fields {
+		reversed[len(fields)-1-i] = fields[i]
+	}
+
+	for i := 0; i < 10; i++ {
+		c.Check(write(fields), Equals, expected)
+		c.Check(write(reversed), Equals, expected)
+	}
+}
+
 func (s *ControlFileSuite) BenchmarkReadStanza(c *C) {
 	for i := 0; i < c.N; i++ {
 		reader := bytes.NewBufferString(controlFile)
@@ -1,61 +1,125 @@
 package deb
 
 import (
+	"bufio"
 	"code.google.com/p/gographviz"
 	"fmt"
+	"io"
+	"path"
+	"sort"
 	"strings"
 )
 
-func BuildGraph(collectionFactory *CollectionFactory) (gographviz.Interface, error) {
-	var err error
+// GraphOptions limits part of relationship graph being built
+type GraphOptions struct {
+	// Focus is shell pattern (as in path.Match) of names of objects the graph
+	// is seeded with, empty pattern selects all the objects
+	Focus string
+	// Depth is maximum number of relationships followed from focused objects,
+	// negative depth means no limit
+	Depth int
+	// MaxNodes is maximum number of nodes in graph, zero means no limit
+	MaxNodes int
+}
 
-	graph := gographviz.NewEscape()
-	graph.SetDir(true)
-	graph.SetName("aptly")
+// GraphTooLargeError is returned when graph has more nodes than allowed by options
+type GraphTooLargeError struct {
+	Nodes    int
+	MaxNodes int
+}
+
+func (e *GraphTooLargeError) Error() string {
+	return fmt.Sprintf("graph has %d nodes, more than maximum of %d, narrow it down with focus and depth", e.Nodes, e.MaxNodes)
+}
+
+// GraphNode is object (mirror, local repo, snapshot or published repository)
+// in the graph of relationships
+type GraphNode struct {
+	UUID string
+	// Type is one of "mirror", "repo", "snapshot" or "published"
+	Type          string
+	Name          string
+	Comment       string   `json:",omitempty"`
+	Description   string   `json:",omitempty"`
+	SourceKind    string   `json:",omitempty"`
+	ArchiveRoot   string   `json:",omitempty"`
+	Prefix        string   `json:",omitempty"`
+	Distribution  string   `json:",omitempty"`
+	Components    []string `json:",omitempty"`
+	Architectures []string `json:",omitempty"`
+	// NumPackages is not set for published repositories
+	NumPackages int `json:",omitempty"`
+}
+
+// GraphEdge is relationship between objects: From is the source of To
+type GraphEdge struct {
+	From, To string
+}
+
+// graphNode is object of the graph before it's loaded, object is loaded completely
+// only when it's selected, as loading list of packages is expensive
+type graphNode struct {
+	id   string
+	name string
+	load func() (*GraphNode, error)
+}
+
+// collectGraph lists all the objects and relationships between them
+func collectGraph(collectionFactory *CollectionFactory) ([]graphNode, []GraphEdge, error) {
+	var (
+		nodes []graphNode
+		edges []GraphEdge
+	)
 
 	existingNodes := map[string]bool{}
 
-	err = collectionFactory.RemoteRepoCollection().ForEach(func(repo *RemoteRepo) error {
-		err := collectionFactory.RemoteRepoCollection().LoadComplete(repo)
-		if err != nil {
-			return err
-		}
+	err := collectionFactory.RemoteRepoCollection().ForEach(func(repo *RemoteRepo) error {
+		nodes = append(nodes, graphNode{id: repo.UUID, name: repo.Name, load: func() (*GraphNode, error) {
+			err := collectionFactory.RemoteRepoCollection().LoadComplete(repo)
+			if err != nil {
+				return nil, err
+			}
 
-		graph.AddNode("aptly", repo.UUID, map[string]string{
-			"shape":     "Mrecord",
-			"style":     "filled",
-			"fillcolor": "darkgoldenrod1",
-			"label": fmt.Sprintf("{Mirror %s|url: %s|dist: %s|comp: %s|arch: %s|pkgs: %d}",
-				repo.Name, repo.ArchiveRoot, repo.Distribution, strings.Join(repo.Components, ", "),
-				strings.Join(repo.Architectures, ", "), repo.NumPackages()),
-		})
+			return &GraphNode{
+				UUID:          repo.UUID,
+				Type:          "mirror",
+				Name:          repo.Name,
+				ArchiveRoot:   repo.ArchiveRoot,
+				Distribution:  repo.Distribution,
+				Components:    repo.Components,
+				Architectures: repo.Architectures,
+				NumPackages:   repo.NumPackages(),
+			}, nil
+		}})
 		existingNodes[repo.UUID] = true
 		return nil
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	err = collectionFactory.LocalRepoCollection().ForEach(func(repo *LocalRepo) error {
-		err := collectionFactory.LocalRepoCollection().LoadComplete(repo)
-		if err != nil {
-			return err
-		}
+		nodes = append(nodes, graphNode{id: repo.UUID, name: repo.Name, load: func() (*GraphNode, error) {
+			err := collectionFactory.LocalRepoCollection().LoadComplete(repo)
+			if err != nil {
+				return nil, err
+			}
 
-		graph.AddNode("aptly", repo.UUID, map[string]string{
-			"shape":     "Mrecord",
-			"style":     "filled",
-			"fillcolor": "mediumseagreen",
-			"label": fmt.Sprintf("{Repo %s|comment: %s|pkgs: %d}",
-				repo.Name, repo.Comment, repo.NumPackages()),
-		})
+			return &GraphNode{
+				UUID:        repo.UUID,
+				Type:        "repo",
+				Name:        repo.Name,
+				Comment:     repo.Comment,
+				NumPackages: repo.NumPackages(),
+			}, nil
+		}})
 		existingNodes[repo.UUID] = true
 		return nil
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	collectionFactory.SnapshotCollection().ForEach(func(snapshot *Snapshot) error {
@@ -64,28 +128,27 @@ func BuildGraph(collectionFactory *CollectionFactory) (gographviz.Interface, err
 	})
 
 	err = collectionFactory.SnapshotCollection().ForEach(func(snapshot *Snapshot) error {
-		err := collectionFactory.SnapshotCollection().LoadComplete(snapshot)
-		if err != nil {
-			return err
-		}
-
-		description := snapshot.Description
-		if snapshot.SourceKind == "repo" {
-			description = "Snapshot from repo"
-		}
+		nodes = append(nodes, graphNode{id: snapshot.UUID, name: snapshot.Name, load: func() (*GraphNode, error) {
+			err := collectionFactory.SnapshotCollection().LoadComplete(snapshot)
+			if err != nil {
+				return nil, err
+			}
 
-		graph.AddNode("aptly", snapshot.UUID, map[string]string{
-			"shape":     "Mrecord",
-			"style":     "filled",
-			"fillcolor": "cadetblue1",
-			"label":     fmt.Sprintf("{Snapshot %s|%s|pkgs: %d}", snapshot.Name, description, snapshot.NumPackages()),
-		})
+			return &GraphNode{
+				UUID:        snapshot.UUID,
+				Type:        "snapshot",
+				Name:        snapshot.Name,
+				Description: snapshot.Description,
+				SourceKind:  snapshot.SourceKind,
+				NumPackages: snapshot.NumPackages(),
+			}, nil
+		}})
 
 		if snapshot.SourceKind == "repo" || snapshot.SourceKind == "local" || snapshot.SourceKind == "snapshot" {
 			for _, uuid := range snapshot.SourceIDs {
 				_, exists := existingNodes[uuid]
 				if exists {
-					graph.AddEdge(uuid, snapshot.UUID, true, nil)
+					edges = append(edges, GraphEdge{From: uuid, To: snapshot.UUID})
 				}
 			}
 		}
@@ -93,27 +156,216 @@ func BuildGraph(collectionFactory *CollectionFactory) (gographviz.Interface, err
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	collectionFactory.PublishedRepoCollection().ForEach(func(repo *PublishedRepo) error {
-		graph.AddNode("aptly", repo.UUID, map[string]string{
-			"shape":     "Mrecord",
-			"style":     "filled",
-			"fillcolor": "darkolivegreen1",
-			"label": fmt.Sprintf("{Published %s/%s|comp: %s|arch: %s}", repo.Prefix, repo.Distribution,
-				strings.Join(repo.Components(), " "), strings.Join(repo.Architectures, ", ")),
-		})
+		nodes = append(nodes, graphNode{id: repo.UUID, name: repo.Prefix + "/" + repo.Distribution, load: func() (*GraphNode, error) {
+			return &GraphNode{
+				UUID:          repo.UUID,
+				Type:          "published",
+				Name:          repo.Prefix + "/" + repo.Distribution,
+				Prefix:        repo.Prefix,
+				Distribution:  repo.Distribution,
+				Components:    repo.Components(),
+				Architectures: repo.Architectures,
+			}, nil
+		}})
 
 		for _, uuid := range repo.Sources {
 			_, exists := existingNodes[uuid]
 			if exists {
-				graph.AddEdge(uuid, repo.UUID, true, nil)
+				edges = append(edges, GraphEdge{From: uuid, To: repo.UUID})
 			}
 		}
 
 		return nil
 	})
 
+	return nodes, edges, nil
+}
+
+// selectGraphNodes returns IDs of nodes matching options: nodes with names
+// matching focus pattern and nodes related to them within depth, in both
+// directions
+func selectGraphNodes(nodes []graphNode, edges []GraphEdge, options *GraphOptions) (map[string]bool, error) {
+	selected := map[string]bool{}
+
+	if options == nil || options.Focus == "" {
+		for _, node := range nodes {
+			selected[node.id] = true
+		}
+	} else {
+		var current []string
+
+		for _, node := range nodes {
+			matched, err := path.Match(options.Focus, node.name)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse focus pattern: %s", err)
+			}
+			if matched {
+				selected[node.id] = true
+				current = append(current, node.id)
+			}
+		}
+
+		neighbours := map[string][]string{}
+		for _, edge := range edges {
+			neighbours[edge.From] = append(neighbours[edge.From], edge.To)
+			neighbours[edge.To] = append(neighbours[edge.To], edge.From)
+		}
+
+		for depth := 0; len(current) > 0 && (options.Depth < 0 || depth < options.Depth); depth++ {
+			var next []string
+
+			for _, id := range current {
+				for _, neighbour := range neighbours[id] {
+					if !selected[neighbour] {
+						selected[neighbour] = true
+						next = append(next, neighbour)
+					}
+				}
+			}
+
+			current = next
+		}
+	}
+
+	if options != nil && options.MaxNodes > 0 && len(selected) > options.MaxNodes {
+		return nil, &GraphTooLargeError{Nodes: len(selected), MaxNodes: options.MaxNodes}
+	}
+
+	return selected, nil
+}
+
+// BuildGraphNodes lists objects and relationships between mirrors, local repos, snapshots
+// and published repositories, options (if not nil) limit part of the graph being built
+func BuildGraphNodes(collectionFactory *CollectionFactory, options *GraphOptions) ([]*GraphNode, []GraphEdge, error) {
+	nodes, edges, err := collectGraph(collectionFactory)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	selected, err := selectGraphNodes(nodes, edges, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := []*GraphNode{}
+	for _, node := range nodes {
+		if !selected[node.id] {
+			continue
+		}
+
+		loaded, err := node.load()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		result = append(result, loaded)
+	}
+
+	resultEdges := []GraphEdge{}
+	for _, edge := range edges {
+		if selected[edge.From] && selected[edge.To] {
+			resultEdges = append(resultEdges, edge)
+		}
+	}
+
+	return result, resultEdges, nil
+}
+
+// graphNodeAttrs returns graphviz attributes used to render node
+func graphNodeAttrs(node *GraphNode) map[string]string {
+	attrs := map[string]string{
+		"shape": "Mrecord",
+		"style": "filled",
+	}
+
+	switch node.Type {
+	case "mirror":
+		attrs["fillcolor"] = "darkgoldenrod1"
+		attrs["label"] = fmt.Sprintf("{Mirror %s|url: %s|dist: %s|comp: %s|arch: %s|pkgs: %d}",
+			node.Name, node.ArchiveRoot, node.Distribution, strings.Join(node.Components, ", "),
+			strings.Join(node.Architectures, ", "), node.NumPackages)
+	case "repo":
+		attrs["fillcolor"] = "mediumseagreen"
+		attrs["label"] = fmt.Sprintf("{Repo %s|comment: %s|pkgs: %d}",
+			node.Name, node.Comment, node.NumPackages)
+	case "snapshot":
+		description := node.Description
+		if node.SourceKind == "repo" {
+			description = "Snapshot from repo"
+		}
+
+		attrs["fillcolor"] = "cadetblue1"
+		attrs["label"] = fmt.Sprintf("{Snapshot %s|%s|pkgs: %d}", node.Name, description, node.NumPackages)
+	case "published":
+		attrs["fillcolor"] = "darkolivegreen1"
+		attrs["label"] = fmt.Sprintf("{Published %s/%s|comp: %s|arch: %s}", node.Prefix, node.Distribution,
+			strings.Join(node.Components, " "), strings.Join(node.Architectures, ", "))
+	}
+
+	return attrs
+}
+
+// BuildGraph builds graphviz graph of relationships between mirrors, local repos, snapshots
+// and published repositories, options (if not nil) limit part of the graph being built
+func BuildGraph(collectionFactory *CollectionFactory, options *GraphOptions) (gographviz.Interface, error) {
+	nodes, edges, err := BuildGraphNodes(collectionFactory, options)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := gographviz.NewEscape()
+	graph.SetDir(true)
+	graph.SetName("aptly")
+
+	for _, node := range nodes {
+		graph.AddNode("aptly", node.UUID, graphNodeAttrs(node))
+	}
+
+	for _, edge := range edges {
+		graph.AddEdge(edge.From, edge.To, true, nil)
+	}
+
 	return graph, nil
 }
+
+// quoteDOT quotes string as graphviz ID
+func quoteDOT(s string) string {
+	return "\"" + strings.Replace(strings.Replace(s, "\\", "\\\\", -1), "\"", "\\\"", -1) + "\""
+}
+
+// WriteGraph writes graph of nodes and edges (as returned by BuildGraphNodes) in DOT format
+// to w, node by node, so that graph is never kept in memory as a whole
+func WriteGraph(w io.Writer, nodes []*GraphNode, edges []GraphEdge) error {
+	buf := bufio.NewWriter(w)
+
+	fmt.Fprintf(buf, "digraph aptly {\n")
+
+	for _, node := range nodes {
+		attrs := graphNodeAttrs(node)
+
+		keys := make([]string, 0, len(attrs))
+		for key := range attrs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		pairs := make([]string, len(keys))
+		for i, key := range keys {
+			pairs[i] = key + "=" + quoteDOT(attrs[key])
+		}
+
+		fmt.Fprintf(buf, "\t%s [ %s ];\n", quoteDOT(node.UUID), strings.Join(pairs, ", "))
+	}
+
+	for _, edge := range edges {
+		fmt.Fprintf(buf, "\t%s -> %s;\n", quoteDOT(edge.From), quoteDOT(edge.To))
+	}
+
+	fmt.Fprintf(buf, "}\n")
+
+	return buf.Flush()
+}
@@ -0,0 +1,153 @@
+package deb
+
+import (
+	"bytes"
+	"github.com/smira/aptly/database"
+
+	. "gopkg.in/check.v1"
+)
+
+type GraphSuite struct {
+	PackageListMixinSuite
+	db      database.Storage
+	factory *CollectionFactory
+}
+
+var _ = Suite(&GraphSuite{})
+
+func (s *GraphSuite) SetUpTest(c *C) {
+	s.SetUpPackages()
+
+	s.db, _ = database.OpenDB(c.MkDir())
+	s.factory = NewCollectionFactory(s.db)
+
+	// repo1 -> snap1 -> merged <- snap2 <- repo2, merged -> published, repo3 is unrelated
+	repo1 := NewLocalRepo("repo1", "")
+	repo1.UpdateRefList(s.reflist)
+	s.factory.LocalRepoCollection().Add(repo1)
+
+	repo2 := NewLocalRepo("repo2", "")
+	repo2.UpdateRefList(s.reflist)
+	s.factory.LocalRepoCollection().Add(repo2)
+
+	s.factory.LocalRepoCollection().Add(NewLocalRepo("repo3", ""))
+
+	snap1, _ := NewSnapshotFromLocalRepo("snap1", repo1)
+	s.factory.SnapshotCollection().Add(snap1)
+
+	snap2, _ := NewSnapshotFromLocalRepo("snap2", repo2)
+	s.factory.SnapshotCollection().Add(snap2)
+
+	merged := NewSnapshotFromRefList("merged", []*Snapshot{snap1, snap2}, s.reflist, "Merged")
+	s.factory.SnapshotCollection().Add(merged)
+
+	published, _ := NewPublishedRepo("", "ppa", "squeeze", nil, []string{"main"}, []interface{}{merged}, s.factory)
+	s.factory.PublishedRepoCollection().Add(published)
+}
+
+func (s *GraphSuite) TearDownTest(c *C) {
+	s.db.Close()
+}
+
+func (s *GraphSuite) selectNames(c *C, options *GraphOptions) []string {
+	nodes, edges, err := collectGraph(s.factory)
+	c.Assert(err, IsNil)
+	c.Assert(nodes, HasLen, 7)
+	c.Assert(edges, HasLen, 5)
+
+	selected, err := selectGraphNodes(nodes, edges, options)
+	c.Assert(err, IsNil)
+
+	names := []string{}
+	for _, node := range nodes {
+		if selected[node.id] {
+			names = append(names, node.name)
+		}
+	}
+	return names
+}
+
+func (s *GraphSuite) TestSelectAll(c *C) {
+	c.Check(s.selectNames(c, nil), HasLen, 7)
+	c.Check(s.selectNames(c, &GraphOptions{Depth: 0}), HasLen, 7)
+}
+
+func (s *GraphSuite) TestSelectFocus(c *C) {
+	c.Check(s.selectNames(c, &GraphOptions{Focus: "snap1", Depth: 0}), DeepEquals, []string{"snap1"})
+	c.Check(s.selectNames(c, &GraphOptions{Focus: "snap1", Depth: 1}), DeepEquals, []string{"repo1", "snap1", "merged"})
+	c.Check(s.selectNames(c, &GraphOptions{Focus: "snap1", Depth: 2}), DeepEquals,
+		[]string{"repo1", "snap1", "snap2", "merged", "ppa/squeeze"})
+	c.Check(s.selectNames(c, &GraphOptions{Focus: "snap1", Depth: -1}), DeepEquals,
+		[]string{"repo1", "repo2", "snap1", "snap2", "merged", "ppa/squeeze"})
+	c.Check(s.selectNames(c, &GraphOptions{Focus: "repo*", Depth: 0}), DeepEquals, []string{"repo1", "repo2", "repo3"})
+	c.Check(s.selectNames(c, &GraphOptions{Focus: "ppa/*", Depth: 1}), DeepEquals, []string{"merged", "ppa/squeeze"})
+	c.Check(s.selectNames(c, &GraphOptions{Focus: "nope", Depth: -1}), HasLen, 0)
+}
+
+func (s *GraphSuite) TestSelectMaxNodes(c *C) {
+	nodes, edges, _ := collectGraph(s.factory)
+
+	for depth := 0; depth < 4; depth++ {
+		selected, err := selectGraphNodes(nodes, edges, &GraphOptions{Focus: "merged", Depth: depth, MaxNodes: 6})
+		c.Assert(err, IsNil)
+		c.Check(len(selected) <= 6, Equals, true)
+	}
+
+	_, err := selectGraphNodes(nodes, edges, &GraphOptions{MaxNodes: 6})
+	c.Check(err, ErrorMatches, "graph has 7 nodes, more than maximum of 6, narrow it down with focus and depth")
+	c.Check(err, FitsTypeOf, &GraphTooLargeError{})
+
+	_, err = selectGraphNodes(nodes, edges, &GraphOptions{Focus: "[", Depth: -1})
+	c.Check(err, ErrorMatches, "unable to parse focus pattern: .*")
+}
+
+func (s *GraphSuite) TestBuildGraph(c *C) {
+	graph, err := BuildGraph(s.factory, &GraphOptions{Focus: "repo1", Depth: 1})
+	c.Assert(err, IsNil)
+	c.Check(graph.String(), Matches, "(?s).*Repo repo1.*Snapshot snap1.*")
+	c.Check(graph.String(), Not(Matches), "(?s).*merged.*")
+
+	_, err = BuildGraph(s.factory, &GraphOptions{MaxNodes: 3})
+	c.Check(err, FitsTypeOf, &GraphTooLargeError{})
+}
+
+func (s *GraphSuite) TestBuildGraphNodes(c *C) {
+	nodes, edges, err := BuildGraphNodes(s.factory, &GraphOptions{Focus: "merged", Depth: 1})
+	c.Assert(err, IsNil)
+	c.Assert(nodes, HasLen, 4)
+	c.Check(edges, HasLen, 3)
+
+	c.Check(nodes[0].Type, Equals, "snapshot")
+	c.Check(nodes[0].Name, Equals, "snap1")
+	c.Check(nodes[0].SourceKind, Equals, "local")
+	c.Check(nodes[0].NumPackages, Equals, 3)
+
+	c.Check(nodes[2].Type, Equals, "snapshot")
+	c.Check(nodes[2].Description, Equals, "Merged")
+
+	c.Check(nodes[3].Type, Equals, "published")
+	c.Check(nodes[3].Name, Equals, "ppa/squeeze")
+	c.Check(nodes[3].Components, DeepEquals, []string{"main"})
+	c.Check(edges[2], DeepEquals, GraphEdge{From: nodes[2].UUID, To: nodes[3].UUID})
+
+	nodes, edges, err = BuildGraphNodes(s.factory, &GraphOptions{Focus: "repo3", Depth: -1})
+	c.Assert(err, IsNil)
+	c.Check(nodes, DeepEquals, []*GraphNode{{UUID: nodes[0].UUID, Type: "repo", Name: "repo3"}})
+	c.Check(edges, HasLen, 0)
+}
+
+func (s *GraphSuite) TestWriteGraph(c *C) {
+	nodes := []*GraphNode{
+		{UUID: "a", Type: "repo", Name: "repo1", Comment: "say \"hi\""},
+		{UUID: "b", Type: "snapshot", Name: "snap1", SourceKind: "repo"},
+	}
+	edges := []GraphEdge{{From: "a", To: "b"}}
+
+	buf := &bytes.Buffer{}
+	c.Assert(WriteGraph(buf, nodes, edges), IsNil)
+	c.Check(buf.String(), Equals, "digraph aptly {\n"+
+		"\t\"a\" [ fillcolor=\"mediumseagreen\", label=\"{Repo repo1|comment: say \\\"hi\\\"|pkgs: 0}\", shape=\"Mrecord\", style=\"filled\" ];\n"+
+		"\t\"b\" [ fillcolor=\"cadetblue1\", label=\"{Snapshot snap1|Snapshot from repo|pkgs: 0}\", shape=\"Mrecord\", style=\"filled\" ];\n"+
+		"\t\"a\" -> \"b\";\n"+
+		"}\n")
+}
@@ -0,0 +1,60 @@
+package deb
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// PreAddHook is external command (e.g. virus scanner or linter) which checks
+// package files before they're added to local repository
+//
+// Hook is invoked with path to the file as the only argument, nonzero exit
+// status rejects the file.
+type PreAddHook struct {
+	Command string
+}
+
+// NewPreAddHook creates hook from configuration, returns nil if hook is not configured
+func NewPreAddHook(command string) *PreAddHook {
+	if command == "" {
+		return nil
+	}
+
+	return &PreAddHook{Command: command}
+}
+
+// Init verifies that hook command could be found
+func (h *PreAddHook) Init() error {
+	_, err := exec.LookPath(h.Command)
+	if err != nil {
+		return fmt.Errorf("unable to find pre-add hook: %s", err)
+	}
+
+	return nil
+}
+
+// CheckPackage runs hook on the package file, returning error if file is rejected
+func (h *PreAddHook) CheckPackage(packageFile string) error {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(h.Command, packageFile)
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(*exec.ExitError); !ok {
+		return fmt.Errorf("unable to execute pre-add hook: %s", err)
+	}
+
+	message := strings.TrimSpace(stderr.String())
+	if message == "" {
+		return fmt.Errorf("rejected by pre-add hook: %s", err)
+	}
+
+	return fmt.Errorf("rejected by pre-add hook: %s", message)
+}
@@ -0,0 +1,70 @@
+package deb
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type PreAddHookSuite struct {
+	hook *PreAddHook
+}
+
+var _ = Suite(&PreAddHookSuite{})
+
+func (s *PreAddHookSuite) SetUpTest(c *C) {
+	// stub scanner rejects files with "infected" in the name
+	script := filepath.Join(c.MkDir(), "scan.sh")
+	c.Assert(ioutil.WriteFile(script, []byte(`#!/bin/sh
+case "$1" in
+	*infected*)
+		echo "$(basename $1): Eicar-Test-Signature FOUND" >&2
+		exit 1
+		;;
+esac
+echo "$1: OK"
+`), 0755), IsNil)
+
+	s.hook = NewPreAddHook(script)
+	c.Assert(s.hook.Init(), IsNil)
+}
+
+func (s *PreAddHookSuite) TestNewPreAddHook(c *C) {
+	c.Check(NewPreAddHook(""), IsNil)
+	c.Check(NewPreAddHook("/usr/bin/clamscan"), DeepEquals, &PreAddHook{Command: "/usr/bin/clamscan"})
+}
+
+func (s *PreAddHookSuite) TestInit(c *C) {
+	c.Check(NewPreAddHook("/no/such/hook").Init(), ErrorMatches, "unable to find pre-add hook: .*")
+}
+
+func (s *PreAddHookSuite) TestCheckPackage(c *C) {
+	c.Check(s.hook.CheckPackage("/incoming/clean_1.0_amd64.deb"), IsNil)
+	c.Check(s.hook.CheckPackage("/incoming/infected_1.0_amd64.deb"), ErrorMatches,
+		"rejected by pre-add hook: infected_1.0_amd64.deb: Eicar-Test-Signature FOUND")
+}
+
+func (s *PreAddHookSuite) TestCheckPackageNoStderr(c *C) {
+	c.Check(NewPreAddHook("false").CheckPackage("/incoming/clean_1.0_amd64.deb"), ErrorMatches,
+		"rejected by pre-add hook: exit status 1")
+}
+
+func (s *PreAddHookSuite) TestCheckPackageSourceFiles(c *C) {
+	p := &Package{Name: "source", Version: "1.0", Architecture: "source", IsSource: true}
+	p.UpdateFiles(PackageFiles{
+		PackageFile{Filename: "source_1.0.dsc"},
+		PackageFile{Filename: "source_1.0.orig.tar.gz"},
+	})
+	path := func(f PackageFile) string { return filepath.Join("/incoming", f.Filename) }
+
+	c.Check(checkPackage(p, path, ImportOptions{Hook: s.hook}), IsNil)
+
+	p.UpdateFiles(PackageFiles{
+		PackageFile{Filename: "source_1.0.dsc"},
+		PackageFile{Filename: "source_1.0.infected.tar.gz"},
+	})
+	c.Check(checkPackage(p, path, ImportOptions{Hook: s.hook}), ErrorMatches,
+		"rejected by pre-add hook: source_1.0.infected.tar.gz: Eicar-Test-Signature FOUND")
+	c.Check(checkPackage(p, path, ImportOptions{}), IsNil)
+}
@@ -1,16 +1,26 @@
 package deb
 
 import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
 	"github.com/smira/aptly/aptly"
 	"github.com/smira/aptly/utils"
+	"io"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
 )
 
 // CollectPackageFiles walks filesystem collecting all candidates for package files
-func CollectPackageFiles(locations []string, reporter aptly.ResultReporter) (packageFiles, failedFiles []string, err error) {
+//
+// If recursive is false, only top-level files in directories are considered
+func CollectPackageFiles(locations []string, recursive bool, reporter aptly.ResultReporter) (packageFiles, failedFiles []string, err error) {
 	for _, location := range locations {
 		info, err2 := os.Stat(location)
 		if err2 != nil {
@@ -24,19 +34,20 @@ func CollectPackageFiles(locations []string, reporter aptly.ResultReporter) (pac
 					return err3
 				}
 				if info.IsDir() {
+					if !recursive && path != location {
+						return filepath.SkipDir
+					}
 					return nil
 				}
 
-				if strings.HasSuffix(info.Name(), ".deb") || strings.HasSuffix(info.Name(), ".udeb") ||
-					strings.HasSuffix(info.Name(), ".dsc") {
+				if isPackageFileName(info.Name()) {
 					packageFiles = append(packageFiles, path)
 				}
 
 				return nil
 			})
 		} else {
-			if strings.HasSuffix(info.Name(), ".deb") || strings.HasSuffix(info.Name(), ".udeb") ||
-				strings.HasSuffix(info.Name(), ".dsc") {
+			if isPackageFileName(info.Name()) {
 				packageFiles = append(packageFiles, location)
 			} else {
 				reporter.Warning("Unknown file extension: %s", location)
@@ -51,56 +62,337 @@ func CollectPackageFiles(locations []string, reporter aptly.ResultReporter) (pac
 	return
 }
 
-// ImportPackageFiles imports files into local repository
-func ImportPackageFiles(list *PackageList, packageFiles []string, forceReplace bool, verifier utils.Verifier,
-	pool aptly.PackagePool, collection *PackageCollection, reporter aptly.ResultReporter) (processedFiles []string, failedFiles []string, err error) {
-	if forceReplace {
-		list.PrepareIndex()
+// isPackageFileName checks whether name has extension of package file
+func isPackageFileName(name string) bool {
+	return strings.HasSuffix(name, ".deb") || strings.HasSuffix(name, ".udeb") || strings.HasSuffix(name, ".dsc")
+}
+
+// IsPackageURL checks whether location is http(s) URL (and not local path)
+func IsPackageURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// DownloadPackageFiles downloads package files specified by URLs into subdirectories of directory,
+// returning paths to downloaded files which could be passed to ImportPackageFiles
+//
+// For source packages, files referenced by .dsc are downloaded from the same location
+// and verified against checksums from .dsc (which is verified with verifier, if set).
+func DownloadPackageFiles(downloader aptly.Downloader, urls []string, directory string, verifier utils.Verifier,
+	reporter aptly.ResultReporter) (packageFiles, failedFiles []string) {
+	for i, location := range urls {
+		packageFile, err := downloadPackageFile(downloader, location, filepath.Join(directory, fmt.Sprintf("%d", i)), verifier)
+		if err != nil {
+			reporter.Warning("Unable to download %s: %s", location, err)
+			failedFiles = append(failedFiles, location)
+			continue
+		}
+
+		packageFiles = append(packageFiles, packageFile)
 	}
 
-	for _, file := range packageFiles {
-		var (
-			stanza Stanza
-			p      *Package
-		)
+	return
+}
 
-		candidateProcessedFiles := []string{}
-		isSourcePackage := strings.HasSuffix(file, ".dsc")
-		isUdebPackage := strings.HasSuffix(file, ".udeb")
+func downloadPackageFile(downloader aptly.Downloader, location, directory string, verifier utils.Verifier) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
 
-		if isSourcePackage {
-			stanza, err = GetControlFileFromDsc(file, verifier)
+	name := path.Base(u.Path)
+	if !isPackageFileName(name) {
+		return "", fmt.Errorf("unknown file extension: %s", name)
+	}
 
-			if err == nil {
-				stanza["Package"] = stanza["Source"]
-				delete(stanza, "Source")
+	err = os.MkdirAll(directory, 0755)
+	if err != nil {
+		return "", err
+	}
 
-				p, err = NewSourcePackageFromControlFile(stanza)
+	packageFile := filepath.Join(directory, name)
+	ch := make(chan error, 1)
+	downloader.Download(location, packageFile, ch)
+	err = <-ch
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasSuffix(name, ".dsc") {
+		return packageFile, nil
+	}
+
+	stanza, err := GetControlFileFromDsc(packageFile, verifier)
+	if err != nil {
+		return "", err
+	}
+
+	p, err := NewSourcePackageFromControlFile(stanza)
+	if err != nil {
+		return "", err
+	}
+
+	files := p.Files()
+	ch = make(chan error, len(files))
+	for _, f := range files {
+		fileURL := *u
+		fileURL.Path = path.Join(path.Dir(u.Path), f.Filename)
+		downloader.DownloadWithChecksum(fileURL.String(), filepath.Join(directory, f.Filename), ch, f.Checksums, false)
+	}
+
+	for range files {
+		e := <-ch
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return packageFile, err
+}
+
+// Policies for packages being imported which conflict with existing packages,
+// i.e. package with the same name, version and architecture is already in the list
+const (
+	// ConflictFail reports an error if existing package has different contents
+	ConflictFail = "fail"
+	// ConflictSkip keeps existing package and ignores the new one
+	ConflictSkip = "skip"
+	// ConflictReplace removes existing package and adds the new one
+	ConflictReplace = "replace"
+)
+
+// parsedPackageFile is package file with checksums calculated and control file parsed
+type parsedPackageFile struct {
+	checksums utils.ChecksumInfo
+	p         *Package
+	err       error
+}
+
+// parsePackageFile calculates checksums for package file and builds package from control file
+func parsePackageFile(file string, verifier utils.Verifier) (result parsedPackageFile) {
+	var stanza Stanza
+
+	isSourcePackage := strings.HasSuffix(file, ".dsc")
+	isUdebPackage := strings.HasSuffix(file, ".udeb")
+
+	result.checksums, result.err = utils.ChecksumsForFile(file)
+	if result.err != nil {
+		return
+	}
+
+	if isSourcePackage {
+		stanza, result.err = GetControlFileFromDsc(file, verifier)
+
+		if result.err == nil {
+			stanza["Package"] = stanza["Source"]
+			delete(stanza, "Source")
+
+			result.p, result.err = NewSourcePackageFromControlFile(stanza)
+		}
+	} else {
+		stanza, result.err = DefaultControlCache.GetControlFileFromDeb(file, result.checksums)
+		if isUdebPackage {
+			result.p = NewUdebPackageFromControlFile(stanza)
+		} else {
+			result.p = NewPackageFromControlFile(stanza)
+		}
+	}
+	if result.err != nil {
+		return
+	}
+
+	if isSourcePackage {
+		result.p.UpdateFiles(append(result.p.Files(), PackageFile{Filename: filepath.Base(file), Checksums: result.checksums}))
+	} else {
+		result.p.UpdateFiles([]PackageFile{PackageFile{Filename: filepath.Base(file), Checksums: result.checksums}})
+	}
+
+	return
+}
+
+// parsePackageFiles parses package files concurrently using worker per CPU,
+// results are returned in the same order as packageFiles
+func parsePackageFiles(packageFiles []string, verifier utils.Verifier) []parsedPackageFile {
+	results := make([]parsedPackageFile, len(packageFiles))
+
+	workers := runtime.NumCPU()
+	if workers > len(packageFiles) {
+		workers = len(packageFiles)
+	}
+
+	queue := make(chan int)
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for idx := range queue {
+				results[idx] = parsePackageFile(packageFiles[idx], verifier)
+			}
+		}()
+	}
+
+	for idx := range packageFiles {
+		queue <- idx
+	}
+	close(queue)
+
+	wg.Wait()
+
+	return results
+}
+
+// DefaultSection is assigned by NormalizeFields to packages without Section
+const DefaultSection = "misc"
+
+// knownPriorities are valid values of Priority field
+var knownPriorities = []string{"required", "important", "standard", "optional", "extra"}
+
+// NormalizeFields fills missing Section of the package with DefaultSection and
+// validates Priority against known priorities, returning list of anomalies found
+//
+// Source packages are not checked for Section, as .dsc files don't carry it.
+func NormalizeFields(p *Package) (anomalies []string) {
+	extra := p.Extra()
+
+	if !p.IsSource && strings.TrimSpace(extra["Section"]) == "" {
+		extra["Section"] = DefaultSection
+		anomalies = append(anomalies, fmt.Sprintf("missing Section, set to %s", DefaultSection))
+	}
+
+	if priority, ok := extra["Priority"]; ok {
+		normalized := strings.ToLower(strings.TrimSpace(priority))
+		if utils.StrSliceHasItem(knownPriorities, normalized) {
+			if normalized != priority {
+				extra["Priority"] = normalized
+				anomalies = append(anomalies, fmt.Sprintf("Priority %#v normalized to %s", priority, normalized))
 			}
 		} else {
-			stanza, err = GetControlFileFromDeb(file)
-			if isUdebPackage {
-				p = NewUdebPackageFromControlFile(stanza)
-			} else {
-				p = NewPackageFromControlFile(stanza)
+			anomalies = append(anomalies, fmt.Sprintf("unknown Priority %#v", priority))
+		}
+	}
+
+	return
+}
+
+// ImportOptions controls how ImportPackageFiles imports package files
+type ImportOptions struct {
+	// ConflictPolicy is one of ConflictFail, ConflictSkip or ConflictReplace
+	ConflictPolicy string
+	// NoDowngrade rejects packages with version lower than version of the same
+	// package (name & architecture) already in the list, otherwise it's only reported
+	NoDowngrade bool
+	// NormalizeFields enables normalization of Section and Priority (see NormalizeFields),
+	// packages already in the DB are not normalized
+	NormalizeFields bool
+	// Verifier checks signatures of .dsc files, if set
+	Verifier utils.Verifier
+	// DebsigVerifier checks signatures embedded into binary packages, if set
+	DebsigVerifier *DebsigVerifier
+	// Hook is external command checking every package file before it's imported, if set
+	Hook *PreAddHook
+}
+
+// checkPackage runs checks enabled in options on files of package p before they're
+// imported, path returns location of package file on disk
+func checkPackage(p *Package, path func(f PackageFile) string, options ImportOptions) error {
+	if options.DebsigVerifier != nil && !p.IsSource {
+		for _, f := range p.Files() {
+			err := options.DebsigVerifier.VerifyPackage(path(f))
+			if err != nil {
+				return fmt.Errorf("signature verification failed: %s", err)
 			}
 		}
-		if err != nil {
-			reporter.Warning("Unable to read file %s: %s", file, err)
+	}
+
+	if options.Hook != nil {
+		for _, f := range p.Files() {
+			err := options.Hook.CheckPackage(path(f))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ImportPackageFiles imports files into local repository
+//
+// Package files are hashed & parsed in parallel, while importing into pool, DB & list
+// happens sequentially in order of packageFiles. Packages which are already in the list
+// with the same contents are skipped (and reported as processed).
+func ImportPackageFiles(list *PackageList, packageFiles []string, options ImportOptions,
+	pool aptly.PackagePool, collection *PackageCollection, reporter aptly.ResultReporter) (processedFiles []string, failedFiles []string, err error) {
+	err = prepareConflictPolicy(list, options.ConflictPolicy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// newest versions of packages which were in the list before import
+	newestVersions := make(map[string]string)
+	list.ForEach(func(p *Package) error {
+		key := p.Name + " " + p.Architecture
+		if version, ok := newestVersions[key]; !ok || CompareVersions(p.Version, version) > 0 {
+			newestVersions[key] = p.Version
+		}
+		return nil
+	})
+
+	parsed := parsePackageFiles(packageFiles, options.Verifier)
+
+	for i, file := range packageFiles {
+		candidateProcessedFiles := []string{}
+		checksums, p := parsed[i].checksums, parsed[i].p
+
+		if parsed[i].err != nil {
+			reporter.Warning("Unable to read file %s: %s", file, parsed[i].err)
 			failedFiles = append(failedFiles, file)
 			continue
 		}
 
-		var checksums utils.ChecksumInfo
-		checksums, err = utils.ChecksumsForFile(file)
+		err = checkPackage(p, func(f PackageFile) string {
+			return filepath.Join(filepath.Dir(file), filepath.Base(f.Filename))
+		}, options)
 		if err != nil {
-			return nil, nil, err
+			reporter.Warning("Unable to add %s: %s", filepath.Base(file), err)
+			failedFiles = append(failedFiles, file)
+			continue
 		}
 
-		if isSourcePackage {
-			p.UpdateFiles(append(p.Files(), PackageFile{Filename: filepath.Base(file), Checksums: checksums}))
-		} else {
-			p.UpdateFiles([]PackageFile{PackageFile{Filename: filepath.Base(file), Checksums: checksums}})
+		if options.NormalizeFields {
+			if _, err := collection.ByKey(p.Key("")); err == nil {
+				// fields of the package are shared by all the repos it's in
+				reporter.Warning("%s: already in the database, fields are not normalized", p)
+			} else {
+				for _, anomaly := range NormalizeFields(p) {
+					reporter.Warning("%s: %s", p, anomaly)
+				}
+			}
+		}
+
+		if skip, identical := checkConflict(list, p, options.ConflictPolicy, reporter); skip {
+			if identical {
+				// re-running add after interruption doesn't process files again,
+				// but all of them (including source tarballs) are reported as processed
+				processedFiles = append(processedFiles, file)
+				for _, f := range p.Files() {
+					if filepath.Base(f.Filename) != filepath.Base(file) {
+						processedFiles = append(processedFiles, filepath.Join(filepath.Dir(file), filepath.Base(f.Filename)))
+					}
+				}
+			}
+			continue
+		}
+
+		if newest, ok := newestVersions[p.Name+" "+p.Architecture]; ok && CompareVersions(p.Version, newest) < 0 {
+			if options.NoDowngrade {
+				reporter.Warning("%s rejected: downgrade from version %s already in the repository", p, newest)
+				failedFiles = append(failedFiles, file)
+				continue
+			}
+			reporter.Warning("%s is a downgrade: version %s is already in the repository", p, newest)
 		}
 
 		err = pool.Import(file, checksums.MD5)
@@ -132,32 +424,325 @@ func ImportPackageFiles(list *PackageList, packageFiles []string, forceReplace b
 			continue
 		}
 
-		err = collection.Update(p)
+		err = addPackage(list, p, options.ConflictPolicy, collection, reporter)
 		if err != nil {
-			reporter.Warning("Unable to save package %s: %s", p, err)
 			failedFiles = append(failedFiles, file)
 			continue
 		}
 
-		if forceReplace {
-			conflictingPackages := list.Search(Dependency{Pkg: p.Name, Version: p.Version, Relation: VersionEqual, Architecture: p.Architecture}, true)
-			for _, cp := range conflictingPackages {
-				reporter.Removed("%s removed due to conflict with package being added", cp)
-				list.Remove(cp)
-			}
+		processedFiles = append(processedFiles, candidateProcessedFiles...)
+	}
+
+	err = nil
+	return
+}
+
+// prepareConflictPolicy validates conflictPolicy, preparing list for it
+func prepareConflictPolicy(list *PackageList, conflictPolicy string) error {
+	switch conflictPolicy {
+	case ConflictFail, ConflictSkip:
+	case ConflictReplace:
+		list.PrepareIndex()
+	default:
+		return fmt.Errorf("unknown conflict policy: %s", conflictPolicy)
+	}
+
+	return nil
+}
+
+// checkConflict checks whether package p should be skipped as the same package
+// is already in the list, identical is set if existing package has the same contents
+func checkConflict(list *PackageList, p *Package, conflictPolicy string, reporter aptly.ResultReporter) (skip, identical bool) {
+	existing, exists := list.packages[string(p.ShortKey(""))]
+	if !exists {
+		return false, false
+	}
+
+	if existing.Equals(p) {
+		// exactly the same package (same checksums of files) is already in the repository
+		reporter.Warning("%s skipped: already in the repository", p)
+		return true, true
+	}
+
+	if conflictPolicy == ConflictSkip {
+		reporter.Warning("%s skipped: conflicting package already in the repository", p)
+		return true, false
+	}
+
+	return false, false
+}
+
+// addPackage saves package p (which files are already in the pool) to DB and adds it
+// to the list, removing conflicting packages with ConflictReplace
+//
+// Failures are reported as warnings.
+func addPackage(list *PackageList, p *Package, conflictPolicy string, collection *PackageCollection, reporter aptly.ResultReporter) error {
+	err := collection.Update(p)
+	if err != nil {
+		reporter.Warning("Unable to save package %s: %s", p, err)
+		return err
+	}
+
+	if conflictPolicy == ConflictReplace {
+		conflictingPackages := list.Search(Dependency{Pkg: p.Name, Version: p.Version, Relation: VersionEqual, Architecture: p.Architecture}, true)
+		for _, cp := range conflictingPackages {
+			reporter.Removed("%s removed due to conflict with package being added", cp)
+			list.Remove(cp)
 		}
+	}
 
-		err = list.Add(p)
+	err = list.Add(p)
+	if err != nil {
+		reporter.Warning("Unable to add package to repo %s: %s", p, err)
+		return err
+	}
+
+	reporter.Added("%s added", p)
+	return nil
+}
+
+// indexCompressions lists suffixes of index files in order of preference
+var indexCompressions = []string{"", ".gz", ".bz2"}
+
+// CollectRepositoryIndexes finds Packages and Sources indexes under dists/ of existing repository
+//
+// If index is available in several compressions, only one of them is returned
+func CollectRepositoryIndexes(root string) (indexes []string, err error) {
+	best := make(map[string]int)
+
+	err = filepath.Walk(filepath.Join(root, "dists"), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			reporter.Warning("Unable to add package to repo %s: %s", p, err)
-			failedFiles = append(failedFiles, file)
-			continue
+			return err
+		}
+		if info.IsDir() {
+			return nil
 		}
 
-		reporter.Added("%s added", p)
-		processedFiles = append(processedFiles, candidateProcessedFiles...)
+		for i, suffix := range indexCompressions {
+			if info.Name() != "Packages"+suffix && info.Name() != "Sources"+suffix {
+				continue
+			}
+
+			stem := strings.TrimSuffix(path, suffix)
+			if current, ok := best[stem]; !ok || i < current {
+				best[stem] = i
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	err = nil
+	for stem, i := range best {
+		indexes = append(indexes, stem+indexCompressions[i])
+	}
+	sort.Strings(indexes)
+
 	return
 }
+
+// openIndex opens (possibly compressed) index file
+func openIndex(path string) (io.Reader, *os.File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var reader io.Reader = file
+
+	switch filepath.Ext(path) {
+	case ".gz":
+		reader, err = gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	case ".bz2":
+		reader = bzip2.NewReader(file)
+	}
+
+	return reader, file, nil
+}
+
+// ImportExistingRepository imports packages of existing (not managed by aptly) repository
+// in directory root into local repository
+//
+// Packages are discovered from Packages and Sources indexes, files referenced by indexes are
+// verified and imported into package pool (hardlinked if link is true, copied otherwise).
+// Conflict policy and package checks are taken from options, indexes are trusted as is,
+// so no normalization is applied.
+func ImportExistingRepository(list *PackageList, root string, options ImportOptions, link bool,
+	pool aptly.PackagePool, collection *PackageCollection, reporter aptly.ResultReporter) (failedPackages []string, err error) {
+	err = prepareConflictPolicy(list, options.ConflictPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, err := CollectRepositoryIndexes(root)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find indexes: %s", err)
+	}
+	if len(indexes) == 0 {
+		return nil, fmt.Errorf("no Packages or Sources indexes found in %s", filepath.Join(root, "dists"))
+	}
+
+	// the same package (e.g. Architecture: all) is usually listed in several indexes
+	seen := make(map[string]bool)
+
+	for _, index := range indexes {
+		reader, file, err := openIndex(index)
+		if err != nil {
+			return failedPackages, fmt.Errorf("unable to open index %s: %s", index, err)
+		}
+
+		isSource := strings.HasPrefix(filepath.Base(index), "Sources")
+		isUdeb := strings.Contains(filepath.ToSlash(index), "/debian-installer/")
+
+		sreader := NewControlFileReader(reader)
+
+		for {
+			stanza, err := sreader.ReadStanza()
+			if err != nil {
+				file.Close()
+				return failedPackages, fmt.Errorf("unable to read index %s: %s", index, err)
+			}
+			if stanza == nil {
+				break
+			}
+
+			var p *Package
+
+			if isSource {
+				// stanza fields are consumed while parsing
+				name, version := stanza["Package"], stanza["Version"]
+
+				p, err = NewSourcePackageFromControlFile(stanza)
+				if err != nil {
+					reporter.Warning("Unable to parse source package %s_%s in %s: %s", name, version, index, err)
+					failedPackages = append(failedPackages, fmt.Sprintf("%s_%s_source", name, version))
+					continue
+				}
+			} else if isUdeb {
+				p = NewUdebPackageFromControlFile(stanza)
+			} else {
+				p = NewPackageFromControlFile(stanza)
+			}
+
+			if seen[string(p.Key(""))] {
+				continue
+			}
+			seen[string(p.Key(""))] = true
+
+			if skip, _ := checkConflict(list, p, options.ConflictPolicy, reporter); skip {
+				continue
+			}
+
+			err = checkExistingFilePaths(p, root)
+			if err == nil {
+				err = checkPackage(p, func(f PackageFile) string {
+					return filepath.Join(root, f.DownloadURL())
+				}, options)
+			}
+			if err == nil {
+				err = importExistingFiles(p, root, link, pool)
+			}
+			if err != nil {
+				reporter.Warning("%s skipped: %s", p, err)
+				failedPackages = append(failedPackages, p.String())
+				continue
+			}
+
+			err = addPackage(list, p, options.ConflictPolicy, collection, reporter)
+			if err != nil {
+				failedPackages = append(failedPackages, p.String())
+				continue
+			}
+		}
+
+		file.Close()
+	}
+
+	return failedPackages, nil
+}
+
+// existingFilePath returns path to file of the package in existing repository at root,
+// files outside of root (e.g. Filename with ..) are rejected
+func existingFilePath(root string, f PackageFile) (string, error) {
+	path := filepath.Join(root, f.DownloadURL())
+
+	rel, err := filepath.Rel(filepath.Clean(root), path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file %s is outside of repository root", f.DownloadURL())
+	}
+
+	return path, nil
+}
+
+// checkExistingFilePaths verifies that all files of the package are inside repository root
+func checkExistingFilePaths(p *Package, root string) error {
+	for _, f := range p.Files() {
+		_, err := existingFilePath(root, f)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// importExistingFiles verifies files of the package against checksums from index
+// and imports them into package pool
+func importExistingFiles(p *Package, root string, link bool, pool aptly.PackagePool) error {
+	files := p.Files()
+	updated := false
+
+	for i, f := range files {
+		path, err := existingFilePath(root, f)
+		if err != nil {
+			return err
+		}
+
+		checksums, err := utils.ChecksumsForFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to read file %s: %s", path, err)
+		}
+
+		if (f.Checksums.Size != 0 && f.Checksums.Size != checksums.Size) ||
+			(f.Checksums.MD5 != "" && f.Checksums.MD5 != checksums.MD5) ||
+			(f.Checksums.SHA1 != "" && f.Checksums.SHA1 != checksums.SHA1) ||
+			(f.Checksums.SHA256 != "" && f.Checksums.SHA256 != checksums.SHA256) {
+			return fmt.Errorf("file %s doesn't match checksums in index", path)
+		}
+
+		if f.Checksums.MD5 == "" {
+			// pool location is based on MD5
+			files[i].Checksums = checksums
+			updated = true
+		}
+	}
+
+	if updated {
+		p.UpdateFiles(files)
+		files = p.Files()
+	}
+
+	for _, f := range files {
+		path, err := existingFilePath(root, f)
+		if err != nil {
+			return err
+		}
+
+		if link {
+			err = pool.Link(path, f.Checksums.MD5)
+		} else {
+			err = pool.Import(path, f.Checksums.MD5)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to import file %s into pool: %s", path, err)
+		}
+	}
+
+	return nil
+}
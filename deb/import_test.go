@@ -0,0 +1,527 @@
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"fmt"
+	"github.com/smira/aptly/aptly"
+	"github.com/smira/aptly/console"
+	"github.com/smira/aptly/database"
+	"github.com/smira/aptly/files"
+	"github.com/smira/aptly/http"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+  . "gopkg.in/check.v1"
+)
+
+type ImportSuite struct {
+	root     string
+	reporter *aptly.RecordingResultReporter
+}
+
+var _ = Suite(&ImportSuite{})
+
+func (s *ImportSuite) SetUpTest(c *C) {
+	s.root = c.MkDir()
+	s.reporter = &aptly.RecordingResultReporter{
+		Warnings: []string{},
+		Adds:     []string{},
+		Removes:  []string{},
+	}
+
+	for _, path := range []string{
+		"a_1.0_i386.deb",
+		"README",
+		"build/b_1.0_amd64.deb",
+		"build/b_1.0.dsc",
+		"build/di/c_1.0_amd64.udeb",
+		"build/di/c_1.0.tar.gz",
+	} {
+		c.Assert(os.MkdirAll(filepath.Join(s.root, filepath.Dir(path)), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(s.root, path), []byte("x"), 0644), IsNil)
+	}
+}
+
+func (s *ImportSuite) TestCollectRecursive(c *C) {
+	packageFiles, failedFiles, err := CollectPackageFiles([]string{s.root}, true, s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(failedFiles, HasLen, 0)
+	c.Check(packageFiles, DeepEquals, []string{
+		filepath.Join(s.root, "a_1.0_i386.deb"),
+		filepath.Join(s.root, "build/b_1.0.dsc"),
+		filepath.Join(s.root, "build/b_1.0_amd64.deb"),
+		filepath.Join(s.root, "build/di/c_1.0_amd64.udeb"),
+	})
+}
+
+func (s *ImportSuite) TestCollectNoRecursive(c *C) {
+	packageFiles, failedFiles, err := CollectPackageFiles([]string{s.root}, false, s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(failedFiles, HasLen, 0)
+	c.Check(packageFiles, DeepEquals, []string{filepath.Join(s.root, "a_1.0_i386.deb")})
+
+	packageFiles, failedFiles, err = CollectPackageFiles([]string{filepath.Join(s.root, "build")}, false, s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(failedFiles, HasLen, 0)
+	c.Check(packageFiles, DeepEquals, []string{
+		filepath.Join(s.root, "build/b_1.0.dsc"),
+		filepath.Join(s.root, "build/b_1.0_amd64.deb"),
+	})
+}
+
+func (s *ImportSuite) TestCollectFailures(c *C) {
+	packageFiles, failedFiles, err := CollectPackageFiles([]string{
+		filepath.Join(s.root, "no-such-file.deb"),
+		filepath.Join(s.root, "README"),
+		filepath.Join(s.root, "a_1.0_i386.deb"),
+	}, true, s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(packageFiles, DeepEquals, []string{filepath.Join(s.root, "a_1.0_i386.deb")})
+	c.Check(failedFiles, DeepEquals, []string{filepath.Join(s.root, "no-such-file.deb"), filepath.Join(s.root, "README")})
+	c.Check(s.reporter.Warnings, HasLen, 2)
+}
+
+func (s *ImportSuite) TestImportExistingRepository(c *C) {
+	repoRoot := c.MkDir()
+
+	os.MkdirAll(filepath.Join(repoRoot, "pool/main/a/app"), 0755)
+	os.MkdirAll(filepath.Join(repoRoot, "dists/stable/main/binary-amd64"), 0755)
+	os.MkdirAll(filepath.Join(repoRoot, "dists/stable/main/binary-i386"), 0755)
+	os.MkdirAll(filepath.Join(repoRoot, "dists/stable/main/source"), 0755)
+
+	good := []byte("app package contents")
+	ioutil.WriteFile(filepath.Join(repoRoot, "pool/main/a/app/app_1.0_amd64.deb"), good, 0644)
+	ioutil.WriteFile(filepath.Join(repoRoot, "pool/main/a/app/data_1.0_all.deb"), []byte("data"), 0644)
+	ioutil.WriteFile(filepath.Join(repoRoot, "pool/main/a/app/broken_1.0_amd64.deb"), []byte("corrupted"), 0644)
+
+	allStanza := fmt.Sprintf("Package: data\nVersion: 1.0\nArchitecture: all\nFilename: pool/main/a/app/data_1.0_all.deb\nSize: 4\nMD5sum: %x\n\n",
+		md5.Sum([]byte("data")))
+
+	ioutil.WriteFile(filepath.Join(repoRoot, "dists/stable/main/binary-amd64/Packages"), []byte(fmt.Sprintf(
+		"Package: app\nVersion: 1.0\nArchitecture: amd64\nFilename: pool/main/a/app/app_1.0_amd64.deb\nSize: %d\nMD5sum: %x\n\n"+
+			"Package: broken\nVersion: 1.0\nArchitecture: amd64\nFilename: pool/main/a/app/broken_1.0_amd64.deb\nSize: 9\nMD5sum: %x\n\n",
+		len(good), md5.Sum(good), md5.Sum([]byte("original")))+allStanza), 0644)
+	ioutil.WriteFile(filepath.Join(repoRoot, "dists/stable/main/binary-i386/Packages"), []byte(allStanza), 0644)
+	ioutil.WriteFile(filepath.Join(repoRoot, "dists/stable/main/source/Sources"),
+		[]byte("Package: app-src\nVersion: 1.0\nFiles:\n broken\n\n"), 0644)
+
+	indexes, err := CollectRepositoryIndexes(repoRoot)
+	c.Assert(err, IsNil)
+	c.Check(indexes, DeepEquals, []string{
+		filepath.Join(repoRoot, "dists/stable/main/binary-amd64/Packages"),
+		filepath.Join(repoRoot, "dists/stable/main/binary-i386/Packages"),
+		filepath.Join(repoRoot, "dists/stable/main/source/Sources"),
+	})
+
+	db, _ := database.OpenDB(c.MkDir())
+	defer db.Close()
+	pool := files.NewPackagePool(c.MkDir())
+	list := NewPackageList()
+
+	failed, err := ImportExistingRepository(list, repoRoot, ImportOptions{ConflictPolicy: ConflictFail}, true, pool, NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(failed, DeepEquals, []string{"broken_1.0_amd64", "app-src_1.0_source"})
+	c.Check(s.reporter.Adds, DeepEquals, []string{"app_1.0_amd64 added", "data_1.0_all added"})
+	c.Check(list.Len(), Equals, 2)
+
+	poolPath, _ := pool.Path("app_1.0_amd64.deb", fmt.Sprintf("%x", md5.Sum(good)))
+	info1, err := os.Stat(poolPath)
+	c.Assert(err, IsNil)
+	info2, _ := os.Stat(filepath.Join(repoRoot, "pool/main/a/app/app_1.0_amd64.deb"))
+	c.Check(os.SameFile(info1, info2), Equals, true)
+
+	_, err = ImportExistingRepository(NewPackageList(), c.MkDir(), ImportOptions{ConflictPolicy: ConflictFail}, true, pool, NewPackageCollection(db), s.reporter)
+	c.Check(err, NotNil)
+}
+
+func (s *ImportSuite) TestImportExistingRepositoryOutsideRoot(c *C) {
+	dir := c.MkDir()
+	repoRoot := filepath.Join(dir, "repo")
+
+	os.MkdirAll(filepath.Join(repoRoot, "dists/stable/main/binary-amd64"), 0755)
+
+	secret := []byte("not a part of repository")
+	ioutil.WriteFile(filepath.Join(dir, "secret_1.0_amd64.deb"), secret, 0644)
+
+	ioutil.WriteFile(filepath.Join(repoRoot, "dists/stable/main/binary-amd64/Packages"), []byte(fmt.Sprintf(
+		"Package: secret\nVersion: 1.0\nArchitecture: amd64\nFilename: pool/main/../../../secret_1.0_amd64.deb\nSize: %d\nMD5sum: %x\n\n",
+		len(secret), md5.Sum(secret))), 0644)
+
+	db, _ := database.OpenDB(c.MkDir())
+	defer db.Close()
+	pool := files.NewPackagePool(c.MkDir())
+	list := NewPackageList()
+
+	failed, err := ImportExistingRepository(list, repoRoot, ImportOptions{ConflictPolicy: ConflictFail}, false, pool, NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(failed, DeepEquals, []string{"secret_1.0_amd64"})
+	c.Check(s.reporter.Warnings, DeepEquals, []string{"secret_1.0_amd64 skipped: file ../secret_1.0_amd64.deb is outside of repository root"})
+	c.Check(list.Len(), Equals, 0)
+
+	poolPath, _ := pool.Path("secret_1.0_amd64.deb", fmt.Sprintf("%x", md5.Sum(secret)))
+	c.Check(poolPath, Not(PathExists))
+}
+
+// writeTestDeb builds minimal .deb package with control file only
+func writeTestDeb(path, name, version, arch string) error {
+	control := fmt.Sprintf("Package: %s\nVersion: %s\nArchitecture: %s\nMaintainer: Aptly Tester <test@aptly.info>\nDescription: test package\n",
+		name, version, arch)
+
+	var controlTar bytes.Buffer
+	gz := gzip.NewWriter(&controlTar)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "./control", Mode: 0644, Size: int64(len(control))})
+	tw.Write([]byte(control))
+	tw.Close()
+	gz.Close()
+
+	var deb bytes.Buffer
+	deb.WriteString("!<arch>\n")
+	for _, member := range []struct {
+		name string
+		data []byte
+	}{{"debian-binary", []byte("2.0\n")}, {"control.tar.gz", controlTar.Bytes()}} {
+		fmt.Fprintf(&deb, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", member.name, 0, 0, 0, "100644", len(member.data))
+		deb.Write(member.data)
+		if len(member.data)%2 == 1 {
+			deb.WriteByte('\n')
+		}
+	}
+
+	return ioutil.WriteFile(path, deb.Bytes(), 0644)
+}
+
+func writeTestDebs(dir string, count int) ([]string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	packageFiles := []string{}
+	for i := 0; i < count; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("pkg%03d_1.0_amd64.deb", i))
+		err := writeTestDeb(path, fmt.Sprintf("pkg%03d", i), "1.0", "amd64")
+		if err != nil {
+			return nil, err
+		}
+		packageFiles = append(packageFiles, path)
+	}
+
+	return packageFiles, nil
+}
+
+func (s *ImportSuite) TestImportPackageFilesConcurrent(c *C) {
+	packageFiles, err := writeTestDebs(c.MkDir(), 100)
+	c.Assert(err, IsNil)
+
+	// one broken package in the middle
+	c.Assert(ioutil.WriteFile(packageFiles[50], []byte("garbage"), 0644), IsNil)
+
+	db, _ := database.OpenDB(c.MkDir())
+	defer db.Close()
+	pool := files.NewPackagePool(c.MkDir())
+	list := NewPackageList()
+
+	processedFiles, failedFiles, err := ImportPackageFiles(list, packageFiles, ImportOptions{ConflictPolicy: ConflictFail}, pool, NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(failedFiles, DeepEquals, []string{packageFiles[50]})
+	c.Check(processedFiles, HasLen, 99)
+	c.Check(list.Len(), Equals, 99)
+
+	// reporting follows order of files
+	c.Assert(s.reporter.Adds, HasLen, 99)
+	for i, j := 0, 0; i < 100; i++ {
+		if i == 50 {
+			continue
+		}
+		c.Check(s.reporter.Adds[j], Equals, fmt.Sprintf("pkg%03d_1.0_amd64 added", i))
+		c.Check(processedFiles[j], Equals, packageFiles[i])
+		j++
+	}
+}
+
+func (s *ImportSuite) TestImportPackageFilesTwice(c *C) {
+	packageFiles, err := writeTestDebs(c.MkDir(), 10)
+	c.Assert(err, IsNil)
+
+	db, _ := database.OpenDB(c.MkDir())
+	defer db.Close()
+	pool := files.NewPackagePool(c.MkDir())
+	list := NewPackageList()
+
+	// first run is "interrupted" after half of the files
+	_, _, err = ImportPackageFiles(list, packageFiles[:5], ImportOptions{ConflictPolicy: ConflictFail}, pool, NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(s.reporter.Adds, HasLen, 5)
+
+	s.reporter.Adds, s.reporter.Warnings = []string{}, []string{}
+
+	processedFiles, failedFiles, err := ImportPackageFiles(list, packageFiles, ImportOptions{ConflictPolicy: ConflictFail}, pool, NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(failedFiles, HasLen, 0)
+	c.Check(processedFiles, DeepEquals, packageFiles)
+	c.Check(s.reporter.Adds, HasLen, 5)
+	c.Check(s.reporter.Warnings, HasLen, 5)
+	c.Check(s.reporter.Warnings[0], Equals, "pkg000_1.0_amd64 skipped: already in the repository")
+
+	// third run skips everything
+	s.reporter.Adds, s.reporter.Warnings = []string{}, []string{}
+
+	_, _, err = ImportPackageFiles(list, packageFiles, ImportOptions{ConflictPolicy: ConflictFail}, pool, NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(s.reporter.Adds, HasLen, 0)
+	c.Check(s.reporter.Warnings, HasLen, 10)
+	c.Check(list.Len(), Equals, 10)
+}
+
+func (s *ImportSuite) TestImportPackageFilesIdenticalSource(c *C) {
+	_, _File, _, _ := runtime.Caller(0)
+	dir := c.MkDir()
+
+	for _, name := range []string{"pyspi_0.6.1-1.3.dsc", "pyspi_0.6.1-1.3.diff.gz", "pyspi_0.6.1.orig.tar.gz"} {
+		data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(_File), "../system/files", name))
+		c.Assert(err, IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, name), data, 0644), IsNil)
+	}
+
+	db, _ := database.OpenDB(c.MkDir())
+	defer db.Close()
+	pool := files.NewPackagePool(c.MkDir())
+	list := NewPackageList()
+	dsc := filepath.Join(dir, "pyspi_0.6.1-1.3.dsc")
+
+	processedFiles, _, err := ImportPackageFiles(list, []string{dsc}, ImportOptions{ConflictPolicy: ConflictFail}, pool, NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(processedFiles, HasLen, 3)
+
+	// source package is already in the repository, all its files are still processed
+	s.reporter.Warnings = []string{}
+	processedFiles2, _, err := ImportPackageFiles(list, []string{dsc}, ImportOptions{ConflictPolicy: ConflictFail}, pool, NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(s.reporter.Warnings, DeepEquals, []string{"pyspi_0.6.1-1.3_source skipped: already in the repository"})
+	c.Check(processedFiles2, HasLen, 3)
+	c.Check(processedFiles2[0], Equals, dsc)
+	c.Check(processedFiles2, DeepEquals, processedFiles)
+}
+
+func (s *ImportSuite) TestImportPackageFilesDowngrade(c *C) {
+	dir := c.MkDir()
+	newer, older := filepath.Join(dir, "app_2.0_amd64.deb"), filepath.Join(dir, "app_1.10_amd64.deb")
+	c.Assert(writeTestDeb(newer, "app", "2.0", "amd64"), IsNil)
+	c.Assert(writeTestDeb(older, "app", "1.10", "amd64"), IsNil)
+
+	db, _ := database.OpenDB(c.MkDir())
+	defer db.Close()
+	pool := files.NewPackagePool(c.MkDir())
+	list := NewPackageList()
+
+	_, _, err := ImportPackageFiles(list, []string{newer}, ImportOptions{ConflictPolicy: ConflictFail}, pool, NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+
+	// rejected with noDowngrade
+	_, failedFiles, err := ImportPackageFiles(list, []string{older}, ImportOptions{ConflictPolicy: ConflictFail, NoDowngrade: true}, pool, NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(failedFiles, DeepEquals, []string{older})
+	c.Check(s.reporter.Warnings, DeepEquals, []string{"app_1.10_amd64 rejected: downgrade from version 2.0 already in the repository"})
+	c.Check(list.Len(), Equals, 1)
+
+	// warning by default
+	s.reporter.Warnings = []string{}
+	_, failedFiles, err = ImportPackageFiles(list, []string{older}, ImportOptions{ConflictPolicy: ConflictFail}, pool, NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(failedFiles, HasLen, 0)
+	c.Check(s.reporter.Warnings, DeepEquals, []string{"app_1.10_amd64 is a downgrade: version 2.0 is already in the repository"})
+	c.Check(list.Len(), Equals, 2)
+}
+
+func (s *ImportSuite) TestImportPackageFilesNormalizeFields(c *C) {
+	dir := c.MkDir()
+	debFile := filepath.Join(dir, "app_1.0_amd64.deb")
+	c.Assert(writeTestDeb(debFile, "app", "1.0", "amd64"), IsNil)
+
+	db, _ := database.OpenDB(c.MkDir())
+	defer db.Close()
+	pool := files.NewPackagePool(c.MkDir())
+
+	// not normalized by default
+	list := NewPackageList()
+	_, _, err := ImportPackageFiles(list, []string{debFile}, ImportOptions{ConflictPolicy: ConflictFail}, pool, NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(s.reporter.Warnings, HasLen, 0)
+	c.Check(list.Len(), Equals, 1)
+	list.ForEach(func(p *Package) error {
+		c.Check(p.Stanza()["Section"], Equals, "")
+		return nil
+	})
+
+	list = NewPackageList()
+	_, failedFiles, err := ImportPackageFiles(list, []string{debFile}, ImportOptions{ConflictPolicy: ConflictFail, NormalizeFields: true}, pool, NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(failedFiles, HasLen, 0)
+	c.Check(s.reporter.Warnings, DeepEquals, []string{"app_1.0_amd64: missing Section, set to misc"})
+	c.Check(s.reporter.Adds, DeepEquals, []string{"app_1.0_amd64 added", "app_1.0_amd64 added"})
+	list.ForEach(func(p *Package) error {
+		c.Check(p.Stanza()["Section"], Equals, "misc")
+		return nil
+	})
+}
+
+func (s *ImportSuite) TestNormalizeFields(c *C) {
+	p := NewPackageFromControlFile(Stanza{"Package": "app", "Version": "1.0", "Architecture": "amd64",
+		"Section": "utils", "Priority": "Optional"})
+	c.Check(NormalizeFields(p), DeepEquals, []string{"Priority \"Optional\" normalized to optional"})
+	c.Check(p.Stanza()["Priority"], Equals, "optional")
+	c.Check(p.Stanza()["Section"], Equals, "utils")
+	c.Check(NormalizeFields(p), HasLen, 0)
+
+	p = NewPackageFromControlFile(Stanza{"Package": "app", "Version": "1.0", "Architecture": "amd64",
+		"Priority": "urgent"})
+	c.Check(NormalizeFields(p), DeepEquals, []string{"missing Section, set to misc", "unknown Priority \"urgent\""})
+	c.Check(p.Stanza()["Priority"], Equals, "urgent")
+
+	// .dsc doesn't have Section, so it's not filled in for source packages
+	p = NewPackageFromControlFile(Stanza{"Package": "app", "Version": "1.0", "Architecture": "source"})
+	p.IsSource = true
+	c.Check(NormalizeFields(p), HasLen, 0)
+	c.Check(p.Extra()["Section"], Equals, "")
+}
+
+func (s *ImportSuite) TestImportPackageFilesDsc(c *C) {
+	_, _File, _, _ := runtime.Caller(0)
+	signed := filepath.Join(filepath.Dir(_File), "../system/files/pyspi_0.6.1-1.3.dsc")
+	plain := filepath.Join(filepath.Dir(_File), "../system/files/pyspi-0.6.1-1.3.stripped.dsc")
+
+	db, _ := database.OpenDB(c.MkDir())
+	defer db.Close()
+	pool := files.NewPackagePool(c.MkDir())
+	list := NewPackageList()
+
+	processedFiles, failedFiles, err := ImportPackageFiles(list, []string{signed, plain}, ImportOptions{ConflictPolicy: ConflictFail}, pool, NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(failedFiles, HasLen, 0)
+	c.Check(processedFiles, HasLen, 2*3)
+	c.Check(s.reporter.Adds, DeepEquals, []string{"pyspi_0.6.1-1.3_source added", "pyspi_0.6.1-1.4_source added"})
+
+	// with verification, unsigned .dsc is rejected
+	list = NewPackageList()
+	s.reporter.Adds, s.reporter.Warnings = []string{}, []string{}
+
+	_, failedFiles, err = ImportPackageFiles(list, []string{signed, plain}, ImportOptions{ConflictPolicy: ConflictFail, Verifier: &NullVerifier{}}, pool, NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(failedFiles, DeepEquals, []string{plain})
+	c.Check(s.reporter.Adds, DeepEquals, []string{"pyspi_0.6.1-1.3_source added"})
+	c.Check(s.reporter.Warnings, HasLen, 1)
+}
+
+func BenchmarkImportPackageFiles(b *testing.B) {
+	root, err := ioutil.TempDir("", "aptly-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	packageFiles, err := writeTestDebs(filepath.Join(root, "debs"), 200)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	db, _ := database.OpenDB(filepath.Join(root, "db"))
+	defer db.Close()
+	pool := files.NewPackagePool(filepath.Join(root, "pool"))
+	reporter := &aptly.RecordingResultReporter{}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, _, err = ImportPackageFiles(NewPackageList(), packageFiles, ImportOptions{ConflictPolicy: ConflictFail}, pool, NewPackageCollection(db), reporter)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func (s *ImportSuite) TestDownloadPackageFiles(c *C) {
+	_, _File, _, _ := runtime.Caller(0)
+	server := httptest.NewServer(nethttp.FileServer(nethttp.Dir(filepath.Join(filepath.Dir(_File), "../system/files"))))
+	defer server.Close()
+
+	progress := console.NewProgress()
+	progress.Start()
+	defer progress.Shutdown()
+
+	downloader := http.NewDownloader(context.Background(), 2, 0, "", progress)
+	dir := c.MkDir()
+
+	packageFiles, failedFiles := DownloadPackageFiles(downloader, []string{
+		server.URL + "/libboost-program-options-dev_1.49.0.1_i386.deb",
+		server.URL + "/pyspi_0.6.1-1.3.dsc",
+		server.URL + "/missing_1.0_amd64.deb",
+		server.URL + "/README",
+	}, dir, nil, s.reporter)
+	c.Check(packageFiles, DeepEquals, []string{
+		filepath.Join(dir, "0", "libboost-program-options-dev_1.49.0.1_i386.deb"),
+		filepath.Join(dir, "1", "pyspi_0.6.1-1.3.dsc"),
+	})
+	c.Check(failedFiles, DeepEquals, []string{server.URL + "/missing_1.0_amd64.deb", server.URL + "/README"})
+	c.Check(s.reporter.Warnings, HasLen, 2)
+	c.Check(s.reporter.Warnings[0], Matches, "Unable to download .*/missing_1.0_amd64.deb: HTTP code 404.*")
+	c.Check(s.reporter.Warnings[1], Equals, "Unable to download "+server.URL+"/README: unknown file extension: README")
+
+	for _, name := range []string{"pyspi_0.6.1-1.3.diff.gz", "pyspi_0.6.1.orig.tar.gz"} {
+		_, err := os.Stat(filepath.Join(dir, "1", name))
+		c.Check(err, IsNil)
+	}
+
+	db, _ := database.OpenDB(c.MkDir())
+	defer db.Close()
+	pool := files.NewPackagePool(c.MkDir())
+
+	list := NewPackageList()
+	processedFiles, failedFiles, err := ImportPackageFiles(list, packageFiles, ImportOptions{ConflictPolicy: ConflictFail}, pool,
+		NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(failedFiles, HasLen, 0)
+	c.Check(processedFiles, HasLen, 4)
+	c.Check(list.Len(), Equals, 2)
+}
+
+func (s *ImportSuite) TestImportKeepVersions(c *C) {
+	dir := c.MkDir()
+	packageFiles := []string{}
+	for _, version := range []string{"1.0", "1.1", "2.0", "1.9"} {
+		path := filepath.Join(dir, fmt.Sprintf("app_%s_amd64.deb", version))
+		c.Assert(writeTestDeb(path, "app", version, "amd64"), IsNil)
+		packageFiles = append(packageFiles, path)
+	}
+
+	db, _ := database.OpenDB(c.MkDir())
+	defer db.Close()
+	pool := files.NewPackagePool(c.MkDir())
+
+	list := NewPackageList()
+	_, failedFiles, err := ImportPackageFiles(list, packageFiles, ImportOptions{ConflictPolicy: ConflictFail}, pool, NewPackageCollection(db), s.reporter)
+	c.Assert(err, IsNil)
+	c.Check(failedFiles, HasLen, 0)
+	c.Check(list.Len(), Equals, 4)
+
+	removed := list.RemoveOldVersions(2)
+	c.Check(removed, HasLen, 2)
+	c.Check(list.Len(), Equals, 2)
+
+	versions := []string{}
+	list.PrepareIndex()
+	list.ForEachIndexed(func(p *Package) error {
+		versions = append(versions, p.Version)
+		return nil
+	})
+	c.Check(versions, DeepEquals, []string{"2.0", "1.9"})
+}
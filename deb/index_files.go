@@ -14,9 +14,12 @@ type indexFiles struct {
 	publishedStorage aptly.PublishedStorage
 	basePath         string
 	renameMap        map[string]string
+	uploadedFiles    []string
 	generatedFiles   map[string]utils.ChecksumInfo
 	tempDir          string
 	suffix           string
+	compressions     []string
+	levels           utils.CompressionLevels
 	indexes          map[string]*indexFile
 }
 
@@ -25,6 +28,8 @@ type indexFile struct {
 	discardable  bool
 	compressable bool
 	signable     bool
+	skipDetached bool
+	skipPlain    bool
 	relativePath string
 	tempFilename string
 	tempFile     *os.File
@@ -61,7 +66,7 @@ func (file *indexFile) Finalize(signer utils.Signer) error {
 	}
 
 	if file.compressable {
-		err = utils.CompressFile(file.tempFile)
+		err = utils.CompressFileFormats(file.tempFile, file.parent.compressions, file.parent.levels)
 		if err != nil {
 			file.tempFile.Close()
 			return fmt.Errorf("unable to compress index file: %s", err)
@@ -72,7 +77,9 @@ func (file *indexFile) Finalize(signer utils.Signer) error {
 
 	exts := []string{""}
 	if file.compressable {
-		exts = append(exts, ".gz", ".bz2")
+		for _, format := range file.parent.compressions {
+			exts = append(exts, "."+format)
+		}
 	}
 
 	for _, ext := range exts {
@@ -91,7 +98,12 @@ func (file *indexFile) Finalize(signer utils.Signer) error {
 	}
 
 	for _, ext := range exts {
-		err = file.parent.publishedStorage.PutFile(filepath.Join(file.parent.basePath, file.relativePath+file.parent.suffix+ext),
+		if ext == "" && file.skipPlain && file.signable && signer != nil {
+			// only signed version (InRelease) is published
+			continue
+		}
+
+		err = file.parent.putFile(filepath.Join(file.parent.basePath, file.relativePath+file.parent.suffix+ext),
 			file.tempFilename+ext)
 		if err != nil {
 			return fmt.Errorf("unable to publish file: %s", err)
@@ -104,9 +116,11 @@ func (file *indexFile) Finalize(signer utils.Signer) error {
 	}
 
 	if file.signable && signer != nil {
-		err = signer.DetachedSign(file.tempFilename, file.tempFilename+".gpg")
-		if err != nil {
-			return fmt.Errorf("unable to detached sign file: %s", err)
+		if !file.skipDetached {
+			err = signer.DetachedSign(file.tempFilename, file.tempFilename+".gpg")
+			if err != nil {
+				return fmt.Errorf("unable to detached sign file: %s", err)
+			}
 		}
 
 		err = signer.ClearSign(file.tempFilename, filepath.Join(filepath.Dir(file.tempFilename), "In"+filepath.Base(file.tempFilename)))
@@ -115,19 +129,23 @@ func (file *indexFile) Finalize(signer utils.Signer) error {
 		}
 
 		if file.parent.suffix != "" {
-			file.parent.renameMap[filepath.Join(file.parent.basePath, file.relativePath+file.parent.suffix+".gpg")] =
-				filepath.Join(file.parent.basePath, file.relativePath+".gpg")
+			if !file.skipDetached {
+				file.parent.renameMap[filepath.Join(file.parent.basePath, file.relativePath+file.parent.suffix+".gpg")] =
+					filepath.Join(file.parent.basePath, file.relativePath+".gpg")
+			}
 			file.parent.renameMap[filepath.Join(file.parent.basePath, "In"+file.relativePath+file.parent.suffix)] =
 				filepath.Join(file.parent.basePath, "In"+file.relativePath)
 		}
 
-		err = file.parent.publishedStorage.PutFile(filepath.Join(file.parent.basePath, file.relativePath+file.parent.suffix+".gpg"),
-			file.tempFilename+".gpg")
-		if err != nil {
-			return fmt.Errorf("unable to publish file: %s", err)
+		if !file.skipDetached {
+			err = file.parent.putFile(filepath.Join(file.parent.basePath, file.relativePath+file.parent.suffix+".gpg"),
+				file.tempFilename+".gpg")
+			if err != nil {
+				return fmt.Errorf("unable to publish file: %s", err)
+			}
 		}
 
-		err = file.parent.publishedStorage.PutFile(filepath.Join(file.parent.basePath, "In"+file.relativePath+file.parent.suffix),
+		err = file.parent.putFile(filepath.Join(file.parent.basePath, "In"+file.relativePath+file.parent.suffix),
 			filepath.Join(filepath.Dir(file.tempFilename), "In"+filepath.Base(file.tempFilename)))
 		if err != nil {
 			return fmt.Errorf("unable to publish file: %s", err)
@@ -145,10 +163,18 @@ func newIndexFiles(publishedStorage aptly.PublishedStorage, basePath, tempDir, s
 		generatedFiles:   make(map[string]utils.ChecksumInfo),
 		tempDir:          tempDir,
 		suffix:           suffix,
+		compressions:     utils.DefaultCompressions,
 		indexes:          make(map[string]*indexFile),
 	}
 }
 
+// putFile uploads file to published storage and remembers it, so that it could be removed
+// if publishing doesn't complete
+func (files *indexFiles) putFile(path string, sourceFilename string) error {
+	files.uploadedFiles = append(files.uploadedFiles, path)
+	return files.publishedStorage.PutFile(path, sourceFilename)
+}
+
 func (files *indexFiles) PackageIndex(component, arch string, udeb bool) *indexFile {
 	if arch == "source" {
 		udeb = false
@@ -256,5 +282,26 @@ func (files *indexFiles) RenameFiles() error {
 		}
 	}
 
+	// files are in place now, publishing is complete
+	files.uploadedFiles = nil
+
+	return nil
+}
+
+// RemoveTempFiles removes files uploaded, but not yet renamed into place
+//
+// When publishing for the first time (without suffix), files are uploaded under
+// final names, so all of them are removed: there's no previous version to keep.
+func (files *indexFiles) RemoveTempFiles() error {
+	for _, path := range files.uploadedFiles {
+		err := files.publishedStorage.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove: %s", err)
+		}
+	}
+
+	files.uploadedFiles = nil
+	files.renameMap = make(map[string]string)
+
 	return nil
 }
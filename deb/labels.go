@@ -0,0 +1,86 @@
+package deb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Labels are arbitrary key=value pairs attached to local repos, mirrors and snapshots
+type Labels map[string]string
+
+// ParseLabels parses comma-separated list of labels in form key=value
+func ParseLabels(s string) (Labels, error) {
+	result := Labels{}
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		i := strings.Index(item, "=")
+		if i == -1 {
+			return nil, fmt.Errorf("wrong label %s, should be key=value", item)
+		}
+
+		key, value := strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])
+		if key == "" {
+			return nil, fmt.Errorf("wrong label %s, key is empty", item)
+		}
+
+		result[key] = value
+	}
+
+	return result, nil
+}
+
+// String returns labels as comma-separated list sorted by key
+func (l Labels) String() string {
+	keys := make([]string, 0, len(l))
+	for key := range l {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for i, key := range keys {
+		keys[i] = key + "=" + l[key]
+	}
+
+	return strings.Join(keys, ",")
+}
+
+// Matches checks whether every label from filter is set to the same value
+func (l Labels) Matches(filter Labels) bool {
+	for key, value := range filter {
+		v, ok := l[key]
+		if !ok || v != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Merge returns labels updated with labels from other, labels with empty
+// values in other are removed
+func (l Labels) Merge(other Labels) Labels {
+	result := Labels{}
+	for key, value := range l {
+		result[key] = value
+	}
+
+	for key, value := range other {
+		if value == "" {
+			delete(result, key)
+		} else {
+			result[key] = value
+		}
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+
+	return result
+}
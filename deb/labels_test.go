@@ -0,0 +1,53 @@
+package deb
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type LabelsSuite struct{}
+
+var _ = Suite(&LabelsSuite{})
+
+func (s *LabelsSuite) TestParseLabels(c *C) {
+	l, err := ParseLabels("")
+	c.Check(err, IsNil)
+	c.Check(l, HasLen, 0)
+
+	l, err = ParseLabels("env=prod, team = core,empty=")
+	c.Check(err, IsNil)
+	c.Check(l, DeepEquals, Labels{"env": "prod", "team": "core", "empty": ""})
+
+	_, err = ParseLabels("env")
+	c.Check(err, ErrorMatches, "wrong label env, should be key=value")
+
+	_, err = ParseLabels("=prod")
+	c.Check(err, ErrorMatches, "wrong label =prod, key is empty")
+}
+
+func (s *LabelsSuite) TestString(c *C) {
+	c.Check(Labels(nil).String(), Equals, "")
+	c.Check(Labels{"team": "core", "env": "prod"}.String(), Equals, "env=prod,team=core")
+}
+
+func (s *LabelsSuite) TestMatches(c *C) {
+	l := Labels{"env": "prod", "team": "core"}
+
+	c.Check(l.Matches(nil), Equals, true)
+	c.Check(l.Matches(Labels{"env": "prod"}), Equals, true)
+	c.Check(l.Matches(Labels{"env": "prod", "team": "core"}), Equals, true)
+	c.Check(l.Matches(Labels{"env": "dev"}), Equals, false)
+	c.Check(l.Matches(Labels{"owner": "me"}), Equals, false)
+	c.Check(Labels(nil).Matches(Labels{"env": "prod"}), Equals, false)
+}
+
+func (s *LabelsSuite) TestMerge(c *C) {
+	l := Labels{"env": "prod", "team": "core"}
+
+	c.Check(l.Merge(Labels{"env": "dev", "owner": "me"}), DeepEquals, Labels{"env": "dev", "team": "core", "owner": "me"})
+	c.Check(l.Merge(Labels{"team": ""}), DeepEquals, Labels{"env": "prod"})
+	c.Check(l.Merge(Labels{"team": "", "env": ""}), IsNil)
+	c.Check(Labels(nil).Merge(Labels{"env": "prod"}), DeepEquals, Labels{"env": "prod"})
+
+	// original labels are not modified
+	c.Check(l, DeepEquals, Labels{"env": "prod", "team": "core"})
+}
@@ -1,6 +1,7 @@
 package deb
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/smira/aptly/aptly"
 	"github.com/smira/aptly/utils"
@@ -197,6 +198,58 @@ func (l *PackageList) Remove(p *Package) {
 	}
 }
 
+// packagesByVersion sorts packages from latest to oldest version
+type packagesByVersion []*Package
+
+func (s packagesByVersion) Len() int {
+	return len(s)
+}
+
+func (s packagesByVersion) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s packagesByVersion) Less(i, j int) bool {
+	return CompareVersions(s[i].Version, s[j].Version) > 0
+}
+
+// RemoveOldVersions removes all but keep latest versions of each package (by name
+// and architecture) from the list, returning removed packages
+//
+// If keep is zero or negative, list is not modified
+func (l *PackageList) RemoveOldVersions(keep int) (removed []*Package) {
+	if keep <= 0 {
+		return
+	}
+
+	versions := make(map[string][]*Package)
+	for _, p := range l.packages {
+		key := p.Architecture + " " + p.Name
+		versions[key] = append(versions[key], p)
+	}
+
+	keys := make([]string, 0, len(versions))
+	for key := range versions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		packages := versions[key]
+		if len(packages) <= keep {
+			continue
+		}
+
+		sort.Sort(packagesByVersion(packages))
+		for _, p := range packages[keep:] {
+			l.Remove(p)
+			removed = append(removed, p)
+		}
+	}
+
+	return
+}
+
 // Architectures returns list of architectures present in packages and flag if source packages are present.
 //
 // If includeSource is true, meta-architecture "source" would be present in the list
@@ -250,12 +303,19 @@ func depSliceDeduplicate(s []Dependency) []Dependency {
 	return s[:j]
 }
 
-// VerifyDependencies looks for missing dependencies in package list.
+// walkUnsatisfiedDependencies checks dependencies of packages in the list for each architecture
+// against sources, calling handler for each dependency which can't be satisfied
 //
-// Analysis would be peformed for each architecture, in specified sources
-func (l *PackageList) VerifyDependencies(options int, architectures []string, sources *PackageList, progress aptly.Progress) ([]Dependency, error) {
+// Handler gets package and its missing dependency, cached is true if the same dependency has
+// already been looked up for this architecture (e.g. while processing other package).
+func (l *PackageList) walkUnsatisfiedDependencies(options int, architectures []string, sources *PackageList, progress aptly.Progress,
+	handler func(p *Package, dep Dependency, cached bool)) error {
+	type missingVariant struct {
+		dep    Dependency
+		cached bool
+	}
+
 	l.PrepareIndex()
-	missing := make([]Dependency, 0, 128)
 
 	if progress != nil {
 		progress.InitBar(int64(l.Len())*int64(len(architectures)), false)
@@ -276,27 +336,28 @@ func (l *PackageList) VerifyDependencies(options int, architectures []string, so
 			for _, dep := range p.GetDependencies(options) {
 				variants, err := ParseDependencyVariants(dep)
 				if err != nil {
-					return nil, fmt.Errorf("unable to process package %s: %s", p, err)
+					return fmt.Errorf("unable to process package %s: %s", p, err)
 				}
 
 				variants = depSliceDeduplicate(variants)
 
-				variantsMissing := make([]Dependency, 0, len(variants))
+				variantsMissing := make([]missingVariant, 0, len(variants))
 
 				for _, dep := range variants {
 					if dep.Architecture == "" {
 						dep.Architecture = arch
 					}
+					dep.Udeb = p.IsUdeb
 
 					hash := dep.Hash()
 					satisfied, ok := cache[hash]
 					if !ok {
-						satisfied = sources.Search(dep, false) != nil
+						satisfied = sources.SearchDependency(dep, false) != nil
 						cache[hash] = satisfied
 					}
 
-					if !satisfied && !ok {
-						variantsMissing = append(variantsMissing, dep)
+					if !satisfied {
+						variantsMissing = append(variantsMissing, missingVariant{dep: dep, cached: ok})
 					}
 
 					if satisfied && options&DepFollowAllVariants == 0 {
@@ -305,7 +366,9 @@ func (l *PackageList) VerifyDependencies(options int, architectures []string, so
 					}
 				}
 
-				missing = append(missing, variantsMissing...)
+				for _, variant := range variantsMissing {
+					handler(p, variant.dep, variant.cached)
+				}
 			}
 		}
 	}
@@ -314,9 +377,138 @@ func (l *PackageList) VerifyDependencies(options int, architectures []string, so
 		progress.ShutdownBar()
 	}
 
+	return nil
+}
+
+// VerifyDependencies looks for missing dependencies in package list.
+//
+// Analysis would be peformed for each architecture, in specified sources
+func (l *PackageList) VerifyDependencies(options int, architectures []string, sources *PackageList, progress aptly.Progress) ([]Dependency, error) {
+	missing := make([]Dependency, 0, 128)
+
+	err := l.walkUnsatisfiedDependencies(options, architectures, sources, progress, func(p *Package, dep Dependency, cached bool) {
+		// each missing dependency is reported once
+		if !cached {
+			missing = append(missing, dep)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	return missing, nil
 }
 
+// PackageDependencyProblems is a list of unsatisfied dependencies of a package
+type PackageDependencyProblems struct {
+	Package *Package
+	Missing []Dependency
+}
+
+// FindDependencyProblems looks for packages in the list with dependencies which
+// can't be satisfied by packages from sources
+//
+// Unlike VerifyDependencies, missing dependencies are reported for every package
+// depending on them. Result is sorted by package.
+func (l *PackageList) FindDependencyProblems(options int, architectures []string, sources *PackageList, progress aptly.Progress) ([]PackageDependencyProblems, error) {
+	problems := map[string]*PackageDependencyProblems{}
+
+	err := l.walkUnsatisfiedDependencies(options, architectures, sources, progress, func(p *Package, dep Dependency, cached bool) {
+		key := string(p.Key(""))
+		problem, exists := problems[key]
+		if !exists {
+			problem = &PackageDependencyProblems{Package: p}
+			problems[key] = problem
+		}
+
+		// arch: all packages are checked for every architecture
+		problem.Missing = append(problem.Missing, dep)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]PackageDependencyProblems, 0, len(problems))
+	for _, problem := range problems {
+		problem.Missing = depSliceDeduplicate(problem.Missing)
+		result = append(result, *problem)
+	}
+
+	sort.Sort(dependencyProblemsByPackage(result))
+
+	return result, nil
+}
+
+type dependencyProblemsByPackage []PackageDependencyProblems
+
+func (d dependencyProblemsByPackage) Len() int      { return len(d) }
+func (d dependencyProblemsByPackage) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d dependencyProblemsByPackage) Less(i, j int) bool {
+	return d[i].Package.String() < d[j].Package.String()
+}
+
+// FindDependents looks for packages in the list with dependencies broken by
+// removal of packages from removed list.
+//
+// Dependency is broken if it was satisfied by some package in removed and
+// isn't satisfied by any package left in the list
+func (l *PackageList) FindDependents(removed *PackageList, options int, architectures []string) ([]*Package, error) {
+	l.PrepareIndex()
+	removed.PrepareIndex()
+
+	dependents := make([]*Package, 0)
+
+	for _, p := range l.packagesIndex {
+		broken := false
+
+		for _, arch := range architectures {
+			if !p.MatchesArchitecture(arch) {
+				continue
+			}
+
+			for _, dep := range p.GetDependencies(options) {
+				variants, err := ParseDependencyVariants(dep)
+				if err != nil {
+					return nil, fmt.Errorf("unable to process package %s: %s", p, err)
+				}
+
+				satisfied, satisfiedByRemoved := false, false
+
+				for _, dep := range variants {
+					if dep.Architecture == "" {
+						dep.Architecture = arch
+					}
+					dep.Udeb = p.IsUdeb
+
+					if l.SearchDependency(dep, false) != nil {
+						satisfied = true
+						break
+					}
+
+					if removed.SearchDependency(dep, false) != nil {
+						satisfiedByRemoved = true
+					}
+				}
+
+				if !satisfied && satisfiedByRemoved {
+					broken = true
+					break
+				}
+			}
+
+			if broken {
+				break
+			}
+		}
+
+		if broken {
+			dependents = append(dependents, p)
+		}
+	}
+
+	return dependents, nil
+}
+
 // Swap swaps two packages in index
 func (l *PackageList) Swap(i, j int) {
 	l.packagesIndex[i], l.packagesIndex[j] = l.packagesIndex[j], l.packagesIndex[i]
@@ -326,6 +518,10 @@ func (l *PackageList) lessPackages(iPkg, jPkg *Package) bool {
 	if iPkg.Name == jPkg.Name {
 		cmp := CompareVersions(iPkg.Version, jPkg.Version)
 		if cmp == 0 {
+			if iPkg.Architecture == jPkg.Architecture {
+				// packages differing only in files (or version spelling), key makes order stable
+				return bytes.Compare(iPkg.Key(""), jPkg.Key("")) < 0
+			}
 			return iPkg.Architecture < jPkg.Architecture
 		}
 		return cmp == 1
@@ -334,7 +530,8 @@ func (l *PackageList) lessPackages(iPkg, jPkg *Package) bool {
 	return iPkg.Name < jPkg.Name
 }
 
-// Less compares two packages by name (lexographical) and version (latest to oldest)
+// Less compares two packages by name (lexographical), version (latest to oldest),
+// architecture and key, so order of packages never depends on order of adding them
 func (l *PackageList) Less(i, j int) bool {
 	return l.lessPackages(l.packagesIndex[i], l.packagesIndex[j])
 }
@@ -428,6 +625,37 @@ func (l *PackageList) Search(dep Dependency, allMatches bool) (searchResults []*
 	return
 }
 
+// SearchDependency searches package index for package(s) satisfying dependency
+// of other package
+//
+// Dependencies of .udeb packages are satisfied only by .udeb packages, and dependencies
+// of regular packages only by other regular (or source) packages
+func (l *PackageList) SearchDependency(dep Dependency, allMatches bool) (searchResults []*Package) {
+	if dep.Architecture == "source" {
+		return l.Search(dep, allMatches)
+	}
+
+	if !allMatches {
+		// fast path: first match is of the right kind
+		first := l.Search(dep, false)
+		if first == nil || first[0].IsUdeb == dep.Udeb {
+			return first
+		}
+	}
+
+	for _, p := range l.Search(dep, true) {
+		if p.IsUdeb == dep.Udeb {
+			searchResults = append(searchResults, p)
+
+			if !allMatches {
+				break
+			}
+		}
+	}
+
+	return
+}
+
 // Filter filters package index by specified queries (ORed together), possibly pulling dependencies
 func (l *PackageList) Filter(queries []PackageQuery, withDependencies bool, source *PackageList, dependencyOptions int, architecturesList []string) (*PackageList, error) {
 	if !l.indexed {
@@ -465,11 +693,11 @@ func (l *PackageList) Filter(queries []PackageQuery, withDependencies bool, sour
 			for _, dep := range missing {
 				// dependency might have already been satisfied
 				// with packages already been added
-				if result.Search(dep, false) != nil {
+				if result.SearchDependency(dep, false) != nil {
 					continue
 				}
 
-				searchResults := l.Search(dep, false)
+				searchResults := l.SearchDependency(dep, false)
 				if searchResults != nil {
 					for _, p := range searchResults {
 						result.Add(p)
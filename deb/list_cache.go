@@ -0,0 +1,144 @@
+package deb
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// PackageListCache keeps recently used package lists loaded from database,
+// so that repeated reads of the same collection don't load packages again
+//
+// Lists are keyed by ID of collection (UUID of snapshot, local repo, etc.),
+// and should be invalidated when collection contents are modified. Cached
+// list is also reloaded if reflist doesn't match the one list was loaded from,
+// so readers racing with modification never get stale list. Least recently
+// used list is evicted when cache is full.
+type PackageListCache struct {
+	sync.Mutex
+	maxEntries int
+	lists      map[string]cachedPackageList
+	order      []string
+}
+
+type cachedPackageList struct {
+	list        *PackageList
+	fingerprint uint64
+}
+
+// refListFingerprint calculates hash of all the refs in the list
+func refListFingerprint(reflist *PackageRefList) uint64 {
+	h := fnv.New64a()
+	for _, ref := range reflist.Refs {
+		h.Write(ref)
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// NewPackageListCache creates cache holding up to maxEntries lists
+func NewPackageListCache(maxEntries int) *PackageListCache {
+	return &PackageListCache{
+		maxEntries: maxEntries,
+		lists:      make(map[string]cachedPackageList, maxEntries),
+	}
+}
+
+// touch moves id to the end of eviction order, should be called under lock
+func (cache *PackageListCache) touch(id string) {
+	for i, key := range cache.order {
+		if key == id {
+			cache.order = append(cache.order[:i], cache.order[i+1:]...)
+			break
+		}
+	}
+	cache.order = append(cache.order, id)
+}
+
+// Get returns indexed package list of collection id, loading it from reflist
+// if it's not cached yet
+//
+// Returned list and its packages are shared, so they should never be modified.
+// Packages are loaded completely before list is returned, so that reading them
+// concurrently doesn't trigger lazy loading of fields.
+func (cache *PackageListCache) Get(id string, reflist *PackageRefList, collection *PackageCollection) (*PackageList, error) {
+	fingerprint := refListFingerprint(reflist)
+
+	cache.Lock()
+	cached, ok := cache.lists[id]
+	ok = ok && cached.fingerprint == fingerprint
+	if ok {
+		cache.touch(id)
+	}
+	cache.Unlock()
+
+	if ok {
+		return cached.list, nil
+	}
+
+	list, err := NewPackageListFromRefList(reflist, collection, nil)
+	if err != nil {
+		return nil, err
+	}
+	list.ForEach(func(p *Package) error {
+		p.Files()
+		p.Deps()
+		p.Extra()
+		return nil
+	})
+	list.PrepareIndex()
+
+	if cache.maxEntries <= 0 {
+		return list, nil
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	if _, ok = cache.lists[id]; ok {
+		cache.touch(id)
+	} else {
+		if len(cache.order) >= cache.maxEntries {
+			delete(cache.lists, cache.order[0])
+			cache.order = cache.order[1:]
+		}
+		cache.order = append(cache.order, id)
+	}
+	cache.lists[id] = cachedPackageList{list: list, fingerprint: fingerprint}
+
+	return list, nil
+}
+
+// Invalidate removes cached list of collection id
+func (cache *PackageListCache) Invalidate(id string) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if _, ok := cache.lists[id]; !ok {
+		return
+	}
+
+	delete(cache.lists, id)
+	for i, key := range cache.order {
+		if key == id {
+			cache.order = append(cache.order[:i], cache.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Flush removes all cached lists
+func (cache *PackageListCache) Flush() {
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.lists = make(map[string]cachedPackageList, cache.maxEntries)
+	cache.order = nil
+}
+
+// Len returns number of cached lists
+func (cache *PackageListCache) Len() int {
+	cache.Lock()
+	defer cache.Unlock()
+
+	return len(cache.lists)
+}
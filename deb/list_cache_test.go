@@ -0,0 +1,127 @@
+package deb
+
+import (
+	"github.com/smira/aptly/database"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+type PackageListCacheSuite struct {
+	PackageListMixinSuite
+	db         database.Storage
+	collection *PackageCollection
+	cache      *PackageListCache
+}
+
+var _ = Suite(&PackageListCacheSuite{})
+
+func (s *PackageListCacheSuite) SetUpTest(c *C) {
+	s.SetUpPackages()
+
+	s.db, _ = database.OpenDB(c.MkDir())
+	s.collection = NewPackageCollection(s.db)
+	c.Assert(s.collection.Update(s.p1), IsNil)
+	c.Assert(s.collection.Update(s.p2), IsNil)
+	c.Assert(s.collection.Update(s.p3), IsNil)
+
+	s.cache = NewPackageListCache(2)
+}
+
+func (s *PackageListCacheSuite) TearDownTest(c *C) {
+	s.db.Close()
+}
+
+func (s *PackageListCacheSuite) TestGet(c *C) {
+	list, err := s.cache.Get("repo1", s.reflist, s.collection)
+	c.Assert(err, IsNil)
+	c.Check(list.Len(), Equals, 3)
+	c.Check(list.indexed, Equals, true)
+	c.Check(s.cache.Len(), Equals, 1)
+
+	list2, err := s.cache.Get("repo1", s.reflist, s.collection)
+	c.Assert(err, IsNil)
+	c.Check(list2, Equals, list)
+
+	_, err = s.cache.Get("repo2", NewPackageRefList(), s.collection)
+	c.Assert(err, IsNil)
+	c.Check(s.cache.Len(), Equals, 2)
+}
+
+func (s *PackageListCacheSuite) TestGetConcurrent(c *C) {
+	list, err := s.cache.Get("repo1", s.reflist, s.collection)
+	c.Assert(err, IsNil)
+
+	// cached packages are loaded completely, readers never modify them
+	list.ForEach(func(p *Package) error {
+		c.Check(p.files, NotNil)
+		c.Check(p.deps, NotNil)
+		c.Check(p.extra, NotNil)
+		return nil
+	})
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			list, err := s.cache.Get("repo1", s.reflist, s.collection)
+			if err != nil {
+				return
+			}
+			list.ForEach(func(p *Package) error {
+				p.Stanza()
+				p.Files()
+				p.Deps()
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	c.Check(s.cache.Len(), Equals, 1)
+}
+
+func (s *PackageListCacheSuite) TestMutation(c *C) {
+	list, err := s.cache.Get("repo1", s.reflist, s.collection)
+	c.Assert(err, IsNil)
+	c.Check(list.Len(), Equals, 3)
+
+	// package removed from the repo
+	modified := NewPackageList()
+	modified.Add(s.p1)
+	modified.Add(s.p2)
+	reflist := NewPackageRefListFromPackageList(modified)
+
+	s.cache.Invalidate("repo1")
+	c.Check(s.cache.Len(), Equals, 0)
+
+	list, err = s.cache.Get("repo1", reflist, s.collection)
+	c.Assert(err, IsNil)
+	c.Check(list.Len(), Equals, 2)
+
+	// even without invalidation, list is reloaded when reflist doesn't match
+	list, err = s.cache.Get("repo1", s.reflist, s.collection)
+	c.Assert(err, IsNil)
+	c.Check(list.Len(), Equals, 3)
+	c.Check(s.cache.Len(), Equals, 1)
+}
+
+func (s *PackageListCacheSuite) TestEviction(c *C) {
+	list1, _ := s.cache.Get("repo1", s.reflist, s.collection)
+	s.cache.Get("repo2", s.reflist, s.collection)
+
+	// repo1 is used recently, so repo2 is evicted
+	list, _ := s.cache.Get("repo1", s.reflist, s.collection)
+	c.Check(list, Equals, list1)
+	s.cache.Get("repo3", s.reflist, s.collection)
+	c.Check(s.cache.Len(), Equals, 2)
+
+	list, _ = s.cache.Get("repo1", s.reflist, s.collection)
+	c.Check(list, Equals, list1)
+	c.Check(s.cache.order, DeepEquals, []string{"repo3", "repo1"})
+
+	s.cache.Flush()
+	c.Check(s.cache.Len(), Equals, 0)
+}
@@ -143,6 +143,25 @@ func (s *PackageListSuite) TestRemove(c *C) {
 	c.Check(s.list.Len(), Equals, 1)
 }
 
+func (s *PackageListSuite) TestRemoveOldVersions(c *C) {
+	for _, version := range []string{"1.0", "1.10", "1.2", "1.0~rc1"} {
+		c.Check(s.list.Add(&Package{Name: "app", Version: version, Architecture: "amd64"}), IsNil)
+	}
+	c.Check(s.list.Add(&Package{Name: "app", Version: "1.0", Architecture: "i386"}), IsNil)
+	c.Check(s.list.Add(&Package{Name: "lib", Version: "0.1", Architecture: "amd64"}), IsNil)
+
+	c.Check(s.list.RemoveOldVersions(0), HasLen, 0)
+	c.Check(s.list.Len(), Equals, 6)
+
+	removed := s.list.RemoveOldVersions(2)
+	c.Check(removed, HasLen, 2)
+	c.Check(removed[0].String(), Equals, "app_1.0_amd64")
+	c.Check(removed[1].String(), Equals, "app_1.0~rc1_amd64")
+	keys := s.list.Strings()
+	sort.Strings(keys)
+	c.Check(keys, DeepEquals, []string{"Pamd64 app 1.10", "Pamd64 app 1.2", "Pamd64 lib 0.1", "Pi386 app 1.0"})
+}
+
 func (s *PackageListSuite) TestAddWhenIndexed(c *C) {
 	c.Check(s.list.Len(), Equals, 0)
 	s.list.PrepareIndex()
@@ -231,6 +250,37 @@ func (s *PackageListSuite) TestIndex(c *C) {
 	c.Check(s.il.packagesIndex[0], Equals, s.packages[8])
 }
 
+func (s *PackageListSuite) TestIndexOrderStable(c *C) {
+	packages := []*Package{
+		{Name: "app", Version: "1.0", Architecture: "i386", V06Plus: true, FilesHash: 2},
+		{Name: "app", Version: "1.00", Architecture: "i386", V06Plus: true, FilesHash: 1},
+		{Name: "app", Version: "1.0", Architecture: "amd64", V06Plus: true, FilesHash: 3},
+		{Name: "app", Version: "2.0", Architecture: "i386", V06Plus: true, FilesHash: 4},
+		{Name: "aa", Version: "1.0", Architecture: "i386", V06Plus: true, FilesHash: 5},
+	}
+
+	keys := func(order []int) []string {
+		list := NewPackageList()
+		for _, i := range order {
+			c.Assert(list.Add(packages[i]), IsNil)
+		}
+		list.PrepareIndex()
+
+		result := []string{}
+		list.ForEachIndexed(func(p *Package) error {
+			result = append(result, string(p.Key("")))
+			return nil
+		})
+		return result
+	}
+
+	expected := []string{"Pi386 aa 1.0 00000005", "Pi386 app 2.0 00000004", "Pamd64 app 1.0 00000003",
+		"Pi386 app 1.0 00000002", "Pi386 app 1.00 00000001"}
+	c.Check(keys([]int{0, 1, 2, 3, 4}), DeepEquals, expected)
+	c.Check(keys([]int{4, 3, 2, 1, 0}), DeepEquals, expected)
+	c.Check(keys([]int{1, 3, 0, 4, 2}), DeepEquals, expected)
+}
+
 func (s *PackageListSuite) TestAppend(c *C) {
 	s.list.Add(s.p1)
 	s.list.Add(s.p3)
@@ -415,6 +465,87 @@ func (s *PackageListSuite) TestVerifyDependencies(c *C) {
 	c.Check(err, ErrorMatches, "unable to process package app_1.0_s390:.*")
 }
 
+func (s *PackageListSuite) TestFindDependencyProblems(c *C) {
+	problems, err := s.il.FindDependencyProblems(0, []string{"i386"}, s.il, nil)
+	c.Check(err, IsNil)
+	c.Check(problems, HasLen, 0)
+
+	problems, err = s.il.FindDependencyProblems(0, []string{"i386", "amd64"}, s.il, nil)
+	c.Check(err, IsNil)
+	c.Check(problems, DeepEquals, []PackageDependencyProblems{
+		{Package: s.packages[5], Missing: []Dependency{{Pkg: "lib", Relation: VersionGreater, Version: "0.9", Architecture: "amd64"}}},
+	})
+
+	// all the packages depending on missing package are reported
+	s.il.Remove(s.packages[4])
+
+	problems, err = s.il.FindDependencyProblems(DepFollowAllVariants, []string{"i386", "arm"}, s.il, nil)
+	c.Check(err, IsNil)
+	c.Check(problems, DeepEquals, []PackageDependencyProblems{
+		{Package: s.packages[6], Missing: []Dependency{
+			{Pkg: "lib", Relation: VersionGreater, Version: "0.9", Architecture: "arm"},
+			{Pkg: "mail-agent", Relation: VersionDontCare, Version: "", Architecture: "arm"}}},
+		{Package: s.packages[0], Missing: []Dependency{{Pkg: "mail-agent", Relation: VersionDontCare, Version: "", Architecture: "i386"}}},
+	})
+
+	_, err = s.il.FindDependencyProblems(0, []string{"s390"}, s.il, nil)
+	c.Check(err, ErrorMatches, "unable to process package app_1.0_s390:.*")
+}
+
+func (s *PackageListSuite) TestFindDependents(c *C) {
+	archs := []string{"i386", "amd64", "arm"}
+
+	// leaf package, nobody depends on it
+	removed := NewPackageList()
+	removed.Add(s.packages[8])
+	s.il.Remove(s.packages[8])
+
+	dependents, err := s.il.FindDependents(removed, 0, archs)
+	c.Check(err, IsNil)
+	c.Check(dependents, HasLen, 0)
+
+	// library used by app on i386 only
+	removed = NewPackageList()
+	removed.Add(s.packages[0])
+	s.il.Remove(s.packages[0])
+
+	dependents, err = s.il.FindDependents(removed, 0, archs)
+	c.Check(err, IsNil)
+	c.Check(dependents, DeepEquals, []*Package{s.packages[3]})
+
+	// pre-dependency for various packages on i386, with arch: all package
+	s.il.Add(s.packages[0])
+	s.il.Add(s.packages[8])
+	removed = NewPackageList()
+	removed.Add(s.packages[1])
+	s.il.Remove(s.packages[1])
+
+	dependents, err = s.il.FindDependents(removed, 0, archs)
+	c.Check(err, IsNil)
+	c.Check(dependents, DeepEquals, []*Package{s.packages[8], s.packages[3], s.packages[2], s.packages[0]})
+}
+
+func (s *PackageListSuite) TestVerifyDependenciesUdeb(c *C) {
+	udebs := []*Package{
+		&Package{Name: "installer", Version: "1.0", Architecture: "i386", IsUdeb: true, deps: &PackageDependencies{Depends: []string{"lib (>> 0.9)", "libudev-udeb"}}},
+		&Package{Name: "libudev-udeb", Version: "1.0", Architecture: "i386", IsUdeb: true, deps: &PackageDependencies{}},
+		&Package{Name: "tool", Version: "1.0", Architecture: "i386", deps: &PackageDependencies{Depends: []string{"libudev-udeb"}}},
+	}
+	for _, p := range udebs {
+		s.il.Add(p)
+	}
+
+	missing, err := s.il.VerifyDependencies(0, []string{"i386"}, s.il, nil)
+	c.Check(err, IsNil)
+	c.Check(missing, DeepEquals, []Dependency{
+		Dependency{Pkg: "lib", Relation: VersionGreater, Version: "0.9", Architecture: "i386", Udeb: true},
+		Dependency{Pkg: "libudev-udeb", Relation: VersionDontCare, Version: "", Architecture: "i386"},
+	})
+
+	c.Check(s.il.SearchDependency(Dependency{Pkg: "libudev-udeb", Architecture: "i386", Udeb: true}, false), DeepEquals, []*Package{udebs[1]})
+	c.Check(s.il.SearchDependency(Dependency{Pkg: "libudev-udeb", Architecture: "i386"}, false), IsNil)
+}
+
 func (s *PackageListSuite) TestArchitectures(c *C) {
 	archs := s.il.Architectures(true)
 	sort.Strings(archs)
@@ -7,7 +7,9 @@ import (
 	"github.com/smira/aptly/database"
 	"github.com/ugorji/go/codec"
 	"log"
+	"sort"
 	"sync"
+	"time"
 )
 
 // LocalRepo is a collection of packages created locally
@@ -22,6 +24,15 @@ type LocalRepo struct {
 	DefaultDistribution string `codec:",omitempty"`
 	// DefaultComponent
 	DefaultComponent string `codec:",omitempty"`
+	// Labels attached to the repo
+	Labels Labels `codec:",omitempty" json:",omitempty"`
+	// Number of versions of each package (by name and architecture) retained
+	// when adding packages, 0 means all versions are kept
+	KeepVersions int `codec:",omitempty" json:",omitempty"`
+	// Date of creation (zero for repos created by older versions)
+	CreatedAt time.Time
+	// Date of last modification
+	ModifiedAt time.Time
 	// "Snapshot" of current list of packages
 	packageRefs *PackageRefList
 }
@@ -29,12 +40,21 @@ type LocalRepo struct {
 // NewLocalRepo creates new instance of Debian local repository
 func NewLocalRepo(name string, comment string) *LocalRepo {
 	return &LocalRepo{
-		UUID:    uuid.New(),
-		Name:    name,
-		Comment: comment,
+		UUID:      uuid.New(),
+		Name:      name,
+		Comment:   comment,
+		CreatedAt: time.Now(),
 	}
 }
 
+// ValidateKeepVersions checks number of versions to keep (see KeepVersions)
+func ValidateKeepVersions(keepVersions int) error {
+	if keepVersions < 0 {
+		return fmt.Errorf("number of versions to keep should be non-negative: %d", keepVersions)
+	}
+	return nil
+}
+
 // String interface
 func (repo *LocalRepo) String() string {
 	if repo.Comment != "" {
@@ -90,8 +110,9 @@ func (repo *LocalRepo) RefKey() []byte {
 // LocalRepoCollection does listing, updating/adding/deleting of LocalRepos
 type LocalRepoCollection struct {
 	*sync.RWMutex
-	db   database.Storage
-	list []*LocalRepo
+	db        database.Storage
+	refCounts *RefCounts
+	list      []*LocalRepo
 }
 
 // NewLocalRepoCollection loads LocalRepos from DB and makes up collection
@@ -135,12 +156,22 @@ func (collection *LocalRepoCollection) Add(repo *LocalRepo) error {
 
 // Update stores updated information about repo in DB
 func (collection *LocalRepoCollection) Update(repo *LocalRepo) error {
-	err := collection.db.Put(repo.Key(), repo.Encode())
+	return collection.update(repo, collection.db, collection.refCounts)
+}
+
+func (collection *LocalRepoCollection) update(repo *LocalRepo, rw database.ReaderWriter, refCounts *RefCounts) error {
+	repo.ModifiedAt = time.Now()
+
+	err := rw.Put(repo.Key(), repo.Encode())
 	if err != nil {
 		return err
 	}
 	if repo.packageRefs != nil {
-		err = collection.db.Put(repo.RefKey(), repo.packageRefs.Encode())
+		err = refCounts.Replace(repo.RefKey(), repo.packageRefs)
+		if err != nil {
+			return err
+		}
+		err = rw.Put(repo.RefKey(), repo.packageRefs.Encode())
 		if err != nil {
 			return err
 		}
@@ -194,6 +225,52 @@ func (collection *LocalRepoCollection) ForEach(handler func(*LocalRepo) error) e
 	return err
 }
 
+// ForEachSorted runs method for each repository in order specified by sortMethod
+// (name, created or modified)
+func (collection *LocalRepoCollection) ForEachSorted(sortMethod string, handler func(*LocalRepo) error) error {
+	method, err := parseSortMethod(sortMethod)
+	if err != nil {
+		return err
+	}
+
+	sorter := &localRepoSorter{list: append([]*LocalRepo(nil), collection.list...), sortMethod: method}
+	sort.Sort(sorter)
+
+	for _, r := range sorter.list {
+		err = handler(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type localRepoSorter struct {
+	list       []*LocalRepo
+	sortMethod int
+}
+
+func (s *localRepoSorter) Swap(i, j int) {
+	s.list[i], s.list[j] = s.list[j], s.list[i]
+}
+
+func (s *localRepoSorter) Less(i, j int) bool {
+	switch s.sortMethod {
+	case SortName:
+		return s.list[i].Name < s.list[j].Name
+	case SortTime:
+		return s.list[i].CreatedAt.Before(s.list[j].CreatedAt)
+	case SortModified:
+		return s.list[i].ModifiedAt.Before(s.list[j].ModifiedAt)
+	}
+	panic("unknown sort method")
+}
+
+func (s *localRepoSorter) Len() int {
+	return len(s.list)
+}
+
 // Len returns number of remote repos
 func (collection *LocalRepoCollection) Len() int {
 	return len(collection.list)
@@ -222,5 +299,10 @@ func (collection *LocalRepoCollection) Drop(repo *LocalRepo) error {
 		return err
 	}
 
+	err = collection.refCounts.Replace(repo.RefKey(), nil)
+	if err != nil {
+		return err
+	}
+
 	return collection.db.Delete(repo.RefKey())
 }
@@ -3,6 +3,7 @@ package deb
 import (
 	"errors"
 	"github.com/smira/aptly/database"
+	"time"
 
   . "gopkg.in/check.v1"
 )
@@ -37,6 +38,12 @@ func (s *LocalRepoSuite) TestString(c *C) {
 	c.Check(NewLocalRepo("lrepo2", "").String(), Equals, "[lrepo2]")
 }
 
+func (s *LocalRepoSuite) TestValidateKeepVersions(c *C) {
+	c.Check(ValidateKeepVersions(0), IsNil)
+	c.Check(ValidateKeepVersions(2), IsNil)
+	c.Check(ValidateKeepVersions(-1), ErrorMatches, "number of versions to keep should be non-negative: -1")
+}
+
 func (s *LocalRepoSuite) TestNumPackages(c *C) {
 	c.Check(NewLocalRepo("lrepo", "My first repo").NumPackages(), Equals, 0)
 	c.Check(s.repo.NumPackages(), Equals, 2)
@@ -169,6 +176,35 @@ func (s *LocalRepoCollectionSuite) TestForEachAndLen(c *C) {
 	c.Assert(err, Equals, e)
 }
 
+func (s *LocalRepoCollectionSuite) TestForEachSorted(c *C) {
+	repo1, repo2, repo3 := NewLocalRepo("b", ""), NewLocalRepo("a", ""), NewLocalRepo("c", "")
+	repo1.CreatedAt = time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo2.CreatedAt = time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC)
+	// created by older version, no timestamp
+	repo3.CreatedAt = time.Time{}
+
+	c.Assert(s.collection.Add(repo1), IsNil)
+	c.Assert(s.collection.Add(repo2), IsNil)
+	c.Assert(s.collection.Add(repo3), IsNil)
+
+	c.Check(repo1.ModifiedAt.IsZero(), Equals, false)
+	c.Assert(s.collection.Update(repo1), IsNil)
+
+	names := func(sortMethod string) (result []string) {
+		c.Assert(s.collection.ForEachSorted(sortMethod, func(repo *LocalRepo) error {
+			result = append(result, repo.Name)
+			return nil
+		}), IsNil)
+		return
+	}
+
+	c.Check(names("name"), DeepEquals, []string{"a", "b", "c"})
+	c.Check(names("created"), DeepEquals, []string{"c", "b", "a"})
+	c.Check(names("modified"), DeepEquals, []string{"a", "c", "b"})
+
+	c.Check(s.collection.ForEachSorted("planet", func(*LocalRepo) error { return nil }), ErrorMatches, "sorting method \"planet\" unknown")
+}
+
 func (s *LocalRepoCollectionSuite) TestDrop(c *C) {
 	repo1 := NewLocalRepo("local1", "Comment 1")
 	s.collection.Add(repo1)
@@ -0,0 +1,309 @@
+package deb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/smira/aptly/aptly"
+	"github.com/smira/aptly/utils"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ChecksumsManifestName is name of checksums manifest written at the root of prefix
+const ChecksumsManifestName = "CHECKSUMS.sha256"
+
+// checksumsManifest maps paths relative to prefix to SHA256 of files
+type checksumsManifest map[string]string
+
+// parseChecksumsManifest loads manifest in sha256sum format
+func parseChecksumsManifest(data []byte) checksumsManifest {
+	result := checksumsManifest{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(parts) == 2 {
+			result[parts[1]] = parts[0]
+		}
+	}
+
+	return result
+}
+
+// addFile adds file to manifest calculating its checksum
+func (manifest checksumsManifest) addFile(path, filename string) error {
+	info, err := utils.ChecksumsForFile(filename)
+	if err != nil {
+		return fmt.Errorf("unable to collect checksums: %s", err)
+	}
+	manifest[path] = info.SHA256
+	return nil
+}
+
+// addPackages adds pool files of packages published in component
+func (manifest checksumsManifest) addPackages(component string, list *PackageList, packagePool aptly.PackagePool) error {
+	return list.ForEach(func(p *Package) error {
+		poolDir, err := p.PoolDirectory()
+		if err != nil {
+			return err
+		}
+
+		for _, f := range p.Files() {
+			path := filepath.Join("pool", component, poolDir, f.Filename)
+
+			if f.Checksums.SHA256 != "" {
+				manifest[path] = f.Checksums.SHA256
+				continue
+			}
+
+			// SHA256 is missing for packages imported by old versions of aptly
+			sourcePath, err := packagePool.Path(f.Filename, f.Checksums.MD5)
+			if err != nil {
+				return err
+			}
+
+			err = manifest.addFile(path, sourcePath)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// WriteTo writes manifest in sha256sum format, sorted by path
+func (manifest checksumsManifest) WriteTo(w io.Writer) error {
+	paths := make([]string, 0, len(manifest))
+	for path := range manifest {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		_, err := fmt.Fprintf(w, "%s  %s\n", manifest[path], path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readPublishedFile reads file back from published storage, if storage supports that
+func readPublishedFile(publishedStorage aptly.PublishedStorage, path string) ([]byte, error) {
+	switch storage := publishedStorage.(type) {
+	case aptly.LocalPublishedStorage:
+		return ioutil.ReadFile(filepath.Join(storage.PublicPath(), path))
+	case aptly.ReadablePublishedStorage:
+		reader, err := storage.ReadRange(path, 0, -1)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		return ioutil.ReadAll(reader)
+	}
+
+	return nil, fmt.Errorf("published storage doesn't support reading files")
+}
+
+// publishChecksumsManifest generates and uploads manifest of all the files published
+// under prefix: index files of this distribution, pool files of all the published
+// repositories sharing prefix and index files of other distributions (taken from
+// the previous version of manifest)
+func (p *PublishedRepo) publishChecksumsManifest(publishedStorage aptly.PublishedStorage, packagePool aptly.PackagePool,
+	collectionFactory *CollectionFactory, lists map[string]*PackageList, indexes *indexFiles, releaseFile *indexFile,
+	signer utils.Signer, tempDir string, progress aptly.Progress) error {
+	if progress != nil {
+		progress.Printf("Generating checksums manifest...\n")
+	}
+
+	manifest := checksumsManifest{}
+	ownDists := filepath.Join("dists", p.Distribution) + "/"
+
+	otherDists, err := p.siblingPackages(collectionFactory.PublishedRepoCollection(), collectionFactory, progress,
+		func(component string, list *PackageList) error {
+			return manifest.addPackages(component, list, packagePool)
+		})
+	if err != nil {
+		return fmt.Errorf("unable to generate checksums manifest: %s", err)
+	}
+
+	if len(otherDists) > 0 {
+		data, err := readPublishedFile(publishedStorage, filepath.Join(p.Prefix, ChecksumsManifestName))
+		if err == nil {
+			for path, hash := range parseChecksumsManifest(data) {
+				if strings.HasPrefix(path, ownDists) {
+					continue
+				}
+				for _, dists := range otherDists {
+					if strings.HasPrefix(path, dists) {
+						manifest[path] = hash
+						break
+					}
+				}
+			}
+		} else if progress != nil {
+			progress.ColoredPrintf("@y[!]@| @!Unable to read previous checksums manifest, index files of other distributions are not listed: %s@|", err)
+		}
+	}
+
+	for component, list := range lists {
+		list, err = p.filterArchitectures(component, list)
+		if err == nil {
+			err = manifest.addPackages(component, list, packagePool)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to generate checksums manifest: %s", err)
+		}
+	}
+
+	for path, info := range indexes.generatedFiles {
+		if path == "Release" && releaseFile.skipPlain && signer != nil {
+			continue
+		}
+		manifest[ownDists+path] = info.SHA256
+	}
+
+	if signer != nil {
+		if !releaseFile.skipDetached {
+			err = manifest.addFile(ownDists+"Release.gpg", releaseFile.tempFilename+".gpg")
+			if err != nil {
+				return err
+			}
+		}
+
+		err = manifest.addFile(ownDists+"InRelease",
+			filepath.Join(filepath.Dir(releaseFile.tempFilename), "In"+filepath.Base(releaseFile.tempFilename)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return manifest.publish(publishedStorage, p.Prefix, tempDir)
+}
+
+// siblingPackages calls handler with packages published in each component of repositories
+// sharing storage and prefix with p (except for p itself), it returns dists directories
+// of these repositories
+func (p *PublishedRepo) siblingPackages(collection *PublishedRepoCollection, collectionFactory *CollectionFactory,
+	progress aptly.Progress, handler func(component string, list *PackageList) error) ([]string, error) {
+	dists := []string{}
+
+	err := collection.ForEach(func(r *PublishedRepo) error {
+		if r.UUID == p.UUID || r.Storage != p.Storage || r.Prefix != p.Prefix {
+			return nil
+		}
+
+		dists = append(dists, filepath.Join("dists", r.Distribution)+"/")
+
+		err := collection.LoadComplete(r, collectionFactory)
+		if err != nil {
+			return err
+		}
+
+		for _, component := range r.Components() {
+			list, err := r.publishedPackageList(component, collectionFactory.PackageCollection(), progress)
+			if err != nil {
+				return err
+			}
+
+			err = handler(component, list)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return dists, err
+}
+
+// updateChecksumsManifest removes files of dropped repository from the manifest under
+// its prefix: index files of its distribution and pool files which are not published
+// by the rest of repositories sharing prefix
+func (collection *PublishedRepoCollection) updateChecksumsManifest(dropped *PublishedRepo,
+	publishedStorage aptly.PublishedStorage, collectionFactory *CollectionFactory, progress aptly.Progress) error {
+	data, err := readPublishedFile(publishedStorage, filepath.Join(dropped.Prefix, ChecksumsManifestName))
+	if err != nil {
+		if progress != nil {
+			progress.ColoredPrintf("@y[!]@| @!Unable to read checksums manifest, it is not updated: %s@|", err)
+		}
+		return nil
+	}
+
+	previous := parseChecksumsManifest(data)
+	manifest := checksumsManifest{}
+
+	dists, err := dropped.siblingPackages(collection, collectionFactory, progress,
+		func(component string, list *PackageList) error {
+			return list.ForEach(func(pkg *Package) error {
+				poolDir, err := pkg.PoolDirectory()
+				if err != nil {
+					return err
+				}
+
+				for _, f := range pkg.Files() {
+					path := filepath.Join("pool", component, poolDir, f.Filename)
+					if hash, ok := previous[path]; ok {
+						manifest[path] = hash
+					}
+				}
+
+				return nil
+			})
+		})
+	if err != nil {
+		return fmt.Errorf("unable to update checksums manifest: %s", err)
+	}
+
+	for path, hash := range previous {
+		for _, dist := range dists {
+			if strings.HasPrefix(path, dist) {
+				manifest[path] = hash
+				break
+			}
+		}
+	}
+
+	tempDir, err := ioutil.TempDir(dropped.tempDir, "aptly")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	return manifest.publish(publishedStorage, dropped.Prefix, tempDir)
+}
+
+// publish writes manifest to temporary directory and uploads it to the root of prefix
+func (manifest checksumsManifest) publish(publishedStorage aptly.PublishedStorage, prefix, tempDir string) error {
+	manifestPath := filepath.Join(tempDir, ChecksumsManifestName)
+	file, err := os.Create(manifestPath)
+	if err != nil {
+		return fmt.Errorf("unable to create checksums manifest: %s", err)
+	}
+
+	bufWriter := bufio.NewWriter(file)
+	err = manifest.WriteTo(bufWriter)
+	if err == nil {
+		err = bufWriter.Flush()
+	}
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("unable to write checksums manifest: %s", err)
+	}
+
+	err = publishedStorage.PutFile(filepath.Join(prefix, ChecksumsManifestName), manifestPath)
+	if err != nil {
+		return fmt.Errorf("unable to publish checksums manifest: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package deb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Override is replacement of Priority and Section (and optionally Maintainer)
+// for the package in published indexes
+type Override struct {
+	Priority   string
+	Section    string
+	Maintainer string
+}
+
+// Overrides maps package names to overrides
+type Overrides map[string]Override
+
+// ParseOverrides parses override file in Debian format
+//
+// Each line contains package name, priority, section and optional maintainer separated
+// by whitespace, "-" leaves field as is, comments start with '#'.
+func ParseOverrides(r io.Reader) (Overrides, error) {
+	result := Overrides{}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected package, priority and section: %#v", lineNo, scanner.Text())
+		}
+
+		override := Override{Priority: fields[1], Section: fields[2]}
+		if len(fields) > 3 {
+			override.Maintainer = strings.Join(fields[3:], " ")
+		}
+		result[fields[0]] = override
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// LoadOverrides reads override file from disk
+func LoadOverrides(filename string) (Overrides, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	result, err := ParseOverrides(file)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", filename, err)
+	}
+
+	return result, nil
+}
+
+// Apply rewrites fields of package stanza according to overrides
+func (overrides Overrides) Apply(stanza Stanza) {
+	override, ok := overrides[stanza["Package"]]
+	if !ok {
+		return
+	}
+
+	if override.Priority != "" && override.Priority != "-" {
+		stanza["Priority"] = override.Priority
+	}
+	if override.Section != "" && override.Section != "-" {
+		stanza["Section"] = override.Section
+	}
+	if override.Maintainer != "" && override.Maintainer != "-" {
+		stanza["Maintainer"] = override.Maintainer
+	}
+}
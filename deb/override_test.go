@@ -0,0 +1,41 @@
+package deb
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type OverridesSuite struct{}
+
+var _ = Suite(&OverridesSuite{})
+
+func (s *OverridesSuite) TestParse(c *C) {
+	overrides, err := ParseOverrides(strings.NewReader(`
+# comment
+alien-arena-common optional games
+alien-arena-server  -  net   Debian Games Team <games@debian.org> # trailing comment
+`))
+	c.Assert(err, IsNil)
+	c.Check(overrides, DeepEquals, Overrides{
+		"alien-arena-common": {Priority: "optional", Section: "games"},
+		"alien-arena-server": {Priority: "-", Section: "net", Maintainer: "Debian Games Team <games@debian.org>"},
+	})
+
+	_, err = ParseOverrides(strings.NewReader("alien-arena-common\n\npackage optional\n"))
+	c.Check(err, ErrorMatches, "line 1: expected package, priority and section: \"alien-arena-common\"")
+}
+
+func (s *OverridesSuite) TestApply(c *C) {
+	overrides := Overrides{
+		"alien-arena-common": {Priority: "-", Section: "games/contrib"},
+	}
+
+	stanza := Stanza{"Package": "alien-arena-common", "Priority": "extra", "Section": "contrib/games"}
+	overrides.Apply(stanza)
+	c.Check(stanza, DeepEquals, Stanza{"Package": "alien-arena-common", "Priority": "extra", "Section": "games/contrib"})
+
+	stanza = Stanza{"Package": "alien-arena-server", "Priority": "extra", "Section": "contrib/games"}
+	overrides.Apply(stanza)
+	c.Check(stanza, DeepEquals, Stanza{"Package": "alien-arena-server", "Priority": "extra", "Section": "contrib/games"})
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/smira/aptly/aptly"
 	"github.com/smira/aptly/utils"
+	"golang.org/x/net/context"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -500,7 +501,9 @@ func (p *Package) Equals(p2 *Package) bool {
 }
 
 // LinkFromPool links package file from pool to dist's pool location
-func (p *Package) LinkFromPool(publishedStorage aptly.PublishedStorage, packagePool aptly.PackagePool,
+//
+// Linking stops before next file if ctx is cancelled
+func (p *Package) LinkFromPool(ctx context.Context, publishedStorage aptly.PublishedStorage, packagePool aptly.PackagePool,
 	prefix, component string, force bool) error {
 	poolDir, err := p.PoolDirectory()
 	if err != nil {
@@ -508,6 +511,10 @@ func (p *Package) LinkFromPool(publishedStorage aptly.PublishedStorage, packageP
 	}
 
 	for i, f := range p.Files() {
+		if ctx.Err() != nil {
+			return fmt.Errorf("unable to link %s: %s", f.Filename, ctx.Err())
+		}
+
 		sourcePath, err := packagePool.Path(f.Filename, f.Checksums.MD5)
 		if err != nil {
 			return err
@@ -173,11 +173,22 @@ func (collection *PackageCollection) Update(p *Package) error {
 		return err
 	}
 
+	_, err = collection.db.Get(p.Key(""))
+	isNew := err == database.ErrNotFound
+
 	err = collection.db.Put(p.Key(""), collection.encodeBuffer.Bytes())
 	if err != nil {
 		return err
 	}
 
+	if isNew {
+		// package isn't referenced by anything yet, so it's candidate for cleanup
+		err = markUnreferencedPackage(collection.db, p)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Encode offloaded fields one by one
 	if p.files != nil {
 		collection.encodeBuffer.Reset()
@@ -37,6 +37,50 @@ func (f *PackageFile) Verify(packagePool aptly.PackagePool) (bool, error) {
 	return st.Size() == f.Checksums.Size, nil
 }
 
+// Results of package file verification with VerifyChecksums
+const (
+	// FileVerified means file is present in the pool and matches index
+	FileVerified = iota
+	// FileMissing means file is not present in the pool
+	FileMissing
+	// FileMismatch means file in the pool differs from the one in index
+	FileMismatch
+)
+
+// VerifyChecksums checks that package file is present in the pool and, unless quick
+// is set, that its size and checksums match the ones from the index
+func (f *PackageFile) VerifyChecksums(packagePool aptly.PackagePool, quick bool) (int, error) {
+	poolPath, err := packagePool.Path(f.Filename, f.Checksums.MD5)
+	if err != nil {
+		return FileMissing, err
+	}
+
+	_, err = os.Stat(poolPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return FileMissing, nil
+		}
+		return FileMissing, err
+	}
+
+	if quick {
+		return FileVerified, nil
+	}
+
+	actual, err := utils.ChecksumsForFile(poolPath)
+	if err != nil {
+		return FileMismatch, err
+	}
+
+	if actual.Size != f.Checksums.Size || actual.MD5 != f.Checksums.MD5 ||
+		(f.Checksums.SHA1 != "" && actual.SHA1 != f.Checksums.SHA1) ||
+		(f.Checksums.SHA256 != "" && actual.SHA256 != f.Checksums.SHA256) {
+		return FileMismatch, nil
+	}
+
+	return FileVerified, nil
+}
+
 // DownloadURL return relative URL to package download location
 func (f *PackageFile) DownloadURL() string {
 	return filepath.Join(f.downloadPath, f.Filename)
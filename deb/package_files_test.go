@@ -3,6 +3,7 @@ package deb
 import (
 	"github.com/smira/aptly/files"
 	"github.com/smira/aptly/utils"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -53,6 +54,41 @@ func (s *PackageFilesSuite) TestVerify(c *C) {
 	c.Check(result, Equals, true)
 }
 
+func (s *PackageFilesSuite) TestVerifyChecksums(c *C) {
+	s.files[0].Checksums = utils.ChecksumInfo{
+		Size:   5,
+		MD5:    "ab56b4d92b40713acc5af89985d4b786",
+		SHA1:   "03de6c570bfe24bfc328ccd7ca46b76eadaf4334",
+		SHA256: "36bbe50ed96841d10443bcb670d6554f0a34b761be67ec9c4a8ad2c0c44ca42c",
+	}
+
+	packagePool := files.NewPackagePool(c.MkDir())
+	poolPath, _ := packagePool.Path(s.files[0].Filename, s.files[0].Checksums.MD5)
+
+	result, err := s.files[0].VerifyChecksums(packagePool, false)
+	c.Check(err, IsNil)
+	c.Check(result, Equals, FileMissing)
+
+	c.Assert(os.MkdirAll(filepath.Dir(poolPath), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(poolPath, []byte("abcde"), 0644), IsNil)
+
+	result, err = s.files[0].VerifyChecksums(packagePool, false)
+	c.Check(err, IsNil)
+	c.Check(result, Equals, FileVerified)
+
+	// corrupted file of the same size
+	c.Assert(ioutil.WriteFile(poolPath, []byte("abcdf"), 0644), IsNil)
+
+	result, err = s.files[0].VerifyChecksums(packagePool, false)
+	c.Check(err, IsNil)
+	c.Check(result, Equals, FileMismatch)
+
+	// quick verification checks existence only
+	result, err = s.files[0].VerifyChecksums(packagePool, true)
+	c.Check(err, IsNil)
+	c.Check(result, Equals, FileVerified)
+}
+
 func (s *PackageFilesSuite) TestDownloadURL(c *C) {
 	c.Check(s.files[0].DownloadURL(), Equals, "pool/contrib/a/alien-arena/alien-arena-common_7.40-2_i386.deb")
 }
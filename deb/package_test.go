@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"github.com/smira/aptly/files"
 	"github.com/smira/aptly/utils"
+	"golang.org/x/net/context"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -370,15 +371,20 @@ func (s *PackageSuite) TestLinkFromPool(c *C) {
 	c.Assert(err, IsNil)
 	file.Close()
 
-	err = p.LinkFromPool(publishedStorage, packagePool, "", "non-free", false)
+	err = p.LinkFromPool(context.Background(), publishedStorage, packagePool, "", "non-free", false)
 	c.Check(err, IsNil)
 	c.Check(p.Files()[0].Filename, Equals, "alien-arena-common_7.40-2_i386.deb")
 	c.Check(p.Files()[0].downloadPath, Equals, "pool/non-free/a/alien-arena")
 
 	p.IsSource = true
-	err = p.LinkFromPool(publishedStorage, packagePool, "", "non-free", false)
+	err = p.LinkFromPool(context.Background(), publishedStorage, packagePool, "", "non-free", false)
 	c.Check(err, IsNil)
 	c.Check(p.Extra()["Directory"], Equals, "pool/non-free/a/alien-arena")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = p.LinkFromPool(ctx, publishedStorage, packagePool, "", "main", false)
+	c.Check(err, ErrorMatches, "unable to link alien-arena-common_7.40-2_i386.deb: context canceled")
 }
 
 func (s *PackageSuite) TestFilepathList(c *C) {
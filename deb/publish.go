@@ -5,16 +5,20 @@ import (
 	"bytes"
 	"code.google.com/p/go-uuid/uuid"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/smira/aptly/aptly"
 	"github.com/smira/aptly/database"
 	"github.com/smira/aptly/utils"
 	"github.com/ugorji/go/codec"
+	"golang.org/x/net/context"
 	"io/ioutil"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -41,8 +45,29 @@ type PublishedRepo struct {
 	Label        string
 	// Architectures is a list of all architectures published
 	Architectures []string
+	// SourceOnlyComponents lists components published with Sources indexes only
+	SourceOnlyComponents []string `codec:",omitempty"`
 	// SourceKind is "local"/"repo"
 	SourceKind string
+	// InReleaseOnly disables publishing of detached signature Release.gpg
+	InReleaseOnly bool `codec:",omitempty"`
+	// SkipRelease disables publishing of unsigned Release (InReleaseOnly only)
+	SkipRelease bool `codec:",omitempty"`
+	// NotAutomatic & ButAutomaticUpgrades are published as Release flags (used for apt pinning)
+	NotAutomatic         bool `codec:",omitempty"`
+	ButAutomaticUpgrades bool `codec:",omitempty"`
+	// FilenamePrefix is prepended to paths of pool files in generated indexes
+	FilenamePrefix string `codec:",omitempty"`
+	// ChecksumsManifest enables publishing of CHECKSUMS.sha256 at prefix root
+	ChecksumsManifest bool `codec:",omitempty"`
+
+	// LatestOnly limits indexes to the latest version of each package (by name and architecture)
+	LatestOnly bool `codec:",omitempty"`
+	// PinFriendly requires Origin and Label to be set explicitly (see CheckPinning)
+	PinFriendly bool `codec:",omitempty"`
+
+	// Overrides of Priority, Section and Maintainer applied to binary packages in indexes
+	Overrides Overrides `codec:",omitempty"`
 
 	// Map of sources by each component: component name -> source UUID
 	Sources map[string]string
@@ -57,6 +82,50 @@ type PublishedRepo struct {
 
 	// True if repo is being re-published
 	rePublishing bool
+
+	// Compression formats for indexes, default if nil
+	compressions []string
+	// Compression levels for indexes
+	compressionLevels utils.CompressionLevels
+
+	// True if publishing without signer was explicitly requested
+	skipSigning bool
+
+	// Directory for staging generated indexes, system default if empty
+	tempDir string
+
+	// Context for cancellation of publishing, never cancelled if nil
+	ctx context.Context
+
+	// True if duplicate packages across components should fail publishing
+	strictDuplicates bool
+
+	// True if architectures of packages should be compared with published architectures,
+	// force publishes even if some published architectures are left without packages
+	checkArchitectures, forceArchitectures bool
+
+	// Partial failures of published storage during last Publish
+	partialFailures []error
+
+	// Warnings (e.g. duplicate packages) reported during last Publish
+	warnings []error
+}
+
+// ArchitecturesMismatchError is returned by Publish when some published architectures
+// are left without packages (see SetArchitecturesCheck)
+type ArchitecturesMismatchError struct {
+	// Missing are published architectures without packages
+	Missing []string
+	// Extra are architectures of packages which are not published
+	Extra []string
+}
+
+func (e *ArchitecturesMismatchError) Error() string {
+	message := fmt.Sprintf("published architectures without packages: %s", strings.Join(e.Missing, ", "))
+	if len(e.Extra) > 0 {
+		message += fmt.Sprintf(", packages of architectures which are not published: %s", strings.Join(e.Extra, ", "))
+	}
+	return message
 }
 
 // ParsePrefix splits [storage:]prefix into components
@@ -362,6 +431,73 @@ func (p *PublishedRepo) RefList(component string) *PackageRefList {
 	panic("unknown source")
 }
 
+// SourceName returns name of snapshot or local repo published in component
+func (p *PublishedRepo) SourceName(component string) string {
+	item := p.sourceItems[component]
+	if item.snapshot != nil {
+		return item.snapshot.Name
+	}
+	if item.localRepo != nil {
+		return item.localRepo.Name
+	}
+	return ""
+}
+
+// indexRefList returns list of package refs of the component which go into indexes,
+// i.e. only the latest versions of packages if LatestOnly is set
+func (p *PublishedRepo) indexRefList(component string) *PackageRefList {
+	refList := p.RefList(component)
+	if p.LatestOnly {
+		refList = &PackageRefList{Refs: append([][]byte(nil), refList.Refs...)}
+		refList.FilterLatestRefs()
+	}
+
+	return refList
+}
+
+// PublishedRefList returns list of package refs which are published in component,
+// i.e. packages of the source matching published architectures (only the latest
+// versions if LatestOnly is set)
+func (p *PublishedRepo) PublishedRefList(component string, packageCollection *PackageCollection) (*PackageRefList, error) {
+	list, err := p.publishedPackageList(component, packageCollection, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPackageRefListFromPackageList(list), nil
+}
+
+// publishedPackageList loads packages which are published in component
+func (p *PublishedRepo) publishedPackageList(component string, packageCollection *PackageCollection,
+	progress aptly.Progress) (*PackageList, error) {
+	list, err := NewPackageListFromRefList(p.indexRefList(component), packageCollection, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.filterArchitectures(component, list)
+}
+
+// filterArchitectures returns packages of the list matching architectures published in component
+func (p *PublishedRepo) filterArchitectures(component string, list *PackageList) (*PackageList, error) {
+	architectures := p.componentArchitectures(component)
+	result := NewPackageList()
+
+	err := list.ForEach(func(pkg *Package) error {
+		for _, arch := range architectures {
+			if pkg.MatchesArchitecture(arch) {
+				return result.Add(pkg)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
 // Components returns sorted list of published repo components
 func (p *PublishedRepo) Components() []string {
 	result := make([]string, 0, len(p.Sources))
@@ -449,11 +585,373 @@ func (p *PublishedRepo) GetLabel() string {
 	return p.Label
 }
 
+// CheckPinning verifies that Origin and Label of pin-friendly published repository are set
+// explicitly, so that clients could pin it on these fields of Release file (Suite is
+// always set from distribution)
+func (p *PublishedRepo) CheckPinning() error {
+	if !p.PinFriendly {
+		return nil
+	}
+
+	missing := []string{}
+	if p.Origin == "" {
+		missing = append(missing, "Origin")
+	}
+	if p.Label == "" {
+		missing = append(missing, "Label")
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("pin-friendly publishing requires Origin and Label to be set, missing: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// CheckReleaseFlags verifies that combination of flags published in Release file is valid:
+// ButAutomaticUpgrades only makes sense together with NotAutomatic
+func (p *PublishedRepo) CheckReleaseFlags() error {
+	if p.ButAutomaticUpgrades && !p.NotAutomatic {
+		return fmt.Errorf("ButAutomaticUpgrades requires NotAutomatic to be set")
+	}
+
+	return nil
+}
+
+// SetSourceOnlyComponents marks components which should be published without binary indexes
+func (p *PublishedRepo) SetSourceOnlyComponents(components []string) error {
+	for _, component := range components {
+		if _, exists := p.sourceItems[component]; !exists {
+			return fmt.Errorf("component %s is not being published", component)
+		}
+	}
+
+	components = append([]string(nil), components...)
+	sort.Strings(components)
+	p.SourceOnlyComponents = utils.StrSliceDeduplicate(components)
+	return nil
+}
+
+// SetFilenamePrefix sets path prefix for pool files in generated indexes
+//
+// Files are still uploaded to the pool under the published prefix, prefix only changes
+// Filename (Directory for sources) fields, so that files could be served from a different
+// location (e.g. CDN). apt resolves these fields relative to repository URL, so prefix
+// should be a relative path.
+func (p *PublishedRepo) SetFilenamePrefix(prefix string) error {
+	prefix = strings.TrimRight(strings.TrimSpace(prefix), "/")
+
+	if strings.Contains(prefix, "://") || strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("filename prefix %s should be relative path, apt resolves Filename relative to repository URL", prefix)
+	}
+	if strings.ContainsAny(prefix, " \t\n") {
+		return fmt.Errorf("filename prefix %s contains whitespace", prefix)
+	}
+	for _, part := range strings.Split(prefix, "/") {
+		if part == ".." {
+			return fmt.Errorf("filename prefix %s shouldn't contain '..'", prefix)
+		}
+	}
+
+	p.FilenamePrefix = prefix
+	return nil
+}
+
+// applyFilenamePrefix rewrites pool path in package stanza with FilenamePrefix
+func (p *PublishedRepo) applyFilenamePrefix(stanza Stanza, isSource bool) {
+	if p.FilenamePrefix == "" {
+		return
+	}
+
+	field := "Filename"
+	if isSource {
+		field = "Directory"
+	}
+
+	if value, ok := stanza[field]; ok {
+		stanza[field] = path.Join(p.FilenamePrefix, value)
+	}
+}
+
+// SetTempDir sets directory used for staging generated indexes before upload
+func (p *PublishedRepo) SetTempDir(dir string) {
+	p.tempDir = dir
+}
+
+// SetContext sets context which could be used to cancel publishing
+//
+// Cancellation is checked while processing packages and before published
+// indexes are replaced, so cancelled publishing leaves previous version intact
+func (p *PublishedRepo) SetContext(ctx context.Context) {
+	p.ctx = ctx
+}
+
+// publishContext returns context set for publishing, never cancelled if not set
+func (p *PublishedRepo) publishContext() context.Context {
+	if p.ctx == nil {
+		return context.Background()
+	}
+	return p.ctx
+}
+
+// cancelled returns error if publishing was cancelled
+func (p *PublishedRepo) cancelled() error {
+	err := p.publishContext().Err()
+	if err != nil {
+		return fmt.Errorf("publishing cancelled: %s", err)
+	}
+	return nil
+}
+
+// SetCompressions sets compression formats for generated indexes (empty list means no compression)
+func (p *PublishedRepo) SetCompressions(formats []string) error {
+	if formats == nil {
+		formats = []string{}
+	}
+
+	for _, format := range formats {
+		if format != utils.CompressionGzip && format != utils.CompressionBzip2 {
+			return fmt.Errorf("unknown compression format: %s", format)
+		}
+	}
+
+	p.compressions = utils.StrSliceDeduplicate(formats)
+	return nil
+}
+
+// SetCompressionLevels sets compression levels for generated indexes
+func (p *PublishedRepo) SetCompressionLevels(levels utils.CompressionLevels) error {
+	err := levels.Validate()
+	if err != nil {
+		return err
+	}
+
+	p.compressionLevels = levels
+	return nil
+}
+
+// SetSkipSigning allows to publish repository without signing it
+//
+// Publishing without signer fails unless signing is explicitly skipped, as apt
+// rejects unsigned repositories by default
+func (p *PublishedRepo) SetSkipSigning(skip bool) {
+	p.skipSigning = skip
+}
+
+// SetStrictDuplicates makes publishing fail when the same package (name, version
+// and architecture) is present in several published components
+//
+// By default duplicates are only reported as warning
+func (p *PublishedRepo) SetStrictDuplicates(strict bool) {
+	p.strictDuplicates = strict
+}
+
+// SetArchitecturesCheck enables comparison of architectures of packages being published
+// with published architectures (e.g. when switching to new snapshots): published architectures
+// without any packages fail publishing with ArchitecturesMismatchError, packages of architectures
+// which are not published are reported as warning
+//
+// With force, published architectures without packages are only reported as warning
+func (p *PublishedRepo) SetArchitecturesCheck(force bool) {
+	p.checkArchitectures = true
+	p.forceArchitectures = force
+}
+
+// PartialFailures returns partial failures of published storage during last Publish
+// (e.g. failed targets of best-effort publishing to multiple storages)
+func (p *PublishedRepo) PartialFailures() []error {
+	return p.partialFailures
+}
+
+// Warnings returns warnings reported during last Publish, e.g. packages present
+// in several components or architectures mismatch
+func (p *PublishedRepo) Warnings() []error {
+	return p.warnings
+}
+
+// warn reports warning of Publish to progress (or log, if progress is nil)
+// and records it to be returned by Warnings
+func (p *PublishedRepo) warn(progress aptly.Progress, warning string) {
+	if progress != nil {
+		progress.ColoredPrintf("@y[!]@| @!%s@|", warning)
+	} else {
+		log.Printf("%s: %s\n", p, warning)
+	}
+
+	p.warnings = append(p.warnings, errors.New(warning))
+}
+
+// reportPartialFailures reports partial failures of published storage to progress
+// (or log, if progress is nil) and resets them, so that failed parts of the storage are
+// not skipped by following operations
+func reportPartialFailures(publishedStorage aptly.PublishedStorage, progress aptly.Progress) []error {
+	partialStorage, ok := publishedStorage.(aptly.PartialPublishedStorage)
+	if !ok {
+		return nil
+	}
+
+	failures := partialStorage.Failures()
+	partialStorage.ResetFailures()
+
+	for _, failure := range failures {
+		if progress != nil {
+			progress.ColoredPrintf("@y[!]@| @!%s@|", failure)
+		} else {
+			log.Printf("%s\n", failure)
+		}
+	}
+
+	return failures
+}
+
+// SetOverrides sets overrides applied to binary package entries in generated indexes
+//
+// Overrides are saved with published repository and kept on update and switch until replaced
+func (p *PublishedRepo) SetOverrides(overrides Overrides) {
+	p.Overrides = overrides
+}
+
+// DuplicatePackage is package present in several components of published repository
+type DuplicatePackage struct {
+	Package    string
+	Components []string
+}
+
+func (d DuplicatePackage) String() string {
+	return fmt.Sprintf("%s (components: %s)", d.Package, strings.Join(d.Components, ", "))
+}
+
+// FindDuplicatePackages returns packages (by name, version and architecture)
+// which are present in more than one component
+func FindDuplicatePackages(lists map[string]*PackageList) []DuplicatePackage {
+	components := make([]string, 0, len(lists))
+	for component := range lists {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+
+	seen := map[string][]string{}
+	for _, component := range components {
+		lists[component].ForEach(func(pkg *Package) error {
+			name := pkg.String()
+			if !utils.StrSliceHasItem(seen[name], component) {
+				seen[name] = append(seen[name], component)
+			}
+			return nil
+		})
+	}
+
+	result := []DuplicatePackage{}
+	for name, inComponents := range seen {
+		if len(inComponents) > 1 {
+			result = append(result, DuplicatePackage{Package: name, Components: inComponents})
+		}
+	}
+	sort.Sort(duplicatePackages(result))
+
+	return result
+}
+
+type duplicatePackages []DuplicatePackage
+
+func (d duplicatePackages) Len() int           { return len(d) }
+func (d duplicatePackages) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d duplicatePackages) Less(i, j int) bool { return d[i].Package < d[j].Package }
+
+// BinaryArchitectures returns published architectures except for source
+func (p *PublishedRepo) BinaryArchitectures() []string {
+	return utils.StrSlicesSubstract(p.Architectures, []string{"source"})
+}
+
+// releaseArchitectures returns list of architectures for Release file: binary architectures
+// only, as Sources indexes are listed separately, or just source for source-only publishing
+func (p *PublishedRepo) releaseArchitectures() []string {
+	binary := p.BinaryArchitectures()
+	if len(binary) == 0 && utils.StrSliceHasItem(p.Architectures, "source") {
+		return []string{"source"}
+	}
+	return binary
+}
+
+// componentArchitectures returns list of architectures to generate indexes for in component
+func (p *PublishedRepo) componentArchitectures(component string) []string {
+	if utils.StrSliceHasItem(p.SourceOnlyComponents, component) {
+		return []string{"source"}
+	}
+	return p.Architectures
+}
+
+// architecturesMismatch compares architectures of packages in lists (contents of all the
+// components) with published architectures: missing are published architectures without
+// any packages, extra are architectures of packages which are not published
+func (p *PublishedRepo) architecturesMismatch(lists map[string]*PackageList) (missing, extra []string) {
+	published := append([]string(nil), p.Architectures...)
+	sort.Strings(published)
+	published = utils.StrSlicesSubstract(published, []string{"all"})
+
+	present := []string{}
+	hasArchAll := false
+	for _, list := range lists {
+		present = append(present, list.Architectures(true)...)
+		for _, pkg := range list.packages {
+			if pkg.Architecture == "all" {
+				hasArchAll = true
+				break
+			}
+		}
+	}
+
+	// packages with architecture all are published for every binary architecture
+	if hasArchAll {
+		present = append(present, utils.StrSlicesSubstract(published, []string{"source"})...)
+	}
+
+	sort.Strings(present)
+	present = utils.StrSliceDeduplicate(present)
+
+	missing = utils.StrSlicesSubstract(published, present)
+	extra = utils.StrSlicesSubstract(present, published)
+	return
+}
+
+// releaseDate is Date of generated Release files, it is taken from SOURCE_DATE_EPOCH
+// environment variable if it is set, for reproducible publishing
+func releaseDate() time.Time {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0)
+	}
+	return time.Now()
+}
+
 // Publish publishes snapshot (repository) contents, links package files, generates Packages & Release files, signs them
+//
+// Generated indexes are reproducible: packages are written sorted (see PackageList.Less),
+// fields of stanzas in canonical order (see Stanza.WriteTo) and indexes in Release are
+// sorted by path, Date of Release honors SOURCE_DATE_EPOCH.
 func (p *PublishedRepo) Publish(packagePool aptly.PackagePool, publishedStorageProvider aptly.PublishedStorageProvider,
 	collectionFactory *CollectionFactory, signer utils.Signer, progress aptly.Progress, forceOverwrite bool) error {
 	publishedStorage := publishedStorageProvider.GetPublishedStorage(p.Storage)
 
+	if signer == nil && !p.skipSigning {
+		return fmt.Errorf("unable to publish: no signer configured and signing wasn't explicitly skipped")
+	}
+	if p.InReleaseOnly && signer == nil {
+		return fmt.Errorf("unable to publish InRelease only without signing")
+	}
+	if p.SkipRelease && !p.InReleaseOnly {
+		return fmt.Errorf("unsigned Release could be skipped only when publishing InRelease only")
+	}
+
+	if cachingStorage, ok := publishedStorage.(aptly.CachingPublishedStorage); ok {
+		cachingStorage.ResetCache()
+	}
+
+	p.partialFailures = nil
+	p.warnings = nil
+	defer func() {
+		p.partialFailures = reportPartialFailures(publishedStorage, progress)
+	}()
+
 	err := publishedStorage.MkDir(filepath.Join(p.Prefix, "pool"))
 	if err != nil {
 		return err
@@ -472,12 +970,50 @@ func (p *PublishedRepo) Publish(packagePool aptly.PackagePool, publishedStorageP
 
 	for component := range p.sourceItems {
 		// Load all packages
-		lists[component], err = NewPackageListFromRefList(p.RefList(component), collectionFactory.PackageCollection(), progress)
+		lists[component], err = NewPackageListFromRefList(p.indexRefList(component), collectionFactory.PackageCollection(), progress)
 		if err != nil {
 			return fmt.Errorf("unable to load packages: %s", err)
 		}
 	}
 
+	duplicates := FindDuplicatePackages(lists)
+	if len(duplicates) > 0 {
+		descriptions := make([]string, len(duplicates))
+		for i := range duplicates {
+			descriptions[i] = duplicates[i].String()
+		}
+
+		if p.strictDuplicates {
+			return fmt.Errorf("packages present in several components: %s", strings.Join(descriptions, "; "))
+		}
+
+		if progress != nil {
+			progress.ColoredPrintf("@y[!]@| @!Packages present in several components:@|")
+			for _, duplicate := range duplicates {
+				progress.ColoredPrintf("  %s", duplicate)
+			}
+		} else {
+			log.Printf("%s: packages present in several components: %s\n", p, strings.Join(descriptions, "; "))
+		}
+		p.warnings = append(p.warnings, fmt.Errorf("packages present in several components: %s", strings.Join(descriptions, "; ")))
+	}
+
+	if p.checkArchitectures {
+		missing, extra := p.architecturesMismatch(lists)
+		if len(missing) > 0 && !p.forceArchitectures {
+			return &ArchitecturesMismatchError{Missing: missing, Extra: extra}
+		}
+
+		if len(missing) > 0 {
+			p.warn(progress, fmt.Sprintf("Published architectures %s have no packages, their indexes would be empty",
+				strings.Join(missing, ", ")))
+		}
+		if len(extra) > 0 {
+			p.warn(progress, fmt.Sprintf("Architectures %s are not published, packages of these architectures are skipped",
+				strings.Join(extra, ", ")))
+		}
+	}
+
 	if !p.rePublishing {
 		if len(p.Architectures) == 0 {
 			for _, list := range lists {
@@ -485,6 +1021,16 @@ func (p *PublishedRepo) Publish(packagePool aptly.PackagePool, publishedStorageP
 			}
 		}
 
+		if len(p.Architectures) == 0 {
+			// only architecture-independent packages, publish them in binary-all index
+			for _, list := range lists {
+				if list.Len() > 0 {
+					p.Architectures = []string{"all"}
+					break
+				}
+			}
+		}
+
 		if len(p.Architectures) == 0 {
 			return fmt.Errorf("unable to figure out list of architectures, please supply explicit list")
 		}
@@ -503,19 +1049,27 @@ func (p *PublishedRepo) Publish(packagePool aptly.PackagePool, publishedStorageP
 	}
 
 	var tempDir string
-	tempDir, err = ioutil.TempDir(os.TempDir(), "aptly")
+	tempDir, err = ioutil.TempDir(p.tempDir, "aptly")
 	if err != nil {
 		return err
 	}
 	defer os.RemoveAll(tempDir)
 
 	indexes := newIndexFiles(publishedStorage, basePath, tempDir, suffix)
+	if p.compressions != nil {
+		indexes.compressions = p.compressions
+	}
+	indexes.levels = p.compressionLevels
+
+	// if publishing fails or is cancelled, remove indexes which are uploaded, but not in place
+	defer indexes.RemoveTempFiles()
 
 	for component, list := range lists {
 		hadUdebs := false
+		architectures := p.componentArchitectures(component)
 
 		// For all architectures, pregenerate packages/sources files
-		for _, arch := range p.Architectures {
+		for _, arch := range architectures {
 			indexes.PackageIndex(component, arch, false)
 		}
 
@@ -526,12 +1080,17 @@ func (p *PublishedRepo) Publish(packagePool aptly.PackagePool, publishedStorageP
 		list.PrepareIndex()
 
 		err = list.ForEachIndexed(func(pkg *Package) error {
+			err = p.cancelled()
+			if err != nil {
+				return err
+			}
+
 			if progress != nil {
 				progress.AddBar(1)
 			}
 
 			matches := false
-			for _, arch := range p.Architectures {
+			for _, arch := range architectures {
 				if pkg.MatchesArchitecture(arch) {
 					matches = true
 					break
@@ -540,13 +1099,13 @@ func (p *PublishedRepo) Publish(packagePool aptly.PackagePool, publishedStorageP
 
 			if matches {
 				hadUdebs = hadUdebs || pkg.IsUdeb
-				err = pkg.LinkFromPool(publishedStorage, packagePool, p.Prefix, component, forceOverwrite)
+				err = pkg.LinkFromPool(p.publishContext(), publishedStorage, packagePool, p.Prefix, component, forceOverwrite)
 				if err != nil {
 					return err
 				}
 			}
 
-			for _, arch := range p.Architectures {
+			for _, arch := range architectures {
 				if pkg.MatchesArchitecture(arch) {
 					var bufWriter *bufio.Writer
 
@@ -555,7 +1114,13 @@ func (p *PublishedRepo) Publish(packagePool aptly.PackagePool, publishedStorageP
 						return err
 					}
 
-					err = pkg.Stanza().WriteTo(bufWriter, pkg.IsSource, false)
+					stanza := pkg.Stanza()
+					p.applyFilenamePrefix(stanza, pkg.IsSource)
+					if !pkg.IsSource && p.Overrides != nil {
+						p.Overrides.Apply(stanza)
+					}
+
+					err = stanza.WriteTo(bufWriter, pkg.IsSource, false)
 					if err != nil {
 						return err
 					}
@@ -586,13 +1151,13 @@ func (p *PublishedRepo) Publish(packagePool aptly.PackagePool, publishedStorageP
 			udebs = append(udebs, true)
 
 			// For all architectures, pregenerate .udeb indexes
-			for _, arch := range p.Architectures {
+			for _, arch := range architectures {
 				indexes.PackageIndex(component, arch, true)
 			}
 		}
 
 		// For all architectures, generate Release files
-		for _, arch := range p.Architectures {
+		for _, arch := range architectures {
 			for _, udeb := range udebs {
 				release := make(Stanza)
 				release["Archive"] = p.Distribution
@@ -626,22 +1191,38 @@ func (p *PublishedRepo) Publish(packagePool aptly.PackagePool, publishedStorageP
 	release["Label"] = p.GetLabel()
 	release["Suite"] = p.Distribution
 	release["Codename"] = p.Distribution
-	release["Date"] = time.Now().UTC().Format("Mon, 2 Jan 2006 15:04:05 MST")
-	release["Architectures"] = strings.Join(utils.StrSlicesSubstract(p.Architectures, []string{"source"}), " ")
+	release["Date"] = releaseDate().UTC().Format(releaseDateFormat)
+	release["Architectures"] = strings.Join(p.releaseArchitectures(), " ")
 	release["Description"] = " Generated by aptly\n"
+	if p.NotAutomatic {
+		release["NotAutomatic"] = "yes"
+	}
+	if p.ButAutomaticUpgrades {
+		release["ButAutomaticUpgrades"] = "yes"
+	}
 	release["MD5Sum"] = "\n"
 	release["SHA1"] = "\n"
 	release["SHA256"] = "\n"
 
 	release["Components"] = strings.Join(p.Components(), " ")
 
-	for path, info := range indexes.generatedFiles {
+	// indexes are listed sorted by path, so that Release is reproducible
+	paths := make([]string, 0, len(indexes.generatedFiles))
+	for path := range indexes.generatedFiles {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		info := indexes.generatedFiles[path]
 		release["MD5Sum"] += fmt.Sprintf(" %s %8d %s\n", info.MD5, info.Size, path)
 		release["SHA1"] += fmt.Sprintf(" %s %8d %s\n", info.SHA1, info.Size, path)
 		release["SHA256"] += fmt.Sprintf(" %s %8d %s\n", info.SHA256, info.Size, path)
 	}
 
 	releaseFile := indexes.ReleaseFile()
+	releaseFile.skipDetached = p.InReleaseOnly
+	releaseFile.skipPlain = p.SkipRelease
 	bufWriter, err := releaseFile.BufWriter()
 	if err != nil {
 		return err
@@ -657,6 +1238,12 @@ func (p *PublishedRepo) Publish(packagePool aptly.PackagePool, publishedStorageP
 		progress.Flush()
 	}
 
+	// last chance to cancel: Release file makes published repository complete
+	err = p.cancelled()
+	if err != nil {
+		return err
+	}
+
 	err = releaseFile.Finalize(signer)
 	if err != nil {
 		return err
@@ -667,6 +1254,204 @@ func (p *PublishedRepo) Publish(packagePool aptly.PackagePool, publishedStorageP
 		return err
 	}
 
+	// remove files left by previous publishing with different options
+	stale := []string{}
+	if p.InReleaseOnly {
+		stale = append(stale, "Release.gpg")
+	}
+	if p.SkipRelease {
+		stale = append(stale, "Release")
+	}
+
+	for _, name := range stale {
+		err = publishedStorage.Remove(filepath.Join(basePath, name))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove stale %s: %s", name, err)
+		}
+	}
+
+	if p.ChecksumsManifest {
+		err = p.publishChecksumsManifest(publishedStorage, packagePool, collectionFactory, lists, indexes, releaseFile,
+			signer, tempDir, progress)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releaseDateFormat is format of Date and Valid-Until fields of Release file
+const releaseDateFormat = "Mon, 2 Jan 2006 15:04:05 MST"
+
+// renewValidUntil updates Date of Release file to now and moves Valid-Until
+// accordingly: its validity period is kept if validFor is zero, Valid-Until is
+// set to now+validFor otherwise
+//
+// Release is returned unchanged if it has no Valid-Until and validFor is zero.
+func renewValidUntil(release []byte, now time.Time, validFor time.Duration) ([]byte, bool, error) {
+	lines := strings.Split(string(release), "\n")
+	dateLine, validUntilLine := -1, -1
+
+	for i, line := range lines {
+		if strings.HasPrefix(line, "Date: ") {
+			dateLine = i
+		} else if strings.HasPrefix(line, "Valid-Until: ") {
+			validUntilLine = i
+		}
+	}
+
+	if validUntilLine == -1 && validFor == 0 {
+		return release, false, nil
+	}
+
+	if dateLine == -1 {
+		return nil, false, fmt.Errorf("no Date in Release file")
+	}
+
+	if validFor == 0 {
+		date, err := time.Parse(releaseDateFormat, strings.TrimPrefix(lines[dateLine], "Date: "))
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to parse Date: %s", err)
+		}
+		validUntil, err := time.Parse(releaseDateFormat, strings.TrimPrefix(lines[validUntilLine], "Valid-Until: "))
+		if err != nil {
+			return nil, false, fmt.Errorf("unable to parse Valid-Until: %s", err)
+		}
+		validFor = validUntil.Sub(date)
+	}
+
+	now = now.UTC()
+	lines[dateLine] = "Date: " + now.Format(releaseDateFormat)
+	validUntil := "Valid-Until: " + now.Add(validFor).Format(releaseDateFormat)
+
+	if validUntilLine == -1 {
+		lines = append(lines[:dateLine+1], append([]string{validUntil}, lines[dateLine+1:]...)...)
+	} else {
+		lines[validUntilLine] = validUntil
+	}
+
+	return []byte(strings.Join(lines, "\n")), true, nil
+}
+
+// Resign signs Release file of published repository again (e.g. after signing key
+// rotation) and uploads only the signatures: InRelease and Release.gpg
+//
+// If Release has Valid-Until field, Date and Valid-Until are renewed keeping the
+// same validity period; validFor (if not zero) sets new validity period. Release
+// is uploaded again in that case. Package indexes are never regenerated.
+func (p *PublishedRepo) Resign(publishedStorageProvider aptly.PublishedStorageProvider, signer utils.Signer,
+	validFor time.Duration, progress aptly.Progress) error {
+	if signer == nil {
+		return fmt.Errorf("unable to resign: no signer configured")
+	}
+
+	publishedStorage := publishedStorageProvider.GetPublishedStorage(p.Storage)
+	defer reportPartialFailures(publishedStorage, progress)
+
+	basePath := filepath.Join(p.Prefix, "dists", p.Distribution)
+
+	var (
+		release []byte
+		err     error
+	)
+
+	if p.SkipRelease {
+		// only InRelease is published, recover Release from it
+		var signed bool
+
+		release, err = readPublishedFile(publishedStorage, filepath.Join(basePath, "InRelease"))
+		if err == nil {
+			release, signed, err = stripClearsign(release)
+			if err == nil && !signed {
+				err = fmt.Errorf("InRelease is not signed")
+			}
+		}
+	} else {
+		release, err = readPublishedFile(publishedStorage, filepath.Join(basePath, "Release"))
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read published Release file: %s", err)
+	}
+
+	release, renewed, err := renewValidUntil(release, releaseDate(), validFor)
+	if err != nil {
+		return fmt.Errorf("unable to renew Valid-Until: %s", err)
+	}
+
+	var tempDir string
+	tempDir, err = ioutil.TempDir(p.tempDir, "aptly")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	releaseFilename := filepath.Join(tempDir, "Release")
+	err = ioutil.WriteFile(releaseFilename, release, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write Release file: %s", err)
+	}
+
+	// Signing files might output to console, so flush progress writer first
+	if progress != nil {
+		progress.Flush()
+	}
+
+	updated := []string{}
+	if renewed && !p.SkipRelease {
+		updated = append(updated, "Release")
+	}
+	if !p.InReleaseOnly {
+		updated = append(updated, "Release.gpg")
+		err = signer.DetachedSign(releaseFilename, filepath.Join(tempDir, "Release.gpg"))
+		if err != nil {
+			return fmt.Errorf("unable to detached sign file: %s", err)
+		}
+	}
+
+	updated = append(updated, "InRelease")
+	err = signer.ClearSign(releaseFilename, filepath.Join(tempDir, "InRelease"))
+	if err != nil {
+		return fmt.Errorf("unable to clearsign file: %s", err)
+	}
+
+	// upload under temporary names first, so that files are replaced with minimum downtime
+	for _, name := range updated {
+		err = publishedStorage.PutFile(filepath.Join(basePath, name+".tmp"), filepath.Join(tempDir, name))
+		if err != nil {
+			return fmt.Errorf("unable to publish file: %s", err)
+		}
+	}
+
+	for _, name := range updated {
+		err = publishedStorage.RenameFile(filepath.Join(basePath, name+".tmp"), filepath.Join(basePath, name))
+		if err != nil {
+			return fmt.Errorf("unable to rename: %s", err)
+		}
+	}
+
+	if p.ChecksumsManifest {
+		var data []byte
+
+		data, err = readPublishedFile(publishedStorage, filepath.Join(p.Prefix, ChecksumsManifestName))
+		if err != nil {
+			return fmt.Errorf("unable to read checksums manifest: %s", err)
+		}
+
+		manifest := parseChecksumsManifest(data)
+		for _, name := range updated {
+			err = manifest.addFile(filepath.Join("dists", p.Distribution, name), filepath.Join(tempDir, name))
+			if err != nil {
+				return err
+			}
+		}
+
+		err = manifest.publish(publishedStorage, p.Prefix, tempDir)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -676,6 +1461,7 @@ func (p *PublishedRepo) Publish(packagePool aptly.PackagePool, publishedStorageP
 func (p *PublishedRepo) RemoveFiles(publishedStorageProvider aptly.PublishedStorageProvider, removePrefix bool,
 	removePoolComponents []string, progress aptly.Progress) error {
 	publishedStorage := publishedStorageProvider.GetPublishedStorage(p.Storage)
+	defer reportPartialFailures(publishedStorage, progress)
 
 	// I. Easy: remove whole prefix (meta+packages)
 	if removePrefix {
@@ -707,8 +1493,9 @@ func (p *PublishedRepo) RemoveFiles(publishedStorageProvider aptly.PublishedStor
 // PublishedRepoCollection does listing, updating/adding/deleting of PublishedRepos
 type PublishedRepoCollection struct {
 	*sync.RWMutex
-	db   database.Storage
-	list []*PublishedRepo
+	db        database.Storage
+	refCounts *RefCounts
+	list      []*PublishedRepo
 }
 
 // NewPublishedRepoCollection loads PublishedRepos from DB and makes up collection
@@ -768,6 +1555,10 @@ func (collection *PublishedRepoCollection) Update(repo *PublishedRepo) (err erro
 
 	if repo.SourceKind == "local" {
 		for component, item := range repo.sourceItems {
+			err = collection.refCounts.Replace(repo.RefKey(component), item.packageRefs)
+			if err != nil {
+				return
+			}
 			err = collection.db.Put(repo.RefKey(component), item.packageRefs.Encode())
 			if err != nil {
 				return
@@ -920,6 +1711,7 @@ func (collection *PublishedRepoCollection) Len() int {
 // CleanupPrefixComponentFiles removes all unreferenced files in published storage under prefix/component pair
 func (collection *PublishedRepoCollection) CleanupPrefixComponentFiles(prefix string, components []string,
 	publishedStorage aptly.PublishedStorage, collectionFactory *CollectionFactory, progress aptly.Progress) error {
+	defer reportPartialFailures(publishedStorage, progress)
 
 	var err error
 	referencedFiles := map[string][]string{}
@@ -957,7 +1749,7 @@ func (collection *PublishedRepoCollection) CleanupPrefixComponentFiles(prefix st
 						return err
 					}
 
-					packageList.ForEach(func(p *Package) error {
+					err = packageList.ForEach(func(p *Package) error {
 						poolDir, err := p.PoolDirectory()
 						if err != nil {
 							return err
@@ -969,6 +1761,10 @@ func (collection *PublishedRepoCollection) CleanupPrefixComponentFiles(prefix st
 
 						return nil
 					})
+					if err != nil {
+						// list of referenced files is incomplete, nothing could be removed safely
+						return err
+					}
 				}
 			}
 		}
@@ -998,9 +1794,102 @@ func (collection *PublishedRepoCollection) CleanupPrefixComponentFiles(prefix st
 	return nil
 }
 
+// OrphanedFiles returns files under dists/ and pool/ of prefix in published storage which
+// are not used by any published repository (e.g. left over by failed or changed publishes)
+//
+// Paths are relative to prefix.
+func (collection *PublishedRepoCollection) OrphanedFiles(storage, prefix string, publishedStorage aptly.PublishedStorage,
+	collectionFactory *CollectionFactory, progress aptly.Progress) ([]string, error) {
+	var (
+		referencedFiles []string
+		distributions   []string
+	)
+
+	for _, r := range collection.list {
+		// only files under dists/ and pool/ of prefix are inspected, so files of other
+		// prefixes (even nested into this one) are never considered
+		if r.Storage != storage || r.Prefix != prefix {
+			continue
+		}
+
+		distributions = append(distributions, "dists/"+r.Distribution+"/")
+
+		err := collection.LoadComplete(r, collectionFactory)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, component := range r.Components() {
+			packageList, err := NewPackageListFromRefList(r.RefList(component), collectionFactory.PackageCollection(), progress)
+			if err != nil {
+				return nil, err
+			}
+
+			err = packageList.ForEach(func(p *Package) error {
+				poolDir, err := p.PoolDirectory()
+				if err != nil {
+					return err
+				}
+
+				for _, f := range p.Files() {
+					referencedFiles = append(referencedFiles, filepath.Join("pool", component, poolDir, f.Filename))
+				}
+
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	existingFiles := []string{}
+	for _, dir := range []string{"dists", "pool"} {
+		list, err := publishedStorage.Filelist(filepath.Join(prefix, dir))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range list {
+			existingFiles = append(existingFiles, filepath.Join(dir, file))
+		}
+	}
+
+	sort.Strings(existingFiles)
+	sort.Strings(referencedFiles)
+
+	result := []string{}
+
+	for _, file := range utils.StrSlicesSubstract(existingFiles, referencedFiles) {
+		used := false
+
+		for _, dir := range distributions {
+			if strings.HasPrefix(file, dir) {
+				used = true
+				break
+			}
+		}
+
+		if !used {
+			result = append(result, file)
+		}
+	}
+
+	return result, nil
+}
+
 // Remove removes published repository, cleaning up directories, files
+//
+// Package files in pool which are shared with other published repositories under the same
+// prefix are kept. If keepPool is true, pool is not touched at all; if forceDrop is true,
+// pool components of repository are removed even if they are used by other published repositories.
 func (collection *PublishedRepoCollection) Remove(publishedStorageProvider aptly.PublishedStorageProvider,
-	storage, prefix, distribution string, collectionFactory *CollectionFactory, progress aptly.Progress) error {
+	storage, prefix, distribution string, collectionFactory *CollectionFactory, progress aptly.Progress,
+	keepPool, forceDrop bool) error {
+	if keepPool && forceDrop {
+		return fmt.Errorf("keepPool and forceDrop can't be used together")
+	}
+
 	repo, err := collection.ByStoragePrefixDistribution(storage, prefix, distribution)
 	if err != nil {
 		return err
@@ -1010,6 +1899,7 @@ func (collection *PublishedRepoCollection) Remove(publishedStorageProvider aptly
 	removePoolComponents := repo.Components()
 	cleanComponents := []string{}
 	repoPosition := -1
+	hasSiblings, hasManifest := false, repo.ChecksumsManifest
 
 	for i, r := range collection.list {
 		if r == repo {
@@ -1018,6 +1908,8 @@ func (collection *PublishedRepoCollection) Remove(publishedStorageProvider aptly
 		}
 		if r.Storage == repo.Storage && r.Prefix == repo.Prefix {
 			removePrefix = false
+			hasSiblings = true
+			hasManifest = hasManifest || r.ChecksumsManifest
 
 			rComponents := r.Components()
 			for _, component := range rComponents {
@@ -1029,6 +1921,15 @@ func (collection *PublishedRepoCollection) Remove(publishedStorageProvider aptly
 		}
 	}
 
+	if keepPool {
+		removePrefix = false
+		removePoolComponents = []string{}
+		cleanComponents = []string{}
+	} else if forceDrop {
+		removePoolComponents = repo.Components()
+		cleanComponents = []string{}
+	}
+
 	err = repo.RemoveFiles(publishedStorageProvider, removePrefix, removePoolComponents, progress)
 	if err != nil {
 		return err
@@ -1051,11 +1952,23 @@ func (collection *PublishedRepoCollection) Remove(publishedStorageProvider aptly
 	}
 
 	for _, component := range repo.Components() {
+		err = collection.refCounts.Replace(repo.RefKey(component), nil)
+		if err != nil {
+			return err
+		}
 		err = collection.db.Delete(repo.RefKey(component))
 		if err != nil {
 			return err
 		}
 	}
 
+	if hasSiblings && hasManifest {
+		err = collection.updateChecksumsManifest(repo, publishedStorageProvider.GetPublishedStorage(storage),
+			collectionFactory, progress)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
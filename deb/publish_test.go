@@ -7,10 +7,16 @@ import (
 	"github.com/smira/aptly/aptly"
 	"github.com/smira/aptly/database"
 	"github.com/smira/aptly/files"
+	"github.com/smira/aptly/multi"
+	"github.com/smira/aptly/utils"
 	"github.com/ugorji/go/codec"
+	"golang.org/x/net/context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
   . "gopkg.in/check.v1"
 )
@@ -54,6 +60,25 @@ func (n *NullSigner) ClearSign(source string, destination string) error {
 	return ioutil.WriteFile(destination, []byte{}, 0644)
 }
 
+// keySigner marks signatures with name of the key
+type keySigner struct {
+	NullSigner
+	key string
+}
+
+func (k *keySigner) DetachedSign(source string, destination string) error {
+	return ioutil.WriteFile(destination, []byte(k.key), 0644)
+}
+
+func (k *keySigner) ClearSign(source string, destination string) error {
+	contents, err := ioutil.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(destination, []byte(pgpSignedMessageHeader+"\nHash: SHA256\n\n"+string(contents)+
+		pgpSignatureHeader+"\n"+k.key+"\n-----END PGP SIGNATURE-----\n"), 0644)
+}
+
 type FakeStorageProvider struct {
 	storages map[string]aptly.PublishedStorage
 }
@@ -117,14 +142,19 @@ func (s *PublishedRepoSuite) SetUpTest(c *C) {
 	s.packageCollection.Update(s.p3)
 
 	s.repo, _ = NewPublishedRepo("", "ppa", "squeeze", nil, []string{"main"}, []interface{}{s.snapshot}, s.factory)
+	s.repo.SetSkipSigning(true)
 
 	s.repo2, _ = NewPublishedRepo("", "ppa", "maverick", nil, []string{"main"}, []interface{}{s.localRepo}, s.factory)
+	s.repo2.SetSkipSigning(true)
 
 	s.repo3, _ = NewPublishedRepo("", "linux", "natty", nil, []string{"main", "contrib"}, []interface{}{s.snapshot, s.snapshot2}, s.factory)
+	s.repo3.SetSkipSigning(true)
 
 	s.repo4, _ = NewPublishedRepo("", "ppa", "maverick", []string{"source"}, []string{"main"}, []interface{}{s.localRepo}, s.factory)
+	s.repo4.SetSkipSigning(true)
 
 	s.repo5, _ = NewPublishedRepo("files:other", "ppa", "maverick", []string{"source"}, []string{"main"}, []interface{}{s.localRepo}, s.factory)
+	s.repo5.SetSkipSigning(true)
 
 	poolPath, _ := s.packagePool.Path(s.p1.Files()[0].Filename, s.p1.Files()[0].Checksums.MD5)
 	err := os.MkdirAll(filepath.Dir(poolPath), 0755)
@@ -337,6 +367,556 @@ func (s *PublishedRepoSuite) TestPublish(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *PublishedRepoSuite) TestPublishMulti(c *C) {
+	s.provider.storages["multi:mirrored"] = multi.NewPublishedStorage([]multi.Target{
+		{Name: "", Storage: s.publishedStorage},
+		{Name: "files:other", Storage: s.publishedStorage2}}, false)
+
+	repo, _ := NewPublishedRepo("multi:mirrored", "ppa", "squeeze", nil, []string{"main"}, []interface{}{s.snapshot}, s.factory)
+	repo.SetSkipSigning(true)
+
+	err := repo.Publish(s.packagePool, s.provider, s.factory, &NullSigner{}, nil, false)
+	c.Assert(err, IsNil)
+
+	for _, storage := range []*files.PublishedStorage{s.publishedStorage, s.publishedStorage2} {
+		c.Check(filepath.Join(storage.PublicPath(), "ppa/dists/squeeze/Release"), PathExists)
+		c.Check(filepath.Join(storage.PublicPath(), "ppa/dists/squeeze/main/binary-i386/Packages"), PathExists)
+		c.Check(filepath.Join(storage.PublicPath(), "ppa/pool/main/a/alien-arena/alien-arena-common_7.40-2_i386.deb"), PathExists)
+	}
+}
+
+// failingPublishedStorage fails to put any file
+type failingPublishedStorage struct {
+	*files.PublishedStorage
+}
+
+func (storage failingPublishedStorage) PutFile(path string, sourceFilename string) error {
+	return errors.New("storage is down")
+}
+
+func (s *PublishedRepoSuite) TestPublishMultiBestEffort(c *C) {
+	storage := multi.NewPublishedStorage([]multi.Target{
+		{Name: "", Storage: s.publishedStorage},
+		{Name: "files:broken", Storage: failingPublishedStorage{s.publishedStorage2}}}, true)
+	s.provider.storages["multi:mirrored"] = storage
+
+	repo, _ := NewPublishedRepo("multi:mirrored", "ppa", "squeeze", nil, []string{"main"}, []interface{}{s.snapshot}, s.factory)
+	repo.SetSkipSigning(true)
+
+	err := repo.Publish(s.packagePool, s.provider, s.factory, &NullSigner{}, nil, false)
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/Release"), PathExists)
+
+	// failures are reported without progress, and failed target is tried again afterwards
+	c.Assert(repo.PartialFailures(), HasLen, 1)
+	c.Check(repo.PartialFailures()[0], ErrorMatches, "publishing to files:broken failed: storage is down")
+	c.Check(storage.Failures(), HasLen, 0)
+
+	c.Check(storage.MkDir("ppa/extra"), IsNil)
+	c.Check(filepath.Join(s.publishedStorage2.PublicPath(), "ppa/extra"), PathExists)
+}
+
+func (s *PublishedRepoSuite) checkChecksumsManifest(c *C, path string) checksumsManifest {
+	data, err := ioutil.ReadFile(filepath.Join(s.publishedStorage.PublicPath(), path, ChecksumsManifestName))
+	c.Assert(err, IsNil)
+
+	manifest := parseChecksumsManifest(data)
+	for file, hash := range manifest {
+		info, err := utils.ChecksumsForFile(filepath.Join(s.publishedStorage.PublicPath(), path, file))
+		c.Assert(err, IsNil)
+		c.Check(info.SHA256, Equals, hash, Commentf("file %s", file))
+	}
+
+	return manifest
+}
+
+func (s *PublishedRepoSuite) TestPublishChecksumsManifest(c *C) {
+	s.repo.ChecksumsManifest = true
+	err := s.repo.Publish(s.packagePool, s.provider, s.factory, &NullSigner{}, nil, false)
+	c.Assert(err, IsNil)
+	c.Assert(s.factory.PublishedRepoCollection().Add(s.repo), IsNil)
+
+	manifest := s.checkChecksumsManifest(c, "ppa")
+	for _, file := range []string{"dists/squeeze/Release", "dists/squeeze/Release.gpg", "dists/squeeze/InRelease",
+		"dists/squeeze/main/binary-i386/Packages", "dists/squeeze/main/binary-i386/Packages.gz",
+		"pool/main/a/alien-arena/alien-arena-common_7.40-2_i386.deb"} {
+		c.Check(manifest[file], Not(Equals), "", Commentf("file %s", file))
+	}
+
+	// other distribution under the same prefix keeps index files of the first one
+	s.repo2.ChecksumsManifest = true
+	err = s.repo2.Publish(s.packagePool, s.provider, s.factory, &NullSigner{}, nil, false)
+	c.Assert(err, IsNil)
+
+	manifest = s.checkChecksumsManifest(c, "ppa")
+	c.Check(manifest["dists/squeeze/Release"], Not(Equals), "")
+	c.Check(manifest["dists/maverick/Release"], Not(Equals), "")
+	c.Check(manifest["pool/main/a/alien-arena/alien-arena-common_7.40-2_i386.deb"], Not(Equals), "")
+
+	var buf bytes.Buffer
+	c.Assert(manifest.WriteTo(&buf), IsNil)
+	c.Check(strings.HasPrefix(buf.String(), manifest["dists/maverick/InRelease"]+"  dists/maverick/InRelease\n"), Equals, true)
+}
+
+func (s *PublishedRepoSuite) TestResign(c *C) {
+	s.repo.ChecksumsManifest = true
+	c.Assert(s.repo.Publish(s.packagePool, s.provider, s.factory, &keySigner{key: "old"}, nil, false), IsNil)
+
+	distPath := filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze")
+
+	unchanged := map[string][]byte{}
+	for _, path := range []string{"Release", "main/binary-i386/Packages", "main/binary-i386/Packages.gz", "main/binary-i386/Release"} {
+		data, err := ioutil.ReadFile(filepath.Join(distPath, path))
+		c.Assert(err, IsNil)
+		unchanged[path] = data
+	}
+
+	c.Check(s.repo.Resign(s.provider, nil, 0, nil), ErrorMatches, "unable to resign: no signer configured")
+
+	c.Assert(s.repo.Resign(s.provider, &keySigner{key: "new"}, 0, nil), IsNil)
+
+	for path, expected := range unchanged {
+		actual, err := ioutil.ReadFile(filepath.Join(distPath, path))
+		c.Assert(err, IsNil)
+		c.Check(bytes.Equal(actual, expected), Equals, true, Commentf("%s changed", path))
+	}
+
+	detached, err := ioutil.ReadFile(filepath.Join(distPath, "Release.gpg"))
+	c.Assert(err, IsNil)
+	c.Check(string(detached), Equals, "new")
+
+	inRelease, err := ioutil.ReadFile(filepath.Join(distPath, "InRelease"))
+	c.Assert(err, IsNil)
+	c.Check(strings.Contains(string(inRelease), "\nnew\n"), Equals, true)
+	c.Check(strings.Contains(string(inRelease), string(unchanged["Release"])), Equals, true)
+
+	c.Check(filepath.Join(distPath, "InRelease.tmp"), Not(PathExists))
+	c.Check(filepath.Join(distPath, "Release.gpg.tmp"), Not(PathExists))
+
+	// manifest lists new signatures
+	s.checkChecksumsManifest(c, "ppa")
+}
+
+func (s *PublishedRepoSuite) TestResignValidUntil(c *C) {
+	os.Setenv("SOURCE_DATE_EPOCH", "1420070400")
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+
+	c.Assert(s.repo.Publish(s.packagePool, s.provider, s.factory, &keySigner{key: "old"}, nil, false), IsNil)
+
+	distPath := filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze")
+	packages, err := ioutil.ReadFile(filepath.Join(distPath, "main/binary-i386/Packages"))
+	c.Assert(err, IsNil)
+
+	c.Assert(s.repo.Resign(s.provider, &keySigner{key: "new"}, 48*time.Hour, nil), IsNil)
+
+	release, err := ioutil.ReadFile(filepath.Join(distPath, "Release"))
+	c.Assert(err, IsNil)
+	c.Check(strings.Contains(string(release), "Date: Thu, 1 Jan 2015 00:00:00 UTC\nValid-Until: Sat, 3 Jan 2015 00:00:00 UTC\n"), Equals, true)
+
+	inRelease, err := ioutil.ReadFile(filepath.Join(distPath, "InRelease"))
+	c.Assert(err, IsNil)
+	c.Check(strings.Contains(string(inRelease), string(release)), Equals, true)
+
+	// validity period is kept on next resign
+	os.Setenv("SOURCE_DATE_EPOCH", "1420156800")
+	c.Assert(s.repo.Resign(s.provider, &keySigner{key: "new"}, 0, nil), IsNil)
+
+	release, err = ioutil.ReadFile(filepath.Join(distPath, "Release"))
+	c.Assert(err, IsNil)
+	c.Check(strings.Contains(string(release), "Date: Fri, 2 Jan 2015 00:00:00 UTC\nValid-Until: Sun, 4 Jan 2015 00:00:00 UTC\n"), Equals, true)
+
+	actual, err := ioutil.ReadFile(filepath.Join(distPath, "main/binary-i386/Packages"))
+	c.Assert(err, IsNil)
+	c.Check(bytes.Equal(actual, packages), Equals, true)
+}
+
+func (s *PublishedRepoSuite) TestRenewValidUntil(c *C) {
+	now := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+	release := []byte("Origin: test\nDate: Sun, 1 Feb 2015 12:00:00 UTC\nArchitectures: i386\n")
+
+	result, renewed, err := renewValidUntil(release, now, 0)
+	c.Assert(err, IsNil)
+	c.Check(renewed, Equals, false)
+	c.Check(string(result), Equals, string(release))
+
+	result, renewed, err = renewValidUntil(release, now, 24*time.Hour)
+	c.Assert(err, IsNil)
+	c.Check(renewed, Equals, true)
+	c.Check(string(result), Equals, "Origin: test\nDate: Sun, 1 Mar 2015 12:00:00 UTC\nValid-Until: Mon, 2 Mar 2015 12:00:00 UTC\nArchitectures: i386\n")
+
+	result, renewed, err = renewValidUntil([]byte("Date: Sun, 1 Feb 2015 12:00:00 UTC\nValid-Until: Sun, 8 Feb 2015 12:00:00 UTC\n"), now, 0)
+	c.Assert(err, IsNil)
+	c.Check(renewed, Equals, true)
+	c.Check(string(result), Equals, "Date: Sun, 1 Mar 2015 12:00:00 UTC\nValid-Until: Sun, 8 Mar 2015 12:00:00 UTC\n")
+
+	_, _, err = renewValidUntil([]byte("Origin: test\n"), now, time.Hour)
+	c.Check(err, ErrorMatches, "no Date in Release file")
+
+	_, _, err = renewValidUntil([]byte("Date: yesterday\nValid-Until: Sun, 8 Feb 2015 12:00:00 UTC\n"), now, 0)
+	c.Check(err, ErrorMatches, "unable to parse Date: .*")
+}
+
+func (s *PublishedRepoSuite) TestResignInReleaseOnly(c *C) {
+	s.repo.InReleaseOnly = true
+	s.repo.SkipRelease = true
+	c.Assert(s.repo.Publish(s.packagePool, s.provider, s.factory, &keySigner{key: "old"}, nil, false), IsNil)
+
+	distPath := filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze")
+	old, err := ioutil.ReadFile(filepath.Join(distPath, "InRelease"))
+	c.Assert(err, IsNil)
+
+	c.Assert(s.repo.Resign(s.provider, &keySigner{key: "new"}, 0, nil), IsNil)
+
+	inRelease, err := ioutil.ReadFile(filepath.Join(distPath, "InRelease"))
+	c.Assert(err, IsNil)
+	c.Check(string(inRelease), Equals, strings.Replace(string(old), "\nold\n", "\nnew\n", 1))
+
+	c.Check(filepath.Join(distPath, "Release"), Not(PathExists))
+	c.Check(filepath.Join(distPath, "Release.gpg"), Not(PathExists))
+}
+
+func (s *PublishedRepoSuite) TestPublishReproducible(c *C) {
+	os.Setenv("SOURCE_DATE_EPOCH", "1420070400")
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+
+	// same packages added in different order
+	list := NewPackageList()
+	list.Add(s.p3)
+	list.Add(s.p2)
+	list.Add(s.p1)
+	localRepo := NewLocalRepo("local2", "comment2")
+	localRepo.UpdateRefList(NewPackageRefListFromPackageList(list))
+	c.Assert(s.factory.LocalRepoCollection().Add(localRepo), IsNil)
+
+	repo, _ := NewPublishedRepo("files:other", "ppa", "maverick", nil, []string{"main"}, []interface{}{localRepo}, s.factory)
+	repo.SetSkipSigning(true)
+
+	c.Assert(s.repo2.Publish(s.packagePool, s.provider, s.factory, nil, nil, false), IsNil)
+	c.Assert(repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false), IsNil)
+
+	for _, path := range []string{"Release", "main/binary-i386/Packages", "main/binary-i386/Packages.gz",
+		"main/binary-i386/Packages.bz2", "main/binary-i386/Release"} {
+		expected, err := ioutil.ReadFile(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/maverick", path))
+		c.Assert(err, IsNil)
+		actual, err := ioutil.ReadFile(filepath.Join(s.publishedStorage2.PublicPath(), "ppa/dists/maverick", path))
+		c.Assert(err, IsNil)
+		c.Check(bytes.Equal(actual, expected), Equals, true, Commentf("%s differs", path))
+	}
+
+	release, _ := ioutil.ReadFile(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/maverick/Release"))
+	c.Check(strings.Contains(string(release), "Date: Thu, 1 Jan 2015 00:00:00 UTC\n"), Equals, true)
+
+	// publishing again produces the same indexes
+	packages, _ := ioutil.ReadFile(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/maverick/main/binary-i386/Packages"))
+	for i := 0; i < 5; i++ {
+		c.Assert(s.repo2.Publish(s.packagePool, s.provider, s.factory, nil, nil, true), IsNil)
+		again, _ := ioutil.ReadFile(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/maverick/main/binary-i386/Packages"))
+		c.Check(bytes.Equal(again, packages), Equals, true)
+		again, _ = ioutil.ReadFile(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/maverick/Release"))
+		c.Check(bytes.Equal(again, release), Equals, true)
+	}
+}
+
+func (s *PublishedRepoSuite) TestPublishLatestOnly(c *C) {
+	list := NewPackageList()
+	for _, version := range []string{"7.40-2", "7.40-10", "7.40-3"} {
+		stanza := packageStanza.Copy()
+		stanza["Version"] = version
+		p := NewPackageFromControlFile(stanza)
+		c.Assert(s.packageCollection.Update(p), IsNil)
+		c.Assert(list.Add(p), IsNil)
+	}
+	localRepo := NewLocalRepo("versions", "several versions")
+	localRepo.UpdateRefList(NewPackageRefListFromPackageList(list))
+	c.Assert(s.factory.LocalRepoCollection().Add(localRepo), IsNil)
+
+	publishedVersions := func(distribution string) []string {
+		f, err := os.Open(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists", distribution, "main/binary-i386/Packages"))
+		c.Assert(err, IsNil)
+		defer f.Close()
+
+		versions := []string{}
+		reader := NewControlFileReader(f)
+		for {
+			stanza, err := reader.ReadStanza()
+			c.Assert(err, IsNil)
+			if stanza == nil {
+				break
+			}
+			versions = append(versions, stanza["Version"])
+		}
+		return versions
+	}
+
+	repo, _ := NewPublishedRepo("", "ppa", "all", nil, []string{"main"}, []interface{}{localRepo}, s.factory)
+	repo.SetSkipSigning(true)
+	c.Assert(repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false), IsNil)
+	c.Check(publishedVersions("all"), DeepEquals, []string{"7.40-10", "7.40-3", "7.40-2"})
+
+	repo, _ = NewPublishedRepo("", "ppa", "latest", nil, []string{"main"}, []interface{}{localRepo}, s.factory)
+	repo.SetSkipSigning(true)
+	repo.LatestOnly = true
+	c.Assert(repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false), IsNil)
+	c.Check(publishedVersions("latest"), DeepEquals, []string{"7.40-10"})
+
+	reflist, err := repo.PublishedRefList("main", s.packageCollection)
+	c.Assert(err, IsNil)
+	c.Check(reflist.Len(), Equals, 1)
+	c.Check(string(reflist.Refs[0]), Matches, "Pi386 alien-arena-common 7.40-10 .*")
+
+	// source of published repository is not modified
+	c.Check(localRepo.RefList().Len(), Equals, 3)
+}
+
+func (s *PublishedRepoSuite) TestPublishTwoComponents(c *C) {
+	err := s.repo3.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Assert(err, IsNil)
+
+	rf, err := os.Open(filepath.Join(s.publishedStorage.PublicPath(), "linux/dists/natty/Release"))
+	c.Assert(err, IsNil)
+
+	cfr := NewControlFileReader(rf)
+	st, err := cfr.ReadStanza()
+	c.Assert(err, IsNil)
+
+	c.Check(st["Components"], Equals, "contrib main")
+
+	for _, component := range []string{"main", "contrib"} {
+		pf, err := os.Open(filepath.Join(s.publishedStorage.PublicPath(), "linux/dists/natty", component, "binary-i386/Packages"))
+		c.Assert(err, IsNil)
+
+		cfr = NewControlFileReader(pf)
+		st, err = cfr.ReadStanza()
+		c.Assert(err, IsNil)
+
+		c.Check(st["Filename"], Equals, "pool/"+component+"/a/alien-arena/alien-arena-common_7.40-2_i386.deb")
+
+		c.Check(filepath.Join(s.publishedStorage.PublicPath(), "linux/pool", component, "a/alien-arena/alien-arena-common_7.40-2_i386.deb"), PathExists)
+		pf.Close()
+	}
+}
+
+func (s *PublishedRepoSuite) publishMultiArch(c *C, prefix string, packageArchs, architectures []string) *PublishedRepo {
+	list := NewPackageList()
+	for _, arch := range packageArchs {
+		stanza := packageStanza.Copy()
+		stanza["Architecture"] = arch
+		stanza["Filename"] = "pool/contrib/a/alien-arena/alien-arena-common_7.40-2_" + arch + ".deb"
+		p := NewPackageFromControlFile(stanza)
+		c.Assert(list.Add(p), IsNil)
+		c.Assert(s.packageCollection.Update(p), IsNil)
+
+		poolPath, _ := s.packagePool.Path(p.Files()[0].Filename, p.Files()[0].Checksums.MD5)
+		c.Assert(os.MkdirAll(filepath.Dir(poolPath), 0755), IsNil)
+		f, err := os.Create(poolPath)
+		c.Assert(err, IsNil)
+		f.Close()
+	}
+
+	snapshot := NewSnapshotFromPackageList(prefix, nil, list, "")
+	c.Assert(s.factory.SnapshotCollection().Add(snapshot), IsNil)
+
+	repo, err := NewPublishedRepo("", prefix, "squeeze", architectures, []string{"main"}, []interface{}{snapshot}, s.factory)
+	c.Assert(err, IsNil)
+	repo.SetSkipSigning(true)
+
+	err = repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Assert(err, IsNil)
+
+	return repo
+}
+
+func (s *PublishedRepoSuite) TestRemoveSharedPool(c *C) {
+	packages := map[string]*Package{}
+	for _, name := range []string{"shared", "unique"} {
+		stanza := packageStanza.Copy()
+		stanza["Package"] = name
+		delete(stanza, "Source")
+		stanza["Filename"] = "pool/main/" + name[:1] + "/" + name + "/" + name + "_7.40-2_i386.deb"
+		p := NewPackageFromControlFile(stanza)
+		c.Assert(s.packageCollection.Update(p), IsNil)
+		packages[name] = p
+
+		poolPath, _ := s.packagePool.Path(p.Files()[0].Filename, p.Files()[0].Checksums.MD5)
+		c.Assert(os.MkdirAll(filepath.Dir(poolPath), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(poolPath, []byte(name), 0644), IsNil)
+	}
+
+	list1 := NewPackageList()
+	list1.Add(packages["shared"])
+	list1.Add(packages["unique"])
+	list2 := NewPackageList()
+	list2.Add(packages["shared"])
+
+	for i, list := range []*PackageList{list1, list2} {
+		snapshot := NewSnapshotFromPackageList(fmt.Sprintf("shared%d", i), nil, list, "")
+		c.Assert(s.factory.SnapshotCollection().Add(snapshot), IsNil)
+
+		repo, err := NewPublishedRepo("", "shared", fmt.Sprintf("dist%d", i), nil, []string{"main"}, []interface{}{snapshot}, s.factory)
+		c.Assert(err, IsNil)
+		repo.SetSkipSigning(true)
+		c.Assert(repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false), IsNil)
+		c.Assert(s.factory.PublishedRepoCollection().Add(repo), IsNil)
+	}
+
+	c.Assert(s.factory.PublishedRepoCollection().Remove(s.provider, "", "shared", "dist0", s.factory, nil, false, false), IsNil)
+
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "shared/dists/dist0"), Not(PathExists))
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "shared/dists/dist1/Release"), PathExists)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "shared/pool/main/s/shared/shared_7.40-2_i386.deb"), PathExists)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "shared/pool/main/u/unique/unique_7.40-2_i386.deb"), Not(PathExists))
+
+	c.Assert(s.factory.PublishedRepoCollection().Remove(s.provider, "", "shared", "dist1", s.factory, nil, false, false), IsNil)
+
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "shared/pool"), Not(PathExists))
+}
+
+func (s *PublishedRepoSuite) TestChecksumsManifestSiblings(c *C) {
+	packages := map[string]*Package{}
+	for _, name := range []string{"shared_7.40-1_i386", "shared_7.40-2_i386", "other_7.40-2_amd64", "unique_7.40-2_i386"} {
+		parts := strings.Split(name, "_")
+		stanza := packageStanza.Copy()
+		stanza["Package"], stanza["Version"], stanza["Architecture"] = parts[0], parts[1], parts[2]
+		delete(stanza, "Source")
+		stanza["Filename"] = "pool/main/" + name[:1] + "/" + parts[0] + "/" + name + ".deb"
+		p := NewPackageFromControlFile(stanza)
+		c.Assert(s.packageCollection.Update(p), IsNil)
+		packages[name] = p
+
+		poolPath, _ := s.packagePool.Path(p.Files()[0].Filename, p.Files()[0].Checksums.MD5)
+		c.Assert(os.MkdirAll(filepath.Dir(poolPath), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(poolPath, []byte(name), 0644), IsNil)
+	}
+
+	list0 := NewPackageList()
+	list0.Add(packages["shared_7.40-1_i386"])
+	list0.Add(packages["shared_7.40-2_i386"])
+	list0.Add(packages["other_7.40-2_amd64"])
+	list1 := NewPackageList()
+	list1.Add(packages["unique_7.40-2_i386"])
+
+	for i, list := range []*PackageList{list0, list1} {
+		snapshot := NewSnapshotFromPackageList(fmt.Sprintf("manifest%d", i), nil, list, "")
+		c.Assert(s.factory.SnapshotCollection().Add(snapshot), IsNil)
+
+		repo, err := NewPublishedRepo("", "manifest", fmt.Sprintf("dist%d", i), []string{"i386"}, []string{"main"}, []interface{}{snapshot}, s.factory)
+		c.Assert(err, IsNil)
+		repo.SetSkipSigning(true)
+		repo.ChecksumsManifest = true
+		repo.LatestOnly = i == 0
+		c.Assert(repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false), IsNil)
+		c.Assert(s.factory.PublishedRepoCollection().Add(repo), IsNil)
+	}
+
+	readManifest := func() checksumsManifest {
+		data, err := ioutil.ReadFile(filepath.Join(s.publishedStorage.PublicPath(), "manifest", ChecksumsManifestName))
+		c.Assert(err, IsNil)
+		return parseChecksumsManifest(data)
+	}
+
+	// only files which are actually published by the other distribution are listed
+	manifest := readManifest()
+	c.Check(manifest["dists/dist0/Release"], Not(Equals), "")
+	c.Check(manifest["dists/dist1/Release"], Not(Equals), "")
+	c.Check(manifest["pool/main/s/shared/shared_7.40-2_i386.deb"], Not(Equals), "")
+	c.Check(manifest["pool/main/u/unique/unique_7.40-2_i386.deb"], Not(Equals), "")
+	c.Check(manifest["pool/main/s/shared/shared_7.40-1_i386.deb"], Equals, "")
+	c.Check(manifest["pool/main/o/other/other_7.40-2_amd64.deb"], Equals, "")
+
+	// dropped distribution is removed from manifest
+	c.Assert(s.factory.PublishedRepoCollection().Remove(s.provider, "", "manifest", "dist1", s.factory, nil, false, false), IsNil)
+
+	manifest = readManifest()
+	c.Check(manifest["dists/dist0/Release"], Not(Equals), "")
+	c.Check(manifest["pool/main/s/shared/shared_7.40-2_i386.deb"], Not(Equals), "")
+	for path := range manifest {
+		c.Check(strings.HasPrefix(path, "dists/dist1/"), Equals, false, Commentf("file %s", path))
+	}
+	c.Check(manifest["pool/main/u/unique/unique_7.40-2_i386.deb"], Equals, "")
+
+	c.Assert(s.factory.PublishedRepoCollection().Remove(s.provider, "", "manifest", "dist0", s.factory, nil, false, false), IsNil)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "manifest"), Not(PathExists))
+}
+
+func (s *PublishedRepoSuite) TestOrphanedFiles(c *C) {
+	c.Assert(s.repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false), IsNil)
+	c.Assert(s.factory.PublishedRepoCollection().Add(s.repo), IsNil)
+
+	orphans, err := s.factory.PublishedRepoCollection().OrphanedFiles("", "ppa", s.publishedStorage, s.factory, nil)
+	c.Assert(err, IsNil)
+	c.Check(orphans, DeepEquals, []string{})
+
+	s.publishedStorage.MkDir("ppa/dists/old")
+	s.publishedStorage.MkDir("ppa/pool/main/o/orphan")
+	s.publishedStorage.MkDir("ppa/nested/pool/main")
+	ioutil.WriteFile(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/old/Release"), []byte("Origin: old"), 0644)
+	ioutil.WriteFile(filepath.Join(s.publishedStorage.PublicPath(), "ppa/pool/main/o/orphan/orphan_1.0_i386.deb"), []byte("orphan"), 0644)
+	ioutil.WriteFile(filepath.Join(s.publishedStorage.PublicPath(), "ppa/nested/pool/main/file.deb"), []byte("not ours"), 0644)
+
+	orphans, err = s.factory.PublishedRepoCollection().OrphanedFiles("", "ppa", s.publishedStorage, s.factory, nil)
+	c.Assert(err, IsNil)
+	c.Check(orphans, DeepEquals, []string{"dists/old/Release", "pool/main/o/orphan/orphan_1.0_i386.deb"})
+
+	// files of other prefixes are not considered
+	orphans, err = s.factory.PublishedRepoCollection().OrphanedFiles("", ".", s.publishedStorage, s.factory, nil)
+	c.Assert(err, IsNil)
+	c.Check(orphans, DeepEquals, []string{})
+}
+
+func (s *PublishedRepoSuite) TestPublishArchitecturesSubset(c *C) {
+	s.publishMultiArch(c, "subset", []string{"amd64", "arm64", "i386"}, []string{"amd64", "arm64"})
+
+	rf, err := os.Open(filepath.Join(s.publishedStorage.PublicPath(), "subset/dists/squeeze/Release"))
+	c.Assert(err, IsNil)
+	defer rf.Close()
+
+	cfr := NewControlFileReader(rf)
+	st, err := cfr.ReadStanza()
+	c.Assert(err, IsNil)
+
+	c.Check(st["Architectures"], Equals, "amd64 arm64")
+	c.Check(strings.Contains(st["SHA256"], "i386"), Equals, false)
+
+	for _, arch := range []string{"amd64", "arm64"} {
+		c.Check(filepath.Join(s.publishedStorage.PublicPath(), "subset/dists/squeeze/main/binary-"+arch+"/Packages"), PathExists)
+		c.Check(filepath.Join(s.publishedStorage.PublicPath(), "subset/pool/main/a/alien-arena/alien-arena-common_7.40-2_"+arch+".deb"), PathExists)
+	}
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "subset/dists/squeeze/main/binary-i386"), Not(PathExists))
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "subset/pool/main/a/alien-arena/alien-arena-common_7.40-2_i386.deb"), Not(PathExists))
+}
+
+func (s *PublishedRepoSuite) TestPublishArchAll(c *C) {
+	repo := s.publishMultiArch(c, "mixed", []string{"amd64", "all"}, nil)
+	c.Check(repo.Architectures, DeepEquals, []string{"amd64"})
+
+	pf, err := os.Open(filepath.Join(s.publishedStorage.PublicPath(), "mixed/dists/squeeze/main/binary-amd64/Packages"))
+	c.Assert(err, IsNil)
+	defer pf.Close()
+
+	archs := []string{}
+	cfr := NewControlFileReader(pf)
+	for {
+		st, err := cfr.ReadStanza()
+		c.Assert(err, IsNil)
+		if st == nil {
+			break
+		}
+		archs = append(archs, st["Architecture"])
+	}
+	sort.Strings(archs)
+	c.Check(archs, DeepEquals, []string{"all", "amd64"})
+
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "mixed/dists/squeeze/main/binary-all"), Not(PathExists))
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "mixed/pool/main/a/alien-arena/alien-arena-common_7.40-2_all.deb"), PathExists)
+}
+
+func (s *PublishedRepoSuite) TestPublishOnlyArchAll(c *C) {
+	repo := s.publishMultiArch(c, "indep", []string{"all"}, nil)
+	c.Check(repo.Architectures, DeepEquals, []string{"all"})
+
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "indep/dists/squeeze/main/binary-all/Packages"), PathExists)
+}
+
 func (s *PublishedRepoSuite) TestPublishNoSigner(c *C) {
 	err := s.repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
 	c.Assert(err, IsNil)
@@ -345,6 +925,122 @@ func (s *PublishedRepoSuite) TestPublishNoSigner(c *C) {
 	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/main/binary-i386/Release"), PathExists)
 }
 
+func (s *PublishedRepoSuite) TestPublishEmptyLocalRepo(c *C) {
+	emptyRepo := NewLocalRepo("empty", "")
+	c.Assert(s.factory.LocalRepoCollection().Add(emptyRepo), IsNil)
+
+	repo, err := NewPublishedRepo("", "empty", "bootstrap", nil, []string{"main"}, []interface{}{emptyRepo}, s.factory)
+	c.Assert(err, IsNil)
+	repo.SetSkipSigning(true)
+	c.Check(repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false), ErrorMatches, "unable to figure out list of architectures.*")
+
+	repo, err = NewPublishedRepo("", "empty", "bootstrap", []string{"amd64", "i386", "source"}, []string{"main"}, []interface{}{emptyRepo}, s.factory)
+	c.Assert(err, IsNil)
+	c.Assert(repo.Publish(s.packagePool, s.provider, s.factory, &NullSigner{}, nil, false), IsNil)
+
+	for _, path := range []string{"main/binary-amd64/Packages", "main/binary-i386/Packages", "main/source/Sources"} {
+		f, err := os.Open(filepath.Join(s.publishedStorage.PublicPath(), "empty/dists/bootstrap", path))
+		c.Assert(err, IsNil)
+
+		st, err := NewControlFileReader(f).ReadStanza()
+		f.Close()
+		c.Check(err, IsNil)
+		c.Check(st, IsNil)
+
+		c.Check(filepath.Join(s.publishedStorage.PublicPath(), "empty/dists/bootstrap", path+".gz"), PathExists)
+	}
+
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "empty/dists/bootstrap/InRelease"), PathExists)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "empty/dists/bootstrap/Release.gpg"), PathExists)
+
+	rf, err := os.Open(filepath.Join(s.publishedStorage.PublicPath(), "empty/dists/bootstrap/Release"))
+	c.Assert(err, IsNil)
+	defer rf.Close()
+
+	st, err := NewControlFileReader(rf).ReadStanza()
+	c.Assert(err, IsNil)
+
+	c.Check(st["Architectures"], Equals, "amd64 i386")
+	c.Check(st["Components"], Equals, "main")
+	c.Check(strings.Contains(st["MD5Sum"], " d41d8cd98f00b204e9800998ecf8427e        0 main/binary-amd64/Packages\n"), Equals, true)
+	c.Check(strings.Contains(st["MD5Sum"], " d41d8cd98f00b204e9800998ecf8427e        0 main/source/Sources\n"), Equals, true)
+}
+
+func (s *PublishedRepoSuite) TestPublishNotAutomatic(c *C) {
+	s.repo.NotAutomatic = true
+	s.repo.ButAutomaticUpgrades = true
+
+	err := s.repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Assert(err, IsNil)
+
+	rf, err := os.Open(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/Release"))
+	c.Assert(err, IsNil)
+	defer rf.Close()
+
+	st, err := NewControlFileReader(rf).ReadStanza()
+	c.Assert(err, IsNil)
+
+	c.Check(st["NotAutomatic"], Equals, "yes")
+	c.Check(st["ButAutomaticUpgrades"], Equals, "yes")
+
+	// flags are regenerated on each publishing
+	s.repo.ButAutomaticUpgrades = false
+	err = s.repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Assert(err, IsNil)
+
+	rf2, err := os.Open(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/Release"))
+	c.Assert(err, IsNil)
+	defer rf2.Close()
+
+	st, err = NewControlFileReader(rf2).ReadStanza()
+	c.Assert(err, IsNil)
+
+	c.Check(st["NotAutomatic"], Equals, "yes")
+	_, ok := st["ButAutomaticUpgrades"]
+	c.Check(ok, Equals, false)
+}
+
+func (s *PublishedRepoSuite) TestPublishInReleaseOnly(c *C) {
+	// previous publishing left detached signature
+	err := s.repo.Publish(s.packagePool, s.provider, s.factory, &NullSigner{}, nil, false)
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/Release.gpg"), PathExists)
+
+	s.repo.InReleaseOnly = true
+	err = s.repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Check(err, ErrorMatches, "unable to publish InRelease only without signing")
+
+	err = s.repo.Publish(s.packagePool, s.provider, s.factory, &NullSigner{}, nil, false)
+	c.Assert(err, IsNil)
+
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/InRelease"), PathExists)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/Release"), PathExists)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/Release.gpg"), Not(PathExists))
+
+	s.repo.SkipRelease = true
+	err = s.repo.Publish(s.packagePool, s.provider, s.factory, &NullSigner{}, nil, false)
+	c.Assert(err, IsNil)
+
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/InRelease"), PathExists)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/Release"), Not(PathExists))
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/Release.gpg"), Not(PathExists))
+}
+
+func (s *PublishedRepoSuite) TestPublishSkipSigning(c *C) {
+	s.repo.SetSkipSigning(false)
+
+	err := s.repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Check(err, ErrorMatches, "unable to publish: no signer configured and signing wasn't explicitly skipped")
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/Release"), Not(PathExists))
+
+	s.repo.SetSkipSigning(true)
+
+	err = s.repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/Release"), PathExists)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/Release.gpg"), Not(PathExists))
+}
+
 func (s *PublishedRepoSuite) TestPublishLocalRepo(c *C) {
 	err := s.repo2.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
 	c.Assert(err, IsNil)
@@ -359,6 +1055,202 @@ func (s *PublishedRepoSuite) TestPublishLocalSourceRepo(c *C) {
 
 	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/maverick/Release"), PathExists)
 	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/maverick/main/source/Release"), PathExists)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/maverick/main/source/Sources"), PathExists)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/maverick/main/binary-i386"), Not(PathExists))
+
+	rf, err := os.Open(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/maverick/Release"))
+	c.Assert(err, IsNil)
+	defer rf.Close()
+
+	st, err := NewControlFileReader(rf).ReadStanza()
+	c.Assert(err, IsNil)
+	c.Check(st["Architectures"], Equals, "source")
+}
+
+func (s *PublishedRepoSuite) TestReleaseArchitectures(c *C) {
+	s.repo.Architectures = []string{"amd64", "i386", "source"}
+	c.Check(s.repo.releaseArchitectures(), DeepEquals, []string{"amd64", "i386"})
+
+	s.repo.Architectures = []string{"amd64"}
+	c.Check(s.repo.releaseArchitectures(), DeepEquals, []string{"amd64"})
+
+	s.repo.Architectures = []string{"source"}
+	c.Check(s.repo.releaseArchitectures(), DeepEquals, []string{"source"})
+	c.Check(s.repo.BinaryArchitectures(), HasLen, 0)
+}
+
+func (s *PublishedRepoSuite) TestArchitecturesMismatch(c *C) {
+	newList := func(architectures ...string) *PackageList {
+		list := NewPackageList()
+		for _, arch := range architectures {
+			stanza := packageStanza.Copy()
+			stanza["Architecture"] = arch
+			list.Add(NewPackageFromControlFile(stanza))
+		}
+		return list
+	}
+
+	s.repo.Architectures = []string{"i386", "amd64"}
+
+	missing, extra := s.repo.architecturesMismatch(map[string]*PackageList{"main": newList("i386"), "contrib": newList("amd64")})
+	c.Check(missing, HasLen, 0)
+	c.Check(extra, HasLen, 0)
+
+	missing, extra = s.repo.architecturesMismatch(map[string]*PackageList{"main": newList("i386", "s390"), "contrib": newList()})
+	c.Check(missing, DeepEquals, []string{"amd64"})
+	c.Check(extra, DeepEquals, []string{"s390"})
+
+	missing, extra = s.repo.architecturesMismatch(map[string]*PackageList{"main": newList("all")})
+	c.Check(missing, HasLen, 0)
+	c.Check(extra, HasLen, 0)
+
+	s.repo.Architectures = []string{"source", "i386"}
+
+	missing, extra = s.repo.architecturesMismatch(map[string]*PackageList{"main": newList("all")})
+	c.Check(missing, DeepEquals, []string{"source"})
+	c.Check(extra, HasLen, 0)
+}
+
+func (s *PublishedRepoSuite) TestPublishSourceOnlyComponent(c *C) {
+	c.Check(s.repo3.SetSourceOnlyComponents([]string{"non-free"}), ErrorMatches, "component non-free is not being published")
+	c.Assert(s.repo3.SetSourceOnlyComponents([]string{"contrib"}), IsNil)
+
+	err := s.repo3.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Assert(err, IsNil)
+
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "linux/dists/natty/main/binary-i386/Packages"), PathExists)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "linux/dists/natty/contrib/source/Sources"), PathExists)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "linux/dists/natty/contrib/binary-i386"), Not(PathExists))
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "linux/pool/contrib/a/alien-arena/alien-arena-common_7.40-2_i386.deb"), Not(PathExists))
+
+	rf, err := os.Open(filepath.Join(s.publishedStorage.PublicPath(), "linux/dists/natty/Release"))
+	c.Assert(err, IsNil)
+	defer rf.Close()
+
+	cfr := NewControlFileReader(rf)
+	st, err := cfr.ReadStanza()
+	c.Assert(err, IsNil)
+
+	c.Check(st["Architectures"], Equals, "i386")
+	c.Check(strings.Contains(st["SHA256"], "contrib/binary-i386"), Equals, false)
+	c.Check(strings.Contains(st["SHA256"], "contrib/source/Sources"), Equals, true)
+}
+
+func (s *PublishedRepoSuite) TestSetSourceOnlyComponentsKeepsArgument(c *C) {
+	components := []string{"main", "contrib", "main"}
+	c.Assert(s.repo3.SetSourceOnlyComponents(components), IsNil)
+
+	c.Check(s.repo3.SourceOnlyComponents, DeepEquals, []string{"contrib", "main"})
+	c.Check(components, DeepEquals, []string{"main", "contrib", "main"})
+}
+
+func (s *PublishedRepoSuite) TestPublishCompressions(c *C) {
+	c.Check(s.repo.SetCompressions([]string{"xz"}), ErrorMatches, "unknown compression format: xz")
+	c.Assert(s.repo.SetCompressions([]string{"gz"}), IsNil)
+	c.Check(s.repo.SetCompressionLevels(utils.CompressionLevels{utils.CompressionGzip: 12}), ErrorMatches, "gz compression level 12 is out of range 1-9")
+	c.Assert(s.repo.SetCompressionLevels(utils.CompressionLevels{utils.CompressionGzip: 1}), IsNil)
+
+	err := s.repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Assert(err, IsNil)
+
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/main/binary-i386/Packages"), PathExists)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/main/binary-i386/Packages.gz"), PathExists)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/main/binary-i386/Packages.bz2"), Not(PathExists))
+
+	release, err := ioutil.ReadFile(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/Release"))
+	c.Assert(err, IsNil)
+	c.Check(strings.Contains(string(release), "Packages.bz2"), Equals, false)
+}
+
+func (s *PublishedRepoSuite) TestPublishFilenamePrefix(c *C) {
+	c.Check(s.repo.SetFilenamePrefix("http://cdn.example.com/debian"), ErrorMatches, ".*should be relative path.*")
+	c.Check(s.repo.SetFilenamePrefix("/debian"), ErrorMatches, ".*should be relative path.*")
+	c.Check(s.repo.SetFilenamePrefix("../debian"), ErrorMatches, ".*shouldn't contain '..'")
+	c.Check(s.repo.SetFilenamePrefix("cdn debian"), ErrorMatches, ".*contains whitespace")
+	c.Assert(s.repo.SetFilenamePrefix("cdn/debian/"), IsNil)
+	c.Check(s.repo.FilenamePrefix, Equals, "cdn/debian")
+
+	err := s.repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Assert(err, IsNil)
+
+	pf, err := os.Open(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/main/binary-i386/Packages"))
+	c.Assert(err, IsNil)
+	defer pf.Close()
+
+	cfr := NewControlFileReader(pf)
+	st, err := cfr.ReadStanza()
+	c.Assert(err, IsNil)
+	c.Check(st["Filename"], Equals, "cdn/debian/pool/main/a/alien-arena/alien-arena-common_7.40-2_i386.deb")
+
+	// files are still uploaded to the pool of published repository
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/pool/main/a/alien-arena/alien-arena-common_7.40-2_i386.deb"), PathExists)
+}
+
+func (s *PublishedRepoSuite) TestApplyFilenamePrefix(c *C) {
+	stanza := Stanza{"Filename": "pool/main/a/app/app_1.0_i386.deb"}
+	s.repo.applyFilenamePrefix(stanza, false)
+	c.Check(stanza["Filename"], Equals, "pool/main/a/app/app_1.0_i386.deb")
+
+	c.Assert(s.repo.SetFilenamePrefix("cdn"), IsNil)
+	s.repo.applyFilenamePrefix(stanza, false)
+	c.Check(stanza["Filename"], Equals, "cdn/pool/main/a/app/app_1.0_i386.deb")
+
+	stanza = Stanza{"Directory": "pool/main/a/app"}
+	s.repo.applyFilenamePrefix(stanza, true)
+	c.Check(stanza["Directory"], Equals, "cdn/pool/main/a/app")
+}
+
+func (s *PublishedRepoSuite) TestCheckPinning(c *C) {
+	c.Check(s.repo.CheckPinning(), IsNil)
+
+	s.repo.PinFriendly = true
+	c.Check(s.repo.CheckPinning(), ErrorMatches, "pin-friendly publishing requires Origin and Label to be set, missing: Origin, Label")
+
+	s.repo.Origin = "Example"
+	c.Check(s.repo.CheckPinning(), ErrorMatches, ".*missing: Label")
+
+	s.repo.Label = "Example Tools"
+	c.Check(s.repo.CheckPinning(), IsNil)
+}
+
+func (s *PublishedRepoSuite) TestCheckReleaseFlags(c *C) {
+	c.Check(s.repo.CheckReleaseFlags(), IsNil)
+
+	s.repo.ButAutomaticUpgrades = true
+	c.Check(s.repo.CheckReleaseFlags(), ErrorMatches, "ButAutomaticUpgrades requires NotAutomatic to be set")
+
+	s.repo.NotAutomatic = true
+	c.Check(s.repo.CheckReleaseFlags(), IsNil)
+}
+
+func (s *PublishedRepoSuite) TestPublishCancelled(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.repo.SetContext(ctx)
+
+	err := s.repo.Publish(s.packagePool, s.provider, s.factory, &NullSigner{}, nil, false)
+	c.Assert(err, ErrorMatches, ".*publishing cancelled.*")
+
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/Release"), Not(PathExists))
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/InRelease"), Not(PathExists))
+}
+
+func (s *PublishedRepoSuite) TestIndexFilesRemoveTempFiles(c *C) {
+	for _, suffix := range []string{"", ".tmp"} {
+		indexes := newIndexFiles(s.publishedStorage, "ppa/dists/temp", c.MkDir(), suffix)
+		indexes.compressions = []string{}
+
+		w, err := indexes.PackageIndex("main", "i386", false).BufWriter()
+		c.Assert(err, IsNil)
+		c.Assert(w.WriteByte('\n'), IsNil)
+		c.Assert(indexes.FinalizeAll(nil), IsNil)
+
+		path := filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/temp/main/binary-i386/Packages"+suffix)
+		c.Check(path, PathExists)
+
+		c.Assert(indexes.RemoveTempFiles(), IsNil)
+		c.Check(path, Not(PathExists))
+	}
 }
 
 func (s *PublishedRepoSuite) TestPublishOtherStorage(c *C) {
@@ -418,6 +1310,14 @@ func (s *PublishedRepoSuite) TestEncodeDecode(c *C) {
 	s.repo2.sourceItems = nil
 	c.Assert(err, IsNil)
 	c.Assert(repo2, DeepEquals, s.repo2)
+
+	s.repo2.SetOverrides(Overrides{"app": Override{Priority: "extra", Section: "-"}})
+	encoded3 := s.repo2.Encode()
+	repo3 := &PublishedRepo{}
+	err = repo3.Decode(encoded3)
+
+	c.Assert(err, IsNil)
+	c.Check(repo3.Overrides, DeepEquals, s.repo2.Overrides)
 }
 
 type PublishedRepoCollectionSuite struct {
@@ -740,7 +1640,7 @@ func (s *PublishedRepoRemoveSuite) TestRemoveFilesWithPrefixRoot(c *C) {
 }
 
 func (s *PublishedRepoRemoveSuite) TestRemoveRepo1and2(c *C) {
-	err := s.collection.Remove(s.provider, "", "ppa", "anaconda", s.factory, nil)
+	err := s.collection.Remove(s.provider, "", "ppa", "anaconda", s.factory, nil, false, false)
 	c.Check(err, IsNil)
 
 	_, err = s.collection.ByStoragePrefixDistribution("", "ppa", "anaconda")
@@ -760,10 +1660,10 @@ func (s *PublishedRepoRemoveSuite) TestRemoveRepo1and2(c *C) {
 	c.Check(filepath.Join(s.publishedStorage2.PublicPath(), "ppa/dists/osminog"), PathExists)
 	c.Check(filepath.Join(s.publishedStorage2.PublicPath(), "ppa/pool/contrib"), PathExists)
 
-	err = s.collection.Remove(s.provider, "", "ppa", "anaconda", s.factory, nil)
+	err = s.collection.Remove(s.provider, "", "ppa", "anaconda", s.factory, nil, false, false)
 	c.Check(err, ErrorMatches, ".*not found")
 
-	err = s.collection.Remove(s.provider, "", "ppa", "meduza", s.factory, nil)
+	err = s.collection.Remove(s.provider, "", "ppa", "meduza", s.factory, nil, false, false)
 	c.Check(err, IsNil)
 
 	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/anaconda"), Not(PathExists))
@@ -778,7 +1678,7 @@ func (s *PublishedRepoRemoveSuite) TestRemoveRepo1and2(c *C) {
 }
 
 func (s *PublishedRepoRemoveSuite) TestRemoveRepo3(c *C) {
-	err := s.collection.Remove(s.provider, "", ".", "anaconda", s.factory, nil)
+	err := s.collection.Remove(s.provider, "", ".", "anaconda", s.factory, nil, false, false)
 	c.Check(err, IsNil)
 
 	_, err = s.collection.ByStoragePrefixDistribution("", ".", "anaconda")
@@ -799,8 +1699,40 @@ func (s *PublishedRepoRemoveSuite) TestRemoveRepo3(c *C) {
 	c.Check(filepath.Join(s.publishedStorage2.PublicPath(), "ppa/pool/contrib"), PathExists)
 }
 
+func (s *PublishedRepoRemoveSuite) TestRemoveKeepPool(c *C) {
+	err := s.collection.Remove(s.provider, "", ".", "anaconda", s.factory, nil, true, false)
+	c.Check(err, IsNil)
+
+	_, err = s.collection.ByStoragePrefixDistribution("", ".", "anaconda")
+	c.Check(err, ErrorMatches, ".*not found")
+
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "dists/anaconda"), Not(PathExists))
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "pool/main"), PathExists)
+
+	err = s.collection.Remove(s.provider, "", "ppa", "meduza", s.factory, nil, true, false)
+	c.Check(err, IsNil)
+
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/meduza"), Not(PathExists))
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/anaconda"), PathExists)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/pool/main"), PathExists)
+
+	err = s.collection.Remove(s.provider, "", "ppa", "anaconda", s.factory, nil, true, true)
+	c.Check(err, ErrorMatches, ".*can't be used together")
+}
+
+func (s *PublishedRepoRemoveSuite) TestRemoveForceDrop(c *C) {
+	// ppa/pool/main is shared by anaconda & meduza
+	err := s.collection.Remove(s.provider, "", "ppa", "meduza", s.factory, nil, false, true)
+	c.Check(err, IsNil)
+
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/meduza"), Not(PathExists))
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/anaconda"), PathExists)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/pool/main"), Not(PathExists))
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/pool/contrib"), PathExists)
+}
+
 func (s *PublishedRepoRemoveSuite) TestRemoveRepo5(c *C) {
-	err := s.collection.Remove(s.provider, "files:other", "ppa", "osminog", s.factory, nil)
+	err := s.collection.Remove(s.provider, "files:other", "ppa", "osminog", s.factory, nil, false, false)
 	c.Check(err, IsNil)
 
 	_, err = s.collection.ByStoragePrefixDistribution("files:other", "ppa", "osminog")
@@ -820,3 +1752,86 @@ func (s *PublishedRepoRemoveSuite) TestRemoveRepo5(c *C) {
 	c.Check(filepath.Join(s.publishedStorage2.PublicPath(), "ppa/dists/osminog"), Not(PathExists))
 	c.Check(filepath.Join(s.publishedStorage2.PublicPath(), "ppa/pool/contrib"), Not(PathExists))
 }
+
+func (s *PublishedRepoSuite) TestFindDuplicatePackages(c *C) {
+	mainList := NewPackageList()
+	mainList.Add(s.p1)
+	mainList.Add(s.p2)
+	contribList := NewPackageList()
+	contribList.Add(s.p1)
+
+	c.Check(FindDuplicatePackages(map[string]*PackageList{"main": mainList}), HasLen, 0)
+
+	duplicates := FindDuplicatePackages(map[string]*PackageList{"main": mainList, "contrib": contribList})
+	c.Assert(duplicates, HasLen, 1)
+	c.Check(duplicates[0].Package, Equals, s.p1.String())
+	c.Check(duplicates[0].Components, DeepEquals, []string{"contrib", "main"})
+}
+
+func (s *PublishedRepoSuite) TestPublishStrictDuplicates(c *C) {
+	s.repo3.SetStrictDuplicates(true)
+
+	err := s.repo3.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Assert(err, ErrorMatches, "packages present in several components: .*\\(components: contrib, main\\).*")
+
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "linux/dists/natty/Release"), Not(PathExists))
+}
+
+func (s *PublishedRepoSuite) TestPublishDuplicatesWarnings(c *C) {
+	// no progress, so warnings are available only via Warnings()
+	err := s.repo3.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Assert(err, IsNil)
+	c.Assert(s.repo3.Warnings(), HasLen, 1)
+	c.Check(s.repo3.Warnings()[0], ErrorMatches, "packages present in several components: .*\\(components: contrib, main\\).*")
+
+	// warnings are reset on each publish
+	s.repo.SetArchitecturesCheck(true)
+	s.repo.Architectures = []string{"amd64", "i386"}
+	err = s.repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Assert(err, IsNil)
+	c.Check(s.repo.Warnings(), HasLen, 1)
+	c.Check(s.repo.Warnings()[0], ErrorMatches, "Published architectures amd64 have no packages, their indexes would be empty")
+
+	s.repo.Architectures = []string{"i386"}
+	err = s.repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Assert(err, IsNil)
+	c.Check(s.repo.Warnings(), HasLen, 0)
+}
+
+func (s *PublishedRepoSuite) TestPublishArchitecturesCheck(c *C) {
+	s.repo.Architectures = []string{"amd64", "i386"}
+	s.repo.SetArchitecturesCheck(false)
+
+	err := s.repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Assert(err, FitsTypeOf, &ArchitecturesMismatchError{})
+	c.Check(err, ErrorMatches, "published architectures without packages: amd64")
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/Release"), Not(PathExists))
+
+	s.repo.Architectures = []string{"amd64"}
+
+	err = s.repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Check(err, ErrorMatches, "published architectures without packages: amd64, packages of architectures which are not published: i386")
+
+	s.repo.Architectures = []string{"amd64", "i386"}
+	s.repo.SetArchitecturesCheck(true)
+
+	err = s.repo.Publish(s.packagePool, s.provider, s.factory, nil, nil, false)
+	c.Assert(err, IsNil)
+	c.Check(filepath.Join(s.publishedStorage.PublicPath(), "ppa/dists/squeeze/main/binary-amd64/Packages"), PathExists)
+}
+
+func (s *PublishedRepoSuite) TestPublishedRefList(c *C) {
+	c.Check(s.repo.SourceName("main"), Equals, "snap")
+	c.Check(s.repo2.SourceName("main"), Equals, "local1")
+	c.Check(s.repo2.SourceName("contrib"), Equals, "")
+
+	s.repo.Architectures = []string{"i386"}
+	reflist, err := s.repo.PublishedRefList("main", s.factory.PackageCollection())
+	c.Assert(err, IsNil)
+	c.Check(reflist.Len(), Equals, 3)
+
+	s.repo.Architectures = []string{"amd64", "source"}
+	reflist, err = s.repo.PublishedRefList("main", s.factory.PackageCollection())
+	c.Assert(err, IsNil)
+	c.Check(reflist.Len(), Equals, 0)
+}
@@ -0,0 +1,421 @@
+package deb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/smira/aptly/database"
+	"strings"
+	"sync"
+)
+
+// Keys used by reference counts in DB
+var (
+	refCountsEnabledKey       = []byte("Cv")
+	refCountsPackagePrefix    = []byte("Cp")
+	refCountsFilePrefix       = []byte("Cf")
+	refCountsPackageZero      = []byte("Cz")
+	refCountsFileZero         = []byte("Cy")
+	refCountsAllPrefix        = []byte("C")
+	refCountsFileKeySeparator = "/"
+)
+
+// RefCounts tracks number of references to packages (from mirrors, local repos,
+// snapshots and published local repos) and number of referencing packages for
+// each file in the package pool
+//
+// When reference count drops to zero, package (or file) is recorded as candidate
+// for removal, so that cleanup doesn't need to walk all the collections.
+// Reference counts are maintained only when enabled (after rebuild).
+type RefCounts struct {
+	*sync.Mutex
+	db                database.Storage
+	packageCollection *PackageCollection
+	enabled           *bool
+	// rw receives count updates: either db itself or separate batch
+	rw database.ReaderWriter
+	// counts written but not yet visible in DB, kept only in batch mode
+	pending map[string]uint32
+	// parent is tracker which would see updates once batch is written
+	parent *RefCounts
+}
+
+// NewRefCounts creates reference counts tracker bound to database
+func NewRefCounts(db database.Storage, packageCollection *PackageCollection) *RefCounts {
+	return &RefCounts{
+		Mutex:             &sync.Mutex{},
+		db:                db,
+		rw:                db,
+		packageCollection: packageCollection,
+	}
+}
+
+// StartBatch starts batch processing in DB
+//
+// Counts written until FinishBatch are tracked in memory, as they're not
+// visible in DB until batch is written.
+func (rc *RefCounts) StartBatch() {
+	rc.Lock()
+	defer rc.Unlock()
+
+	rc.db.StartBatch()
+	rc.pending = make(map[string]uint32)
+}
+
+// FinishBatch writes batch to DB, forgetting counts tracked in memory
+func (rc *RefCounts) FinishBatch() error {
+	rc.Lock()
+	defer rc.Unlock()
+
+	rc.pending = nil
+	return rc.db.FinishBatch()
+}
+
+// inBatch returns tracker which writes updated counts to batch
+//
+// Caller should hold rc lock until batch is either written (followed by
+// commit) or discarded.
+func (rc *RefCounts) inBatch(batch database.ReaderWriter) *RefCounts {
+	return &RefCounts{
+		Mutex:             &sync.Mutex{},
+		db:                rc.db,
+		rw:                batch,
+		packageCollection: rc.packageCollection,
+		enabled:           rc.enabled,
+		pending:           make(map[string]uint32),
+		parent:            rc,
+	}
+}
+
+// commit makes counts from batched tracker visible after batch is written
+func (rc *RefCounts) commit(batched *RefCounts) {
+	if rc.pending != nil {
+		// DB batch is in progress, keep counts tracked for it up to date
+		for key, count := range batched.pending {
+			rc.pending[key] = count
+		}
+	}
+	if rc.enabled == nil {
+		rc.enabled = batched.enabled
+	}
+}
+
+// Enabled checks whether reference counts are maintained
+func (rc *RefCounts) Enabled() bool {
+	if rc == nil {
+		return false
+	}
+
+	rc.Lock()
+	defer rc.Unlock()
+
+	return rc.isEnabled()
+}
+
+func (rc *RefCounts) isEnabled() bool {
+	if rc.enabled == nil {
+		_, err := rc.db.Get(refCountsEnabledKey)
+		enabled := err == nil
+		rc.enabled = &enabled
+	}
+
+	return *rc.enabled
+}
+
+// Replace updates reference counts when reference list stored under refKey is replaced
+// with refs (refs == nil means reference list is removed)
+//
+// Replace should be called before reference list is saved to DB.
+func (rc *RefCounts) Replace(refKey []byte, refs *PackageRefList) error {
+	if rc == nil {
+		return nil
+	}
+
+	rc.Lock()
+	defer rc.Unlock()
+
+	if !rc.isEnabled() {
+		return nil
+	}
+
+	old := NewPackageRefList()
+	encoded, err := rc.rw.Get(refKey)
+	if err == nil {
+		err = old.Decode(encoded)
+		if err != nil {
+			return err
+		}
+	} else if err != database.ErrNotFound {
+		return err
+	}
+
+	if refs == nil {
+		refs = NewPackageRefList()
+	}
+
+	return rc.adjust(refs.Substract(old), old.Substract(refs))
+}
+
+// Rebuild drops all reference counts and recalculates them from reference lists
+// provided by walker, enabling reference counting
+func (rc *RefCounts) Rebuild(walker func(handler func(refs *PackageRefList) error) error) error {
+	rc.Lock()
+	defer rc.Unlock()
+
+	for _, key := range rc.db.KeysByPrefix(refCountsAllPrefix) {
+		err := rc.db.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	if rc.pending != nil {
+		rc.pending = make(map[string]uint32)
+	}
+
+	err := walker(func(refs *PackageRefList) error {
+		if refs == nil {
+			return nil
+		}
+		return rc.adjust(refs, NewPackageRefList())
+	})
+	if err != nil {
+		return err
+	}
+
+	err = rc.db.Put(refCountsEnabledKey, []byte{})
+	if err != nil {
+		return err
+	}
+
+	enabled := true
+	rc.enabled = &enabled
+	return nil
+}
+
+// UnreferencedPackages returns keys of packages which are no longer referenced
+func (rc *RefCounts) UnreferencedPackages() (*PackageRefList, error) {
+	rc.Lock()
+	defer rc.Unlock()
+
+	result := NewPackageRefList()
+	for _, key := range rc.db.KeysByPrefix(refCountsPackageZero) {
+		ref := key[len(refCountsPackageZero):]
+		count, err := rc.get(refCountsPackagePrefix, ref)
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			result.Refs = append(result.Refs, append([]byte(nil), ref...))
+		}
+	}
+
+	return result, nil
+}
+
+// ForgetPackage removes information about package deleted from DB
+func (rc *RefCounts) ForgetPackage(ref []byte) error {
+	rc.Lock()
+	defer rc.Unlock()
+
+	err := rc.db.Delete(append(append([]byte(nil), refCountsPackageZero...), ref...))
+	if err != nil {
+		return err
+	}
+
+	return rc.set(refCountsPackagePrefix, ref, 0)
+}
+
+// UnreferencedFile is a file in package pool which is no longer used by any package
+type UnreferencedFile struct {
+	Filename string
+	MD5      string
+}
+
+// UnreferencedFiles returns list of files in package pool not used by packages
+func (rc *RefCounts) UnreferencedFiles() ([]UnreferencedFile, error) {
+	rc.Lock()
+	defer rc.Unlock()
+
+	result := []UnreferencedFile{}
+	for _, key := range rc.db.KeysByPrefix(refCountsFileZero) {
+		fileKey := key[len(refCountsFileZero):]
+		count, err := rc.get(refCountsFilePrefix, fileKey)
+		if err != nil {
+			return nil, err
+		}
+		if count != 0 {
+			continue
+		}
+
+		parts := strings.SplitN(string(fileKey), refCountsFileKeySeparator, 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed file reference %s", fileKey)
+		}
+		result = append(result, UnreferencedFile{MD5: parts[0], Filename: parts[1]})
+	}
+
+	return result, nil
+}
+
+// ForgetFile removes information about file deleted from package pool
+func (rc *RefCounts) ForgetFile(file UnreferencedFile) error {
+	rc.Lock()
+	defer rc.Unlock()
+
+	fileKey := []byte(file.MD5 + refCountsFileKeySeparator + file.Filename)
+
+	err := rc.db.Delete(append(append([]byte(nil), refCountsFileZero...), fileKey...))
+	if err != nil {
+		return err
+	}
+
+	return rc.set(refCountsFilePrefix, fileKey, 0)
+}
+
+// markUnreferencedPackage records package just stored in DB (and its files) as
+// candidate for removal, as it has no references yet
+//
+// Nothing is recorded unless reference counts are enabled.
+func markUnreferencedPackage(db database.Storage, p *Package) error {
+	_, err := db.Get(refCountsEnabledKey)
+	if err == database.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = db.Put(append(append([]byte(nil), refCountsPackageZero...), p.Key("")...), []byte{})
+	if err != nil {
+		return err
+	}
+
+	if p.files == nil {
+		return nil
+	}
+
+	for _, f := range *p.files {
+		fileKey := []byte(f.Checksums.MD5 + refCountsFileKeySeparator + f.Filename)
+		err = db.Put(append(append([]byte(nil), refCountsFileZero...), fileKey...), []byte{})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// adjust increments counts for added packages and decrements for removed
+func (rc *RefCounts) adjust(added, removed *PackageRefList) error {
+	err := added.ForEach(func(ref []byte) error {
+		count, _, err := rc.increment(refCountsPackagePrefix, refCountsPackageZero, ref, 1)
+		if err != nil || count != 1 {
+			return err
+		}
+		return rc.adjustFiles(ref, 1)
+	})
+	if err != nil {
+		return err
+	}
+
+	return removed.ForEach(func(ref []byte) error {
+		count, changed, err := rc.increment(refCountsPackagePrefix, refCountsPackageZero, ref, -1)
+		if err != nil || !changed || count != 0 {
+			// package which isn't counted (e.g. already deleted from DB) is skipped
+			return err
+		}
+		return rc.adjustFiles(ref, -1)
+	})
+}
+
+// adjustFiles updates reference counts for files of the package
+func (rc *RefCounts) adjustFiles(ref []byte, delta int) error {
+	p, err := rc.packageCollection.ByKey(ref)
+	if err == database.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, f := range p.Files() {
+		fileKey := []byte(f.Checksums.MD5 + refCountsFileKeySeparator + f.Filename)
+		_, _, err = rc.increment(refCountsFilePrefix, refCountsFileZero, fileKey, delta)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// increment changes count by delta, maintaining list of zero-count candidates
+//
+// Decrementing zero count doesn't change anything, so changed is false.
+func (rc *RefCounts) increment(prefix, zeroPrefix, key []byte, delta int) (count uint32, changed bool, err error) {
+	count, err = rc.get(prefix, key)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if delta > 0 {
+		count++
+	} else if count > 0 {
+		count--
+	} else {
+		return 0, false, nil
+	}
+
+	err = rc.set(prefix, key, count)
+	if err != nil {
+		return 0, false, err
+	}
+
+	zeroKey := append(append([]byte(nil), zeroPrefix...), key...)
+	if count == 0 {
+		err = rc.rw.Put(zeroKey, []byte{})
+	} else if count == 1 && delta > 0 {
+		err = rc.rw.Delete(zeroKey)
+	}
+
+	return count, err == nil, err
+}
+
+func (rc *RefCounts) get(prefix, key []byte) (uint32, error) {
+	fullKey := append(append([]byte(nil), prefix...), key...)
+
+	for tracker := rc; tracker != nil; tracker = tracker.parent {
+		if count, ok := tracker.pending[string(fullKey)]; ok {
+			return count, nil
+		}
+	}
+
+	encoded, err := rc.rw.Get(fullKey)
+	if err == database.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(encoded) != 4 {
+		return 0, fmt.Errorf("malformed reference count for %s", fullKey)
+	}
+
+	return binary.BigEndian.Uint32(encoded), nil
+}
+
+func (rc *RefCounts) set(prefix, key []byte, count uint32) error {
+	fullKey := append(append([]byte(nil), prefix...), key...)
+
+	// DB writes are postponed in batch mode, so keep track of them
+	if rc.pending != nil {
+		rc.pending[string(fullKey)] = count
+	}
+
+	if count == 0 {
+		return rc.rw.Delete(fullKey)
+	}
+
+	encoded := make([]byte, 4)
+	binary.BigEndian.PutUint32(encoded, count)
+	return rc.rw.Put(fullKey, encoded)
+}
@@ -0,0 +1,251 @@
+package deb
+
+import (
+	"github.com/smira/aptly/database"
+	"github.com/smira/aptly/utils"
+
+  . "gopkg.in/check.v1"
+)
+
+type RefCountsSuite struct {
+	db      database.Storage
+	factory *CollectionFactory
+	pkg     *Package
+	reflist *PackageRefList
+}
+
+var _ = Suite(&RefCountsSuite{})
+
+func (s *RefCountsSuite) SetUpTest(c *C) {
+	s.db, _ = database.OpenDB(c.MkDir())
+	s.factory = NewCollectionFactory(s.db)
+
+	s.pkg = &Package{Name: "app", Version: "1.0", Architecture: "amd64"}
+	s.pkg.UpdateFiles(PackageFiles{PackageFile{Filename: "app_1.0_amd64.deb", Checksums: utils.ChecksumInfo{MD5: "d41d8cd98f00b204e9800998ecf8427e"}}})
+	c.Assert(s.factory.PackageCollection().Update(s.pkg), IsNil)
+
+	list := NewPackageList()
+	list.Add(s.pkg)
+	s.reflist = NewPackageRefListFromPackageList(list)
+}
+
+func (s *RefCountsSuite) TearDownTest(c *C) {
+	s.db.Close()
+}
+
+func (s *RefCountsSuite) rebuild(c *C) {
+	c.Assert(s.factory.RefCounts().Rebuild(func(handler func(refs *PackageRefList) error) error {
+		return s.factory.SnapshotCollection().ForEach(func(snapshot *Snapshot) error {
+			err := s.factory.SnapshotCollection().LoadComplete(snapshot)
+			if err != nil {
+				return err
+			}
+			return handler(snapshot.RefList())
+		})
+	}), IsNil)
+}
+
+func (s *RefCountsSuite) TestDisabled(c *C) {
+	c.Check(s.factory.RefCounts().Enabled(), Equals, false)
+
+	snapshot := NewSnapshotFromRefList("snap1", nil, s.reflist, "")
+	c.Assert(s.factory.SnapshotCollection().Add(snapshot), IsNil)
+	c.Assert(s.factory.SnapshotCollection().Drop(snapshot), IsNil)
+
+	c.Check(s.db.KeysByPrefix(refCountsAllPrefix), HasLen, 0)
+}
+
+func (s *RefCountsSuite) TestSnapshots(c *C) {
+	s.rebuild(c)
+	c.Check(s.factory.RefCounts().Enabled(), Equals, true)
+
+	snapshot1 := NewSnapshotFromRefList("snap1", nil, s.reflist, "")
+	snapshot2 := NewSnapshotFromRefList("snap2", nil, s.reflist, "")
+	c.Assert(s.factory.SnapshotCollection().Add(snapshot1), IsNil)
+	c.Assert(s.factory.SnapshotCollection().Add(snapshot2), IsNil)
+
+	c.Assert(s.factory.SnapshotCollection().Drop(snapshot1), IsNil)
+
+	packages, err := s.factory.RefCounts().UnreferencedPackages()
+	c.Assert(err, IsNil)
+	c.Check(packages.Len(), Equals, 0)
+	files, err := s.factory.RefCounts().UnreferencedFiles()
+	c.Assert(err, IsNil)
+	c.Check(files, HasLen, 0)
+
+	c.Assert(s.factory.SnapshotCollection().Drop(snapshot2), IsNil)
+
+	packages, err = s.factory.RefCounts().UnreferencedPackages()
+	c.Assert(err, IsNil)
+	c.Check(packages.Strings(), DeepEquals, []string{string(s.pkg.Key(""))})
+	files, err = s.factory.RefCounts().UnreferencedFiles()
+	c.Assert(err, IsNil)
+	c.Check(files, DeepEquals, []UnreferencedFile{{Filename: "app_1.0_amd64.deb", MD5: "d41d8cd98f00b204e9800998ecf8427e"}})
+
+	c.Assert(s.factory.RefCounts().ForgetPackage(s.pkg.Key("")), IsNil)
+	c.Assert(s.factory.RefCounts().ForgetFile(files[0]), IsNil)
+
+	packages, _ = s.factory.RefCounts().UnreferencedPackages()
+	c.Check(packages.Len(), Equals, 0)
+	files, _ = s.factory.RefCounts().UnreferencedFiles()
+	c.Check(files, HasLen, 0)
+}
+
+func (s *RefCountsSuite) TestRebuild(c *C) {
+	snapshot := NewSnapshotFromRefList("snap1", nil, s.reflist, "")
+	c.Assert(s.factory.SnapshotCollection().Add(snapshot), IsNil)
+
+	s.rebuild(c)
+
+	// re-adding same reference list doesn't change counts
+	c.Assert(s.factory.SnapshotCollection().Update(snapshot), IsNil)
+
+	c.Assert(s.factory.SnapshotCollection().Drop(snapshot), IsNil)
+
+	packages, err := s.factory.RefCounts().UnreferencedPackages()
+	c.Assert(err, IsNil)
+	c.Check(packages.Len(), Equals, 1)
+}
+
+func (s *RefCountsSuite) TestMissingPackage(c *C) {
+	s.rebuild(c)
+
+	snapshot := NewSnapshotFromRefList("snap1", nil, s.reflist, "")
+	c.Assert(s.factory.SnapshotCollection().Add(snapshot), IsNil)
+	c.Assert(s.factory.SnapshotCollection().Drop(snapshot), IsNil)
+
+	// package gets cleaned up
+	c.Assert(s.factory.PackageCollection().DeleteByKey(s.pkg.Key("")), IsNil)
+	c.Assert(s.factory.RefCounts().ForgetPackage(s.pkg.Key("")), IsNil)
+
+	// stale reference list mentioning deleted package is removed
+	c.Assert(s.db.Put([]byte("Estale"), s.reflist.Encode()), IsNil)
+	c.Assert(s.factory.RefCounts().Replace([]byte("Estale"), nil), IsNil)
+
+	packages, err := s.factory.RefCounts().UnreferencedPackages()
+	c.Assert(err, IsNil)
+	c.Check(packages.Len(), Equals, 0)
+
+	count, err := s.factory.RefCounts().get(refCountsPackagePrefix, s.pkg.Key(""))
+	c.Assert(err, IsNil)
+	c.Check(count, Equals, uint32(0))
+}
+
+func (s *RefCountsSuite) TestBatch(c *C) {
+	s.rebuild(c)
+
+	repo := NewLocalRepo("repo", "")
+	repo.UpdateRefList(s.reflist)
+	c.Assert(s.factory.LocalRepoCollection().Add(repo), IsNil)
+
+	// snapshot & clear repo in one batch, counts should stay consistent
+	s.factory.RefCounts().StartBatch()
+	snapshot, _ := NewSnapshotFromLocalRepo("snap1", repo)
+	c.Assert(s.factory.SnapshotCollection().Add(snapshot), IsNil)
+	repo.UpdateRefList(NewPackageRefList())
+	c.Assert(s.factory.LocalRepoCollection().Update(repo), IsNil)
+	c.Assert(s.factory.RefCounts().FinishBatch(), IsNil)
+
+	// counts are in DB now, nothing is kept in memory
+	c.Check(s.factory.RefCounts().pending, IsNil)
+
+	packages, err := s.factory.RefCounts().UnreferencedPackages()
+	c.Assert(err, IsNil)
+	c.Check(packages.Len(), Equals, 0)
+}
+
+func (s *RefCountsSuite) TestSnapshotAndClearLocalRepo(c *C) {
+	s.rebuild(c)
+
+	repo := NewLocalRepo("repo", "")
+	repo.UpdateRefList(s.reflist)
+	c.Assert(s.factory.LocalRepoCollection().Add(repo), IsNil)
+
+	snapshot, _ := NewSnapshotFromLocalRepo("snap1", repo)
+	c.Assert(s.factory.SnapshotAndClearLocalRepo(snapshot, repo), IsNil)
+	c.Check(repo.NumPackages(), Equals, 0)
+
+	packages, err := s.factory.RefCounts().UnreferencedPackages()
+	c.Assert(err, IsNil)
+	c.Check(packages.Len(), Equals, 0)
+
+	snapshot, err = s.factory.SnapshotCollection().ByName("snap1")
+	c.Assert(err, IsNil)
+	c.Assert(s.factory.SnapshotCollection().LoadComplete(snapshot), IsNil)
+	c.Check(snapshot.NumPackages(), Equals, 1)
+
+	// name already taken
+	repo.UpdateRefList(s.reflist)
+	c.Assert(s.factory.LocalRepoCollection().Update(repo), IsNil)
+	snapshot, _ = NewSnapshotFromLocalRepo("snap1", repo)
+	c.Check(s.factory.SnapshotAndClearLocalRepo(snapshot, repo), ErrorMatches, "snapshot with name snap1 already exists")
+	c.Check(repo.NumPackages(), Equals, 1)
+}
+
+func (s *RefCountsSuite) TestSnapshotAndClearLocalRepoRollback(c *C) {
+	s.rebuild(c)
+
+	repo := NewLocalRepo("repo", "")
+	repo.UpdateRefList(s.reflist)
+	c.Assert(s.factory.LocalRepoCollection().Add(repo), IsNil)
+
+	// broken reference count makes saving fail halfway
+	c.Assert(s.db.Put(append(append([]byte(nil), refCountsPackagePrefix...), s.pkg.Key("")...), []byte{1}), IsNil)
+	factory := NewCollectionFactory(s.db)
+
+	repo, err := factory.LocalRepoCollection().ByName("repo")
+	c.Assert(err, IsNil)
+	c.Assert(factory.LocalRepoCollection().LoadComplete(repo), IsNil)
+	modifiedAt := repo.ModifiedAt
+
+	snapshot, _ := NewSnapshotFromLocalRepo("snap1", repo)
+	c.Check(factory.SnapshotAndClearLocalRepo(snapshot, repo), ErrorMatches, "malformed reference count.*")
+
+	c.Check(repo.NumPackages(), Equals, 1)
+	c.Check(repo.ModifiedAt, Equals, modifiedAt)
+
+	_, err = factory.SnapshotCollection().ByName("snap1")
+	c.Check(err, NotNil)
+	c.Check(s.db.KeysByPrefix([]byte("S")), HasLen, 0)
+
+	repo2 := NewLocalRepo("repo", "")
+	repo2.UUID = repo.UUID
+	c.Assert(factory.LocalRepoCollection().LoadComplete(repo2), IsNil)
+	c.Check(repo2.NumPackages(), Equals, 1)
+}
+
+func (s *RefCountsSuite) TestNewPackageUnreferenced(c *C) {
+	s.rebuild(c)
+
+	// package is imported, but never gets referenced
+	pkg := &Package{Name: "lib", Version: "2.0", Architecture: "i386"}
+	pkg.UpdateFiles(PackageFiles{PackageFile{Filename: "lib_2.0_i386.deb", Checksums: utils.ChecksumInfo{MD5: "5d41402abc4b2a76b9719d911017c592"}}})
+	c.Assert(s.factory.PackageCollection().Update(pkg), IsNil)
+
+	// re-importing same package doesn't reset its count
+	snapshot := NewSnapshotFromRefList("snap1", nil, s.reflist, "")
+	c.Assert(s.factory.SnapshotCollection().Add(snapshot), IsNil)
+	c.Assert(s.factory.PackageCollection().Update(s.pkg), IsNil)
+
+	packages, err := s.factory.RefCounts().UnreferencedPackages()
+	c.Assert(err, IsNil)
+	c.Assert(packages.Len(), Equals, 1)
+
+	// cleanup removes new package, but keeps referenced one
+	c.Assert(packages.ForEach(func(ref []byte) error {
+		err := s.factory.PackageCollection().DeleteByKey(ref)
+		if err != nil {
+			return err
+		}
+		return s.factory.RefCounts().ForgetPackage(ref)
+	}), IsNil)
+
+	_, err = s.factory.PackageCollection().ByKey(pkg.Key(""))
+	c.Check(err, Equals, database.ErrNotFound)
+	_, err = s.factory.PackageCollection().ByKey(s.pkg.Key(""))
+	c.Check(err, IsNil)
+
+	files, err := s.factory.RefCounts().UnreferencedFiles()
+	c.Assert(err, IsNil)
+	c.Check(files, DeepEquals, []UnreferencedFile{{Filename: "lib_2.0_i386.deb", MD5: "5d41402abc4b2a76b9719d911017c592"}})
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/smira/aptly/http"
 	"github.com/smira/aptly/utils"
 	"github.com/ugorji/go/codec"
+	"io/ioutil"
 	"log"
 	"net/url"
 	"os"
@@ -64,6 +65,16 @@ type RemoteRepo struct {
 	Status int
 	// WorkerPID is PID of the process modifying the mirror (if any)
 	WorkerPID int
+	// MissingPackages is number of packages left out on last (partial) update
+	MissingPackages int `codec:",omitempty"`
+	// Labels attached to the mirror
+	Labels Labels `codec:",omitempty" json:",omitempty"`
+	// Date of creation (zero for mirrors created by older versions)
+	CreatedAt time.Time
+	// Date of last modification
+	ModifiedAt time.Time
+	// RetryPolicy overrides retry policy from config for downloads of the mirror
+	RetryPolicy *utils.RetryPolicy `codec:",omitempty" json:",omitempty"`
 	// "Snapshot" of current list of packages
 	packageRefs *PackageRefList
 	// Temporary list of package refs
@@ -72,6 +83,10 @@ type RemoteRepo struct {
 	archiveRootURL *url.URL
 	// Current list of packages (filled while updating mirror)
 	packageList *PackageList
+	// Package keys by destination path of download tasks
+	taskPackages map[string][][]byte
+	// Packages completely downloaded by interrupted update (checkpoint)
+	checkpointRefs *PackageRefList
 }
 
 // NewRemoteRepo creates new instance of Debian remote repository with specified params
@@ -86,6 +101,7 @@ func NewRemoteRepo(name string, archiveRoot string, distribution string, compone
 		Architectures:   architectures,
 		DownloadSources: downloadSources,
 		DownloadUdebs:   downloadUdebs,
+		CreatedAt:       time.Now(),
 	}
 
 	err := result.prepare()
@@ -159,6 +175,12 @@ func (repo *RemoteRepo) RefList() *PackageRefList {
 	return repo.packageRefs
 }
 
+// CheckpointRefList returns list of packages completely downloaded by last
+// (interrupted) update, nil if there is no checkpoint
+func (repo *RemoteRepo) CheckpointRefList() *PackageRefList {
+	return repo.checkpointRefs
+}
+
 // MarkAsUpdating puts current PID and sets status to updating
 func (repo *RemoteRepo) MarkAsUpdating() {
 	repo.Status = MirrorUpdating
@@ -242,18 +264,59 @@ func (repo *RemoteRepo) PackageURL(filename string) *url.URL {
 	return repo.archiveRootURL.ResolveReference(path)
 }
 
+// extractClearsignedUnverified strips clearsign wrapper from InRelease file without
+// verifying the signature, returning temporary file with cleartext
+func extractClearsignedUnverified(clearsigned *os.File) (*os.File, error) {
+	contents, err := ioutil.ReadAll(clearsigned)
+	if err != nil {
+		return nil, err
+	}
+
+	text, _, err := stripClearsign(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := ioutil.TempFile("", "aptly")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(release.Name())
+
+	_, err = release.Write(text)
+	if err == nil {
+		_, err = release.Seek(0, 0)
+	}
+	if err != nil {
+		release.Close()
+		return nil, err
+	}
+
+	return release, nil
+}
+
 // Fetch updates information about repository
 func (repo *RemoteRepo) Fetch(d aptly.Downloader, verifier utils.Verifier) error {
 	var (
 		release, inrelease, releasesig *os.File
-		err                            error
+		err, inreleaseErr              error
 	)
 
 	if verifier == nil {
 		// 0. Just download release file to temporary URL
 		release, err = http.DownloadTemp(d, repo.ReleaseURL("Release").String())
 		if err != nil {
-			return err
+			// some archives publish only InRelease, use it without verification
+			inrelease, inreleaseErr = http.DownloadTemp(d, repo.ReleaseURL("InRelease").String())
+			if inreleaseErr != nil {
+				return err
+			}
+			defer inrelease.Close()
+
+			release, err = extractClearsignedUnverified(inrelease)
+			if err != nil {
+				return err
+			}
 		}
 	} else {
 		// 1. try InRelease file
@@ -263,15 +326,15 @@ func (repo *RemoteRepo) Fetch(d aptly.Downloader, verifier utils.Verifier) error
 		}
 		defer inrelease.Close()
 
-		err = verifier.VerifyClearsigned(inrelease)
-		if err != nil {
+		inreleaseErr = verifier.VerifyClearsigned(inrelease)
+		if inreleaseErr != nil {
 			goto splitsignature
 		}
 
 		inrelease.Seek(0, 0)
 
-		release, err = verifier.ExtractClearsigned(inrelease)
-		if err != nil {
+		release, inreleaseErr = verifier.ExtractClearsigned(inrelease)
+		if inreleaseErr != nil {
 			goto splitsignature
 		}
 
@@ -281,6 +344,10 @@ func (repo *RemoteRepo) Fetch(d aptly.Downloader, verifier utils.Verifier) error
 		// 2. try Release + Release.gpg
 		release, err = http.DownloadTemp(d, repo.ReleaseURL("Release").String())
 		if err != nil {
+			if inreleaseErr != nil {
+				// archive has only InRelease, report why it was rejected
+				return inreleaseErr
+			}
 			return err
 		}
 
@@ -310,11 +377,14 @@ ok:
 	}
 
 	if !repo.IsFlat() {
-		architectures := strings.Split(stanza["Architectures"], " ")
+		architectures := strings.Fields(stanza["Architectures"])
 		sort.Strings(architectures)
 		// "source" architecture is never present, despite Release file claims
 		architectures = utils.StrSlicesSubstract(architectures, []string{"source"})
 		if len(repo.Architectures) == 0 {
+			if len(architectures) == 0 && !repo.DownloadSources {
+				return fmt.Errorf("no architectures listed in Release file of %s, please specify architectures explicitly", repo)
+			}
 			repo.Architectures = architectures
 		} else {
 			err = utils.StringsIsSubset(repo.Architectures, architectures,
@@ -324,7 +394,7 @@ ok:
 			}
 		}
 
-		components := strings.Split(stanza["Components"], " ")
+		components := strings.Fields(stanza["Components"])
 		if strings.Contains(repo.Distribution, "/") {
 			distributionLast := path.Base(repo.Distribution) + "/"
 			for i := range components {
@@ -334,6 +404,9 @@ ok:
 			}
 		}
 		if len(repo.Components) == 0 {
+			if len(components) == 0 {
+				return fmt.Errorf("no components listed in Release file of %s, please specify components explicitly", repo)
+			}
 			repo.Components = components
 		} else if !repo.SkipComponentCheck {
 			err = utils.StringsIsSubset(repo.Components, components,
@@ -506,8 +579,14 @@ func (repo *RemoteRepo) ApplyFilter(dependencyOptions int, filterQuery PackageQu
 func (repo *RemoteRepo) BuildDownloadQueue(packagePool aptly.PackagePool) (queue []PackageDownloadTask, downloadSize int64, err error) {
 	queue = make([]PackageDownloadTask, 0, repo.packageList.Len())
 	seen := make(map[string]struct{}, repo.packageList.Len())
+	repo.taskPackages = make(map[string][][]byte)
 
 	err = repo.packageList.ForEach(func(p *Package) error {
+		if repo.checkpointRefs != nil && repo.checkpointRefs.Has(p) {
+			// files have been downloaded before last checkpoint, no need to verify them again
+			return nil
+		}
+
 		list, err2 := p.DownloadList(packagePool)
 		if err2 != nil {
 			return err2
@@ -515,6 +594,8 @@ func (repo *RemoteRepo) BuildDownloadQueue(packagePool aptly.PackagePool) (queue
 		p.files = nil
 
 		for _, task := range list {
+			repo.taskPackages[task.DestinationPath] = append(repo.taskPackages[task.DestinationPath], p.Key(""))
+
 			key := task.RepoURI + "-" + task.DestinationPath
 			_, found := seen[key]
 			if !found {
@@ -537,10 +618,53 @@ func (repo *RemoteRepo) BuildDownloadQueue(packagePool aptly.PackagePool) (queue
 	return
 }
 
+// ExcludeFailedDownloads drops packages which files failed to download from
+// the list being downloaded, so that mirror is updated partially
+//
+// Excluded packages would be downloaded again on next update. Returns number
+// of excluded packages.
+func (repo *RemoteRepo) ExcludeFailedDownloads(failed []PackageDownloadTask) int {
+	before := repo.tempPackageRefs.Len()
+	repo.tempPackageRefs = repo.tempPackageRefs.Substract(repo.tasksPackageRefs(failed))
+
+	return before - repo.tempPackageRefs.Len()
+}
+
+// Checkpoint records list of packages which files have been completely downloaded
+// so far, pending is list of download tasks which haven't finished successfully yet
+//
+// If update is interrupted, next update skips packages from the checkpoint when
+// building download queue. Checkpoint doesn't change list of packages in the mirror.
+func (repo *RemoteRepo) Checkpoint(pending []PackageDownloadTask) {
+	repo.checkpointRefs = repo.tempPackageRefs.Substract(repo.tasksPackageRefs(pending))
+}
+
+// tasksPackageRefs returns list of packages which files are downloaded by tasks
+func (repo *RemoteRepo) tasksPackageRefs(tasks []PackageDownloadTask) *PackageRefList {
+	result := NewPackageRefList()
+
+	for _, task := range tasks {
+		result.Refs = append(result.Refs, repo.taskPackages[task.DestinationPath]...)
+	}
+
+	sort.Sort(result)
+	return result
+}
+
 // FinalizeDownload swaps for final value of package refs
 func (repo *RemoteRepo) FinalizeDownload() {
 	repo.LastDownloadDate = time.Now()
 	repo.packageRefs = repo.tempPackageRefs
+	repo.MissingPackages = 0
+	repo.taskPackages = nil
+	repo.checkpointRefs = nil
+}
+
+// FinalizePartialDownload swaps for final value of package refs, marking
+// mirror as partially updated with missing packages
+func (repo *RemoteRepo) FinalizePartialDownload(missing int) {
+	repo.FinalizeDownload()
+	repo.MissingPackages = missing
 }
 
 // Encode does msgpack encoding of RemoteRepo
@@ -609,11 +733,17 @@ func (repo *RemoteRepo) RefKey() []byte {
 	return []byte("E" + repo.UUID)
 }
 
+// CheckpointKey is a unique id for package reference list of update checkpoint
+func (repo *RemoteRepo) CheckpointKey() []byte {
+	return []byte("K" + repo.UUID)
+}
+
 // RemoteRepoCollection does listing, updating/adding/deleting of RemoteRepos
 type RemoteRepoCollection struct {
 	*sync.RWMutex
-	db   database.Storage
-	list []*RemoteRepo
+	db        database.Storage
+	refCounts *RefCounts
+	list      []*RemoteRepo
 }
 
 // NewRemoteRepoCollection loads RemoteRepos from DB and makes up collection
@@ -657,11 +787,17 @@ func (collection *RemoteRepoCollection) Add(repo *RemoteRepo) error {
 
 // Update stores updated information about repo in DB
 func (collection *RemoteRepoCollection) Update(repo *RemoteRepo) error {
+	repo.ModifiedAt = time.Now()
+
 	err := collection.db.Put(repo.Key(), repo.Encode())
 	if err != nil {
 		return err
 	}
 	if repo.packageRefs != nil {
+		err = collection.refCounts.Replace(repo.RefKey(), repo.packageRefs)
+		if err != nil {
+			return err
+		}
 		err = collection.db.Put(repo.RefKey(), repo.packageRefs.Encode())
 		if err != nil {
 			return err
@@ -670,9 +806,32 @@ func (collection *RemoteRepoCollection) Update(repo *RemoteRepo) error {
 	return nil
 }
 
+// UpdateCheckpoint stores (or removes, if there's no checkpoint) update checkpoint of repo in DB
+func (collection *RemoteRepoCollection) UpdateCheckpoint(repo *RemoteRepo) error {
+	err := collection.refCounts.Replace(repo.CheckpointKey(), repo.checkpointRefs)
+	if err != nil {
+		return err
+	}
+
+	if repo.checkpointRefs == nil {
+		return collection.db.Delete(repo.CheckpointKey())
+	}
+
+	return collection.db.Put(repo.CheckpointKey(), repo.checkpointRefs.Encode())
+}
+
 // LoadComplete loads additional information for remote repo
 func (collection *RemoteRepoCollection) LoadComplete(repo *RemoteRepo) error {
-	encoded, err := collection.db.Get(repo.RefKey())
+	encoded, err := collection.db.Get(repo.CheckpointKey())
+	if err == nil {
+		repo.checkpointRefs = &PackageRefList{}
+		err = repo.checkpointRefs.Decode(encoded)
+	}
+	if err != nil && err != database.ErrNotFound {
+		return err
+	}
+
+	encoded, err = collection.db.Get(repo.RefKey())
 	if err == database.ErrNotFound {
 		return nil
 	}
@@ -716,6 +875,52 @@ func (collection *RemoteRepoCollection) ForEach(handler func(*RemoteRepo) error)
 	return err
 }
 
+// ForEachSorted runs method for each repository in order specified by sortMethod
+// (name, created or modified)
+func (collection *RemoteRepoCollection) ForEachSorted(sortMethod string, handler func(*RemoteRepo) error) error {
+	method, err := parseSortMethod(sortMethod)
+	if err != nil {
+		return err
+	}
+
+	sorter := &remoteRepoSorter{list: append([]*RemoteRepo(nil), collection.list...), sortMethod: method}
+	sort.Sort(sorter)
+
+	for _, r := range sorter.list {
+		err = handler(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type remoteRepoSorter struct {
+	list       []*RemoteRepo
+	sortMethod int
+}
+
+func (s *remoteRepoSorter) Swap(i, j int) {
+	s.list[i], s.list[j] = s.list[j], s.list[i]
+}
+
+func (s *remoteRepoSorter) Less(i, j int) bool {
+	switch s.sortMethod {
+	case SortName:
+		return s.list[i].Name < s.list[j].Name
+	case SortTime:
+		return s.list[i].CreatedAt.Before(s.list[j].CreatedAt)
+	case SortModified:
+		return s.list[i].ModifiedAt.Before(s.list[j].ModifiedAt)
+	}
+	panic("unknown sort method")
+}
+
+func (s *remoteRepoSorter) Len() int {
+	return len(s.list)
+}
+
 // Len returns number of remote repos
 func (collection *RemoteRepoCollection) Len() int {
 	return len(collection.list)
@@ -744,5 +949,16 @@ func (collection *RemoteRepoCollection) Drop(repo *RemoteRepo) error {
 		return err
 	}
 
+	err = collection.refCounts.Replace(repo.RefKey(), nil)
+	if err != nil {
+		return err
+	}
+
+	repo.checkpointRefs = nil
+	err = collection.UpdateCheckpoint(repo)
+	if err != nil {
+		return err
+	}
+
 	return collection.db.Delete(repo.RefKey())
 }
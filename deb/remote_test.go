@@ -12,6 +12,8 @@ import (
 	"io/ioutil"
 	"os"
 	"sort"
+	"strings"
+	"time"
 
   . "gopkg.in/check.v1"
 )
@@ -30,6 +32,10 @@ func (n *NullVerifier) VerifyDetachedSignature(signature, cleartext io.Reader) e
 	return nil
 }
 
+func (n *NullVerifier) VerifyDetachedSignatureInfo(signature, cleartext io.Reader) (*utils.SignatureInfo, error) {
+	return &utils.SignatureInfo{Valid: true}, nil
+}
+
 func (n *NullVerifier) VerifyClearsigned(clearsigned io.Reader) error {
 	return nil
 }
@@ -43,6 +49,15 @@ func (n *NullVerifier) ExtractClearsigned(clearsigned io.Reader) (text *os.File,
 	return
 }
 
+// failingVerifier rejects all clearsigned files
+type failingVerifier struct {
+	NullVerifier
+}
+
+func (f *failingVerifier) VerifyClearsigned(clearsigned io.Reader) error {
+	return errors.New("verification of clearsigned file failed: exit status 1")
+}
+
 type PackageListMixinSuite struct {
 	p1, p2, p3 *Package
 	list       *PackageList
@@ -215,6 +230,39 @@ func (s *RemoteRepoSuite) TestFetchNullVerifier2(c *C) {
 	c.Assert(downloader.Empty(), Equals, true)
 }
 
+func (s *RemoteRepoSuite) TestFetchInReleaseOnly(c *C) {
+	downloader := http.NewFakeDownloader()
+	downloader.ExpectError("http://mirror.yandex.ru/debian/dists/squeeze/Release", &http.HTTPError{Code: 404})
+	downloader.ExpectResponse("http://mirror.yandex.ru/debian/dists/squeeze/InRelease",
+		"-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA256\n\n"+exampleReleaseFile+
+			"-----BEGIN PGP SIGNATURE-----\n\niEYEARECAAYFAlT\n-----END PGP SIGNATURE-----\n")
+
+	err := s.repo.Fetch(downloader, nil)
+	c.Assert(err, IsNil)
+	c.Assert(s.repo.Architectures, DeepEquals, []string{"amd64", "armel", "armhf", "i386", "powerpc"})
+	c.Assert(s.repo.Components, DeepEquals, []string{"main"})
+	c.Assert(downloader.Empty(), Equals, true)
+	c.Check(s.repo.ReleaseFiles, HasLen, 39)
+}
+
+func (s *RemoteRepoSuite) TestFetchInReleaseOnlyBadSignature(c *C) {
+	downloader := http.NewFakeDownloader()
+	downloader.ExpectResponse("http://mirror.yandex.ru/debian/dists/squeeze/InRelease", exampleReleaseFile)
+	downloader.ExpectError("http://mirror.yandex.ru/debian/dists/squeeze/Release", &http.HTTPError{Code: 404})
+
+	err := s.repo.Fetch(downloader, &failingVerifier{})
+	c.Assert(err, ErrorMatches, "verification of clearsigned file failed.*")
+}
+
+func (s *RemoteRepoSuite) TestFetchNoRelease(c *C) {
+	downloader := http.NewFakeDownloader()
+	downloader.ExpectError("http://mirror.yandex.ru/debian/dists/squeeze/Release", &http.HTTPError{Code: 404})
+	downloader.ExpectError("http://mirror.yandex.ru/debian/dists/squeeze/InRelease", &http.HTTPError{Code: 404})
+
+	err := s.repo.Fetch(downloader, nil)
+	c.Assert(err, ErrorMatches, "HTTP code 404.*")
+}
+
 func (s *RemoteRepoSuite) TestFetchWrongArchitecture(c *C) {
 	s.repo, _ = NewRemoteRepo("s", "http://mirror.yandex.ru/debian/", "squeeze", []string{"main"}, []string{"xyz"}, false, false)
 	err := s.repo.Fetch(s.downloader, nil)
@@ -227,6 +275,45 @@ func (s *RemoteRepoSuite) TestFetchWrongComponent(c *C) {
 	c.Assert(err, ErrorMatches, "component xyz not available in repo.*")
 }
 
+func (s *RemoteRepoSuite) TestFetchDiscoverComponents(c *C) {
+	s.repo, _ = NewRemoteRepo("s", "http://mirror.yandex.ru/debian/", "squeeze", []string{}, []string{"i386"}, false, false)
+	downloader := http.NewFakeDownloader().ExpectResponse("http://mirror.yandex.ru/debian/dists/squeeze/Release",
+		strings.Replace(exampleReleaseFile, "Components: main\n", "Components: main contrib non-free\n", 1))
+
+	err := s.repo.Fetch(downloader, nil)
+	c.Assert(err, IsNil)
+	c.Check(s.repo.Components, DeepEquals, []string{"main", "contrib", "non-free"})
+}
+
+func (s *RemoteRepoSuite) TestFetchNoComponents(c *C) {
+	s.repo, _ = NewRemoteRepo("s", "http://mirror.yandex.ru/debian/", "squeeze", []string{}, []string{"i386"}, false, false)
+	downloader := http.NewFakeDownloader().ExpectResponse("http://mirror.yandex.ru/debian/dists/squeeze/Release",
+		strings.Replace(exampleReleaseFile, "Components: main\n", "Components: \n", 1))
+
+	err := s.repo.Fetch(downloader, nil)
+	c.Assert(err, ErrorMatches, "no components listed in Release file of .*")
+}
+
+func (s *RemoteRepoSuite) TestFetchDiscoverArchitectures(c *C) {
+	s.repo, _ = NewRemoteRepo("s", "http://mirror.yandex.ru/debian/", "squeeze", []string{"main"}, []string{}, true, false)
+	downloader := http.NewFakeDownloader().ExpectResponse("http://mirror.yandex.ru/debian/dists/squeeze/Release",
+		strings.Replace(exampleReleaseFile, "Architectures: amd64 armel armhf i386 powerpc\n", "Architectures: arm64 source amd64\n", 1))
+
+	err := s.repo.Fetch(downloader, nil)
+	c.Assert(err, IsNil)
+	c.Check(s.repo.Architectures, DeepEquals, []string{"amd64", "arm64"})
+	c.Check(s.repo.DownloadSources, Equals, true)
+}
+
+func (s *RemoteRepoSuite) TestFetchNoArchitectures(c *C) {
+	s.repo, _ = NewRemoteRepo("s", "http://mirror.yandex.ru/debian/", "squeeze", []string{"main"}, []string{}, false, false)
+	downloader := http.NewFakeDownloader().ExpectResponse("http://mirror.yandex.ru/debian/dists/squeeze/Release",
+		strings.Replace(exampleReleaseFile, "Architectures: amd64 armel armhf i386 powerpc\n", "Architectures: source\n", 1))
+
+	err := s.repo.Fetch(downloader, nil)
+	c.Assert(err, ErrorMatches, "no architectures listed in Release file of .*")
+}
+
 func (s *RemoteRepoSuite) TestEncodeDecode(c *C) {
 	repo := &RemoteRepo{}
 	err := repo.Decode(s.repo.Encode())
@@ -247,6 +334,12 @@ func (s *RemoteRepoSuite) TestRefKey(c *C) {
 	c.Assert(s.repo.RefKey()[1:], DeepEquals, s.repo.Key()[1:])
 }
 
+func (s *RemoteRepoSuite) TestCheckpointKey(c *C) {
+	c.Assert(len(s.repo.CheckpointKey()), Equals, 37)
+	c.Assert(s.repo.CheckpointKey()[0], Equals, byte('K'))
+	c.Assert(s.repo.CheckpointKey()[1:], DeepEquals, s.repo.Key()[1:])
+}
+
 func (s *RemoteRepoSuite) TestDownload(c *C) {
 	s.repo.Architectures = []string{"i386"}
 
@@ -275,6 +368,183 @@ func (s *RemoteRepoSuite) TestDownload(c *C) {
 	c.Check(pkg.Name, Equals, "amanda-client")
 }
 
+func (s *RemoteRepoSuite) TestDownloadUncompressedOnly(c *C) {
+	s.repo.Architectures = []string{"i386"}
+
+	downloader := http.NewFakeDownloader()
+	downloader.ExpectResponse("http://mirror.yandex.ru/debian/dists/squeeze/Release", uncompressedReleaseFile)
+	err := s.repo.Fetch(downloader, nil)
+	c.Assert(err, IsNil)
+
+	// compressed indexes are not listed in Release, so they're not requested
+	downloader.ExpectResponse("http://mirror.yandex.ru/debian/dists/squeeze/main/binary-i386/Packages", examplePackagesFile)
+
+	err = s.repo.DownloadPackageIndexes(s.progress, downloader, s.collectionFactory, true)
+	c.Assert(err, IsNil)
+	c.Assert(downloader.Empty(), Equals, true)
+
+	queue, _, err := s.repo.BuildDownloadQueue(s.packagePool)
+	c.Assert(err, IsNil)
+	c.Check(queue, HasLen, 1)
+}
+
+func (s *RemoteRepoSuite) TestRefilterOnUpdate(c *C) {
+	s.repo.Architectures = []string{"i386"}
+
+	update := func(filter string) []string {
+		downloader := http.NewFakeDownloader()
+		downloader.ExpectResponse("http://mirror.yandex.ru/debian/dists/squeeze/Release", exampleReleaseFile)
+		err := s.repo.Fetch(downloader, nil)
+		c.Assert(err, IsNil)
+
+		downloader.ExpectError("http://mirror.yandex.ru/debian/dists/squeeze/main/binary-i386/Packages.bz2", &http.HTTPError{Code: 404})
+		downloader.ExpectError("http://mirror.yandex.ru/debian/dists/squeeze/main/binary-i386/Packages.gz", &http.HTTPError{Code: 404})
+		downloader.ExpectResponse("http://mirror.yandex.ru/debian/dists/squeeze/main/binary-i386/Packages", filterPackagesFile)
+
+		err = s.repo.DownloadPackageIndexes(s.progress, downloader, s.collectionFactory, true)
+		c.Assert(err, IsNil)
+
+		s.repo.Filter = filter
+		s.repo.FilterWithDeps = true
+		_, _, err = s.repo.ApplyFilter(0, &FieldQuery{Field: "Name", Relation: VersionPatternMatch, Value: filter})
+		c.Assert(err, IsNil)
+
+		_, _, err = s.repo.BuildDownloadQueue(s.packagePool)
+		c.Assert(err, IsNil)
+		s.repo.FinalizeDownload()
+
+		names := []string{}
+		s.repo.packageRefs.ForEach(func(key []byte) error {
+			pkg, err := s.collectionFactory.PackageCollection().ByKey(key)
+			c.Assert(err, IsNil)
+			names = append(names, pkg.Name)
+			return nil
+		})
+		sort.Strings(names)
+		return names
+	}
+
+	// file-utils pulls in libfile as dependency
+	c.Check(update("file-*"), DeepEquals, []string{"file-tools", "file-utils", "libfile"})
+
+	// narrowed filter drops file-utils and its dependency on next update
+	c.Check(update("file-tools"), DeepEquals, []string{"file-tools"})
+}
+
+func (s *RemoteRepoSuite) TestPartialDownload(c *C) {
+	s.repo.Architectures = []string{"i386"}
+
+	err := s.repo.Fetch(s.downloader, nil)
+	c.Assert(err, IsNil)
+
+	s.downloader.ExpectError("http://mirror.yandex.ru/debian/dists/squeeze/main/binary-i386/Packages.bz2", &http.HTTPError{Code: 404})
+	s.downloader.ExpectError("http://mirror.yandex.ru/debian/dists/squeeze/main/binary-i386/Packages.gz", &http.HTTPError{Code: 404})
+	s.downloader.ExpectResponse("http://mirror.yandex.ru/debian/dists/squeeze/main/binary-i386/Packages", filterPackagesFile)
+
+	err = s.repo.DownloadPackageIndexes(s.progress, s.downloader, s.collectionFactory, true)
+	c.Assert(err, IsNil)
+
+	queue, _, err := s.repo.BuildDownloadQueue(s.packagePool)
+	c.Assert(err, IsNil)
+	c.Assert(queue, HasLen, 3)
+
+	failed := []PackageDownloadTask{}
+	for _, task := range queue {
+		if task.RepoURI == "pool/main/f/file-utils/libfile_1.0_i386.deb" {
+			failed = append(failed, task)
+		}
+	}
+	c.Assert(failed, HasLen, 1)
+
+	c.Check(s.repo.ExcludeFailedDownloads(failed), Equals, 1)
+	s.repo.FinalizePartialDownload(1)
+
+	c.Check(s.repo.MissingPackages, Equals, 1)
+	c.Check(s.repo.packageRefs.Len(), Equals, 2)
+
+	names := []string{}
+	s.repo.packageRefs.ForEach(func(key []byte) error {
+		pkg, err := s.collectionFactory.PackageCollection().ByKey(key)
+		c.Assert(err, IsNil)
+		names = append(names, pkg.Name)
+		return nil
+	})
+	sort.Strings(names)
+	c.Check(names, DeepEquals, []string{"file-tools", "file-utils"})
+
+	repo := &RemoteRepo{}
+	c.Assert(repo.Decode(s.repo.Encode()), IsNil)
+	c.Check(repo.MissingPackages, Equals, 1)
+
+	s.repo.FinalizeDownload()
+	c.Check(s.repo.MissingPackages, Equals, 0)
+}
+
+func (s *RemoteRepoSuite) TestCheckpointResume(c *C) {
+	s.repo.Architectures = []string{"i386"}
+
+	fetch := func(repo *RemoteRepo) []PackageDownloadTask {
+		downloader := http.NewFakeDownloader()
+		downloader.ExpectResponse("http://mirror.yandex.ru/debian/dists/squeeze/Release", exampleReleaseFile)
+		err := repo.Fetch(downloader, nil)
+		c.Assert(err, IsNil)
+
+		downloader.ExpectError("http://mirror.yandex.ru/debian/dists/squeeze/main/binary-i386/Packages.bz2", &http.HTTPError{Code: 404})
+		downloader.ExpectError("http://mirror.yandex.ru/debian/dists/squeeze/main/binary-i386/Packages.gz", &http.HTTPError{Code: 404})
+		downloader.ExpectResponse("http://mirror.yandex.ru/debian/dists/squeeze/main/binary-i386/Packages", filterPackagesFile)
+
+		err = repo.DownloadPackageIndexes(s.progress, downloader, s.collectionFactory, true)
+		c.Assert(err, IsNil)
+
+		queue, _, err := repo.BuildDownloadQueue(s.packagePool)
+		c.Assert(err, IsNil)
+		return queue
+	}
+
+	collection := s.collectionFactory.RemoteRepoCollection()
+
+	queue := fetch(s.repo)
+	c.Assert(queue, HasLen, 3)
+
+	// first batch has landed, last task is still pending
+	pending := []PackageDownloadTask{}
+	for _, task := range queue {
+		if task.RepoURI == "pool/main/f/file-utils/libfile_1.0_i386.deb" {
+			pending = append(pending, task)
+		}
+	}
+	c.Assert(pending, HasLen, 1)
+
+	s.repo.Checkpoint(pending)
+	c.Check(s.repo.CheckpointRefList().Len(), Equals, 2)
+	c.Check(s.repo.RefList(), IsNil)
+	c.Assert(collection.UpdateCheckpoint(s.repo), IsNil)
+
+	// update is interrupted, resume continues from the checkpoint
+	repo := &RemoteRepo{}
+	c.Assert(repo.Decode(s.repo.Encode()), IsNil)
+	c.Assert(collection.LoadComplete(repo), IsNil)
+	c.Assert(repo.CheckpointRefList(), NotNil)
+	c.Check(repo.CheckpointRefList().Len(), Equals, 2)
+
+	queue = fetch(repo)
+	c.Assert(queue, HasLen, 1)
+	c.Check(queue[0].RepoURI, Equals, "pool/main/f/file-utils/libfile_1.0_i386.deb")
+
+	repo.Checkpoint(nil)
+	c.Check(repo.CheckpointRefList().Len(), Equals, 3)
+
+	repo.FinalizeDownload()
+	c.Check(repo.RefList().Len(), Equals, 3)
+	c.Check(repo.CheckpointRefList(), IsNil)
+	c.Assert(collection.UpdateCheckpoint(repo), IsNil)
+
+	repo = &RemoteRepo{}
+	c.Assert(repo.Decode(s.repo.Encode()), IsNil)
+	c.Assert(collection.LoadComplete(repo), IsNil)
+	c.Check(repo.CheckpointRefList(), IsNil)
+}
+
 func (s *RemoteRepoSuite) TestDownloadWithSources(c *C) {
 	s.repo.Architectures = []string{"i386"}
 	s.repo.DownloadSources = true
@@ -486,6 +756,28 @@ func (s *RemoteRepoCollectionSuite) TestForEachAndLen(c *C) {
 	c.Assert(err, Equals, e)
 }
 
+func (s *RemoteRepoCollectionSuite) TestForEachSorted(c *C) {
+	repo1, _ := NewRemoteRepo("b", "http://mirror.yandex.ru/debian/", "squeeze", []string{"main"}, []string{}, false, false)
+	repo2, _ := NewRemoteRepo("a", "http://mirror.yandex.ru/debian/", "wheezy", []string{"main"}, []string{}, false, false)
+	repo1.CreatedAt = time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo2.CreatedAt = time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	c.Assert(s.collection.Add(repo2), IsNil)
+	c.Assert(s.collection.Add(repo1), IsNil)
+
+	names := func(sortMethod string) (result []string) {
+		c.Assert(s.collection.ForEachSorted(sortMethod, func(repo *RemoteRepo) error {
+			result = append(result, repo.Name)
+			return nil
+		}), IsNil)
+		return
+	}
+
+	c.Check(names("name"), DeepEquals, []string{"a", "b"})
+	c.Check(names("created"), DeepEquals, []string{"b", "a"})
+	c.Check(names("modified"), DeepEquals, []string{"a", "b"})
+}
+
 func (s *RemoteRepoCollectionSuite) TestDrop(c *C) {
 	repo1, _ := NewRemoteRepo("yandex", "http://mirror.yandex.ru/debian/", "squeeze", []string{"main"}, []string{}, false, false)
 	s.collection.Add(repo1)
@@ -512,6 +804,16 @@ func (s *RemoteRepoCollectionSuite) TestDrop(c *C) {
 	c.Check(func() { s.collection.Drop(repo1) }, Panics, "repo not found!")
 }
 
+const uncompressedReleaseFile = `Origin: test
+Label: uncompressed
+Suite: squeeze
+Codename: squeeze
+Architectures: i386
+Components: main
+MD5Sum:
+ c8d336856df67d509032bb54145c2f89              826 main/binary-i386/Packages
+`
+
 const exampleReleaseFile = `Origin: LP-PPA-agenda-developers-daily
 Label: Agenda Daily Builds
 Suite: precise
@@ -642,6 +944,29 @@ SHA256:
  d178f1e310218d9f0f16c37d0780637f1cf3640a94a7fb0e24dc940c51b1e115              656 main/source/Sources.bz2
  080228b550da407fb8ac73fb30b37323468fd2b2de98dd56a324ee7d701f6103              592 main/source/Sources.gz`
 
+const filterPackagesFile = `Package: file-utils
+Version: 1.0
+Architecture: i386
+Depends: libfile
+Filename: pool/main/f/file-utils/file-utils_1.0_i386.deb
+Size: 1
+MD5sum: 0cc175b9c0f1b6a831c399e269772661
+
+Package: libfile
+Version: 1.0
+Architecture: i386
+Filename: pool/main/f/file-utils/libfile_1.0_i386.deb
+Size: 1
+MD5sum: 92eb5ffee6ae2fec3ad71c777531578f
+
+Package: file-tools
+Version: 2.0
+Architecture: i386
+Filename: pool/main/f/file-tools/file-tools_2.0_i386.deb
+Size: 1
+MD5sum: 4a8a08f09d37b73795649038408b5f33
+`
+
 const examplePackagesFile = `Package: amanda-client
 Source: amanda
 Version: 1:3.3.1-3~bpo60+1
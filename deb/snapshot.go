@@ -23,12 +23,18 @@ type Snapshot struct {
 	Name string
 	// Date of creation
 	CreatedAt time.Time
+	// Date of last modification of snapshot metadata
+	ModifiedAt time.Time
 
 	// Source: kind + ID
 	SourceKind string   `json:"-"`
 	SourceIDs  []string `json:"-"`
 	// Description of how snapshot was created
 	Description string
+	// Pinned snapshot is protected from being dropped
+	Pinned bool `codec:",omitempty"`
+	// Labels attached to the snapshot
+	Labels Labels `codec:",omitempty" json:",omitempty"`
 
 	packageRefs *PackageRefList
 }
@@ -90,6 +96,34 @@ func NewSnapshotFromRefList(name string, sources []*Snapshot, list *PackageRefLi
 	}
 }
 
+// FilterLatestRefs leaves in snapshot only the latest version of each package
+// (by name and architecture)
+func (s *Snapshot) FilterLatestRefs() {
+	// reflist is shared with the source of snapshot, so it's copied first
+	s.packageRefs = &PackageRefList{Refs: append([][]byte(nil), s.packageRefs.Refs...)}
+	s.packageRefs.FilterLatestRefs()
+}
+
+// DependencyProblems resolves dependencies of packages in the snapshot within the snapshot
+// itself, if architectures are not specified, all architectures of packages are checked
+func (s *Snapshot) DependencyProblems(packageCollection *PackageCollection, options int, architectures []string) ([]PackageDependencyProblems, error) {
+	list, err := NewPackageListFromRefList(s.RefList(), packageCollection, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load packages: %s", err)
+	}
+
+	if len(architectures) == 0 {
+		architectures = list.Architectures(true)
+	}
+
+	problems, err := list.FindDependencyProblems(options, architectures, list, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to verify dependencies: %s", err)
+	}
+
+	return problems, nil
+}
+
 // String returns string representation of snapshot
 func (s *Snapshot) String() string {
 	return fmt.Sprintf("[%s]: %s", s.Name, s.Description)
@@ -163,8 +197,9 @@ func (s *Snapshot) Decode(input []byte) error {
 // SnapshotCollection does listing, updating/adding/deleting of Snapshots
 type SnapshotCollection struct {
 	*sync.RWMutex
-	db   database.Storage
-	list []*Snapshot
+	db        database.Storage
+	refCounts *RefCounts
+	list      []*Snapshot
 }
 
 // NewSnapshotCollection loads Snapshots from DB and makes up collection
@@ -191,13 +226,12 @@ func NewSnapshotCollection(db database.Storage) *SnapshotCollection {
 
 // Add appends new repo to collection and saves it
 func (collection *SnapshotCollection) Add(snapshot *Snapshot) error {
-	for _, s := range collection.list {
-		if s.Name == snapshot.Name {
-			return fmt.Errorf("snapshot with name %s already exists", snapshot.Name)
-		}
+	err := collection.checkUnique(snapshot)
+	if err != nil {
+		return err
 	}
 
-	err := collection.Update(snapshot)
+	err = collection.Update(snapshot)
 	if err != nil {
 		return err
 	}
@@ -206,14 +240,35 @@ func (collection *SnapshotCollection) Add(snapshot *Snapshot) error {
 	return nil
 }
 
+// checkUnique verifies that snapshot name is not used yet
+func (collection *SnapshotCollection) checkUnique(snapshot *Snapshot) error {
+	for _, s := range collection.list {
+		if s.Name == snapshot.Name {
+			return fmt.Errorf("snapshot with name %s already exists", snapshot.Name)
+		}
+	}
+
+	return nil
+}
+
 // Update stores updated information about repo in DB
 func (collection *SnapshotCollection) Update(snapshot *Snapshot) error {
-	err := collection.db.Put(snapshot.Key(), snapshot.Encode())
+	return collection.update(snapshot, collection.db, collection.refCounts)
+}
+
+func (collection *SnapshotCollection) update(snapshot *Snapshot, rw database.ReaderWriter, refCounts *RefCounts) error {
+	snapshot.ModifiedAt = time.Now()
+
+	err := rw.Put(snapshot.Key(), snapshot.Encode())
 	if err != nil {
 		return err
 	}
 	if snapshot.packageRefs != nil {
-		return collection.db.Put(snapshot.RefKey(), snapshot.packageRefs.Encode())
+		err = refCounts.Replace(snapshot.RefKey(), snapshot.packageRefs)
+		if err != nil {
+			return err
+		}
+		return rw.Put(snapshot.RefKey(), snapshot.packageRefs.Encode())
 	}
 	return nil
 }
@@ -342,15 +397,35 @@ func (collection *SnapshotCollection) Drop(snapshot *Snapshot) error {
 		return err
 	}
 
+	err = collection.refCounts.Replace(snapshot.RefKey(), nil)
+	if err != nil {
+		return err
+	}
+
 	return collection.db.Delete(snapshot.RefKey())
 }
 
-// Snapshot sorting methods
+// Collection sorting methods
 const (
 	SortName = iota
 	SortTime
+	SortModified
 )
 
+// parseSortMethod converts name of sorting method to one of Sort* constants
+func parseSortMethod(sortMethod string) (int, error) {
+	switch sortMethod {
+	case "time", "Time", "created", "Created":
+		return SortTime, nil
+	case "modified", "Modified":
+		return SortModified, nil
+	case "name", "Name":
+		return SortName, nil
+	}
+
+	return 0, fmt.Errorf("sorting method \"%s\" unknown", sortMethod)
+}
+
 type snapshotSorter struct {
 	list       []int
 	collection *SnapshotCollection
@@ -358,17 +433,13 @@ type snapshotSorter struct {
 }
 
 func newSnapshotSorter(sortMethod string, collection *SnapshotCollection) (*snapshotSorter, error) {
-	s := &snapshotSorter{collection: collection}
-
-	switch sortMethod {
-	case "time", "Time":
-		s.sortMethod = SortTime
-	case "name", "Name":
-		s.sortMethod = SortName
-	default:
-		return nil, fmt.Errorf("sorting method \"%s\" unknown", sortMethod)
+	method, err := parseSortMethod(sortMethod)
+	if err != nil {
+		return nil, err
 	}
 
+	s := &snapshotSorter{collection: collection, sortMethod: method}
+
 	s.list = make([]int, len(collection.list))
 	for i := range s.list {
 		s.list[i] = i
@@ -389,6 +460,8 @@ func (s *snapshotSorter) Less(i, j int) bool {
 		return s.collection.list[s.list[i]].Name < s.collection.list[s.list[j]].Name
 	case SortTime:
 		return s.collection.list[s.list[i]].CreatedAt.Before(s.collection.list[s.list[j]].CreatedAt)
+	case SortModified:
+		return s.collection.list[s.list[i]].ModifiedAt.Before(s.collection.list[s.list[j]].ModifiedAt)
 	}
 	panic("unknown sort method")
 }
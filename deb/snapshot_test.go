@@ -69,6 +69,24 @@ func (s *SnapshotSuite) TestNewSnapshotFromRefList(c *C) {
 	c.Check(snapshot.SourceIDs, DeepEquals, []string{snap.UUID})
 }
 
+func (s *SnapshotSuite) TestFilterLatestRefs(c *C) {
+	list := NewPackageList()
+	for _, version := range []string{"7.40-2", "7.40-10", "7.40-3"} {
+		stanza := packageStanza.Copy()
+		stanza["Version"] = version
+		c.Assert(list.Add(NewPackageFromControlFile(stanza)), IsNil)
+	}
+	s.repo.packageRefs = NewPackageRefListFromPackageList(list)
+
+	snapshot, _ := NewSnapshotFromRepository("snap1", s.repo)
+	snapshot.FilterLatestRefs()
+	c.Assert(snapshot.RefList().Len(), Equals, 1)
+	c.Check(string(snapshot.RefList().Refs[0]), Matches, "Pi386 alien-arena-common 7.40-10 .*")
+
+	// source of snapshot is not modified
+	c.Check(s.repo.RefList().Len(), Equals, 3)
+}
+
 func (s *SnapshotSuite) TestKey(c *C) {
 	snapshot, _ := NewSnapshotFromRepository("snap1", s.repo)
 	c.Assert(len(snapshot.Key()), Equals, 37)
@@ -90,6 +108,12 @@ func (s *SnapshotSuite) TestEncodeDecode(c *C) {
 	c.Assert(snapshot2.Decode(snapshot.Encode()), IsNil)
 	c.Assert(snapshot2.Name, Equals, snapshot.Name)
 	c.Assert(snapshot2.packageRefs, IsNil)
+	c.Assert(snapshot2.Pinned, Equals, false)
+
+	snapshot.Pinned = true
+	snapshot2 = &Snapshot{}
+	c.Assert(snapshot2.Decode(snapshot.Encode()), IsNil)
+	c.Assert(snapshot2.Pinned, Equals, true)
 }
 
 type SnapshotCollectionSuite struct {
@@ -28,6 +28,31 @@ func CompareVersions(ver1, ver2 string) int {
 	return compareVersionPart(d1, d2)
 }
 
+// CompareVersionsRelation checks whether relation op holds between versions ver1 and ver2
+//
+// Relations are the ones accepted by dpkg --compare-versions: lt, le, eq, ne, ge, gt
+// (or <<, <=, =, !=, >=, >>); obsolete < and > are treated as <= and >=
+func CompareVersionsRelation(ver1, op, ver2 string) (bool, error) {
+	r := CompareVersions(ver1, ver2)
+
+	switch op {
+	case "lt", "<<":
+		return r < 0, nil
+	case "le", "<=", "<":
+		return r <= 0, nil
+	case "eq", "=":
+		return r == 0, nil
+	case "ne", "!=":
+		return r != 0, nil
+	case "ge", ">=", ">":
+		return r >= 0, nil
+	case "gt", ">>":
+		return r > 0, nil
+	}
+
+	return false, fmt.Errorf("unknown version relation: %s", op)
+}
+
 // parseVersions breaks down full version to components (possibly empty)
 func parseVersion(ver string) (epoch, upstream, debian string) {
 	i := strings.LastIndex(ver, "-")
@@ -190,10 +215,15 @@ type Dependency struct {
 	Version      string
 	Architecture string
 	Regexp       *regexp.Regexp
+	// Udeb is set for dependencies of .udeb packages, which could be satisfied only by .udebs
+	Udeb bool
 }
 
 // Hash calculates some predefined unique ID of Dependency
 func (d *Dependency) Hash() string {
+	if d.Udeb {
+		return fmt.Sprintf("%s:%s:%d:%s:udeb", d.Architecture, d.Pkg, d.Relation, d.Version)
+	}
 	return fmt.Sprintf("%s:%s:%d:%s", d.Architecture, d.Pkg, d.Relation, d.Version)
 }
 
@@ -98,6 +98,78 @@ func (s *VersionSuite) TestCompareVersions(c *C) {
 	c.Check(CompareVersions("1.0~beta1", "1.0"), Equals, -1)
 }
 
+func (s *VersionSuite) TestCompareVersionsDpkg(c *C) {
+	// vectors from dpkg test suite
+	for _, t := range []struct {
+		ver1, ver2 string
+		result     int
+	}{
+		{"1.0", "1.0", 0},
+		{"0:1.0", "1.0", 0},
+		{"1.0-0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.2.3", "1.2.10", -1},
+		{"1.0", "1.0.0", -1},
+		{"1:1.0", "2.0", 1},
+		{"1:1.0", "0:9.9", 1},
+		{"10:1.0", "9:1.0", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0~~a", "1.0~", -1},
+		{"1.0~", "1.0", -1},
+		{"1.0", "1.0a", -1},
+		{"1.0a", "1.0+", -1},
+		{"1.0a", "1.0b", -1},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0-1", "1.0-1ubuntu1", -1},
+		{"1.0-1~bpo1", "1.0-1", -1},
+		{"7.6p2-4", "7.6-0", 1},
+		{"1.0-1.1", "1.0-1", 1},
+		{"2.0-1", "1.9-10", 1},
+		{"1.0+dfsg-1", "1.0-1", 1},
+	} {
+		c.Check(CompareVersions(t.ver1, t.ver2), Equals, t.result, Commentf("%s vs %s", t.ver1, t.ver2))
+		c.Check(CompareVersions(t.ver2, t.ver1), Equals, -t.result, Commentf("%s vs %s", t.ver2, t.ver1))
+	}
+}
+
+func (s *VersionSuite) TestCompareVersionsRelation(c *C) {
+	for _, t := range []struct {
+		op                string
+		less, equal, more bool
+	}{
+		{"lt", true, false, false},
+		{"<<", true, false, false},
+		{"le", true, true, false},
+		{"<=", true, true, false},
+		{"<", true, true, false},
+		{"eq", false, true, false},
+		{"=", false, true, false},
+		{"ne", true, false, true},
+		{"!=", true, false, true},
+		{"ge", false, true, true},
+		{">=", false, true, true},
+		{">", false, true, true},
+		{"gt", false, false, true},
+		{">>", false, false, true},
+	} {
+		r, err := CompareVersionsRelation("1.0~rc1", t.op, "1.0")
+		c.Check(err, IsNil)
+		c.Check(r, Equals, t.less, Commentf("op %s", t.op))
+
+		r, err = CompareVersionsRelation("0:1.0", t.op, "1.0")
+		c.Check(err, IsNil)
+		c.Check(r, Equals, t.equal, Commentf("op %s", t.op))
+
+		r, err = CompareVersionsRelation("1:0.1", t.op, "1.0")
+		c.Check(err, IsNil)
+		c.Check(r, Equals, t.more, Commentf("op %s", t.op))
+	}
+
+	_, err := CompareVersionsRelation("1.0", "lt-nl", "1.1")
+	c.Check(err, ErrorMatches, "unknown version relation: lt-nl")
+}
+
 func (s *VersionSuite) TestParseDependency(c *C) {
 	d, e := ParseDependency("dpkg (>= 1.6)")
 	c.Check(e, IsNil)
@@ -0,0 +1,72 @@
+package files
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes contents of source to destination
+//
+// Contents are written to temporary file in the same directory, which is renamed
+// to destination only when expected number of bytes (if expectedSize != -1) with expected
+// MD5 checksum (if expectedMD5 != "") has been written and synced to disk, so destination
+// never contains partial or corrupted file, even if process is killed while copying.
+func writeFileAtomic(destination string, source io.Reader, expectedSize int64, expectedMD5 string) error {
+	temp, err := ioutil.TempFile(filepath.Dir(destination), "."+filepath.Base(destination)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	hash := md5.New()
+	written, err := io.Copy(io.MultiWriter(temp, hash), source)
+	if err == nil && expectedSize != -1 && written != expectedSize {
+		err = fmt.Errorf("unable to write %s: size mismatch %d != %d", destination, written, expectedSize)
+	}
+	if err == nil && expectedMD5 != "" {
+		if actualMD5 := fmt.Sprintf("%x", hash.Sum(nil)); actualMD5 != expectedMD5 {
+			err = fmt.Errorf("unable to write %s: MD5 mismatch %s != %s", destination, actualMD5, expectedMD5)
+		}
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if err == nil {
+		// temporary files are created private
+		err = temp.Chmod(0644)
+	}
+	if err == nil {
+		err = temp.Close()
+	} else {
+		temp.Close()
+	}
+
+	if err == nil {
+		err = os.Rename(temp.Name(), destination)
+	}
+
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+
+	return err
+}
+
+// copyFileAtomic copies file at sourcePath to destination using writeFileAtomic
+func copyFileAtomic(destination string, sourcePath string, expectedMD5 string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(destination, source, info.Size(), expectedMD5)
+}
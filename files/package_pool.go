@@ -3,10 +3,11 @@ package files
 import (
 	"fmt"
 	"github.com/smira/aptly/aptly"
-	"io"
+	"github.com/smira/aptly/utils"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // PackagePool is deduplicated storage of package files on filesystem
@@ -104,7 +105,51 @@ func (pool *PackagePool) Remove(path string) (size int64, err error) {
 	return info.Size(), err
 }
 
+// findIdentical looks for file with the same contents as source in pool directory
+//
+// Pool directories are named after MD5 prefix, so files with the same contents always
+// end up in the same directory. Candidates are compared by size and then by SHA256.
+func (pool *PackagePool) findIdentical(dir string, sourcePath string, sourceInfo os.FileInfo) (string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var sourceChecksums *utils.ChecksumInfo
+
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Size() != sourceInfo.Size() || strings.HasPrefix(entry.Name(), ".") {
+			// skip temporary files of interrupted imports
+			continue
+		}
+
+		if sourceChecksums == nil {
+			checksums, err := utils.ChecksumsForFile(sourcePath)
+			if err != nil {
+				return "", err
+			}
+			sourceChecksums = &checksums
+		}
+
+		candidate := filepath.Join(dir, entry.Name())
+		checksums, err := utils.ChecksumsForFile(candidate)
+		if err != nil {
+			return "", err
+		}
+
+		if checksums.SHA256 == sourceChecksums.SHA256 {
+			return candidate, nil
+		}
+	}
+
+	return "", nil
+}
+
 // Import copies file into package pool
+//
+// If file with identical contents is already in the pool, it is hardlinked instead of copying.
+// Copy is written to temporary file and renamed into place once complete, so pool never
+// contains partial files.
 func (pool *PackagePool) Import(path string, hashMD5 string) error {
 	source, err := os.Open(path)
 	if err != nil {
@@ -145,13 +190,44 @@ func (pool *PackagePool) Import(path string, hashMD5 string) error {
 		return err
 	}
 
-	target, err := os.Create(poolPath)
+	// file with the same contents might be already in the pool under another name
+	identical, err := pool.findIdentical(filepath.Dir(poolPath), path, sourceInfo)
+	if err != nil {
+		return err
+	}
+	if identical != "" && os.Link(identical, poolPath) == nil {
+		return nil
+	}
+
+	return writeFileAtomic(poolPath, source, sourceInfo.Size(), hashMD5)
+}
+
+// Link hardlinks file into package pool
+//
+// If hardlinking is not possible (e.g. pool is on another filesystem), file is copied
+func (pool *PackagePool) Link(path string, hashMD5 string) error {
+	poolPath, err := pool.Path(path, hashMD5)
 	if err != nil {
 		return err
 	}
-	defer target.Close()
 
-	_, err = io.Copy(target, source)
+	_, err = os.Stat(poolPath)
+	if err == nil {
+		// file is already in the pool, let Import check that it is the same
+		return pool.Import(path, hashMD5)
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(poolPath), 0755)
+	if err != nil {
+		return err
+	}
+
+	if os.Link(path, poolPath) == nil {
+		return nil
+	}
 
-	return err
+	return pool.Import(path, hashMD5)
 }
@@ -1,6 +1,8 @@
 package files
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -91,20 +93,20 @@ func (s *PackagePoolSuite) TestImportOk(c *C) {
 	_, _File, _, _ := runtime.Caller(0)
 	debFile := filepath.Join(filepath.Dir(_File), "../system/files/libboost-program-options-dev_1.49.0.1_i386.deb")
 
-	err := s.pool.Import(debFile, "91b1a1480b90b9e269ca44d897b12575")
+	err := s.pool.Import(debFile, "0035d7822b2f8f0ec4013f270fd650c2")
 	c.Check(err, IsNil)
 
-	info, err := os.Stat(filepath.Join(s.pool.rootPath, "91", "b1", "libboost-program-options-dev_1.49.0.1_i386.deb"))
+	info, err := os.Stat(filepath.Join(s.pool.rootPath, "00", "35", "libboost-program-options-dev_1.49.0.1_i386.deb"))
 	c.Check(err, IsNil)
 	c.Check(info.Size(), Equals, int64(2738))
 
 	// double import, should be ok
-	err = s.pool.Import(debFile, "91b1a1480b90b9e269ca44d897b12575")
+	err = s.pool.Import(debFile, "0035d7822b2f8f0ec4013f270fd650c2")
 	c.Check(err, IsNil)
 }
 
 func (s *PackagePoolSuite) TestImportNotExist(c *C) {
-	err := s.pool.Import("no-such-file", "91b1a1480b90b9e269ca44d897b12575")
+	err := s.pool.Import("no-such-file", "0035d7822b2f8f0ec4013f270fd650c2")
 	c.Check(err, ErrorMatches, ".*no such file or directory")
 }
 
@@ -112,9 +114,118 @@ func (s *PackagePoolSuite) TestImportOverwrite(c *C) {
 	_, _File, _, _ := runtime.Caller(0)
 	debFile := filepath.Join(filepath.Dir(_File), "../system/files/libboost-program-options-dev_1.49.0.1_i386.deb")
 
-	os.MkdirAll(filepath.Join(s.pool.rootPath, "91", "b1"), 0755)
-	ioutil.WriteFile(filepath.Join(s.pool.rootPath, "91", "b1", "libboost-program-options-dev_1.49.0.1_i386.deb"), []byte("1"), 0644)
+	os.MkdirAll(filepath.Join(s.pool.rootPath, "00", "35"), 0755)
+	ioutil.WriteFile(filepath.Join(s.pool.rootPath, "00", "35", "libboost-program-options-dev_1.49.0.1_i386.deb"), []byte("1"), 0644)
 
-	err := s.pool.Import(debFile, "91b1a1480b90b9e269ca44d897b12575")
+	err := s.pool.Import(debFile, "0035d7822b2f8f0ec4013f270fd650c2")
 	c.Check(err, ErrorMatches, "unable to import into pool.*")
 }
+
+func (s *PackagePoolSuite) TestImportDeduplicate(c *C) {
+	_, _File, _, _ := runtime.Caller(0)
+	debFile := filepath.Join(filepath.Dir(_File), "../system/files/libboost-program-options-dev_1.49.0.1_i386.deb")
+
+	// same file under another name, e.g. coming from another mirror
+	dir := c.MkDir()
+	contents, _ := ioutil.ReadFile(debFile)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "libboost-program-options_1.49.0.1_i386.deb"), contents, 0644), IsNil)
+	// same size, different contents
+	contents[100]++
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "other_1.0_i386.deb"), contents, 0644), IsNil)
+
+	c.Assert(s.pool.Import(debFile, "0035d7822b2f8f0ec4013f270fd650c2"), IsNil)
+	c.Assert(s.pool.Import(filepath.Join(dir, "libboost-program-options_1.49.0.1_i386.deb"), "0035d7822b2f8f0ec4013f270fd650c2"), IsNil)
+	// file with the same size, but different contents doesn't match MD5
+	c.Check(s.pool.Import(filepath.Join(dir, "other_1.0_i386.deb"), "0035d7822b2f8f0ec4013f270fd650c2"), ErrorMatches, ".*MD5 mismatch.*")
+
+	info1, err := os.Stat(filepath.Join(s.pool.rootPath, "00", "35", "libboost-program-options-dev_1.49.0.1_i386.deb"))
+	c.Assert(err, IsNil)
+	info2, err := os.Stat(filepath.Join(s.pool.rootPath, "00", "35", "libboost-program-options_1.49.0.1_i386.deb"))
+	c.Assert(err, IsNil)
+	_, err = os.Stat(filepath.Join(s.pool.rootPath, "00", "35", "other_1.0_i386.deb"))
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	c.Check(os.SameFile(info1, info2), Equals, true)
+}
+
+func (s *PackagePoolSuite) TestLink(c *C) {
+	_, _File, _, _ := runtime.Caller(0)
+	debFile := filepath.Join(filepath.Dir(_File), "../system/files/libboost-program-options-dev_1.49.0.1_i386.deb")
+
+	dir := c.MkDir()
+	contents, _ := ioutil.ReadFile(debFile)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "libboost-program-options-dev_1.49.0.1_i386.deb"), contents, 0644), IsNil)
+
+	c.Assert(s.pool.Link(filepath.Join(dir, "libboost-program-options-dev_1.49.0.1_i386.deb"), "0035d7822b2f8f0ec4013f270fd650c2"), IsNil)
+	// second time it's a no-op
+	c.Assert(s.pool.Link(filepath.Join(dir, "libboost-program-options-dev_1.49.0.1_i386.deb"), "0035d7822b2f8f0ec4013f270fd650c2"), IsNil)
+
+	info1, err := os.Stat(filepath.Join(dir, "libboost-program-options-dev_1.49.0.1_i386.deb"))
+	c.Assert(err, IsNil)
+	info2, err := os.Stat(filepath.Join(s.pool.rootPath, "00", "35", "libboost-program-options-dev_1.49.0.1_i386.deb"))
+	c.Assert(err, IsNil)
+
+	c.Check(os.SameFile(info1, info2), Equals, true)
+}
+
+type failingReader struct {
+	remaining int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, fmt.Errorf("killed")
+	}
+	if len(p) > r.remaining {
+		p = p[:r.remaining]
+	}
+	for i := range p {
+		p[i] = 'x'
+	}
+	r.remaining -= len(p)
+	return len(p), nil
+}
+
+func (s *PackagePoolSuite) TestImportInterrupted(c *C) {
+	_, _File, _, _ := runtime.Caller(0)
+	debFile := filepath.Join(filepath.Dir(_File), "../system/files/libboost-program-options-dev_1.49.0.1_i386.deb")
+	poolPath := filepath.Join(s.pool.rootPath, "00", "35", "libboost-program-options-dev_1.49.0.1_i386.deb")
+
+	c.Assert(os.MkdirAll(filepath.Dir(poolPath), 0755), IsNil)
+
+	// copy is interrupted half-way, nothing appears at final location
+	err := writeFileAtomic(poolPath, &failingReader{remaining: 1000}, 2738, "")
+	c.Check(err, ErrorMatches, "killed")
+	_, err = os.Stat(poolPath)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	entries, err := ioutil.ReadDir(filepath.Dir(poolPath))
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 0)
+
+	// short copy isn't renamed into place either
+	err = writeFileAtomic(poolPath, bytes.NewReader(make([]byte, 1000)), 2738, "")
+	c.Check(err, ErrorMatches, ".*size mismatch 1000 != 2738")
+	_, err = os.Stat(poolPath)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	// right size, but wrong contents
+	err = writeFileAtomic(poolPath, bytes.NewReader(make([]byte, 2738)), 2738, "0035d7822b2f8f0ec4013f270fd650c2")
+	c.Check(err, ErrorMatches, ".*MD5 mismatch [0-9a-f]+ != 0035d7822b2f8f0ec4013f270fd650c2")
+	_, err = os.Stat(poolPath)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	// process was killed, leaving temporary file behind
+	c.Assert(ioutil.WriteFile(filepath.Join(filepath.Dir(poolPath), ".libboost-program-options-dev_1.49.0.1_i386.deb.tmp123"), make([]byte, 2738), 0600), IsNil)
+
+	c.Assert(s.pool.Import(debFile, "0035d7822b2f8f0ec4013f270fd650c2"), IsNil)
+
+	contents, err := ioutil.ReadFile(poolPath)
+	c.Assert(err, IsNil)
+	expected, _ := ioutil.ReadFile(debFile)
+	c.Check(contents, DeepEquals, expected)
+
+	info, err := os.Stat(poolPath)
+	c.Assert(err, IsNil)
+	c.Check(info.Mode().Perm(), Equals, os.FileMode(0644))
+}
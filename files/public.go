@@ -3,7 +3,6 @@ package files
 import (
 	"fmt"
 	"github.com/smira/aptly/aptly"
-	"io"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -11,13 +10,15 @@ import (
 
 // PublishedStorage abstract file system with public dirs (published repos)
 type PublishedStorage struct {
-	rootPath string
+	rootPath  string
+	copyFiles bool
 }
 
 // Check interfaces
 var (
-	_ aptly.PublishedStorage      = (*PublishedStorage)(nil)
-	_ aptly.LocalPublishedStorage = (*PublishedStorage)(nil)
+	_ aptly.PublishedStorage                 = (*PublishedStorage)(nil)
+	_ aptly.LocalPublishedStorage            = (*PublishedStorage)(nil)
+	_ aptly.NotExistCheckingPublishedStorage = (*PublishedStorage)(nil)
 )
 
 // NewPublishedStorage creates new instance of PublishedStorage which specified root
@@ -25,6 +26,13 @@ func NewPublishedStorage(root string) *PublishedStorage {
 	return &PublishedStorage{rootPath: filepath.Join(root, "public")}
 }
 
+// NewExportStorage creates new instance of PublishedStorage which publishes directly
+// into root, copying package files instead of hardlinking them, so that result
+// is independent of the package pool
+func NewExportStorage(root string) *PublishedStorage {
+	return &PublishedStorage{rootPath: root, copyFiles: true}
+}
+
 // PublicPath returns root of public part
 func (storage *PublishedStorage) PublicPath() string {
 	return storage.rootPath
@@ -36,25 +44,10 @@ func (storage *PublishedStorage) MkDir(path string) error {
 }
 
 // PutFile puts file into published storage at specified path
+//
+// File is written to temporary file and renamed into place once complete
 func (storage *PublishedStorage) PutFile(path string, sourceFilename string) error {
-	var (
-		source, f *os.File
-		err       error
-	)
-	source, err = os.Open(sourceFilename)
-	if err != nil {
-		return err
-	}
-	defer source.Close()
-
-	f, err = os.Create(filepath.Join(storage.rootPath, path))
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	_, err = io.Copy(f, source)
-	return err
+	return copyFileAtomic(filepath.Join(storage.rootPath, path), sourceFilename, "")
 }
 
 // Remove removes single file under public path
@@ -63,6 +56,11 @@ func (storage *PublishedStorage) Remove(path string) error {
 	return os.Remove(filepath)
 }
 
+// IsNotExist checks whether error returned by storage reports missing file
+func (storage *PublishedStorage) IsNotExist(err error) bool {
+	return os.IsNotExist(err)
+}
+
 // RemoveDirs removes directory structure under public path
 func (storage *PublishedStorage) RemoveDirs(path string, progress aptly.Progress) error {
 	filepath := filepath.Join(storage.rootPath, path)
@@ -103,6 +101,11 @@ func (storage *PublishedStorage) LinkFromPool(publishedDirectory string, sourceP
 			return err
 		}
 
+		// copied earlier
+		if storage.copyFiles && srcStat.Size() == dstStat.Size() {
+			return nil
+		}
+
 		srcSys := srcStat.Sys().(*syscall.Stat_t)
 		dstSys := dstStat.Sys().(*syscall.Stat_t)
 
@@ -123,6 +126,10 @@ func (storage *PublishedStorage) LinkFromPool(publishedDirectory string, sourceP
 		}
 	}
 
+	if storage.copyFiles {
+		return copyFileAtomic(filepath.Join(poolPath, baseName), sourcePath, sourceMD5)
+	}
+
 	// destination doesn't exist (or forced), create link
 	return os.Link(sourcePath, filepath.Join(poolPath, baseName))
 }
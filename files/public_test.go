@@ -191,3 +191,31 @@ func (s *PublishedStorageSuite) TestLinkFromPool(c *C) {
 	info = st.Sys().(*syscall.Stat_t)
 	c.Check(int(info.Nlink), Equals, 2)
 }
+
+func (s *PublishedStorageSuite) TestExportStorage(c *C) {
+	root := c.MkDir()
+	storage := NewExportStorage(root)
+	c.Check(storage.PublicPath(), Equals, root)
+
+	pool := NewPackagePool(s.root)
+	sourcePath := filepath.Join(s.root, "pool/01/ae/mars-invaders_1.03.deb")
+	c.Assert(os.MkdirAll(filepath.Dir(sourcePath), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(sourcePath, []byte("Contents"), 0644), IsNil)
+
+	c.Assert(storage.LinkFromPool("pool/main/m/mars-invaders", pool, sourcePath, "", false), IsNil)
+	// second time is no-op
+	c.Assert(storage.LinkFromPool("pool/main/m/mars-invaders", pool, sourcePath, "", false), IsNil)
+
+	st, err := os.Stat(filepath.Join(root, "pool/main/m/mars-invaders/mars-invaders_1.03.deb"))
+	c.Assert(err, IsNil)
+
+	// file is copied, not linked
+	info := st.Sys().(*syscall.Stat_t)
+	c.Check(int(info.Nlink), Equals, 1)
+
+	// copy is verified against MD5
+	c.Check(storage.LinkFromPool("pool/main/m/mars-invaders-bad", pool, sourcePath, "00000000000000000000000000000000", false),
+		ErrorMatches, ".*MD5 mismatch.*")
+	_, err = os.Stat(filepath.Join(root, "pool/main/m/mars-invaders-bad/mars-invaders_1.03.deb"))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/smira/aptly/aptly"
 	"github.com/smira/aptly/utils"
 	"github.com/smira/go-ftp-protocol/protocol"
+	"golang.org/x/net/context"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -34,6 +35,7 @@ var (
 
 // downloaderImpl is implementation of Downloader interface
 type downloaderImpl struct {
+	ctx       context.Context
 	queue     chan *downloadTask
 	stop      chan struct{}
 	stopped   chan struct{}
@@ -42,7 +44,17 @@ type downloaderImpl struct {
 	progress  aptly.Progress
 	aggWriter io.Writer
 	threads   int
+	tempDir   string
 	client    *http.Client
+
+	// retry policy for tasks queued directly (not via WithRetryPolicy)
+	retryPolicy utils.RetryPolicy
+}
+
+// policyDownloader queues tasks to shared downloader with specific retry policy
+type policyDownloader struct {
+	*downloaderImpl
+	retryPolicy utils.RetryPolicy
 }
 
 // downloadTask represents single item in queue
@@ -52,22 +64,36 @@ type downloadTask struct {
 	result         chan<- error
 	expected       utils.ChecksumInfo
 	ignoreMismatch bool
+	retryPolicy    utils.RetryPolicy
+}
+
+// temporaryError is download error which might go away when download is retried
+type temporaryError struct {
+	error
 }
 
 // NewDownloader creates new instance of Downloader which specified number
 // of threads and download limit in bytes/sec
-func NewDownloader(threads int, downLimit int64, progress aptly.Progress) aptly.Downloader {
+//
+// In-progress downloads are stored in tempDir and moved to destination
+// once complete, if tempDir is empty they're stored next to destination.
+//
+// When ctx is cancelled, in-progress downloads are aborted and queued
+// downloads fail immediately.
+func NewDownloader(ctx context.Context, threads int, downLimit int64, tempDir string, progress aptly.Progress) aptly.Downloader {
 	transport := *http.DefaultTransport.(*http.Transport)
 	transport.DisableCompression = true
 	transport.RegisterProtocol("ftp", &protocol.FTPRoundTripper{})
 
 	downloader := &downloaderImpl{
+		ctx:      ctx,
 		queue:    make(chan *downloadTask, 1000),
 		stop:     make(chan struct{}, threads),
 		stopped:  make(chan struct{}, threads),
 		pause:    make(chan struct{}),
 		unpause:  make(chan struct{}),
 		threads:  threads,
+		tempDir:  tempDir,
 		progress: progress,
 		client: &http.Client{
 			Transport: &transport,
@@ -125,6 +151,17 @@ func (downloader *downloaderImpl) GetProgress() aptly.Progress {
 	return downloader.progress
 }
 
+// GetTempDir returns directory for temporary files ("" means system default)
+func (downloader *downloaderImpl) GetTempDir() string {
+	return downloader.tempDir
+}
+
+// WithRetryPolicy returns downloader sharing download threads with this one,
+// but retrying failed downloads according to policy
+func (downloader *downloaderImpl) WithRetryPolicy(policy utils.RetryPolicy) aptly.Downloader {
+	return &policyDownloader{downloaderImpl: downloader, retryPolicy: policy}
+}
+
 // Download starts new download task
 func (downloader *downloaderImpl) Download(url string, destination string, result chan<- error) {
 	downloader.DownloadWithChecksum(url, destination, result, utils.ChecksumInfo{Size: -1}, false)
@@ -133,17 +170,70 @@ func (downloader *downloaderImpl) Download(url string, destination string, resul
 // DownloadWithChecksum starts new download task with checksum verification
 func (downloader *downloaderImpl) DownloadWithChecksum(url string, destination string, result chan<- error,
 	expected utils.ChecksumInfo, ignoreMismatch bool) {
-	downloader.queue <- &downloadTask{url: url, destination: destination, result: result, expected: expected, ignoreMismatch: ignoreMismatch}
+	downloader.queue <- &downloadTask{url: url, destination: destination, result: result, expected: expected,
+		ignoreMismatch: ignoreMismatch, retryPolicy: downloader.retryPolicy}
+}
+
+// Download starts new download task
+func (downloader *policyDownloader) Download(url string, destination string, result chan<- error) {
+	downloader.DownloadWithChecksum(url, destination, result, utils.ChecksumInfo{Size: -1}, false)
+}
+
+// DownloadWithChecksum starts new download task with checksum verification
+func (downloader *policyDownloader) DownloadWithChecksum(url string, destination string, result chan<- error,
+	expected utils.ChecksumInfo, ignoreMismatch bool) {
+	downloader.queue <- &downloadTask{url: url, destination: destination, result: result, expected: expected,
+		ignoreMismatch: ignoreMismatch, retryPolicy: downloader.retryPolicy}
+}
+
+// taskError builds error for the task, reporting cancellation if downloader was cancelled
+func (downloader *downloaderImpl) taskError(task *downloadTask, err error) error {
+	if downloader.ctx.Err() != nil {
+		return fmt.Errorf("%s: download cancelled: %s", task.url, downloader.ctx.Err())
+	}
+	return fmt.Errorf("%s: %s", task.url, err)
 }
 
-// handleTask processes single download task
+// handleTask processes single download task, retrying it according to task retry policy
 func (downloader *downloaderImpl) handleTask(task *downloadTask) {
+	err := task.retryPolicy.Do(downloader.ctx.Done(), func() error {
+		return downloader.download(task)
+	}, func(err error) bool {
+		if downloader.ctx.Err() != nil {
+			return false
+		}
+
+		switch e := err.(type) {
+		case *HTTPError:
+			if task.retryPolicy.IsRetryableStatus(e.Code) {
+				downloader.progress.Printf("Download failed, retrying: %s\n", err)
+				return true
+			}
+		case *temporaryError:
+			downloader.progress.Printf("Download failed, retrying: %s\n", err)
+			return true
+		}
+		return false
+	})
+
+	if e, ok := err.(*temporaryError); ok {
+		err = e.error
+	}
+
+	task.result <- err
+}
+
+// download makes single attempt to download file for the task
+func (downloader *downloaderImpl) download(task *downloadTask) error {
+	if downloader.ctx.Err() != nil {
+		return downloader.taskError(task, nil)
+	}
+
 	downloader.progress.Printf("Downloading %s...\n", task.url)
 
 	req, err := http.NewRequest("GET", task.url, nil)
 	if err != nil {
-		task.result <- fmt.Errorf("%s: %s", task.url, err)
-		return
+		return fmt.Errorf("%s: %s", task.url, err)
 	}
 
 	proxyURL, _ := downloader.client.Transport.(*http.Transport).Proxy(req)
@@ -152,35 +242,60 @@ func (downloader *downloaderImpl) handleTask(task *downloadTask) {
 		req.URL.RawQuery = ""
 	}
 
+	// abort request (including reading of response body) on cancellation
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-downloader.ctx.Done():
+			downloader.client.Transport.(*http.Transport).CancelRequest(req)
+		case <-finished:
+		}
+	}()
+
 	resp, err := downloader.client.Do(req)
 	if err != nil {
-		task.result <- fmt.Errorf("%s: %s", task.url, err)
-		return
+		return &temporaryError{downloader.taskError(task, err)}
 	}
 	if resp.Body != nil {
 		defer resp.Body.Close()
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		task.result <- &HTTPError{Code: resp.StatusCode, URL: task.url}
-		return
+		return &HTTPError{Code: resp.StatusCode, URL: task.url}
+	}
+
+	// reject file of wrong size before downloading it, if server advertises size
+	if task.expected.Size != -1 && resp.ContentLength != -1 && resp.ContentLength != task.expected.Size && !task.ignoreMismatch {
+		return fmt.Errorf("%s: size check mismatch %d != %d", task.url, resp.ContentLength, task.expected.Size)
 	}
 
 	err = os.MkdirAll(filepath.Dir(task.destination), 0755)
 	if err != nil {
-		task.result <- fmt.Errorf("%s: %s", task.url, err)
-		return
+		return fmt.Errorf("%s: %s", task.url, err)
 	}
 
-	temppath := task.destination + ".down"
-
-	outfile, err := os.Create(temppath)
+	var outfile *os.File
+	if downloader.tempDir != "" {
+		outfile, err = ioutil.TempFile(downloader.tempDir, "aptly-download")
+		if err == nil {
+			// temporary files are created private, but downloaded files end up in the pool
+			err = outfile.Chmod(0644)
+			if err != nil {
+				outfile.Close()
+				os.Remove(outfile.Name())
+			}
+		}
+	} else {
+		outfile, err = os.Create(task.destination + ".down")
+	}
 	if err != nil {
-		task.result <- fmt.Errorf("%s: %s", task.url, err)
-		return
+		return fmt.Errorf("%s: %s", task.url, err)
 	}
 	defer outfile.Close()
 
+	temppath := outfile.Name()
+
 	checksummer := utils.NewChecksumWriter()
 	writers := []io.Writer{outfile, downloader.aggWriter}
 
@@ -190,11 +305,21 @@ func (downloader *downloaderImpl) handleTask(task *downloadTask) {
 
 	w := io.MultiWriter(writers...)
 
-	_, err = io.Copy(w, resp.Body)
+	var body io.Reader = resp.Body
+	if task.expected.Size != -1 && !task.ignoreMismatch {
+		// no need to read more than one byte past expected size to detect mismatch
+		body = io.LimitReader(resp.Body, task.expected.Size+1)
+	}
+
+	_, err = io.Copy(w, body)
 	if err != nil {
 		os.Remove(temppath)
-		task.result <- fmt.Errorf("%s: %s", task.url, err)
-		return
+		if downloader.ctx.Err() != nil {
+			return downloader.taskError(task, err)
+		} else if err == io.ErrUnexpectedEOF {
+			return &temporaryError{fmt.Errorf("%s: download truncated, got less data than advertised by server", task.url)}
+		}
+		return &temporaryError{fmt.Errorf("%s: %s", task.url, err)}
 	}
 
 	if task.expected.Size != -1 {
@@ -215,20 +340,62 @@ func (downloader *downloaderImpl) handleTask(task *downloadTask) {
 				downloader.progress.Printf("WARNING: %s\n", err.Error())
 			} else {
 				os.Remove(temppath)
-				task.result <- err
-				return
+				// file might have been changed on the server while downloading
+				return &temporaryError{err}
 			}
 		}
 	}
 
-	err = os.Rename(temppath, task.destination)
+	err = moveFile(temppath, task.destination)
 	if err != nil {
 		os.Remove(temppath)
-		task.result <- fmt.Errorf("%s: %s", task.url, err)
-		return
+		return fmt.Errorf("%s: %s", task.url, err)
 	}
 
-	task.result <- nil
+	return nil
+}
+
+// moveFile atomically replaces destination with file at temppath
+//
+// If temppath is on another filesystem, file is first copied next to destination
+// and then renamed, so that incomplete file never appears at destination
+func moveFile(temppath, destination string) error {
+	err := os.Rename(temppath, destination)
+	if err == nil {
+		return nil
+	}
+
+	source, err := os.Open(temppath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	copypath := destination + ".down"
+
+	target, err := os.Create(copypath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(target, source)
+	if err == nil {
+		err = target.Close()
+	} else {
+		target.Close()
+	}
+	if err != nil {
+		os.Remove(copypath)
+		return err
+	}
+
+	err = os.Rename(copypath, destination)
+	if err != nil {
+		os.Remove(copypath)
+		return err
+	}
+
+	return os.Remove(temppath)
 }
 
 // process implements download thread in goroutine
@@ -257,7 +424,7 @@ func DownloadTemp(downloader aptly.Downloader, url string) (*os.File, error) {
 //
 // Temporary file would be already removed, so no need to cleanup
 func DownloadTempWithChecksum(downloader aptly.Downloader, url string, expected utils.ChecksumInfo, ignoreMismatch bool) (*os.File, error) {
-	tempdir, err := ioutil.TempDir(os.TempDir(), "aptly")
+	tempdir, err := ioutil.TempDir(downloader.GetTempDir(), "aptly")
 	if err != nil {
 		return nil, err
 	}
@@ -308,24 +475,31 @@ var compressionMethods = []struct {
 
 // DownloadTryCompression tries to download from URL .bz2, .gz and raw extension until
 // it finds existing file.
+//
+// If expectedChecksums list some of the variants, variants not listed there are not
+// tried (e.g. upstream which serves only uncompressed indexes).
 func DownloadTryCompression(downloader aptly.Downloader, url string, expectedChecksums map[string]utils.ChecksumInfo, ignoreMismatch bool) (io.Reader, *os.File, error) {
 	var err error
 
+	listed := false
+	for _, method := range compressionMethods {
+		if _, found := lookupChecksum(url+method.extenstion, expectedChecksums); found {
+			listed = true
+			break
+		}
+	}
+
 	for _, method := range compressionMethods {
 		var file *os.File
 
 		tryURL := url + method.extenstion
-		foundChecksum := false
-
-		for suffix, expected := range expectedChecksums {
-			if strings.HasSuffix(tryURL, suffix) {
-				file, err = DownloadTempWithChecksum(downloader, tryURL, expected, ignoreMismatch)
-				foundChecksum = true
-				break
-			}
-		}
+		expected, foundChecksum := lookupChecksum(tryURL, expectedChecksums)
 
-		if !foundChecksum {
+		if foundChecksum {
+			file, err = DownloadTempWithChecksum(downloader, tryURL, expected, ignoreMismatch)
+		} else if listed {
+			continue
+		} else {
 			file, err = DownloadTemp(downloader, tryURL)
 		}
 
@@ -346,3 +520,13 @@ func DownloadTryCompression(downloader aptly.Downloader, url string, expectedChe
 	}
 	return nil, nil, err
 }
+
+// lookupChecksum finds expected checksums for URL
+func lookupChecksum(url string, expectedChecksums map[string]utils.ChecksumInfo) (utils.ChecksumInfo, bool) {
+	for suffix, expected := range expectedChecksums {
+		if strings.HasSuffix(url, suffix) {
+			return expected, true
+		}
+	}
+	return utils.ChecksumInfo{}, false
+}
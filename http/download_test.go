@@ -6,12 +6,15 @@ import (
 	"github.com/smira/aptly/aptly"
 	"github.com/smira/aptly/console"
 	"github.com/smira/aptly/utils"
+	"golang.org/x/net/context"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
+	"sync/atomic"
 	"time"
 
   . "gopkg.in/check.v1"
@@ -23,6 +26,9 @@ type DownloaderSuite struct {
 	url      string
 	ch       chan bool
 	progress aptly.Progress
+	started  chan struct{}
+	// number of requests to /flaky and number of them failing with 503
+	flakyRequests, flakyFailures int32
 }
 
 var _ = Suite(&DownloaderSuite{})
@@ -36,6 +42,34 @@ func (s *DownloaderSuite) SetUpTest(c *C) {
 	mux.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "Hello, %s", r.URL.Path)
 	})
+	mux.HandleFunc("/truncated", func(w http.ResponseWriter, r *http.Request) {
+		// server advertises more data than it actually sends
+		w.Header().Set("Content-Length", "100")
+		fmt.Fprintf(w, "Hello, %s", r.URL.Path)
+	})
+
+	s.started = make(chan struct{}, 1)
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		// sends part of the file and hangs until client goes away
+		w.Header().Set("Content-Length", "100")
+		fmt.Fprintf(w, "Hello, %s", r.URL.Path)
+		w.(http.Flusher).Flush()
+		s.started <- struct{}{}
+
+		select {
+		case <-w.(http.CloseNotifier).CloseNotify():
+		case <-time.After(10 * time.Second):
+		}
+	})
+
+	s.flakyRequests, s.flakyFailures = 0, 0
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&s.flakyRequests, 1) <= atomic.LoadInt32(&s.flakyFailures) {
+			w.WriteHeader(503)
+			return
+		}
+		fmt.Fprintf(w, "Hello, %s", r.URL.Path)
+	})
 
 	s.ch = make(chan bool)
 
@@ -61,7 +95,7 @@ func (s *DownloaderSuite) TearDownTest(c *C) {
 func (s *DownloaderSuite) TestStartupShutdown(c *C) {
 	goroutines := runtime.NumGoroutine()
 
-	d := NewDownloader(10, 100, s.progress)
+	d := NewDownloader(context.Background(), 10, 100, "", s.progress)
 	d.Shutdown()
 
 	// wait for goroutines to shutdown
@@ -73,7 +107,7 @@ func (s *DownloaderSuite) TestStartupShutdown(c *C) {
 }
 
 func (s *DownloaderSuite) TestPauseResume(c *C) {
-	d := NewDownloader(2, 0, s.progress)
+	d := NewDownloader(context.Background(), 2, 0, "", s.progress)
 	defer d.Shutdown()
 
 	d.Pause()
@@ -81,7 +115,7 @@ func (s *DownloaderSuite) TestPauseResume(c *C) {
 }
 
 func (s *DownloaderSuite) TestDownloadOK(c *C) {
-	d := NewDownloader(2, 0, s.progress)
+	d := NewDownloader(context.Background(), 2, 0, "", s.progress)
 	defer d.Shutdown()
 	ch := make(chan error)
 
@@ -91,7 +125,7 @@ func (s *DownloaderSuite) TestDownloadOK(c *C) {
 }
 
 func (s *DownloaderSuite) TestDownloadWithChecksum(c *C) {
-	d := NewDownloader(2, 0, s.progress)
+	d := NewDownloader(context.Background(), 2, 0, "", s.progress)
 	defer d.Shutdown()
 	ch := make(chan error)
 
@@ -131,8 +165,149 @@ func (s *DownloaderSuite) TestDownloadWithChecksum(c *C) {
 	c.Assert(res, IsNil)
 }
 
+func (s *DownloaderSuite) TestDownloadSizeMismatch(c *C) {
+	d := NewDownloader(context.Background(), 2, 0, "", s.progress)
+	defer d.Shutdown()
+	ch := make(chan error)
+
+	destination := filepath.Join(c.MkDir(), "file")
+
+	// size advertised by server doesn't match index
+	d.DownloadWithChecksum(s.url+"/test", destination, ch, utils.ChecksumInfo{Size: 100}, false)
+	res := <-ch
+	c.Assert(res, ErrorMatches, ".*/test: size check mismatch 12 != 100")
+	_, err := os.Stat(destination)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	// server returns shorter body than advertised
+	d.DownloadWithChecksum(s.url+"/truncated", destination, ch, utils.ChecksumInfo{Size: 100}, false)
+	res = <-ch
+	c.Assert(res, ErrorMatches, ".*/truncated: download truncated, got less data than advertised by server")
+	_, err = os.Stat(destination)
+	c.Check(os.IsNotExist(err), Equals, true)
+	_, err = os.Stat(destination + ".down")
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *DownloaderSuite) TestDownloadTempDir(c *C) {
+	tempDir := c.MkDir()
+
+	d := NewDownloader(context.Background(), 2, 0, tempDir, s.progress)
+	defer d.Shutdown()
+	ch := make(chan error)
+
+	c.Check(d.GetTempDir(), Equals, tempDir)
+
+	destination := filepath.Join(c.MkDir(), "pool", "file")
+
+	// failed download is cleaned up
+	d.DownloadWithChecksum(s.url+"/test", destination, ch, utils.ChecksumInfo{Size: 12, MD5: "abcdef"}, false)
+	res := <-ch
+	c.Assert(res, ErrorMatches, ".*md5 hash mismatch \"a1acb0fe91c7db45ec4d775192ec5738\" != \"abcdef\"")
+
+	entries, err := ioutil.ReadDir(tempDir)
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 0)
+	_, err = os.Stat(destination)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	// successful download is moved out of temporary directory
+	d.DownloadWithChecksum(s.url+"/test", destination, ch, utils.ChecksumInfo{Size: 12, MD5: "a1acb0fe91c7db45ec4d775192ec5738"}, false)
+	res = <-ch
+	c.Assert(res, IsNil)
+
+	entries, err = ioutil.ReadDir(tempDir)
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 0)
+
+	info, err := os.Stat(destination)
+	c.Assert(err, IsNil)
+	c.Check(info.Size(), Equals, int64(12))
+	c.Check(info.Mode().Perm(), Equals, os.FileMode(0644))
+}
+
+func (s *DownloaderSuite) TestDownloadCancel(c *C) {
+	tempDir := c.MkDir()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d := NewDownloader(ctx, 2, 0, tempDir, s.progress)
+	defer d.Shutdown()
+	ch := make(chan error)
+
+	destination := filepath.Join(c.MkDir(), "pool", "file")
+
+	// cancel download in progress
+	d.DownloadWithChecksum(s.url+"/slow", destination, ch, utils.ChecksumInfo{Size: 100}, false)
+	<-s.started
+	cancel()
+
+	select {
+	case res := <-ch:
+		c.Check(res, ErrorMatches, ".*/slow: download cancelled: context canceled")
+	case <-time.After(5 * time.Second):
+		c.Fatal("download hasn't been aborted")
+	}
+
+	entries, err := ioutil.ReadDir(tempDir)
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 0)
+	_, err = os.Stat(destination)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	// further downloads fail immediately
+	d.Download(s.url+"/test", destination, ch)
+	c.Check(<-ch, ErrorMatches, ".*/test: download cancelled: context canceled")
+}
+
+func (s *DownloaderSuite) TestDownloadDeadline(c *C) {
+	tempDir := c.MkDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	d := NewDownloader(ctx, 2, 0, tempDir, s.progress)
+	defer d.Shutdown()
+	ch := make(chan error)
+
+	destination := filepath.Join(c.MkDir(), "pool", "file")
+
+	// download stalls, so it is aborted once deadline passes
+	started := time.Now()
+	d.DownloadWithChecksum(s.url+"/slow", destination, ch, utils.ChecksumInfo{Size: 100}, false)
+
+	select {
+	case res := <-ch:
+		c.Check(res, ErrorMatches, ".*/slow: download cancelled: context deadline exceeded")
+	case <-time.After(5 * time.Second):
+		c.Fatal("download hasn't been aborted")
+	}
+
+	c.Check(time.Since(started) >= 500*time.Millisecond, Equals, true)
+
+	entries, err := ioutil.ReadDir(tempDir)
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 0)
+	_, err = os.Stat(destination)
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *DownloaderSuite) TestMoveFile(c *C) {
+	dir := c.MkDir()
+	source, destination := filepath.Join(dir, "source"), filepath.Join(dir, "destination")
+
+	c.Assert(ioutil.WriteFile(source, []byte("contents"), 0644), IsNil)
+	c.Assert(moveFile(source, destination), IsNil)
+
+	contents, err := ioutil.ReadFile(destination)
+	c.Assert(err, IsNil)
+	c.Check(string(contents), Equals, "contents")
+	_, err = os.Stat(source)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	c.Check(moveFile(source, destination), NotNil)
+}
+
 func (s *DownloaderSuite) TestDownload404(c *C) {
-	d := NewDownloader(2, 0, s.progress)
+	d := NewDownloader(context.Background(), 2, 0, "", s.progress)
 	defer d.Shutdown()
 	ch := make(chan error)
 
@@ -141,8 +316,34 @@ func (s *DownloaderSuite) TestDownload404(c *C) {
 	c.Assert(res, ErrorMatches, "HTTP code 404.*")
 }
 
+func (s *DownloaderSuite) TestDownloadRetry(c *C) {
+	d := NewDownloader(context.Background(), 2, 0, "", s.progress).WithRetryPolicy(utils.RetryPolicy{MaxAttempts: 3, BaseDelay: 1})
+	defer d.Shutdown()
+	ch := make(chan error)
+
+	atomic.StoreInt32(&s.flakyFailures, 2)
+	d.Download(s.url+"/flaky", s.tempfile.Name(), ch)
+	c.Assert(<-ch, IsNil)
+	c.Check(atomic.LoadInt32(&s.flakyRequests), Equals, int32(3))
+
+	// 404 isn't retried
+	d.Download(s.url+"/doesntexist", s.tempfile.Name(), ch)
+	c.Assert(<-ch, ErrorMatches, "HTTP code 404.*")
+}
+
+func (s *DownloaderSuite) TestDownloadRetryGiveUp(c *C) {
+	d := NewDownloader(context.Background(), 2, 0, "", s.progress).WithRetryPolicy(utils.RetryPolicy{MaxAttempts: 2, BaseDelay: 1})
+	defer d.Shutdown()
+	ch := make(chan error)
+
+	atomic.StoreInt32(&s.flakyFailures, 5)
+	d.Download(s.url+"/flaky", s.tempfile.Name(), ch)
+	c.Assert(<-ch, ErrorMatches, "HTTP code 503.*")
+	c.Check(atomic.LoadInt32(&s.flakyRequests), Equals, int32(2))
+}
+
 func (s *DownloaderSuite) TestDownloadConnectError(c *C) {
-	d := NewDownloader(2, 0, s.progress)
+	d := NewDownloader(context.Background(), 2, 0, "", s.progress)
 	defer d.Shutdown()
 	ch := make(chan error)
 
@@ -152,7 +353,7 @@ func (s *DownloaderSuite) TestDownloadConnectError(c *C) {
 }
 
 func (s *DownloaderSuite) TestDownloadFileError(c *C) {
-	d := NewDownloader(2, 0, s.progress)
+	d := NewDownloader(context.Background(), 2, 0, "", s.progress)
 	defer d.Shutdown()
 	ch := make(chan error)
 
@@ -162,7 +363,7 @@ func (s *DownloaderSuite) TestDownloadFileError(c *C) {
 }
 
 func (s *DownloaderSuite) TestDownloadTemp(c *C) {
-	d := NewDownloader(2, 0, s.progress)
+	d := NewDownloader(context.Background(), 2, 0, "", s.progress)
 	defer d.Shutdown()
 
 	f, err := DownloadTemp(d, s.url+"/test")
@@ -179,7 +380,7 @@ func (s *DownloaderSuite) TestDownloadTemp(c *C) {
 }
 
 func (s *DownloaderSuite) TestDownloadTempWithChecksum(c *C) {
-	d := NewDownloader(2, 0, s.progress)
+	d := NewDownloader(context.Background(), 2, 0, "", s.progress)
 	defer d.Shutdown()
 
 	f, err := DownloadTempWithChecksum(d, s.url+"/test", utils.ChecksumInfo{Size: 12, MD5: "a1acb0fe91c7db45ec4d775192ec5738",
@@ -192,7 +393,7 @@ func (s *DownloaderSuite) TestDownloadTempWithChecksum(c *C) {
 }
 
 func (s *DownloaderSuite) TestDownloadTempError(c *C) {
-	d := NewDownloader(2, 0, s.progress)
+	d := NewDownloader(context.Background(), 2, 0, "", s.progress)
 	defer d.Shutdown()
 
 	f, err := DownloadTemp(d, s.url+"/doesntexist")
@@ -275,9 +476,30 @@ func (s *DownloaderSuite) TestDownloadTryCompressionErrors(c *C) {
 	c.Assert(err, ErrorMatches, "403")
 
 	d = NewFakeDownloader()
-	d.ExpectError("http://example.com/file.bz2", &HTTPError{Code: 404})
-	d.ExpectError("http://example.com/file.gz", &HTTPError{Code: 404})
 	d.ExpectResponse("http://example.com/file", rawData)
 	_, _, err = DownloadTryCompression(d, "http://example.com/file", map[string]utils.ChecksumInfo{"file": utils.ChecksumInfo{Size: 7}}, false)
 	c.Assert(err, ErrorMatches, "checksums don't match.*")
 }
+
+func (s *DownloaderSuite) TestDownloadTryCompressionUncompressedOnly(c *C) {
+	buf := make([]byte, 4)
+
+	// only raw file is listed, compressed variants are not requested
+	d := NewFakeDownloader()
+	d.ExpectResponse("http://example.com/file", rawData)
+	r, file, err := DownloadTryCompression(d, "http://example.com/file",
+		map[string]utils.ChecksumInfo{"file": utils.ChecksumInfo{Size: int64(len(rawData))}}, false)
+	c.Assert(err, IsNil)
+	defer file.Close()
+	io.ReadFull(r, buf)
+	c.Assert(string(buf), Equals, rawData)
+	c.Assert(d.Empty(), Equals, true)
+
+	// listed file is missing
+	d = NewFakeDownloader()
+	d.ExpectError("http://example.com/file", &HTTPError{Code: 404})
+	_, _, err = DownloadTryCompression(d, "http://example.com/file",
+		map[string]utils.ChecksumInfo{"file": utils.ChecksumInfo{Size: int64(len(rawData))}}, false)
+	c.Assert(err, ErrorMatches, ".*404.*")
+	c.Assert(d.Empty(), Equals, true)
+}
@@ -139,3 +139,13 @@ func (f *FakeDownloader) Resume() {
 func (f *FakeDownloader) GetProgress() aptly.Progress {
 	return nil
 }
+
+// GetTempDir returns system default
+func (f *FakeDownloader) GetTempDir() string {
+	return ""
+}
+
+// WithRetryPolicy returns the same downloader, fake downloads are never retried
+func (f *FakeDownloader) WithRetryPolicy(policy utils.RetryPolicy) aptly.Downloader {
+	return f
+}
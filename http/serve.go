@@ -0,0 +1,398 @@
+package http
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"github.com/smira/aptly/aptly"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache-Control values for published files
+const (
+	// pool files are never changed once published
+	poolCacheControl = "public, max-age=31536000, immutable"
+	// indexes change on each publish, so clients should always revalidate
+	indexCacheControl = "no-cache"
+)
+
+// Maximum number of index files ETags are cached for
+const etagCacheMaxEntries = 1024
+
+type etagEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// PublicHandler serves published repositories from local directory
+//
+// Compared to plain http.FileServer, it generates ETag headers, honors conditional
+// requests and sets Cache-Control headers depending on kind of file
+type PublicHandler struct {
+	root       string
+	fileServer http.Handler
+
+	sync.Mutex
+	etags    map[string]etagEntry
+	maxETags int
+}
+
+// NewPublicHandler creates handler serving files from root directory
+func NewPublicHandler(root string) *PublicHandler {
+	return &PublicHandler{
+		root:       root,
+		fileServer: http.FileServer(http.Dir(root)),
+		etags:      make(map[string]etagEntry),
+		maxETags:   etagCacheMaxEntries,
+	}
+}
+
+// isPoolPath checks whether URL path points into package pool
+func isPoolPath(urlPath string) bool {
+	return strings.Contains(urlPath, "/pool/")
+}
+
+// etag returns ETag for the file
+//
+// Index files are tagged by MD5 of contents (cached while size & modification time are unchanged),
+// pool files are immutable, so size & modification time is enough
+func (h *PublicHandler) etag(urlPath string, file *os.File, info os.FileInfo) (string, error) {
+	if isPoolPath(urlPath) {
+		return fmt.Sprintf("\"%x-%x\"", info.ModTime().Unix(), info.Size()), nil
+	}
+
+	h.Lock()
+	entry, ok := h.etags[urlPath]
+	h.Unlock()
+
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.etag, nil
+	}
+
+	hash := md5.New()
+	_, err := io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = file.Seek(0, 0)
+	if err != nil {
+		return "", err
+	}
+
+	entry = etagEntry{size: info.Size(), modTime: info.ModTime(), etag: fmt.Sprintf("\"%x\"", hash.Sum(nil))}
+
+	h.Lock()
+	if _, ok = h.etags[urlPath]; !ok && len(h.etags) >= h.maxETags {
+		// evict random entry, it would be recalculated on next request
+		for key := range h.etags {
+			delete(h.etags, key)
+			break
+		}
+	}
+	h.etags[urlPath] = entry
+	h.Unlock()
+
+	return entry.etag, nil
+}
+
+// etagMatches checks If-None-Match header against etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNotModified sets validation & caching headers and replies with 304
+// if client has up to date copy of the file
+func checkNotModified(w http.ResponseWriter, r *http.Request, urlPath string, etag string, modTime time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	if isPoolPath(urlPath) {
+		w.Header().Set("Cache-Control", poolCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", indexCacheControl)
+	}
+
+	notModified := false
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		notModified = etag != "" && etagMatches(match, etag)
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modTime.IsZero() {
+		notModified = !modTime.Truncate(time.Second).After(since)
+	}
+
+	if notModified {
+		if !modTime.IsZero() {
+			w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+		}
+		w.WriteHeader(http.StatusNotModified)
+	}
+
+	return notModified
+}
+
+// ServeHTTP implements http.Handler
+func (h *PublicHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	urlPath := path.Clean("/" + r.URL.Path)
+
+	file, err := os.Open(filepath.Join(h.root, filepath.FromSlash(urlPath)))
+	if err != nil {
+		// let file server generate proper error response
+		h.fileServer.ServeHTTP(w, r)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		// directory listings & redirects are handled by file server
+		h.fileServer.ServeHTTP(w, r)
+		return
+	}
+
+	etag, err := h.etag(urlPath, file, info)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if checkNotModified(w, r, urlPath, etag, info.ModTime()) {
+		return
+	}
+
+	// ServeContent takes care of Range & If-Range requests, replying with 206 Partial Content
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+// Limits for caching of index files served from published storage
+const (
+	storageCacheTTL     = 30 * time.Second
+	storageCacheMaxSize = 1024 * 1024
+)
+
+type cachedFile struct {
+	info    aptly.PublishedFileInfo
+	data    []byte
+	expires time.Time
+}
+
+// PublishedStorageHandler serves published repositories directly from published storage
+// which isn't available on local filesystem (e.g. S3 bucket)
+//
+// Files are streamed from the storage on demand, range requests are passed
+// down to the storage. Small index files are kept in memory for a short time.
+type PublishedStorageHandler struct {
+	storage aptly.ReadablePublishedStorage
+
+	sync.Mutex
+	cache map[string]cachedFile
+}
+
+// NewPublishedStorageHandler creates handler serving files from published storage
+func NewPublishedStorageHandler(storage aptly.ReadablePublishedStorage) *PublishedStorageHandler {
+	return &PublishedStorageHandler{
+		storage: storage,
+		cache:   make(map[string]cachedFile),
+	}
+}
+
+// cached returns index file, either from cache or loading it from storage
+func (h *PublishedStorageHandler) cached(urlPath string, info aptly.PublishedFileInfo) ([]byte, error) {
+	h.Lock()
+	entry, ok := h.cache[urlPath]
+	h.Unlock()
+
+	if ok && time.Now().Before(entry.expires) {
+		return entry.data, nil
+	}
+
+	reader, err := h.storage.ReadRange(urlPath, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	h.Lock()
+	for key, entry := range h.cache {
+		if now.After(entry.expires) {
+			delete(h.cache, key)
+		}
+	}
+	h.cache[urlPath] = cachedFile{info: info, data: data, expires: now.Add(storageCacheTTL)}
+	h.Unlock()
+
+	return data, nil
+}
+
+// stat returns information about file, using cache for index files
+func (h *PublishedStorageHandler) stat(urlPath string) (aptly.PublishedFileInfo, error) {
+	h.Lock()
+	entry, ok := h.cache[urlPath]
+	h.Unlock()
+
+	if ok && time.Now().Before(entry.expires) {
+		return entry.info, nil
+	}
+
+	return h.storage.Stat(urlPath)
+}
+
+// parseRange parses single range in Range header
+//
+// ok is false if range should be ignored and whole file served (multiple ranges or
+// unknown units), err is returned if range is not satisfiable
+func parseRange(header string, size int64) (offset, length int64, ok bool, err error) {
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return 0, 0, false, nil
+	}
+
+	spec := strings.TrimSpace(strings.TrimPrefix(header, "bytes="))
+	i := strings.Index(spec, "-")
+	if i == -1 {
+		return 0, 0, false, nil
+	}
+
+	start, end := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if start == "" {
+		// suffix range: last N bytes
+		var n int64
+		if _, err = fmt.Sscanf(end, "%d", &n); err != nil || n <= 0 {
+			return 0, 0, false, fmt.Errorf("invalid range")
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+
+	if _, err = fmt.Sscanf(start, "%d", &offset); err != nil || offset < 0 || offset >= size {
+		return 0, 0, false, fmt.Errorf("invalid range")
+	}
+
+	if end == "" {
+		return offset, size - offset, true, nil
+	}
+
+	var last int64
+	if _, err = fmt.Sscanf(end, "%d", &last); err != nil || last < offset {
+		return 0, 0, false, fmt.Errorf("invalid range")
+	}
+	if last >= size {
+		last = size - 1
+	}
+
+	return offset, last - offset + 1, true, nil
+}
+
+// ServeHTTP implements http.Handler
+func (h *PublishedStorageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	urlPath := path.Clean("/" + r.URL.Path)
+	if urlPath == "/" || strings.HasSuffix(r.URL.Path, "/") {
+		// no directory listings for object storage
+		http.NotFound(w, r)
+		return
+	}
+	urlPath = urlPath[1:]
+
+	info, err := h.stat(urlPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if checkNotModified(w, r, "/"+urlPath, info.ETag, info.ModTime) {
+		return
+	}
+
+	if !isPoolPath("/"+urlPath) && info.Size <= storageCacheMaxSize {
+		data, err := h.cached(urlPath, info)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		http.ServeContent(w, r, path.Base(urlPath), info.ModTime, bytes.NewReader(data))
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(urlPath))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if !info.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	offset, length, partial := int64(0), info.Size, false
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		ifRange := r.Header.Get("If-Range")
+		if ifRange == "" || ifRange == info.ETag {
+			offset, length, partial, err = parseRange(rangeHeader, info.Size)
+			if err != nil {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+				http.Error(w, "requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			if !partial {
+				offset, length = 0, info.Size
+			}
+		}
+	}
+
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", length))
+
+	status := http.StatusOK
+	if partial {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, info.Size))
+		status = http.StatusPartialContent
+	}
+
+	if r.Method == "HEAD" {
+		w.WriteHeader(status)
+		return
+	}
+
+	readLength := length
+	if !partial {
+		readLength = -1
+	}
+
+	reader, err := h.storage.ReadRange(urlPath, offset, readLength)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer reader.Close()
+
+	w.WriteHeader(status)
+	io.CopyN(w, reader, length)
+}
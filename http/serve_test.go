@@ -0,0 +1,219 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/smira/aptly/aptly"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+  . "gopkg.in/check.v1"
+)
+
+type PublicHandlerSuite struct {
+	root    string
+	handler *PublicHandler
+}
+
+var _ = Suite(&PublicHandlerSuite{})
+
+func (s *PublicHandlerSuite) SetUpTest(c *C) {
+	s.root = c.MkDir()
+
+	c.Assert(os.MkdirAll(filepath.Join(s.root, "ppa/dists/squeeze"), 0755), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(s.root, "ppa/pool/main/a/abc"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "ppa/dists/squeeze/Release"), []byte("Origin: aptly\n"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "ppa/pool/main/a/abc/abc_1.0_i386.deb"), []byte("0123456789"), 0644), IsNil)
+
+	s.handler = NewPublicHandler(s.root)
+}
+
+func (s *PublicHandlerSuite) get(c *C, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "http://localhost"+path, nil)
+	c.Assert(err, IsNil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (s *PublicHandlerSuite) TestIndexConditional(c *C) {
+	w := s.get(c, "/ppa/dists/squeeze/Release", nil)
+	c.Check(w.Code, Equals, 200)
+	c.Check(w.Body.String(), Equals, "Origin: aptly\n")
+	c.Check(w.Header().Get("ETag"), Equals, "\"7f9c5b8df62b933d72ba8bd4246b87ba\"")
+	c.Check(w.Header().Get("Last-Modified"), Not(Equals), "")
+	c.Check(w.Header().Get("Cache-Control"), Equals, "no-cache")
+
+	w2 := s.get(c, "/ppa/dists/squeeze/Release", map[string]string{"If-None-Match": w.Header().Get("ETag")})
+	c.Check(w2.Code, Equals, 304)
+	c.Check(w2.Body.Len(), Equals, 0)
+
+	w2 = s.get(c, "/ppa/dists/squeeze/Release", map[string]string{"If-Modified-Since": w.Header().Get("Last-Modified")})
+	c.Check(w2.Code, Equals, 304)
+
+	w2 = s.get(c, "/ppa/dists/squeeze/Release", map[string]string{"If-None-Match": "\"other\""})
+	c.Check(w2.Code, Equals, 200)
+}
+
+func (s *PublicHandlerSuite) TestIndexChanged(c *C) {
+	w := s.get(c, "/ppa/dists/squeeze/Release", nil)
+	etag := w.Header().Get("ETag")
+
+	c.Assert(ioutil.WriteFile(filepath.Join(s.root, "ppa/dists/squeeze/Release"), []byte("Origin: aptly2\n"), 0644), IsNil)
+
+	w = s.get(c, "/ppa/dists/squeeze/Release", map[string]string{"If-None-Match": etag})
+	c.Check(w.Code, Equals, 200)
+	c.Check(w.Body.String(), Equals, "Origin: aptly2\n")
+	c.Check(w.Header().Get("ETag"), Not(Equals), etag)
+}
+
+func (s *PublicHandlerSuite) TestETagCacheLimit(c *C) {
+	s.handler.maxETags = 2
+
+	for _, dist := range []string{"squeeze", "wheezy", "jessie"} {
+		c.Assert(os.MkdirAll(filepath.Join(s.root, "ppa/dists", dist), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(s.root, "ppa/dists", dist, "Release"), []byte("Codename: "+dist+"\n"), 0644), IsNil)
+
+		w := s.get(c, "/ppa/dists/"+dist+"/Release", nil)
+		c.Check(w.Code, Equals, 200)
+		c.Check(w.Header().Get("ETag"), Not(Equals), "")
+	}
+
+	c.Check(s.handler.etags, HasLen, 2)
+	c.Check(s.handler.etags["/ppa/dists/jessie/Release"].etag, Not(Equals), "")
+}
+
+func (s *PublicHandlerSuite) TestPoolFile(c *C) {
+	w := s.get(c, "/ppa/pool/main/a/abc/abc_1.0_i386.deb", nil)
+	c.Check(w.Code, Equals, 200)
+	c.Check(w.Body.String(), Equals, "0123456789")
+	c.Check(w.Header().Get("Cache-Control"), Equals, "public, max-age=31536000, immutable")
+
+	w = s.get(c, "/ppa/pool/main/a/abc/abc_1.0_i386.deb", map[string]string{"If-None-Match": w.Header().Get("ETag")})
+	c.Check(w.Code, Equals, 304)
+}
+
+func (s *PublicHandlerSuite) TestPoolFileRange(c *C) {
+	w := s.get(c, "/ppa/pool/main/a/abc/abc_1.0_i386.deb", map[string]string{"Range": "bytes=2-5"})
+	c.Check(w.Code, Equals, 206)
+	c.Check(w.Body.String(), Equals, "2345")
+	c.Check(w.Header().Get("Accept-Ranges"), Equals, "bytes")
+	c.Check(w.Header().Get("Content-Range"), Equals, "bytes 2-5/10")
+
+	w = s.get(c, "/ppa/pool/main/a/abc/abc_1.0_i386.deb", map[string]string{"Range": "bytes=7-"})
+	c.Check(w.Code, Equals, 206)
+	c.Check(w.Body.String(), Equals, "789")
+
+	w = s.get(c, "/ppa/pool/main/a/abc/abc_1.0_i386.deb", map[string]string{"Range": "bytes=20-30"})
+	c.Check(w.Code, Equals, 416)
+}
+
+func (s *PublicHandlerSuite) TestNotFound(c *C) {
+	w := s.get(c, "/ppa/dists/wheezy/Release", nil)
+	c.Check(w.Code, Equals, 404)
+}
+
+type fakeReadableStorage struct {
+	files map[string][]byte
+	reads []string
+}
+
+func (f *fakeReadableStorage) Stat(path string) (aptly.PublishedFileInfo, error) {
+	data, ok := f.files[path]
+	if !ok {
+		return aptly.PublishedFileInfo{}, fmt.Errorf("not found: %s", path)
+	}
+	return aptly.PublishedFileInfo{Size: int64(len(data)), ETag: "\"" + path + "\"", ModTime: time.Unix(1400000000, 0)}, nil
+}
+
+func (f *fakeReadableStorage) ReadRange(path string, offset, length int64) (io.ReadCloser, error) {
+	f.reads = append(f.reads, fmt.Sprintf("%s:%d:%d", path, offset, length))
+
+	data := f.files[path][offset:]
+	if length >= 0 {
+		data = data[:length]
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+type PublishedStorageHandlerSuite struct {
+	storage *fakeReadableStorage
+	handler *PublishedStorageHandler
+}
+
+var _ = Suite(&PublishedStorageHandlerSuite{})
+
+func (s *PublishedStorageHandlerSuite) SetUpTest(c *C) {
+	s.storage = &fakeReadableStorage{files: map[string][]byte{
+		"ppa/dists/squeeze/Release":            []byte("Origin: aptly\n"),
+		"ppa/pool/main/a/abc/abc_1.0_i386.deb": []byte("0123456789"),
+	}}
+	s.handler = NewPublishedStorageHandler(s.storage)
+}
+
+func (s *PublishedStorageHandlerSuite) get(c *C, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "http://localhost"+path, nil)
+	c.Assert(err, IsNil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (s *PublishedStorageHandlerSuite) TestIndexCached(c *C) {
+	w := s.get(c, "/ppa/dists/squeeze/Release", nil)
+	c.Check(w.Code, Equals, 200)
+	c.Check(w.Body.String(), Equals, "Origin: aptly\n")
+	c.Check(w.Header().Get("ETag"), Equals, "\"ppa/dists/squeeze/Release\"")
+
+	w = s.get(c, "/ppa/dists/squeeze/Release", nil)
+	c.Check(w.Code, Equals, 200)
+	c.Check(w.Body.String(), Equals, "Origin: aptly\n")
+	c.Check(s.storage.reads, DeepEquals, []string{"ppa/dists/squeeze/Release:0:-1"})
+
+	w = s.get(c, "/ppa/dists/squeeze/Release", map[string]string{"If-None-Match": "\"ppa/dists/squeeze/Release\""})
+	c.Check(w.Code, Equals, 304)
+}
+
+func (s *PublishedStorageHandlerSuite) TestPoolFileStreamed(c *C) {
+	w := s.get(c, "/ppa/pool/main/a/abc/abc_1.0_i386.deb", nil)
+	c.Check(w.Code, Equals, 200)
+	c.Check(w.Body.String(), Equals, "0123456789")
+	c.Check(w.Header().Get("Content-Length"), Equals, "10")
+	c.Check(w.Header().Get("Cache-Control"), Equals, "public, max-age=31536000, immutable")
+
+	w = s.get(c, "/ppa/pool/main/a/abc/abc_1.0_i386.deb", map[string]string{"Range": "bytes=2-5"})
+	c.Check(w.Code, Equals, 206)
+	c.Check(w.Body.String(), Equals, "2345")
+	c.Check(w.Header().Get("Content-Range"), Equals, "bytes 2-5/10")
+
+	w = s.get(c, "/ppa/pool/main/a/abc/abc_1.0_i386.deb", map[string]string{"Range": "bytes=-3"})
+	c.Check(w.Code, Equals, 206)
+	c.Check(w.Body.String(), Equals, "789")
+
+	w = s.get(c, "/ppa/pool/main/a/abc/abc_1.0_i386.deb", map[string]string{"Range": "bytes=20-"})
+	c.Check(w.Code, Equals, 416)
+
+	c.Check(s.storage.reads, DeepEquals, []string{
+		"ppa/pool/main/a/abc/abc_1.0_i386.deb:0:-1",
+		"ppa/pool/main/a/abc/abc_1.0_i386.deb:2:4",
+		"ppa/pool/main/a/abc/abc_1.0_i386.deb:7:3",
+	})
+}
+
+func (s *PublishedStorageHandlerSuite) TestNotFound(c *C) {
+	c.Check(s.get(c, "/ppa/dists/wheezy/Release", nil).Code, Equals, 404)
+	c.Check(s.get(c, "/ppa/dists/", nil).Code, Equals, 404)
+}
@@ -0,0 +1,319 @@
+// Package multi implements published storage which mirrors publishing to several storages
+package multi
+
+import (
+	"fmt"
+	"github.com/smira/aptly/aptly"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Target is single storage publishing is mirrored to
+type Target struct {
+	Name    string
+	Storage aptly.PublishedStorage
+}
+
+// PublishedStorage writes published files to each of the targets
+//
+// Package indexes are generated once and then uploaded to every target. In fail-fast
+// mode first error of any target fails the operation. In best-effort mode failed target
+// is skipped till failures are reset (publishing resets them when it starts and
+// finishes), and operation fails only if all of the targets failed; failures are
+// available via Failures.
+type PublishedStorage struct {
+	sync.Mutex
+	targets    []Target
+	bestEffort bool
+	failed     map[string]bool
+	failures   []error
+}
+
+// Check interface
+var (
+	_ aptly.PublishedStorage         = (*PublishedStorage)(nil)
+	_ aptly.CachingPublishedStorage  = (*PublishedStorage)(nil)
+	_ aptly.PartialPublishedStorage  = (*PublishedStorage)(nil)
+	_ aptly.ReadablePublishedStorage = (*PublishedStorage)(nil)
+)
+
+// NewPublishedStorage creates storage mirroring to targets
+func NewPublishedStorage(targets []Target, bestEffort bool) *PublishedStorage {
+	return &PublishedStorage{targets: targets, bestEffort: bestEffort, failed: map[string]bool{}}
+}
+
+// String
+func (storage *PublishedStorage) String() string {
+	names := make([]string, len(storage.targets))
+	for i, target := range storage.targets {
+		names[i] = target.String()
+	}
+	return fmt.Sprintf("multi: %s", strings.Join(names, ", "))
+}
+
+// String
+func (target Target) String() string {
+	if target.Name == "" {
+		return "local"
+	}
+	return target.Name
+}
+
+// ResetFailures forgets failed targets
+func (storage *PublishedStorage) ResetFailures() {
+	storage.Lock()
+	defer storage.Unlock()
+
+	storage.failed = map[string]bool{}
+	storage.failures = nil
+}
+
+// ResetCache forgets failed targets and resets caches of the targets
+func (storage *PublishedStorage) ResetCache() {
+	storage.ResetFailures()
+
+	for _, target := range storage.targets {
+		if cachingStorage, ok := target.Storage.(aptly.CachingPublishedStorage); ok {
+			cachingStorage.ResetCache()
+		}
+	}
+}
+
+// Failures returns errors of targets which failed in best-effort mode since last ResetFailures
+func (storage *PublishedStorage) Failures() []error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	return append([]error(nil), storage.failures...)
+}
+
+// active returns targets which haven't failed
+func (storage *PublishedStorage) active() []Target {
+	storage.Lock()
+	defer storage.Unlock()
+
+	result := make([]Target, 0, len(storage.targets))
+	for _, target := range storage.targets {
+		if !storage.failed[target.Name] {
+			result = append(result, target)
+		}
+	}
+	return result
+}
+
+// each runs operation on every active target
+func (storage *PublishedStorage) each(operation func(aptly.PublishedStorage) error) error {
+	succeeded := 0
+
+	for _, target := range storage.active() {
+		err := operation(target.Storage)
+		if err == nil {
+			succeeded++
+			continue
+		}
+
+		err = fmt.Errorf("publishing to %s failed: %s", target, err)
+		if !storage.bestEffort {
+			return err
+		}
+
+		storage.Lock()
+		storage.failed[target.Name] = true
+		storage.failures = append(storage.failures, err)
+		storage.Unlock()
+	}
+
+	if succeeded == 0 {
+		failures := storage.Failures()
+		messages := make([]string, len(failures))
+		for i := range failures {
+			messages[i] = failures[i].Error()
+		}
+		return fmt.Errorf("all publishing targets failed: %s", strings.Join(messages, "; "))
+	}
+
+	return nil
+}
+
+// MkDir creates directory recursively under public path
+func (storage *PublishedStorage) MkDir(path string) error {
+	return storage.each(func(target aptly.PublishedStorage) error {
+		return target.MkDir(path)
+	})
+}
+
+// PutFile puts file into published storage at specified path
+func (storage *PublishedStorage) PutFile(path string, sourceFilename string) error {
+	return storage.each(func(target aptly.PublishedStorage) error {
+		return target.PutFile(path, sourceFilename)
+	})
+}
+
+// RemoveDirs removes directory structure under public path
+func (storage *PublishedStorage) RemoveDirs(path string, progress aptly.Progress) error {
+	return storage.each(func(target aptly.PublishedStorage) error {
+		return target.RemoveDirs(path, progress)
+	})
+}
+
+// Remove removes single file under public path
+//
+// File missing on some of the targets is not an error
+func (storage *PublishedStorage) Remove(path string) error {
+	return storage.each(func(target aptly.PublishedStorage) error {
+		err := target.Remove(path)
+		if err != nil && isNotExist(target, err) {
+			return nil
+		}
+		return err
+	})
+}
+
+// isNotExist checks whether error of target reports missing file
+func isNotExist(target aptly.PublishedStorage, err error) bool {
+	if checker, ok := target.(aptly.NotExistCheckingPublishedStorage); ok {
+		return checker.IsNotExist(err)
+	}
+	return os.IsNotExist(err)
+}
+
+// LinkFromPool links package file from pool to dist's pool location
+func (storage *PublishedStorage) LinkFromPool(publishedDirectory string, sourcePool aptly.PackagePool,
+	sourcePath, sourceMD5 string, force bool) error {
+	return storage.each(func(target aptly.PublishedStorage) error {
+		return target.LinkFromPool(publishedDirectory, sourcePool, sourcePath, sourceMD5, force)
+	})
+}
+
+// Filelist returns list of files under prefix
+//
+// Targets might diverge (e.g. after best-effort publishing), so files of all the
+// targets are listed
+func (storage *PublishedStorage) Filelist(prefix string) ([]string, error) {
+	result := []string{}
+	seen := map[string]bool{}
+
+	err := storage.each(func(target aptly.PublishedStorage) error {
+		list, err := target.Filelist(prefix)
+		if err != nil {
+			return err
+		}
+
+		for _, path := range list {
+			if !seen[path] {
+				seen[path] = true
+				result = append(result, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// RenameFile renames (moves) file
+func (storage *PublishedStorage) RenameFile(oldName, newName string) error {
+	return storage.each(func(target aptly.PublishedStorage) error {
+		return target.RenameFile(oldName, newName)
+	})
+}
+
+// readable runs read operation on active targets till first of them succeeds
+//
+// Targets might diverge (e.g. after best-effort publishing), so file missing on
+// one target is looked up on the next ones
+func (storage *PublishedStorage) readable(operation func(aptly.ReadablePublishedStorage) error) error {
+	messages := []string{}
+
+	for _, target := range storage.active() {
+		var readable aptly.ReadablePublishedStorage
+
+		switch targetStorage := target.Storage.(type) {
+		case aptly.LocalPublishedStorage:
+			readable = localReader(targetStorage.PublicPath())
+		case aptly.ReadablePublishedStorage:
+			readable = targetStorage
+		default:
+			messages = append(messages, fmt.Sprintf("reading from %s is not supported", target))
+			continue
+		}
+
+		err := operation(readable)
+		if err == nil {
+			return nil
+		}
+		messages = append(messages, fmt.Sprintf("reading from %s failed: %s", target, err))
+	}
+
+	if len(messages) == 0 {
+		return fmt.Errorf("no publishing targets to read from")
+	}
+
+	return fmt.Errorf("unable to read from any of publishing targets: %s", strings.Join(messages, "; "))
+}
+
+// Stat returns information about published file
+func (storage *PublishedStorage) Stat(path string) (info aptly.PublishedFileInfo, err error) {
+	err = storage.readable(func(target aptly.ReadablePublishedStorage) error {
+		var e error
+		info, e = target.Stat(path)
+		return e
+	})
+	return
+}
+
+// ReadRange opens published file for reading length bytes starting at offset,
+// negative length means reading till the end of file
+func (storage *PublishedStorage) ReadRange(path string, offset, length int64) (reader io.ReadCloser, err error) {
+	err = storage.readable(func(target aptly.ReadablePublishedStorage) error {
+		var e error
+		reader, e = target.ReadRange(path, offset, length)
+		return e
+	})
+	return
+}
+
+// localReader reads published files of local target
+type localReader string
+
+func (root localReader) Stat(path string) (aptly.PublishedFileInfo, error) {
+	st, err := os.Stat(filepath.Join(string(root), path))
+	if err != nil {
+		return aptly.PublishedFileInfo{}, err
+	}
+
+	return aptly.PublishedFileInfo{Size: st.Size(), ModTime: st.ModTime()}, nil
+}
+
+func (root localReader) ReadRange(path string, offset, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(string(root), path))
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > 0 {
+		_, err = f.Seek(offset, os.SEEK_SET)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	if length < 0 {
+		return f, nil
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
@@ -0,0 +1,229 @@
+package multi
+
+import (
+	"errors"
+	"github.com/smira/aptly/files"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+// failingStorage fails every modifying operation
+type failingStorage struct {
+	*files.PublishedStorage
+	calls     int
+	removeErr error
+}
+
+var errFailing = errors.New("storage is down")
+
+func (storage *failingStorage) MkDir(path string) error {
+	storage.calls++
+	return errFailing
+}
+
+func (storage *failingStorage) PutFile(path string, sourceFilename string) error {
+	storage.calls++
+	return errFailing
+}
+
+func (storage *failingStorage) Remove(path string) error {
+	storage.calls++
+	if storage.removeErr != nil {
+		return storage.removeErr
+	}
+	return errFailing
+}
+
+// missingStorage reports every file as missing with its own error
+type missingStorage struct {
+	*files.PublishedStorage
+}
+
+var errMissing = errors.New("no such key")
+
+func (storage *missingStorage) Remove(path string) error {
+	return errMissing
+}
+
+func (storage *missingStorage) IsNotExist(err error) bool {
+	return err == errMissing
+}
+
+type PublishedStorageSuite struct {
+	root1, root2     string
+	storage1         *files.PublishedStorage
+	storage2         *files.PublishedStorage
+	broken           *failingStorage
+	source           string
+	storage, partial *PublishedStorage
+}
+
+var _ = Suite(&PublishedStorageSuite{})
+
+func (s *PublishedStorageSuite) SetUpTest(c *C) {
+	s.root1, s.root2 = c.MkDir(), c.MkDir()
+	s.storage1 = files.NewPublishedStorage(s.root1)
+	s.storage2 = files.NewPublishedStorage(s.root2)
+	s.broken = &failingStorage{PublishedStorage: files.NewPublishedStorage(c.MkDir())}
+
+	s.storage = NewPublishedStorage([]Target{{"", s.storage1}, {"files:other", s.storage2}}, false)
+
+	s.source = filepath.Join(c.MkDir(), "Release")
+	c.Assert(ioutil.WriteFile(s.source, []byte("Origin: test\n"), 0644), IsNil)
+}
+
+func (s *PublishedStorageSuite) TestString(c *C) {
+	c.Check(s.storage.String(), Equals, "multi: local, files:other")
+}
+
+func (s *PublishedStorageSuite) TestPutFileAllTargets(c *C) {
+	c.Assert(s.storage.MkDir("ppa/dists/squeeze"), IsNil)
+	c.Assert(s.storage.PutFile("ppa/dists/squeeze/Release", s.source), IsNil)
+
+	for _, storage := range []*files.PublishedStorage{s.storage1, s.storage2} {
+		data, err := ioutil.ReadFile(filepath.Join(storage.PublicPath(), "ppa/dists/squeeze/Release"))
+		c.Check(err, IsNil)
+		c.Check(string(data), Equals, "Origin: test\n")
+	}
+
+	list, err := s.storage.Filelist("ppa")
+	c.Check(err, IsNil)
+	c.Check(list, DeepEquals, []string{"dists/squeeze/Release"})
+
+	c.Assert(s.storage.RenameFile("ppa/dists/squeeze/Release", "ppa/dists/squeeze/Release.old"), IsNil)
+	c.Assert(s.storage.Remove("ppa/dists/squeeze/Release.old"), IsNil)
+	// missing file is not an error
+	c.Assert(s.storage.Remove("ppa/dists/squeeze/Release.old"), IsNil)
+
+	for _, storage := range []*files.PublishedStorage{s.storage1, s.storage2} {
+		_, err = os.Stat(filepath.Join(storage.PublicPath(), "ppa/dists/squeeze/Release.old"))
+		c.Check(os.IsNotExist(err), Equals, true)
+	}
+}
+
+func (s *PublishedStorageSuite) TestFailFast(c *C) {
+	storage := NewPublishedStorage([]Target{{"files:broken", s.broken}, {"", s.storage1}}, false)
+
+	c.Check(storage.MkDir("ppa"), ErrorMatches, "publishing to files:broken failed: storage is down")
+	c.Check(s.broken.calls, Equals, 1)
+
+	// second target is never reached
+	_, err := os.Stat(filepath.Join(s.storage1.PublicPath(), "ppa"))
+	c.Check(os.IsNotExist(err), Equals, true)
+	c.Check(storage.Failures(), HasLen, 0)
+}
+
+func (s *PublishedStorageSuite) TestBestEffort(c *C) {
+	storage := NewPublishedStorage([]Target{{"files:broken", s.broken}, {"", s.storage1}}, true)
+
+	c.Assert(storage.MkDir("ppa/dists/squeeze"), IsNil)
+	c.Assert(storage.PutFile("ppa/dists/squeeze/Release", s.source), IsNil)
+	// failed target is skipped for the rest of operation
+	c.Check(s.broken.calls, Equals, 1)
+	c.Check(storage.Failures(), HasLen, 1)
+	c.Check(storage.Failures()[0], ErrorMatches, "publishing to files:broken failed: storage is down")
+
+	_, err := os.Stat(filepath.Join(s.storage1.PublicPath(), "ppa/dists/squeeze/Release"))
+	c.Check(err, IsNil)
+
+	storage.ResetFailures()
+	c.Check(storage.Failures(), HasLen, 0)
+	c.Assert(storage.MkDir("ppa"), IsNil)
+	c.Check(s.broken.calls, Equals, 2)
+
+	storage.ResetCache()
+	c.Check(storage.Failures(), HasLen, 0)
+	c.Assert(storage.MkDir("ppa"), IsNil)
+	c.Check(s.broken.calls, Equals, 3)
+
+	// all targets failed
+	storage = NewPublishedStorage([]Target{{"files:broken", s.broken}}, true)
+	c.Check(storage.MkDir("ppa"), ErrorMatches, "all publishing targets failed: publishing to files:broken failed: storage is down")
+}
+
+func (s *PublishedStorageSuite) TestFilelistAllTargets(c *C) {
+	c.Assert(s.storage1.MkDir("ppa/dists/squeeze"), IsNil)
+	c.Assert(s.storage1.PutFile("ppa/dists/squeeze/Release", s.source), IsNil)
+	c.Assert(s.storage2.MkDir("ppa/dists/squeeze"), IsNil)
+	c.Assert(s.storage2.PutFile("ppa/dists/squeeze/Release", s.source), IsNil)
+	c.Assert(s.storage2.MkDir("ppa/dists/wheezy"), IsNil)
+	c.Assert(s.storage2.PutFile("ppa/dists/wheezy/Release", s.source), IsNil)
+
+	list, err := s.storage.Filelist("ppa")
+	c.Check(err, IsNil)
+	c.Check(list, DeepEquals, []string{"dists/squeeze/Release", "dists/wheezy/Release"})
+}
+
+func (s *PublishedStorageSuite) TestRemoveMissing(c *C) {
+	missing := &missingStorage{PublishedStorage: files.NewPublishedStorage(c.MkDir())}
+
+	// missing file is recognized by the target which reported it
+	storage := NewPublishedStorage([]Target{{"s3:missing", missing}, {"", s.storage1}}, false)
+	c.Check(storage.Remove("ppa/dists/squeeze/Release"), IsNil)
+
+	storage = NewPublishedStorage([]Target{{"files:broken", s.broken}}, false)
+	s.broken.removeErr = errMissing
+	c.Check(storage.Remove("ppa/dists/squeeze/Release"), ErrorMatches, "publishing to files:broken failed: no such key")
+}
+
+func (s *PublishedStorageSuite) TestRead(c *C) {
+	// file is present only on second target
+	c.Assert(s.storage2.MkDir("ppa/dists/squeeze"), IsNil)
+	c.Assert(s.storage2.PutFile("ppa/dists/squeeze/Release", s.source), IsNil)
+
+	info, err := s.storage.Stat("ppa/dists/squeeze/Release")
+	c.Assert(err, IsNil)
+	c.Check(info.Size, Equals, int64(13))
+
+	reader, err := s.storage.ReadRange("ppa/dists/squeeze/Release", 0, -1)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(reader)
+	c.Check(err, IsNil)
+	c.Check(string(data), Equals, "Origin: test\n")
+	c.Check(reader.Close(), IsNil)
+
+	reader, err = s.storage.ReadRange("ppa/dists/squeeze/Release", 2, 4)
+	c.Assert(err, IsNil)
+	data, err = ioutil.ReadAll(reader)
+	c.Check(err, IsNil)
+	c.Check(string(data), Equals, "igin")
+	c.Check(reader.Close(), IsNil)
+
+	_, err = s.storage.Stat("ppa/dists/wheezy/Release")
+	c.Check(err, ErrorMatches, "unable to read from any of publishing targets: reading from local failed: .*; reading from files:other failed: .*")
+
+	// failed targets are skipped
+	storage := NewPublishedStorage([]Target{{"files:broken", s.broken}, {"files:other", s.storage2}}, true)
+	c.Check(storage.MkDir("ppa"), IsNil)
+
+	reader, err = storage.ReadRange("ppa/dists/squeeze/Release", 0, -1)
+	c.Assert(err, IsNil)
+	c.Check(reader.Close(), IsNil)
+
+	storage = NewPublishedStorage([]Target{{"files:broken", s.broken}}, true)
+	c.Check(storage.MkDir("ppa"), NotNil)
+
+	_, err = storage.Stat("ppa/dists/squeeze/Release")
+	c.Check(err, ErrorMatches, "no publishing targets to read from")
+}
+
+func (s *PublishedStorageSuite) TestLinkFromPool(c *C) {
+	root := c.MkDir()
+	pool := files.NewPackagePool(root)
+
+	sourcePath := filepath.Join(root, "pool/c1/df/mars-invaders_1.03.deb")
+	c.Assert(os.MkdirAll(filepath.Dir(sourcePath), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(sourcePath, []byte("Contents"), 0644), IsNil)
+
+	err := s.storage.LinkFromPool(filepath.Join("", "pool", "main", "m/mars-invaders"), pool, sourcePath, "c1df1da7a1ce305a3b60af9d5733ac1d", false)
+	c.Assert(err, IsNil)
+
+	for _, storage := range []*files.PublishedStorage{s.storage1, s.storage2} {
+		data, err := ioutil.ReadFile(filepath.Join(storage.PublicPath(), "pool/main/m/mars-invaders/mars-invaders_1.03.deb"))
+		c.Check(err, IsNil)
+		c.Check(string(data), Equals, "Contents")
+	}
+}
@@ -0,0 +1,333 @@
+package s3
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/mitchellh/goamz/aws"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Modes of obtaining S3 credentials
+const (
+	// CredentialsDefault uses static keys if both are set, credential chain otherwise
+	CredentialsDefault = ""
+	// CredentialsStatic requires static keys to be set
+	CredentialsStatic = "static"
+	// CredentialsChain ignores static keys and always uses credential chain
+	CredentialsChain = "chain"
+)
+
+// stsEndpoint is URL of AWS STS, used to exchange web identity token for credentials
+var stsEndpoint = "https://sts.amazonaws.com/"
+
+// imdsEndpoint is URL of EC2 instance metadata service listing instance profile credentials
+var imdsEndpoint = "http://169.254.169.254/latest/meta-data/iam/security-credentials/"
+
+// refreshWindow is how long before expiration temporary credentials are fetched again
+var refreshWindow = 5 * time.Minute
+
+// AuthProvider supplies S3 credentials
+//
+// Temporary credentials (web identity token or instance profile) are fetched again
+// once they're about to expire, static credentials never change.
+type AuthProvider struct {
+	sync.Mutex
+	fetch      func() (aws.Auth, time.Time, error)
+	auth       aws.Auth
+	expiration time.Time
+}
+
+// StaticAuthProvider creates provider of credentials which never expire
+func StaticAuthProvider(auth aws.Auth) *AuthProvider {
+	return &AuthProvider{auth: auth}
+}
+
+// Auth returns current credentials, refreshing them if they're about to expire
+//
+// If refresh fails, previous credentials are returned while they're still valid.
+func (provider *AuthProvider) Auth() (aws.Auth, error) {
+	provider.Lock()
+	defer provider.Unlock()
+
+	if provider.fetch == nil || provider.expiration.IsZero() || time.Now().Add(refreshWindow).Before(provider.expiration) {
+		return provider.auth, nil
+	}
+
+	auth, expiration, err := provider.fetch()
+	if err != nil {
+		if time.Now().Before(provider.expiration) {
+			return provider.auth, nil
+		}
+		return aws.Auth{}, fmt.Errorf("unable to refresh S3 credentials: %s", err)
+	}
+
+	provider.auth, provider.expiration = auth, expiration
+
+	return provider.auth, nil
+}
+
+// ResolveAuth builds provider of S3 credentials according to mode
+//
+// Credential chain follows the order of AWS SDKs: environment variables, shared
+// credentials file, web identity token (IAM roles for service accounts on EKS) and
+// finally IAM role of EC2 instance profile.
+func ResolveAuth(mode, accessKey, secretKey string) (*AuthProvider, error) {
+	switch mode {
+	case CredentialsDefault:
+		if accessKey != "" && secretKey != "" {
+			return StaticAuthProvider(aws.Auth{AccessKey: accessKey, SecretKey: secretKey}), nil
+		}
+	case CredentialsStatic:
+		if accessKey == "" || secretKey == "" {
+			return nil, fmt.Errorf("static credentials requested, but access key or secret key is not set")
+		}
+		return StaticAuthProvider(aws.Auth{AccessKey: accessKey, SecretKey: secretKey}), nil
+	case CredentialsChain:
+	default:
+		return nil, fmt.Errorf("unknown credentials mode: %#v, supported modes: %s, %s",
+			mode, CredentialsStatic, CredentialsChain)
+	}
+
+	auth, expiration, err := chainAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthProvider{fetch: chainAuth, auth: auth, expiration: expiration}, nil
+}
+
+// chainAuth walks AWS credential chain, returning first credentials found
+// along with their expiration (zero if credentials don't expire)
+func chainAuth() (aws.Auth, time.Time, error) {
+	if auth, ok := envAuth(); ok {
+		return auth, time.Time{}, nil
+	}
+
+	auth, ok, err := sharedAuth()
+	if err != nil {
+		return aws.Auth{}, time.Time{}, err
+	}
+	if ok {
+		return auth, time.Time{}, nil
+	}
+
+	auth, expiration, ok, err := webIdentityAuth()
+	if err != nil {
+		return aws.Auth{}, time.Time{}, err
+	}
+	if ok {
+		return auth, expiration, nil
+	}
+
+	auth, expiration, err = instanceProfileAuth()
+	if err != nil {
+		return aws.Auth{}, time.Time{}, fmt.Errorf("unable to find S3 credentials in environment, shared credentials file, "+
+			"web identity token or instance profile: %s", err)
+	}
+
+	return auth, expiration, nil
+}
+
+// envAuth loads credentials from environment variables
+func envAuth() (aws.Auth, bool) {
+	auth := aws.Auth{
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Token:     os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if auth.AccessKey == "" {
+		auth.AccessKey = os.Getenv("AWS_ACCESS_KEY")
+	}
+	if auth.SecretKey == "" {
+		auth.SecretKey = os.Getenv("AWS_SECRET_KEY")
+	}
+
+	return auth, auth.AccessKey != "" && auth.SecretKey != ""
+}
+
+// sharedAuth loads credentials for profile $AWS_PROFILE (or default one) from
+// shared credentials file
+func sharedAuth() (aws.Auth, bool, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			return aws.Auth{}, false, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return aws.Auth{}, false, nil
+		}
+		return aws.Auth{}, false, fmt.Errorf("unable to read shared credentials file: %s", err)
+	}
+	defer file.Close()
+
+	var (
+		auth    aws.Auth
+		section string
+	)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		if section != profile {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+
+		switch strings.TrimSpace(parts[0]) {
+		case "aws_access_key_id":
+			auth.AccessKey = value
+		case "aws_secret_access_key":
+			auth.SecretKey = value
+		case "aws_session_token":
+			auth.Token = value
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return aws.Auth{}, false, fmt.Errorf("unable to read shared credentials file: %s", err)
+	}
+
+	return auth, auth.AccessKey != "" && auth.SecretKey != "", nil
+}
+
+type stsCredentials struct {
+	AccessKeyID     string    `xml:"AssumeRoleWithWebIdentityResult>Credentials>AccessKeyId"`
+	SecretAccessKey string    `xml:"AssumeRoleWithWebIdentityResult>Credentials>SecretAccessKey"`
+	SessionToken    string    `xml:"AssumeRoleWithWebIdentityResult>Credentials>SessionToken"`
+	Expiration      time.Time `xml:"AssumeRoleWithWebIdentityResult>Credentials>Expiration"`
+}
+
+// webIdentityAuth exchanges web identity token from $AWS_WEB_IDENTITY_TOKEN_FILE
+// for temporary credentials of role $AWS_ROLE_ARN
+//
+// Token file is read again on each call, as it's rotated as well.
+func webIdentityAuth() (aws.Auth, time.Time, bool, error) {
+	tokenFile, roleARN := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), os.Getenv("AWS_ROLE_ARN")
+	if tokenFile == "" || roleARN == "" {
+		return aws.Auth{}, time.Time{}, false, nil
+	}
+
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return aws.Auth{}, time.Time{}, false, fmt.Errorf("unable to read web identity token: %s", err)
+	}
+
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("aptly-%d", time.Now().Unix())
+	}
+
+	params := url.Values{}
+	params.Set("Action", "AssumeRoleWithWebIdentity")
+	params.Set("Version", "2011-06-15")
+	params.Set("RoleArn", roleARN)
+	params.Set("RoleSessionName", sessionName)
+	params.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.PostForm(stsEndpoint, params)
+	if err != nil {
+		return aws.Auth{}, time.Time{}, false, fmt.Errorf("unable to assume role with web identity: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return aws.Auth{}, time.Time{}, false, fmt.Errorf("unable to assume role with web identity: HTTP code %d", resp.StatusCode)
+	}
+
+	var creds stsCredentials
+	if err = xml.NewDecoder(resp.Body).Decode(&creds); err != nil {
+		return aws.Auth{}, time.Time{}, false, fmt.Errorf("unable to parse STS response: %s", err)
+	}
+
+	return aws.Auth{AccessKey: creds.AccessKeyID, SecretKey: creds.SecretAccessKey, Token: creds.SessionToken},
+		creds.Expiration, true, nil
+}
+
+type imdsCredentials struct {
+	Code            string
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+// instanceProfileAuth gets temporary credentials of EC2 instance profile role
+// from instance metadata service
+func instanceProfileAuth() (aws.Auth, time.Time, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	get := func(url string) ([]byte, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("HTTP code %d", resp.StatusCode)
+		}
+
+		return ioutil.ReadAll(resp.Body)
+	}
+
+	roles, err := get(imdsEndpoint)
+	if err != nil {
+		return aws.Auth{}, time.Time{}, fmt.Errorf("unable to list instance profile roles: %s", err)
+	}
+
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return aws.Auth{}, time.Time{}, fmt.Errorf("no instance profile role")
+	}
+
+	data, err := get(imdsEndpoint + role)
+	if err != nil {
+		return aws.Auth{}, time.Time{}, fmt.Errorf("unable to get instance profile credentials: %s", err)
+	}
+
+	var creds imdsCredentials
+	if err = json.Unmarshal(data, &creds); err != nil {
+		return aws.Auth{}, time.Time{}, fmt.Errorf("unable to parse instance profile credentials: %s", err)
+	}
+
+	if creds.Code != "Success" {
+		return aws.Auth{}, time.Time{}, fmt.Errorf("unable to get instance profile credentials: %s", creds.Code)
+	}
+
+	return aws.Auth{AccessKey: creds.AccessKeyID, SecretKey: creds.SecretAccessKey, Token: creds.Token},
+		creds.Expiration, nil
+}
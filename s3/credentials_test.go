@@ -0,0 +1,223 @@
+package s3
+
+import (
+	"fmt"
+	"github.com/mitchellh/goamz/aws"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type CredentialsSuite struct {
+	savedEnv map[string]string
+}
+
+var _ = Suite(&CredentialsSuite{})
+
+var credentialsEnv = []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN",
+	"AWS_ACCESS_KEY", "AWS_SECRET_KEY", "AWS_SHARED_CREDENTIALS_FILE", "AWS_PROFILE",
+	"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_ROLE_SESSION_NAME", "HOME"}
+
+func (s *CredentialsSuite) SetUpTest(c *C) {
+	s.savedEnv = make(map[string]string)
+	for _, name := range credentialsEnv {
+		s.savedEnv[name] = os.Getenv(name)
+		os.Unsetenv(name)
+	}
+}
+
+func (s *CredentialsSuite) TearDownTest(c *C) {
+	for name, value := range s.savedEnv {
+		if value == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+	}
+}
+
+func (s *CredentialsSuite) TestStatic(c *C) {
+	provider, err := ResolveAuth(CredentialsDefault, "aa", "bb")
+	c.Assert(err, IsNil)
+	c.Check(provider.auth, Equals, aws.Auth{AccessKey: "aa", SecretKey: "bb"})
+
+	provider, err = ResolveAuth(CredentialsStatic, "aa", "bb")
+	c.Assert(err, IsNil)
+	c.Check(provider.auth, Equals, aws.Auth{AccessKey: "aa", SecretKey: "bb"})
+
+	_, err = ResolveAuth(CredentialsStatic, "aa", "")
+	c.Check(err, ErrorMatches, "static credentials requested.*")
+
+	_, err = ResolveAuth("magic", "aa", "bb")
+	c.Check(err, ErrorMatches, "unknown credentials mode: \"magic\".*")
+}
+
+func (s *CredentialsSuite) TestEnv(c *C) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "envkey")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "envsecret")
+	os.Setenv("AWS_SESSION_TOKEN", "envtoken")
+
+	provider, err := ResolveAuth(CredentialsDefault, "", "")
+	c.Assert(err, IsNil)
+	c.Check(provider.auth, Equals, aws.Auth{AccessKey: "envkey", SecretKey: "envsecret", Token: "envtoken"})
+
+	// chain mode ignores static keys
+	provider, err = ResolveAuth(CredentialsChain, "aa", "bb")
+	c.Assert(err, IsNil)
+	c.Check(provider.auth, Equals, aws.Auth{AccessKey: "envkey", SecretKey: "envsecret", Token: "envtoken"})
+
+	stor, err := NewPublishedStorage("aa", "bb", CredentialsChain, "us-east-1", "", "test", "", "", "", "", false, false, false)
+	c.Assert(err, IsNil)
+	c.Check(stor.s3.Auth, Equals, aws.Auth{AccessKey: "envkey", SecretKey: "envsecret", Token: "envtoken"})
+}
+
+func (s *CredentialsSuite) TestSharedFile(c *C) {
+	dir := c.MkDir()
+	os.Setenv("HOME", dir)
+	c.Assert(os.MkdirAll(filepath.Join(dir, ".aws"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, ".aws", "credentials"), []byte(
+		"[default]\naws_access_key_id = defkey\naws_secret_access_key = defsecret\n\n"+
+			"# other profile\n[ci]\naws_access_key_id=cikey\naws_secret_access_key=cisecret\naws_session_token=citoken\n"), 0600), IsNil)
+
+	provider, err := ResolveAuth(CredentialsChain, "", "")
+	c.Assert(err, IsNil)
+	c.Check(provider.auth, Equals, aws.Auth{AccessKey: "defkey", SecretKey: "defsecret"})
+
+	os.Setenv("AWS_PROFILE", "ci")
+	provider, err = ResolveAuth(CredentialsChain, "", "")
+	c.Assert(err, IsNil)
+	c.Check(provider.auth, Equals, aws.Auth{AccessKey: "cikey", SecretKey: "cisecret", Token: "citoken"})
+
+	// environment takes precedence
+	os.Setenv("AWS_ACCESS_KEY_ID", "envkey")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "envsecret")
+	provider, err = ResolveAuth(CredentialsChain, "", "")
+	c.Assert(err, IsNil)
+	c.Check(provider.auth, Equals, aws.Auth{AccessKey: "envkey", SecretKey: "envsecret"})
+}
+
+func (s *CredentialsSuite) TestWebIdentity(c *C) {
+	var form map[string][]string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		fmt.Fprint(w, `<AssumeRoleWithWebIdentityResponse>
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>stskey</AccessKeyId>
+      <SecretAccessKey>stssecret</SecretAccessKey>
+      <SessionToken>ststoken</SessionToken>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`)
+	}))
+	defer srv.Close()
+
+	savedEndpoint := stsEndpoint
+	stsEndpoint = srv.URL
+	defer func() { stsEndpoint = savedEndpoint }()
+
+	tokenFile := filepath.Join(c.MkDir(), "token")
+	c.Assert(ioutil.WriteFile(tokenFile, []byte("jwt\n"), 0600), IsNil)
+	os.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	os.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/aptly")
+	os.Setenv("AWS_ROLE_SESSION_NAME", "test")
+
+	provider, err := ResolveAuth(CredentialsDefault, "", "")
+	c.Assert(err, IsNil)
+	c.Check(provider.auth, Equals, aws.Auth{AccessKey: "stskey", SecretKey: "stssecret", Token: "ststoken"})
+	c.Check(form["Action"], DeepEquals, []string{"AssumeRoleWithWebIdentity"})
+	c.Check(form["RoleArn"], DeepEquals, []string{"arn:aws:iam::123456789012:role/aptly"})
+	c.Check(form["RoleSessionName"], DeepEquals, []string{"test"})
+	c.Check(form["WebIdentityToken"], DeepEquals, []string{"jwt"})
+}
+
+func (s *CredentialsSuite) TestWebIdentityRefresh(c *C) {
+	requests := 0
+	expiration := time.Now().Add(time.Minute)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse>
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>stskey%d</AccessKeyId>
+      <SecretAccessKey>stssecret</SecretAccessKey>
+      <SessionToken>ststoken</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`, requests, expiration.Format(time.RFC3339))
+	}))
+	defer srv.Close()
+
+	savedEndpoint := stsEndpoint
+	stsEndpoint = srv.URL
+	defer func() { stsEndpoint = savedEndpoint }()
+
+	tokenFile := filepath.Join(c.MkDir(), "token")
+	c.Assert(ioutil.WriteFile(tokenFile, []byte("jwt\n"), 0600), IsNil)
+	os.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	os.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/aptly")
+
+	provider, err := ResolveAuth(CredentialsDefault, "", "")
+	c.Assert(err, IsNil)
+	c.Check(provider.auth.AccessKey, Equals, "stskey1")
+
+	// credentials expire within refresh window, so they're fetched again
+	auth, err := provider.Auth()
+	c.Assert(err, IsNil)
+	c.Check(auth.AccessKey, Equals, "stskey2")
+
+	// fresh credentials are cached
+	expiration = time.Now().Add(time.Hour)
+	auth, err = provider.Auth()
+	c.Assert(err, IsNil)
+	c.Check(auth.AccessKey, Equals, "stskey3")
+
+	auth, err = provider.Auth()
+	c.Assert(err, IsNil)
+	c.Check(auth.AccessKey, Equals, "stskey3")
+	c.Check(requests, Equals, 3)
+
+	// refresh failure: still valid credentials are kept, expired ones are not
+	os.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", filepath.Join(c.MkDir(), "missing"))
+	provider.expiration = time.Now().Add(time.Minute)
+	auth, err = provider.Auth()
+	c.Assert(err, IsNil)
+	c.Check(auth.AccessKey, Equals, "stskey3")
+
+	provider.expiration = time.Now().Add(-time.Minute)
+	_, err = provider.Auth()
+	c.Check(err, ErrorMatches, "unable to refresh S3 credentials: unable to read web identity token: .*")
+}
+
+func (s *CredentialsSuite) TestInstanceProfile(c *C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, "aptly-role\n")
+		case "/aptly-role":
+			fmt.Fprint(w, `{"Code": "Success", "AccessKeyId": "imdskey", "SecretAccessKey": "imdssecret",
+			    "Token": "imdstoken", "Expiration": "2030-01-01T00:00:00Z"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	savedEndpoint := imdsEndpoint
+	imdsEndpoint = srv.URL + "/"
+	defer func() { imdsEndpoint = savedEndpoint }()
+
+	provider, err := ResolveAuth(CredentialsChain, "", "")
+	c.Assert(err, IsNil)
+	c.Check(provider.auth, Equals, aws.Auth{AccessKey: "imdskey", SecretKey: "imdssecret", Token: "imdstoken"})
+	c.Check(provider.expiration.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)), Equals, true)
+}
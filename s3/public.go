@@ -6,13 +6,22 @@ import (
 	"github.com/mitchellh/goamz/s3"
 	"github.com/smira/aptly/aptly"
 	"github.com/smira/aptly/files"
+	"github.com/smira/aptly/utils"
+	"golang.org/x/net/context"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 // PublishedStorage abstract file system with published files (actually hosted on S3)
 type PublishedStorage struct {
+	auth *AuthProvider
+	// s3 & bucket are replaced (under bucketLock) once credentials are refreshed
+	bucketLock       sync.Mutex
 	s3               *s3.S3
 	bucket           *s3.Bucket
 	acl              s3.ACL
@@ -20,11 +29,27 @@ type PublishedStorage struct {
 	storageClass     string
 	encryptionMethod string
 	plusWorkaround   bool
+	retryPolicy      utils.RetryPolicy
+	breaker          *utils.CircuitBreaker
+	// number of keys requested per listing page
+	pageSize int
+	// custom metadata attached to uploaded objects
+	metadata map[string]string
+	// list published pool once instead of requesting each file in LinkFromPool
+	prescanPool bool
+	// cached listings of published pool, by pool root
+	poolListingsLock sync.Mutex
+	poolListings     map[string]map[string]s3.Key
+	// context for cancellation of requests, never cancelled if nil
+	ctx context.Context
 }
 
 // Check interface
 var (
-	_ aptly.PublishedStorage = (*PublishedStorage)(nil)
+	_ aptly.PublishedStorage                 = (*PublishedStorage)(nil)
+	_ aptly.ReadablePublishedStorage         = (*PublishedStorage)(nil)
+	_ aptly.CachingPublishedStorage          = (*PublishedStorage)(nil)
+	_ aptly.NotExistCheckingPublishedStorage = (*PublishedStorage)(nil)
 )
 
 // NewPublishedStorageRaw creates published storage from raw aws credentials
@@ -39,12 +64,15 @@ func NewPublishedStorageRaw(auth aws.Auth, region aws.Region, bucket, defaultACL
 	}
 
 	result := &PublishedStorage{
+		auth:             StaticAuthProvider(auth),
 		s3:               s3.New(auth, region),
 		acl:              s3.ACL(defaultACL),
 		prefix:           prefix,
 		storageClass:     storageClass,
 		encryptionMethod: encryptionMethod,
-		plusWorkaround:   plusWorkaround}
+		plusWorkaround:   plusWorkaround,
+		breaker:          utils.NewCircuitBreaker(nil),
+		pageSize:         1000}
 	result.bucket = result.s3.Bucket(bucket)
 
 	return result, nil
@@ -52,26 +80,264 @@ func NewPublishedStorageRaw(auth aws.Auth, region aws.Region, bucket, defaultACL
 
 // NewPublishedStorage creates new instance of PublishedStorage with specified S3 access
 // keys, region and bucket name
-func NewPublishedStorage(accessKey, secretKey, region, bucket, defaultACL, prefix,
-	storageClass, encryptionMethod string, plusWorkaround bool) (*PublishedStorage, error) {
-	auth, err := aws.GetAuth(accessKey, secretKey)
+//
+// Credentials are resolved according to credentials mode, see ResolveAuth.
+//
+// If endpoint is set, storage is accessed at S3-compatible endpoint (e.g. MinIO or
+// Ceph RGW) instead of AWS, region is optional in that case.
+func NewPublishedStorage(accessKey, secretKey, credentials, region, endpoint, bucket, defaultACL, prefix,
+	storageClass, encryptionMethod string, plusWorkaround, forcePathStyle, disableSSL bool) (*PublishedStorage, error) {
+	provider, err := ResolveAuth(credentials, accessKey, secretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := provider.Auth()
 	if err != nil {
 		return nil, err
 	}
 
-	awsRegion, ok := aws.Regions[region]
-	if !ok {
-		return nil, fmt.Errorf("unknown region: %#v", region)
+	var awsRegion aws.Region
+
+	if endpoint != "" {
+		awsRegion, err = CustomRegion(region, endpoint, forcePathStyle, disableSSL)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var ok bool
+
+		awsRegion, ok = aws.Regions[region]
+		if !ok {
+			return nil, fmt.Errorf("unknown region: %#v", region)
+		}
 	}
 
-	return NewPublishedStorageRaw(auth, awsRegion, bucket, defaultACL, prefix, storageClass, encryptionMethod, plusWorkaround)
+	result, err := NewPublishedStorageRaw(auth, awsRegion, bucket, defaultACL, prefix, storageClass, encryptionMethod, plusWorkaround)
+	if err != nil {
+		return nil, err
+	}
+
+	result.auth = provider
+
+	return result, nil
+}
+
+// CustomRegion builds region for S3-compatible endpoint
+//
+// Endpoint could be either URL or host[:port], in the latter case HTTPS is used
+// unless disableSSL is set. If endpoint is HTTPS URL, disableSSL can't be set. Bucket name is put into host name (virtual-hosted style),
+// unless forcePathStyle is set: bucket name is put into URL path in that case.
+func CustomRegion(name, endpoint string, forcePathStyle, disableSSL bool) (aws.Region, error) {
+	if name == "" {
+		name = "us-east-1"
+	}
+
+	scheme, host := "https", endpoint
+	if i := strings.Index(endpoint, "://"); i != -1 {
+		scheme, host = endpoint[:i], endpoint[i+3:]
+	} else if disableSSL {
+		scheme = "http"
+	}
+	host = strings.TrimSuffix(host, "/")
+
+	if disableSSL && scheme != "http" {
+		return aws.Region{}, fmt.Errorf("disableSSL conflicts with endpoint %s", endpoint)
+	}
+
+	region := aws.Region{
+		Name:       name,
+		S3Endpoint: scheme + "://" + host,
+	}
+
+	if !forcePathStyle {
+		region.S3BucketEndpoint = scheme + "://${bucket}." + host
+	}
+
+	return region, nil
 }
 
 // String
 func (storage *PublishedStorage) String() string {
+	storage.bucketLock.Lock()
+	defer storage.bucketLock.Unlock()
+
 	return fmt.Sprintf("S3: %s:%s/%s", storage.s3.Region.Name, storage.bucket.Name, storage.prefix)
 }
 
+// getBucket returns bucket handle with current credentials
+//
+// Once credentials are refreshed, new handle is created, so that requests
+// in progress keep using handle they've started with.
+func (storage *PublishedStorage) getBucket() (*s3.Bucket, error) {
+	if storage.ctx != nil && storage.ctx.Err() != nil {
+		return nil, fmt.Errorf("S3 request cancelled: %s", storage.ctx.Err())
+	}
+
+	auth, err := storage.auth.Auth()
+	if err != nil {
+		return nil, err
+	}
+
+	storage.bucketLock.Lock()
+	defer storage.bucketLock.Unlock()
+
+	if storage.s3.Auth != auth {
+		conn := *storage.s3
+		conn.Auth = auth
+		storage.s3 = &conn
+		storage.bucket = conn.Bucket(storage.bucket.Name)
+	}
+
+	return storage.bucket, nil
+}
+
+// SetContext sets context for cancellation of requests: once it's cancelled, requests
+// in progress are aborted and new requests (and retries) fail immediately
+func (storage *PublishedStorage) SetContext(ctx context.Context) {
+	storage.bucketLock.Lock()
+	defer storage.bucketLock.Unlock()
+
+	storage.ctx = ctx
+
+	transport := &cancelTransport{ctx: ctx, transport: http.DefaultTransport.(*http.Transport)}
+	conn := *storage.s3
+	conn.HTTPClient = func() *http.Client {
+		return &http.Client{Transport: transport}
+	}
+	storage.s3 = &conn
+	storage.bucket = conn.Bucket(storage.bucket.Name)
+}
+
+// SetRetryPolicy sets policy for retrying failed uploads
+func (storage *PublishedStorage) SetRetryPolicy(policy utils.RetryPolicy) {
+	storage.retryPolicy = policy
+}
+
+// SetCircuitBreaker configures circuit breaker for uploads and deletes
+func (storage *PublishedStorage) SetCircuitBreaker(config *utils.CircuitBreakerConfig) {
+	storage.breaker = utils.NewCircuitBreaker(config)
+}
+
+// SetMetadata configures custom metadata (x-amz-meta-*) attached to uploaded objects,
+// in addition to aptly version and upload time
+func (storage *PublishedStorage) SetMetadata(metadata map[string]string) {
+	storage.metadata = metadata
+}
+
+// SetPrescanPool enables listing published pool once per publishing, so that
+// LinkFromPool skips files which match size and ETag without requesting each file
+func (storage *PublishedStorage) SetPrescanPool(prescan bool) {
+	storage.prescanPool = prescan
+}
+
+// ResetCache drops cached listings of published pool
+func (storage *PublishedStorage) ResetCache() {
+	storage.poolListingsLock.Lock()
+	defer storage.poolListingsLock.Unlock()
+
+	storage.poolListings = nil
+}
+
+// metadataHeaders builds x-amz-meta-* headers for uploaded object
+//
+// S3 copies metadata along with object, so metadata is preserved by RenameFile
+func (storage *PublishedStorage) metadataHeaders() map[string][]string {
+	headers := map[string][]string{
+		"x-amz-meta-aptly-version":   {aptly.Version},
+		"x-amz-meta-aptly-published": {time.Now().UTC().Format(time.RFC3339)},
+	}
+	for key, value := range storage.metadata {
+		headers["x-amz-meta-"+strings.ToLower(key)] = []string{value}
+	}
+	return headers
+}
+
+// temporary checks whether error is S3 error with retryable status code or network error
+func (storage *PublishedStorage) temporary(err error) bool {
+	if s3err, ok := err.(*s3.Error); ok {
+		return storage.retryPolicy.IsRetryableStatus(s3err.StatusCode)
+	}
+	return true
+}
+
+// retry runs S3 operation through circuit breaker according to retry policy, retrying
+// temporary errors until context is cancelled
+func (storage *PublishedStorage) retry(operation func() error) error {
+	var done <-chan struct{}
+	if storage.ctx != nil {
+		done = storage.ctx.Done()
+	}
+
+	return storage.breaker.Call(func() error {
+		return storage.retryPolicy.Do(done, operation, func(err error) bool {
+			if storage.ctx != nil && storage.ctx.Err() != nil {
+				return false
+			}
+			return storage.temporary(err)
+		})
+	}, storage.temporary)
+}
+
+// cancelTransport aborts requests in progress (including reading of response body)
+// once context is cancelled
+type cancelTransport struct {
+	ctx       context.Context
+	transport *http.Transport
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *cancelTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.ctx.Err() != nil {
+		return nil, fmt.Errorf("S3 request cancelled: %s", t.ctx.Err())
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-t.ctx.Done():
+			t.transport.CancelRequest(req)
+		case <-finished:
+		}
+	}()
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		close(finished)
+		return nil, err
+	}
+
+	resp.Body = &finishingBody{ReadCloser: resp.Body, finished: finished}
+	return resp, nil
+}
+
+// finishingBody stops watching for cancellation of request once response body is closed
+type finishingBody struct {
+	io.ReadCloser
+	once     sync.Once
+	finished chan struct{}
+}
+
+// Close closes response body
+func (b *finishingBody) Close() error {
+	b.once.Do(func() { close(b.finished) })
+	return b.ReadCloser.Close()
+}
+
+// Check verifies that bucket is reachable and credentials are accepted
+//
+// It performs single cheap listing request and doesn't modify anything
+func (storage *PublishedStorage) Check() error {
+	bucket, err := storage.getBucket()
+	if err == nil {
+		_, err = bucket.List(storage.prefix, "", "", 1)
+	}
+	if err != nil {
+		return fmt.Errorf("error accessing %s: %s", storage, err)
+	}
+	return nil
+}
+
 // MkDir creates directory recursively under public path
 func (storage *PublishedStorage) MkDir(path string) error {
 	// no op for S3
@@ -96,9 +362,8 @@ func (storage *PublishedStorage) PutFile(path string, sourceFilename string) err
 		return err
 	}
 
-	headers := map[string][]string{
-		"Content-Type": {"binary/octet-stream"},
-	}
+	headers := storage.metadataHeaders()
+	headers["Content-Type"] = []string{"binary/octet-stream"}
 	if storage.storageClass != "" {
 		headers["x-amz-storage-class"] = []string{storage.storageClass}
 	}
@@ -106,7 +371,17 @@ func (storage *PublishedStorage) PutFile(path string, sourceFilename string) err
 		headers["x-amz-server-side-encryption"] = []string{storage.encryptionMethod}
 	}
 
-	err = storage.bucket.PutReaderHeader(filepath.Join(storage.prefix, path), source, fi.Size(), headers, storage.acl)
+	err = storage.retry(func() error {
+		_, err := source.Seek(0, 0)
+		if err != nil {
+			return err
+		}
+		bucket, err := storage.getBucket()
+		if err != nil {
+			return err
+		}
+		return bucket.PutReaderHeader(filepath.Join(storage.prefix, path), source, fi.Size(), headers, storage.acl)
+	})
 	if err != nil {
 		return fmt.Errorf("error uploading %s to %s: %s", sourceFilename, storage, err)
 	}
@@ -119,17 +394,41 @@ func (storage *PublishedStorage) PutFile(path string, sourceFilename string) err
 
 // Remove removes single file under public path
 func (storage *PublishedStorage) Remove(path string) error {
-	err := storage.bucket.Del(filepath.Join(storage.prefix, path))
+	storage.ResetCache()
+
+	err := storage.retry(func() error {
+		bucket, err := storage.getBucket()
+		if err != nil {
+			return err
+		}
+		return bucket.Del(filepath.Join(storage.prefix, path))
+	})
 	if err != nil {
+		if s3err, ok := err.(*s3.Error); ok && s3err.StatusCode == 404 {
+			return &notExistError{fmt.Errorf("error deleting %s from %s: %s", path, storage, err)}
+		}
 		return fmt.Errorf("error deleting %s from %s: %s", path, storage, err)
 	}
 	return nil
 }
 
+// notExistError reports missing published file
+type notExistError struct {
+	error
+}
+
+// IsNotExist checks whether error returned by storage reports missing file
+func (storage *PublishedStorage) IsNotExist(err error) bool {
+	_, ok := err.(*notExistError)
+	return ok
+}
+
 // RemoveDirs removes directory structure under public path
 func (storage *PublishedStorage) RemoveDirs(path string, progress aptly.Progress) error {
 	const page = 1000
 
+	storage.ResetCache()
+
 	filelist, err := storage.Filelist(path)
 	if err != nil {
 		return err
@@ -150,13 +449,16 @@ func (storage *PublishedStorage) RemoveDirs(path string, progress aptly.Progress
 			paths[i] = filepath.Join(storage.prefix, path, part[i])
 		}
 
-		err = storage.bucket.MultiDel(paths)
+		err = storage.retry(func() error {
+			bucket, err := storage.getBucket()
+			if err != nil {
+				return err
+			}
+			return bucket.MultiDel(paths)
+		})
 		if err != nil {
 			return fmt.Errorf("error deleting multiple paths from %s: %s", storage, err)
 		}
-		if err != nil {
-			return err
-		}
 	}
 
 	return nil
@@ -183,7 +485,40 @@ func (storage *PublishedStorage) LinkFromPool(publishedDirectory string, sourceP
 		err    error
 	)
 
-	dstKey, err = storage.bucket.GetKey(poolPath)
+	if storage.prescanPool {
+		var (
+			key    s3.Key
+			exists bool
+			fi     os.FileInfo
+		)
+
+		key, exists, err = storage.prescannedKey(publishedDirectory, poolPath)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return storage.putPoolFile(relPath, poolPath, sourcePath, sourceMD5)
+		}
+
+		fi, err = os.Stat(sourcePath)
+		if err != nil {
+			return err
+		}
+
+		if key.Size == fi.Size() && strings.Replace(key.ETag, "\"", "", -1) == sourceMD5 {
+			return nil
+		}
+
+		// ambiguous (e.g. ETag of multipart upload), fall back to full comparison
+	}
+
+	bucket, err := storage.getBucket()
+	if err != nil {
+		return err
+	}
+
+	dstKey, err = bucket.GetKey(poolPath)
 	if err != nil {
 		if s3err, ok := err.(*s3.Error); !ok || s3err.StatusCode != 404 {
 			return fmt.Errorf("error getting information about %s from %s: %s", poolPath, storage, err)
@@ -200,29 +535,116 @@ func (storage *PublishedStorage) LinkFromPool(publishedDirectory string, sourceP
 		}
 	}
 
-	return storage.PutFile(relPath, sourcePath)
+	return storage.putPoolFile(relPath, poolPath, sourcePath, sourceMD5)
+}
+
+// putPoolFile uploads pool file, recording it in pool listing if listing is cached
+func (storage *PublishedStorage) putPoolFile(relPath, poolPath, sourcePath, sourceMD5 string) error {
+	err := storage.PutFile(relPath, sourcePath)
+	if err != nil || !storage.prescanPool {
+		return err
+	}
+
+	fi, err := os.Stat(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	storage.poolListingsLock.Lock()
+	defer storage.poolListingsLock.Unlock()
+
+	if listing, ok := storage.poolListings[poolRoot(filepath.Dir(relPath))]; ok {
+		listing[poolPath] = s3.Key{Key: poolPath, Size: fi.Size(), ETag: "\"" + sourceMD5 + "\""}
+	}
+
+	return nil
+}
+
+// poolRoot returns root of component's pool for pool directory
+// (prefix/pool/component/l/lib -> prefix/pool/component)
+func poolRoot(publishedDirectory string) string {
+	return filepath.Dir(filepath.Dir(publishedDirectory))
+}
+
+// prescannedKey looks up poolPath in cached listing of component's pool containing
+// publishedDirectory, listing bucket on first access
+func (storage *PublishedStorage) prescannedKey(publishedDirectory, poolPath string) (s3.Key, bool, error) {
+	root := poolRoot(publishedDirectory)
+
+	storage.poolListingsLock.Lock()
+	defer storage.poolListingsLock.Unlock()
+
+	if listing, ok := storage.poolListings[root]; ok {
+		key, exists := listing[poolPath]
+		return key, exists, nil
+	}
+
+	keys, err := storage.listKeys(root)
+	if err != nil {
+		return s3.Key{}, false, err
+	}
+
+	listing := make(map[string]s3.Key, len(keys))
+	for _, key := range keys {
+		listing[key.Key] = key
+	}
+
+	if storage.poolListings == nil {
+		storage.poolListings = make(map[string]map[string]s3.Key)
+	}
+	storage.poolListings[root] = listing
+
+	key, exists := listing[poolPath]
+	return key, exists, nil
 }
 
 // Filelist returns list of files under prefix
 func (storage *PublishedStorage) Filelist(prefix string) ([]string, error) {
-	result := []string{}
+	keys, err := storage.listKeys(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	fullPrefix := filepath.Join(storage.prefix, prefix)
+	if fullPrefix != "" {
+		fullPrefix += "/"
+	}
+
+	result := make([]string, len(keys))
+	for i, key := range keys {
+		result[i] = key.Key[len(fullPrefix):]
+	}
+
+	return result, nil
+}
+
+// listKeys returns all the keys under prefix
+func (storage *PublishedStorage) listKeys(prefix string) ([]s3.Key, error) {
+	result := []s3.Key{}
 	marker := ""
 	prefix = filepath.Join(storage.prefix, prefix)
 	if prefix != "" {
+		// listing is limited to keys under prefix "directory", so that sibling
+		// prefixes (e.g. other publishing roots in the same bucket) are never loaded
 		prefix += "/"
 	}
 	for {
-		contents, err := storage.bucket.List(prefix, "", marker, 1000)
+		var contents *s3.ListResp
+
+		err := storage.retry(func() error {
+			bucket, err := storage.getBucket()
+			if err != nil {
+				return err
+			}
+			contents, err = bucket.List(prefix, "", marker, storage.pageSize)
+			return err
+		})
 		if err != nil {
 			return nil, fmt.Errorf("error listing under prefix %s in %s: %s", prefix, storage, err)
 		}
 		lastKey := ""
 		for _, key := range contents.Contents {
-			if prefix == "" {
-				result = append(result, key.Key)
-			} else {
-				result = append(result, key.Key[len(prefix):])
-			}
+			result = append(result, key)
 			lastKey = key.Key
 		}
 		if contents.IsTruncated {
@@ -243,11 +665,75 @@ func (storage *PublishedStorage) Filelist(prefix string) ([]string, error) {
 }
 
 // RenameFile renames (moves) file
+//
+// Object is copied with default metadata directive, so metadata is preserved
 func (storage *PublishedStorage) RenameFile(oldName, newName string) error {
-	err := storage.bucket.Copy(filepath.Join(storage.prefix, oldName), filepath.Join(storage.prefix, newName), storage.acl)
+	storage.ResetCache()
+
+	err := storage.retry(func() error {
+		bucket, err := storage.getBucket()
+		if err != nil {
+			return err
+		}
+		return bucket.Copy(filepath.Join(storage.prefix, oldName), filepath.Join(storage.prefix, newName), storage.acl)
+	})
 	if err != nil {
 		return fmt.Errorf("error copying %s -> %s in %s: %s", oldName, newName, storage, err)
 	}
 
 	return storage.Remove(oldName)
 }
+
+// Stat returns information about published file
+func (storage *PublishedStorage) Stat(path string) (aptly.PublishedFileInfo, error) {
+	bucket, err := storage.getBucket()
+	if err != nil {
+		return aptly.PublishedFileInfo{}, err
+	}
+
+	resp, err := bucket.Head(filepath.Join(storage.prefix, path))
+	if err == nil && resp == nil {
+		err = fmt.Errorf("empty response")
+	}
+	if err != nil {
+		return aptly.PublishedFileInfo{}, fmt.Errorf("error getting information about %s in %s: %s", path, storage, err)
+	}
+	if resp.Body != nil {
+		resp.Body.Close()
+	}
+	if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+		return aptly.PublishedFileInfo{}, fmt.Errorf("error getting information about %s in %s: unexpected response %s",
+			path, storage, resp.Status)
+	}
+
+	info := aptly.PublishedFileInfo{
+		Size: resp.ContentLength,
+		ETag: resp.Header.Get("ETag"),
+	}
+	info.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return info, nil
+}
+
+// ReadRange opens published file for reading length bytes starting at offset,
+// negative length means reading till the end of file
+func (storage *PublishedStorage) ReadRange(path string, offset, length int64) (io.ReadCloser, error) {
+	headers := map[string][]string{}
+	if length >= 0 {
+		headers["Range"] = []string{fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)}
+	} else if offset > 0 {
+		headers["Range"] = []string{fmt.Sprintf("bytes=%d-", offset)}
+	}
+
+	bucket, err := storage.getBucket()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := bucket.GetResponseWithHeaders(filepath.Join(storage.prefix, path), headers)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s from %s: %s", path, storage, err)
+	}
+
+	return resp.Body, nil
+}
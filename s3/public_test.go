@@ -1,12 +1,21 @@
 package s3
 
 import (
+	"fmt"
 	"github.com/mitchellh/goamz/aws"
 	"github.com/mitchellh/goamz/s3/s3test"
+	"github.com/smira/aptly/aptly"
 	"github.com/smira/aptly/files"
+	"golang.org/x/net/context"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
   . "gopkg.in/check.v1"
 )
@@ -40,11 +49,93 @@ func (s *PublishedStorageSuite) TearDownTest(c *C) {
 }
 
 func (s *PublishedStorageSuite) TestNewPublishedStorage(c *C) {
-	stor, err := NewPublishedStorage("aa", "bbb", "", "", "", "", "", "", false)
+	stor, err := NewPublishedStorage("aa", "bbb", "", "", "", "", "", "", "", "", false, false, false)
 	c.Check(stor, IsNil)
 	c.Check(err, ErrorMatches, "unknown region: .*")
 }
 
+func (s *PublishedStorageSuite) TestNewPublishedStorageEndpoint(c *C) {
+	// MinIO-style endpoint: host:port without TLS, path-style addressing, no region
+	endpoint := strings.TrimPrefix(s.srv.URL(), "http://")
+
+	stor, err := NewPublishedStorage("aa", "bb", "", "", endpoint, "test", "", "", "", "", false, true, true)
+	c.Assert(err, IsNil)
+	c.Check(stor.s3.Region, DeepEquals, aws.Region{Name: "us-east-1", S3Endpoint: "http://" + endpoint})
+	c.Check(stor.String(), Equals, "S3: us-east-1:test/")
+
+	dir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "a"), []byte("hello"), 0644), IsNil)
+	c.Assert(stor.PutFile("a/b.txt", filepath.Join(dir, "a")), IsNil)
+
+	data, err := s.storage.bucket.Get("a/b.txt")
+	c.Check(err, IsNil)
+	c.Check(data, DeepEquals, []byte("hello"))
+
+	list, err := stor.Filelist("a")
+	c.Check(err, IsNil)
+	c.Check(list, DeepEquals, []string{"b.txt"})
+}
+
+func (s *PublishedStorageSuite) TestCustomRegion(c *C) {
+	region, err := CustomRegion("", "minio.local:9000", true, true)
+	c.Check(err, IsNil)
+	c.Check(region, DeepEquals, aws.Region{Name: "us-east-1", S3Endpoint: "http://minio.local:9000"})
+
+	region, err = CustomRegion("", "minio.local:9000", true, false)
+	c.Check(err, IsNil)
+	c.Check(region, DeepEquals, aws.Region{Name: "us-east-1", S3Endpoint: "https://minio.local:9000"})
+
+	region, err = CustomRegion("default", "https://rgw.example.com/", false, false)
+	c.Check(err, IsNil)
+	c.Check(region, DeepEquals,
+		aws.Region{Name: "default", S3Endpoint: "https://rgw.example.com", S3BucketEndpoint: "https://${bucket}.rgw.example.com"})
+
+	region, err = CustomRegion("", "http://rgw.example.com", false, false)
+	c.Check(err, IsNil)
+	c.Check(region, DeepEquals,
+		aws.Region{Name: "us-east-1", S3Endpoint: "http://rgw.example.com", S3BucketEndpoint: "http://${bucket}.rgw.example.com"})
+
+	region, err = CustomRegion("", "http://rgw.example.com", false, true)
+	c.Check(err, IsNil)
+	c.Check(region.S3Endpoint, Equals, "http://rgw.example.com")
+
+	_, err = CustomRegion("", "https://rgw.example.com", false, true)
+	c.Check(err, ErrorMatches, "disableSSL conflicts with endpoint https://rgw.example.com")
+}
+
+func (s *PublishedStorageSuite) TestCheck(c *C) {
+	c.Check(s.storage.Check(), IsNil)
+	c.Check(s.prefixedStorage.Check(), IsNil)
+
+	auth, _ := aws.GetAuth("aa", "bb")
+	broken, err := NewPublishedStorageRaw(auth, aws.Region{Name: "test-1", S3Endpoint: s.srv.URL(), S3LocationConstraint: true}, "nosuchbucket", "", "", "", "", false)
+	c.Assert(err, IsNil)
+	c.Check(broken.Check(), ErrorMatches, "error accessing S3: test-1:nosuchbucket/: .*")
+}
+
+func (s *PublishedStorageSuite) TestContextCancelled(c *C) {
+	release := make(chan struct{})
+	stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer stalled.Close()
+	defer close(release)
+
+	auth, _ := aws.GetAuth("aa", "bb")
+	storage, err := NewPublishedStorageRaw(auth, aws.Region{Name: "test-1", S3Endpoint: stalled.URL, S3LocationConstraint: true}, "test", "", "", "", "", false)
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	storage.SetContext(ctx)
+
+	start := time.Now()
+	c.Check(storage.Check(), ErrorMatches, "error accessing S3: .*")
+	c.Check(time.Since(start) < 5*time.Second, Equals, true)
+
+	c.Check(storage.Remove("a/b.txt"), ErrorMatches, "error deleting a/b.txt from .*: S3 request cancelled: context deadline exceeded")
+}
+
 func (s *PublishedStorageSuite) TestPutFile(c *C) {
 	dir := c.MkDir()
 	err := ioutil.WriteFile(filepath.Join(dir, "a"), []byte("welcome to s3!"), 0644)
@@ -108,6 +199,34 @@ func (s *PublishedStorageSuite) TestFilelist(c *C) {
 	c.Check(list, DeepEquals, []string{"a", "b", "c"})
 }
 
+func (s *PublishedStorageSuite) TestFilelistPrefixesPaging(c *C) {
+	paths := []string{"lala/a", "lala/b", "lala/c", "lala/d/e", "lala/d/f", "lalaland/a", "lalaland/b", "lal", "other/a"}
+	for _, path := range paths {
+		err := s.storage.bucket.Put(path, []byte("test"), "binary/octet-stream", "private")
+		c.Check(err, IsNil)
+	}
+
+	// 2 keys per page, so that listing spans several pages
+	s.storage.pageSize = 2
+	s.prefixedStorage.pageSize = 2
+
+	list, err := s.prefixedStorage.Filelist("")
+	c.Check(err, IsNil)
+	c.Check(list, DeepEquals, []string{"a", "b", "c", "d/e", "d/f"})
+
+	list, err = s.prefixedStorage.Filelist("d")
+	c.Check(err, IsNil)
+	c.Check(list, DeepEquals, []string{"e", "f"})
+
+	list, err = s.storage.Filelist("lalaland")
+	c.Check(err, IsNil)
+	c.Check(list, DeepEquals, []string{"a", "b"})
+
+	list, err = s.storage.Filelist("")
+	c.Check(err, IsNil)
+	c.Check(list, DeepEquals, []string{"lal", "lala/a", "lala/b", "lala/c", "lala/d/e", "lala/d/f", "lalaland/a", "lalaland/b", "other/a"})
+}
+
 func (s *PublishedStorageSuite) TestRemove(c *C) {
 	err := s.storage.bucket.Put("a/b", []byte("test"), "binary/octet-stream", "private")
 	c.Check(err, IsNil)
@@ -117,6 +236,11 @@ func (s *PublishedStorageSuite) TestRemove(c *C) {
 
 	_, err = s.storage.bucket.Get("a/b")
 	c.Check(err, ErrorMatches, "The specified key does not exist.")
+
+	// missing key is either ignored or reported as missing
+	err = s.storage.Remove("a/b")
+	c.Check(err == nil || s.storage.IsNotExist(err), Equals, true)
+	c.Check(s.storage.IsNotExist(fmt.Errorf("error deleting a/b")), Equals, false)
 }
 
 func (s *PublishedStorageSuite) TestRemoveDirs(c *C) {
@@ -137,8 +261,53 @@ func (s *PublishedStorageSuite) TestRemoveDirs(c *C) {
 
 }
 
+func (s *PublishedStorageSuite) TestPutFileMetadata(c *C) {
+	s.storage.SetMetadata(map[string]string{"Snapshot": "wheezy-main"})
+
+	dir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "a"), []byte("welcome to s3!"), 0644)
+	c.Assert(err, IsNil)
+
+	err = s.storage.PutFile("a/b.txt", filepath.Join(dir, "a"))
+	c.Assert(err, IsNil)
+
+	resp, err := s.storage.bucket.Head("a/b.txt")
+	c.Assert(err, IsNil)
+	c.Check(resp.Header.Get("X-Amz-Meta-Snapshot"), Equals, "wheezy-main")
+	c.Check(resp.Header.Get("X-Amz-Meta-Aptly-Version"), Equals, aptly.Version)
+
+	published, err := time.Parse(time.RFC3339, resp.Header.Get("X-Amz-Meta-Aptly-Published"))
+	c.Check(err, IsNil)
+	c.Check(time.Since(published) < time.Minute, Equals, true)
+}
+
 func (s *PublishedStorageSuite) TestRenameFile(c *C) {
-	c.Skip("copy not available in s3test")
+	// copy is not available in s3test, so copy request is verified directly
+	var copyHeaders http.Header
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" && r.Header.Get("X-Amz-Copy-Source") != "" {
+			copyHeaders = r.Header
+			fmt.Fprint(w, "<CopyObjectResult><ETag>\"x\"</ETag></CopyObjectResult>")
+			return
+		}
+		if r.Method == "DELETE" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	auth, _ := aws.GetAuth("aa", "bb")
+	stor, err := NewPublishedStorageRaw(auth, aws.Region{Name: "test-1", S3Endpoint: srv.URL}, "test", "", "", "", "", false)
+	c.Assert(err, IsNil)
+
+	c.Assert(stor.RenameFile("a/old.txt", "a/new.txt"), IsNil)
+	c.Assert(copyHeaders, NotNil)
+	c.Check(copyHeaders.Get("X-Amz-Copy-Source"), Matches, ".*test/a/old.txt")
+	// metadata is copied along with object unless directive is REPLACE
+	c.Check(copyHeaders.Get("X-Amz-Metadata-Directive"), Not(Equals), "REPLACE")
 }
 
 func (s *PublishedStorageSuite) TestLinkFromPool(c *C) {
@@ -191,3 +360,137 @@ func (s *PublishedStorageSuite) TestLinkFromPool(c *C) {
 	c.Check(err, IsNil)
 	c.Check(data, DeepEquals, []byte("Spam"))
 }
+
+// countingProxy forwards requests to s3test server, counting them by method
+func (s *PublishedStorageSuite) countingProxy(c *C) (*httptest.Server, map[string]int) {
+	target, err := url.Parse(s.srv.URL())
+	c.Assert(err, IsNil)
+
+	counts := map[string]int{}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counts[r.Method]++
+		proxy.ServeHTTP(w, r)
+	})), counts
+}
+
+func (s *PublishedStorageSuite) TestLinkFromPoolPrescan(c *C) {
+	root := c.MkDir()
+	pool := files.NewPackagePool(root)
+
+	sourcePath := filepath.Join(root, "pool/c1/df/mars-invaders_1.03.deb")
+	c.Assert(os.MkdirAll(filepath.Dir(sourcePath), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(sourcePath, []byte("Contents"), 0644), IsNil)
+
+	sourcePath2 := filepath.Join(root, "pool/e9/df/mars-invaders_1.04.deb")
+	c.Assert(os.MkdirAll(filepath.Dir(sourcePath2), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(sourcePath2, []byte("Spam"), 0644), IsNil)
+
+	c.Assert(s.storage.bucket.Put("pool/main/m/mars-invaders/mars-invaders_1.03.deb", []byte("Contents"), "binary/octet-stream", "private"), IsNil)
+
+	srv, counts := s.countingProxy(c)
+	defer srv.Close()
+
+	auth, _ := aws.GetAuth("aa", "bb")
+	stor, err := NewPublishedStorageRaw(auth, aws.Region{Name: "test-1", S3Endpoint: srv.URL, S3LocationConstraint: true}, "test", "", "", "", "", false)
+	c.Assert(err, IsNil)
+	stor.SetPrescanPool(true)
+
+	// matching file is skipped, pool is listed once
+	err = stor.LinkFromPool(filepath.Join("", "pool", "main", "m/mars-invaders"), pool, sourcePath, "c1df1da7a1ce305a3b60af9d5733ac1d", false)
+	c.Check(err, IsNil)
+	c.Check(counts, DeepEquals, map[string]int{"GET": 1})
+
+	// missing file is uploaded without requesting it first
+	err = stor.LinkFromPool(filepath.Join("", "pool", "main", "m/mars-invaders"), pool, sourcePath2, "e9dfd31cc505d51fc26975250750deab", false)
+	c.Check(err, IsNil)
+	c.Check(counts, DeepEquals, map[string]int{"GET": 1, "PUT": 1})
+
+	data, err := s.storage.bucket.Get("pool/main/m/mars-invaders/mars-invaders_1.04.deb")
+	c.Check(err, IsNil)
+	c.Check(data, DeepEquals, []byte("Spam"))
+
+	// uploaded file is recorded in listing
+	err = stor.LinkFromPool(filepath.Join("", "pool", "main", "m/mars-invaders"), pool, sourcePath2, "e9dfd31cc505d51fc26975250750deab", false)
+	c.Check(err, IsNil)
+	c.Check(counts, DeepEquals, map[string]int{"GET": 1, "PUT": 1})
+
+	// mismatch falls back to full comparison
+	c.Assert(s.storage.bucket.Put("pool/main/m/mars-invaders/mars-invaders_1.03.deb", []byte("Spam"), "binary/octet-stream", "private"), IsNil)
+	stor.ResetCache()
+
+	err = stor.LinkFromPool(filepath.Join("", "pool", "main", "m/mars-invaders"), pool, sourcePath, "c1df1da7a1ce305a3b60af9d5733ac1d", false)
+	c.Check(err, ErrorMatches, ".*file already exists and is different.*")
+	c.Check(counts, DeepEquals, map[string]int{"GET": 2, "PUT": 1, "HEAD": 1})
+}
+
+func (s *PublishedStorageSuite) benchmarkLinkFromPool(c *C, prescan bool) {
+	root := c.MkDir()
+	pool := files.NewPackagePool(root)
+
+	sourcePath := filepath.Join(root, "pool/c1/df/mars-invaders_1.03.deb")
+	c.Assert(os.MkdirAll(filepath.Dir(sourcePath), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(sourcePath, []byte("Contents"), 0644), IsNil)
+
+	dirs := make([]string, 100)
+	for i := range dirs {
+		dirs[i] = filepath.Join("pool", "main", "m", fmt.Sprintf("mars-invaders%d", i))
+		c.Assert(s.storage.LinkFromPool(dirs[i], pool, sourcePath, "c1df1da7a1ce305a3b60af9d5733ac1d", false), IsNil)
+	}
+
+	s.storage.SetPrescanPool(prescan)
+
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		s.storage.ResetCache()
+		for _, dir := range dirs {
+			s.storage.LinkFromPool(dir, pool, sourcePath, "c1df1da7a1ce305a3b60af9d5733ac1d", false)
+		}
+	}
+}
+
+func (s *PublishedStorageSuite) BenchmarkLinkFromPool(c *C) {
+	s.benchmarkLinkFromPool(c, false)
+}
+
+func (s *PublishedStorageSuite) BenchmarkLinkFromPoolPrescan(c *C) {
+	s.benchmarkLinkFromPool(c, true)
+}
+
+func (s *PublishedStorageSuite) TestStatReadRange(c *C) {
+	dir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "a"), []byte("welcome to s3!"), 0644)
+	c.Assert(err, IsNil)
+
+	err = s.prefixedStorage.PutFile("a/b.txt", filepath.Join(dir, "a"))
+	c.Assert(err, IsNil)
+
+	info, err := s.prefixedStorage.Stat("a/b.txt")
+	c.Assert(err, IsNil)
+	c.Check(info.Size, Equals, int64(14))
+
+	reader, err := s.prefixedStorage.ReadRange("a/b.txt", 0, -1)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(reader)
+	reader.Close()
+	c.Check(err, IsNil)
+	c.Check(data, DeepEquals, []byte("welcome to s3!"))
+
+	_, err = s.storage.Stat("a/b.txt")
+	c.Check(err, ErrorMatches, "error getting information about a/b.txt in .*")
+}
+
+func (s *PublishedStorageSuite) TestStatUnexpectedResponse(c *C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	auth, _ := aws.GetAuth("aa", "bb")
+	stor, err := NewPublishedStorageRaw(auth, aws.Region{Name: "test-1", S3Endpoint: srv.URL}, "test", "", "", "", "", false)
+	c.Assert(err, IsNil)
+
+	_, err = stor.Stat("a/b.txt")
+	c.Check(err, ErrorMatches, "error getting information about a/b.txt in .*: unexpected response 204.*")
+}
@@ -6,6 +6,7 @@ import string
 import os
 import inspect
 import shutil
+import tempfile
 
 try:
     import requests
@@ -37,7 +38,8 @@ class APITest(BaseTest):
         pass
 
     def get(self, uri, *args, **kwargs):
-        return requests.get("http://%s%s" % (self.base_url, uri), *args, **kwargs)
+        base_url = kwargs.pop("base_url", self.base_url)
+        return requests.get("http://%s%s" % (base_url, uri), *args, **kwargs)
 
     def post(self, uri, *args, **kwargs):
         if "json" in kwargs:
@@ -45,7 +47,8 @@ class APITest(BaseTest):
             if not "headers" in kwargs:
                 kwargs["headers"] = {}
             kwargs["headers"]["Content-Type"] = "application/json"
-        return requests.post("http://%s%s" % (self.base_url, uri), *args, **kwargs)
+        base_url = kwargs.pop("base_url", self.base_url)
+        return requests.post("http://%s%s" % (base_url, uri), *args, **kwargs)
 
     def put(self, uri, *args, **kwargs):
         if "json" in kwargs:
@@ -53,7 +56,8 @@ class APITest(BaseTest):
             if not "headers" in kwargs:
                 kwargs["headers"] = {}
             kwargs["headers"]["Content-Type"] = "application/json"
-        return requests.put("http://%s%s" % (self.base_url, uri), *args, **kwargs)
+        base_url = kwargs.pop("base_url", self.base_url)
+        return requests.put("http://%s%s" % (base_url, uri), *args, **kwargs)
 
     def delete(self, uri, *args, **kwargs):
         if "json" in kwargs:
@@ -61,11 +65,13 @@ class APITest(BaseTest):
             if not "headers" in kwargs:
                 kwargs["headers"] = {}
             kwargs["headers"]["Content-Type"] = "application/json"
-        return requests.delete("http://%s%s" % (self.base_url, uri), *args, **kwargs)
+        base_url = kwargs.pop("base_url", self.base_url)
+        return requests.delete("http://%s%s" % (base_url, uri), *args, **kwargs)
 
     def upload(self, uri, *filenames, **kwargs):
         upload_name = kwargs.pop("upload_name", None)
         directory = kwargs.pop("directory", "files")
+        base_url = kwargs.pop("base_url", self.base_url)
         assert kwargs == {}
 
         files = {}
@@ -78,7 +84,32 @@ class APITest(BaseTest):
                 upload_filename = filename
             files[upload_filename] = (upload_filename, fp)
 
-        return self.post(uri, files=files)
+        return self.post(uri, files=files, base_url=base_url)
+
+    def start_isolated_server(self, listen, configOverride={}):
+        """
+        starts additional API server with its own root directory and config,
+        so that it doesn't share database with the main API server
+
+        requests are sent to isolated server with base_url=listen
+        """
+        root = tempfile.mkdtemp()
+        config = self.configFile.copy()
+        config.update(**configOverride)
+        config["rootDir"] = os.path.join(root, ".aptly")
+        config_path = os.path.join(root, "aptly.conf")
+        with open(config_path, "w") as f:
+            f.write(json.dumps(config))
+
+        server = self._start_process("aptly -config=%s api serve -listen=%s" % (config_path, listen))
+        time.sleep(1)
+
+        return server, root
+
+    def stop_isolated_server(self, server, root):
+        server.terminate()
+        server.wait()
+        shutil.rmtree(root)
 
     @classmethod
     def shutdown_class(cls):
@@ -8,6 +8,7 @@ import json
 import subprocess
 import os
 import posixpath
+import re
 import shlex
 import shutil
 import string
@@ -278,6 +279,10 @@ class BaseTest(object):
             raise Exception("content doesn't match:\n" + diff)
 
     def verify_match(self, a, b, match_prepare=None):
+        # creation & modification timestamps are different on each run
+        a = re.sub(r"^(Created At|Last Modified): .*\n", "", a, flags=re.M)
+        b = re.sub(r"^(Created At|Last Modified): .*\n", "", b, flags=re.M)
+
         if match_prepare is not None:
             a = match_prepare(a)
             b = match_prepare(b)
@@ -11,3 +11,29 @@ class VersionTest(BaseTest):
     """
 
     runCmd = "aptly version"
+
+
+class VersionCompare1Test(BaseTest):
+    """
+    version compare: relation holds
+    """
+
+    runCmd = "aptly version compare 1:0.9 gt 1.0~rc1-1"
+
+
+class VersionCompare2Test(BaseTest):
+    """
+    version compare: relation doesn't hold
+    """
+
+    runCmd = "aptly version compare 1.0~rc1 ge 1.0"
+    expectedCode = 1
+
+
+class VersionCompare3Test(BaseTest):
+    """
+    version compare: unknown relation
+    """
+
+    runCmd = "aptly version compare 1.0 lt-nl 1.1"
+    expectedCode = 2
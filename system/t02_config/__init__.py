@@ -63,3 +63,20 @@ class ConfigShowTest(BaseTest):
     """
     runCmd = ["aptly", "config", "show"]
     gold_processor = BaseTest.expand_environ
+
+
+class ConfigValidateTest(BaseTest):
+    """
+    config validation: no endpoints, signing disabled
+    """
+    runCmd = ["aptly", "config", "validate"]
+    configOverride = {"gpgDisableSign": True}
+
+
+class ConfigValidateStrictTest(BaseTest):
+    """
+    config validation: warnings fail in strict mode
+    """
+    runCmd = ["aptly", "config", "validate", "-strict"]
+    configOverride = {"gpgDisableSign": True}
+    expectedCode = 1
@@ -10,3 +10,4 @@ from .drop import *
 from .rename import *
 from .edit import *
 from .search import *
+from .verify import *
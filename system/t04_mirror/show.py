@@ -36,3 +36,12 @@ class ShowMirror4Test(BaseTest):
     ]
     runCmd = "aptly mirror show mirror4"
     outputMatchPrepare = lambda _, s: re.sub(r"(Date|Valid-Until): [,0-9:+A-Za-z -]+\n", "", s)
+
+
+class ShowMirror5Test(BaseTest):
+    """
+    show mirror: packages matching query
+    """
+    fixtureDB = True
+    runCmd = "aptly mirror show -query='cltl | crafty-books-medium' wheezy-contrib"
+    outputMatchPrepare = lambda _, s: re.sub(r"Last update: [0-9:+A-Za-z -]+\n", "", s)
@@ -1,6 +1,7 @@
 import string
 import re
-from lib import BaseTest
+import time
+from lib import BaseTest, FileHTTPServerRequestHandler
 
 
 class UpdateMirror1Test(BaseTest):
@@ -172,3 +173,55 @@ class UpdateMirror12Test(BaseTest):
 
     def output_processor(self, output):
         return "\n".join(sorted(output.split("\n")))
+
+
+class UpdateMirror13Test(BaseTest):
+    """
+    update mirrors: upstream with uncompressed indexes only, published with compression
+    """
+    fixtureCmds = [
+        "aptly mirror create --ignore-signatures uncompressed ${url} hardy main",
+    ]
+    fixtureWebServer = "test_release2"
+    runCmd = "aptly mirror update -ignore-checksums --ignore-signatures uncompressed"
+
+    def gold_processor(self, gold):
+        return string.Template(gold).substitute({'url': self.webServerUrl})
+
+    def check(self):
+        super(UpdateMirror13Test, self).check()
+
+        self.run_cmd("aptly snapshot create uncompressed from mirror uncompressed")
+        self.run_cmd("aptly publish snapshot -skip-signing uncompressed")
+
+        self.check_exists('public/dists/hardy/main/binary-amd64/Packages')
+        self.check_exists('public/dists/hardy/main/binary-amd64/Packages.gz')
+        self.check_exists('public/dists/hardy/main/binary-amd64/Packages.bz2')
+        self.check_file_not_empty('public/dists/hardy/main/binary-amd64/Packages.gz')
+
+
+class StalledHTTPServerRequestHandler(FileHTTPServerRequestHandler):
+    def do_GET(self):
+        # never respond, so that download hangs until client gives up
+        time.sleep(5)
+
+
+class UpdateMirror14Test(BaseTest):
+    """
+    update mirrors: server stalls, aborted on -timeout
+    """
+    fixtureCmds = [
+        "aptly mirror create --ignore-signatures stalled ${url} hardy main",
+    ]
+    fixtureWebServer = "test_release2"
+    runCmd = "aptly -timeout=1s mirror update --ignore-signatures stalled"
+    expectedCode = 3
+
+    def prepare(self):
+        super(UpdateMirror14Test, self).prepare()
+
+        self.webserver.daemon_threads = True
+        self.webserver.RequestHandlerClass = StalledHTTPServerRequestHandler
+
+    def gold_processor(self, gold):
+        return string.Template(gold).substitute({'url': self.webServerUrl})
@@ -0,0 +1,71 @@
+import os
+import re
+from lib import BaseTest
+
+
+def find_pool_file(name):
+    for root, _, files in os.walk(os.path.join(os.environ["HOME"], ".aptly", "pool")):
+        if name in files:
+            return os.path.join(root, name)
+    raise Exception("file %s not found in pool" % name)
+
+
+class VerifyMirror1Test(BaseTest):
+    """
+    verify mirror: missing mirror
+    """
+    runCmd = "aptly mirror verify mirror-xx"
+    expectedCode = 1
+
+
+class VerifyMirror2Test(BaseTest):
+    """
+    verify mirror: all files are correct
+    """
+    fixtureDB = True
+    fixturePool = True
+    runCmd = "aptly mirror verify gnuplot-maverick"
+    outputMatchPrepare = lambda _, s: re.sub(r'All \d+ files', 'All files', s)
+
+
+class VerifyMirror3Test(BaseTest):
+    """
+    verify mirror: corrupted and missing files
+    """
+    fixtureDB = True
+    fixturePoolCopy = True
+    runCmd = "aptly mirror verify gnuplot-maverick"
+    expectedCode = 1
+    outputMatchPrepare = lambda _, s: re.sub(r'out of \d+', 'out of N', s)
+
+    def prepare(self):
+        super(VerifyMirror3Test, self).prepare()
+
+        path = find_pool_file("gnuplot-doc_4.6.1-1~maverick2_all.deb")
+        with open(path, "r+b") as f:
+            f.seek(100)
+            b = f.read(1)
+            f.seek(100)
+            f.write(chr(ord(b) ^ 0xff))
+
+        os.remove(find_pool_file("gnuplot-nox_4.6.1-1~maverick2_i386.deb"))
+
+
+class VerifyMirror4Test(BaseTest):
+    """
+    verify mirror: quick verification doesn't detect corrupted file
+    """
+    fixtureDB = True
+    fixturePoolCopy = True
+    runCmd = "aptly mirror verify -quick gnuplot-maverick"
+    outputMatchPrepare = lambda _, s: re.sub(r'All \d+ files', 'All files', s)
+
+    def prepare(self):
+        super(VerifyMirror4Test, self).prepare()
+
+        path = find_pool_file("gnuplot-doc_4.6.1-1~maverick2_all.deb")
+        with open(path, "r+b") as f:
+            f.seek(100)
+            b = f.read(1)
+            f.seek(100)
+            f.write(chr(ord(b) ^ 0xff))
@@ -12,4 +12,6 @@ from .merge import *
 from .drop import *
 from .rename import *
 from .search import *
-from .filter import *
\ No newline at end of file
+from .filter import *
+from .export import *
+from .edit import *
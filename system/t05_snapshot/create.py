@@ -107,3 +107,21 @@ class CreateSnapshot9Test(BaseTest):
     ]
     runCmd = "aptly snapshot create snap9 from repo local-repo"
     expectedCode = 1
+
+
+class CreateSnapshot10Test(BaseTest):
+    """
+    create snapshot: from repo, latest versions only
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}"
+    ]
+    runCmd = "aptly snapshot create -latest-only snap10 from repo local-repo"
+
+    def check(self):
+        def remove_created_at(s):
+            return re.sub(r"Created At: [0-9:A-Za-z -]+\n", "", s)
+
+        self.check_output()
+        self.check_cmd_output("aptly snapshot show -with-packages snap10", "snapshot_show", match_prepare=remove_created_at)
@@ -92,3 +92,87 @@ class DropSnapshot7Test(BaseTest):
         "aptly publish drop maverick",
     ]
     runCmd = "aptly snapshot drop snap1"
+
+
+class DropSnapshot8Test(BaseTest):
+    """
+    drop snapshot: pinned
+    """
+    fixtureDB = True
+    fixtureCmds = [
+        "aptly snapshot create snap1 from mirror wheezy-non-free",
+        "aptly snapshot edit -pin snap1",
+    ]
+    runCmd = "aptly snapshot drop snap1"
+    expectedCode = 1
+
+
+class DropSnapshot9Test(BaseTest):
+    """
+    drop snapshot: pinned, then unpinned
+    """
+    fixtureDB = True
+    fixtureCmds = [
+        "aptly snapshot create snap1 from mirror wheezy-non-free",
+        "aptly snapshot edit -pin snap1",
+        "aptly snapshot edit -unpin snap1",
+    ]
+    runCmd = "aptly snapshot drop snap1"
+
+    def check(self):
+        self.check_output()
+        self.check_cmd_output("aptly snapshot show snap1", "snapshot_show", expected_code=1)
+
+
+class DropSnapshot10Test(BaseTest):
+    """
+    drop snapshot: pinned with -force
+    """
+    fixtureDB = True
+    fixtureCmds = [
+        "aptly snapshot create snap1 from mirror wheezy-non-free",
+        "aptly snapshot edit -pin snap1",
+    ]
+    runCmd = "aptly snapshot drop -force snap1"
+
+
+class DropSnapshot11Test(BaseTest):
+    """
+    drop snapshot: published, drop publications as well
+    """
+    fixtureDB = True
+    fixturePool = True
+    fixtureCmds = [
+        "aptly snapshot create snap1 from mirror gnuplot-maverick",
+        "aptly publish snapshot -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec snap1",
+        "aptly publish snapshot -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec snap1 ppa",
+    ]
+    runCmd = "aptly snapshot drop -force-drop-publications snap1"
+    gold_processor = BaseTest.expand_environ
+
+    def check(self):
+        self.check_output()
+        self.check_cmd_output("aptly publish list", "publish_list")
+        self.check_not_exists('public/dists/')
+        self.check_not_exists('public/pool/')
+        self.check_not_exists('public/ppa/dists/')
+        self.check_not_exists('public/ppa/pool/')
+
+
+class DropSnapshot12Test(BaseTest):
+    """
+    drop snapshot: published & pinned, publications are kept
+    """
+    fixtureDB = True
+    fixturePool = True
+    fixtureCmds = [
+        "aptly snapshot create snap1 from mirror gnuplot-maverick",
+        "aptly snapshot edit -pin snap1",
+        "aptly publish snapshot -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec snap1",
+    ]
+    runCmd = "aptly snapshot drop -force-drop-publications snap1"
+    expectedCode = 1
+
+    def check(self):
+        self.check_output()
+        self.check_exists('public/dists/maverick/Release')
@@ -0,0 +1,35 @@
+import re
+
+from lib import BaseTest
+
+
+class EditSnapshot1Test(BaseTest):
+    """
+    edit snapshot: pin
+    """
+    fixtureDB = True
+    fixtureCmds = ["aptly snapshot create snap1 from mirror wheezy-non-free"]
+    runCmd = "aptly snapshot edit -pin snap1"
+
+    def check(self):
+        self.check_output()
+        self.check_cmd_output("aptly snapshot show snap1", "snapshot_show",
+                              match_prepare=lambda s: re.sub(r"Created At: [0-9:A-Za-z -]+\n", "", s))
+
+
+class EditSnapshot2Test(BaseTest):
+    """
+    edit snapshot: -pin and -unpin together
+    """
+    fixtureDB = True
+    fixtureCmds = ["aptly snapshot create snap1 from mirror wheezy-non-free"]
+    runCmd = "aptly snapshot edit -pin -unpin snap1"
+    expectedCode = 1
+
+
+class EditSnapshot3Test(BaseTest):
+    """
+    edit snapshot: no such snapshot
+    """
+    runCmd = "aptly snapshot edit -pin no-such-snapshot"
+    expectedCode = 1
@@ -0,0 +1,45 @@
+from lib import BaseTest
+
+
+class ExportSnapshot1Test(BaseTest):
+    """
+    export snapshot: unsigned, gzip only
+    """
+    fixtureDB = True
+    fixturePool = True
+    fixtureCmds = [
+        "aptly snapshot create snap1 from mirror gnuplot-maverick",
+    ]
+    runCmd = "aptly snapshot export -skip-signing -compression=gz snap1 ${aptlyroot}/export"
+
+    def check(self):
+        self.check_exists('export/dists/maverick/Release')
+        self.check_not_exists('export/dists/maverick/Release.gpg')
+
+        self.check_exists('export/dists/maverick/main/binary-i386/Packages')
+        self.check_exists('export/dists/maverick/main/binary-i386/Packages.gz')
+        self.check_not_exists('export/dists/maverick/main/binary-i386/Packages.bz2')
+        self.check_exists('export/dists/maverick/main/binary-amd64/Packages')
+
+        self.check_exists('export/pool/main/g/gnuplot/gnuplot-doc_4.6.1-1~maverick2_all.deb')
+
+        packages = self.read_file('export/dists/maverick/main/binary-amd64/Packages')
+        if 'Filename: pool/main/g/gnuplot/gnuplot-doc_4.6.1-1~maverick2_all.deb' not in packages:
+            raise Exception("package missing from Packages index")
+
+        # snapshot isn't published
+        self.check_cmd_output("aptly publish list -raw", "publish_list")
+
+
+class ExportSnapshot2Test(BaseTest):
+    """
+    export snapshot: non-empty directory
+    """
+    fixtureDB = True
+    fixtureCmds = [
+        "aptly snapshot create snap1 from mirror gnuplot-maverick",
+        "mkdir -p ${aptlyroot}/export/dists",
+    ]
+    runCmd = "aptly snapshot export -skip-signing snap1 ${aptlyroot}/export"
+    expectedCode = 1
+    gold_processor = BaseTest.expand_environ
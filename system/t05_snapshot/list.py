@@ -92,3 +92,45 @@ class ListSnapshot7Test(BaseTest):
     ]
     runCmd = "aptly -sort=planet snapshot list"
     expectedCode = 1
+
+
+class ListSnapshot8Test(BaseTest):
+    """
+    list snapshots: filtered by label
+    """
+    fixtureCmds = [
+        "aptly snapshot create -label=env=prod snap1 empty",
+        "aptly snapshot create -label=env=dev snap2 empty",
+        "aptly snapshot create snap3 empty",
+        "aptly snapshot edit -label=env=prod snap3",
+    ]
+    runCmd = "aptly snapshot list -label=env=prod"
+
+
+class ListSnapshot9Test(BaseTest):
+    """
+    list snapshots: only published
+    """
+    fixtureCmds = [
+        "aptly snapshot create snap1 empty",
+        "aptly snapshot create snap2 empty",
+        "aptly snapshot create snap3 empty",
+        "aptly -architectures=i386 publish snapshot -skip-signing -distribution=wheezy snap2",
+    ]
+    runCmd = "aptly snapshot list -published"
+
+
+class ListSnapshot10Test(BaseTest):
+    """
+    list snapshots: only unpublished
+    """
+    fixtureCmds = ListSnapshot9Test.fixtureCmds
+    runCmd = "aptly snapshot list -raw -unpublished"
+
+
+class ListSnapshot11Test(BaseTest):
+    """
+    list snapshots: conflicting filters
+    """
+    runCmd = "aptly snapshot list -published -unpublished"
+    expectedCode = 1
@@ -231,3 +231,35 @@ class PullSnapshot14Test(BaseTest):
         "aptly snapshot create sensu from mirror sensu",
     ]
     runCmd = "aptly snapshot pull -architectures=amd64,i386 -all-matches empty sensu destination 'sensu (>0.12)' 'sensu (<0.9.6)'"
+
+
+class PullSnapshot15Test(BaseTest):
+    """
+    pull snapshot: dry-run preview matches real pull
+    """
+    fixtureCmds = [
+        "aptly repo create r1",
+        "aptly repo add r1 ${files}/pyspi_0.6.1-1.3.dsc",
+        "aptly snapshot create snap1 from repo r1",
+        "aptly repo create r2",
+        "aptly repo add r2 ${files}/pyspi-0.6.1-1.3.stripped.dsc ${files}/libboost-program-options-dev_1.49.0.1_i386.deb",
+        "aptly snapshot create snap2 from repo r2",
+    ]
+    runCmd = "aptly -architectures=i386,source snapshot pull -dry-run snap1 snap2 snap3 'pyspi (>= 0.6.1-1.4)' libboost-program-options-dev"
+    outputMatchPrepare = lambda _, output: "\n".join(sorted(output.split("\n")))
+
+    def check(self):
+        def remove_created_at(s):
+            return re.sub(r"Created At: [0-9:A-Za-z -]+\n", "", s)
+
+        def changes(output):
+            return sorted([l for l in output.split("\n") if l.startswith("[+]") or l.startswith("[-]")])
+
+        self.check_output()
+        self.check_cmd_output("aptly snapshot list", "snapshot_list")
+
+        preview = changes(self.output)
+        result = changes(self.run_cmd(self.runCmd.replace(" -dry-run", "")))
+        self.check_equal(result, preview)
+
+        self.check_cmd_output("aptly snapshot show -with-packages snap3", "snapshot_show", match_prepare=remove_created_at)
@@ -29,3 +29,23 @@ class ShowSnapshot3Test(BaseTest):
     fixtureCmds = ["aptly snapshot create snap1 from mirror wheezy-non-free"]
     runCmd = "aptly snapshot show snap1"
     outputMatchPrepare = lambda _, s: re.sub(r"Created At: [0-9:A-Za-z -]+\n", "", s)
+
+
+class ShowSnapshot4Test(BaseTest):
+    """
+    show snapshot: with dependency problems
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}/libboost-program-options-dev_1.49.0.1_i386.deb",
+        "aptly snapshot create snap1 from repo local-repo",
+    ]
+    runCmd = "aptly snapshot show -with-dep-problems snap1"
+
+
+class ShowSnapshot5Test(BaseTest):
+    """
+    show snapshot: no dependency problems
+    """
+    fixtureCmds = ["aptly snapshot create snap1 empty"]
+    runCmd = "aptly snapshot show -with-dep-problems snap1"
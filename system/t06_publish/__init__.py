@@ -5,6 +5,8 @@ Testing publishing snapshots
 from .drop import *
 from .list import *
 from .repo import *
+from .resign import *
+from .show import *
 from .snapshot import *
 from .switch import *
 from .update import *
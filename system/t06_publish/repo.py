@@ -646,3 +646,227 @@ class PublishRepo27Test(BaseTest):
 
         # verify contents except of sums
         self.check_file_contents('public/dists/maverick/main/debian-installer/binary-i386/Packages', 'udeb_binary', match_prepare=lambda s: "\n".join(sorted(s.split("\n"))))
+
+
+class PublishRepo28Test(BaseTest):
+    """
+    publish repo: duplicate packages across components with -strict
+    """
+    fixtureCmds = [
+        "aptly repo create repo1",
+        "aptly repo create repo2",
+        "aptly repo add repo1 ${files}/libboost-program-options-dev_1.49.0.1_i386.deb ${files}/pyspi_0.6.1-1.3.dsc",
+        "aptly repo add repo2 ${files}/pyspi-0.6.1-1.3.stripped.dsc",
+    ]
+    runCmd = "aptly publish repo -strict -skip-signing -component=main,contrib -distribution=maverick repo1 repo2"
+    expectedCode = 1
+
+    def check(self):
+        super(PublishRepo28Test, self).check()
+
+        self.check_not_exists('public/dists/maverick/Release')
+
+
+class PublishRepo29Test(BaseTest):
+    """
+    publish repo: -override
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}",
+    ]
+    runCmd = "aptly publish repo -skip-signing -override=${testfiles}/override -distribution=maverick local-repo"
+    gold_processor = BaseTest.expand_environ
+
+    def check(self):
+        super(PublishRepo29Test, self).check()
+
+        # Sections of binary packages are rewritten, Sources are intact
+        self.check_file_contents('public/dists/maverick/main/binary-i386/Packages', 'binary', match_prepare=lambda s: "\n".join(sorted(s.split("\n"))))
+        self.check_file_contents('public/dists/maverick/main/source/Sources', 'sources', match_prepare=lambda s: "\n".join(sorted(s.split("\n"))))
+
+
+class PublishRepo30Test(BaseTest):
+    """
+    publish repo: signing key from config
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}",
+    ]
+    configOverride = {"gpgKey": "C5ACD2179B5231DFE842EE6121DBB89C16DB3E6D"}
+    runCmd = "aptly publish repo -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec -distribution=maverick local-repo"
+    gold_processor = BaseTest.expand_environ
+
+    def check(self):
+        super(PublishRepo30Test, self).check()
+
+        output = self.run_cmd(["gpg", "--no-auto-check-trustdb", "--keyring", os.path.join(os.path.dirname(inspect.getsourcefile(BaseTest)), "files", "aptly.pub"),
+                               "--verify", os.path.join(os.environ["HOME"], ".aptly", 'public/dists/maverick/InRelease')])
+        if "16DB3E6D" not in output:
+            raise Exception("InRelease is not signed with key from config: %s" % (output, ))
+
+
+class PublishRepo31Test(BaseTest):
+    """
+    publish repo: -gpg-key overrides signing key from config
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}",
+    ]
+    configOverride = {"gpgKey": "nosuchkey"}
+    runCmd = "aptly publish repo -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec -gpg-key=21DBB89C16DB3E6D -distribution=maverick local-repo"
+    gold_processor = BaseTest.expand_environ
+
+    def check(self):
+        super(PublishRepo31Test, self).check()
+
+        for path in ['public/dists/maverick/InRelease', 'public/dists/maverick/Release.gpg']:
+            args = ["gpg", "--no-auto-check-trustdb", "--keyring", os.path.join(os.path.dirname(inspect.getsourcefile(BaseTest)), "files", "aptly.pub"),
+                    "--verify", os.path.join(os.environ["HOME"], ".aptly", path)]
+            if path.endswith(".gpg"):
+                args.append(os.path.join(os.environ["HOME"], ".aptly", 'public/dists/maverick/Release'))
+            output = self.run_cmd(args)
+            if "16DB3E6D" not in output:
+                raise Exception("%s is not signed with key from -gpg-key: %s" % (path, output))
+
+
+class PublishRepo32Test(BaseTest):
+    """
+    publish repo: signing key from config doesn't exist
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}",
+    ]
+    configOverride = {"gpgKey": "nosuchkey"}
+    runCmd = "aptly publish repo -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec -distribution=maverick local-repo"
+    expectedCode = 1
+    outputMatchPrepare = lambda _, s: s.split(" in keyring:")[0]
+
+    def check(self):
+        super(PublishRepo32Test, self).check()
+
+        self.check_not_exists('public/dists/maverick/Release')
+
+
+class PublishRepo33Test(BaseTest):
+    """
+    publish repo: -pin-friendly without label
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}",
+    ]
+    runCmd = "aptly publish repo -pin-friendly -skip-signing -origin=Example -distribution=maverick local-repo"
+    expectedCode = 1
+
+    def check(self):
+        super(PublishRepo33Test, self).check()
+
+        self.check_not_exists('public/dists/maverick/Release')
+
+
+class PublishRepo34Test(BaseTest):
+    """
+    publish repo: -pin-friendly with origin and label
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}",
+    ]
+    runCmd = "aptly publish repo -pin-friendly -skip-signing -origin=Example -label='Example Tools' -distribution=maverick local-repo"
+    gold_processor = BaseTest.expand_environ
+
+    def check(self):
+        super(PublishRepo34Test, self).check()
+
+        # verify contents except of sums
+        self.check_file_contents('public/dists/maverick/Release', 'release', match_prepare=strip_processor)
+
+
+class PublishRepo35Test(BaseTest):
+    """
+    publish repo: -architectures with binary architecture and source
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}",
+    ]
+    runCmd = "aptly -architectures=i386,source publish repo -skip-signing -distribution=maverick local-repo"
+    gold_processor = BaseTest.expand_environ
+
+    def check(self):
+        super(PublishRepo35Test, self).check()
+
+        self.check_exists('public/dists/maverick/main/binary-i386/Packages')
+        self.check_exists('public/dists/maverick/main/source/Sources')
+        self.check_exists('public/pool/main/b/boost-defaults/libboost-program-options-dev_1.49.0.1_i386.deb')
+        self.check_exists('public/pool/main/p/pyspi/pyspi_0.6.1-1.3.dsc')
+
+        # verify contents except of sums
+        self.check_file_contents('public/dists/maverick/Release', 'release', match_prepare=strip_processor)
+
+
+class PublishRepo36Test(BaseTest):
+    """
+    publish repo: -architectures without source excludes source packages
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}",
+    ]
+    runCmd = "aptly -architectures=i386 publish repo -skip-signing -distribution=maverick local-repo"
+    gold_processor = BaseTest.expand_environ
+
+    def check(self):
+        super(PublishRepo36Test, self).check()
+
+        self.check_exists('public/dists/maverick/main/binary-i386/Packages')
+        self.check_not_exists('public/dists/maverick/main/source')
+        self.check_exists('public/pool/main/b/boost-defaults/libboost-program-options-dev_1.49.0.1_i386.deb')
+        self.check_not_exists('public/pool/main/p/pyspi/pyspi_0.6.1-1.3.dsc')
+
+        # verify contents except of sums
+        self.check_file_contents('public/dists/maverick/Release', 'release', match_prepare=strip_processor)
+
+
+class PublishRepo37Test(BaseTest):
+    """
+    publish repo: -architectures=source publishes only source packages
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}",
+    ]
+    runCmd = "aptly -architectures=source publish repo -skip-signing -distribution=maverick local-repo"
+    gold_processor = BaseTest.expand_environ
+
+    def check(self):
+        super(PublishRepo37Test, self).check()
+
+        self.check_not_exists('public/dists/maverick/main/binary-i386')
+        self.check_exists('public/dists/maverick/main/source/Sources')
+        self.check_not_exists('public/pool/main/b/boost-defaults/libboost-program-options-dev_1.49.0.1_i386.deb')
+        self.check_exists('public/pool/main/p/pyspi/pyspi_0.6.1-1.3.dsc')
+
+        # verify contents except of sums
+        self.check_file_contents('public/dists/maverick/Release', 'release', match_prepare=strip_processor)
+
+
+class PublishRepo38Test(BaseTest):
+    """
+    publish repo: -butautomaticupgrades without -notautomatic
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}",
+    ]
+    runCmd = "aptly publish repo -butautomaticupgrades -skip-signing -distribution=maverick local-repo"
+    expectedCode = 1
+
+    def check(self):
+        super(PublishRepo38Test, self).check()
+
+        self.check_not_exists('public/dists/maverick/Release')
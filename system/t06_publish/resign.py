@@ -0,0 +1,78 @@
+import os
+import inspect
+from lib import BaseTest
+
+
+class PublishResign1Test(BaseTest):
+    """
+    publish resign: sign with another key
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}",
+        "aptly publish repo -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec -distribution=maverick local-repo",
+    ]
+    runCmd = "aptly publish resign -keyring=${files}/aptly_passphrase.pub -secret-keyring=${files}/aptly_passphrase.sec -passphrase=verysecret maverick"
+    outputMatchPrepare = lambda _, s: s.replace("gpg: gpg-agent is not available in this session\n", "")
+
+    def prepare(self):
+        super(PublishResign1Test, self).prepare()
+
+        self.packages = self.read_file('public/dists/maverick/main/binary-i386/Packages')
+        self.release = self.read_file('public/dists/maverick/Release')
+
+    def check(self):
+        super(PublishResign1Test, self).check()
+
+        if self.read_file('public/dists/maverick/main/binary-i386/Packages') != self.packages:
+            raise Exception("Packages changed after resign")
+        if self.read_file('public/dists/maverick/Release') != self.release:
+            raise Exception("Release changed after resign")
+
+        # signatures are made with new key
+        self.run_cmd(["gpg", "--no-auto-check-trustdb", "--keyring", os.path.join(os.path.dirname(inspect.getsourcefile(BaseTest)), "files", "aptly_passphrase.pub"),
+                      "--verify", os.path.join(os.environ["HOME"], ".aptly", 'public/dists/maverick/InRelease')])
+        self.run_cmd(["gpg", "--no-auto-check-trustdb",  "--keyring", os.path.join(os.path.dirname(inspect.getsourcefile(BaseTest)), "files", "aptly_passphrase.pub"),
+                      "--verify", os.path.join(os.environ["HOME"], ".aptly", 'public/dists/maverick/Release.gpg'),
+                      os.path.join(os.environ["HOME"], ".aptly", 'public/dists/maverick/Release')])
+
+
+class PublishResign2Test(BaseTest):
+    """
+    publish resign: no such published repository
+    """
+    runCmd = "aptly publish resign -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec maverick"
+    expectedCode = 1
+
+
+class PublishResign3Test(BaseTest):
+    """
+    publish resign: set Valid-Until
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}",
+        "aptly publish repo -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec -distribution=maverick local-repo",
+    ]
+    runCmd = "aptly publish resign -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec -valid-for=168h maverick"
+
+    def prepare(self):
+        super(PublishResign3Test, self).prepare()
+
+        self.packages = self.read_file('public/dists/maverick/main/binary-i386/Packages')
+
+    def check(self):
+        super(PublishResign3Test, self).check()
+
+        if self.read_file('public/dists/maverick/main/binary-i386/Packages') != self.packages:
+            raise Exception("Packages changed after resign")
+
+        release = self.read_file('public/dists/maverick/Release')
+        if "\nValid-Until: " not in release:
+            raise Exception("Valid-Until missing in Release")
+        if release not in self.read_file('public/dists/maverick/InRelease'):
+            raise Exception("InRelease doesn't match Release")
+
+        self.run_cmd(["gpg", "--no-auto-check-trustdb", "--keyring", os.path.join(os.path.dirname(inspect.getsourcefile(BaseTest)), "files", "aptly.pub"),
+                      "--verify", os.path.join(os.environ["HOME"], ".aptly", 'public/dists/maverick/Release.gpg'),
+                      os.path.join(os.environ["HOME"], ".aptly", 'public/dists/maverick/Release')])
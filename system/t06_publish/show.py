@@ -0,0 +1,52 @@
+from lib import BaseTest
+
+
+class PublishShow1Test(BaseTest):
+    """
+    publish show: existing published repo
+    """
+    fixtureCmds = [
+        "aptly repo create repo1",
+        "aptly repo create repo2",
+        "aptly repo add repo1 ${files}/libboost-program-options-dev_1.49.0.1_i386.deb ${files}/pyspi_0.6.1-1.3.dsc",
+        "aptly repo add repo2 ${files}/pyspi-0.6.1-1.3.stripped.dsc",
+        "aptly publish repo -skip-signing -component=main,contrib -distribution=maverick repo1 repo2",
+    ]
+    runCmd = "aptly publish show maverick"
+
+
+class PublishShow2Test(BaseTest):
+    """
+    publish show: two components with packages
+    """
+    fixtureCmds = PublishShow1Test.fixtureCmds
+    runCmd = "aptly publish show -with-packages maverick"
+
+
+class PublishShow3Test(BaseTest):
+    """
+    publish show: packages matching query
+    """
+    fixtureCmds = PublishShow1Test.fixtureCmds
+    runCmd = "aptly publish show -query='Name (pyspi)' maverick ."
+
+
+class PublishShow4Test(BaseTest):
+    """
+    publish show: missing published repo
+    """
+    runCmd = "aptly publish show maverick ppa"
+    expectedCode = 1
+
+
+class PublishShow5Test(BaseTest):
+    """
+    publish show: -with-packages lists only latest versions with -latest-only
+    """
+    fixtureDB = True
+    fixturePool = True
+    fixtureCmds = [
+        "aptly snapshot create sensu from mirror sensu",
+        "aptly publish snapshot -skip-signing -latest-only -architectures=i386 -component=main -distribution=sensu sensu",
+    ]
+    runCmd = "aptly publish show -with-packages sensu"
@@ -246,7 +246,7 @@ class PublishSwitch8Test(BaseTest):
         "aptly repo remove local-repo pyspi",
         "aptly snapshot create local2 from repo local-repo",
     ]
-    runCmd = "aptly publish switch -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec -component=b,c maverick snap3 local2"
+    runCmd = "aptly publish switch -force -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec -component=b,c maverick snap3 local2"
     gold_processor = BaseTest.expand_environ
 
     def check(self):
@@ -362,7 +362,7 @@ class PublishSwitch10Test(BaseTest):
         "aptly snapshot create snap2 from repo local-repo2",
         "aptly publish snapshot -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec -distribution=maverick snap1",
     ]
-    runCmd = "aptly publish switch -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec maverick snap2"
+    runCmd = "aptly publish switch -force -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec maverick snap2"
     expectedCode = 1
     gold_processor = BaseTest.expand_environ
 
@@ -380,7 +380,7 @@ class PublishSwitch11Test(BaseTest):
         "aptly snapshot create snap2 from repo local-repo2",
         "aptly publish snapshot -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec -distribution=maverick snap1",
     ]
-    runCmd = "aptly publish switch -force-overwrite -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec maverick snap2"
+    runCmd = "aptly publish switch -force -force-overwrite -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec maverick snap2"
     gold_processor = BaseTest.expand_environ
 
     def check(self):
@@ -400,3 +400,53 @@ class PublishSwitch12Test(BaseTest):
     ]
     runCmd = "aptly publish switch -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec -component=a,c maverick snap2 snap1"
     expectedCode = 1
+
+
+class PublishSwitch13Test(BaseTest):
+    """
+    publish switch: new snapshot is missing published architecture
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo1",
+        "aptly repo add local-repo1 ${files}",
+        "aptly snapshot create snap1 from repo local-repo1",
+        "aptly repo create local-repo2",
+        "aptly repo add local-repo2 ${files}/pyspi_0.6.1-1.3.dsc",
+        "aptly snapshot create snap2 from repo local-repo2",
+        "aptly publish snapshot -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec -distribution=maverick snap1",
+    ]
+    runCmd = "aptly publish switch -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec maverick snap2"
+    expectedCode = 1
+
+    def check(self):
+        super(PublishSwitch13Test, self).check()
+
+        self.check_exists('public/pool/main/b/boost-defaults/libboost-program-options-dev_1.49.0.1_i386.deb')
+
+
+class PublishSwitch14Test(BaseTest):
+    """
+    publish switch: new snapshot is missing published architecture, -force
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo1",
+        "aptly repo add local-repo1 ${files}",
+        "aptly snapshot create snap1 from repo local-repo1",
+        "aptly repo create local-repo2",
+        "aptly repo add local-repo2 ${files}/pyspi_0.6.1-1.3.dsc",
+        "aptly snapshot create snap2 from repo local-repo2",
+        "aptly publish snapshot -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec -distribution=maverick snap1",
+    ]
+    runCmd = "aptly publish switch -force -keyring=${files}/aptly.pub -secret-keyring=${files}/aptly.sec maverick snap2"
+    gold_processor = BaseTest.expand_environ
+
+    def check(self):
+        super(PublishSwitch14Test, self).check()
+
+        self.check_exists('public/dists/maverick/main/binary-i386/Packages')
+        self.check_exists('public/dists/maverick/main/source/Sources')
+        self.check_exists('public/pool/main/p/pyspi/pyspi_0.6.1-1.3.dsc')
+        self.check_not_exists('public/pool/main/b/boost-defaults/libboost-program-options-dev_1.49.0.1_i386.deb')
+
+        if self.read_file('public/dists/maverick/main/binary-i386/Packages') != "":
+            raise Exception("binary-i386 index should be empty")
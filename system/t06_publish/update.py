@@ -309,3 +309,34 @@ class PublishUpdate10Test(BaseTest):
         super(PublishUpdate10Test, self).check()
 
         self.check_file_contents("public/pool/main/p/pyspi/pyspi_0.6.1.orig.tar.gz", "file")
+
+
+class PublishUpdate11Test(BaseTest):
+    """
+    publish update: overrides are kept from original publishing
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}",
+        "aptly publish repo -skip-signing -override=${testfiles}/override -distribution=maverick local-repo",
+    ]
+    runCmd = "aptly publish update -skip-signing maverick"
+    gold_processor = BaseTest.expand_environ
+
+    def check(self):
+        super(PublishUpdate11Test, self).check()
+
+        self.check_file_contents('public/dists/maverick/main/binary-i386/Packages', 'binary', match_prepare=lambda s: "\n".join(sorted(s.split("\n"))))
+
+
+class PublishUpdate12Test(BaseTest):
+    """
+    publish update: -pin-friendly without label
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}",
+        "aptly publish repo -skip-signing -origin=Example -distribution=maverick local-repo",
+    ]
+    runCmd = "aptly publish update -pin-friendly -skip-signing maverick"
+    expectedCode = 1
@@ -1,11 +1,13 @@
 from lib import BaseTest
+import os
+import re
 
 
 class RecoverDB1Test(BaseTest):
     """
     recover db: no DB
     """
-    runCmd = "aptly db recover"
+    runCmd = "aptly db recover -force"
 
 
 class RecoverDB2Test(BaseTest):
@@ -13,7 +15,8 @@ class RecoverDB2Test(BaseTest):
     recover db: without CURRENT files
     """
     fixtureDB = True
-    runCmd = "aptly db recover"
+    runCmd = "aptly db recover -force"
+    outputMatchPrepare = lambda _, s: re.sub(r'Recovered \d+ keys', 'Recovered keys', s)
 
     def prepare(self):
         super(RecoverDB2Test, self).prepare()
@@ -23,3 +26,40 @@ class RecoverDB2Test(BaseTest):
     def check(self):
         self.check_output()
         self.check_cmd_output("aptly mirror list", "mirror_list")
+
+
+class RecoverDB3Test(BaseTest):
+    """
+    recover db: without confirmation
+    """
+    fixtureDB = True
+    runCmd = "aptly db recover"
+    expectedCode = 1
+
+
+class RecoverDB4Test(BaseTest):
+    """
+    recover db: lost manifest, collections re-enumerated
+    """
+    fixtureDB = True
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}/libboost-program-options-dev_1.49.0.1_i386.deb",
+        "aptly snapshot create snap1 from repo local-repo",
+        "aptly snapshot create snap2 from mirror wheezy-main",
+    ]
+    runCmd = "aptly db recover -force"
+    outputMatchPrepare = lambda _, s: re.sub(r'Recovered \d+ keys', 'Recovered keys', s)
+
+    def prepare(self):
+        super(RecoverDB4Test, self).prepare()
+
+        for name in os.listdir(os.path.join(os.environ["HOME"], ".aptly", "db")):
+            if name.startswith("MANIFEST-") or name == "CURRENT":
+                self.delete_file(os.path.join("db", name))
+
+    def check(self):
+        self.check_output()
+        self.check_cmd_output("aptly mirror list", "mirror_list")
+        self.check_cmd_output("aptly snapshot list", "snapshot_list")
+        self.check_cmd_output("aptly repo list", "repo_list")
@@ -283,3 +283,170 @@ class AddRepo14Test(BaseTest):
         super(AddRepo14Test, self).check()
         # check pool
         self.check_file_not_empty('pool/00/35/libboost-program-options-dev_1.49.0.1_i386.deb')
+
+
+class AddRepo15Test(BaseTest):
+    """
+    add package to local repo: identical package with -conflict=skip
+    """
+    fixtureCmds = [
+        "aptly repo create -comment=Repo15 -distribution=squeeze repo15",
+        "aptly repo add repo15 ${files}/pyspi_0.6.1-1.3.dsc",
+    ]
+    runCmd = "aptly repo add -conflict=skip repo15 ${files}/pyspi_0.6.1-1.3.dsc"
+
+    def check(self):
+        self.check_output()
+        self.check_cmd_output("aptly repo show -with-packages repo15", "repo_show")
+
+
+class AddRepo16Test(BaseTest):
+    """
+    add package to local repo: conflict in packages + -conflict=skip
+    """
+    fixtureCmds = [
+        "aptly repo create -comment=Repo16 -distribution=squeeze repo16",
+        "aptly repo add repo16 ${files}/pyspi_0.6.1-1.3.dsc",
+    ]
+    runCmd = "aptly repo add -conflict=skip repo16 ${testfiles}/pyspi_0.6.1-1.3.conflict.dsc"
+
+    def check(self):
+        self.check_output()
+        self.check_cmd_output("aptly repo show -with-packages repo16", "repo_show")
+
+
+class AddRepo17Test(BaseTest):
+    """
+    add package to local repo: conflict in packages + -conflict=replace
+    """
+    fixtureCmds = [
+        "aptly repo create -comment=Repo17 -distribution=squeeze repo17",
+        "aptly repo add repo17 ${files}/pyspi_0.6.1-1.3.dsc",
+    ]
+    runCmd = "aptly repo add -conflict=replace repo17 ${testfiles}/pyspi_0.6.1-1.3.conflict.dsc"
+
+    def check(self):
+        self.check_output()
+        self.check_cmd_output("aptly repo show -with-packages repo17", "repo_show")
+
+
+class AddRepo18Test(BaseTest):
+    """
+    add package to local repo: unknown conflict policy
+    """
+    fixtureCmds = [
+        "aptly repo create -comment=Repo18 -distribution=squeeze repo18",
+    ]
+    runCmd = "aptly repo add -conflict=overwrite repo18 ${files}/pyspi_0.6.1-1.3.dsc"
+    expectedCode = 1
+
+
+class AddRepo19Test(BaseTest):
+    """
+    add package to local repo: -remove-files with some files failed
+    """
+    fixtureCmds = [
+        "aptly repo create -comment=Repo19 -distribution=squeeze repo19",
+    ]
+    runCmd = "aptly repo add -remove-files repo19 "
+    outputMatchPrepare = lambda self, s: s.replace(self.tempSrcDir, "")
+    expectedCode = 1
+
+    def prepare(self):
+        super(AddRepo19Test, self).prepare()
+
+        self.tempSrcDir = tempfile.mkdtemp()
+        os.makedirs(os.path.join(self.tempSrcDir, "01"), 0755)
+        os.makedirs(os.path.join(self.tempSrcDir, "02", "03"), 0755)
+
+        shutil.copy(os.path.join(os.path.dirname(inspect.getsourcefile(BaseTest)), "files", "libboost-program-options-dev_1.49.0.1_i386.deb"),
+            os.path.join(self.tempSrcDir, "01"))
+        shutil.copy(os.path.join(os.path.dirname(inspect.getsourcefile(BaseTest)), "files", "pyspi_0.6.1-1.3.dsc"),
+            os.path.join(self.tempSrcDir, "02", "03"))
+        shutil.copy(os.path.join(os.path.dirname(inspect.getsourcefile(BaseTest)), "files", "pyspi_0.6.1.orig.tar.gz"),
+            os.path.join(self.tempSrcDir, "02", "03"))
+
+        self.runCmd += self.tempSrcDir
+
+    def check(self):
+        self.check_output()
+
+        for path in [os.path.join(self.tempSrcDir, "01", "libboost-program-options-dev_1.49.0.1_i386.deb"),
+                     os.path.join(self.tempSrcDir, "02", "03", "pyspi_0.6.1-1.3.dsc"),
+                     os.path.join(self.tempSrcDir, "02", "03", "pyspi_0.6.1.orig.tar.gz")]:
+            if not os.path.exists(path):
+                raise Exception("path %s doesn't exist" % (path, ))
+
+        shutil.rmtree(self.tempSrcDir)
+
+
+class AddRepo20Test(BaseTest):
+    """
+    add package to local repo: pre-add hook rejects one of the files
+    """
+    fixtureCmds = [
+        "aptly repo create -comment=Repo20 -distribution=squeeze repo20",
+    ]
+    runCmd = "aptly repo add repo20 ${files}/libboost-program-options-dev_1.49.0.1_i386.deb ${files}/pyspi_0.6.1-1.3.dsc"
+    outputMatchPrepare = lambda self, s: s.replace(os.path.join(os.path.dirname(inspect.getsourcefile(BaseTest)), "files"), "")
+    expectedCode = 1
+
+    def prepare(self):
+        self.tempHookDir = tempfile.mkdtemp()
+        hook = os.path.join(self.tempHookDir, "scan.sh")
+        with open(hook, "w") as f:
+            f.write("#!/bin/sh\ncase \"$1\" in\n  *libboost*) echo \"$(basename $1): rejected by scanner\" >&2; exit 1;;\nesac\n")
+        os.chmod(hook, 0755)
+
+        self.configOverride = {"preAddHook": hook}
+
+        super(AddRepo20Test, self).prepare()
+
+    def check(self):
+        self.check_output()
+        self.check_cmd_output("aptly repo show -with-packages repo20", "repo_show")
+
+        shutil.rmtree(self.tempHookDir)
+
+
+class AddRepo21Test(BaseTest):
+    """
+    add package to local repo: pre-add hook rejects source file of the package
+    """
+    fixtureCmds = [
+        "aptly repo create -comment=Repo21 -distribution=squeeze repo21",
+    ]
+    runCmd = "aptly repo add repo21 ${files}/pyspi_0.6.1-1.3.dsc"
+    outputMatchPrepare = lambda self, s: s.replace(os.path.join(os.path.dirname(inspect.getsourcefile(BaseTest)), "files"), "")
+    expectedCode = 1
+
+    def prepare(self):
+        self.tempHookDir = tempfile.mkdtemp()
+        hook = os.path.join(self.tempHookDir, "scan.sh")
+        with open(hook, "w") as f:
+            f.write("#!/bin/sh\ncase \"$1\" in\n  *.orig.tar.gz) echo \"$(basename $1): rejected by scanner\" >&2; exit 1;;\nesac\n")
+        os.chmod(hook, 0755)
+
+        self.configOverride = {"preAddHook": hook}
+
+        super(AddRepo21Test, self).prepare()
+
+    def check(self):
+        self.check_output()
+
+        shutil.rmtree(self.tempHookDir)
+
+
+class AddRepo22Test(BaseTest):
+    """
+    add package to local repo: -keep-versions=0 overrides repository setting
+    """
+    fixtureCmds = [
+        "aptly repo create -keep-versions=1 -comment=Repo22 -distribution=squeeze repo22",
+        "aptly repo add repo22 ${files}/pyspi_0.6.1-1.3.dsc",
+    ]
+    runCmd = "aptly repo add -keep-versions=0 repo22 ${files}/pyspi-0.6.1-1.3.stripped.dsc"
+
+    def check(self):
+        self.check_output()
+        self.check_cmd_output("aptly repo show -with-packages repo22", "repo_show")
@@ -1,3 +1,5 @@
+import getpass
+import json
 from lib import BaseTest
 
 
@@ -30,3 +32,43 @@ class CreateRepo3Test(BaseTest):
     fixtureCmds = ["aptly repo create repo3"]
     runCmd = "aptly repo create -comment=Repository3 repo3"
     expectedCode = 1
+
+
+class CreateRepo4Test(BaseTest):
+    """
+    create local repo: rejected in read-only mode
+    """
+    runCmd = "aptly -read-only repo create repo4"
+    expectedCode = 1
+
+    def check(self):
+        self.check_output()
+        self.check_cmd_output("aptly repo list", "repo_list")
+
+
+class CreateRepo5Test(BaseTest):
+    """
+    create local repo: negative -keep-versions
+    """
+    runCmd = "aptly repo create -keep-versions=-1 repo5"
+    expectedCode = 1
+
+
+class CreateRepo6Test(BaseTest):
+    """
+    create local repo: recorded in audit journal
+    """
+    configOverride = {"auditJournal": True}
+    runCmd = "aptly repo create -comment=Repository6 repo6"
+
+    def check(self):
+        self.check_output()
+        self.run_cmd("aptly repo list")
+
+        entries = [json.loads(line) for line in self.read_file("audit.jsonl").splitlines()]
+        self.check_equal([(e["Operation"], e["Target"], e.get("Status")) for e in entries], [
+            ("repo create", "repo6", None),
+            ("repo create", "repo6", 200),
+        ])
+        self.check_equal(entries[0]["ID"], entries[1]["ID"])
+        self.check_equal(entries[0]["Principal"], getpass.getuser())
@@ -37,3 +37,106 @@ class ListRepo4Test(BaseTest):
         "aptly repo create repo1",
     ]
     runCmd = "aptly repo list -raw"
+
+
+class ListRepo5Test(BaseTest):
+    """
+    list local repo: filtered by label
+    """
+    fixtureCmds = [
+        "aptly repo create -comment=Cool3 -label=env=prod,team=core repo3",
+        "aptly repo create -comment=Cool2 -label=env=dev repo2",
+        "aptly repo create -label=env=prod repo1",
+    ]
+    runCmd = "aptly repo list -label=env=prod"
+
+
+class ListRepo6Test(BaseTest):
+    """
+    list local repo: filtered by label after edit
+    """
+    fixtureCmds = [
+        "aptly repo create -comment=Cool3 -label=env=prod,team=core repo3",
+        "aptly repo create -comment=Cool2 -label=env=dev repo2",
+        "aptly repo create -label=env=prod repo1",
+        "aptly repo edit -label=team=core,env= repo1",
+    ]
+    runCmd = "aptly repo list -raw -label=team=core"
+
+    def check(self):
+        self.check_output()
+        self.check_cmd_output("aptly repo show repo1", "repo_show")
+
+
+class ListRepo7Test(BaseTest):
+    """
+    list local repo: wrong label filter
+    """
+    runCmd = "aptly repo list -label=env"
+    expectedCode = 1
+
+
+class ListRepo8Test(BaseTest):
+    """
+    list local repo: sorted by creation time
+    """
+    fixtureCmds = [
+        "aptly repo create -comment=Cool3 repo3",
+        "aptly repo create -comment=Cool1 repo1",
+        "aptly repo create -comment=Cool2 repo2",
+    ]
+    runCmd = "aptly repo list -sort=created"
+
+
+class ListRepo9Test(BaseTest):
+    """
+    list local repo: sorted by modification time
+    """
+    fixtureCmds = [
+        "aptly repo create -comment=Cool3 repo3",
+        "aptly repo create -comment=Cool1 repo1",
+        "aptly repo create -comment=Cool2 repo2",
+        "aptly repo edit -comment=Cool4 repo3",
+    ]
+    runCmd = "aptly repo list -raw -sort=modified"
+
+
+class ListRepo10Test(BaseTest):
+    """
+    list local repo: wrong sort
+    """
+    runCmd = "aptly repo list -sort=planet"
+    expectedCode = 1
+
+
+class ListRepo11Test(BaseTest):
+    """
+    list local repos: read-only mode
+    """
+    fixtureCmds = [
+        "aptly repo create -comment=Cool3 repo3",
+        "aptly repo create -comment=Cool2 repo2",
+        "aptly repo create repo1",
+    ]
+    runCmd = "aptly -read-only repo list"
+
+
+class ListRepo12Test(BaseTest):
+    """
+    list local repos: only published
+    """
+    fixtureCmds = [
+        "aptly repo create -comment=Cool3 repo3",
+        "aptly repo create -comment=Cool2 repo2",
+        "aptly repo create repo1",
+        "aptly -architectures=i386 publish repo -skip-signing -distribution=maverick repo2",
+    ]
+    runCmd = "aptly repo list -published"
+
+
+class ListRepo13Test(BaseTest):
+    """
+    list local repos: only unpublished
+    """
+    fixtureCmds = ListRepo12Test.fixtureCmds
+    runCmd = "aptly repo list -raw -unpublished"
@@ -62,3 +62,52 @@ class RemoveRepo4Test(BaseTest):
     def output_processor(self, output):
         return "\n".join(sorted(output.split("\n")))
 
+
+
+class RemoveRepo5Test(BaseTest):
+    """
+    remove from local repo: -check-deps, leaf package
+    """
+    fixtureDB = True
+    fixtureCmds = [
+        "aptly repo create -comment=Cool -distribution=squeeze local-repo",
+        "aptly -architectures=i386,amd64 repo import -with-deps wheezy-main local-repo dpkg_1.16.12_i386 userinfo_2.2-3_amd64",
+    ]
+    runCmd = "aptly repo remove -check-deps local-repo userinfo_2.2-3_amd64"
+
+    def check(self):
+        self.check_output()
+        self.check_cmd_output("aptly repo show -with-packages local-repo", "repo_show")
+
+
+class RemoveRepo6Test(BaseTest):
+    """
+    remove from local repo: -check-deps, package with dependents
+    """
+    fixtureDB = True
+    fixtureCmds = [
+        "aptly repo create -comment=Cool -distribution=squeeze local-repo",
+        "aptly -architectures=i386,amd64 repo import -with-deps wheezy-main local-repo dpkg_1.16.12_i386 userinfo_2.2-3_amd64",
+    ]
+    runCmd = "aptly repo remove -check-deps local-repo gcc-4.7-base_4.7.2-5_amd64"
+    expectedCode = 1
+
+    def check(self):
+        self.check_output()
+        self.check_cmd_output("aptly repo show -with-packages local-repo", "repo_show")
+
+
+class RemoveRepo7Test(BaseTest):
+    """
+    remove from local repo: package with dependents, no -check-deps
+    """
+    fixtureDB = True
+    fixtureCmds = [
+        "aptly repo create -comment=Cool -distribution=squeeze local-repo",
+        "aptly -architectures=i386,amd64 repo import -with-deps wheezy-main local-repo dpkg_1.16.12_i386 userinfo_2.2-3_amd64",
+    ]
+    runCmd = "aptly repo remove local-repo gcc-4.7-base_4.7.2-5_amd64"
+
+    def check(self):
+        self.check_output()
+        self.check_cmd_output("aptly repo show -with-packages local-repo", "repo_show")
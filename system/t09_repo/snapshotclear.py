@@ -0,0 +1,50 @@
+import re
+from lib import BaseTest
+
+
+def remove_created_at(s):
+    return re.sub(r"Created At: [0-9:A-Za-z -]+\n", "", s)
+
+
+class SnapshotAndClearRepo1Test(BaseTest):
+    """
+    snapshot and clear repo: regular
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}"
+    ]
+    runCmd = "aptly repo snapshot-and-clear local-repo snap1"
+
+    def check(self):
+        self.check_output()
+        self.check_cmd_output("aptly snapshot show -with-packages snap1", "snapshot_show", match_prepare=remove_created_at)
+        self.check_cmd_output("aptly repo show -with-packages local-repo", "repo_show")
+
+
+class SnapshotAndClearRepo2Test(BaseTest):
+    """
+    snapshot and clear repo: duplicate snapshot name, repo untouched
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+        "aptly repo add local-repo ${files}",
+        "aptly snapshot create snap2 empty",
+    ]
+    runCmd = "aptly repo snapshot-and-clear local-repo snap2"
+    expectedCode = 1
+
+    def check(self):
+        self.check_output()
+        self.check_cmd_output("aptly repo show -with-packages local-repo", "repo_show")
+
+
+class SnapshotAndClearRepo3Test(BaseTest):
+    """
+    snapshot and clear repo: empty repo
+    """
+    fixtureCmds = [
+        "aptly repo create local-repo",
+    ]
+    runCmd = "aptly repo snapshot-and-clear local-repo snap3"
+    expectedCode = 1
@@ -9,3 +9,9 @@ from .version import *
 from .graph import *
 from .snapshots import *
 from .packages import *
+from .config import *
+from .gpg import *
+from .tasks import *
+from .readonly import *
+from .mirrors import *
+from .audit import *
@@ -0,0 +1,55 @@
+from api_lib import APITest
+import time
+
+
+class AuditAPITest(APITest):
+    """
+    GET /audit: repo add & publish are recorded in audit journal
+    """
+    isolated_url = "127.0.0.1:8768"
+
+    def check(self):
+        server, root = self.start_isolated_server(self.isolated_url, {"auditJournal": True})
+
+        try:
+            since = time.strftime("%Y-%m-%dT%H:%M:%SZ", time.gmtime(time.time() - 1))
+
+            repo_name = self.random_name()
+            self.check_equal(self.post("/api/repos", json={"Name": repo_name, "DefaultDistribution": "wheezy"},
+                                       base_url=self.isolated_url).status_code, 201)
+
+            d = self.random_name()
+            self.check_equal(self.upload("/api/files/" + d, "libboost-program-options-dev_1.49.0.1_i386.deb",
+                                         base_url=self.isolated_url).status_code, 200)
+            self.check_equal(self.post("/api/repos/" + repo_name + "/file/" + d, auth=("admin", "secret"),
+                                       base_url=self.isolated_url).status_code, 200)
+
+            prefix = self.random_name()
+            self.check_equal(self.post("/api/publish/" + prefix + "/repos",
+                                       json={"Sources": [{"Name": repo_name}], "Signing": {"Skip": True}},
+                                       base_url=self.isolated_url).status_code, 200)
+
+            self.check_equal(self.get("/api/repos/" + repo_name, base_url=self.isolated_url).status_code, 200)
+
+            resp = self.get("/api/audit", params={"since": since}, base_url=self.isolated_url)
+            self.check_equal(resp.status_code, 200)
+            entries = resp.json()
+            self.check_equal([(e["Operation"], e["Target"], e.get("Status")) for e in entries], [
+                ("POST", "/api/repos", None),
+                ("POST", "/api/repos", 201),
+                ("POST", "/api/files/" + d, None),
+                ("POST", "/api/files/" + d, 200),
+                ("POST", "/api/repos/" + repo_name + "/file/" + d, None),
+                ("POST", "/api/repos/" + repo_name + "/file/" + d, 200),
+                ("POST", "/api/publish/" + prefix + "/repos", None),
+                ("POST", "/api/publish/" + prefix + "/repos", 200),
+            ])
+            self.check_equal(entries[0]["ID"], entries[1]["ID"])
+            # unverified basic auth credentials are not trusted as principal
+            self.check_equal("Principal" in entries[4], False)
+            self.check_equal("Principal" in entries[6], False)
+
+            resp = self.get("/api/audit", params={"since": "yesterday"}, base_url=self.isolated_url)
+            self.check_equal(resp.status_code, 400)
+        finally:
+            self.stop_isolated_server(server, root)
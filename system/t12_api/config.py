@@ -0,0 +1,13 @@
+from api_lib import APITest
+
+
+class ConfigValidateAPITest(APITest):
+    """
+    GET /config/validate
+    """
+
+    def check(self):
+        resp = self.get("/api/config/validate")
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json()["Errors"], [])
+        self.check_equal(resp.json()["Valid"], True)
@@ -1,3 +1,6 @@
+import hashlib
+import inspect
+import os
 from api_lib import APITest
 
 
@@ -96,3 +99,139 @@ class FilesAPITestSecurity(APITest):
         self.check_equal(self.delete("/api/files/../.").status_code, 400)
         self.check_equal(self.delete("/api/files/./..").status_code, 400)
         self.check_equal(self.delete("/api/files/dir/..").status_code, 400)
+
+
+class FilesAPITestUploadTraversal(APITest):
+    """
+    POST /files/:dir, path traversal in filename
+    """
+
+    def check(self):
+        d = self.random_name()
+
+        resp = self.upload("/api/files/" + d, "pyspi_0.6.1-1.3.dsc", upload_name="../../pyspi_0.6.1-1.3.dsc")
+        self.check_equal(resp.status_code, 400)
+        self.check_not_exists("upload/pyspi_0.6.1-1.3.dsc")
+        self.check_not_exists("pyspi_0.6.1-1.3.dsc")
+
+        # directories in filename are stripped
+        resp = self.upload("/api/files/" + d, "pyspi_0.6.1-1.3.dsc", upload_name="some/dir/pyspi_0.6.1-1.3.dsc")
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json(), [d + '/pyspi_0.6.1-1.3.dsc'])
+
+
+class FilesAPITestUploadInvalidPart(APITest):
+    """
+    POST /files/:dir, invalid name of any part rejects whole upload
+    """
+
+    def check(self):
+        d = self.random_name()
+        files_dir = os.path.join(os.path.dirname(inspect.getsourcefile(APITest)), "files")
+
+        resp = self.post("/api/files/" + d, files=[
+            ("file1", ("pyspi_0.6.1-1.3.dsc", open(os.path.join(files_dir, "pyspi_0.6.1-1.3.dsc"), "rb"))),
+            ("file2", ("../pyspi_0.6.1-1.3.diff.gz", open(os.path.join(files_dir, "pyspi_0.6.1-1.3.diff.gz"), "rb"))),
+        ])
+        self.check_equal(resp.status_code, 400)
+        self.check_not_exists("upload/" + d)
+
+        self.check_equal(self.upload("/api/files/" + d, "pyspi_0.6.1-1.3.dsc").status_code, 200)
+        self.check_equal(os.stat(os.path.join(os.environ["HOME"], ".aptly", "upload", d, "pyspi_0.6.1-1.3.dsc")).st_mode & 0o777, 0o644)
+
+        # collision of any file rejects whole upload
+        resp = self.upload("/api/files/" + d + "?collision=reject", "pyspi_0.6.1-1.3.diff.gz", "pyspi_0.6.1-1.3.dsc")
+        self.check_equal(resp.status_code, 409)
+        self.check_equal(self.get("/api/files/" + d).json(), ['pyspi_0.6.1-1.3.dsc'])
+
+
+class FilesAPITestUploadCollision(APITest):
+    """
+    POST /files/:dir, collision policies
+    """
+
+    def check(self):
+        d = self.random_name()
+
+        self.check_equal(self.upload("/api/files/" + d, "pyspi_0.6.1-1.3.dsc").status_code, 200)
+
+        # overwrite (default)
+        resp = self.upload("/api/files/" + d, "pyspi_0.6.1-1.3.dsc")
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json(), [d + '/pyspi_0.6.1-1.3.dsc'])
+
+        resp = self.upload("/api/files/" + d + "?collision=overwrite", "pyspi_0.6.1-1.3.dsc")
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json(), [d + '/pyspi_0.6.1-1.3.dsc'])
+        self.check_equal(self.get("/api/files/" + d).json(), ['pyspi_0.6.1-1.3.dsc'])
+
+        # reject
+        resp = self.upload("/api/files/" + d + "?collision=reject", "pyspi_0.6.1-1.3.dsc")
+        self.check_equal(resp.status_code, 409)
+        self.check_equal(self.get("/api/files/" + d).json(), ['pyspi_0.6.1-1.3.dsc'])
+
+        # rename
+        resp = self.upload("/api/files/" + d + "?collision=rename", "pyspi_0.6.1-1.3.dsc")
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json(), [d + '/pyspi_0.6.1-1.3-1.dsc'])
+
+        resp = self.upload("/api/files/" + d + "?collision=rename", "pyspi_0.6.1-1.3.dsc")
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json(), [d + '/pyspi_0.6.1-1.3-2.dsc'])
+
+        self.check_equal(sorted(self.get("/api/files/" + d).json()),
+                         ['pyspi_0.6.1-1.3-1.dsc', 'pyspi_0.6.1-1.3-2.dsc', 'pyspi_0.6.1-1.3.dsc'])
+
+        self.check_equal(self.upload("/api/files/" + d + "?collision=whatever", "pyspi_0.6.1-1.3.dsc").status_code, 400)
+
+
+class FilesAPITestUploadLarge(APITest):
+    """
+    POST /files/:dir, large file streamed with chunked transfer encoding
+    """
+    chunk = "0123456789abcdef" * 65536
+    chunks = 256
+
+    def body(self, boundary):
+        yield "--%s\r\nContent-Disposition: form-data; name=\"file\"; filename=\"large_1.0_amd64.deb\"\r\n" \
+            "Content-Type: application/octet-stream\r\n\r\n" % (boundary, )
+        for _ in range(self.chunks):
+            yield self.chunk
+        yield "\r\n--%s--\r\n" % (boundary, )
+
+    def peak_memory(self):
+        with open("/proc/%d/status" % (APITest.aptly_server.pid, ), "r") as f:
+            for line in f:
+                if line.startswith("VmHWM:"):
+                    return int(line.split()[1]) * 1024
+        return 0
+
+    def check(self):
+        d = self.random_name()
+        boundary = self.random_name()
+
+        # generator body is sent with Transfer-Encoding: chunked
+        resp = self.post("/api/files/" + d, data=self.body(boundary),
+                         headers={"Content-Type": "multipart/form-data; boundary=" + boundary})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json(), [d + '/large_1.0_amd64.deb'])
+
+        path = os.path.join(os.environ["HOME"], ".aptly", "upload", d, "large_1.0_amd64.deb")
+        self.check_equal(os.path.getsize(path), len(self.chunk) * self.chunks)
+
+        expected = hashlib.md5()
+        for _ in range(self.chunks):
+            expected.update(self.chunk)
+        actual = hashlib.md5()
+        with open(path, "rb") as f:
+            for block in iter(lambda: f.read(1024 * 1024), ""):
+                actual.update(block)
+        self.check_equal(actual.hexdigest(), expected.hexdigest())
+
+        # no temporary files are left behind
+        self.check_equal(self.get("/api/files/" + d).json(), ['large_1.0_amd64.deb'])
+
+        # 256 MiB body is never buffered in memory
+        peak = self.peak_memory()
+        if peak > 128 * 1024 * 1024:
+            raise Exception("aptly API server peak memory is too high: %d bytes" % (peak, ))
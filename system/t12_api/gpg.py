@@ -0,0 +1,111 @@
+from api_lib import APITest
+import inspect
+import os
+import subprocess
+import tempfile
+
+
+class GPGAPITestVerify(APITest):
+    """
+    POST /gpg/verify
+    """
+
+    def check(self):
+        files = os.path.join(os.path.dirname(inspect.getsourcefile(APITest)), "files")
+        keyring = os.path.join(files, "aptly.pub")
+        cleartext = os.path.join(files, "pyspi_0.6.1-1.3.dsc")
+
+        signature = tempfile.NamedTemporaryFile(suffix=".asc", delete=False)
+        signature.close()
+        subprocess.check_call(["gpg", "--no-default-keyring", "--keyring", keyring,
+                               "--secret-keyring", os.path.join(files, "aptly.sec"),
+                               "--armor", "--yes", "-o", signature.name, "--detach-sign", cleartext])
+
+        try:
+            # valid signature, keyring is uploaded
+            resp = self.post("/api/gpg/verify",
+                             files={"file": open(cleartext, "rb"), "signature": open(signature.name, "rb"),
+                                    "keyring": open(keyring, "rb")})
+            self.check_equal(resp.status_code, 200)
+            self.check_equal(resp.json()['Valid'], True)
+            self.check_equal(resp.json()['KeyID'] != "", True)
+            self.check_equal(len(resp.json()['Fingerprint']), 40)
+
+            # invalid signature: contents don't match
+            resp = self.post("/api/gpg/verify",
+                             files={"file": open(os.path.join(files, "pyspi_0.6.1-1.3.diff.gz"), "rb"),
+                                    "signature": open(signature.name, "rb"), "keyring": open(keyring, "rb")})
+            self.check_equal(resp.status_code, 200)
+            self.check_equal(resp.json()['Valid'], False)
+
+            # keyring can't be referenced by path on server
+            resp = self.post("/api/gpg/verify", data={"keyring": keyring},
+                             files={"file": open(cleartext, "rb"), "signature": open(signature.name, "rb")})
+            self.check_equal(resp.status_code, 400)
+
+            # unknown managed keyring
+            resp = self.post("/api/gpg/verify", data={"keyring": self.random_name()},
+                             files={"file": open(cleartext, "rb"), "signature": open(signature.name, "rb")})
+            self.check_equal(resp.status_code, 400)
+        finally:
+            os.unlink(signature.name)
+
+        # signature missing
+        resp = self.post("/api/gpg/verify", files={"file": open(cleartext, "rb")})
+        self.check_equal(resp.status_code, 400)
+
+
+class GPGAPITestKeys(APITest):
+    """
+    POST /gpg/keys, GET /gpg/keys/:keyring, DELETE /gpg/keys/:keyring/:key
+    """
+
+    def check(self):
+        files = os.path.join(os.path.dirname(inspect.getsourcefile(APITest)), "files")
+        key = subprocess.check_output(["gpg", "--no-default-keyring", "--keyring", os.path.join(files, "aptly.pub"),
+                                       "--armor", "--export", "21DBB89C16DB3E6D"])
+        keyring = self.random_name()
+
+        # keyring doesn't exist yet
+        self.check_equal(self.get("/api/gpg/keys/" + keyring).status_code, 404)
+
+        resp = self.post("/api/gpg/keys", json={"Keyring": keyring, "Key": key.decode("ascii")})
+        self.check_equal(resp.status_code, 201)
+        self.check_equal(resp.json(), [{
+            'KeyID': '21DBB89C16DB3E6D',
+            'Fingerprint': 'C5ACD2179B5231DFE842EE6121DBB89C16DB3E6D',
+            'UserIDs': ["Aptly Tester (don't use it) <test@aptly.info>"]}])
+
+        resp = self.get("/api/gpg/keys/" + keyring)
+        self.check_equal(resp.status_code, 200)
+        self.check_equal([k['KeyID'] for k in resp.json()], ['21DBB89C16DB3E6D'])
+
+        # managed keyring is referenced by name
+        cleartext = os.path.join(files, "pyspi_0.6.1-1.3.dsc")
+        signature = tempfile.NamedTemporaryFile(suffix=".asc", delete=False)
+        signature.close()
+        subprocess.check_call(["gpg", "--no-default-keyring", "--keyring", os.path.join(files, "aptly.pub"),
+                               "--secret-keyring", os.path.join(files, "aptly.sec"),
+                               "--armor", "--yes", "-o", signature.name, "--detach-sign", cleartext])
+        try:
+            resp = self.post("/api/gpg/verify", data={"keyring": keyring},
+                             files={"file": open(cleartext, "rb"), "signature": open(signature.name, "rb")})
+            self.check_equal(resp.status_code, 200)
+            self.check_equal(resp.json()['Valid'], True)
+        finally:
+            os.unlink(signature.name)
+
+        # not a public key
+        resp = self.post("/api/gpg/keys", json={"Keyring": keyring, "Key": "garbage"})
+        self.check_equal(resp.status_code, 400)
+
+        # wrong keyring name
+        resp = self.post("/api/gpg/keys", json={"Keyring": "../secrets", "Key": key.decode("ascii")})
+        self.check_equal(resp.status_code, 400)
+
+        self.check_equal(self.delete("/api/gpg/keys/" + keyring + "/AAAABBBBCCCCDDDD").status_code, 404)
+        self.check_equal(self.delete("/api/gpg/keys/" + keyring + "/16DB3E6D").status_code, 200)
+
+        resp = self.get("/api/gpg/keys/" + keyring)
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json(), [])
@@ -1,4 +1,6 @@
 from api_lib import APITest
+import distutils.spawn
+import os
 
 
 class GraphAPITest(APITest):
@@ -15,3 +17,96 @@ class GraphAPITest(APITest):
         resp = self.get("/api/graph.svg")
         self.check_equal(resp.headers["Content-Type"], "image/svg+xml")
         self.check_equal(resp.content[:4], '<?xm')
+
+
+class GraphAPITestFocus(APITest):
+    """
+    GET /graph.:ext?focus=&depth=&max-nodes=
+    """
+
+    def check(self):
+        prefix = self.random_name()
+        self.check_equal(self.post("/api/repos", json={"Name": prefix + "1"}).status_code, 201)
+        self.check_equal(self.post("/api/repos", json={"Name": prefix + "2"}).status_code, 201)
+        self.check_equal(self.post("/api/repos", json={"Name": "other" + prefix}).status_code, 201)
+
+        resp = self.get("/api/graph.svg", params={"focus": prefix + "*", "depth": "0", "max-nodes": "2"})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.headers["Content-Type"], "image/svg+xml")
+        self.check_equal(resp.content.count("<g id=\"node"), 2)
+        self.check_equal(("Repo " + prefix + "1") in resp.content, True)
+        self.check_equal(("Repo other" + prefix) in resp.content, False)
+
+        resp = self.get("/api/graph.svg", params={"focus": "*" + prefix + "*", "max-nodes": "2"})
+        self.check_equal(resp.status_code, 400)
+
+        resp = self.get("/api/graph.svg", params={"depth": "x"})
+        self.check_equal(resp.status_code, 400)
+
+
+class GraphAPITestFormats(APITest):
+    """
+    GET /graph.svg, /graph.json, unsupported formats
+    """
+
+    def check(self):
+        repo_name = self.random_name()
+        snapshot_name = self.random_name()
+        merged_name = self.random_name()
+        self.check_equal(self.post("/api/repos", json={"Name": repo_name, "Comment": "fun repo"}).status_code, 201)
+        self.check_equal(self.post("/api/snapshots", json={"Name": snapshot_name}).status_code, 201)
+        self.check_equal(self.post("/api/snapshots", json={"Name": merged_name,
+                                                           "SourceSnapshots": [snapshot_name]}).status_code, 201)
+
+        resp = self.get("/api/graph.svg", params={"focus": snapshot_name, "depth": "1"})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.headers["Content-Type"], "image/svg+xml")
+        self.check_equal("<svg" in resp.content, True)
+        self.check_equal(("Snapshot " + merged_name) in resp.content, True)
+
+        resp = self.get("/api/graph.json", params={"focus": repo_name})
+        self.check_equal(resp.status_code, 200)
+        self.check_subset({"Type": "repo", "Name": repo_name, "Comment": "fun repo"}, resp.json()["Nodes"][0])
+
+        resp = self.get("/api/graph.json", params={"focus": snapshot_name, "depth": "1"})
+        self.check_equal(resp.status_code, 200)
+        graph = resp.json()
+        self.check_equal([node["Name"] for node in graph["Nodes"]], [snapshot_name, merged_name])
+        self.check_equal(graph["Nodes"][1]["SourceKind"], "snapshot")
+        self.check_equal(graph["Edges"], [{"From": graph["Nodes"][0]["UUID"], "To": graph["Nodes"][1]["UUID"]}])
+
+        resp = self.get("/api/graph.json", params={"focus": self.random_name()})
+        self.check_equal(resp.json(), {"Nodes": [], "Edges": []})
+
+        resp = self.get("/api/graph.bmp")
+        self.check_equal(resp.status_code, 400)
+        self.check_equal("supported formats: dot, gif, jpg, json, pdf, png, svg" in resp.content, True)
+
+
+class GraphAPITestNoGraphviz(APITest):
+    """
+    GET /graph.:ext without graphviz installed
+    """
+    isolated_url = "127.0.0.1:8767"
+
+    def check(self):
+        # only aptly itself is available in PATH
+        self.environmentOverride = {"PATH": os.path.dirname(distutils.spawn.find_executable("aptly"))}
+        server, root = self.start_isolated_server(self.isolated_url)
+
+        try:
+            repo_name = self.random_name()
+            self.check_equal(self.post("/api/repos", json={"Name": repo_name}, base_url=self.isolated_url).status_code, 201)
+
+            resp = self.get("/api/graph.png", params={"focus": repo_name}, base_url=self.isolated_url)
+            self.check_equal(resp.status_code, 200)
+            self.check_equal(resp.headers["Content-Type"], "text/vnd.graphviz; charset=utf-8")
+            self.check_equal(resp.headers["X-Aptly-Graph-Fallback"], "graphviz is not installed, graph returned in DOT format")
+            self.check_equal(resp.content.startswith("digraph aptly"), True)
+            self.check_equal(("Repo " + repo_name) in resp.content, True)
+
+            resp = self.get("/api/graph.json", params={"focus": repo_name}, base_url=self.isolated_url)
+            self.check_equal(resp.status_code, 200)
+            self.check_equal([node["Name"] for node in resp.json()["Nodes"]], [repo_name])
+        finally:
+            self.stop_isolated_server(server, root)
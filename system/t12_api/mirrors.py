@@ -0,0 +1,11 @@
+from api_lib import APITest
+
+
+class MirrorsAPITestPackages(APITest):
+    """
+    GET /api/mirrors/:name/packages
+    """
+    def check(self):
+        self.check_equal(self.get("/api/mirrors/" + self.random_name() + "/packages").status_code, 404)
+        self.check_equal(self.get("/api/mirrors/" + self.random_name() + "/packages",
+                                  params={"q": "nginx"}).status_code, 404)
@@ -45,6 +45,87 @@ class PublishAPITestRepo(APITest):
             'Storage': ''})
 
 
+class PublishAPITestRepoDefaults(APITest):
+    """
+    POST /publish/:prefix/repos: distribution & component from repo defaults
+    """
+    fixtureGpg = True
+
+    def check(self):
+        repo_name = self.random_name()
+        self.check_equal(self.post("/api/repos",
+                         json={"Name": repo_name, "DefaultDistribution": "squeeze", "DefaultComponent": "contrib"}).status_code, 201)
+
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d,
+                         "libboost-program-options-dev_1.49.0.1_i386.deb").status_code, 200)
+
+        self.check_equal(self.post("/api/repos/" + repo_name + "/file/" + d).status_code, 200)
+
+        prefix = self.random_name()
+        resp = self.post("/api/publish/" + prefix + "/repos",
+                         json={
+                             "Sources": [{"Name": repo_name}],
+                             "Signing": DefaultSigningOptions,
+                         })
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json()['Distribution'], 'squeeze')
+        self.check_equal(resp.json()['Sources'], [{'Component': 'contrib', 'Name': repo_name}])
+
+        self.check_exists("public/" + prefix + "/dists/squeeze/contrib/binary-i386/Packages")
+        self.check_exists("public/" + prefix + "/pool/contrib/b/boost-defaults/libboost-program-options-dev_1.49.0.1_i386.deb")
+
+
+class PublishAPITestShowPackages(APITest):
+    """
+    GET /publish/:prefix/:distribution, GET /publish/:prefix/:distribution/packages
+    """
+    fixtureGpg = True
+
+    def check(self):
+        repo1 = self.random_name()
+        repo2 = self.random_name()
+        self.check_equal(self.post("/api/repos", json={"Name": repo1}).status_code, 201)
+        self.check_equal(self.post("/api/repos", json={"Name": repo2}).status_code, 201)
+
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d,
+                         "libboost-program-options-dev_1.49.0.1_i386.deb", "pyspi_0.6.1-1.3.dsc",
+                         "pyspi_0.6.1-1.3.diff.gz", "pyspi_0.6.1.orig.tar.gz").status_code, 200)
+        self.check_equal(self.post("/api/repos/" + repo1 + "/file/" + d + "/libboost-program-options-dev_1.49.0.1_i386.deb").status_code, 200)
+        self.check_equal(self.post("/api/repos/" + repo2 + "/file/" + d).status_code, 200)
+
+        prefix = self.random_name()
+        resp = self.post("/api/publish/" + prefix + "/repos",
+                         json={
+                             "Distribution": "wheezy",
+                             "Sources": [{"Component": "main", "Name": repo1}, {"Component": "contrib", "Name": repo2}],
+                             "Signing": DefaultSigningOptions,
+                         })
+        self.check_equal(resp.status_code, 200)
+
+        resp = self.get("/api/publish/" + prefix + "/wheezy")
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json()['Architectures'], ['i386', 'source'])
+
+        resp = self.get("/api/publish/" + prefix + "/wheezy/packages", params={"format": "details"})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(sorted(p['Package'] for p in resp.json()), ['libboost-program-options-dev', 'pyspi'])
+
+        resp = self.get("/api/publish/" + prefix + "/wheezy/packages", params={"component": "contrib"})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(len(resp.json()), 1)
+        self.check_equal(resp.json()[0].startswith("Psource pyspi 0.6.1-1.3 "), True)
+
+        resp = self.get("/api/publish/" + prefix + "/wheezy/packages", params={"q": "Name (libboost-program-options-dev)"})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(len(resp.json()), 1)
+        self.check_equal(resp.json()[0].startswith("Pi386 libboost-program-options-dev "), True)
+
+        self.check_equal(self.get("/api/publish/" + prefix + "/wheezy/packages", params={"component": "non-free"}).status_code, 404)
+        self.check_equal(self.get("/api/publish/" + prefix + "/squeeze").status_code, 404)
+
+
 class PublishSnapshotAPITest(APITest):
     """
     POST /publish/:prefix/snapshot
@@ -54,3 +135,137 @@ class PublishSnapshotAPITest(APITest):
 
     def check(self):
         pass
+
+
+class PublishResignAPITest(APITest):
+    """
+    POST /publish/:prefix/:distribution/resign: renew Valid-Until
+    """
+    fixtureGpg = True
+
+    def check(self):
+        repo_name = self.random_name()
+        self.check_equal(self.post("/api/repos", json={"Name": repo_name, "DefaultDistribution": "wheezy"}).status_code, 201)
+
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d,
+                         "libboost-program-options-dev_1.49.0.1_i386.deb").status_code, 200)
+        self.check_equal(self.post("/api/repos/" + repo_name + "/file/" + d).status_code, 200)
+
+        prefix = self.random_name()
+        resp = self.post("/api/publish/" + prefix + "/repos",
+                         json={
+                             "Sources": [{"Name": repo_name}],
+                             "Signing": DefaultSigningOptions,
+                         })
+        self.check_equal(resp.status_code, 200)
+
+        packages = self.read_file("public/" + prefix + "/dists/wheezy/main/binary-i386/Packages")
+        self.check_equal("Valid-Until: " in self.read_file("public/" + prefix + "/dists/wheezy/Release"), False)
+
+        resp = self.post("/api/publish/" + prefix + "/wheezy/resign",
+                         json={"Signing": DefaultSigningOptions, "ValidFor": "yesterday"})
+        self.check_equal(resp.status_code, 400)
+
+        resp = self.post("/api/publish/" + prefix + "/wheezy/resign",
+                         json={"Signing": DefaultSigningOptions, "ValidFor": "168h"})
+        self.check_equal(resp.status_code, 200)
+
+        release = self.read_file("public/" + prefix + "/dists/wheezy/Release")
+        self.check_equal("Valid-Until: " in release, True)
+        self.check_equal(release in self.read_file("public/" + prefix + "/dists/wheezy/InRelease"), True)
+        self.check_equal(self.read_file("public/" + prefix + "/dists/wheezy/main/binary-i386/Packages"), packages)
+
+        self.check_equal(self.post("/api/publish/" + prefix + "/squeeze/resign",
+                                   json={"Signing": DefaultSigningOptions}).status_code, 404)
+
+
+class PublishUpdateAPITestArchitectures(APITest):
+    """
+    PUT /publish/:prefix/:distribution: published architecture without packages
+    """
+    fixtureGpg = True
+
+    def check(self):
+        repo_name = self.random_name()
+        self.check_equal(self.post("/api/repos", json={"Name": repo_name, "DefaultDistribution": "wheezy"}).status_code, 201)
+
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d,
+                         "libboost-program-options-dev_1.49.0.1_i386.deb", "pyspi_0.6.1-1.3.dsc",
+                         "pyspi_0.6.1-1.3.diff.gz", "pyspi_0.6.1.orig.tar.gz").status_code, 200)
+        self.check_equal(self.post("/api/repos/" + repo_name + "/file/" + d).status_code, 200)
+
+        prefix = self.random_name()
+        resp = self.post("/api/publish/" + prefix + "/repos",
+                         json={
+                             "Sources": [{"Name": repo_name}],
+                             "Signing": DefaultSigningOptions,
+                         })
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json()['Architectures'], ['i386', 'source'])
+
+        self.check_equal(self.delete("/api/repos/" + repo_name + "/packages/",
+                         json={"Queries": ["pyspi"]}).status_code, 200)
+
+        resp = self.put("/api/publish/" + prefix + "/wheezy",
+                        json={"Signing": DefaultSigningOptions})
+        self.check_equal(resp.status_code, 400)
+        self.check_equal(resp.json()[0]["error"], "unable to update: published architectures without packages: source")
+        self.check_exists("public/" + prefix + "/pool/main/p/pyspi/pyspi_0.6.1-1.3.dsc")
+
+        resp = self.put("/api/publish/" + prefix + "/wheezy",
+                        json={"Signing": DefaultSigningOptions, "Force": True})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json()['Architectures'], ['i386', 'source'])
+        self.check_not_exists("public/" + prefix + "/pool/main/p/pyspi/pyspi_0.6.1-1.3.dsc")
+
+
+class PublishAPITestReleaseFlags(APITest):
+    """
+    POST /publish/:prefix/repos, PUT /publish/:prefix/:distribution: ButAutomaticUpgrades requires NotAutomatic
+    """
+    fixtureGpg = True
+
+    def check(self):
+        repo_name = self.random_name()
+        self.check_equal(self.post("/api/repos", json={"Name": repo_name, "DefaultDistribution": "wheezy"}).status_code, 201)
+
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d,
+                         "libboost-program-options-dev_1.49.0.1_i386.deb").status_code, 200)
+        self.check_equal(self.post("/api/repos/" + repo_name + "/file/" + d).status_code, 200)
+
+        prefix = self.random_name()
+        resp = self.post("/api/publish/" + prefix + "/repos",
+                         json={
+                             "Sources": [{"Name": repo_name}],
+                             "Signing": DefaultSigningOptions,
+                             "ButAutomaticUpgrades": True,
+                         })
+        self.check_equal(resp.status_code, 400)
+        self.check_equal(resp.json()[0]["error"], "unable to publish: ButAutomaticUpgrades requires NotAutomatic to be set")
+        self.check_not_exists("public/" + prefix + "/dists/wheezy/Release")
+
+        resp = self.post("/api/publish/" + prefix + "/repos",
+                         json={
+                             "Sources": [{"Name": repo_name}],
+                             "Signing": DefaultSigningOptions,
+                             "NotAutomatic": True,
+                             "ButAutomaticUpgrades": True,
+                         })
+        self.check_equal(resp.status_code, 200)
+
+        release = self.read_file("public/" + prefix + "/dists/wheezy/Release")
+        self.check_equal("NotAutomatic: yes\n" in release, True)
+        self.check_equal("ButAutomaticUpgrades: yes\n" in release, True)
+
+        resp = self.put("/api/publish/" + prefix + "/wheezy",
+                        json={"Signing": DefaultSigningOptions, "NotAutomatic": False})
+        self.check_equal(resp.status_code, 400)
+        self.check_equal(resp.json()[0]["error"], "unable to update: ButAutomaticUpgrades requires NotAutomatic to be set")
+
+        resp = self.put("/api/publish/" + prefix + "/wheezy",
+                        json={"Signing": DefaultSigningOptions, "NotAutomatic": False, "ButAutomaticUpgrades": False})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal("NotAutomatic: " in self.read_file("public/" + prefix + "/dists/wheezy/Release"), False)
@@ -0,0 +1,26 @@
+from api_lib import APITest
+import time
+
+
+class ReadOnlyAPITest(APITest):
+    """
+    GET works, POST rejected in read-only mode
+    """
+    base_url = "127.0.0.1:8766"
+
+    def check(self):
+        server = self._start_process("aptly -read-only api serve -listen=%s" % (self.base_url,))
+        time.sleep(1)
+
+        try:
+            resp = self.get("/api/version")
+            self.check_equal(resp.status_code, 200)
+
+            resp = self.post("/api/publish/ppa/repos", json={"Sources": [{"Name": "repo"}]})
+            self.check_equal(resp.status_code, 405)
+
+            resp = self.post("/api/repos", json={"Name": "repo"})
+            self.check_equal(resp.status_code, 405)
+        finally:
+            server.terminate()
+            server.wait()
@@ -1,4 +1,5 @@
 from api_lib import APITest
+from publish import DefaultSigningOptions
 
 
 class ReposAPITestCreateShow(APITest):
@@ -13,10 +14,10 @@ class ReposAPITestCreateShow(APITest):
                      u'Name': repo_name}
 
         resp = self.post("/api/repos", json={"Name": repo_name, "Comment": "fun repo"})
-        self.check_equal(resp.json(), repo_desc)
+        self.check_subset(repo_desc, resp.json())
         self.check_equal(resp.status_code, 201)
 
-        self.check_equal(self.get("/api/repos/" + repo_name).json(), repo_desc)
+        self.check_subset(repo_desc, self.get("/api/repos/" + repo_name).json())
         self.check_equal(self.get("/api/repos/" + repo_name).status_code, 200)
 
         resp = self.get("/api/repos/" + repo_name + "/packages")
@@ -74,6 +75,27 @@ class ReposAPITestAdd(APITest):
         self.check_not_exists("upload/" + d)
 
 
+class ReposAPITestAddUnknownConflictPolicy(APITest):
+    """
+    POST /api/repos/:name/file/:dir with unknown conflict policy
+    """
+    def check(self):
+        repo_name = self.random_name()
+
+        self.check_equal(self.post("/api/repos", json={"Name": repo_name}).status_code, 201)
+
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d,
+                         "pyspi_0.6.1-1.3.dsc", "pyspi_0.6.1-1.3.diff.gz", "pyspi_0.6.1.orig.tar.gz").status_code, 200)
+
+        resp = self.post("/api/repos/" + repo_name + "/file/" + d, params={"conflict": "ignore"})
+        self.check_equal(resp.status_code, 400)
+        self.check_equal(resp.json()[0]["error"], "unknown conflict policy: ignore, supported policies: fail, skip, replace")
+
+        self.check_equal(self.get("/api/repos/" + repo_name + "/packages").json(), [])
+        self.check_exists("upload/" + d)
+
+
 class ReposAPITestAddNotFullRemove(APITest):
     """
     POST /api/repos/:name/file/:dir not all files removed
@@ -113,6 +135,42 @@ class ReposAPITestAddNoRemove(APITest):
         self.check_exists("upload/" + d + "/pyspi_0.6.1-1.3.dsc")
 
 
+class ReposAPITestAddFailedNoRemove(APITest):
+    """
+    POST /api/repos/:name/file/:dir some files failed, nothing removed
+    """
+    def check(self):
+        repo_name = self.random_name()
+
+        self.check_equal(self.post("/api/repos", json={"Name": repo_name, "Comment": "fun repo"}).status_code, 201)
+
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d,
+                         "libboost-program-options-dev_1.49.0.1_i386.deb", "pyspi_0.6.1-1.3.dsc",
+                         "pyspi_0.6.1-1.3.diff.gz").status_code, 200)
+
+        resp = self.post("/api/repos/" + repo_name + "/file/" + d)
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(len(resp.json()["failedFiles"]), 1)
+        self.check_equal(resp.json()["report"]["added"], [u'libboost-program-options-dev_1.49.0.1_i386 added'])
+        self.check_equal(resp.json()["report"]["warnings"][-1], u'files are kept in upload directory as some files failed to be added')
+
+        self.check_exists("upload/" + d + "/libboost-program-options-dev_1.49.0.1_i386.deb")
+        self.check_exists("upload/" + d + "/pyspi_0.6.1-1.3.dsc")
+
+        # after fixing the problem, add succeeds and upload directory is cleaned up
+        self.check_equal(self.upload("/api/files/" + d, "pyspi_0.6.1.orig.tar.gz").status_code, 200)
+
+        resp = self.post("/api/repos/" + repo_name + "/file/" + d)
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json()["failedFiles"], [])
+        self.check_equal(sorted(self.get("/api/repos/" + repo_name + "/packages").json()),
+                         ['Pi386 libboost-program-options-dev 1.49.0.1 918d2f433384e378',
+                          'Psource pyspi 0.6.1-1.3 3a8b37cbd9a3559e'])
+
+        self.check_not_exists("upload/" + d)
+
+
 class ReposAPITestAddFile(APITest):
     """
     POST /api/repos/:name/file/:dir/:file
@@ -172,6 +230,41 @@ class ReposAPITestShowQuery(APITest):
         self.check_equal(resp.json()[0]["error"], u'parsing failed: unexpected token ): expecting end of query')
 
 
+class ReposAPITestShowStream(APITest):
+    """
+    GET /api/repos/:name/packages (streamed)
+    """
+    def check(self):
+        repo_name = self.random_name()
+
+        self.check_equal(self.post("/api/repos", json={"Name": repo_name, "Comment": "fun repo"}).status_code, 201)
+
+        resp = self.get("/api/repos/" + repo_name + "/packages")
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json(), [])
+
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d,
+                         "libboost-program-options-dev_1.49.0.1_i386.deb", "pyspi_0.6.1-1.3.dsc",
+                         "pyspi_0.6.1-1.3.diff.gz", "pyspi_0.6.1.orig.tar.gz",
+                         "pyspi-0.6.1-1.3.stripped.dsc").status_code, 200)
+        self.check_equal(self.post("/api/repos/" + repo_name + "/file/" + d).status_code, 200)
+
+        resp = self.get("/api/repos/" + repo_name + "/packages")
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.headers["Content-Type"], "application/json; charset=utf-8")
+        self.check_equal(resp.json(),
+                         ['Pi386 libboost-program-options-dev 1.49.0.1 918d2f433384e378',
+                          'Psource pyspi 0.6.1-1.3 3a8b37cbd9a3559e', 'Psource pyspi 0.6.1-1.4 f8f1daa806004e89'])
+
+        resp = self.get("/api/repos/" + repo_name + "/packages", params={"format": "details"})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal([(p['Key'], p['Version']) for p in resp.json()],
+                         [('Pi386 libboost-program-options-dev 1.49.0.1 918d2f433384e378', '1.49.0.1'),
+                          ('Psource pyspi 0.6.1-1.3 3a8b37cbd9a3559e', '0.6.1-1.3'),
+                          ('Psource pyspi 0.6.1-1.4 f8f1daa806004e89', '0.6.1-1.4')])
+
+
 class ReposAPITestAddMultiple(APITest):
     """
     POST /api/repos/:name/file/:dir/:file multiple
@@ -259,3 +352,168 @@ class ReposAPITestPackagesAddDelete(APITest):
         self.check_equal(sorted(self.get("/api/repos/" + repo_name2 + "/packages").json()),
                          ['Pi386 libboost-program-options-dev 1.49.0.1 918d2f433384e378',
                           'Psource pyspi 0.6.1-1.4 f8f1daa806004e89'])
+
+
+class ReposAPITestPackagesDeleteQuery(APITest):
+    """
+    DELETE /api/repos/:name/packages with queries
+    """
+    def check(self):
+        repo_name = self.random_name()
+
+        self.check_equal(self.post("/api/repos", json={"Name": repo_name}).status_code, 201)
+
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d,
+                         "libboost-program-options-dev_1.49.0.1_i386.deb", "pyspi_0.6.1-1.3.dsc",
+                         "pyspi_0.6.1-1.3.diff.gz", "pyspi_0.6.1.orig.tar.gz",
+                         "pyspi-0.6.1-1.3.stripped.dsc").status_code, 200)
+
+        self.check_equal(self.post("/api/repos/" + repo_name + "/file/" + d).status_code, 200)
+
+        resp = self.delete("/api/repos/" + repo_name + "/packages/", json={"Queries": ["pyspi ("]})
+        self.check_equal(resp.status_code, 400)
+
+        resp = self.delete("/api/repos/" + repo_name + "/packages/",
+                           json={"Queries": ["pyspi (>= 0.6.1-1.4)"], "CheckDeps": True})
+        self.check_equal(resp.status_code, 200)
+
+        self.check_equal(sorted(self.get("/api/repos/" + repo_name + "/packages").json()),
+                         ['Pi386 libboost-program-options-dev 1.49.0.1 918d2f433384e378',
+                          'Psource pyspi 0.6.1-1.3 3a8b37cbd9a3559e'])
+
+        resp = self.delete("/api/repos/" + repo_name + "/packages/",
+                           json={"PackageRefs": ['Pi386 libboost-program-options-dev 1.49.0.1 918d2f433384e378'],
+                                 "Queries": ["$Architecture (source)"]})
+        self.check_equal(resp.status_code, 200)
+
+        self.check_equal(self.get("/api/repos/" + repo_name + "/packages").json(), [])
+
+
+class ReposAPITestLabels(APITest):
+    """
+    POST /api/repos, PUT /api/repos/:name, GET /api/repos?label=
+    """
+    def check(self):
+        repo1, repo2 = self.random_name(), self.random_name()
+        label = self.random_name()
+
+        resp = self.post("/api/repos", json={"Name": repo1, "Labels": {"env": label}})
+        self.check_equal(resp.status_code, 201)
+        self.check_equal(resp.json()['Labels'], {"env": label})
+
+        self.check_equal(self.post("/api/repos", json={"Name": repo2}).status_code, 201)
+
+        resp = self.get("/api/repos", params={"label": "env=" + label})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal([r['Name'] for r in resp.json()], [repo1])
+
+        self.check_equal(self.put("/api/repos/" + repo1, json={"Labels": {"env": ""}}).status_code, 200)
+        self.check_equal(self.put("/api/repos/" + repo2, json={"Labels": {"env": label}}).status_code, 200)
+
+        resp = self.get("/api/repos", params={"label": "env=" + label})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal([r['Name'] for r in resp.json()], [repo2])
+
+
+class ReposAPITestRename(APITest):
+    """
+    PUT /api/repos/:name (rename), GET /api/publish
+    """
+    fixtureGpg = True
+
+    def check(self):
+        repo_name, other_name = self.random_name(), self.random_name()
+        self.check_equal(self.post("/api/repos", json={"Name": repo_name, "DefaultDistribution": "wheezy"}).status_code, 201)
+        self.check_equal(self.post("/api/repos", json={"Name": other_name}).status_code, 201)
+
+        # rename to existing name
+        self.check_equal(self.put("/api/repos/" + repo_name, json={"Name": other_name}).status_code, 409)
+
+        # rename unpublished repo
+        new_name = self.random_name()
+        resp = self.put("/api/repos/" + repo_name, json={"Name": new_name})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json()['Name'], new_name)
+        self.check_equal(self.get("/api/repos/" + repo_name).status_code, 404)
+        self.check_equal(self.get("/api/repos/" + new_name).status_code, 200)
+
+        # rename published repo
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d, "libboost-program-options-dev_1.49.0.1_i386.deb").status_code, 200)
+        self.check_equal(self.post("/api/repos/" + new_name + "/file/" + d).status_code, 200)
+
+        prefix = self.random_name()
+        resp = self.post("/api/publish/" + prefix + "/repos",
+                         json={
+                             "Sources": [{"Name": new_name}],
+                             "Signing": DefaultSigningOptions,
+                         })
+        self.check_equal(resp.status_code, 200)
+
+        renamed = self.random_name()
+        self.check_equal(self.put("/api/repos/" + new_name, json={"Name": renamed}).status_code, 200)
+
+        published = [p for p in self.get("/api/publish").json() if p['Prefix'] == prefix]
+        self.check_equal(len(published), 1)
+        self.check_equal(published[0]['Sources'], [{'Component': 'main', 'Name': renamed}])
+
+
+class ReposAPITestPublishedFilter(APITest):
+    """
+    GET /api/repos?published=
+    """
+    def check(self):
+        repo1, repo2 = self.random_name(), self.random_name()
+        self.check_equal(self.post("/api/repos", json={"Name": repo1}).status_code, 201)
+        self.check_equal(self.post("/api/repos", json={"Name": repo2}).status_code, 201)
+
+        resp = self.post("/api/publish/" + self.random_name() + "/repos",
+                         json={
+                             "Sources": [{"Name": repo1}],
+                             "Distribution": "wheezy",
+                             "Architectures": ["i386"],
+                             "Signing": {"Skip": True},
+                         })
+        self.check_equal(resp.status_code, 200)
+
+        resp = self.get("/api/repos", params={"published": "1"})
+        self.check_equal(resp.status_code, 200)
+        names = [r['Name'] for r in resp.json()]
+        self.check_equal(repo1 in names, True)
+        self.check_equal(repo2 in names, False)
+
+        resp = self.get("/api/repos", params={"published": "0"})
+        self.check_equal(resp.status_code, 200)
+        names = [r['Name'] for r in resp.json()]
+        self.check_equal(repo1 in names, False)
+        self.check_equal(repo2 in names, True)
+
+        self.check_equal(self.get("/api/repos", params={"published": "maybe"}).status_code, 400)
+
+
+class ReposAPITestAddVerifyDscKeyring(APITest):
+    """
+    POST /api/repos/:name/file/:dir?verifyDsc=1&keyring= only accepts managed keyrings
+    """
+    def check(self):
+        repo_name = self.random_name()
+
+        self.check_equal(self.post("/api/repos", json={"Name": repo_name}).status_code, 201)
+
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d,
+                         "pyspi_0.6.1-1.3.dsc", "pyspi_0.6.1-1.3.diff.gz", "pyspi_0.6.1.orig.tar.gz").status_code, 200)
+
+        # keyring can't be referenced by path on server
+        resp = self.post("/api/repos/" + repo_name + "/file/" + d,
+                         params={"verifyDsc": "1", "keyring": "/etc/passwd"})
+        self.check_equal(resp.status_code, 400)
+
+        # unknown managed keyring
+        resp = self.post("/api/repos/" + repo_name + "/file/" + d,
+                         params={"verifyDsc": "1", "keyring": self.random_name()})
+        self.check_equal(resp.status_code, 400)
+
+        self.check_equal(self.get("/api/repos/" + repo_name + "/packages").json(), [])
+        self.check_exists("upload/" + d + "/pyspi_0.6.1-1.3.dsc")
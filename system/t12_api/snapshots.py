@@ -1,3 +1,5 @@
+import time
+
 from api_lib import APITest
 from publish import DefaultSigningOptions
 
@@ -198,6 +200,20 @@ class SnapshotsAPITestCreateDelete(APITest):
         self.check_equal(self.delete("/api/snapshots/" + snap2).status_code, 409)
         self.check_equal(self.delete("/api/snapshots/" + snap2, params={"force": "1"}).status_code, 409)
 
+        # deleting pinned snapshot
+        snap3 = self.random_name()
+        self.check_equal(self.post("/api/snapshots", json={"Name": snap3}).status_code, 201)
+        resp = self.put("/api/snapshots/" + snap3, json={"Pinned": True})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json()['Pinned'], True)
+
+        self.check_equal(self.delete("/api/snapshots/" + snap3).status_code, 409)
+        self.check_equal(self.get("/api/snapshots/" + snap3).status_code, 200)
+
+        self.check_equal(self.put("/api/snapshots/" + snap3, json={"Pinned": False}).status_code, 200)
+        self.check_equal(self.delete("/api/snapshots/" + snap3).status_code, 200)
+        self.check_equal(self.get("/api/snapshots/" + snap3).status_code, 404)
+
 
 class SnapshotsAPITestSearch(APITest):
     """
@@ -272,3 +288,252 @@ class SnapshotsAPITestDiff(APITest):
         resp = self.get("/api/snapshots/" + snapshots[1] + "/diff/" + snapshots[1])
         self.check_equal(resp.status_code, 200)
         self.check_equal(resp.json(), [])
+
+
+class SnapshotsAPITestCreateFromRepoAndClear(APITest):
+    """
+    POST /api/repos/:name/snapshot-and-clear, GET /api/snapshots/:name/packages, GET /api/repos/:name/packages
+    """
+    def check(self):
+        repo_name = self.random_name()
+        snapshot_name = self.random_name()
+        self.check_equal(self.post("/api/repos", json={"Name": repo_name}).status_code, 201)
+
+        # empty repo can't be snapshotted
+        resp = self.post("/api/repos/" + repo_name + '/snapshot-and-clear', json={'Name': snapshot_name})
+        self.check_equal(resp.status_code, 400)
+
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d,
+                         "libboost-program-options-dev_1.49.0.1_i386.deb").status_code, 200)
+
+        self.check_equal(self.post("/api/repos/" + repo_name + "/file/" + d).status_code, 200)
+
+        resp = self.post("/api/repos/" + repo_name + '/snapshot-and-clear', json={'Name': snapshot_name})
+        self.check_equal(resp.status_code, 201)
+
+        self.check_equal(self.get("/api/snapshots/" + snapshot_name + "/packages").json(),
+                         ['Pi386 libboost-program-options-dev 1.49.0.1 918d2f433384e378'])
+        self.check_equal(self.get("/api/repos/" + repo_name + "/packages").json(), [])
+
+        # duplicate snapshot name, repo stays untouched
+        self.check_equal(self.upload("/api/files/" + d,
+                         "libboost-program-options-dev_1.49.0.1_i386.deb").status_code, 200)
+        self.check_equal(self.post("/api/repos/" + repo_name + "/file/" + d).status_code, 200)
+
+        resp = self.post("/api/repos/" + repo_name + '/snapshot-and-clear', json={'Name': snapshot_name})
+        self.check_equal(resp.status_code, 400)
+        self.check_equal(self.get("/api/repos/" + repo_name + "/packages").json(),
+                         ['Pi386 libboost-program-options-dev 1.49.0.1 918d2f433384e378'])
+
+
+class SnapshotsAPITestLabels(APITest):
+    """
+    POST /api/snapshots, PUT /api/snapshots/:name, GET /api/snapshots?label=
+    """
+    def check(self):
+        snap1, snap2 = self.random_name(), self.random_name()
+        label = self.random_name()
+
+        resp = self.post("/api/snapshots", json={"Name": snap1, "Labels": {"env": label}})
+        self.check_equal(resp.status_code, 201)
+        self.check_equal(resp.json()['Labels'], {"env": label})
+
+        self.check_equal(self.post("/api/snapshots", json={"Name": snap2}).status_code, 201)
+
+        resp = self.get("/api/snapshots", params={"label": "env=" + label})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal([s['Name'] for s in resp.json()], [snap1])
+
+        resp = self.put("/api/snapshots/" + snap2, json={"Labels": {"env": label, "team": "core"}})
+        self.check_equal(resp.status_code, 200)
+
+        resp = self.get("/api/snapshots", params={"label": ["env=" + label, "team=core"]})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal([s['Name'] for s in resp.json()], [snap2])
+
+        self.check_equal(self.get("/api/snapshots", params={"label": "env"}).status_code, 400)
+
+
+class SnapshotsAPITestRenamePublished(APITest):
+    """
+    PUT /api/snapshots/:name (rename published snapshot), GET /api/publish
+    """
+    fixtureGpg = True
+
+    def check(self):
+        snapshot_name = self.random_name()
+        self.check_equal(self.post("/api/snapshots", json={"Name": snapshot_name}).status_code, 201)
+
+        prefix = self.random_name()
+        resp = self.post("/api/publish/" + prefix + "/snapshots",
+                         json={
+                             "Distribution": "trusty",
+                             "Architectures": ["i386"],
+                             "Sources": [{"Name": snapshot_name}],
+                             "Signing": DefaultSigningOptions,
+                         })
+        self.check_equal(resp.status_code, 200)
+
+        new_name = self.random_name()
+        self.check_equal(self.put("/api/snapshots/" + snapshot_name, json={"Name": new_name}).status_code, 200)
+        self.check_equal(self.get("/api/snapshots/" + snapshot_name).status_code, 404)
+
+        published = [p for p in self.get("/api/publish").json() if p['Prefix'] == prefix]
+        self.check_equal(len(published), 1)
+        self.check_equal(published[0]['Sources'], [{'Component': 'main', 'Name': new_name}])
+
+        # published snapshot is still protected under new name
+        self.check_equal(self.delete("/api/snapshots/" + new_name).status_code, 409)
+
+
+class SnapshotsAPITestCreateBatch(APITest):
+    """
+    POST /api/snapshots/batch
+    """
+    def check(self):
+        repo1 = self.random_name()
+        repo2 = self.random_name()
+        self.check_equal(self.post("/api/repos", json={"Name": repo1}).status_code, 201)
+        self.check_equal(self.post("/api/repos", json={"Name": repo2}).status_code, 201)
+
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d,
+                         "libboost-program-options-dev_1.49.0.1_i386.deb").status_code, 200)
+        self.check_equal(self.post("/api/repos/" + repo1 + "/file/" + d).status_code, 200)
+
+        missing = self.random_name()
+        resp = self.post("/api/snapshots/batch",
+                         json={"Sources": [{"Name": repo1}, {"Name": repo2, "Kind": "repo"}, {"Name": missing}],
+                               "NameTemplate": "{source}-{date}",
+                               "Description": "nightly"})
+        self.check_equal(resp.status_code, 200)
+
+        suffix = "-" + time.strftime("%Y%m%d")
+        results = resp.json()['Results']
+        self.check_equal(len(results), 3)
+        self.check_equal([r['Snapshot'] for r in results[:2]], [repo1 + suffix, repo2 + suffix])
+        self.check_equal([r['Kind'] for r in results[:2]], ["repo", "repo"])
+        self.check_equal('Error' in results[0], False)
+        self.check_equal(results[2]['Snapshot'], "")
+        self.check_equal(results[2]['Error'], "mirror or local repo with name %s not found" % missing)
+
+        resp_snapshot = self.get("/api/snapshots/" + repo1 + suffix)
+        self.check_equal(resp_snapshot.status_code, 200)
+        self.check_equal(resp_snapshot.json()['Description'], "nightly")
+        self.check_equal(len(self.get("/api/snapshots/" + repo1 + suffix + "/packages").json()), 1)
+        self.check_equal(self.get("/api/snapshots/" + repo2 + suffix).status_code, 200)
+
+        task = [t for t in self.get("/api/tasks").json() if t['ID'] == resp.json()['Task']]
+        self.check_equal(len(task), 1)
+        self.check_equal(task[0]['State'], 'FAILED')
+
+        # snapshot with the same name already exists
+        resp = self.post("/api/snapshots/batch",
+                         json={"Sources": [{"Name": repo1}], "NameTemplate": "{source}-{date}"})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json()['Results'][0]['Error'] != "", True)
+
+
+class SnapshotsAPITestFilter(APITest):
+    """
+    POST /api/snapshots/:name/filter
+    """
+    def check(self):
+        repo_name = self.random_name()
+        self.check_equal(self.post("/api/repos", json={"Name": repo_name}).status_code, 201)
+
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d,
+                         "libboost-program-options-dev_1.49.0.1_i386.deb", "pyspi_0.6.1-1.3.dsc",
+                         "pyspi_0.6.1-1.3.diff.gz", "pyspi_0.6.1.orig.tar.gz").status_code, 200)
+        self.check_equal(self.post("/api/repos/" + repo_name + "/file/" + d).status_code, 200)
+
+        # app depends on libapp
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d,
+                         "app_1.0_i386.deb", "libapp_1.0_i386.deb", directory="t12_api/deps").status_code, 200)
+        self.check_equal(self.post("/api/repos/" + repo_name + "/file/" + d).status_code, 200)
+
+        snapshot_name = self.random_name()
+        self.check_equal(self.post("/api/repos/" + repo_name + '/snapshots', json={'Name': snapshot_name}).status_code, 201)
+
+        # by name
+        filtered_name = self.random_name()
+        resp = self.post("/api/snapshots/" + snapshot_name + "/filter",
+                         json={"Name": filtered_name, "Queries": ["libboost-program-options-dev"]})
+        self.check_equal(resp.status_code, 201)
+        self.check_equal(resp.json()['Description'],
+                         "Filtered '" + snapshot_name + "', query was: 'libboost-program-options-dev'")
+        self.check_equal(self.get("/api/snapshots/" + filtered_name + "/packages").json(),
+                         ["Pi386 libboost-program-options-dev 1.49.0.1 918d2f433384e378"])
+
+        # by architecture
+        filtered_name = self.random_name()
+        resp = self.post("/api/snapshots/" + snapshot_name + "/filter",
+                         json={"Name": filtered_name, "Queries": ["$Architecture (source)"], "Description": "sources"})
+        self.check_equal(resp.status_code, 201)
+        self.check_equal(resp.json()['Description'], "sources")
+        self.check_equal(self.get("/api/snapshots/" + filtered_name + "/packages").json(),
+                         ["Psource pyspi 0.6.1-1.3 3a8b37cbd9a3559e"])
+
+        # without deps, only matching package is included
+        filtered_name = self.random_name()
+        resp = self.post("/api/snapshots/" + snapshot_name + "/filter",
+                         json={"Name": filtered_name, "Queries": ["app"]})
+        self.check_equal(resp.status_code, 201)
+        self.check_equal([p.split(" ")[1] for p in self.get("/api/snapshots/" + filtered_name + "/packages").json()],
+                         ["app"])
+
+        # with deps, libapp is pulled in as dependency of app
+        filtered_name = self.random_name()
+        resp = self.post("/api/snapshots/" + snapshot_name + "/filter",
+                         json={"Name": filtered_name, "Queries": ["app"], "WithDeps": True,
+                               "Architectures": ["i386"]})
+        self.check_equal(resp.status_code, 201)
+        self.check_equal(sorted(p.split(" ")[1] for p in self.get("/api/snapshots/" + filtered_name + "/packages").json()),
+                         ["app", "libapp"])
+
+        # duplicate name
+        resp = self.post("/api/snapshots/" + snapshot_name + "/filter",
+                         json={"Name": filtered_name, "Queries": ["pyspi"]})
+        self.check_equal(resp.status_code, 400)
+
+        # bad query
+        resp = self.post("/api/snapshots/" + snapshot_name + "/filter",
+                         json={"Name": self.random_name(), "Queries": ["pyspi ("]})
+        self.check_equal(resp.status_code, 400)
+
+        # missing source snapshot
+        resp = self.post("/api/snapshots/" + self.random_name() + "/filter",
+                         json={"Name": self.random_name(), "Queries": ["pyspi"]})
+        self.check_equal(resp.status_code, 404)
+
+
+class SnapshotsAPITestShowDepProblems(APITest):
+    """
+    GET /api/snapshots/:name?withDepProblems=1
+    """
+    def check(self):
+        repo_name = self.random_name()
+        snapshot_name = self.random_name()
+        self.check_equal(self.post("/api/repos", json={"Name": repo_name}).status_code, 201)
+
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d,
+                         "libboost-program-options-dev_1.49.0.1_i386.deb").status_code, 200)
+        self.check_equal(self.post("/api/repos/" + repo_name + "/file/" + d).status_code, 200)
+
+        resp = self.post("/api/repos/" + repo_name + '/snapshots', json={'Name': snapshot_name})
+        self.check_equal(resp.status_code, 201)
+
+        resp = self.get("/api/snapshots/" + snapshot_name)
+        self.check_equal(resp.status_code, 200)
+        self.check_equal('DependencyProblems' in resp.json(), False)
+
+        resp = self.get("/api/snapshots/" + snapshot_name, params={"withDepProblems": 1})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json()['Name'], snapshot_name)
+        self.check_equal(resp.json()['DependencyProblems'], [
+            {u'Package': u'libboost-program-options-dev_1.49.0.1_i386',
+             u'Missing': [u'libboost-program-options1.49-dev [i386]']}])
@@ -0,0 +1,40 @@
+from api_lib import APITest
+from publish import DefaultSigningOptions
+
+
+class TasksAPITestList(APITest):
+    """
+    GET /tasks, DELETE /tasks/:id
+    """
+    fixtureGpg = True
+
+    def check(self):
+        repo_name = self.random_name()
+        self.check_equal(self.post("/api/repos", json={"Name": repo_name, "DefaultDistribution": "wheezy"}).status_code, 201)
+
+        d = self.random_name()
+        self.check_equal(self.upload("/api/files/" + d,
+                         "libboost-program-options-dev_1.49.0.1_i386.deb").status_code, 200)
+        self.check_equal(self.post("/api/repos/" + repo_name + "/file/" + d).status_code, 200)
+
+        prefix = self.random_name()
+        resp = self.post("/api/publish/" + prefix + "/repos",
+                         json={
+                             "Sources": [{"Name": repo_name}],
+                             "Signing": DefaultSigningOptions,
+                         })
+        self.check_equal(resp.status_code, 200)
+
+        resp = self.get("/api/tasks")
+        self.check_equal(resp.status_code, 200)
+
+        task = [t for t in resp.json() if t['Name'].startswith("publish " + prefix + "/wheezy ")]
+        self.check_equal(len(task), 1)
+        self.check_equal(task[0]['State'], 'SUCCEEDED')
+
+        # finished task can't be cancelled
+        self.check_equal(self.delete("/api/tasks/" + str(task[0]['ID'])).status_code, 409)
+
+        # unknown task
+        self.check_equal(self.delete("/api/tasks/999999").status_code, 404)
+        self.check_equal(self.delete("/api/tasks/abc").status_code, 404)
@@ -8,3 +8,21 @@ class VersionAPITest(APITest):
 
     def check(self):
         self.check_equal(self.get("/api/version").json(), {'Version': '0.9~dev'})
+
+
+class VersionAPITestCompare(APITest):
+    """
+    GET /version/compare
+    """
+
+    def check(self):
+        resp = self.get("/api/version/compare", params={"a": "1.0~rc1", "op": "lt", "b": "1.0"})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json(), {'Result': True})
+
+        resp = self.get("/api/version/compare", params={"a": "1:0.1", "op": "<<", "b": "2.0"})
+        self.check_equal(resp.status_code, 200)
+        self.check_equal(resp.json(), {'Result': False})
+
+        resp = self.get("/api/version/compare", params={"a": "1.0", "op": "lt-nl", "b": "1.1"})
+        self.check_equal(resp.status_code, 400)
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEntry is single record in audit journal
+//
+// Each operation is recorded twice: before it's started (Status is zero)
+// and once it's finished, with the same ID. Status is HTTP response status
+// for API requests, and 200 (success) or 500 (failure) for commands.
+type AuditEntry struct {
+	ID        string
+	Time      time.Time
+	Operation string
+	Target    string
+	Principal string `json:",omitempty"`
+	Status    int    `json:",omitempty"`
+}
+
+// AuditJournal is append-only journal of mutating operations, one
+// JSON-encoded entry per line
+type AuditJournal struct {
+	sync.Mutex
+	path string
+}
+
+// NewAuditJournal creates journal stored in file path
+func NewAuditJournal(path string) *AuditJournal {
+	return &AuditJournal{path: path}
+}
+
+// Record appends entry to the journal, entry is synced to disk before returning
+func (journal *AuditJournal) Record(entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	journal.Lock()
+	defer journal.Unlock()
+
+	file, err := os.OpenFile(journal.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("unable to open audit journal: %s", err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("unable to write audit journal: %s", err)
+	}
+
+	return file.Sync()
+}
+
+// Track records entry ahead of calling fn, so fn isn't called at all if entry
+// can't be recorded. Once fn returns, entry is recorded again with status returned
+// by fn; at that point failure to record could only be logged.
+func (journal *AuditJournal) Track(entry *AuditEntry, fn func() int) error {
+	entry.Time = time.Now()
+
+	err := journal.Record(entry)
+	if err != nil {
+		return err
+	}
+
+	entry.Status = fn()
+	entry.Time = time.Now()
+
+	if err = journal.Record(entry); err != nil {
+		log.Printf("Unable to record %s %s in audit journal: %s\n", entry.Operation, entry.Target, err)
+	}
+
+	return nil
+}
+
+// Entries returns journal entries recorded since moment in time
+func (journal *AuditJournal) Entries(since time.Time) ([]AuditEntry, error) {
+	journal.Lock()
+	defer journal.Unlock()
+
+	result := []AuditEntry{}
+
+	file, err := os.Open(journal.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, fmt.Errorf("unable to open audit journal: %s", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse audit journal: %s", err)
+		}
+
+		if !entry.Time.Before(since) {
+			result = append(result, entry)
+		}
+	}
+
+	return result, scanner.Err()
+}
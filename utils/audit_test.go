@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type AuditJournalSuite struct {
+	path    string
+	journal *AuditJournal
+}
+
+var _ = Suite(&AuditJournalSuite{})
+
+func (s *AuditJournalSuite) SetUpTest(c *C) {
+	s.path = filepath.Join(c.MkDir(), "audit.jsonl")
+	s.journal = NewAuditJournal(s.path)
+}
+
+func (s *AuditJournalSuite) TestEmpty(c *C) {
+	entries, err := s.journal.Entries(time.Time{})
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 0)
+}
+
+func (s *AuditJournalSuite) TestRecordEntries(c *C) {
+	start := time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	c.Assert(s.journal.Record(&AuditEntry{ID: "1", Time: start, Operation: "POST", Target: "/api/repos"}), IsNil)
+	c.Assert(s.journal.Record(&AuditEntry{ID: "1", Time: start, Operation: "POST", Target: "/api/repos", Status: 201}), IsNil)
+	c.Assert(s.journal.Record(&AuditEntry{ID: "2", Time: start.Add(time.Hour), Operation: "DELETE", Target: "/api/repos/a",
+		Principal: "admin"}), IsNil)
+
+	contents, _ := ioutil.ReadFile(s.path)
+	c.Check(string(contents), Equals,
+		`{"ID":"1","Time":"2015-03-01T10:00:00Z","Operation":"POST","Target":"/api/repos"}`+"\n"+
+			`{"ID":"1","Time":"2015-03-01T10:00:00Z","Operation":"POST","Target":"/api/repos","Status":201}`+"\n"+
+			`{"ID":"2","Time":"2015-03-01T11:00:00Z","Operation":"DELETE","Target":"/api/repos/a","Principal":"admin"}`+"\n")
+
+	entries, err := s.journal.Entries(time.Time{})
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 3)
+	c.Check(entries[1].Status, Equals, 201)
+
+	entries, err = s.journal.Entries(start.Add(time.Minute))
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 1)
+	c.Check(entries[0].Principal, Equals, "admin")
+	c.Check(entries[0].Time.Equal(start.Add(time.Hour)), Equals, true)
+}
+
+func (s *AuditJournalSuite) TestTrack(c *C) {
+	called := false
+	err := s.journal.Track(&AuditEntry{ID: "1", Operation: "repo add", Target: "a"}, func() int {
+		entries, _ := s.journal.Entries(time.Time{})
+		c.Check(entries, HasLen, 1)
+		called = true
+		return 200
+	})
+	c.Assert(err, IsNil)
+	c.Check(called, Equals, true)
+
+	entries, err := s.journal.Entries(time.Time{})
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 2)
+	c.Check(entries[0].Status, Equals, 0)
+	c.Check(entries[1].ID, Equals, "1")
+	c.Check(entries[1].Status, Equals, 200)
+
+	journal := NewAuditJournal(filepath.Join(c.MkDir(), "missing", "audit.jsonl"))
+	err = journal.Track(&AuditEntry{ID: "2"}, func() int {
+		c.Fatal("should not be called")
+		return 0
+	})
+	c.Check(err, ErrorMatches, "unable to open audit journal: .*")
+}
+
+func (s *AuditJournalSuite) TestCorrupted(c *C) {
+	c.Assert(ioutil.WriteFile(s.path, []byte("{\n"), 0644), IsNil)
+
+	_, err := s.journal.Entries(time.Time{})
+	c.Check(err, ErrorMatches, "unable to parse audit journal: .*")
+}
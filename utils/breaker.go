@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CircuitBreakerConfig is configuration of circuit breaker for published storage endpoint
+type CircuitBreakerConfig struct {
+	// Threshold is number of consecutive failures which opens the breaker (0 disables breaker)
+	Threshold int `json:"threshold" yaml:"threshold"`
+	// Cooldown is time in seconds before the breaker lets next call through
+	Cooldown int `json:"cooldown" yaml:"cooldown"`
+}
+
+// Circuit breaker states
+const (
+	CircuitClosed = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+// CircuitOpenError is returned when calls are short-circuited by open breaker
+type CircuitOpenError struct {
+	Failures int
+	RetryAt  time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("endpoint unavailable: %d consecutive failures, next attempt at %s", e.Failures, e.RetryAt.Format(time.RFC3339))
+}
+
+// CircuitBreaker stops calling failing endpoint after number of consecutive failures
+//
+// When breaker is open, calls fail immediately until cooldown period expires, after that
+// breaker is half-open: single call is let through, if it succeeds breaker is closed again,
+// otherwise it is opened for another cooldown period.
+type CircuitBreaker struct {
+	sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     int
+	failures  int
+	openedAt  time.Time
+
+	// now is current time source, replaced in tests
+	now func() time.Time
+}
+
+// NewCircuitBreaker creates circuit breaker from configuration, nil config
+// or zero threshold gives breaker which is never opened
+func NewCircuitBreaker(config *CircuitBreakerConfig) *CircuitBreaker {
+	breaker := &CircuitBreaker{now: time.Now}
+	if config != nil {
+		breaker.threshold = config.Threshold
+		breaker.cooldown = time.Duration(config.Cooldown) * time.Second
+	}
+	return breaker
+}
+
+// State returns current state of the breaker
+func (breaker *CircuitBreaker) State() int {
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	if breaker.state == CircuitOpen && !breaker.now().Before(breaker.openedAt.Add(breaker.cooldown)) {
+		return CircuitHalfOpen
+	}
+	return breaker.state
+}
+
+// Call runs operation unless breaker is open, failure decides which errors
+// are counted as endpoint failures
+func (breaker *CircuitBreaker) Call(operation func() error, failure func(err error) bool) error {
+	if breaker.threshold <= 0 {
+		return operation()
+	}
+
+	breaker.Lock()
+	if breaker.state != CircuitClosed {
+		retryAt := breaker.openedAt.Add(breaker.cooldown)
+		if breaker.state == CircuitHalfOpen || breaker.now().Before(retryAt) {
+			breaker.Unlock()
+			return &CircuitOpenError{Failures: breaker.failures, RetryAt: retryAt}
+		}
+		breaker.state = CircuitHalfOpen
+	}
+	breaker.Unlock()
+
+	err := operation()
+
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	if err != nil && failure(err) {
+		breaker.failures++
+		if breaker.state == CircuitHalfOpen || breaker.failures >= breaker.threshold {
+			breaker.state = CircuitOpen
+			breaker.openedAt = breaker.now()
+		}
+	} else {
+		breaker.failures = 0
+		breaker.state = CircuitClosed
+	}
+
+	return err
+}
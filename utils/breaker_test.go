@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type CircuitBreakerSuite struct {
+	breaker *CircuitBreaker
+	now     time.Time
+	calls   int
+}
+
+var _ = Suite(&CircuitBreakerSuite{})
+
+var (
+	errEndpoint = errors.New("endpoint failure")
+	errNotFound = errors.New("not found")
+)
+
+func (s *CircuitBreakerSuite) SetUpTest(c *C) {
+	s.now = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.calls = 0
+	s.breaker = NewCircuitBreaker(&CircuitBreakerConfig{Threshold: 3, Cooldown: 60})
+	s.breaker.now = func() time.Time { return s.now }
+}
+
+func (s *CircuitBreakerSuite) call(result error) error {
+	return s.breaker.Call(func() error {
+		s.calls++
+		return result
+	}, func(err error) bool { return err == errEndpoint })
+}
+
+func (s *CircuitBreakerSuite) TestDisabled(c *C) {
+	s.breaker = NewCircuitBreaker(nil)
+
+	for i := 0; i < 10; i++ {
+		c.Check(s.call(errEndpoint), Equals, errEndpoint)
+	}
+	c.Check(s.calls, Equals, 10)
+	c.Check(s.breaker.State(), Equals, CircuitClosed)
+}
+
+func (s *CircuitBreakerSuite) TestOpenHalfOpen(c *C) {
+	c.Check(s.call(errEndpoint), Equals, errEndpoint)
+	c.Check(s.call(errEndpoint), Equals, errEndpoint)
+	c.Check(s.breaker.State(), Equals, CircuitClosed)
+	c.Check(s.call(errEndpoint), Equals, errEndpoint)
+	c.Check(s.breaker.State(), Equals, CircuitOpen)
+
+	err := s.call(nil)
+	c.Check(err, ErrorMatches, "endpoint unavailable: 3 consecutive failures, next attempt at 2015-01-01T00:01:00Z")
+	c.Check(s.calls, Equals, 3)
+
+	// after cooldown single call is let through, failure opens breaker again
+	s.now = s.now.Add(time.Minute)
+	c.Check(s.breaker.State(), Equals, CircuitHalfOpen)
+	c.Check(s.call(errEndpoint), Equals, errEndpoint)
+	c.Check(s.calls, Equals, 4)
+	c.Check(s.breaker.State(), Equals, CircuitOpen)
+	c.Check(s.call(nil), FitsTypeOf, &CircuitOpenError{})
+
+	// successful call closes breaker
+	s.now = s.now.Add(time.Minute)
+	c.Check(s.call(nil), IsNil)
+	c.Check(s.calls, Equals, 5)
+	c.Check(s.breaker.State(), Equals, CircuitClosed)
+	c.Check(s.call(errEndpoint), Equals, errEndpoint)
+	c.Check(s.breaker.State(), Equals, CircuitClosed)
+}
+
+func (s *CircuitBreakerSuite) TestNotCounted(c *C) {
+	c.Check(s.call(errEndpoint), Equals, errEndpoint)
+	c.Check(s.call(errEndpoint), Equals, errEndpoint)
+	c.Check(s.call(errNotFound), Equals, errNotFound)
+	c.Check(s.call(errEndpoint), Equals, errEndpoint)
+	c.Check(s.breaker.State(), Equals, CircuitClosed)
+}
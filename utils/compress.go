@@ -2,16 +2,95 @@ package utils
 
 import (
 	"compress/gzip"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"sort"
 )
 
+// Compression formats for index files
+const (
+	CompressionGzip  = "gz"
+	CompressionBzip2 = "bz2"
+)
+
+// DefaultCompressions lists formats index files are compressed to by default
+var DefaultCompressions = []string{CompressionGzip, CompressionBzip2}
+
+// CompressionLevels specifies compression level for each format,
+// missing (or zero) level means default level of the format
+type CompressionLevels map[string]int
+
+// compressionLevelRanges lists valid levels for each format
+var compressionLevelRanges = map[string][2]int{
+	CompressionGzip:  {gzip.BestSpeed, gzip.BestCompression},
+	CompressionBzip2: {1, 9},
+}
+
+// Validate checks that levels are in range supported by each format
+func (levels CompressionLevels) Validate() error {
+	formats := make([]string, 0, len(levels))
+	for format := range levels {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+
+	for _, format := range formats {
+		level := levels[format]
+		if level == 0 {
+			continue
+		}
+
+		levelRange, ok := compressionLevelRanges[format]
+		if !ok {
+			return fmt.Errorf("unknown compression format: %s", format)
+		}
+
+		if level < levelRange[0] || level > levelRange[1] {
+			return fmt.Errorf("%s compression level %d is out of range %d-%d", format, level, levelRange[0], levelRange[1])
+		}
+	}
+
+	return nil
+}
+
 // CompressFile compresses file specified by source to .gz & .bz2
+func CompressFile(source *os.File) error {
+	return CompressFileFormats(source, DefaultCompressions, nil)
+}
+
+// CompressFileFormats compresses file specified by source to each of formats
+// using compression levels (default level for formats missing in levels)
 //
 // It uses internal gzip and external bzip2, see:
 // https://code.google.com/p/go/issues/detail?id=4828
-func CompressFile(source *os.File) error {
+func CompressFileFormats(source *os.File, formats []string, levels CompressionLevels) error {
+	for _, format := range formats {
+		var err error
+
+		switch format {
+		case CompressionGzip:
+			err = compressGzip(source, levels[CompressionGzip])
+		case CompressionBzip2:
+			args := []string{"-k", "-f"}
+			if level := levels[CompressionBzip2]; level != 0 {
+				args = append(args, fmt.Sprintf("-%d", level))
+			}
+			err = exec.Command("bzip2", append(args, source.Name())...).Run()
+		default:
+			err = fmt.Errorf("unknown compression format: %s", format)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func compressGzip(source *os.File, level int) error {
 	gzPath := source.Name() + ".gz"
 	gzFile, err := os.Create(gzPath)
 	if err != nil {
@@ -19,15 +98,17 @@ func CompressFile(source *os.File) error {
 	}
 	defer gzFile.Close()
 
-	gzWriter := gzip.NewWriter(gzFile)
-	defer gzWriter.Close()
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
 
-	source.Seek(0, 0)
-	_, err = io.Copy(gzWriter, source)
+	gzWriter, err := gzip.NewWriterLevel(gzFile, level)
 	if err != nil {
 		return err
 	}
+	defer gzWriter.Close()
 
-	cmd := exec.Command("bzip2", "-k", "-f", source.Name())
-	return cmd.Run()
+	source.Seek(0, 0)
+	_, err = io.Copy(gzWriter, source)
+	return err
 }
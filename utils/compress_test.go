@@ -3,6 +3,7 @@ package utils
 import (
 	"compress/bzip2"
 	"compress/gzip"
+	"fmt"
 	"io/ioutil"
 	"os"
 
@@ -58,3 +59,42 @@ func (s *CompressSuite) TestCompress(c *C) {
 
 	c.Check(string(buf), Equals, testString)
 }
+
+func (s *CompressSuite) TestCompressFormats(c *C) {
+	err := CompressFileFormats(s.tempfile, []string{CompressionGzip}, nil)
+	c.Assert(err, IsNil)
+
+	_, err = os.Stat(s.tempfile.Name() + ".gz")
+	c.Check(err, IsNil)
+	_, err = os.Stat(s.tempfile.Name() + ".bz2")
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	c.Check(CompressFileFormats(s.tempfile, []string{"xz"}, nil), ErrorMatches, "unknown compression format: xz")
+}
+
+func (s *CompressSuite) TestCompressionLevels(c *C) {
+	c.Check(CompressionLevels{}.Validate(), IsNil)
+	c.Check(CompressionLevels{CompressionGzip: 9, CompressionBzip2: 1}.Validate(), IsNil)
+	c.Check(CompressionLevels{CompressionGzip: 10}.Validate(), ErrorMatches, "gz compression level 10 is out of range 1-9")
+	c.Check(CompressionLevels{CompressionBzip2: -1}.Validate(), ErrorMatches, "bz2 compression level -1 is out of range 1-9")
+	c.Check(CompressionLevels{"xz": 6}.Validate(), ErrorMatches, "unknown compression format: xz")
+
+	// synthetic index, large enough for compression levels to matter
+	index, _ := ioutil.TempFile(c.MkDir(), "Packages")
+	defer index.Close()
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(index, "Package: package-%d\nVersion: %d.%d\nArchitecture: amd64\nMD5sum: %x\n\n", i, i%7, i*31%1000, i*7919)
+	}
+
+	sizes := []int64{}
+	for _, level := range []int{1, 9} {
+		err := CompressFileFormats(index, []string{CompressionGzip}, CompressionLevels{CompressionGzip: level})
+		c.Assert(err, IsNil)
+
+		st, err := os.Stat(index.Name() + ".gz")
+		c.Assert(err, IsNil)
+		sizes = append(sizes, st.Size())
+	}
+
+	c.Check(sizes[0] > sizes[1], Equals, true)
+}
@@ -2,39 +2,78 @@ package utils
 
 import (
 	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 )
 
 // ConfigStructure is structure of main configuration
 type ConfigStructure struct {
-	RootDir                string                   `json:"rootDir"`
-	DownloadConcurrency    int                      `json:"downloadConcurrency"`
-	DownloadLimit          int64                    `json:"downloadSpeedLimit"`
-	Architectures          []string                 `json:"architectures"`
-	DepFollowSuggests      bool                     `json:"dependencyFollowSuggests"`
-	DepFollowRecommends    bool                     `json:"dependencyFollowRecommends"`
-	DepFollowAllVariants   bool                     `json:"dependencyFollowAllVariants"`
-	DepFollowSource        bool                     `json:"dependencyFollowSource"`
-	GpgDisableSign         bool                     `json:"gpgDisableSign"`
-	GpgDisableVerify       bool                     `json:"gpgDisableVerify"`
-	DownloadSourcePackages bool                     `json:"downloadSourcePackages"`
-	PpaDistributorID       string                   `json:"ppaDistributorID"`
-	PpaCodename            string                   `json:"ppaCodename"`
-	S3PublishRoots         map[string]S3PublishRoot `json:"S3PublishEndpoints"`
+	RootDir                string                      `json:"rootDir" yaml:"rootDir"`
+	DownloadConcurrency    int                         `json:"downloadConcurrency" yaml:"downloadConcurrency"`
+	DownloadLimit          int64                       `json:"downloadSpeedLimit" yaml:"downloadSpeedLimit"`
+	Architectures          []string                    `json:"architectures" yaml:"architectures"`
+	DepFollowSuggests      bool                        `json:"dependencyFollowSuggests" yaml:"dependencyFollowSuggests"`
+	DepFollowRecommends    bool                        `json:"dependencyFollowRecommends" yaml:"dependencyFollowRecommends"`
+	DepFollowAllVariants   bool                        `json:"dependencyFollowAllVariants" yaml:"dependencyFollowAllVariants"`
+	DepFollowSource        bool                        `json:"dependencyFollowSource" yaml:"dependencyFollowSource"`
+	GpgDisableSign         bool                        `json:"gpgDisableSign" yaml:"gpgDisableSign"`
+	GpgDisableVerify       bool                        `json:"gpgDisableVerify" yaml:"gpgDisableVerify"`
+	GpgKey                 string                      `json:"gpgKey,omitempty" yaml:"gpgKey,omitempty"`
+	DownloadSourcePackages bool                        `json:"downloadSourcePackages" yaml:"downloadSourcePackages"`
+	PpaDistributorID       string                      `json:"ppaDistributorID" yaml:"ppaDistributorID"`
+	PpaCodename            string                      `json:"ppaCodename" yaml:"ppaCodename"`
+	GzipCompressionLevel   int                         `json:"gzipCompressionLevel" yaml:"gzipCompressionLevel"`
+	Bzip2CompressionLevel  int                         `json:"bzip2CompressionLevel" yaml:"bzip2CompressionLevel"`
+	TempDir                string                      `json:"tempDir" yaml:"tempDir"`
+	ReadOnly               bool                        `json:"readOnly" yaml:"readOnly"`
+	RetryPolicy            RetryPolicy                 `json:"retryPolicy" yaml:"retryPolicy"`
+	Debsig                 *DebsigConfig               `json:"debsig,omitempty" yaml:"debsig,omitempty"`
+	PreAddHook             string                      `json:"preAddHook,omitempty" yaml:"preAddHook,omitempty"`
+	AuditJournal           bool                        `json:"auditJournal,omitempty" yaml:"auditJournal,omitempty"`
+	DatabaseLockTimeout    int                         `json:"databaseLockTimeout,omitempty" yaml:"databaseLockTimeout,omitempty"`
+	UploadSizeLimit        int64                       `json:"uploadSizeLimit,omitempty" yaml:"uploadSizeLimit,omitempty"`
+	S3PublishRoots         map[string]S3PublishRoot    `json:"S3PublishEndpoints" yaml:"S3PublishEndpoints"`
+	MultiPublishRoots      map[string]MultiPublishRoot `json:"MultiPublishEndpoints,omitempty" yaml:"MultiPublishEndpoints,omitempty"`
+}
+
+// MultiPublishRoot describes publishing entry point which mirrors publishing to
+// several other endpoints
+type MultiPublishRoot struct {
+	// Endpoints are published storages (e.g. s3:name), empty string is local storage
+	Endpoints  []string `json:"endpoints" yaml:"endpoints"`
+	BestEffort bool     `json:"bestEffort,omitempty" yaml:"bestEffort,omitempty"`
 }
 
 // S3PublishRoot describes single S3 publishing entry point
 type S3PublishRoot struct {
-	Region           string `json:"region"`
-	Bucket           string `json:"bucket"`
-	AccessKeyID      string `json:"awsAccessKeyID"`
-	SecretAccessKey  string `json:"awsSecretAccessKey"`
-	Prefix           string `json:"prefix"`
-	ACL              string `json:"acl"`
-	StorageClass     string `json:"storageClass"`
-	EncryptionMethod string `json:"encryptionMethod"`
-	PlusWorkaround   bool   `json:"plusWorkaround"`
+	Region           string                `json:"region" yaml:"region"`
+	Endpoint         string                `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	ForcePathStyle   bool                  `json:"forcePathStyle,omitempty" yaml:"forcePathStyle,omitempty"`
+	DisableSSL       bool                  `json:"disableSSL,omitempty" yaml:"disableSSL,omitempty"`
+	Bucket           string                `json:"bucket" yaml:"bucket"`
+	AccessKeyID      string                `json:"awsAccessKeyID" yaml:"awsAccessKeyID"`
+	SecretAccessKey  string                `json:"awsSecretAccessKey" yaml:"awsSecretAccessKey"`
+	Credentials      string                `json:"credentials,omitempty" yaml:"credentials,omitempty"`
+	Prefix           string                `json:"prefix" yaml:"prefix"`
+	ACL              string                `json:"acl" yaml:"acl"`
+	StorageClass     string                `json:"storageClass" yaml:"storageClass"`
+	EncryptionMethod string                `json:"encryptionMethod" yaml:"encryptionMethod"`
+	PlusWorkaround   bool                  `json:"plusWorkaround" yaml:"plusWorkaround"`
+	Metadata         map[string]string     `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	PrescanPool      bool                  `json:"prescanPool,omitempty" yaml:"prescanPool,omitempty"`
+	RetryPolicy      *RetryPolicy          `json:"retryPolicy,omitempty" yaml:"retryPolicy,omitempty"`
+	CircuitBreaker   *CircuitBreakerConfig `json:"circuitBreaker,omitempty" yaml:"circuitBreaker,omitempty"`
+}
+
+// DebsigConfig configures verification of signatures embedded into .deb packages
+type DebsigConfig struct {
+	PoliciesDir string `json:"policiesDir" yaml:"policiesDir"`
+	KeyringsDir string `json:"keyringsDir" yaml:"keyringsDir"`
 }
 
 // Config is configuration for aptly, shared by all modules
@@ -52,19 +91,128 @@ var Config = ConfigStructure{
 	DownloadSourcePackages: false,
 	PpaDistributorID:       "ubuntu",
 	PpaCodename:            "",
+	GzipCompressionLevel:   0,
+	Bzip2CompressionLevel:  0,
+	TempDir:                "",
+	ReadOnly:               false,
+	RetryPolicy:            RetryPolicy{MaxAttempts: 1, BaseDelay: 1000, MaxDelay: 30000, Jitter: 0.1},
 	S3PublishRoots:         map[string]S3PublishRoot{},
 }
 
-// LoadConfig loads configuration from json file
+// isYAMLConfig checks whether config file is in YAML format (by extension)
+func isYAMLConfig(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// unmarshalConfig decodes config file contents, YAML or JSON depending on file extension
+func unmarshalConfig(filename string, data []byte, v interface{}) error {
+	if isYAMLConfig(filename) {
+		return yaml.Unmarshal(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// LoadConfig loads configuration from json (or yaml, if filename ends in .yaml/.yml) file
 func LoadConfig(filename string, config *ConfigStructure) error {
-	f, err := os.Open(filename)
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	dec := json.NewDecoder(f)
-	return dec.Decode(&config)
+	return unmarshalConfig(filename, data, config)
+}
+
+// ConfigDirSuffix is appended to config filename to get directory with config fragments
+const ConfigDirSuffix = ".d"
+
+// LoadConfigDir merges config fragments (*.json and *.yaml files) from directory into config
+//
+// Fragments are applied in lexical order of filenames, so settings from later files
+// override earlier ones. Map settings (e.g. publishing endpoints) are merged by name, but
+// the same entry can't be defined differently in two fragments.
+func LoadConfigDir(dirname string, config *ConfigStructure) error {
+	entries, err := ioutil.ReadDir(dirname)
+	if err != nil {
+		return err
+	}
+
+	// fragment where each map entry was defined, by field and key
+	definedIn := map[string]map[string]string{}
+
+	for _, entry := range entries {
+		if entry.IsDir() || (filepath.Ext(entry.Name()) != ".json" && !isYAMLConfig(entry.Name())) {
+			continue
+		}
+
+		filename := filepath.Join(dirname, entry.Name())
+
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+
+		var fragment ConfigStructure
+
+		err = unmarshalConfig(filename, data, &fragment)
+		if err != nil {
+			return fmt.Errorf("error loading config file %s: %s", filename, err)
+		}
+
+		err = checkConfigConflicts(config, &fragment, filename, definedIn)
+		if err != nil {
+			return err
+		}
+
+		err = unmarshalConfig(filename, data, config)
+		if err != nil {
+			return fmt.Errorf("error loading config file %s: %s", filename, err)
+		}
+	}
+
+	return nil
+}
+
+// configMapNames are human-readable names of entries of map settings
+var configMapNames = map[string]string{
+	"S3PublishRoots":    "S3 endpoint",
+	"MultiPublishRoots": "multi endpoint",
+}
+
+// checkConfigConflicts verifies that entries of every map setting in fragment
+// don't redefine differently entries coming from previously loaded fragments
+func checkConfigConflicts(config, fragment *ConfigStructure, filename string, definedIn map[string]map[string]string) error {
+	current := reflect.ValueOf(config).Elem()
+	loaded := reflect.ValueOf(fragment).Elem()
+
+	for i := 0; i < loaded.NumField(); i++ {
+		if loaded.Field(i).Kind() != reflect.Map {
+			continue
+		}
+
+		field := loaded.Type().Field(i).Name
+		if definedIn[field] == nil {
+			definedIn[field] = map[string]string{}
+		}
+
+		name, ok := configMapNames[field]
+		if !ok {
+			name = strings.Split(loaded.Type().Field(i).Tag.Get("json"), ",")[0] + " entry"
+		}
+
+		for _, key := range loaded.Field(i).MapKeys() {
+			previous, ok := definedIn[field][key.String()]
+			if ok {
+				existing := current.Field(i).MapIndex(key)
+				if !existing.IsValid() || !reflect.DeepEqual(existing.Interface(), loaded.Field(i).MapIndex(key).Interface()) {
+					return fmt.Errorf("conflicting definitions of %s %s in %s and %s", name, key.String(), previous, filename)
+				}
+			}
+			definedIn[field][key.String()] = filename
+		}
+	}
+
+	return nil
 }
 
 // SaveConfig write configuration to json file
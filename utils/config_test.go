@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -25,6 +26,80 @@ func (s *ConfigSuite) TestLoadConfig(c *C) {
 	c.Check(s.config.DownloadConcurrency, Equals, 33)
 }
 
+func (s *ConfigSuite) TestLoadConfigYAML(c *C) {
+	dir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "aptly.json"), []byte(configFileFull), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "aptly.yaml"), []byte(configFileYAML), 0644), IsNil)
+
+	var jsonConfig, yamlConfig ConfigStructure
+
+	c.Assert(LoadConfig(filepath.Join(dir, "aptly.json"), &jsonConfig), IsNil)
+	c.Assert(LoadConfig(filepath.Join(dir, "aptly.yaml"), &yamlConfig), IsNil)
+	c.Check(yamlConfig, DeepEquals, jsonConfig)
+	c.Check(yamlConfig.S3PublishRoots["backup"].Region, Equals, "eu-west-1")
+	c.Check(yamlConfig.S3PublishRoots["backup"].ACL, Equals, "private")
+
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "broken.yml"), []byte("rootDir: [\n"), 0644), IsNil)
+	c.Check(LoadConfig(filepath.Join(dir, "broken.yml"), &yamlConfig), NotNil)
+}
+
+func (s *ConfigSuite) TestLoadConfigDir(c *C) {
+	dir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "10-base.json"),
+		[]byte(`{"rootDir": "/srv/aptly", "downloadConcurrency": 10, "S3PublishEndpoints": {"main": {"region": "us-east-1", "bucket": "repo"}}}`), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "20-override.json"),
+		[]byte(`{"downloadConcurrency": 20, "S3PublishEndpoints": {"backup": {"region": "eu-west-1", "bucket": "backup"}}}`), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a config"), 0644), IsNil)
+
+	config := ConfigStructure{RootDir: "/opt/aptly", PpaCodename: "trusty"}
+
+	err := LoadConfigDir(dir, &config)
+	c.Assert(err, IsNil)
+	c.Check(config.RootDir, Equals, "/srv/aptly")
+	c.Check(config.DownloadConcurrency, Equals, 20)
+	c.Check(config.PpaCodename, Equals, "trusty")
+	c.Check(config.S3PublishRoots, DeepEquals, map[string]S3PublishRoot{
+		"main":   S3PublishRoot{Region: "us-east-1", Bucket: "repo"},
+		"backup": S3PublishRoot{Region: "eu-west-1", Bucket: "backup"},
+	})
+
+	// same endpoint defined differently
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "30-conflict.json"),
+		[]byte(`{"S3PublishEndpoints": {"main": {"region": "us-west-2", "bucket": "repo"}}}`), 0644), IsNil)
+
+	err = LoadConfigDir(dir, &ConfigStructure{})
+	c.Check(err, ErrorMatches, "conflicting definitions of S3 endpoint main in .*/10-base.json and .*/30-conflict.json")
+
+	// same multi endpoint defined differently
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "30-conflict.json"),
+		[]byte(`{"MultiPublishEndpoints": {"all": {"endpoints": ["", "s3:main"]}}}`), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "40-conflict.json"),
+		[]byte(`{"MultiPublishEndpoints": {"all": {"endpoints": ["s3:main"]}}}`), 0644), IsNil)
+
+	err = LoadConfigDir(dir, &ConfigStructure{})
+	c.Check(err, ErrorMatches, "conflicting definitions of multi endpoint all in .*/30-conflict.json and .*/40-conflict.json")
+
+	// identical definitions are fine
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "40-conflict.json"),
+		[]byte(`{"MultiPublishEndpoints": {"all": {"endpoints": ["", "s3:main"]}}}`), 0644), IsNil)
+
+	config = ConfigStructure{}
+	err = LoadConfigDir(dir, &config)
+	c.Assert(err, IsNil)
+	c.Check(config.MultiPublishRoots, DeepEquals, map[string]MultiPublishRoot{
+		"all": MultiPublishRoot{Endpoints: []string{"", "s3:main"}},
+	})
+	c.Assert(os.Remove(filepath.Join(dir, "40-conflict.json")), IsNil)
+
+	// broken fragment
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "30-conflict.json"), []byte(`{"rootDir": `), 0644), IsNil)
+
+	err = LoadConfigDir(dir, &ConfigStructure{})
+	c.Check(err, ErrorMatches, "error loading config file .*/30-conflict.json: .*")
+
+	c.Check(os.IsNotExist(LoadConfigDir(filepath.Join(dir, "nosuchdir"), &ConfigStructure{})), Equals, true)
+}
+
 func (s *ConfigSuite) TestSaveConfig(c *C) {
 	configname := filepath.Join(c.MkDir(), "aptly.json")
 
@@ -59,6 +134,16 @@ func (s *ConfigSuite) TestSaveConfig(c *C) {
 		"  \"downloadSourcePackages\": false,\n"+
 		"  \"ppaDistributorID\": \"\",\n"+
 		"  \"ppaCodename\": \"\",\n"+
+		"  \"gzipCompressionLevel\": 0,\n"+
+		"  \"bzip2CompressionLevel\": 0,\n"+
+		"  \"tempDir\": \"\",\n"+
+		"  \"readOnly\": false,\n"+
+		"  \"retryPolicy\": {\n"+
+		"    \"maxAttempts\": 0,\n"+
+		"    \"baseDelay\": 0,\n"+
+		"    \"maxDelay\": 0,\n"+
+		"    \"jitter\": 0\n"+
+		"  },\n"+
 		"  \"S3PublishEndpoints\": {\n"+
 		"    \"test\": {\n"+
 		"      \"region\": \"us-east-1\",\n"+
@@ -76,3 +161,37 @@ func (s *ConfigSuite) TestSaveConfig(c *C) {
 }
 
 const configFile = `{"rootDir": "/opt/aptly/", "downloadConcurrency": 33}`
+
+const configFileFull = `{
+  "rootDir": "/opt/aptly/",
+  "downloadConcurrency": 8,
+  "architectures": ["amd64", "i386"],
+  "dependencyFollowSuggests": true,
+  "gpgDisableSign": true,
+  "ppaCodename": "trusty",
+  "gzipCompressionLevel": 9,
+  "S3PublishEndpoints": {
+    "main": {"region": "us-east-1", "bucket": "repo", "acl": "private"},
+    "backup": {"region": "eu-west-1", "bucket": "backup", "acl": "private"}
+  }
+}`
+
+const configFileYAML = `
+# same config as configFileFull
+rootDir: /opt/aptly/
+downloadConcurrency: 8
+architectures: [amd64, i386]
+dependencyFollowSuggests: true
+gpgDisableSign: true
+ppaCodename: trusty
+gzipCompressionLevel: 9
+S3PublishEndpoints:
+  main: &endpoint
+    region: us-east-1
+    bucket: repo
+    acl: private
+  backup:
+    <<: *endpoint
+    region: eu-west-1
+    bucket: backup
+`
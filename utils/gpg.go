@@ -28,6 +28,7 @@ type Verifier interface {
 	InitKeyring() error
 	AddKeyring(keyring string)
 	VerifyDetachedSignature(signature, cleartext io.Reader) error
+	VerifyDetachedSignatureInfo(signature, cleartext io.Reader) (*SignatureInfo, error)
 	VerifyClearsigned(clearsigned io.Reader) error
 	ExtractClearsigned(clearsigned io.Reader) (text *os.File, err error)
 }
@@ -51,11 +52,28 @@ func (g *GpgSigner) SetBatch(batch bool) {
 	g.batch = batch
 }
 
-// SetKey sets key ID to use when signing files
+// SetKey sets key to use when signing files: key ID, full fingerprint
+// of (sub)key or keygrip prefixed with '&'
 func (g *GpgSigner) SetKey(keyRef string) {
 	g.keyRef = keyRef
 }
 
+var fingerprintRegexp = regexp.MustCompile("^(0[xX])?[0-9a-fA-F]{40}$")
+
+// keySelector converts key reference to gpg key specification
+//
+// Full fingerprints are suffixed with '!', so that gpg signs with exactly
+// that (sub)key instead of picking signing subkey on its own
+func (g *GpgSigner) keySelector() string {
+	keyRef := strings.Replace(g.keyRef, " ", "", -1)
+
+	if fingerprintRegexp.MatchString(keyRef) {
+		return strings.TrimPrefix(strings.TrimPrefix(keyRef, "0x"), "0X") + "!"
+	}
+
+	return g.keyRef
+}
+
 // SetKeyRing allows to set custom keyring and secretkeyring
 func (g *GpgSigner) SetKeyRing(keyring, secretKeyring string) {
 	g.keyring, g.secretKeyring = keyring, secretKeyring
@@ -66,7 +84,7 @@ func (g *GpgSigner) SetPassphrase(passphrase, passphraseFile string) {
 	g.passphrase, g.passphraseFile = passphrase, passphraseFile
 }
 
-func (g *GpgSigner) gpgArgs() []string {
+func (g *GpgSigner) keyringArgs() []string {
 	args := []string{}
 	if g.keyring != "" {
 		args = append(args, "--no-auto-check-trustdb", "--no-default-keyring", "--keyring", g.keyring)
@@ -75,8 +93,14 @@ func (g *GpgSigner) gpgArgs() []string {
 		args = append(args, "--secret-keyring", g.secretKeyring)
 	}
 
+	return args
+}
+
+func (g *GpgSigner) gpgArgs() []string {
+	args := g.keyringArgs()
+
 	if g.keyRef != "" {
-		args = append(args, "-u", g.keyRef)
+		args = append(args, "-u", g.keySelector())
 	}
 
 	if g.passphrase != "" || g.passphraseFile != "" {
@@ -109,6 +133,14 @@ func (g *GpgSigner) Init() error {
 		return fmt.Errorf("looks like there are no keys in gpg, please create one (official manual: http://www.gnupg.org/gph/en/manual.html)")
 	}
 
+	if g.keyRef != "" {
+		args := append(g.keyringArgs(), "--list-secret-keys", "--with-colons", g.keySelector())
+		output, err = exec.Command("gpg", args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("unable to find signing key %s in keyring: %s", g.keyRef, string(output))
+		}
+	}
+
 	return err
 }
 
@@ -257,6 +289,93 @@ func (g *GpgVerifier) VerifyDetachedSignature(signature, cleartext io.Reader) er
 	return g.runGpgv(args, "detached signature")
 }
 
+// SignatureInfo describes results of signature verification
+type SignatureInfo struct {
+	Valid       bool
+	KeyID       string
+	Fingerprint string
+	Signer      string
+}
+
+// parseGpgStatus extracts signature information from gpgv --status-fd output
+func parseGpgStatus(status []byte) *SignatureInfo {
+	info := &SignatureInfo{}
+	goodSig, validSig := false, false
+
+	for _, line := range strings.Split(string(status), "\n") {
+		if !strings.HasPrefix(line, "[GNUPG:] ") {
+			continue
+		}
+
+		fields := strings.SplitN(strings.TrimPrefix(line, "[GNUPG:] "), " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "GOODSIG", "BADSIG", "EXPSIG", "EXPKEYSIG", "REVKEYSIG":
+			goodSig = fields[0] == "GOODSIG"
+			info.KeyID = fields[1]
+			if len(fields) > 2 {
+				info.Signer = fields[2]
+			}
+		case "ERRSIG", "NO_PUBKEY":
+			info.KeyID = fields[1]
+		case "VALIDSIG":
+			validSig = true
+			info.Fingerprint = fields[1]
+		}
+	}
+
+	info.Valid = goodSig && validSig
+	return info
+}
+
+// VerifyDetachedSignatureInfo verifies combination of signature and cleartext using gpgv
+//
+// Unlike VerifyDetachedSignature, it doesn't fail on bad signature, but reports
+// signing key and validity of signature
+func (g *GpgVerifier) VerifyDetachedSignatureInfo(signature, cleartext io.Reader) (*SignatureInfo, error) {
+	args := g.argsKeyrings()
+
+	sigf, err := ioutil.TempFile("", "aptly-gpg")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(sigf.Name())
+	defer sigf.Close()
+
+	_, err = io.Copy(sigf, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	clearf, err := ioutil.TempFile("", "aptly-gpg")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(clearf.Name())
+	defer clearf.Close()
+
+	_, err = io.Copy(clearf, cleartext)
+	if err != nil {
+		return nil, err
+	}
+
+	args = append(args, "--status-fd", "1", sigf.Name(), clearf.Name())
+	output, err := exec.Command("gpgv", args...).Output()
+
+	info := parseGpgStatus(output)
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, fmt.Errorf("unable to execute gpgv: %s", err)
+		}
+		info.Valid = false
+	}
+
+	return info, nil
+}
+
 // VerifyClearsigned verifies clearsigned file using gpgv
 func (g *GpgVerifier) VerifyClearsigned(clearsigned io.Reader) error {
 	args := g.argsKeyrings()
@@ -329,3 +448,121 @@ func (g *GpgVerifier) ExtractClearsigned(clearsigned io.Reader) (text *os.File,
 
 	return
 }
+
+// GpgKey describes public key stored in keyring
+type GpgKey struct {
+	KeyID       string
+	Fingerprint string
+	UserIDs     []string
+}
+
+// Matches checks whether key is referenced by key ID (short or long) or fingerprint
+func (k *GpgKey) Matches(keyRef string) bool {
+	keyRef = strings.ToUpper(strings.TrimPrefix(strings.Replace(keyRef, " ", "", -1), "0x"))
+	if len(keyRef) < 8 {
+		return false
+	}
+
+	return keyRef == k.Fingerprint || strings.HasSuffix(k.KeyID, keyRef)
+}
+
+// parseGpgKeys extracts public keys from gpg --with-colons --with-fingerprint output
+func parseGpgKeys(output []byte) []GpgKey {
+	keys := []GpgKey{}
+	primary := false
+
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 10 {
+			continue
+		}
+
+		switch fields[0] {
+		case "pub":
+			keys = append(keys, GpgKey{KeyID: fields[4], UserIDs: []string{}})
+			primary = true
+		case "fpr":
+			// only fingerprint following pub line belongs to primary key
+			if primary {
+				keys[len(keys)-1].Fingerprint = fields[9]
+			}
+			primary = false
+		case "uid":
+			if len(keys) > 0 {
+				keys[len(keys)-1].UserIDs = append(keys[len(keys)-1].UserIDs, fields[9])
+			}
+			primary = false
+		default:
+			primary = false
+		}
+	}
+
+	return keys
+}
+
+// parseGpgImportStatus extracts fingerprints of imported keys from gpg --status-fd output
+func parseGpgImportStatus(status []byte) []string {
+	fingerprints := []string{}
+
+	for _, line := range strings.Split(string(status), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 4 && fields[0] == "[GNUPG:]" && fields[1] == "IMPORT_OK" {
+			fingerprints = append(fingerprints, fields[3])
+		}
+	}
+
+	return fingerprints
+}
+
+func gpgKeyringArgs(keyring string) []string {
+	return []string{"--no-default-keyring", "--no-auto-check-trustdb", "--batch", "--keyring", keyring}
+}
+
+// GpgImportKeys imports ASCII-armored public keys into keyring (created if missing),
+// returning fingerprints of imported keys
+func GpgImportKeys(keyring string, armored []byte) ([]string, error) {
+	if !bytes.Contains(armored, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		return nil, fmt.Errorf("no ASCII-armored public key found")
+	}
+
+	args := append(gpgKeyringArgs(keyring), "--status-fd", "1", "--import")
+	cmd := exec.Command("gpg", args...)
+	cmd.Stdin = bytes.NewReader(armored)
+
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to import keys: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	fingerprints := parseGpgImportStatus(output)
+	if len(fingerprints) == 0 {
+		return nil, fmt.Errorf("no keys imported: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return fingerprints, nil
+}
+
+// GpgListKeys lists public keys in the keyring
+func GpgListKeys(keyring string) ([]GpgKey, error) {
+	args := append(gpgKeyringArgs(keyring), "--with-colons", "--with-fingerprint", "--list-keys")
+	output, err := exec.Command("gpg", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list keys: %s", err)
+	}
+
+	return parseGpgKeys(output), nil
+}
+
+// GpgDeleteKey removes public key with fingerprint from the keyring
+func GpgDeleteKey(keyring string, fingerprint string) error {
+	args := append(gpgKeyringArgs(keyring), "--yes", "--delete-keys", fingerprint)
+	output, err := exec.Command("gpg", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("unable to delete key %s: %s: %s", fingerprint, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+  . "gopkg.in/check.v1"
+)
+
+type GpgSuite struct {
+}
+
+var _ = Suite(&GpgSuite{})
+
+func (s *GpgSuite) TestKeySelector(c *C) {
+	signer := &GpgSigner{}
+
+	signer.SetKey("21DBB89C16DB3E6D")
+	c.Check(signer.keySelector(), Equals, "21DBB89C16DB3E6D")
+
+	signer.SetKey("D7D6 07E8 8472 7CD0 8F48  B6F8 21DB B89C 16DB 3E6D")
+	c.Check(signer.keySelector(), Equals, "D7D607E884727CD08F48B6F821DBB89C16DB3E6D!")
+
+	signer.SetKey("0xD7D607E884727CD08F48B6F821DBB89C16DB3E6D")
+	c.Check(signer.keySelector(), Equals, "D7D607E884727CD08F48B6F821DBB89C16DB3E6D!")
+
+	signer.SetKey("D7D607E884727CD08F48B6F821DBB89C16DB3E6D!")
+	c.Check(signer.keySelector(), Equals, "D7D607E884727CD08F48B6F821DBB89C16DB3E6D!")
+
+	signer.SetKey("&2A6A0B0C45D0F9C5886C36E271C2DD0DB2C1B496")
+	c.Check(signer.keySelector(), Equals, "&2A6A0B0C45D0F9C5886C36E271C2DD0DB2C1B496")
+}
+
+func (s *GpgSuite) TestGpgArgs(c *C) {
+	signer := &GpgSigner{}
+	signer.SetKeyRing("pubring.gpg", "secring.gpg")
+	signer.SetKey("D7D607E884727CD08F48B6F821DBB89C16DB3E6D")
+
+	c.Check(signer.gpgArgs(), DeepEquals, []string{"--no-auto-check-trustdb", "--no-default-keyring", "--keyring", "pubring.gpg",
+		"--secret-keyring", "secring.gpg", "-u", "D7D607E884727CD08F48B6F821DBB89C16DB3E6D!"})
+}
+
+func (s *GpgSuite) TestParseGpgStatus(c *C) {
+	info := parseGpgStatus([]byte("[GNUPG:] NEWSIG\n" +
+		"[GNUPG:] GOODSIG 21DBB89C16DB3E6D Aptly Tester (don't use it) <test@aptly.info>\n" +
+		"[GNUPG:] VALIDSIG D7D607E884727CD08F48B6F821DBB89C16DB3E6D 2014-01-01 1388534400 0 4 0 1 2 00 D7D607E884727CD08F48B6F821DBB89C16DB3E6D\n"))
+	c.Check(info, DeepEquals, &SignatureInfo{
+		Valid:       true,
+		KeyID:       "21DBB89C16DB3E6D",
+		Fingerprint: "D7D607E884727CD08F48B6F821DBB89C16DB3E6D",
+		Signer:      "Aptly Tester (don't use it) <test@aptly.info>",
+	})
+
+	info = parseGpgStatus([]byte("[GNUPG:] NEWSIG\n" +
+		"[GNUPG:] BADSIG 21DBB89C16DB3E6D Aptly Tester (don't use it) <test@aptly.info>\n"))
+	c.Check(info.Valid, Equals, false)
+	c.Check(info.KeyID, Equals, "21DBB89C16DB3E6D")
+
+	info = parseGpgStatus([]byte("[GNUPG:] ERRSIG 8B48AD6246925553 1 8 00 1390000000 9\n" +
+		"[GNUPG:] NO_PUBKEY 8B48AD6246925553\n"))
+	c.Check(info.Valid, Equals, false)
+	c.Check(info.KeyID, Equals, "8B48AD6246925553")
+	c.Check(info.Fingerprint, Equals, "")
+}
+
+func (s *GpgSuite) TestParseGpgKeys(c *C) {
+	keys := parseGpgKeys([]byte("tru::1:1791996941:0:3:1:5\n" +
+		"pub:-:1024:17:21DBB89C16DB3E6D:1392223631:::-:::scaESCA::::::::0:\n" +
+		"fpr:::::::::C5ACD2179B5231DFE842EE6121DBB89C16DB3E6D:\n" +
+		"uid:-::::1392223631::4B16C01DA2B558C90FF5F351E9F32F814DE54225::Aptly Tester (don't use it) <test@aptly.info>::::::::::0:\n" +
+		"sub:-:1024:16:5FFBAFBC9A4BCB39:1392223631::::::e:::::::\n" +
+		"fpr:::::::::1085FD4404301F965D7E51D55FFBAFBC9A4BCB39:\n"))
+	c.Check(keys, DeepEquals, []GpgKey{{
+		KeyID:       "21DBB89C16DB3E6D",
+		Fingerprint: "C5ACD2179B5231DFE842EE6121DBB89C16DB3E6D",
+		UserIDs:     []string{"Aptly Tester (don't use it) <test@aptly.info>"},
+	}})
+
+	c.Check(parseGpgKeys([]byte("")), DeepEquals, []GpgKey{})
+}
+
+func (s *GpgSuite) TestParseGpgImportStatus(c *C) {
+	c.Check(parseGpgImportStatus([]byte("[GNUPG:] KEY_CONSIDERED C5ACD2179B5231DFE842EE6121DBB89C16DB3E6D 0\n"+
+		"[GNUPG:] IMPORTED 21DBB89C16DB3E6D Aptly Tester (don't use it) <test@aptly.info>\n"+
+		"[GNUPG:] IMPORT_OK 1 C5ACD2179B5231DFE842EE6121DBB89C16DB3E6D\n"+
+		"[GNUPG:] IMPORT_RES 1 0 1 0 0 0 0 0 0 0 0 0 0 0 0\n")), DeepEquals,
+		[]string{"C5ACD2179B5231DFE842EE6121DBB89C16DB3E6D"})
+}
+
+func (s *GpgSuite) TestKeyMatches(c *C) {
+	key := &GpgKey{KeyID: "21DBB89C16DB3E6D", Fingerprint: "C5ACD2179B5231DFE842EE6121DBB89C16DB3E6D"}
+
+	c.Check(key.Matches("21DBB89C16DB3E6D"), Equals, true)
+	c.Check(key.Matches("16db3e6d"), Equals, true)
+	c.Check(key.Matches("0xC5ACD2179B5231DFE842EE6121DBB89C16DB3E6D"), Equals, true)
+	c.Check(key.Matches("C5AC D217 9B52 31DF E842  EE61 21DB B89C 16DB 3E6D"), Equals, true)
+	c.Check(key.Matches("3E6D"), Equals, false)
+	c.Check(key.Matches("AAAABBBBCCCCDDDD"), Equals, false)
+}
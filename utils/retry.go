@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy describes how failed network operations (downloads, uploads
+// to published storage) are retried
+type RetryPolicy struct {
+	// MaxAttempts is maximum number of attempts, including the first one (0 or 1 disables retries)
+	MaxAttempts int `json:"maxAttempts" yaml:"maxAttempts"`
+	// BaseDelay is delay before the first retry in milliseconds, it is doubled with each next retry
+	BaseDelay int `json:"baseDelay" yaml:"baseDelay"`
+	// MaxDelay limits delay between retries (in milliseconds, 0 means no limit)
+	MaxDelay int `json:"maxDelay" yaml:"maxDelay"`
+	// Jitter is random fraction of delay (0..1) added to or subtracted from each delay
+	Jitter float64 `json:"jitter" yaml:"jitter"`
+
+	// RetryableStatus reports whether operation failed with HTTP status code
+	// should be retried, nil means default (429 and 5xx)
+	RetryableStatus func(code int) bool `json:"-" yaml:"-" codec:"-"`
+}
+
+// Override returns copy of policy with non-zero settings of override applied
+func (policy RetryPolicy) Override(override *RetryPolicy) RetryPolicy {
+	if override == nil {
+		return policy
+	}
+
+	if override.MaxAttempts != 0 {
+		policy.MaxAttempts = override.MaxAttempts
+	}
+	if override.BaseDelay != 0 {
+		policy.BaseDelay = override.BaseDelay
+	}
+	if override.MaxDelay != 0 {
+		policy.MaxDelay = override.MaxDelay
+	}
+	if override.Jitter != 0 {
+		policy.Jitter = override.Jitter
+	}
+	if override.RetryableStatus != nil {
+		policy.RetryableStatus = override.RetryableStatus
+	}
+
+	return policy
+}
+
+// IsRetryableStatus checks whether operation failed with HTTP status code should be retried
+func (policy RetryPolicy) IsRetryableStatus(code int) bool {
+	if policy.RetryableStatus != nil {
+		return policy.RetryableStatus(code)
+	}
+
+	return code == 429 || (code >= 500 && code <= 599)
+}
+
+// Delay returns delay before retry number retry (starting with 1)
+func (policy RetryPolicy) Delay(retry int) time.Duration {
+	delay := time.Duration(policy.BaseDelay) * time.Millisecond
+	maxDelay := time.Duration(policy.MaxDelay) * time.Millisecond
+
+	for i := 1; i < retry && (maxDelay == 0 || delay < maxDelay); i++ {
+		delay *= 2
+	}
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+
+	if policy.Jitter > 0 {
+		delay += time.Duration(policy.Jitter * (2*rand.Float64() - 1) * float64(delay))
+	}
+
+	return delay
+}
+
+// Do runs operation until it succeeds, fails with error which isn't retryable or
+// maximum number of attempts is reached, returning last error
+//
+// Waiting between attempts is aborted when done is closed.
+func (policy RetryPolicy) Do(done <-chan struct{}, operation func() error, retryable func(err error) bool) error {
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		err = operation()
+		if err == nil || attempt >= policy.MaxAttempts || !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-done:
+			return err
+		case <-time.After(policy.Delay(attempt)):
+		}
+	}
+}
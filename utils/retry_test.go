@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type RetrySuite struct{}
+
+var _ = Suite(&RetrySuite{})
+
+func (s *RetrySuite) TestOverride(c *C) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: 100, MaxDelay: 1000, Jitter: 0.5}
+
+	c.Check(policy.Override(nil), DeepEquals, policy)
+	c.Check(policy.Override(&RetryPolicy{MaxAttempts: 5}), DeepEquals, RetryPolicy{MaxAttempts: 5, BaseDelay: 100, MaxDelay: 1000, Jitter: 0.5})
+}
+
+func (s *RetrySuite) TestIsRetryableStatus(c *C) {
+	policy := RetryPolicy{}
+
+	c.Check(policy.IsRetryableStatus(503), Equals, true)
+	c.Check(policy.IsRetryableStatus(429), Equals, true)
+	c.Check(policy.IsRetryableStatus(404), Equals, false)
+
+	policy.RetryableStatus = func(code int) bool { return code == 404 }
+	c.Check(policy.IsRetryableStatus(503), Equals, false)
+	c.Check(policy.IsRetryableStatus(404), Equals, true)
+}
+
+func (s *RetrySuite) TestDelay(c *C) {
+	policy := RetryPolicy{BaseDelay: 100, MaxDelay: 1000}
+
+	c.Check(policy.Delay(1), Equals, 100*time.Millisecond)
+	c.Check(policy.Delay(2), Equals, 200*time.Millisecond)
+	c.Check(policy.Delay(4), Equals, 800*time.Millisecond)
+	c.Check(policy.Delay(10), Equals, 1000*time.Millisecond)
+
+	policy.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		delay := policy.Delay(1)
+		c.Check(delay >= 50*time.Millisecond && delay <= 150*time.Millisecond, Equals, true)
+	}
+}
+
+func (s *RetrySuite) TestDo(c *C) {
+	errTemporary := errors.New("temporary")
+	errPermanent := errors.New("permanent")
+	retryable := func(err error) bool { return err == errTemporary }
+
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: 1}
+
+	attempts := 0
+	err := policy.Do(nil, func() error {
+		attempts++
+		if attempts < 3 {
+			return errTemporary
+		}
+		return nil
+	}, retryable)
+	c.Check(err, IsNil)
+	c.Check(attempts, Equals, 3)
+
+	attempts = 0
+	err = policy.Do(nil, func() error {
+		attempts++
+		return errTemporary
+	}, retryable)
+	c.Check(err, Equals, errTemporary)
+	c.Check(attempts, Equals, 3)
+
+	attempts = 0
+	err = policy.Do(nil, func() error {
+		attempts++
+		return errPermanent
+	}, retryable)
+	c.Check(err, Equals, errPermanent)
+	c.Check(attempts, Equals, 1)
+
+	// no retries by default
+	attempts = 0
+	err = RetryPolicy{}.Do(nil, func() error {
+		attempts++
+		return errTemporary
+	}, retryable)
+	c.Check(err, Equals, errTemporary)
+	c.Check(attempts, Equals, 1)
+
+	// waiting is aborted
+	done := make(chan struct{})
+	close(done)
+	attempts = 0
+	err = RetryPolicy{MaxAttempts: 3, BaseDelay: 10000}.Do(done, func() error {
+		attempts++
+		return errTemporary
+	}, retryable)
+	c.Check(err, Equals, errTemporary)
+	c.Check(attempts, Equals, 1)
+}